@@ -0,0 +1,87 @@
+package acme
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jingweno/codeface/store"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// cachePrefix is where the certificates, the account key and the pending
+// challenges of autocert are kept.
+const cachePrefix = "autocert/"
+
+// Cache keeps the data of autocert in the store, so that the server
+// instances sharing a store share certificates and can answer any
+// challenge.
+type Cache struct {
+	Store store.Store
+	// Gone is whether a host is gone for good, whose certificates aren't
+	// cached anymore, e.g. when autocert renews the certificate of a
+	// deleted editor
+	Gone func(ctx context.Context, host string) bool
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	var b []byte
+	err := c.Store.Get(ctx, cachePrefix+key, &b)
+	if err == store.ErrNotFound {
+		return nil, autocert.ErrCacheMiss
+	}
+
+	return b, err
+}
+
+func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
+	if host, ok := certHost(key); ok && c.Gone != nil && c.Gone(ctx, host) {
+		return nil
+	}
+
+	return c.Store.Put(ctx, cachePrefix+key, data)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if err := c.Store.Delete(ctx, cachePrefix+key); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+type cachedCert struct {
+	host string
+	rsa  bool
+}
+
+// certs returns the cached certificates.
+func (c *Cache) certs(ctx context.Context) ([]cachedCert, error) {
+	keys, err := c.Store.List(ctx, cachePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []cachedCert
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, cachePrefix)
+		if host, ok := certHost(key); ok {
+			certs = append(certs, cachedCert{host: host, rsa: strings.HasSuffix(key, "+rsa")})
+		}
+	}
+
+	return certs, nil
+}
+
+// certHost returns the host of the key of a certificate, which is the host
+// for ECDSA certificates and the host with a +rsa suffix for RSA ones.
+// The other keys are of the account and of challenges.
+func certHost(key string) (string, bool) {
+	switch {
+	case key == "acme_account+key", strings.HasSuffix(key, "+http-01"), strings.HasSuffix(key, "+token"):
+		return "", false
+	case strings.HasSuffix(key, "+rsa"):
+		return strings.TrimSuffix(key, "+rsa"), true
+	default:
+		return key, true
+	}
+}
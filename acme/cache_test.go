@@ -0,0 +1,85 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jingweno/codeface/store"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	gone := map[string]bool{"gone.example.com": true}
+	c := &Cache{
+		Store: store.NewMemory(),
+		Gone:  func(_ context.Context, host string) bool { return gone[host] },
+	}
+
+	if _, err := c.Get(ctx, "a.example.com"); err != autocert.ErrCacheMiss {
+		t.Errorf("Get of a missing key = %v, want ErrCacheMiss", err)
+	}
+	if err := c.Delete(ctx, "a.example.com"); err != nil {
+		t.Errorf("Delete of a missing key = %s", err)
+	}
+
+	for _, key := range []string{"a.example.com", "a.example.com+rsa", "acme_account+key", "tok+http-01", "a.example.com+token", "gone.example.com"} {
+		if err := c.Put(ctx, key, []byte("data of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := c.Get(ctx, "a.example.com")
+	if err != nil || string(b) != "data of a.example.com" {
+		t.Errorf("Get = %q, %v, want the data put", b, err)
+	}
+	if _, err := c.Get(ctx, "gone.example.com"); err != autocert.ErrCacheMiss {
+		t.Errorf("Get of the certificate of a host that is gone = %v, want ErrCacheMiss", err)
+	}
+
+	certs, err := c.certs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cachedCert{{host: "a.example.com"}, {host: "a.example.com", rsa: true}}
+	if !reflect.DeepEqual(certs, want) {
+		t.Errorf("certs = %+v, want %+v", certs, want)
+	}
+}
+
+func TestRenewForgetsHostsThatAreGone(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+
+	policy := func(_ context.Context, host string) error {
+		switch host {
+		case "gone.example.com":
+			return fmt.Errorf("%w: deleted", ErrHostGone)
+		case "down.example.com":
+			return fmt.Errorf("error: backend is down")
+		}
+		return nil
+	}
+	m := NewManager("http://127.0.0.1:0/directory", "ops@example.com", st, policy)
+
+	for _, key := range []string{"gone.example.com", "gone.example.com+rsa", "down.example.com"} {
+		if err := st.Put(ctx, cachePrefix+key, []byte("cert")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := m.cache.certs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rejected hosts that may come back keep their certificate
+	if want := []cachedCert{{host: "down.example.com"}}; !reflect.DeepEqual(certs, want) {
+		t.Errorf("certs = %+v, want %+v", certs, want)
+	}
+}
@@ -0,0 +1,149 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certificates are renewed when they expire within renewBefore
+const renewBefore = 30 * 24 * time.Hour
+
+// ErrHostGone is wrapped by the errors of host policies rejecting hosts
+// that are gone for good, e.g. of deleted editors. Their certificates are
+// forgotten instead of renewed.
+var ErrHostGone = errors.New("error: host is gone")
+
+// Manager provisions certificates with autocert on demand during TLS
+// handshakes, and keeps them in the store with Cache. HostPolicy is
+// checked on every handshake rather than only before a certificate is
+// obtained, so that the certificates of hosts that are gone stop being
+// served and renewed.
+type Manager struct {
+	HostPolicy autocert.HostPolicy
+
+	autocert *autocert.Manager
+	cache    *Cache
+	logger   log.FieldLogger
+}
+
+func NewManager(directoryURL, email string, st store.Store, hostPolicy autocert.HostPolicy) *Manager {
+	m := &Manager{
+		HostPolicy: hostPolicy,
+		logger:     log.New().WithField("com", "acme"),
+	}
+	m.cache = &Cache{Store: st, Gone: m.gone}
+	m.autocert = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       m.cache,
+		HostPolicy:  hostPolicy,
+		RenewBefore: renewBefore,
+		Email:       email,
+		Client:      &acme.Client{DirectoryURL: directoryURL},
+	}
+
+	return m
+}
+
+func (m *Manager) gone(ctx context.Context, host string) bool {
+	return m.HostPolicy != nil && errors.Is(m.HostPolicy(ctx, host), ErrHostGone)
+}
+
+// TLSConfig returns a TLS config serving certificates of the manager,
+// which answers tls-alpn-01 challenges too.
+func (m *Manager) TLSConfig() *tls.Config {
+	cfg := m.autocert.TLSConfig()
+	cfg.GetCertificate = m.GetCertificate
+	cfg.MinVersion = tls.VersionTLS12
+
+	return cfg
+}
+
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if host == "" {
+		return nil, errors.New("error: missing server name")
+	}
+
+	if m.HostPolicy != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := m.HostPolicy(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.autocert.GetCertificate(hello)
+}
+
+// HTTPHandler answers http-01 challenges and redirects any other request
+// to https.
+func (m *Manager) HTTPHandler() http.Handler {
+	return m.autocert.HTTPHandler(nil)
+}
+
+// Renew goes through the cached certificates, so that the ones about to
+// expire are renewed without waiting for a handshake: autocert renews the
+// certificates it loaded on its own. Hosts rejected by the host policy
+// aren't renewed, and the certificates of the ones that are gone are
+// forgotten.
+func (m *Manager) Renew(ctx context.Context) error {
+	certs, err := m.cache.certs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		host := cert.host
+		logger := m.logger.WithField("host", host)
+
+		if m.HostPolicy != nil {
+			if err := m.HostPolicy(ctx, host); err != nil {
+				if !errors.Is(err, ErrHostGone) {
+					logger.WithError(err).Info("Skip renewing certificate of rejected host")
+					continue
+				}
+
+				logger.Info("Forgetting certificate of host that is gone")
+				if err := m.Forget(ctx, host); err != nil {
+					logger.WithError(err).Info("Fail to forget certificate")
+				}
+				continue
+			}
+		}
+
+		// autocert picks the certificate of the key type the client supports
+		hello := &tls.ClientHelloInfo{ServerName: host}
+		if !cert.rsa {
+			hello.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}
+		}
+		if _, err := m.autocert.GetCertificate(hello); err != nil {
+			logger.WithError(err).Info("Fail to renew certificate")
+		}
+	}
+
+	return nil
+}
+
+// Forget deletes the certificates of a host, e.g. of a deleted editor.
+// autocert still serves the ones it loaded until the process restarts, but
+// GetCertificate no longer hands them out once the host policy rejects
+// the host, and Cache doesn't keep their renewals.
+func (m *Manager) Forget(ctx context.Context, host string) error {
+	for _, key := range []string{host, host + "+rsa"} {
+		if err := m.cache.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
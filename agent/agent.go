@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/store"
+)
+
+const (
+	keyPrefix      = "agents/"
+	rotationPrefix = "agentrotations/"
+)
+
+// RotationTTL is how long the agent of an editor has to pick up a new
+// token once its token is rotated.
+const RotationTTL = 24 * time.Hour
+
+// NewToken returns a token for the agent of an editor to report to the
+// server with. Tokens are handed out at claim time, before the provider
+// picks the editor, and are bound to it by Register afterwards.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func key(token string) string {
+	return keyPrefix + hash(token)
+}
+
+type rotation struct {
+	Editor    string
+	ExpiresAt time.Time
+}
+
+// Register binds a token to the editor it's handed to. Only a hash of the
+// token is stored.
+func Register(ctx context.Context, st store.Store, token, editor string) error {
+	return st.Put(ctx, key(token), editor)
+}
+
+// Editor returns the name of the editor a token is bound to.
+func Editor(ctx context.Context, st store.Store, token string) (string, error) {
+	var editor string
+	if err := st.Get(ctx, key(token), &editor); err != nil {
+		return "", err
+	}
+
+	return editor, nil
+}
+
+// Revoke invalidates the tokens bound to an editor.
+func Revoke(ctx context.Context, st store.Store, editor string) error {
+	keys, err := st.List(ctx, keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		var name string
+		if err := st.Get(ctx, k, &name); err != nil || name != editor {
+			continue
+		}
+
+		if err := st.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rotate invalidates the tokens bound to an editor like Revoke, e.g. when
+// it's transferred to another user, but lets its agent pick up a new
+// token with one of them once. The agent doesn't need a restart that way.
+func Rotate(ctx context.Context, st store.Store, editor string, now time.Time) error {
+	keys, err := st.List(ctx, keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		var name string
+		if err := st.Get(ctx, k, &name); err != nil || name != editor {
+			continue
+		}
+
+		rot := rotation{Editor: editor, ExpiresAt: now.Add(RotationTTL)}
+		if err := st.Put(ctx, rotationPrefix+strings.TrimPrefix(k, keyPrefix), rot); err != nil {
+			return err
+		}
+
+		if err := st.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PickUp returns a new token bound to the editor a rotated token was bound
+// to. Only the first pickup of a rotated token gets one, the others get
+// store.ErrNotFound. The new token is only handed out, never stored.
+func PickUp(ctx context.Context, st store.Store, token string, now time.Time) (string, error) {
+	k := rotationPrefix + hash(token)
+
+	var rot rotation
+	if err := st.Get(ctx, k, &rot); err != nil {
+		return "", err
+	}
+	if now.After(rot.ExpiresAt) {
+		return "", store.ErrNotFound
+	}
+
+	// the marker outlives the rotation until it expires, see PruneRotations
+	if err := st.Create(ctx, k+".picked", rot); err != nil {
+		if err == store.ErrExists {
+			return "", store.ErrNotFound
+		}
+		return "", err
+	}
+
+	if err := st.Delete(ctx, k); err != nil && err != store.ErrNotFound {
+		return "", err
+	}
+
+	newToken, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := Register(ctx, st, newToken, rot.Editor); err != nil {
+		return "", err
+	}
+
+	return newToken, nil
+}
+
+// PruneRotations deletes the rotations that weren't picked up in time and
+// the markers of the ones that were.
+func PruneRotations(ctx context.Context, st store.Store, now time.Time) error {
+	keys, err := st.List(ctx, rotationPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		var rot rotation
+		err := st.Get(ctx, k, &rot)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if now.Before(rot.ExpiresAt) {
+			continue
+		}
+
+		if err := st.Delete(ctx, k); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func DiskKey(editor string) string {
+	return "disk/" + editor
+}
+
+func ResourcesKey(editor string) string {
+	return "resources/" + editor
+}
+
+const RecordingsPrefix = "recordings/"
+
+func RecordingKey(editor, id string) string {
+	return RecordingsPrefix + editor + "/" + id
+}
+
+// RecordingObject returns the object a terminal recording of an editor is
+// uploaded to.
+func RecordingObject(editor, id string) string {
+	return RecordingsPrefix + editor + "/" + id + ".cast"
+}
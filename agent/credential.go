@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/jingweno/codeface/client"
+)
+
+// RepoCredential is the credential the user claimed an editor with for its
+// repository, e.g. a GitLab token, which is kept out of the URL of the
+// repository.
+type RepoCredential struct {
+	Repo     string
+	Username string
+	Password string
+}
+
+// host returns the host the credential is for, if any.
+func (rc RepoCredential) host() string {
+	if rc.Password == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rc.Repo)
+	if err != nil || u.Scheme != "https" {
+		return ""
+	}
+
+	return u.Host
+}
+
+// GitCredentialHelper implements the git credential helper protocol for
+// an operation of git, i.e. get, store or erase. It answers with the
+// credential of the repository for its host if there is one, and gets a
+// short-lived token of the repository of the editor from the server for
+// github.com otherwise, so that no long-lived token is kept in the editor.
+// Requests for other hosts are left to the other helpers.
+func GitCredentialHelper(ctx context.Context, c *client.Client, rc RepoCredential, op string, in io.Reader, out io.Writer) error {
+	attrs := make(map[string]string)
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) == 2 {
+			attrs[kv[0]] = kv[1]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	// tokens expire by themselves, there is nothing to store or erase
+	if op != "get" || attrs["protocol"] != "https" {
+		return nil
+	}
+
+	if host := rc.host(); host != "" && strings.EqualFold(attrs["host"], host) {
+		_, err := fmt.Fprintf(out, "username=%s\npassword=%s\n", rc.Username, rc.Password)
+		return err
+	}
+
+	if attrs["host"] != "github.com" {
+		return nil
+	}
+
+	cred, err := c.GitCredential(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "username=%s\npassword=%s\npassword_expiry_utc=%d\n", cred.Username, cred.Password, cred.ExpiresAt.Unix())
+	return err
+}
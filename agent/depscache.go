@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DepsCacher uploads the dependencies installed in an editor, e.g. the Go
+// module cache or the node_modules of the repo, when the editor stops, so
+// that the next editors of the repo restore them before installing
+// dependencies.
+type DepsCacher struct {
+	// Paths are the absolute paths archived, and may be globs, e.g.
+	// /home/dyno/project/*/node_modules
+	Paths     []string
+	UploadURL string
+	// MaxBytes is the largest archive uploaded
+	MaxBytes int64
+	Logger   log.FieldLogger
+}
+
+func (c *DepsCacher) Run(ctx context.Context) error {
+	<-ctx.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), finalUploadTimeout)
+	defer cancel()
+
+	if err := c.upload(ctx); err != nil {
+		c.Logger.WithError(err).Info("Fail to upload dependency cache")
+	}
+
+	return nil
+}
+
+// paths returns the paths to archive relative to /. Editors restore the
+// ones of their CF_DEPS_CACHE_PATHS from the archive.
+func (c *DepsCacher) paths() []string {
+	var paths []string
+	for _, p := range c.Paths {
+		if !filepath.IsAbs(p) {
+			c.Logger.WithField("path", p).Info("Fail to cache dependencies of a relative path")
+			continue
+		}
+
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			c.Logger.WithError(err).WithField("path", p).Info("Fail to cache dependencies")
+			continue
+		}
+		for _, m := range matches {
+			paths = append(paths, strings.TrimPrefix(m, "/"))
+		}
+	}
+
+	return paths
+}
+
+func (c *DepsCacher) upload(ctx context.Context) error {
+	paths := c.paths()
+	if len(paths) == 0 {
+		return nil
+	}
+
+	f, err := ioutil.TempFile("", "deps-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	args := append([]string{"-czf", f.Name(), "-C", "/"}, paths...)
+	cmd := exec.CommandContext(ctx, "tar", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error: fail to archive dependencies: %w: %s", err, out)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() > c.MaxBytes {
+		return fmt.Errorf("error: dependency cache of %d bytes is larger than %d bytes", fi.Size(), c.MaxBytes)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.UploadURL, f)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	// presigned uploads need the length up front
+	req.ContentLength = fi.Size()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error: fail to upload dependency cache status=%d body=%s", resp.StatusCode, b)
+	}
+
+	c.Logger.WithField("bytes", fi.Size()).Info("Uploaded dependency cache")
+	return nil
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// MeasureDisk returns the size of a workspace and the usage of the
+// filesystem it's on.
+func MeasureDisk(workspace string) (*model.DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(workspace, &st); err != nil {
+		return nil, err
+	}
+
+	var size int64
+	err := filepath.Walk(workspace, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// files may go away while walking
+			return nil
+		}
+
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bsize := int64(st.Bsize)
+	total := int64(st.Blocks) * bsize
+
+	return &model.DiskUsage{
+		WorkspaceBytes: size,
+		UsedBytes:      total - int64(st.Bfree)*bsize,
+		TotalBytes:     total,
+		ReportedAt:     time.Now(),
+	}, nil
+}
+
+// DiskReporter periodically reports the disk usage of an editor to the
+// server, and runs the cleanup command when the server asks for it.
+type DiskReporter struct {
+	Client    *client.Client
+	Workspace string
+	Interval  time.Duration
+	// CleanupCommand is run with bash in the workspace, e.g. to remove
+	// build caches
+	CleanupCommand string
+	Logger         log.FieldLogger
+}
+
+func (r *DiskReporter) Run(ctx context.Context) error {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+
+	for {
+		if err := r.report(ctx); err != nil {
+			r.Logger.WithError(err).Info("Fail to report disk usage")
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *DiskReporter) report(ctx context.Context) error {
+	usage, err := MeasureDisk(r.Workspace)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.Client.ReportDiskUsage(ctx, *usage)
+	if err != nil {
+		return err
+	}
+
+	if resp.Warning != "" {
+		r.Logger.Warn(resp.Warning)
+	}
+
+	if resp.Cleanup && r.CleanupCommand != "" {
+		r.Logger.Info("Running disk cleanup")
+
+		cmd := exec.CommandContext(ctx, "bash", "-c", r.CleanupCommand)
+		cmd.Dir = r.Workspace
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			r.Logger.WithError(err).Info("Fail to run disk cleanup")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/jingweno/codeface/client"
+)
+
+// IdleWarning writes the warning to show in the editor before it's
+// released as JSON, or nothing if there is none. It's polled by the
+// Codeface extension of the editor, which can't reach the server itself.
+func IdleWarning(ctx context.Context, c *client.Client, out io.Writer) error {
+	resp, err := c.Idle(ctx)
+	if err != nil {
+		return err
+	}
+
+	if resp.Warning == nil {
+		return nil
+	}
+
+	return json.NewEncoder(out).Encode(resp.Warning)
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// readyPollInterval is how often the editor is polled until it serves.
+const readyPollInterval = time.Second
+
+// ReadyReporter reports to the server once code-server first answers, which
+// is when the editor is ready for its user and its boot time ends.
+type ReadyReporter struct {
+	Client *client.Client
+	// Addr is the address code-server listens on, e.g. 127.0.0.1:8079
+	Addr   string
+	Logger log.FieldLogger
+}
+
+func (r *ReadyReporter) Run(ctx context.Context) error {
+	t := time.NewTicker(readyPollInterval)
+	defer t.Stop()
+
+	start := time.Now()
+	reported := false
+	for !reported {
+		if r.serves(ctx) {
+			// the session of the editor is started after its claim and may
+			// not be there yet, in which case the report is retried
+			if err := r.Client.ReportReady(ctx); err != nil {
+				r.Logger.WithError(err).Info("Fail to report editor is ready")
+			} else {
+				r.Logger.WithField("elapsed", time.Since(start)).Info("Reported editor is ready")
+				reported = true
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	// returning would stop the proxy with it
+	<-ctx.Done()
+	return nil
+}
+
+// serves returns whether code-server answers without a server error.
+func (r *ReadyReporter) serves(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, readyPollInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+r.Addr+"/", nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < http.StatusInternalServerError
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// finalUploadTimeout is how long the recordings of terminals that are
+// still open are uploaded for when the editor stops.
+const finalUploadTimeout = 20 * time.Second
+
+// Recorder uploads the terminal recordings of an editor, which the shell
+// of the editor writes with script(1). Recordings are uploaded once their
+// terminal exits, and the rest when the editor stops.
+type Recorder struct {
+	Client   *client.Client
+	Dir      string
+	Interval time.Duration
+	Logger   log.FieldLogger
+}
+
+func (rec *Recorder) Run(ctx context.Context) error {
+	t := time.NewTicker(rec.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			rec.uploadAll(ctx, false)
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), finalUploadTimeout)
+			rec.uploadAll(ctx, true)
+			cancel()
+			return nil
+		}
+	}
+}
+
+func (rec *Recorder) uploadAll(ctx context.Context, open bool) {
+	logs, err := filepath.Glob(filepath.Join(rec.Dir, "*.log"))
+	if err != nil {
+		rec.Logger.WithError(err).Info("Fail to list terminal recordings")
+		return
+	}
+
+	for _, file := range logs {
+		id := strings.TrimSuffix(filepath.Base(file), ".log")
+		if _, err := os.Stat(filepath.Join(rec.Dir, id+".done")); err != nil && !open {
+			continue
+		}
+
+		if err := rec.upload(ctx, id); err != nil {
+			rec.Logger.WithError(err).WithField("recording", id).Info("Fail to upload terminal recording")
+			continue
+		}
+
+		for _, ext := range []string{".log", ".timing", ".done"} {
+			os.Remove(filepath.Join(rec.Dir, id+ext))
+		}
+	}
+}
+
+func (rec *Recorder) upload(ctx context.Context, id string) error {
+	cast, startedAt, err := Cast(filepath.Join(rec.Dir, id+".log"), filepath.Join(rec.Dir, id+".timing"))
+	if err != nil {
+		return err
+	}
+
+	resp, err := rec.Client.RecordingUpload(ctx, model.RecordingRequest{
+		ID:        id,
+		Bytes:     int64(len(cast)),
+		StartedAt: startedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, resp.UploadURL, bytes.NewReader(cast))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	hresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(hresp.Body)
+		return fmt.Errorf("error: fail to upload recording status=%d body=%s", hresp.StatusCode, b)
+	}
+
+	return nil
+}
+
+// Cast converts the typescript and timing files of script(1) into an
+// asciicast v2 recording, and returns when the recording started.
+func Cast(logFile, timingFile string) ([]byte, time.Time, error) {
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// older versions of script start the typescript with a header line
+	// that isn't in the timing
+	if bytes.HasPrefix(data, []byte("Script started on")) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	tf, err := os.Open(timingFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer tf.Close()
+
+	var (
+		events  [][]interface{}
+		elapsed float64
+		carry   []byte
+	)
+	s := bufio.NewScanner(tf)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		delay, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			continue
+		}
+		if n > len(data) {
+			n = len(data)
+		}
+
+		elapsed += delay
+		chunk := append(carry, data[:n]...)
+		data = data[n:]
+
+		// keep characters split between chunks for the next one, so that
+		// the output stays valid UTF-8
+		carry = nil
+		for i := 1; i < utf8.UTFMax && i <= len(chunk) && !utf8.Valid(chunk); i++ {
+			if utf8.Valid(chunk[:len(chunk)-i]) {
+				chunk, carry = chunk[:len(chunk)-i], chunk[len(chunk)-i:]
+				break
+			}
+		}
+
+		if len(chunk) > 0 {
+			events = append(events, []interface{}{elapsed, "o", string(chunk)})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// the typescript is written until the terminal exits
+	startedAt := fi.ModTime().Add(-time.Duration(elapsed * float64(time.Second)))
+
+	buf := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(map[string]interface{}{
+		"version":   2,
+		"width":     80,
+		"height":    24,
+		"timestamp": startedAt.Unix(),
+	}); err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+
+	return buf.Bytes(), startedAt, nil
+}
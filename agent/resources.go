@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// cgroup files of the memory and CPU usage of the dyno or container, of
+// cgroup v2 and then v1. Limits of v1 are a huge number when there is none.
+var (
+	memoryFiles      = []string{"/sys/fs/cgroup/memory.current", "/sys/fs/cgroup/memory/memory.usage_in_bytes"}
+	memoryLimitFiles = []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"}
+	cpuV2File        = "/sys/fs/cgroup/cpu.stat"
+	cpuV1File        = "/sys/fs/cgroup/cpuacct/cpuacct.usage"
+)
+
+// noMemoryLimit is the limit of cgroup v1 without one, rounded down to a
+// page.
+const noMemoryLimit = 1 << 62
+
+// MeasureMemory returns the memory used by the editor and its limit, which
+// is 0 when there is none.
+func MeasureMemory() (used, limit int64, err error) {
+	used, err = readCgroupInt(memoryFiles)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit, err = readCgroupInt(memoryLimitFiles)
+	if err != nil || limit >= noMemoryLimit {
+		// max means no limit on cgroup v2
+		return used, 0, nil
+	}
+
+	return used, limit, nil
+}
+
+// CPUTime returns the CPU time used by the editor so far.
+func CPUTime() (time.Duration, error) {
+	if b, err := ioutil.ReadFile(cpuV2File); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return 0, err
+				}
+				return time.Duration(usec) * time.Microsecond, nil
+			}
+		}
+	}
+
+	ns, err := readCgroupInt([]string{cpuV1File})
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ns), nil
+}
+
+func readCgroupInt(files []string) (int64, error) {
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+
+		return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+
+	return 0, fmt.Errorf("error: none of %s is found", strings.Join(files, ", "))
+}
+
+// ResourceReporter periodically reports the memory and CPU utilization of
+// an editor to the server.
+type ResourceReporter struct {
+	Client   *client.Client
+	Interval time.Duration
+	Logger   log.FieldLogger
+
+	lastCPU time.Duration
+	lastAt  time.Time
+}
+
+func (r *ResourceReporter) Run(ctx context.Context) error {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+
+	for {
+		if err := r.report(ctx); err != nil {
+			r.Logger.WithError(err).Info("Fail to report resource usage")
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *ResourceReporter) report(ctx context.Context) error {
+	used, limit, err := MeasureMemory()
+	if err != nil {
+		return err
+	}
+
+	cpu, err := CPUTime()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	// CPU utilization is only known from the second measurement on
+	first := r.lastAt.IsZero()
+	var cpuPercent float64
+	if !first {
+		cpuPercent = float64(cpu-r.lastCPU) / float64(now.Sub(r.lastAt)) * 100
+	}
+	r.lastCPU, r.lastAt = cpu, now
+	if first {
+		return nil
+	}
+
+	resp, err := r.Client.ReportResources(ctx, model.ResourceUsage{
+		MemoryBytes:      used,
+		MemoryLimitBytes: limit,
+		CPUPercent:       cpuPercent,
+		ReportedAt:       now,
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Warning != "" {
+		r.Logger.Warn(resp.Warning)
+	}
+
+	return nil
+}
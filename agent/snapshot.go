@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshotter saves the workspace of an editor when it's being suspended,
+// so that it can be restored on disks that don't persist, e.g. of dynos.
+type Snapshotter struct {
+	Client    *client.Client
+	Workspace string
+	Interval  time.Duration
+	Logger    log.FieldLogger
+}
+
+func (s *Snapshotter) Run(ctx context.Context) error {
+	t := time.NewTicker(s.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.poll(ctx); err != nil {
+				s.Logger.WithError(err).Info("Fail to snapshot workspace")
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *Snapshotter) poll(ctx context.Context) error {
+	resp, err := s.Client.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	if resp.UploadURL == "" {
+		return nil
+	}
+
+	s.Logger.Info("Saving workspace snapshot")
+	if err := UploadWorkspace(ctx, s.Workspace, resp.UploadURL); err != nil {
+		return err
+	}
+
+	return s.Client.CompleteSnapshot(ctx)
+}
+
+// UploadWorkspace uploads a snapshot of a workspace to a presigned URL.
+func UploadWorkspace(ctx context.Context, workspace, url string) error {
+	f, err := ioutil.TempFile("", "snapshot-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cmd := exec.CommandContext(ctx, "tar", "-czf", f.Name(), "-C", workspace, ".")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error: fail to archive workspace: %w: %s", err, out)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, f)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	// presigned uploads need the length up front
+	req.ContentLength = fi.Size()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error: fail to upload snapshot status=%d body=%s", resp.StatusCode, b)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// wipPushTimeout is how long committing and pushing the work of an editor
+// may take.
+const wipPushTimeout = 2 * time.Minute
+
+// emptyTree is the tree git hashes a commit without files to.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// WIPPusher pushes the uncommitted work of an editor to a branch when the
+// editor is about to be released, so that it isn't lost with the editor.
+type WIPPusher struct {
+	Client    *client.Client
+	Workspace string
+	Interval  time.Duration
+	Logger    log.FieldLogger
+}
+
+func (p *WIPPusher) Run(ctx context.Context) error {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := p.poll(ctx); err != nil {
+				p.Logger.WithError(err).Info("Fail to push work in progress")
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *WIPPusher) poll(ctx context.Context) error {
+	resp, err := p.Client.WIPPush(ctx)
+	if err != nil {
+		return err
+	}
+
+	if resp.Branch == "" {
+		return nil
+	}
+
+	logger := p.Logger.WithField("branch", resp.Branch)
+	logger.Info("Pushing work in progress")
+
+	pctx, cancel := context.WithTimeout(ctx, wipPushTimeout)
+	defer cancel()
+
+	result := model.WIPPushResult{State: model.WIPPushStatePushed}
+	pushed, err := PushWorkInProgress(pctx, p.Workspace, resp.Branch)
+	switch {
+	case err != nil:
+		// e.g. the editor has no credentials for the remote
+		logger.WithError(err).Info("Fail to push work in progress")
+		result = model.WIPPushResult{State: model.WIPPushStateFailed, Reason: err.Error()}
+	case !pushed:
+		result = model.WIPPushResult{State: model.WIPPushStateSkipped, Reason: "there is no work to push"}
+	}
+
+	return p.Client.CompleteWIPPush(ctx, result)
+}
+
+// PushWorkInProgress commits the changes in the git repository at
+// workspace, including untracked files, and pushes them with the commits
+// that aren't pushed yet to branch of its origin. The commit is made from
+// a separate index, so that the working tree, the index and the current
+// branch are left as they are. It returns false if there is nothing to
+// push.
+func PushWorkInProgress(ctx context.Context, workspace, branch string) (bool, error) {
+	if _, err := git(ctx, workspace, nil, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return false, nil
+	}
+
+	if _, err := git(ctx, workspace, nil, "remote", "get-url", "origin"); err != nil {
+		return false, fmt.Errorf("error: workspace has no origin remote")
+	}
+
+	dir, err := ioutil.TempDir("", "wip-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	head, err := git(ctx, workspace, nil, "rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		// repositories without commits have no HEAD
+		head = ""
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(dir, "index")}
+	if head != "" {
+		if _, err := git(ctx, workspace, env, "read-tree", head); err != nil {
+			return false, err
+		}
+	}
+	if _, err := git(ctx, workspace, env, "add", "-A"); err != nil {
+		return false, err
+	}
+	tree, err := git(ctx, workspace, env, "write-tree")
+	if err != nil {
+		return false, err
+	}
+
+	commit := head
+	// the tree of a repository without commits is compared to the empty tree
+	changed := tree != emptyTree
+	if head != "" {
+		headTree, err := git(ctx, workspace, nil, "rev-parse", head+"^{tree}")
+		if err != nil {
+			return false, err
+		}
+		changed = tree != headTree
+	}
+
+	if changed {
+		args := []string{"commit-tree", tree, "-m", "WIP: work in progress saved by Codeface before the editor was released"}
+		if head != "" {
+			args = append(args, "-p", head)
+		}
+
+		// commits need an author, which editors may not have configured
+		var commitEnv []string
+		if email, _ := git(ctx, workspace, nil, "config", "user.email"); email == "" {
+			commitEnv = []string{
+				"GIT_AUTHOR_NAME=Codeface", "GIT_AUTHOR_EMAIL=codeface@localhost",
+				"GIT_COMMITTER_NAME=Codeface", "GIT_COMMITTER_EMAIL=codeface@localhost",
+			}
+		}
+
+		commit, err = git(ctx, workspace, commitEnv, args...)
+		if err != nil {
+			return false, err
+		}
+	} else if head == "" {
+		return false, nil
+	} else {
+		// a clean workspace may still have commits that aren't pushed
+		unpushed, err := git(ctx, workspace, nil, "rev-list", "-n", "1", commit, "--not", "--remotes")
+		if err != nil {
+			return false, err
+		}
+		if unpushed == "" {
+			return false, nil
+		}
+	}
+
+	if _, err := git(ctx, workspace, nil, "push", "origin", commit+":refs/heads/"+branch); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// git runs git in a repository and returns its trimmed output. It never
+// prompts for credentials.
+func git(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("error: fail to run git %s: %w: %s", args[0], err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
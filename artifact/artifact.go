@@ -0,0 +1,256 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/s3"
+	"github.com/jingweno/codeface/store"
+)
+
+// Kinds of artifacts.
+const (
+	// Heroku artifacts are releases of builder apps, Ref is the builder app
+	Heroku = "heroku"
+	// OCI artifacts are images in a registry, Ref is the image reference,
+	// e.g. registry.example.com/editor@sha256:...
+	OCI = "oci"
+	// S3 artifacts are rendered source tarballs, Ref is the object key
+	S3 = "s3"
+)
+
+// Key is the key of the record of an artifact in the store.
+func Key(template, version string) string {
+	return fmt.Sprintf("artifacts/%s/%s", template, version)
+}
+
+// promotionKey is the key of the promotion of a template in an
+// environment. Only production pools have their own, staging pools share
+// the one of pools without an environment.
+func promotionKey(env, template string) string {
+	if env == model.EnvironmentProduction {
+		return "promotions/" + env + "/" + template
+	}
+
+	return "promotions/" + template
+}
+
+// Store records which artifact each version of a template is built into
+// and holds the artifacts themselves. The records are kept in the store
+// shared by the server and the worker, so that any kind of artifact can be
+// listed and promoted with Get, List and Promote.
+type Store interface {
+	Kind() string
+	Put(ctx context.Context, art model.Artifact) error
+	Get(ctx context.Context, template, version string) (*model.Artifact, error)
+	// List returns the artifacts of a template, newest first.
+	List(ctx context.Context, template string) ([]model.Artifact, error)
+	// Delete removes an artifact along with its record.
+	Delete(ctx context.Context, art model.Artifact) error
+}
+
+// SourceStore is implemented by stores that hold the rendered source of
+// templates, which editors are built from, rather than builds of it.
+type SourceStore interface {
+	Store
+	// Upload puts the source tarball of an artifact and sets its ref.
+	Upload(ctx context.Context, art *model.Artifact, tarball []byte) error
+	// URL returns a URL that the tarball of an artifact can be downloaded
+	// from until it expires.
+	URL(art model.Artifact, expires time.Duration) string
+}
+
+type Config struct {
+	Kind string
+
+	HerokuAPIKey string
+
+	// OCIUsername and OCIPassword authenticate deletes of images with
+	// basic auth
+	OCIUsername string
+	OCIPassword string
+
+	S3 *s3.Client
+}
+
+// New returns the artifact store of the kind named by cfg.Kind, which
+// records artifacts in st.
+func New(st store.Store, cfg Config) (Store, error) {
+	idx := index{st: st}
+
+	switch cfg.Kind {
+	case Heroku, "":
+		if cfg.HerokuAPIKey == "" {
+			return nil, fmt.Errorf("error: HEROKU_API_KEY is required by heroku artifacts")
+		}
+		return newHeroku(idx, cfg.HerokuAPIKey), nil
+	case OCI:
+		return &ociStore{index: idx, username: cfg.OCIUsername, password: cfg.OCIPassword}, nil
+	case S3:
+		if cfg.S3 == nil || cfg.S3.Bucket == "" {
+			return nil, fmt.Errorf("error: a bucket is required by s3 artifacts")
+		}
+		return &s3Store{index: idx, s3: cfg.S3}, nil
+	default:
+		return nil, fmt.Errorf("error: unknown artifact kind %q", cfg.Kind)
+	}
+}
+
+// index records artifacts of any kind, the stores of each kind embed it.
+type index struct {
+	st store.Store
+}
+
+func (i index) Put(ctx context.Context, art model.Artifact) error {
+	return i.st.Put(ctx, Key(art.Template, art.Version), art)
+}
+
+func (i index) Get(ctx context.Context, template, version string) (*model.Artifact, error) {
+	return Get(ctx, i.st, template, version)
+}
+
+func (i index) List(ctx context.Context, template string) ([]model.Artifact, error) {
+	return List(ctx, i.st, template)
+}
+
+func (i index) deleteRecord(ctx context.Context, art model.Artifact) error {
+	return i.st.Delete(ctx, Key(art.Template, art.Version))
+}
+
+// Get returns the recorded artifact of a version of a template, or
+// store.ErrNotFound.
+func Get(ctx context.Context, st store.Store, template, version string) (*model.Artifact, error) {
+	var art model.Artifact
+	if err := st.Get(ctx, Key(template, version), &art); err != nil {
+		return nil, err
+	}
+
+	return &art, nil
+}
+
+// List returns the recorded artifacts of a template, newest first.
+func List(ctx context.Context, st store.Store, template string) ([]model.Artifact, error) {
+	keys, err := st.List(ctx, Key(template, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var arts []model.Artifact
+	for _, k := range keys {
+		var art model.Artifact
+		err := st.Get(ctx, k, &art)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		arts = append(arts, art)
+	}
+
+	sort.Slice(arts, func(i, j int) bool {
+		return arts[i].CreatedAt.After(arts[j].CreatedAt)
+	})
+
+	return arts, nil
+}
+
+// Promotion returns the promotion of a template in an environment, or
+// store.ErrNotFound if none of its artifacts is promoted yet.
+func Promotion(ctx context.Context, st store.Store, env, template string) (*model.Promotion, error) {
+	var promo model.Promotion
+	if err := st.Get(ctx, promotionKey(env, template), &promo); err != nil {
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+func Promote(ctx context.Context, st store.Store, promo model.Promotion) error {
+	return st.Put(ctx, promotionKey(promo.Environment, promo.Template), promo)
+}
+
+// Promoted returns the artifact pool editors of a template are promoted
+// from in an environment. It's the pinned one after a rollback, or else
+// the one of version, which is promoted first if it isn't yet, in which
+// case changed is set. It returns store.ErrNotFound if version has no
+// artifact yet. An artifact is only promoted if gate, if any, lets it
+// through, otherwise the previous one stays promoted. Production pools are
+// only promoted from staging by admins, their promotions are pinned.
+func Promoted(ctx context.Context, s Store, st store.Store, env, template, version string, gate func(context.Context, *model.Artifact) error) (art *model.Artifact, changed bool, err error) {
+	promo, err := Promotion(ctx, st, env, template)
+	if err == store.ErrNotFound && env == model.EnvironmentProduction {
+		return nil, false, fmt.Errorf("error: no artifact of %s is promoted to production yet, see cf-admin environments promote", template)
+	}
+	if err == store.ErrNotFound {
+		promo = &model.Promotion{}
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if promo.Pinned {
+		version = promo.Version
+	}
+
+	art, err = s.Get(ctx, template, version)
+	if err == store.ErrNotFound && promo.Pinned {
+		return nil, false, fmt.Errorf("error: pinned artifact %s of %s is not found", version, template)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if promo.Version == art.Version {
+		return art, false, nil
+	}
+
+	if gate != nil {
+		if err := gate(ctx, art); err != nil {
+			if promo.Version == "" {
+				return nil, false, err
+			}
+
+			prev, perr := s.Get(ctx, template, promo.Version)
+			if perr != nil {
+				return nil, false, err
+			}
+
+			return prev, false, nil
+		}
+	}
+
+	if err := Promote(ctx, st, model.Promotion{
+		Template:   template,
+		Version:    art.Version,
+		PromotedAt: time.Now(),
+	}); err != nil {
+		return nil, false, err
+	}
+
+	return art, true, nil
+}
+
+// Prune deletes all but the newest keep artifacts of a template, which are
+// kept to roll back to. The promoted artifact is always kept.
+func Prune(ctx context.Context, s Store, template string, keep int, promoted string) error {
+	arts, err := s.List(ctx, template)
+	if err != nil {
+		return err
+	}
+
+	for i, art := range arts {
+		if i < keep || art.Version == promoted {
+			continue
+		}
+
+		if err := s.Delete(ctx, art); err != nil {
+			return fmt.Errorf("error: fail to delete artifact %s of %s: %w", art.Version, template, err)
+		}
+	}
+
+	return nil
+}
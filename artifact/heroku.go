@@ -0,0 +1,48 @@
+package artifact
+
+import (
+	"context"
+	"net/http"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/model"
+)
+
+func newHeroku(idx index, apiKey string) *herokuStore {
+	client := &http.Client{
+		Transport: &heroku.Transport{
+			BearerToken: apiKey,
+			Transport:   &metrics.Transport{},
+		},
+	}
+
+	return &herokuStore{
+		index:  idx,
+		heroku: heroku.NewService(client),
+	}
+}
+
+// herokuStore keeps artifacts as the releases of builder apps, which pool
+// editors are promoted from through the pipeline of the template.
+type herokuStore struct {
+	index
+	heroku *heroku.Service
+}
+
+func (s *herokuStore) Kind() string {
+	return Heroku
+}
+
+func (s *herokuStore) Delete(ctx context.Context, art model.Artifact) error {
+	if _, err := s.heroku.AppDelete(ctx, art.Ref); err != nil && !isNotFound(err) {
+		return err
+	}
+
+	return s.deleteRecord(ctx, art)
+}
+
+func isNotFound(err error) bool {
+	herr, ok := err.(heroku.Error)
+	return ok && herr.StatusCode == http.StatusNotFound
+}
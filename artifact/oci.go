@@ -0,0 +1,89 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jingweno/codeface/model"
+)
+
+// ociStore keeps artifacts as images in an OCI registry, which are built
+// and pushed by CI and registered with the server. Docker editors are
+// created from the image of the promoted artifact.
+type ociStore struct {
+	index
+	username string
+	password string
+}
+
+func (s *ociStore) Kind() string {
+	return OCI
+}
+
+// Delete deletes the manifest of the image of an artifact from its
+// registry with the distribution API. Images must be referred to by digest
+// for registries to delete them.
+func (s *ociStore) Delete(ctx context.Context, art model.Artifact) error {
+	host, repo, digest, err := parseImageRef(art.Ref)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repo, digest)
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error: fail to delete image %s status=%d body=%s", art.Ref, resp.StatusCode, b)
+	}
+
+	return s.deleteRecord(ctx, art)
+}
+
+// parseImageRef splits an image reference like
+// registry.example.com/team/editor@sha256:... into its registry,
+// repository and digest.
+func parseImageRef(ref string) (host, repo, digest string, err error) {
+	i := strings.Index(ref, "@")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("error: image %s isn't referred to by digest", ref)
+	}
+	name, digest := ref[:i], ref[i+1:]
+
+	j := strings.Index(name, "/")
+	if j < 0 || !strings.ContainsAny(name[:j], ".:") {
+		return "", "", "", fmt.Errorf("error: image %s doesn't name its registry", ref)
+	}
+
+	return name[:j], name[j+1:], digest, nil
+}
+
+// ValidateRef checks that an artifact refers to what its kind can hold.
+func ValidateRef(kind, ref string) error {
+	if ref == "" {
+		return fmt.Errorf("error: artifact has no ref")
+	}
+
+	if kind == OCI {
+		_, _, _, err := parseImageRef(ref)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/s3"
+)
+
+// s3Store keeps artifacts as rendered source tarballs of templates in a
+// bucket. Pool editors are built from the tarball of the promoted artifact,
+// so a rollback rebuilds the exact source that was promoted before.
+type s3Store struct {
+	index
+	s3 *s3.Client
+}
+
+func (s *s3Store) Kind() string {
+	return S3
+}
+
+// ObjectKey is the key of the source tarball of a version of a template.
+func ObjectKey(template, version string) string {
+	return fmt.Sprintf("artifacts/%s/%s.tar.gz", template, version)
+}
+
+func (s *s3Store) Upload(ctx context.Context, art *model.Artifact, tarball []byte) error {
+	art.Ref = ObjectKey(art.Template, art.Version)
+	return s.s3.Put(ctx, art.Ref, "application/gzip", tarball)
+}
+
+func (s *s3Store) URL(art model.Artifact, expires time.Duration) string {
+	return s.s3.Presign("GET", art.Ref, expires, time.Now())
+}
+
+func (s *s3Store) Delete(ctx context.Context, art model.Artifact) error {
+	if err := s.s3.Delete(ctx, art.Ref); err != nil {
+		return err
+	}
+
+	return s.deleteRecord(ctx, art)
+}
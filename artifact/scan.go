@@ -0,0 +1,170 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// Kinds of scan targets, which are the trivy subcommands scanning them.
+const (
+	ScanImage = "image"
+	ScanFS    = "fs"
+)
+
+// ErrVulnerable is returned by Gate for artifacts whose scan found
+// vulnerabilities and isn't overridden.
+var ErrVulnerable = errors.New("error: artifact has vulnerabilities")
+
+// ScanKey is the key of the scan report of an artifact.
+func ScanKey(template, version string) string {
+	return fmt.Sprintf("scans/%s/%s", template, version)
+}
+
+// ScanTarget is scanned by trivy, an image or a directory.
+type ScanTarget struct {
+	Kind string
+	Ref  string
+}
+
+// Scanner scans artifacts for vulnerabilities of Severity by running
+// trivy, e.g. CRITICAL or HIGH,CRITICAL.
+type Scanner struct {
+	Path     string
+	Severity string
+}
+
+// Scan scans the targets of an artifact.
+func (s *Scanner) Scan(ctx context.Context, art model.Artifact, targets []ScanTarget) (*model.ScanReport, error) {
+	report := &model.ScanReport{
+		Template:  art.Template,
+		Version:   art.Version,
+		Severity:  s.Severity,
+		ScannedAt: time.Now(),
+	}
+
+	for _, t := range targets {
+		vulns, err := s.run(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Targets = append(report.Targets, t.Ref)
+		report.Vulnerabilities = append(report.Vulnerabilities, vulns...)
+	}
+
+	sort.SliceStable(report.Vulnerabilities, func(i, j int) bool {
+		return report.Vulnerabilities[i].ID < report.Vulnerabilities[j].ID
+	})
+
+	return report, nil
+}
+
+func (s *Scanner) run(ctx context.Context, t ScanTarget) ([]model.Vulnerability, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Path, t.Kind, "--quiet", "--format", "json", "--severity", s.Severity, t.Ref)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error: fail to scan %s: %w: %s", t.Ref, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out struct {
+		Results []struct {
+			Target          string
+			Vulnerabilities []struct {
+				VulnerabilityID  string
+				PkgName          string
+				InstalledVersion string
+				FixedVersion     string
+				Severity         string
+				Title            string
+			}
+		}
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("error: fail to parse the scan of %s: %w", t.Ref, err)
+	}
+
+	var vulns []model.Vulnerability
+	for _, r := range out.Results {
+		for _, v := range r.Vulnerabilities {
+			vulns = append(vulns, model.Vulnerability{
+				ID:               v.VulnerabilityID,
+				Target:           t.Ref + " " + r.Target,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         v.Severity,
+				Title:            v.Title,
+			})
+		}
+	}
+
+	return vulns, nil
+}
+
+// Gate returns the scan report of an artifact, which is scanned first if
+// it isn't yet. It fails with ErrVulnerable if the scan found
+// vulnerabilities, unless the report is overridden.
+func Gate(ctx context.Context, st store.Store, s *Scanner, art model.Artifact, targets []ScanTarget) (*model.ScanReport, error) {
+	var report model.ScanReport
+	err := st.Get(ctx, ScanKey(art.Template, art.Version), &report)
+	if err == store.ErrNotFound {
+		r, err := s.Scan(ctx, art, targets)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := st.Put(ctx, ScanKey(art.Template, art.Version), r); err != nil {
+			return nil, err
+		}
+		report = *r
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(report.Vulnerabilities) > 0 && report.OverriddenBy == "" {
+		return &report, fmt.Errorf("%w: %d of %s in %s of %s", ErrVulnerable, len(report.Vulnerabilities), report.Severity, art.Version, art.Template)
+	}
+
+	return &report, nil
+}
+
+// Scans returns the scan reports of the artifacts of a template, newest
+// first.
+func Scans(ctx context.Context, st store.Store, template string) ([]model.ScanReport, error) {
+	keys, err := st.List(ctx, ScanKey(template, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []model.ScanReport
+	for _, k := range keys {
+		var r model.ScanReport
+		err := st.Get(ctx, k, &r)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, r)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].ScannedAt.After(reports[j].ScannedAt)
+	})
+
+	return reports, nil
+}
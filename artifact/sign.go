@@ -0,0 +1,136 @@
+package artifact
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jingweno/codeface/model"
+)
+
+var (
+	// ErrUnsigned is returned by Verify for artifacts without a signature.
+	ErrUnsigned = errors.New("error: artifact is not signed")
+	// ErrBadSignature is returned by Verify for artifacts whose signature
+	// isn't made by any of the keys, e.g. when what they point at was
+	// swapped.
+	ErrBadSignature = errors.New("error: artifact signature is invalid")
+)
+
+// Digest returns the digest of a source tarball that's signed with its
+// artifact.
+func Digest(tarball []byte) string {
+	sum := sha256.Sum256(tarball)
+	return hex.EncodeToString(sum[:])
+}
+
+// signedPayload is what's signed of an artifact: what it is and what it
+// points at, which is pinned by a digest or a release.
+func signedPayload(art model.Artifact) []byte {
+	return []byte(strings.Join([]string{
+		"codeface-artifact-v1",
+		art.Template,
+		art.Version,
+		art.Kind,
+		art.Stack,
+		art.Manifest,
+		art.Ref,
+		art.Pipeline,
+		art.Release,
+		art.Digest,
+	}, "\n"))
+}
+
+// Signer signs artifacts with an Ed25519 key.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a signer of a base64 encoded Ed25519 seed or private
+// key, as printed by GenerateKey.
+func NewSigner(encoded string) (*Signer, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid signing key: %w", err)
+	}
+
+	switch len(b) {
+	case ed25519.SeedSize:
+		return &Signer{key: ed25519.NewKeyFromSeed(b)}, nil
+	case ed25519.PrivateKeySize:
+		return &Signer{key: ed25519.PrivateKey(b)}, nil
+	default:
+		return nil, fmt.Errorf("error: invalid signing key of %d bytes", len(b))
+	}
+}
+
+// GenerateKey returns a new base64 encoded signing key and its public key.
+func GenerateKey() (signingKey, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// PublicKey returns the base64 encoded public key of the signer.
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign sets the signature of an artifact.
+func (s *Signer) Sign(art *model.Artifact) {
+	art.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedPayload(*art)))
+}
+
+// Verifier verifies the signatures of artifacts against any of its public
+// keys, so that signing keys can be rotated.
+type Verifier struct {
+	keys []ed25519.PublicKey
+}
+
+// NewVerifier returns a verifier of base64 encoded Ed25519 public keys.
+func NewVerifier(encoded []string) (*Verifier, error) {
+	v := &Verifier{}
+	for _, e := range encoded {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e))
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("error: invalid public key %q", e)
+		}
+
+		v.keys = append(v.keys, ed25519.PublicKey(b))
+	}
+
+	return v, nil
+}
+
+// Verify returns an error if an artifact isn't signed by any of the keys.
+// OCI artifacts have to pin their image by digest, since what a tag points
+// at can be changed.
+func (v *Verifier) Verify(art model.Artifact) error {
+	if art.Signature == "" {
+		return fmt.Errorf("%w: %s of %s", ErrUnsigned, art.Version, art.Template)
+	}
+	if art.Kind == OCI && !strings.Contains(art.Ref, "@sha256:") {
+		return fmt.Errorf("error: image %s of signed artifacts has to be pinned by digest", art.Ref)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(art.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s of %s", ErrBadSignature, art.Version, art.Template)
+	}
+
+	payload := signedPayload(art)
+	for _, k := range v.keys {
+		if ed25519.Verify(k, payload, sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s of %s", ErrBadSignature, art.Version, art.Template)
+}
@@ -0,0 +1,151 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client calls AWS service APIs with either the JSON or the query
+// protocol, which covers ECS and Elastic Load Balancing.
+type Client struct {
+	Service     string
+	Region      string
+	Credentials *CredentialsChain
+	HTTPClient  *http.Client
+	// Endpoint is the URL of the API for services whose host isn't named
+	// after them, e.g. SES, https://#{Service}.#{Region}.amazonaws.com/ if
+	// empty
+	Endpoint string
+}
+
+// APIError is an error returned by an AWS API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error: aws %s status=%d message=%s", e.Code, e.StatusCode, e.Message)
+}
+
+func (c *Client) endpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+
+	return fmt.Sprintf("https://%s.%s.amazonaws.com/", c.Service, c.Region)
+}
+
+// JSON calls an action of an API with the JSON 1.1 protocol, e.g.
+// target AmazonEC2ContainerServiceV20141113.RunTask.
+func (c *Client) JSON(ctx context.Context, target string, in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-amz-json-1.1")
+	header.Set("X-Amz-Target", target)
+
+	body, err := c.do(ctx, header, b)
+	if err != nil {
+		if e, ok := err.(*APIError); ok {
+			var v struct {
+				Type    string `json:"__type"`
+				Message string `json:"message"`
+			}
+			if json.Unmarshal([]byte(e.Message), &v) == nil {
+				e.Code, e.Message = v.Type, v.Message
+			}
+		}
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// Query calls an action of an API with the query protocol and decodes
+// the XML response into out.
+func (c *Client) Query(ctx context.Context, action, version string, params url.Values, out interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("Action", action)
+	params.Set("Version", version)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	body, err := c.do(ctx, header, []byte(params.Encode()))
+	if err != nil {
+		if e, ok := err.(*APIError); ok {
+			var v struct {
+				Error struct {
+					Code    string
+					Message string
+				}
+			}
+			if xml.Unmarshal([]byte(e.Message), &v) == nil {
+				e.Code, e.Message = v.Error.Code, v.Error.Message
+			}
+		}
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return xml.Unmarshal(body, out)
+}
+
+func (c *Client) do(ctx context.Context, header http.Header, b []byte) ([]byte, error) {
+	creds, err := c.Credentials.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header = header
+
+	Sign(req, b, c.Service, c.Region, creds, time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	return body, nil
+}
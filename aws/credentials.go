@@ -0,0 +1,185 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const containerCredentialsHost = "http://169.254.170.2"
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// zero for credentials that don't expire
+	Expires time.Time
+}
+
+func (c Credentials) expired(now time.Time) bool {
+	// refresh a little early so that signed requests don't race the expiry
+	return !c.Expires.IsZero() && now.Add(5*time.Minute).After(c.Expires)
+}
+
+// CredentialsChain looks up credentials the same way the AWS SDKs do:
+// environment variables, then the shared credentials file, then the ECS
+// container credentials endpoint. Credentials are cached until they
+// expire.
+type CredentialsChain struct {
+	mu    sync.Mutex
+	creds *Credentials
+}
+
+func NewCredentialsChain() *CredentialsChain {
+	return &CredentialsChain{}
+}
+
+func (c *CredentialsChain) Get(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creds != nil && !c.creds.expired(time.Now()) {
+		return *c.creds, nil
+	}
+
+	creds, err := c.retrieve(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.creds = &creds
+
+	return creds, nil
+}
+
+func (c *CredentialsChain) retrieve(ctx context.Context) (Credentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return Credentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	creds, err := sharedCredentials()
+	if err == nil {
+		return creds, nil
+	}
+	if !os.IsNotExist(err) {
+		return Credentials{}, err
+	}
+
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return containerCredentials(ctx, containerCredentialsHost+uri)
+	}
+
+	return Credentials{}, fmt.Errorf("error: no AWS credentials are found")
+}
+
+func sharedCredentials() (Credentials, error) {
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, err
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer f.Close()
+
+	var (
+		creds   Credentials
+		section string
+	)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		if section != profile {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		v := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = v
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = v
+		case "aws_session_token":
+			creds.SessionToken = v
+		}
+	}
+	if err := s.Err(); err != nil {
+		return Credentials{}, err
+	}
+
+	if creds.AccessKeyID == "" {
+		return Credentials{}, fmt.Errorf("error: no AWS credentials for profile %s in %s", profile, file)
+	}
+
+	return creds, nil
+}
+
+func containerCredentials(ctx context.Context, url string) (Credentials, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("error: fail to get container credentials status=%d", resp.StatusCode)
+	}
+
+	var v struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{
+		AccessKeyID:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		SessionToken:    v.Token,
+		Expires:         v.Expiration,
+	}, nil
+}
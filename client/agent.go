@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jingweno/codeface/model"
+)
+
+const agentTokenPath = "/v1/agent/token"
+
+// agentTokenFile keeps the token the agent of an editor picked up once
+// its token was rotated, so that every process of the agent reports with
+// it. CF_AGENT_TOKEN stays the token the editor was claimed with.
+func agentTokenFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".codeface", "agent-token")
+}
+
+func readAgentToken() string {
+	f := agentTokenFile()
+	if f == "" {
+		return ""
+	}
+
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// NewAgent returns a client of the agent of an editor, which reports with
+// token unless it picked up another one. The client picks up a new token
+// on its own when the server rejects its token after a rotation.
+func NewAgent(serverURL, token string) *Client {
+	c := New(serverURL, token)
+	c.agent = true
+	if t := readAgentToken(); t != "" {
+		c.token = t
+	}
+
+	return c
+}
+
+// rotatedToken returns the token to report with instead of a rejected
+// one: the one another process of the agent picked up, or a new one the
+// client picks up.
+func (c *Client) rotatedToken(ctx context.Context, rejected string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// another request of the client picked it up already
+	if c.token != rejected {
+		return c.token, nil
+	}
+
+	if t := readAgentToken(); t != "" && t != rejected {
+		c.token = t
+		return t, nil
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, agentTokenPath, nil, rejected)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error: POST %s returned status=%d", agentTokenPath, resp.StatusCode)
+	}
+
+	var tok model.AgentTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	c.token = tok.Token
+
+	// the token is only handed out once, the client keeps it even if the
+	// other processes can't pick it up
+	if f := agentTokenFile(); f != "" {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err == nil {
+			_ = ioutil.WriteFile(f, []byte(tok.Token+"\n"), 0600)
+		}
+	}
+
+	return tok.Token, nil
+}
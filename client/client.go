@@ -0,0 +1,605 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/websocket"
+)
+
+// Client talks to the cf-server API on behalf of a user.
+type Client struct {
+	serverURL string
+	http      *http.Client
+
+	mu    sync.Mutex
+	token string
+	// agent is set for clients of the agent of an editor, see NewAgent
+	agent bool
+}
+
+func New(serverURL, token string) *Client {
+	return &Client{
+		serverURL: strings.TrimRight(serverURL, "/"),
+		token:     token,
+		http:      http.DefaultClient,
+	}
+}
+
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	resp, err := c.send(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send makes a request and returns the response if it succeeded, the
+// caller has to close its body.
+func (c *Client) send(ctx context.Context, method, path string, in interface{}) (*http.Response, error) {
+	var b []byte
+	if in != nil {
+		var err error
+		if b, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	token := c.currentToken()
+	resp, err := c.do(ctx, method, path, b, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// the token of an agent is rotated when its editor is transferred
+	if c.agent && resp.StatusCode == http.StatusUnauthorized && path != agentTokenPath {
+		if newToken, err := c.rotatedToken(ctx, token); err == nil {
+			resp.Body.Close()
+			if resp, err = c.do(ctx, method, path, b, newToken); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		var errResp model.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return nil, fmt.Errorf("error: %s %s returned status=%d", method, path, resp.StatusCode)
+		}
+
+		return nil, fmt.Errorf(errResp.Error)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, b []byte, token string) (*http.Response, error) {
+	var body io.Reader
+	if b != nil {
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.serverURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return c.http.Do(req)
+}
+
+func (c *Client) currentToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.token
+}
+
+func (c *Client) Capabilities(ctx context.Context) (*model.Capabilities, error) {
+	var resp model.Capabilities
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/capabilities", nil, &resp)
+}
+
+func (c *Client) Prebuild(ctx context.Context, req model.PrebuildRequest) (*model.PrebuildResponse, error) {
+	var resp model.PrebuildResponse
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/prebuilds", req, &resp)
+}
+
+func (c *Client) CompletePrebuild(ctx context.Context, req model.PrebuildRequest) error {
+	return c.Do(ctx, http.MethodPut, "/v1/prebuilds", req, nil)
+}
+
+func (c *Client) Activity(ctx context.Context, editor string) (*model.Activity, error) {
+	var a model.Activity
+	return &a, c.Do(ctx, http.MethodGet, "/v1/editors/"+editor+"/activity", nil, &a)
+}
+
+func (c *Client) DiskUsage(ctx context.Context, editor string) (*model.DiskUsage, error) {
+	var resp model.DiskUsage
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/editors/"+editor+"/disk", nil, &resp)
+}
+
+// ReportDiskUsage is called by the agent of an editor with its agent token.
+func (c *Client) ReportDiskUsage(ctx context.Context, usage model.DiskUsage) (*model.DiskUsageResponse, error) {
+	var resp model.DiskUsageResponse
+	return &resp, c.Do(ctx, http.MethodPut, "/v1/agent/disk", usage, &resp)
+}
+
+// ReportReady is called by the agent of an editor with its agent token once
+// the editor serves.
+func (c *Client) ReportReady(ctx context.Context) error {
+	return c.Do(ctx, http.MethodPut, "/v1/agent/ready", nil, nil)
+}
+
+// ReportResources is called by the agent of an editor with its agent token.
+func (c *Client) ReportResources(ctx context.Context, usage model.ResourceUsage) (*model.ResourceUsage, error) {
+	var resp model.ResourceUsage
+	return &resp, c.Do(ctx, http.MethodPut, "/v1/agent/resources", usage, &resp)
+}
+
+// RecordingUpload is called by the agent of an editor with its agent token.
+func (c *Client) RecordingUpload(ctx context.Context, req model.RecordingRequest) (*model.RecordingUploadResponse, error) {
+	var resp model.RecordingUploadResponse
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/agent/recordings", req, &resp)
+}
+
+func (c *Client) Recordings(ctx context.Context, editor string) (*model.RecordingsResponse, error) {
+	path := "/v1/recordings"
+	if editor != "" {
+		path += "?editor=" + url.QueryEscape(editor)
+	}
+
+	var resp model.RecordingsResponse
+	return &resp, c.Do(ctx, http.MethodGet, path, nil, &resp)
+}
+
+func (c *Client) Recording(ctx context.Context, editor, id string) (*model.Recording, error) {
+	var resp model.Recording
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/recordings/"+editor+"/"+id, nil, &resp)
+}
+
+// GitCredential is called by the git credential helper of an editor with
+// its agent token.
+func (c *Client) GitCredential(ctx context.Context) (*model.GitCredential, error) {
+	var resp model.GitCredential
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/agent/git-credential", nil, &resp)
+}
+
+func (c *Client) Resources(ctx context.Context, editor string) (*model.ResourceUsage, error) {
+	var resp model.ResourceUsage
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/editors/"+editor+"/resources", nil, &resp)
+}
+
+func (c *Client) TemplateResources(ctx context.Context) (*model.ResourcesResponse, error) {
+	var resp model.ResourcesResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/resources", nil, &resp)
+}
+
+func (c *Client) CreateBatch(ctx context.Context, req model.BatchRequest) (*model.Batch, error) {
+	var resp model.Batch
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/batches", req, &resp)
+}
+
+func (c *Client) Batches(ctx context.Context) (*model.BatchesResponse, error) {
+	var resp model.BatchesResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/batches", nil, &resp)
+}
+
+func (c *Client) Batch(ctx context.Context, id string) (*model.Batch, error) {
+	var resp model.Batch
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/batches/"+id, nil, &resp)
+}
+
+// BatchCSV writes the editors of a batch as CSV.
+func (c *Client) BatchCSV(ctx context.Context, id string, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/v1/batches/"+id+"/editors.csv", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) DeleteBatch(ctx context.Context, id string) (*model.Batch, error) {
+	var resp model.Batch
+	return &resp, c.Do(ctx, http.MethodDelete, "/v1/batches/"+id, nil, &resp)
+}
+
+func (c *Client) Transfer(ctx context.Context, editor, recipient string) (*model.Transfer, error) {
+	var resp model.Transfer
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/editors/"+editor+"/transfer", model.TransferRequest{Recipient: recipient}, &resp)
+}
+
+func (c *Client) AcceptTransfer(ctx context.Context, editor string) (*model.Transfer, error) {
+	var resp model.Transfer
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/editors/"+editor+"/transfer/accept", nil, &resp)
+}
+
+func (c *Client) CancelTransfer(ctx context.Context, editor string) error {
+	return c.Do(ctx, http.MethodDelete, "/v1/editors/"+editor+"/transfer", nil, nil)
+}
+
+func (c *Client) Suspend(ctx context.Context, editor string) (*model.Suspension, error) {
+	var resp model.Suspension
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/editors/"+editor+"/suspend", nil, &resp)
+}
+
+func (c *Client) Resume(ctx context.Context, editor string) (*model.EditorResponse, error) {
+	var resp model.EditorResponse
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/editors/"+editor+"/resume", nil, &resp)
+}
+
+func (c *Client) Undelete(ctx context.Context, editor string) (*model.EditorResponse, error) {
+	var resp model.EditorResponse
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/editors/"+editor+"/undelete", nil, &resp)
+}
+
+// Idle is polled by the agent of an editor with its agent token.
+func (c *Client) Idle(ctx context.Context) (*model.IdleResponse, error) {
+	var resp model.IdleResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/agent/idle", nil, &resp)
+}
+
+// KeepAlive is called by the agent of an editor with its agent token.
+func (c *Client) KeepAlive(ctx context.Context) error {
+	return c.Do(ctx, http.MethodPost, "/v1/agent/keep-alive", nil, nil)
+}
+
+// CompleteRun is called by the agent of a headless editor with its agent
+// token when its command exits.
+func (c *Client) CompleteRun(ctx context.Context, res model.RunResult) error {
+	return c.Do(ctx, http.MethodPut, "/v1/agent/run", res, nil)
+}
+
+func (c *Client) CreateRun(ctx context.Context, req model.RunRequest) (*model.Run, error) {
+	var run model.Run
+	return &run, c.Do(ctx, http.MethodPost, "/v1/runs", req, &run)
+}
+
+func (c *Client) Run(ctx context.Context, editor string) (*model.Run, error) {
+	var run model.Run
+	return &run, c.Do(ctx, http.MethodGet, "/v1/runs/"+editor, nil, &run)
+}
+
+func (c *Client) CreateClaim(ctx context.Context, req model.EditorRequest) (*model.Claim, error) {
+	var claim model.Claim
+	return &claim, c.Do(ctx, http.MethodPost, "/v1/claims", req, &claim)
+}
+
+// Claim polls a claim, waiting up to wait for it to complete if it's
+// pending.
+func (c *Client) Claim(ctx context.Context, id string, wait time.Duration) (*model.Claim, error) {
+	path := "/v1/claims/" + id
+	if wait > 0 {
+		path += "?wait=" + wait.String()
+	}
+
+	var claim model.Claim
+	return &claim, c.Do(ctx, http.MethodGet, path, nil, &claim)
+}
+
+func (c *Client) CancelClaim(ctx context.Context, id string) (*model.Claim, error) {
+	var claim model.Claim
+	return &claim, c.Do(ctx, http.MethodDelete, "/v1/claims/"+id, nil, &claim)
+}
+
+// Snapshot is polled by the agent of an editor with its agent token.
+func (c *Client) Snapshot(ctx context.Context) (*model.SnapshotResponse, error) {
+	var resp model.SnapshotResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/agent/snapshot", nil, &resp)
+}
+
+func (c *Client) CompleteSnapshot(ctx context.Context) error {
+	return c.Do(ctx, http.MethodPut, "/v1/agent/snapshot", nil, nil)
+}
+
+// WIPPush and CompleteWIPPush are called by the agent of an editor that is
+// about to be released, to push the uncommitted work in it.
+func (c *Client) WIPPush(ctx context.Context) (*model.WIPPushResponse, error) {
+	var resp model.WIPPushResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/agent/wip-push", nil, &resp)
+}
+
+func (c *Client) CompleteWIPPush(ctx context.Context, result model.WIPPushResult) error {
+	return c.Do(ctx, http.MethodPut, "/v1/agent/wip-push", result, nil)
+}
+
+// AgentSession, ExtendSession, SaveSnapshot, CompleteSavedSnapshot, Ports,
+// OpenPort and ClosePort are called by cf-agent in an editor with its
+// agent token.
+func (c *Client) AgentSession(ctx context.Context) (*model.AgentSession, error) {
+	var resp model.AgentSession
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/agent/session", nil, &resp)
+}
+
+func (c *Client) ExtendSession(ctx context.Context) (*model.AgentSession, error) {
+	var resp model.AgentSession
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/agent/extend", nil, &resp)
+}
+
+func (c *Client) SaveSnapshot(ctx context.Context) (*model.SnapshotResponse, error) {
+	var resp model.SnapshotResponse
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/agent/snapshots", nil, &resp)
+}
+
+func (c *Client) CompleteSavedSnapshot(ctx context.Context) error {
+	return c.Do(ctx, http.MethodPut, "/v1/agent/snapshots", nil, nil)
+}
+
+func (c *Client) Ports(ctx context.Context) (*model.EditorPorts, error) {
+	var resp model.EditorPorts
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/agent/ports", nil, &resp)
+}
+
+func (c *Client) OpenPort(ctx context.Context, port int) (*model.EditorPorts, error) {
+	var resp model.EditorPorts
+	return &resp, c.Do(ctx, http.MethodPut, "/v1/agent/ports", model.PortRequest{Port: port}, &resp)
+}
+
+func (c *Client) ClosePort(ctx context.Context, port int) (*model.EditorPorts, error) {
+	var resp model.EditorPorts
+	return &resp, c.Do(ctx, http.MethodDelete, fmt.Sprintf("/v1/agent/ports/%d", port), nil, &resp)
+}
+
+func (c *Client) Artifacts(ctx context.Context, template string) (*model.ArtifactsResponse, error) {
+	var resp model.ArtifactsResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/artifacts/"+template, nil, &resp)
+}
+
+func (c *Client) RegisterArtifact(ctx context.Context, art model.Artifact) (*model.Artifact, error) {
+	var resp model.Artifact
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/artifacts/"+art.Template, art, &resp)
+}
+
+func (c *Client) Promote(ctx context.Context, template, version string) (*model.Promotion, error) {
+	var resp model.Promotion
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/artifacts/"+template+"/promote", model.PromoteRequest{Version: version}, &resp)
+}
+
+// EditorsOptions filters, sorts and pages the editors listed by Editors.
+type EditorsOptions struct {
+	Template string
+	State    string
+	Owner    string
+	Sort     string
+	Limit    int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	// Labels only lists editors claimed with all of them
+	Labels map[string]string
+}
+
+func (c *Client) Editors(ctx context.Context, opts EditorsOptions) (*model.EditorsResponse, error) {
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"template": opts.Template,
+		"state":    opts.State,
+		"owner":    opts.Owner,
+		"sort":     opts.Sort,
+		"cursor":   opts.Cursor,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	for k, v := range opts.Labels {
+		q.Add("label", k+"="+v)
+	}
+
+	var resp model.EditorsResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/editors?"+q.Encode(), nil, &resp)
+}
+
+// DeleteEditorsOptions filters the claimed editors deleted by
+// DeleteEditors, which matches every filter that's set.
+type DeleteEditorsOptions struct {
+	Owner    string
+	Template string
+	Labels   map[string]string
+	// OlderThan only deletes editors claimed longer ago
+	OlderThan time.Duration
+	// DryRun lists the editors without deleting them
+	DryRun bool
+}
+
+func (c *Client) DeleteEditors(ctx context.Context, opts DeleteEditorsOptions) (*model.BulkDeleteResponse, error) {
+	q := url.Values{}
+	if opts.Owner != "" {
+		q.Set("owner", opts.Owner)
+	}
+	if opts.Template != "" {
+		q.Set("template", opts.Template)
+	}
+	for k, v := range opts.Labels {
+		q.Add("label", k+"="+v)
+	}
+	if opts.OlderThan > 0 {
+		q.Set("older_than", opts.OlderThan.String())
+	}
+	if opts.DryRun {
+		q.Set("dry_run", "true")
+	}
+
+	var resp model.BulkDeleteResponse
+	return &resp, c.Do(ctx, http.MethodDelete, "/v1/editors?"+q.Encode(), nil, &resp)
+}
+
+func (c *Client) Deploys(ctx context.Context) (*model.DeploysResponse, error) {
+	var resp model.DeploysResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/deploys", nil, &resp)
+}
+
+func (c *Client) Deploy(ctx context.Context, id string) (*model.Deploy, error) {
+	var dep model.Deploy
+	return &dep, c.Do(ctx, http.MethodGet, "/v1/deploys/"+id, nil, &dep)
+}
+
+// RuntimeLogs copies the output of the process of an editor to w, and new
+// output until ctx is done when follow is set.
+func (c *Client) RuntimeLogs(ctx context.Context, editor string, follow bool, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, fmt.Sprintf("/v1/editors/%s/runtime-logs?follow=%t", editor, follow), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) Settings(ctx context.Context) (*model.UserSettings, error) {
+	var resp model.UserSettings
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/settings", nil, &resp)
+}
+
+func (c *Client) UpdateSettings(ctx context.Context, s model.UserSettings) (*model.UserSettings, error) {
+	var resp model.UserSettings
+	return &resp, c.Do(ctx, http.MethodPut, "/v1/settings", s, &resp)
+}
+
+// Notifications calls fn with the notifications of the user pushed over a
+// WebSocket, after the one of ID after if it's set, until ctx is done or
+// the connection drops.
+func (c *Client) Notifications(ctx context.Context, after string, fn func(model.Notification) error) error {
+	path := "/v1/notifications"
+	if after != "" {
+		path += "?after=" + url.QueryEscape(after)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.serverURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	conn, resp, err := websocket.Dial(c.http, req)
+	if err == websocket.ErrBadHandshake && resp != nil {
+		defer resp.Body.Close()
+
+		var errResp model.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
+			return fmt.Errorf(errResp.Error)
+		}
+		return fmt.Errorf("error: GET %s returned status=%d", path, resp.StatusCode)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		var n model.Notification
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+}
+
+// DeviceCode starts a device login, the client needs no token.
+func (c *Client) DeviceCode(ctx context.Context) (*model.DeviceCode, error) {
+	var resp model.DeviceCode
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/device/code", nil, &resp)
+}
+
+func (c *Client) DeviceToken(ctx context.Context, deviceCode string) (*model.Token, error) {
+	var resp model.Token
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/device/token", model.DeviceTokenRequest{DeviceCode: deviceCode}, &resp)
+}
+
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*model.Token, error) {
+	var resp model.Token
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/device/refresh", model.RefreshRequest{RefreshToken: refreshToken}, &resp)
+}
+
+func (c *Client) CreateImpersonation(ctx context.Context, req model.ImpersonationRequest) (*model.Impersonation, error) {
+	var resp model.Impersonation
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/impersonations", req, &resp)
+}
+
+func (c *Client) Impersonations(ctx context.Context) (*model.ImpersonationsResponse, error) {
+	var resp model.ImpersonationsResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/impersonations", nil, &resp)
+}
+
+func (c *Client) Impersonation(ctx context.Context, id string) (*model.Impersonation, error) {
+	var resp model.Impersonation
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/impersonations/"+id, nil, &resp)
+}
+
+func (c *Client) RevokeImpersonation(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/v1/impersonations/"+id, nil, nil)
+}
+
+func (c *Client) CreateViewerLink(ctx context.Context, editor string) (*model.ViewerLink, error) {
+	var resp model.ViewerLink
+	return &resp, c.Do(ctx, http.MethodPost, "/v1/editors/"+editor+"/viewers", nil, &resp)
+}
+
+func (c *Client) ViewerLinks(ctx context.Context, editor string) (*model.ViewerLinksResponse, error) {
+	var resp model.ViewerLinksResponse
+	return &resp, c.Do(ctx, http.MethodGet, "/v1/editors/"+editor+"/viewers", nil, &resp)
+}
+
+func (c *Client) RevokeViewerLink(ctx context.Context, editor, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/v1/editors/"+editor+"/viewers/"+id, nil, nil)
+}
+
+func (c *Client) SaveHerokuKey(ctx context.Context, apiKey string) error {
+	return c.Do(ctx, http.MethodPut, "/v1/credentials/heroku", model.HerokuKeyRequest{APIKey: apiKey}, nil)
+}
+
+func (c *Client) DeleteHerokuKey(ctx context.Context) error {
+	return c.Do(ctx, http.MethodDelete, "/v1/credentials/heroku", nil, nil)
+}
+
+func (c *Client) SaveGitHubToken(ctx context.Context, token string) (*model.GitHubAccount, error) {
+	var resp model.GitHubAccount
+	return &resp, c.Do(ctx, http.MethodPut, "/v1/credentials/github", model.GitHubTokenRequest{Token: token}, &resp)
+}
+
+func (c *Client) DeleteGitHubToken(ctx context.Context) error {
+	return c.Do(ctx, http.MethodDelete, "/v1/credentials/github", nil, nil)
+}
@@ -0,0 +1,76 @@
+package command
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jingweno/codeface/editor"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func drainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "drain",
+		Short: "Remove all idle apps from the pool",
+		RunE:  drainRunE,
+	}
+
+	return cmd
+}
+
+func drainRunE(c *cobra.Command, args []string) error {
+	ctx := context.Background()
+	client, err := adminHeroku(ctx)
+	if err != nil {
+		return err
+	}
+
+	currentVersion, otherVersion, err := editor.AllIdledApps(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New().WithField("com", "admin")
+	for _, app := range append(currentVersion, otherVersion...) {
+		app := app
+		editor.DeleteApp(client, &app, logger)
+	}
+
+	fmt.Printf("Drained %d apps\n", len(currentVersion)+len(otherVersion))
+
+	return nil
+}
+
+func rolloverCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollover",
+		Short: "Remove all outdated idle apps at once instead of in batches",
+		RunE:  rolloverRunE,
+	}
+
+	return cmd
+}
+
+func rolloverRunE(c *cobra.Command, args []string) error {
+	ctx := context.Background()
+	client, err := adminHeroku(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, otherVersion, err := editor.AllIdledApps(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New().WithField("com", "admin")
+	for _, app := range otherVersion {
+		app := app
+		editor.DeleteApp(client, &app, logger)
+	}
+
+	fmt.Printf("Removed %d outdated apps\n", len(otherVersion))
+
+	return nil
+}
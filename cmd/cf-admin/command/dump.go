@@ -0,0 +1,51 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpPrefix string
+)
+
+func dumpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Dump the state store as JSON",
+		RunE:  dumpRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&dumpPrefix, "prefix", "p", "", "only dump keys with the prefix")
+
+	return cmd
+}
+
+func dumpRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	keys, err := st.List(ctx, dumpPrefix)
+	if err != nil {
+		return err
+	}
+
+	state := make(map[string]json.RawMessage)
+	for _, key := range keys {
+		var v json.RawMessage
+		if err := st.Get(ctx, key, &v); err != nil {
+			return err
+		}
+		state[key] = v
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
@@ -0,0 +1,152 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	envPromoteVersion string
+	envPromoteSoak    time.Duration
+	envPromoteForce   bool
+	envPromoteBy      string
+)
+
+func environmentsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "environments",
+		Short: "Promote template versions from the staging pool to the production pool",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status <template>",
+		Short: "Show the versions of a template promoted to staging and to production",
+		Args:  cobra.ExactArgs(1),
+		RunE:  environmentsStatusRunE,
+	})
+
+	promote := &cobra.Command{
+		Use:   "promote <template>",
+		Short: "Promote the version of a template on staging to production once it's validated",
+		Args:  cobra.ExactArgs(1),
+		RunE:  environmentsPromoteRunE,
+	}
+	promote.Flags().StringVarP(&envPromoteVersion, "version", "", "", "version to promote, e.g. to roll production back, the one on staging if empty")
+	promote.Flags().DurationVarP(&envPromoteSoak, "soak", "", 30*time.Minute, "how long the version must have been on staging")
+	promote.Flags().BoolVarP(&envPromoteForce, "force", "f", false, "promote a version that isn't validated, e.g. a hotfix")
+	promote.Flags().StringVarP(&envPromoteBy, "by", "b", os.Getenv("USER"), "who promotes the version")
+	cmd.AddCommand(promote)
+
+	return cmd
+}
+
+func environmentsStatusRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	fmt.Printf("%-12s %-14s %-20s %-8s %s\n", "ENVIRONMENT", "VERSION", "PROMOTED", "PINNED", "BY")
+	for _, env := range []string{model.EnvironmentStaging, model.EnvironmentProduction} {
+		promo, err := artifact.Promotion(ctx, st, env, args[0])
+		if err == store.ErrNotFound {
+			fmt.Printf("%-12s %-14s\n", env, "-")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%-12s %-14s %-20s %-8t %s\n", env, promo.Version, promo.PromotedAt.Format("2006-01-02 15:04 MST"), promo.Pinned, promo.PromotedBy)
+	}
+
+	return nil
+}
+
+func environmentsPromoteRunE(c *cobra.Command, args []string) error {
+	if envPromoteBy == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	template := args[0]
+	version, err := validatePromotion(ctx, st, template)
+	if err != nil {
+		return err
+	}
+
+	promo := model.Promotion{
+		Template:    template,
+		Version:     version,
+		PromotedAt:  time.Now(),
+		Pinned:      true,
+		Environment: model.EnvironmentProduction,
+		PromotedBy:  envPromoteBy,
+	}
+	if err := artifact.Promote(ctx, st, promo); err != nil {
+		return err
+	}
+
+	fmt.Printf("Promoted %s of %s to production, the production worker replaces its idle editors a batch per check\n", version, template)
+
+	return nil
+}
+
+// validatePromotion returns the version of a template to promote to
+// production. It has to be the one on staging, promoted there for at least
+// the soak time, and not held back by its scan, unless it's forced.
+func validatePromotion(ctx context.Context, st store.Store, template string) (string, error) {
+	staging, err := artifact.Promotion(ctx, st, model.EnvironmentStaging, template)
+	if err != nil && err != store.ErrNotFound {
+		return "", err
+	}
+
+	version := envPromoteVersion
+	if version == "" {
+		if staging == nil {
+			return "", fmt.Errorf("error: no artifact of %s is promoted to staging yet", template)
+		}
+		version = staging.Version
+	}
+
+	if _, err := artifact.Get(ctx, st, template, version); err == store.ErrNotFound {
+		return "", fmt.Errorf("error: artifact %s of %s is not found", version, template)
+	} else if err != nil {
+		return "", err
+	}
+
+	if envPromoteForce {
+		return version, nil
+	}
+
+	if staging == nil || staging.Version != version {
+		return "", fmt.Errorf("error: %s of %s isn't on staging, promote it with --force if it's validated otherwise", version, template)
+	}
+	if soaked := time.Since(staging.PromotedAt); soaked < envPromoteSoak {
+		return "", fmt.Errorf("error: %s of %s has been on staging for %s, wait until it's been for %s or promote it with --force", version, template, soaked.Round(time.Minute), envPromoteSoak)
+	}
+
+	var r model.ScanReport
+	err = st.Get(ctx, artifact.ScanKey(template, version), &r)
+	if err != nil && err != store.ErrNotFound {
+		return "", err
+	}
+	if err == nil && len(r.Vulnerabilities) > 0 && r.OverriddenBy == "" {
+		return "", fmt.Errorf("error: %s of %s has %d vulnerabilities of %s, see cf-admin scans show %s %s", version, template, len(r.Vulnerabilities), r.Severity, template, version)
+	}
+
+	return version, nil
+}
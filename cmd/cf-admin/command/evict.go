@@ -0,0 +1,73 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	evictUser string
+)
+
+func evictCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "evict",
+		Short: "Scale down all editors claimed by a user",
+		RunE:  evictRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&evictUser, "user", "u", "", "email of the user (required)")
+
+	return cmd
+}
+
+func evictRunE(c *cobra.Command, args []string) error {
+	if evictUser == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx := context.Background()
+	client, err := adminHeroku(ctx)
+	if err != nil {
+		return err
+	}
+
+	var st store.Store
+	if storeURL != "" {
+		st, err = openStore()
+		if err != nil {
+			return err
+		}
+	}
+
+	apps, err := editor.AllClaimedApps(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	for _, app := range apps {
+		if app.Owner.Email != evictUser {
+			continue
+		}
+
+		if err := editor.ScaleApp(ctx, client, app.Name, 0); err != nil {
+			return err
+		}
+
+		if st != nil {
+			if _, err := usage.EndSession(ctx, st, app.Name, time.Now()); err != nil && err != store.ErrNotFound {
+				return err
+			}
+		}
+
+		fmt.Printf("Evicted %s\n", app.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/feature"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagPercent   int
+	flagTemplates []string
+	flagUsers     []string
+	flagBy        string
+)
+
+func flagsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flags",
+		Short: "Ramp behaviors up to a percentage of users with feature flags",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the feature flags set in the store",
+		Args:  cobra.NoArgs,
+		RunE:  flagsListRunE,
+	})
+
+	set := &cobra.Command{
+		Use:   "set <flag>",
+		Short: "Turn a flag on for a percentage of users, which takes precedence over FEATURE_FLAGS",
+		Args:  cobra.ExactArgs(1),
+		RunE:  flagsSetRunE,
+	}
+	set.Flags().IntVarP(&flagPercent, "percent", "p", 0, "percentage of users the flag is on for")
+	set.Flags().StringSliceVar(&flagTemplates, "template", nil, "templates the flag is limited to, may be repeated")
+	set.Flags().StringSliceVar(&flagUsers, "user", nil, "users the flag is always on for, may be repeated")
+	set.Flags().StringVarP(&flagBy, "by", "b", os.Getenv("USER"), "who sets the flag")
+	cmd.AddCommand(set)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <flag>",
+		Short: "Delete a flag from the store, which falls back to FEATURE_FLAGS",
+		Args:  cobra.ExactArgs(1),
+		RunE:  flagsDeleteRunE,
+	})
+
+	return cmd
+}
+
+func flagsListRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	flags, err := feature.List(context.Background(), st)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-24s %-8s %-24s %-24s %-20s %s\n", "FLAG", "PERCENT", "TEMPLATES", "USERS", "UPDATED", "BY")
+	for _, f := range flags {
+		fmt.Printf("%-24s %-8d %-24s %-24s %-20s %s\n", f.Name, f.Percent, strings.Join(f.Templates, ","), strings.Join(f.Users, ","), f.UpdatedAt.Format("2006-01-02 15:04 MST"), f.UpdatedBy)
+	}
+
+	return nil
+}
+
+func flagsSetRunE(c *cobra.Command, args []string) error {
+	if !feature.IsKnown(args[0]) {
+		return fmt.Errorf("error: unknown feature flag %q, known flags are %s", args[0], strings.Join(feature.Known, ", "))
+	}
+	if flagPercent < 0 || flagPercent > 100 {
+		return fmt.Errorf("error: percentage must be between 0 and 100")
+	}
+
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	f := model.Flag{
+		Name:      args[0],
+		Percent:   flagPercent,
+		Templates: flagTemplates,
+		Users:     flagUsers,
+		UpdatedBy: flagBy,
+		UpdatedAt: time.Now(),
+	}
+	if err := st.Put(context.Background(), feature.Key(f.Name), f); err != nil {
+		return err
+	}
+
+	fmt.Printf("Flag %s is on for %d%% of users, which takes effect within a minute\n", f.Name, f.Percent)
+
+	return nil
+}
+
+func flagsDeleteRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var f model.Flag
+	err = st.Get(ctx, feature.Key(args[0]), &f)
+	if err == store.ErrNotFound {
+		return fmt.Errorf("error: flag %s isn't set", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := st.Delete(ctx, feature.Key(args[0])); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted flag %s\n", args[0])
+
+	return nil
+}
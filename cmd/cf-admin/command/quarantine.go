@@ -0,0 +1,142 @@
+package command
+
+import (
+	"context"
+	"fmt"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	purgeAllQuarantined bool
+)
+
+func quarantineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "quarantine",
+		Short: "Inspect the apps of failed pool deploys",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List quarantined deploys",
+		Args:  cobra.NoArgs,
+		RunE:  quarantineListRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "inspect <app>",
+		Short: "Show the error and the build output of a quarantined deploy",
+		Args:  cobra.ExactArgs(1),
+		RunE:  quarantineInspectRunE,
+	})
+
+	purge := &cobra.Command{
+		Use:   "purge [<app>...]",
+		Short: "Delete quarantined apps, which lets the worker deploy to their pool again",
+		RunE:  quarantinePurgeRunE,
+	}
+	purge.Flags().BoolVarP(&purgeAllQuarantined, "all", "a", false, "purge all quarantined apps")
+	cmd.AddCommand(purge)
+
+	return cmd
+}
+
+func quarantineListRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	qs, err := editor.Quarantined(context.Background(), st)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-32s %-16s %-20s %-20s %s\n", "APP", "TEMPLATE", "QUARANTINED", "EXPIRES", "ERROR")
+	for _, q := range qs {
+		fmt.Printf("%-32s %-16s %-20s %-20s %s\n", q.App, q.Template, q.QuarantinedAt.Format("2006-01-02 15:04 MST"), q.ExpiresAt.Format("2006-01-02 15:04 MST"), q.Error)
+	}
+
+	return nil
+}
+
+func quarantineInspectRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	var q model.Quarantine
+	err = st.Get(context.Background(), editor.QuarantineKey(args[0]), &q)
+	if err == store.ErrNotFound {
+		return fmt.Errorf("error: %s isn't quarantined", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("App:         %s\n", q.App)
+	fmt.Printf("Template:    %s\n", q.Template)
+	fmt.Printf("Deploy:      %s\n", q.Deploy)
+	fmt.Printf("Quarantined: %s\n", q.QuarantinedAt.Format("2006-01-02 15:04 MST"))
+	fmt.Printf("Expires:     %s\n", q.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	fmt.Printf("Error:       %s\n", q.Error)
+	fmt.Printf("\nInspect the app with e.g.: heroku logs -a %s\n", q.App)
+	if q.Output != "" {
+		fmt.Printf("\n%s", q.Output)
+	}
+
+	return nil
+}
+
+func quarantinePurgeRunE(c *cobra.Command, args []string) error {
+	if len(args) == 0 && !purgeAllQuarantined {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx := context.Background()
+	client, err := adminHeroku(ctx)
+	if err != nil {
+		return err
+	}
+
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	apps := args
+	if purgeAllQuarantined {
+		qs, err := editor.Quarantined(ctx, st)
+		if err != nil {
+			return err
+		}
+
+		apps = nil
+		for _, q := range qs {
+			apps = append(apps, q.App)
+		}
+	}
+
+	logger := log.New().WithField("com", "admin")
+	for _, app := range apps {
+		if err := st.Get(ctx, editor.QuarantineKey(app), &model.Quarantine{}); err != nil {
+			return fmt.Errorf("error: %s isn't quarantined", app)
+		}
+
+		editor.DeleteApp(client, &heroku.App{Name: app}, logger)
+		if err := st.Delete(ctx, editor.QuarantineKey(app)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Purged %d apps\n", len(apps))
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	herokuAPIToken string
+	storeURL       string
+	storeKeys      string
+	storePlaintext bool
+	awsRegion      string
+)
+
+func Root() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "cf-admin",
+		Short: "Codeface fleet administration",
+	}
+
+	rootCmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token of the pool account (required)")
+	rootCmd.PersistentFlags().StringVarP(&storeURL, "store", "", os.Getenv("STORE_URL"), "state store URL")
+	rootCmd.PersistentFlags().StringVarP(&storeKeys, "store-keys", "", os.Getenv("STORE_ENCRYPTION_KEYS"), "encryption keys of the state store, separated by ;")
+	rootCmd.PersistentFlags().BoolVarP(&storePlaintext, "store-allow-plaintext", "", os.Getenv("STORE_ALLOW_PLAINTEXT") == "true", "read values of the state store written before it was encrypted")
+	rootCmd.PersistentFlags().StringVarP(&awsRegion, "aws-region", "", envOr("AWS_REGION", "us-east-1"), "AWS region of KMS store keys")
+
+	rootCmd.AddCommand(drainCmd())
+	rootCmd.AddCommand(rolloverCmd())
+	rootCmd.AddCommand(evictCmd())
+	rootCmd.AddCommand(quarantineCmd())
+	rootCmd.AddCommand(rotateTokenCmd())
+	rootCmd.AddCommand(scansCmd())
+	rootCmd.AddCommand(flagsCmd())
+	rootCmd.AddCommand(environmentsCmd())
+	rootCmd.AddCommand(rotateStoreKeyCmd())
+	rootCmd.AddCommand(dumpCmd())
+
+	return rootCmd
+}
+
+// adminHeroku returns a Heroku client for the pool account. Admin
+// commands act on the whole fleet, so the token must be valid.
+func adminHeroku(ctx context.Context) (*heroku.Service, error) {
+	if herokuAPIToken == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	client := editor.HerokuService(herokuAPIToken)
+	if _, err := editor.Account(ctx, client); err != nil {
+		return nil, fmt.Errorf("error: invalid admin credentials: %w", err)
+	}
+
+	return client, nil
+}
+
+func openStore() (store.Store, error) {
+	if storeURL == "" {
+		return nil, fmt.Errorf("missing --store or STORE_URL")
+	}
+	if !store.Shared(storeURL) {
+		return nil, fmt.Errorf("error: %s isn't shared with the server and the worker", storeURL)
+	}
+
+	var keys []string
+	if storeKeys != "" {
+		keys = strings.Split(storeKeys, ";")
+	}
+
+	return store.OpenEncrypted(storeURL, keys, awsRegion, storePlaintext)
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
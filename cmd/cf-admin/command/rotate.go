@@ -0,0 +1,104 @@
+package command
+
+import (
+	"context"
+	"fmt"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rotateApp    string
+	rotateRevoke string
+)
+
+func rotateTokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-token",
+		Short: "Create a new Heroku API token for the pool account",
+		RunE:  rotateTokenRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&rotateApp, "app", "a", "", "Heroku app running codeface to update HEROKU_API_KEY on (optional)")
+	cmd.PersistentFlags().StringVarP(&rotateRevoke, "revoke", "", "", "OAuth authorization ID of the old token to revoke (optional)")
+
+	return cmd
+}
+
+func rotateTokenRunE(c *cobra.Command, args []string) error {
+	ctx := context.Background()
+	client, err := adminHeroku(ctx)
+	if err != nil {
+		return err
+	}
+
+	desc := "codeface"
+	auth, err := client.OAuthAuthorizationCreate(ctx, heroku.OAuthAuthorizationCreateOpts{
+		Description: &desc,
+		Scope:       []string{"global"},
+	})
+	if err != nil {
+		return err
+	}
+
+	if auth.AccessToken == nil {
+		return fmt.Errorf("error: no access token is returned for authorization %s", auth.ID)
+	}
+	token := auth.AccessToken.Token
+
+	if rotateApp != "" {
+		_, err := client.ConfigVarUpdate(ctx, rotateApp, map[string]*string{
+			"HEROKU_API_KEY": &token,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Updated HEROKU_API_KEY on %s\n", rotateApp)
+	}
+
+	if rotateRevoke != "" {
+		// the old token may be the one in use, so revoke it with the new one
+		if _, err := client.OAuthAuthorizationDelete(ctx, rotateRevoke); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked authorization %s\n", rotateRevoke)
+	}
+
+	fmt.Printf("Created authorization %s\n", auth.ID)
+	if rotateApp == "" {
+		fmt.Printf("Token: %s\n", token)
+	}
+
+	return nil
+}
+
+func rotateStoreKeyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate-store-key",
+		Short: "Encrypt the state store again with the first of --store-keys, along with the values written before it was encrypted",
+		RunE:  rotateStoreKeyRunE,
+	}
+}
+
+func rotateStoreKeyRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	enc, ok := st.(*store.Encrypted)
+	if !ok {
+		return fmt.Errorf("missing --store-keys or STORE_ENCRYPTION_KEYS")
+	}
+
+	n, err := enc.Rotate(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Encrypted %d values again, keys other than the first and STORE_ALLOW_PLAINTEXT can be removed\n", n)
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanOverrideBy string
+	scanRescan     bool
+)
+
+func scansCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scans",
+		Short: "Inspect the vulnerability scans of artifacts",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <template>",
+		Short: "List the scans of the artifacts of a template",
+		Args:  cobra.ExactArgs(1),
+		RunE:  scansListRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show <template> <version>",
+		Short: "Show the vulnerabilities found in an artifact",
+		Args:  cobra.ExactArgs(2),
+		RunE:  scansShowRunE,
+	})
+
+	override := &cobra.Command{
+		Use:   "override <template> <version>",
+		Short: "Let an artifact with vulnerabilities be promoted, or scan it again with --rescan",
+		Args:  cobra.ExactArgs(2),
+		RunE:  scansOverrideRunE,
+	}
+	override.Flags().StringVarP(&scanOverrideBy, "by", "b", os.Getenv("USER"), "who overrides the scan")
+	override.Flags().BoolVarP(&scanRescan, "rescan", "r", false, "delete the scan so that the worker scans the artifact again")
+	cmd.AddCommand(override)
+
+	return cmd
+}
+
+func scansListRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	reports, err := artifact.Scans(context.Background(), st, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-14s %-20s %-16s %-8s %s\n", "VERSION", "SCANNED", "SEVERITY", "FOUND", "OVERRIDDEN BY")
+	for _, r := range reports {
+		fmt.Printf("%-14s %-20s %-16s %-8d %s\n", r.Version, r.ScannedAt.Format("2006-01-02 15:04 MST"), r.Severity, len(r.Vulnerabilities), r.OverriddenBy)
+	}
+
+	return nil
+}
+
+func scanReport(ctx context.Context, st store.Store, template, version string) (*model.ScanReport, error) {
+	var r model.ScanReport
+	err := st.Get(ctx, artifact.ScanKey(template, version), &r)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("error: artifact %s of %s isn't scanned", version, template)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func scansShowRunE(c *cobra.Command, args []string) error {
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	r, err := scanReport(context.Background(), st, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Template: %s\n", r.Template)
+	fmt.Printf("Version:  %s\n", r.Version)
+	fmt.Printf("Scanned:  %s\n", r.ScannedAt.Format("2006-01-02 15:04 MST"))
+	for _, t := range r.Targets {
+		fmt.Printf("Target:   %s\n", t)
+	}
+	if r.OverriddenBy != "" {
+		fmt.Printf("Overridden by %s at %s\n", r.OverriddenBy, r.OverriddenAt.Format("2006-01-02 15:04 MST"))
+	}
+
+	if len(r.Vulnerabilities) == 0 {
+		fmt.Printf("\nNo vulnerabilities of %s are found\n", r.Severity)
+		return nil
+	}
+
+	fmt.Printf("\n%-20s %-10s %-24s %-16s %-16s %s\n", "ID", "SEVERITY", "PACKAGE", "INSTALLED", "FIXED", "TARGET")
+	for _, v := range r.Vulnerabilities {
+		fmt.Printf("%-20s %-10s %-24s %-16s %-16s %s\n", v.ID, v.Severity, v.Package, v.InstalledVersion, v.FixedVersion, v.Target)
+	}
+
+	return nil
+}
+
+func scansOverrideRunE(c *cobra.Command, args []string) error {
+	if !scanRescan && scanOverrideBy == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx := context.Background()
+	st, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	r, err := scanReport(ctx, st, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if scanRescan {
+		if err := st.Delete(ctx, artifact.ScanKey(r.Template, r.Version)); err != nil {
+			return err
+		}
+
+		fmt.Printf("Artifact %s of %s is scanned again on the next deploy\n", r.Version, r.Template)
+		return nil
+	}
+
+	now := time.Now()
+	r.OverriddenBy = scanOverrideBy
+	r.OverriddenAt = &now
+	if err := st.Put(ctx, artifact.ScanKey(r.Template, r.Version), r); err != nil {
+		return err
+	}
+
+	fmt.Printf("Artifact %s of %s may be promoted with %d vulnerabilities\n", r.Version, r.Template, len(r.Vulnerabilities))
+
+	return nil
+}
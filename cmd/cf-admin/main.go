@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/jingweno/codeface/cmd/cf-admin/command"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	rootCmd := command.Root()
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+func portsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ports",
+		Short: "List the ports served at /ports/<port>/ of the editor URL",
+		Args:  cobra.NoArgs,
+		RunE:  portsRunE,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "open <port>",
+		Short: "Serve a port, e.g. of a dev server, at /ports/<port>/ of the editor URL",
+		Args:  cobra.ExactArgs(1),
+		RunE:  portsOpenRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "close <port>",
+		Short: "Stop serving a port",
+		Args:  cobra.ExactArgs(1),
+		RunE:  portsCloseRunE,
+	})
+
+	return cmd
+}
+
+func portsRunE(c *cobra.Command, args []string) error {
+	cl, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	ports, err := cl.Ports(context.Background())
+	if err != nil {
+		return err
+	}
+
+	printPorts(ports)
+
+	return nil
+}
+
+func portsOpenRunE(c *cobra.Command, args []string) error {
+	port, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid port %q", args[0])
+	}
+
+	cl, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	ports, err := cl.OpenPort(context.Background(), port)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Port %d is served at %s%d/ of the editor URL\n", port, editorproxy.PortsPrefix, port)
+	printPorts(ports)
+
+	return nil
+}
+
+func portsCloseRunE(c *cobra.Command, args []string) error {
+	port, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid port %q", args[0])
+	}
+
+	cl, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	ports, err := cl.ClosePort(context.Background(), port)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Port %d is closed\n", port)
+	printPorts(ports)
+
+	return nil
+}
+
+func printPorts(ports *model.EditorPorts) {
+	if len(ports.Ports) == 0 {
+		fmt.Println("No port is open")
+		return
+	}
+
+	fmt.Printf("%-6s %s\n", "PORT", "PATH")
+	for _, p := range ports.Ports {
+		fmt.Printf("%-6d %s%d/\n", p, editorproxy.PortsPrefix, p)
+	}
+}
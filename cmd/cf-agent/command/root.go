@@ -0,0 +1,36 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+// Root is the CLI users run inside their editor. It talks to the server
+// with the agent token of the editor, which is set on claimed editors with
+// SERVER_URL set on the server.
+func Root() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:          "cf-agent",
+		Short:        "Manage the session of this Codeface editor",
+		SilenceUsage: true,
+	}
+
+	rootCmd.AddCommand(statusCmd())
+	rootCmd.AddCommand(extendCmd())
+	rootCmd.AddCommand(snapshotCmd())
+	rootCmd.AddCommand(portsCmd())
+
+	return rootCmd
+}
+
+func agentClient() (*client.Client, error) {
+	serverURL, token := os.Getenv("CF_SERVER_URL"), os.Getenv("CF_AGENT_TOKEN")
+	if serverURL == "" || token == "" {
+		return nil, fmt.Errorf("this editor has no agent, its server doesn't set SERVER_URL")
+	}
+
+	return client.NewAgent(serverURL, token), nil
+}
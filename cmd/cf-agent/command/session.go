@@ -0,0 +1,88 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+func statusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the session of the editor, e.g. the time it has left",
+		Args:  cobra.NoArgs,
+		RunE:  statusRunE,
+	}
+}
+
+func extendCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "extend",
+		Short: "Extend the maximum session duration of the editor",
+		Args:  cobra.NoArgs,
+		RunE:  extendRunE,
+	}
+}
+
+func statusRunE(c *cobra.Command, args []string) error {
+	cl, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := cl.AgentSession(context.Background())
+	if err != nil {
+		return err
+	}
+
+	printSession(s)
+
+	return nil
+}
+
+func extendRunE(c *cobra.Command, args []string) error {
+	cl, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := cl.ExtendSession(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Session is extended, %d of %d extensions are used\n", s.Extensions, s.MaxExtensions)
+	printSession(s)
+
+	return nil
+}
+
+func printSession(s *model.AgentSession) {
+	fmt.Printf("%-12s %s\n", "Editor:", s.Editor)
+	fmt.Printf("%-12s %s\n", "Template:", s.Template)
+	fmt.Printf("%-12s %s\n", "Owner:", s.User)
+	fmt.Printf("%-12s %s\n", "Claimed:", s.ClaimedAt.Format("2006-01-02 15:04 MST"))
+
+	if s.ExpiresAt == nil {
+		fmt.Printf("%-12s %s\n", "Expires:", "never, the editor has no maximum session duration")
+	} else {
+		left := time.Until(*s.ExpiresAt).Round(time.Minute)
+		if left < 0 {
+			left = 0
+		}
+		fmt.Printf("%-12s %s (%s left)\n", "Expires:", s.ExpiresAt.Format("2006-01-02 15:04 MST"), left)
+		fmt.Printf("%-12s %d of %d used\n", "Extensions:", s.Extensions, s.MaxExtensions)
+	}
+
+	if len(s.Ports) > 0 {
+		var ports []string
+		for _, p := range s.Ports {
+			ports = append(ports, fmt.Sprintf("%d", p))
+		}
+		fmt.Printf("%-12s %s\n", "Ports:", strings.Join(ports, ", "))
+	}
+}
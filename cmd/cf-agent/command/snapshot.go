@@ -0,0 +1,54 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/agent"
+	"github.com/spf13/cobra"
+)
+
+var workspace string
+
+func snapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save a snapshot of the workspace, which the owner downloads with GET /v1/editors/{name}/snapshot",
+		Args:  cobra.NoArgs,
+		RunE:  snapshotRunE,
+	}
+
+	dir := os.Getenv("CF_WORKSPACE")
+	if dir == "" {
+		dir = "/home/dyno/project"
+	}
+	cmd.Flags().StringVarP(&workspace, "workspace", "w", dir, "directory to save")
+
+	return cmd
+}
+
+func snapshotRunE(c *cobra.Command, args []string) error {
+	cl, err := agentClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	resp, err := cl.SaveSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Saving snapshot of %s...\n", workspace)
+	if err := agent.UploadWorkspace(ctx, workspace, resp.UploadURL); err != nil {
+		return err
+	}
+	if err := cl.CompleteSavedSnapshot(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("Workspace snapshot is saved")
+
+	return nil
+}
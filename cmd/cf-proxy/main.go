@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/middleware"
+	"github.com/jingweno/codeface/model"
+	"github.com/joeshaw/envdecode"
+	"github.com/oklog/run"
+	log "github.com/sirupsen/logrus"
+)
+
+type config struct {
+	Port            string   `env:"PORT,required"`
+	CodeServerAddr  string   `env:"CF_CODE_SERVER_ADDR,default=127.0.0.1:8079"`
+	EgressAddr      string   `env:"CF_EGRESS_PROXY_ADDR,default=127.0.0.1:8078"`
+	IPAllowList     []string `env:"CF_IP_ALLOWLIST"`
+	EgressDeny      []string `env:"CF_EGRESS_DENY"`
+	TrustedHops     int      `env:"CF_TRUSTED_HOPS,default=0"`
+	MaxRequestBytes int64    `env:"CF_MAX_REQUEST_BYTES,default=67108864"`
+	// ViewerKey is set on editors that can be shared with viewer links,
+	// which are served by the read-only code-server at ViewerCodeServerAddr
+	ViewerKey            string `env:"CF_VIEWER_KEY"`
+	ViewerCodeServerAddr string `env:"CF_VIEWER_CODE_SERVER_ADDR,default=127.0.0.1:8077"`
+
+	ServerURL          string        `env:"CF_SERVER_URL"`
+	AgentToken         string        `env:"CF_AGENT_TOKEN"`
+	Workspace          string        `env:"CF_WORKSPACE,default=/home/dyno/project"`
+	DiskReportInterval time.Duration `env:"CF_DISK_REPORT_INTERVAL,default=5m"`
+	DiskCleanupCommand string        `env:"CF_DISK_CLEANUP_COMMAND"`
+	SnapshotInterval   time.Duration `env:"CF_SNAPSHOT_POLL_INTERVAL,default=15s"`
+	WIPPushInterval    time.Duration `env:"CF_WIP_PUSH_POLL_INTERVAL,default=15s"`
+	// ResourceReportInterval is how often memory and CPU utilization is
+	// reported, 0 to turn reports off
+	ResourceReportInterval time.Duration `env:"CF_RESOURCE_REPORT_INTERVAL,default=1m"`
+	// TerminalRecording is set by templates whose terminals are recorded
+	TerminalRecording       bool          `env:"CF_TERMINAL_RECORDING,default=false"`
+	RecordingDir            string        `env:"CF_RECORDING_DIR,default=/home/dyno/.codeface/recordings"`
+	RecordingUploadInterval time.Duration `env:"CF_RECORDING_UPLOAD_INTERVAL,default=1m"`
+	// DepsCacheUploadURL is set on claims of a repo, the dependencies at
+	// DepsCachePaths are uploaded to it when the editor stops
+	DepsCacheUploadURL string   `env:"CF_DEPS_CACHE_UPLOAD_URL"`
+	DepsCachePaths     []string `env:"CF_DEPS_CACHE_PATHS"`
+	DepsCacheMaxBytes  int64    `env:"CF_DEPS_CACHE_MAX_BYTES,default=2147483648"`
+}
+
+func main() {
+	logger := log.New().WithField("com", "cf-proxy")
+
+	// cf-proxy is also the git credential helper of the editor
+	if len(os.Args) == 3 && os.Args[1] == "git-credential" {
+		c := client.NewAgent(os.Getenv("CF_SERVER_URL"), os.Getenv("CF_AGENT_TOKEN"))
+		rc := agent.RepoCredential{
+			Repo:     os.Getenv("GIT_REPO"),
+			Username: os.Getenv("CF_GIT_USERNAME"),
+			Password: os.Getenv("CF_GIT_PASSWORD"),
+		}
+		if err := agent.GitCredentialHelper(context.Background(), c, rc, os.Args[2], os.Stdin, os.Stdout); err != nil {
+			logger.WithError(err).Error("Fail to get git credential")
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the Codeface extension warns users with it before the editor is
+	// released
+	if len(os.Args) == 2 && (os.Args[1] == "idle-warning" || os.Args[1] == "keep-alive") {
+		c := client.NewAgent(os.Getenv("CF_SERVER_URL"), os.Getenv("CF_AGENT_TOKEN"))
+
+		var err error
+		if os.Args[1] == "idle-warning" {
+			err = agent.IdleWarning(context.Background(), c, os.Stdout)
+		} else {
+			err = c.KeepAlive(context.Background())
+		}
+		if err != nil {
+			logger.WithError(err).Error("Fail to " + strings.Replace(os.Args[1], "-", " ", 1))
+			os.Exit(1)
+		}
+		return
+	}
+
+	// headless editors report the exit status of their command with it
+	if len(os.Args) == 3 && os.Args[1] == "complete-run" {
+		code, err := strconv.Atoi(os.Args[2])
+		if err != nil {
+			logger.WithError(err).Error("Fail to parse exit status")
+			os.Exit(1)
+		}
+
+		c := client.NewAgent(os.Getenv("CF_SERVER_URL"), os.Getenv("CF_AGENT_TOKEN"))
+		if err := c.CompleteRun(context.Background(), model.RunResult{ExitCode: code}); err != nil {
+			logger.WithError(err).Error("Fail to complete run")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := serve(logger); err != nil {
+		logger.WithError(err).Error("Fail to run proxy")
+		os.Exit(1)
+	}
+}
+
+func serve(logger log.FieldLogger) error {
+	var cfg config
+	if err := envdecode.StrictDecode(&cfg); err != nil {
+		return err
+	}
+
+	allowed, err := editorproxy.ParseCIDRs(cfg.IPAllowList)
+	if err != nil {
+		return err
+	}
+
+	deny, err := editorproxy.ParseCIDRs(cfg.EgressDeny)
+	if err != nil {
+		return err
+	}
+
+	var g run.Group
+	// stopUploads is set when files are uploaded once the editor stops,
+	// which needs the group to stop on SIGTERM
+	var stopUploads bool
+
+	h := editorproxy.NewEditorHandler(cfg.CodeServerAddr, nil, 0, logger)
+	// the ports the user opens are served next to the editor, behind the
+	// same network policy
+	if cfg.AgentToken != "" {
+		c := client.NewAgent(cfg.ServerURL, cfg.AgentToken)
+		h = &editorproxy.Ports{
+			Next: h,
+			Lookup: func(ctx context.Context) ([]int, error) {
+				ports, err := c.Ports(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return ports.Ports, nil
+			},
+			Reserved: addrPorts(":"+cfg.Port, cfg.CodeServerAddr, cfg.ViewerCodeServerAddr, cfg.EgressAddr),
+			Logger:   logger,
+		}
+	}
+	// requests of viewer links never reach the code-server of the user,
+	// also on editors without a viewer key
+	h = editorproxy.Viewers(h, cfg.ViewerCodeServerAddr, cfg.ViewerKey, logger)
+	h = editorproxy.AllowList(h, allowed, cfg.TrustedHops, logger)
+	editor := middleware.Server(":"+cfg.Port, middleware.Harden(h, middleware.Options{
+		FrameOptions:    "SAMEORIGIN",
+		MaxRequestBytes: cfg.MaxRequestBytes,
+	}, logger))
+	g.Add(editor.ListenAndServe, func(error) {
+		editor.Close()
+	})
+
+	if len(deny) > 0 {
+		// the egress proxy is only reachable from inside the editor
+		egress := middleware.Server(cfg.EgressAddr, editorproxy.NewEgress(deny, logger))
+		g.Add(egress.ListenAndServe, func(error) {
+			egress.Close()
+		})
+	}
+
+	if cfg.AgentToken != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &agent.DiskReporter{
+			Client:         client.NewAgent(cfg.ServerURL, cfg.AgentToken),
+			Workspace:      cfg.Workspace,
+			Interval:       cfg.DiskReportInterval,
+			CleanupCommand: cfg.DiskCleanupCommand,
+			Logger:         logger,
+		}
+		g.Add(func() error {
+			return r.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+
+		if cfg.ResourceReportInterval > 0 {
+			rr := &agent.ResourceReporter{
+				Client:   client.NewAgent(cfg.ServerURL, cfg.AgentToken),
+				Interval: cfg.ResourceReportInterval,
+				Logger:   logger,
+			}
+			g.Add(func() error {
+				return rr.Run(ctx)
+			}, func(error) {
+				cancel()
+			})
+		}
+
+		rd := &agent.ReadyReporter{
+			Client: client.NewAgent(cfg.ServerURL, cfg.AgentToken),
+			Addr:   cfg.CodeServerAddr,
+			Logger: logger,
+		}
+		g.Add(func() error {
+			return rd.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+
+		sn := &agent.Snapshotter{
+			Client:    client.NewAgent(cfg.ServerURL, cfg.AgentToken),
+			Workspace: cfg.Workspace,
+			Interval:  cfg.SnapshotInterval,
+			Logger:    logger,
+		}
+		g.Add(func() error {
+			return sn.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+
+		wp := &agent.WIPPusher{
+			Client:    client.NewAgent(cfg.ServerURL, cfg.AgentToken),
+			Workspace: cfg.Workspace,
+			Interval:  cfg.WIPPushInterval,
+			Logger:    logger,
+		}
+		g.Add(func() error {
+			return wp.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+
+		if cfg.TerminalRecording {
+			rec := &agent.Recorder{
+				Client:   client.NewAgent(cfg.ServerURL, cfg.AgentToken),
+				Dir:      cfg.RecordingDir,
+				Interval: cfg.RecordingUploadInterval,
+				Logger:   logger,
+			}
+			g.Add(func() error {
+				return rec.Run(ctx)
+			}, func(error) {
+				cancel()
+			})
+
+			// the recordings of open terminals are uploaded when the editor
+			// is stopped
+			stopUploads = true
+		}
+	}
+
+	if cfg.DepsCacheUploadURL != "" && len(cfg.DepsCachePaths) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		dc := &agent.DepsCacher{
+			Paths:     cfg.DepsCachePaths,
+			UploadURL: cfg.DepsCacheUploadURL,
+			MaxBytes:  cfg.DepsCacheMaxBytes,
+			Logger:    logger,
+		}
+		g.Add(func() error {
+			return dc.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+		stopUploads = true
+	}
+
+	if stopUploads {
+		g.Add(run.SignalHandler(context.Background(), syscall.SIGTERM, os.Interrupt))
+	}
+
+	logger.WithField("port", cfg.Port).Info("Starting proxy")
+
+	err = g.Run()
+	if _, ok := err.(run.SignalError); ok {
+		return nil
+	}
+
+	return err
+}
+
+// addrPorts returns the ports of addresses, e.g. 127.0.0.1:8079.
+func addrPorts(addrs ...string) []int {
+	var ports []int
+	for _, addr := range addrs {
+		_, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if port, err := strconv.Atoi(p); err == nil {
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
@@ -0,0 +1,57 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func activityCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "activity <editor>",
+		Short: "Show the requests and errors of a claimed editor",
+		Args:  cobra.ExactArgs(1),
+		RunE:  activityRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func activityRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	a, err := client.New(serverURL, herokuAPIToken).Activity(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	var errorRate float64
+	if a.Requests > 0 {
+		errorRate = float64(a.Errors) * 100 / float64(a.Requests)
+	}
+
+	lastRequest := "-"
+	if !a.LastRequestAt.IsZero() {
+		lastRequest = a.LastRequestAt.Format("2006-01-02 15:04 MST")
+	}
+	lastError := a.LastError
+	if lastError == "" {
+		lastError = "-"
+	}
+
+	fmt.Printf("Since:        %s\n", a.Since.Format("2006-01-02 15:04 MST"))
+	fmt.Printf("Requests:     %d\n", a.Requests)
+	fmt.Printf("Errors:       %d (%.1f%%)\n", a.Errors, errorRate)
+	fmt.Printf("Last error:   %s\n", lastError)
+	fmt.Printf("Last request: %s\n", lastRequest)
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	artifactKind       string
+	artifactStack      string
+	artifactDigest     string
+	artifactSigningKey string
+)
+
+func artifactsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "artifacts <template>",
+		Short: "List the builds of a template that pool editors are promoted from",
+		Args:  cobra.ExactArgs(1),
+		RunE:  artifactsRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "promote <template> [version]",
+		Short: "Roll the pool of a template back to an artifact, or follow the newest build again without a version",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  artifactsPromoteRunE,
+	})
+
+	registerCmd := &cobra.Command{
+		Use:   "register <template> <version> <ref>",
+		Short: "Record an image or source tarball of a template that is built outside of the worker, e.g. by CI",
+		Args:  cobra.ExactArgs(3),
+		RunE:  artifactsRegisterRunE,
+	}
+	registerCmd.Flags().StringVarP(&artifactKind, "kind", "k", "oci", "Kind of the artifact, oci or s3")
+	registerCmd.Flags().StringVar(&artifactStack, "stack", "", "Stack the artifact is built on")
+	registerCmd.Flags().StringVar(&artifactDigest, "digest", "", "SHA-256 of the source tarball of s3 artifacts, in hex")
+	registerCmd.Flags().StringVar(&artifactSigningKey, "signing-key", os.Getenv("CODEFACE_ARTIFACT_SIGNING_KEY"), "Key the artifact is signed with")
+	cmd.AddCommand(registerCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key to sign artifacts with and its public key to verify them with",
+		Args:  cobra.NoArgs,
+		RunE:  artifactsKeygenRunE,
+	})
+
+	return cmd
+}
+
+func artifactsClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func artifactsRunE(c *cobra.Command, args []string) error {
+	cl, err := artifactsClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.Artifacts(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	var promoted, production string
+	if resp.Promotion != nil {
+		promoted = resp.Promotion.Version
+	}
+	if resp.Production != nil {
+		production = resp.Production.Version
+	}
+
+	// * is promoted to the pool, or to staging with environments, and P to
+	// production
+	for _, art := range resp.Artifacts {
+		mark := " "
+		if art.Version == production {
+			mark = "P"
+		}
+		if art.Version == promoted {
+			mark = "*"
+		}
+
+		fmt.Printf("%s %s  %-6s  %-9s  %-24s  %s\n", mark, art.Version, art.Kind, art.Stack, art.Ref, art.CreatedAt.Format("2006-01-02 15:04 MST"))
+	}
+
+	if resp.Promotion != nil && resp.Promotion.Pinned {
+		fmt.Printf("The pool is pinned to %s, follow the newest build again with: cf artifacts promote %s\n", promoted, args[0])
+	}
+	if resp.Production != nil && production != promoted {
+		fmt.Printf("Production is on %s, promote %s to it with: cf-admin environments promote %s\n", production, promoted, args[0])
+	}
+
+	return nil
+}
+
+func artifactsPromoteRunE(c *cobra.Command, args []string) error {
+	cl, err := artifactsClient()
+	if err != nil {
+		return err
+	}
+
+	var version string
+	if len(args) == 2 {
+		version = args[1]
+	}
+
+	promo, err := cl.Promote(context.Background(), args[0], version)
+	if err != nil {
+		return err
+	}
+
+	if !promo.Pinned {
+		fmt.Printf("The pool of %s follows the newest build again\n", args[0])
+		return nil
+	}
+
+	fmt.Printf("Promoted %s of %s, idle editors are being replaced\n", promo.Version, args[0])
+
+	return nil
+}
+
+func artifactsRegisterRunE(c *cobra.Command, args []string) error {
+	cl, err := artifactsClient()
+	if err != nil {
+		return err
+	}
+
+	art := model.Artifact{
+		Template: args[0],
+		Version:  args[1],
+		Kind:     artifactKind,
+		Ref:      args[2],
+		Stack:    artifactStack,
+		Digest:   artifactDigest,
+	}
+	if artifactSigningKey != "" {
+		signer, err := artifact.NewSigner(artifactSigningKey)
+		if err != nil {
+			return err
+		}
+		signer.Sign(&art)
+	}
+
+	reg, err := cl.RegisterArtifact(context.Background(), art)
+	if err != nil {
+		return err
+	}
+	art = *reg
+
+	fmt.Printf("Registered %s of %s as %s\n", art.Version, art.Template, art.Ref)
+
+	return nil
+}
+
+func artifactsKeygenRunE(c *cobra.Command, args []string) error {
+	signingKey, publicKey, err := artifact.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Signing key: %s\n", signingKey)
+	fmt.Printf("Public key:  %s\n", publicKey)
+	fmt.Println("Set ARTIFACT_SIGNING_KEY of the worker or ARTIFACT_VERIFY_KEYS to the public key, and keep the signing key secret")
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	batchReq  model.BatchRequest
+	batchWait bool
+	batchCSV  bool
+)
+
+const batchPollInterval = 5 * time.Second
+
+func batchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Provision and tear down editors for a workshop or a class",
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Provision a batch of editors",
+		Args:  cobra.NoArgs,
+		RunE:  batchCreateRunE,
+	}
+	create.Flags().IntVarP(&batchReq.Count, "count", "n", 0, "number of editors (required)")
+	create.Flags().StringVarP(&batchReq.Template, "template", "", "", "template of the editors, any if empty")
+	create.Flags().StringVarP(&batchReq.GitRepo, "git", "g", "", "Git repository cloned in every editor")
+	create.Flags().StringVarP(&batchReq.GitRef, "ref", "", "", "branch, tag or commit checked out, the default branch if empty")
+	create.Flags().StringSliceVarP(&batchReq.Invitees, "invite", "", nil, "email addresses the seat links are emailed to, one per seat in order")
+	create.Flags().BoolVarP(&batchWait, "wait", "w", false, "wait until every editor is claimed")
+	create.Flags().BoolVarP(&batchCSV, "csv", "", false, "print the editors as CSV once they're claimed, implies --wait")
+	cmd.AddCommand(create)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List batches",
+		Args:  cobra.NoArgs,
+		RunE:  batchListRunE,
+	})
+
+	get := &cobra.Command{
+		Use:   "get <batch>",
+		Short: "Show the editors of a batch",
+		Args:  cobra.ExactArgs(1),
+		RunE:  batchGetRunE,
+	}
+	get.Flags().BoolVarP(&batchCSV, "csv", "", false, "print the editors as CSV")
+	cmd.AddCommand(get)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <batch>",
+		Short: "Tear down every editor of a batch",
+		Args:  cobra.ExactArgs(1),
+		RunE:  batchDeleteRunE,
+	})
+
+	return cmd
+}
+
+func batchClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func batchCreateRunE(c *cobra.Command, args []string) error {
+	cl, err := batchClient()
+	if err != nil {
+		return err
+	}
+	if batchReq.Count == 0 {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx := context.Background()
+	b, err := cl.CreateBatch(ctx, batchReq)
+	if err != nil {
+		return err
+	}
+
+	if !batchWait && !batchCSV {
+		fmt.Printf("Provisioning %d editors in batch %s, see them with: cf batch get %s\n", b.Count, b.ID, b.ID)
+		return nil
+	}
+
+	for b.State == model.BatchStateProvisioning {
+		fmt.Fprintf(os.Stderr, "Claimed %d of %d editors\n", len(b.Editors), b.Count)
+		time.Sleep(batchPollInterval)
+
+		if b, err = cl.Batch(ctx, b.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := printBatch(ctx, cl, b); err != nil {
+		return err
+	}
+	if b.State == model.BatchStateFailed {
+		return fmt.Errorf("error: batch %s failed: %s", b.ID, b.Error)
+	}
+
+	return nil
+}
+
+func batchListRunE(c *cobra.Command, args []string) error {
+	cl, err := batchClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.Batches(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-20s %-24s %-16s %-12s %8s %-20s\n", "ID", "OWNER", "TEMPLATE", "STATE", "EDITORS", "CREATED")
+	for _, b := range resp.Batches {
+		fmt.Printf("%-20s %-24s %-16s %-12s %8s %-20s\n", b.ID, b.Owner, b.Template, b.State, fmt.Sprintf("%d/%d", len(b.Editors), b.Count), b.CreatedAt.Format("2006-01-02 15:04 MST"))
+	}
+
+	return nil
+}
+
+func batchGetRunE(c *cobra.Command, args []string) error {
+	cl, err := batchClient()
+	if err != nil {
+		return err
+	}
+
+	b, err := cl.Batch(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	return printBatch(context.Background(), cl, b)
+}
+
+func printBatch(ctx context.Context, cl *client.Client, b *model.Batch) error {
+	if batchCSV {
+		return cl.BatchCSV(ctx, b.ID, os.Stdout)
+	}
+
+	fmt.Printf("Batch %s is %s with %d of %d editors\n", b.ID, b.State, len(b.Editors), b.Count)
+	if b.Error != "" {
+		fmt.Printf("Error: %s\n", b.Error)
+	}
+	for _, ed := range b.Editors {
+		line := fmt.Sprintf("%4d %-24s %s %s", ed.Seat, ed.Name, ed.URL, ed.Token)
+		if ed.Invitee != "" {
+			line += " " + ed.Invitee
+		}
+		if ed.InviteError != "" {
+			line += " (invitation failed: " + ed.InviteError + ")"
+		}
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+func batchDeleteRunE(c *cobra.Command, args []string) error {
+	cl, err := batchClient()
+	if err != nil {
+		return err
+	}
+
+	b, err := cl.DeleteBatch(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Batch %s is torn down, %d editors are deleted\n", b.ID, len(b.Editors))
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func capabilitiesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capabilities",
+		Short: "Show the features supported by the provider of a Codeface server",
+		RunE:  capabilitiesRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func capabilitiesRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	caps, err := client.New(serverURL, herokuAPIToken).Capabilities(context.Background())
+	if err != nil {
+		return err
+	}
+
+	regions := strings.Join(caps.Regions, ", ")
+	if regions == "" {
+		regions = "-"
+	}
+
+	fmt.Printf("Provider:        %s\n", caps.Provider)
+	fmt.Printf("Regions:         %s\n", regions)
+	fmt.Printf("Persistent disk: %t\n", caps.PersistentDisk)
+	fmt.Printf("Scale to zero:   %t\n", caps.ScaleToZero)
+	fmt.Printf("Crash restarts:  %t\n", caps.CrashRestarts)
+
+	return nil
+}
@@ -3,8 +3,11 @@ package command
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/github"
+	"github.com/jingweno/codeface/model"
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
 )
@@ -13,6 +16,11 @@ var (
 	appIdentity string
 	recipient   string
 	gitRepo     string
+	gitRef      string
+	gitPath     string
+	cloneOpts   model.CloneOptions
+	claimEnv    map[string]string
+	pullRequest string
 )
 
 func claimCmd() *cobra.Command {
@@ -25,18 +33,65 @@ func claimCmd() *cobra.Command {
 	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
 	cmd.PersistentFlags().StringVarP(&appIdentity, "app", "a", "", "Heroku app identity (optional)")
 	cmd.PersistentFlags().StringVarP(&recipient, "recipient", "r", "", "recipient (required)")
-	cmd.PersistentFlags().StringVarP(&gitRepo, "git", "g", "", "Git repository (required)")
+	cmd.PersistentFlags().StringVarP(&gitRepo, "git", "g", "", "Git repository, or the default repositories of the template if empty")
+	cmd.PersistentFlags().StringVarP(&gitRef, "ref", "", "", "branch, tag or commit checked out, the default branch if empty")
+	cmd.PersistentFlags().StringVarP(&gitPath, "path", "", "", "subdirectory of the repository to open, e.g. a package of a monorepo")
+	cmd.PersistentFlags().IntVarP(&cloneOpts.Depth, "depth", "", 0, "number of commits to clone, all of them if 0")
+	cmd.PersistentFlags().BoolVarP(&cloneOpts.SingleBranch, "single-branch", "", false, "only clone the branch that is checked out")
+	cmd.PersistentFlags().StringVarP(&cloneOpts.Filter, "filter", "", "", "partial clone filter, blob:none or tree:0")
+	cmd.PersistentFlags().StringVarP(&pullRequest, "pr", "", "", "pull request to review in the format of owner/repo#123, instead of --git")
+	cmd.PersistentFlags().StringToStringVarP(&claimEnv, "env", "e", nil, "environment variables set on the editor, e.g. -e API_URL=https://example.com")
 
 	return cmd
 }
 
 func claimRunE(c *cobra.Command, args []string) error {
-	if herokuAPIToken == "" || recipient == "" || gitRepo == "" {
+	if herokuAPIToken == "" || recipient == "" {
 		return fmt.Errorf("missing required flags")
 	}
 
+	ctx := context.Background()
+	opts := editor.ClaimOptions{
+		App:       appIdentity,
+		Recipient: recipient,
+		GitRepo:   gitRepo,
+		GitRef:    gitRef,
+		Env:       claimEnv,
+	}
+
+	if cloneOpts != (model.CloneOptions{}) {
+		if err := cloneOpts.Validate(); err != nil {
+			return err
+		}
+		opts.Clone = &cloneOpts
+	}
+
+	if gitPath != "" {
+		p, err := model.CleanGitPath(gitPath)
+		if err != nil {
+			return err
+		}
+		opts.GitPath = p
+	}
+
+	if pullRequest != "" {
+		owner, repo, number, err := github.ParsePullRequest(pullRequest)
+		if err != nil {
+			return err
+		}
+
+		pr, err := github.GetPullRequest(ctx, owner, repo, number, os.Getenv("GITHUB_TOKEN"))
+		if err != nil {
+			return err
+		}
+
+		opts.GitRepo = pr.HeadRepo
+		opts.GitRef = pr.HeadRef
+		opts.GitUpstream = pr.BaseRepo
+	}
+
 	t := editor.NewClaimer(herokuAPIToken)
-	app, err := t.Claim(context.Background(), appIdentity, recipient, gitRepo)
+	app, err := t.Claim(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,119 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func credentialsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Manage the Heroku API key the server deploys editors into your account with, and your linked GitHub account",
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "save <heroku-api-key>",
+		Short: "Store a Heroku API key, e.g. one from heroku authorizations:create",
+		Args:  cobra.ExactArgs(1),
+		RunE:  credentialsSaveRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete",
+		Short: "Delete the stored Heroku API key",
+		Args:  cobra.NoArgs,
+		RunE:  credentialsDeleteRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "github <github-token>",
+		Short: "Link your GitHub account with a token, e.g. one from gh auth token, to get the git credentials and caches of your repositories",
+		Args:  cobra.ExactArgs(1),
+		RunE:  credentialsGitHubRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete-github",
+		Short: "Unlink your GitHub account",
+		Args:  cobra.NoArgs,
+		RunE:  credentialsDeleteGitHubRunE,
+	})
+
+	return cmd
+}
+
+func credentialsClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func credentialsSaveRunE(c *cobra.Command, args []string) error {
+	cl, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.SaveHerokuKey(context.Background(), args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println("Heroku API key is saved, editors are deployed into your account with it")
+
+	return nil
+}
+
+func credentialsDeleteRunE(c *cobra.Command, args []string) error {
+	cl, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.DeleteHerokuKey(context.Background()); err != nil {
+		return err
+	}
+
+	fmt.Println("Heroku API key is deleted")
+
+	return nil
+}
+
+func credentialsGitHubRunE(c *cobra.Command, args []string) error {
+	cl, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+
+	acct, err := cl.SaveGitHubToken(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("GitHub account %s is linked\n", acct.Login)
+
+	return nil
+}
+
+func credentialsDeleteGitHubRunE(c *cobra.Command, args []string) error {
+	cl, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.DeleteGitHubToken(context.Background()); err != nil {
+		return err
+	}
+
+	fmt.Println("GitHub account is unlinked")
+
+	return nil
+}
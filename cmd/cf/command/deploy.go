@@ -3,11 +3,21 @@ package command
 import (
 	"context"
 	"fmt"
+	"os"
+	"time"
 
+	heroku "github.com/heroku/heroku-go/v5"
 	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
 	"github.com/spf13/cobra"
 )
 
+var (
+	deployPreview bool
+	deployGitURL  string
+	deployGitRef  string
+)
+
 func deployCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "deploy",
@@ -17,6 +27,10 @@ func deployCmd() *cobra.Command {
 
 	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", "", "Heroku API token (required)")
 	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", "./template", "deployment template directory")
+	cmd.PersistentFlags().BoolVarP(&deployPreview, "preview", "", false, "deploy a running preview app that is not added to the pool")
+	cmd.PersistentFlags().StringVarP(&gitRepo, "git", "g", "", "Git repository cloned into the preview app")
+	cmd.PersistentFlags().StringVarP(&deployGitURL, "template-git", "", "", "GitHub repository of the template, built instead of the template directory")
+	cmd.PersistentFlags().StringVarP(&deployGitRef, "ref", "", "main", "ref of the template repository")
 
 	return cmd
 }
@@ -27,7 +41,22 @@ func deployRunE(c *cobra.Command, args []string) error {
 	}
 
 	d := editor.NewDeployer(herokuAPIToken, templateDir)
-	app, err := d.DeployEditorAndScaleDown(context.Background())
+	if deployPreview {
+		return deployPreviewApp(d)
+	}
+
+	var (
+		app *heroku.App
+		res *model.DeployResult
+		err error
+	)
+	if deployGitURL != "" {
+		d.SetGitToken(os.Getenv("GITHUB_TOKEN"))
+		app, res, err = d.DeployFromGit(context.Background(), deployGitURL, deployGitRef)
+	} else {
+		app, res, err = d.DeployEditorAndScaleDown(context.Background())
+	}
+	printDeployResult(res)
 	if err != nil {
 		return err
 	}
@@ -36,3 +65,29 @@ func deployRunE(c *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// printDeployResult prints how long the phases of a deploy took, including
+// the ones of a failed deploy up to where it failed.
+func printDeployResult(res *model.DeployResult) {
+	if res == nil {
+		return
+	}
+
+	ms := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	fmt.Fprintf(os.Stderr, "tarball %s, upload %s, build %s, release %s, readiness %s, total %s\n",
+		ms(res.Tarball), ms(res.Upload), ms(res.Build), ms(res.Release), ms(res.Readiness), ms(res.Total))
+}
+
+func deployPreviewApp(d *editor.Deployer) error {
+	app, res, err := d.DeployPreview(context.Background(), gitRepo)
+	printDeployResult(res)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Deployed preview app: %s\n", app.Name)
+	fmt.Printf("Open it at %s\n", editor.EditorAppURL(app))
+	fmt.Printf("Delete it when done with: heroku apps:destroy -a %s --confirm %s\n", app.Name, app.Name)
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	editorsTemplate string
+	editorsState    string
+	editorsOwner    string
+	editorsSort     string
+	editorsLimit    int
+	editorsAll      bool
+	editorsLabels   map[string]string
+
+	editorsOlderThan time.Duration
+	editorsDryRun    bool
+)
+
+func editorsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "editors",
+		Short: "List your claimed editors, or the pool and all claimed editors for admins",
+		Args:  cobra.NoArgs,
+		RunE:  editorsRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+	cmd.PersistentFlags().StringVarP(&editorsTemplate, "template", "", "", "only editors of the template")
+	cmd.PersistentFlags().StringVarP(&editorsState, "state", "", "", "only idle or claimed editors")
+	cmd.PersistentFlags().StringVarP(&editorsOwner, "owner", "", "", "only editors claimed by the user")
+	cmd.PersistentFlags().StringVarP(&editorsSort, "sort", "", "name", "sort by name, template or claimed, or in reverse with a leading -")
+	cmd.PersistentFlags().IntVarP(&editorsLimit, "limit", "", 100, "editors per page")
+	cmd.PersistentFlags().BoolVarP(&editorsAll, "all", "", false, "list every page instead of the first one")
+	cmd.PersistentFlags().StringToStringVarP(&editorsLabels, "label", "l", nil, "only editors claimed with the label, e.g. -l ticket=OPS-123")
+
+	del := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the claimed editors that match every filter, e.g. of an offboarded user (admin)",
+		Args:  cobra.NoArgs,
+		RunE:  editorsDeleteRunE,
+	}
+	del.Flags().DurationVarP(&editorsOlderThan, "older-than", "", 0, "only editors claimed longer ago, e.g. 24h")
+	del.Flags().BoolVarP(&editorsDryRun, "dry-run", "", false, "list the editors that would be deleted without deleting them")
+	cmd.AddCommand(del)
+
+	return cmd
+}
+
+func editorsDeleteRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	resp, err := client.New(serverURL, herokuAPIToken).DeleteEditors(context.Background(), client.DeleteEditorsOptions{
+		Owner:     editorsOwner,
+		Template:  editorsTemplate,
+		Labels:    editorsLabels,
+		OlderThan: editorsOlderThan,
+		DryRun:    editorsDryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "Deleted"
+	if resp.DryRun {
+		verb = "Would delete"
+	}
+	for _, ed := range resp.Editors {
+		fmt.Printf("%s %s of %s\n", verb, ed.Name, ed.Owner)
+	}
+	for _, f := range resp.Failed {
+		fmt.Fprintf(os.Stderr, "Fail to delete %s: %s\n", f.Name, f.Error)
+	}
+	if len(resp.Failed) > 0 {
+		return fmt.Errorf("error: %d editors are not deleted", len(resp.Failed))
+	}
+
+	return nil
+}
+
+func editorsRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	cl := client.New(serverURL, herokuAPIToken)
+	opts := client.EditorsOptions{
+		Template: editorsTemplate,
+		State:    editorsState,
+		Owner:    editorsOwner,
+		Sort:     editorsSort,
+		Limit:    editorsLimit,
+		Labels:   editorsLabels,
+	}
+
+	for {
+		resp, err := cl.Editors(context.Background(), opts)
+		if err != nil {
+			return err
+		}
+
+		for _, ed := range resp.Editors {
+			claimed := "-"
+			if ed.ClaimedAt != nil {
+				claimed = ed.ClaimedAt.Format("2006-01-02 15:04 MST")
+			}
+			owner := ed.Owner
+			if owner == "" {
+				owner = "-"
+			}
+			fmt.Printf("%-24s  %-7s  %-8s  %-12s  %-24s  %-20s  %s\n", ed.Name, ed.State, ed.Provider, ed.Template, owner, claimed, model.FormatLabels(ed.Labels))
+		}
+
+		if resp.NextCursor == "" {
+			return nil
+		}
+		if !editorsAll {
+			fmt.Fprintf(os.Stderr, "More editors are listed with --all\n")
+			return nil
+		}
+		opts.Cursor = resp.NextCursor
+	}
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var impersonationReason string
+
+func impersonateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "impersonate",
+		Short: "Act as another user to reproduce their issues (support)",
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	start := &cobra.Command{
+		Use:   "start <user>",
+		Short: "Get a time-limited token to act as a user",
+		Args:  cobra.ExactArgs(1),
+		RunE:  impersonateStartRunE,
+	}
+	start.Flags().StringVarP(&impersonationReason, "reason", "r", "", "why the user is impersonated, e.g. the support ticket (required)")
+	cmd.AddCommand(start)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List impersonations",
+		Args:  cobra.NoArgs,
+		RunE:  impersonateListRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show <impersonation>",
+		Short: "Show the requests made with an impersonation",
+		Args:  cobra.ExactArgs(1),
+		RunE:  impersonateShowRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "revoke <impersonation>",
+		Short: "End an impersonation before it expires",
+		Args:  cobra.ExactArgs(1),
+		RunE:  impersonateRevokeRunE,
+	})
+
+	return cmd
+}
+
+func impersonateClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func impersonateStartRunE(c *cobra.Command, args []string) error {
+	cl, err := impersonateClient()
+	if err != nil {
+		return err
+	}
+	if impersonationReason == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	imp, err := cl.CreateImpersonation(context.Background(), model.ImpersonationRequest{
+		User:   args[0],
+		Reason: impersonationReason,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Impersonating %s until %s, every request is logged\n", imp.User, imp.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(os.Stderr, "Use the token with e.g.: HEROKU_API_KEY=<token> cf editors\n")
+	fmt.Println(imp.Token)
+
+	return nil
+}
+
+func impersonateListRunE(c *cobra.Command, args []string) error {
+	cl, err := impersonateClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.Impersonations(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-20s %-24s %-24s %-20s %-20s %s\n", "ID", "SUPPORT", "USER", "CREATED", "ENDS", "REASON")
+	for _, imp := range resp.Impersonations {
+		ends := imp.ExpiresAt
+		if imp.RevokedAt != nil {
+			ends = *imp.RevokedAt
+		}
+		fmt.Printf("%-20s %-24s %-24s %-20s %-20s %s\n", imp.ID, imp.Support, imp.User, imp.CreatedAt.Format("2006-01-02 15:04 MST"), ends.Format("2006-01-02 15:04 MST"), imp.Reason)
+	}
+
+	return nil
+}
+
+func impersonateShowRunE(c *cobra.Command, args []string) error {
+	cl, err := impersonateClient()
+	if err != nil {
+		return err
+	}
+
+	imp, err := cl.Impersonation(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Impersonation %s of %s by %s: %s\n", imp.ID, imp.User, imp.Support, imp.Reason)
+	fmt.Printf("%-20s %-32s %-7s %s\n", "AT", "REQUEST", "METHOD", "PATH")
+	for _, req := range imp.Requests {
+		fmt.Printf("%-20s %-32s %-7s %s\n", req.At.Format("2006-01-02 15:04 MST"), req.RequestID, req.Method, req.Path)
+	}
+
+	return nil
+}
+
+func impersonateRevokeRunE(c *cobra.Command, args []string) error {
+	cl, err := impersonateClient()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.RevokeImpersonation(context.Background(), args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Impersonation %s is revoked\n", args[0])
+
+	return nil
+}
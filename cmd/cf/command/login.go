@@ -0,0 +1,198 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+// credentials are what cf login stores for the other commands, which use
+// them when no token is given.
+type credentials struct {
+	Server       string
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+	User         string
+}
+
+func loginCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in to a Codeface server with your browser",
+		Long: `Log in to a Codeface server with your browser.
+
+The token is stored in the user config directory and refreshed by the other
+commands, which use it when --token and HEROKU_API_KEY aren't set.`,
+		Args: cobra.NoArgs,
+		RunE: loginRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func logoutCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Remove the token stored by cf login",
+		Args:  cobra.NoArgs,
+		RunE:  logoutRunE,
+	}
+}
+
+func loginRunE(c *cobra.Command, args []string) error {
+	if serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx := context.Background()
+	cl := client.New(serverURL, "")
+
+	code, err := cl.DeviceCode(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Open %s?code=%s and approve the code %s\n", code.VerificationURL, code.UserCode, code.UserCode)
+
+	interval := time.Duration(code.Interval) * time.Second
+	for time.Now().Before(code.ExpiresAt) {
+		time.Sleep(interval)
+
+		tok, err := cl.DeviceToken(ctx, code.DeviceCode)
+		if err != nil {
+			return err
+		}
+		if tok.Pending {
+			continue
+		}
+
+		creds := credentials{
+			Server:       serverURL,
+			AccessToken:  tok.AccessToken,
+			RefreshToken: tok.RefreshToken,
+			Expiry:       tok.Expiry,
+			User:         tok.User,
+		}
+		if err := saveCredentials(creds); err != nil {
+			return err
+		}
+
+		fmt.Printf("Logged in as %s\n", tok.User)
+
+		return nil
+	}
+
+	return fmt.Errorf("error: code %s expired before it was approved", code.UserCode)
+}
+
+func logoutRunE(c *cobra.Command, args []string) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	fmt.Println("Logged out")
+
+	return nil
+}
+
+// useCredentials fills in the token and server of a command from the
+// credentials stored by cf login, refreshing the token if it's expired.
+func useCredentials(c *cobra.Command, args []string) error {
+	if herokuAPIToken != "" || c.Name() == "login" || c.Name() == "logout" {
+		return nil
+	}
+
+	creds, err := loadCredentials()
+	if err != nil || creds == nil {
+		return err
+	}
+	// the token is only good for the server it's from
+	if serverURL != "" && serverURL != creds.Server {
+		return nil
+	}
+
+	if !creds.Expiry.IsZero() && time.Now().After(creds.Expiry.Add(-time.Minute)) {
+		tok, err := client.New(creds.Server, "").RefreshToken(context.Background(), creds.RefreshToken)
+		if err != nil {
+			return fmt.Errorf("%s, log in again with: cf login", err)
+		}
+
+		creds.AccessToken = tok.AccessToken
+		creds.Expiry = tok.Expiry
+		if tok.RefreshToken != "" {
+			creds.RefreshToken = tok.RefreshToken
+		}
+		if err := saveCredentials(*creds); err != nil {
+			return err
+		}
+	}
+
+	herokuAPIToken = creds.AccessToken
+	serverURL = creds.Server
+
+	return nil
+}
+
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "codeface", "credentials.json"), nil
+}
+
+func loadCredentials() (*credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var creds credentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, fmt.Errorf("error: invalid credentials in %s: %s", path, err)
+	}
+
+	return &creds, nil
+}
+
+func saveCredentials(creds credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0600)
+}
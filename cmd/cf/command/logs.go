@@ -0,0 +1,74 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsDeploy string
+	logsTail   bool
+)
+
+func logsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs [editor]",
+		Short: "Show the output of a claimed editor, or of deploys",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  logsRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+	cmd.PersistentFlags().StringVarP(&logsDeploy, "deploy", "d", "", "ID of the deploy to show the output of, recent deploys are listed without it")
+	cmd.PersistentFlags().BoolVarP(&logsTail, "tail", "", false, "keep streaming new output of the editor")
+
+	return cmd
+}
+
+func logsRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	cl := client.New(serverURL, herokuAPIToken)
+
+	if len(args) == 1 {
+		return cl.RuntimeLogs(context.Background(), args[0], logsTail, os.Stdout)
+	}
+
+	if logsDeploy != "" {
+		dep, err := cl.Deploy(context.Background(), logsDeploy)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(dep.Output)
+		printDeployResult(dep.Result)
+		if dep.Error != "" {
+			fmt.Fprintf(os.Stderr, "Deploy %s failed: %s\n", dep.ID, dep.Error)
+		}
+
+		return nil
+	}
+
+	resp, err := cl.Deploys(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range resp.Deploys {
+		total := "-"
+		if dep.Result != nil && dep.FinishedAt != nil {
+			total = dep.Result.Total.Round(time.Second).String()
+		}
+		fmt.Printf("%s  %-9s  %-24s  %-12s  %s  %s\n", dep.ID, dep.Status, dep.App, dep.Template, dep.StartedAt.Format("2006-01-02 15:04 MST"), total)
+	}
+
+	return nil
+}
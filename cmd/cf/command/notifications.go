@@ -0,0 +1,52 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+// notificationsReconnectDelay is how long cf waits before reconnecting a
+// dropped notification channel.
+const notificationsReconnectDelay = 5 * time.Second
+
+func notificationsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "notifications",
+		Short: "Watch the notifications of your editors, e.g. that one is ready or about to be released",
+		Args:  cobra.NoArgs,
+		RunE:  notificationsRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func notificationsRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	cl := client.New(serverURL, herokuAPIToken)
+	var last string
+	for {
+		err := cl.Notifications(context.Background(), last, func(n model.Notification) error {
+			last = n.ID
+			line := fmt.Sprintf("%s %-16s %s", n.At.Local().Format("15:04:05"), n.Type, n.Message)
+			if n.URL != "" {
+				line += " " + n.URL
+			}
+			fmt.Println(line)
+			return nil
+		})
+		fmt.Fprintf(os.Stderr, "Notification channel dropped, reconnecting: %s\n", err)
+		time.Sleep(notificationsReconnectDelay)
+	}
+}
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serverURL      string
+	prebuildCommit string
+	prebuildPaths  []string
+)
+
+func prebuildCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prebuild",
+		Short: "Upload a dependency cache of a repository restored by new editors",
+		Long: `Upload a dependency cache of a repository restored by new editors.
+
+Run it in CI after installing dependencies, e.g. on pushes to the main branch:
+
+  cf prebuild --server https://codeface.example.com --git https://github.com/owner/repo --path node_modules`,
+		RunE: prebuildRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+	cmd.PersistentFlags().StringVarP(&gitRepo, "git", "g", "", "Git repository (required)")
+	cmd.PersistentFlags().StringVarP(&prebuildCommit, "commit", "", os.Getenv("GITHUB_SHA"), "commit the cache is built from")
+	cmd.PersistentFlags().StringSliceVarP(&prebuildPaths, "path", "p", nil, "directories to cache, relative to the repository root (required)")
+
+	return cmd
+}
+
+func prebuildRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" || gitRepo == "" || len(prebuildPaths) == 0 {
+		return fmt.Errorf("missing required flags")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := tarDirs(buf, prebuildPaths); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	cl := client.New(serverURL, herokuAPIToken)
+
+	req := model.PrebuildRequest{
+		GitRepo: gitRepo,
+		Commit:  prebuildCommit,
+	}
+	resp, err := cl.Prebuild(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Uploading %d bytes\n", buf.Len())
+	if err := upload(ctx, resp.UploadURL, buf); err != nil {
+		return err
+	}
+
+	req.Object = resp.Object
+	if err := cl.CompletePrebuild(ctx, req); err != nil {
+		return err
+	}
+
+	fmt.Printf("Prebuilt %s\n", gitRepo)
+
+	return nil
+}
+
+func upload(ctx context.Context, url string, body *bytes.Buffer) error {
+	req, err := http.NewRequest(http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("error: fail to upload cache status=%d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func tarDirs(w io.Writer, dirs []string) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(file)
+
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,66 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func recordingsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recordings [editor] [id]",
+		Short: "List the terminal recordings of editors, or get the download URL of one (admin)",
+		Args:  cobra.MaximumNArgs(2),
+		RunE:  recordingsRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func recordingsRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	cl := client.New(serverURL, herokuAPIToken)
+	if len(args) == 2 {
+		rec, err := cl.Recording(context.Background(), args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(rec.DownloadURL)
+		return nil
+	}
+
+	var editor string
+	if len(args) == 1 {
+		editor = args[0]
+	}
+
+	resp, err := cl.Recordings(context.Background(), editor)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-24s %-24s %-24s %-20s %10s\n", "EDITOR", "ID", "USER", "STARTED", "SIZE")
+	for _, rec := range resp.Recordings {
+		fmt.Printf("%-24s %-24s %-24s %-20s %10s\n", rec.Editor, rec.ID, rec.User, rec.StartedAt.Format("2006-01-02 15:04 MST"), kilobytes(rec.Bytes))
+	}
+
+	return nil
+}
+
+func kilobytes(b int64) string {
+	if b <= 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%dKB", (b+1023)/1024)
+}
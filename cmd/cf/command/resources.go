@@ -0,0 +1,67 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func resourcesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resources [editor]",
+		Short: "Show the memory and CPU usage of a claimed editor, or of all templates for admins",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  resourcesRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func resourcesRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	cl := client.New(serverURL, herokuAPIToken)
+	if len(args) == 0 {
+		resp, err := cl.TemplateResources(context.Background())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%-24s %8s %12s %12s %12s %8s %8s\n", "TEMPLATE", "EDITORS", "AVG MEM", "PEAK MEM", "LIMIT", "AVG CPU", "PEAK CPU")
+		for _, t := range resp.Templates {
+			fmt.Printf("%-24s %8d %12s %12s %12s %7.1f%% %7.1f%%\n", t.Template, t.Editors, megabytes(t.AvgMemoryBytes), megabytes(t.PeakMemoryBytes), megabytes(t.MemoryLimitBytes), t.AvgCPUPercent, t.PeakCPUPercent)
+		}
+
+		return nil
+	}
+
+	ru, err := cl.Resources(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Memory:      %s of %s (peak %s)\n", megabytes(ru.MemoryBytes), megabytes(ru.MemoryLimitBytes), megabytes(ru.PeakMemoryBytes))
+	fmt.Printf("CPU:         %.1f%% (peak %.1f%%)\n", ru.CPUPercent, ru.PeakCPUPercent)
+	fmt.Printf("Reported at: %s\n", ru.ReportedAt.Format("2006-01-02 15:04 MST"))
+	if ru.Warning != "" {
+		fmt.Printf("Warning:     %s\n", ru.Warning)
+	}
+
+	return nil
+}
+
+func megabytes(b int64) string {
+	if b <= 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%dMB", b/(1<<20))
+}
@@ -12,12 +12,36 @@ func Root() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "cf",
 		Short: "Codeface",
+		// commands without a token use the one stored by cf login
+		PersistentPreRunE: useCredentials,
 	}
 
+	rootCmd.AddCommand(activityCmd())
+	rootCmd.AddCommand(artifactsCmd())
+	rootCmd.AddCommand(batchCmd())
+	rootCmd.AddCommand(capabilitiesCmd())
 	rootCmd.AddCommand(claimCmd())
+	rootCmd.AddCommand(credentialsCmd())
 	rootCmd.AddCommand(deployCmd())
+	rootCmd.AddCommand(editorsCmd())
+	rootCmd.AddCommand(impersonateCmd())
+	rootCmd.AddCommand(loginCmd())
+	rootCmd.AddCommand(logoutCmd())
+	rootCmd.AddCommand(logsCmd())
+	rootCmd.AddCommand(notificationsCmd())
+	rootCmd.AddCommand(prebuildCmd())
+	rootCmd.AddCommand(recordingsCmd())
+	rootCmd.AddCommand(resourcesCmd())
+	rootCmd.AddCommand(resumeCmd())
+	rootCmd.AddCommand(runCmd())
 	rootCmd.AddCommand(workerCmd())
 	rootCmd.AddCommand(serverCmd())
+	rootCmd.AddCommand(settingsCmd())
+	rootCmd.AddCommand(suspendCmd())
+	rootCmd.AddCommand(templateCmd())
+	rootCmd.AddCommand(transferCmd())
+	rootCmd.AddCommand(undeleteCmd())
+	rootCmd.AddCommand(viewersCmd())
 
 	return rootCmd
 }
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var runReq model.RunRequest
+
+const (
+	runPollInterval = 5 * time.Second
+	// runFlushDelay is how long the output is streamed after the command
+	// exited, the last lines may still be on their way
+	runFlushDelay = 5 * time.Second
+)
+
+func runCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run [flags] -- <command>",
+		Short: "Run a command in a headless editor from the pool, e.g. a CI job",
+		Long: `Run a command in a headless editor from the pool instead of code-server.
+The output of the command is streamed until it exits, the editor is
+terminated then, and cf exits with the exit status of the command.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+	cmd.Flags().StringVarP(&runReq.Template, "template", "", "", "template of the editor, any if empty")
+	cmd.Flags().StringVarP(&runReq.GitRepo, "git", "g", "", "Git repository the command runs in, or the default repositories of the template if empty")
+	cmd.Flags().StringVarP(&runReq.GitRef, "ref", "", "", "branch, tag or commit checked out, the default branch if empty")
+	cmd.Flags().StringVarP(&runReq.DynoSize, "dyno-size", "", "", "dyno size of the editor, the size of the template if empty")
+	cmd.Flags().StringToStringVarP(&runReq.Env, "env", "e", nil, "environment variables set on the editor, e.g. -e CI=true")
+	cmd.Flags().StringToStringVarP(&runReq.Labels, "label", "l", nil, "labels of the claim, e.g. -l ci.run-id=1234")
+
+	return cmd
+}
+
+func runRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := client.New(serverURL, herokuAPIToken)
+	runReq.Command = strings.Join(args, " ")
+	run, err := cl.CreateRun(ctx, runReq)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Running %q in %s\n", run.Command, run.Editor)
+
+	logs := make(chan error, 1)
+	go func() {
+		logs <- cl.RuntimeLogs(ctx, run.Editor, true, os.Stdout)
+	}()
+
+	for run.FinishedAt == nil {
+		select {
+		case err := <-logs:
+			// the exit status is still polled for without the output
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Fail to stream the output: %s\n", err)
+			}
+		case <-time.After(runPollInterval):
+		}
+
+		if run, err = cl.Run(ctx, run.Editor); err != nil {
+			return err
+		}
+	}
+
+	time.Sleep(runFlushDelay)
+	cancel()
+
+	if code := *run.ExitCode; code != 0 {
+		fmt.Fprintf(os.Stderr, "Command exited with status %d\n", code)
+		os.Exit(code)
+	}
+
+	return nil
+}
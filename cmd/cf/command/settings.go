@@ -0,0 +1,102 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	settingsIdleWarnings   string
+	settingsRecycleNotices string
+)
+
+func settingsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "settings",
+		Short: "Show your settings, e.g. how you're notified about your editors",
+		Args:  cobra.NoArgs,
+		RunE:  settingsRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	deliveries := strings.Join(model.Deliveries, ", ")
+	setCmd := &cobra.Command{
+		Use:   "set",
+		Short: "Change how you're notified about your editors",
+		Args:  cobra.NoArgs,
+		RunE:  settingsSetRunE,
+	}
+	setCmd.Flags().StringVar(&settingsIdleWarnings, "idle-warnings", "", "How you're warned that an editor is idle or about to expire: "+deliveries)
+	setCmd.Flags().StringVar(&settingsRecycleNotices, "recycle-notices", "", "How you're told that an editor was released: "+deliveries)
+	cmd.AddCommand(setCmd)
+
+	return cmd
+}
+
+func settingsClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func settingsRunE(c *cobra.Command, args []string) error {
+	cl, err := settingsClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := cl.Settings(context.Background())
+	if err != nil {
+		return err
+	}
+
+	printSettings(s)
+
+	return nil
+}
+
+func settingsSetRunE(c *cobra.Command, args []string) error {
+	if settingsIdleWarnings == "" && settingsRecycleNotices == "" {
+		return fmt.Errorf("missing --idle-warnings or --recycle-notices")
+	}
+
+	cl, err := settingsClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := cl.Settings(context.Background())
+	if err != nil {
+		return err
+	}
+	if settingsIdleWarnings != "" {
+		s.Notifications.IdleWarnings = settingsIdleWarnings
+	}
+	if settingsRecycleNotices != "" {
+		s.Notifications.RecycleNotices = settingsRecycleNotices
+	}
+
+	s, err = cl.UpdateSettings(context.Background(), *s)
+	if err != nil {
+		return err
+	}
+
+	printSettings(s)
+
+	return nil
+}
+
+func printSettings(s *model.UserSettings) {
+	fmt.Printf("Idle warnings:   %s\n", s.Notifications.IdleWarnings)
+	fmt.Printf("Recycle notices: %s\n", s.Notifications.RecycleNotices)
+}
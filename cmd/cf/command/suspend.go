@@ -0,0 +1,108 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/jingweno/codeface/model"
+	"github.com/spf13/cobra"
+)
+
+func suspendCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "suspend <editor>",
+		Short: "Stop a claimed editor without releasing it",
+		Args:  cobra.ExactArgs(1),
+		RunE:  suspendRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func resumeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resume <editor>",
+		Short: "Start a suspended editor again",
+		Args:  cobra.ExactArgs(1),
+		RunE:  resumeRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func undeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "undelete <editor>",
+		Short: "Restore an editor deleted within the delete grace period",
+		Args:  cobra.ExactArgs(1),
+		RunE:  undeleteRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	return cmd
+}
+
+func suspendRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	susp, err := client.New(serverURL, herokuAPIToken).Suspend(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	if susp.State == model.SuspensionStateSnapshotting {
+		fmt.Printf("Saving the workspace of %s, it's suspended once the snapshot is uploaded\n", susp.Editor)
+		return nil
+	}
+
+	fmt.Printf("Editor %s is suspended, resume it with: cf resume %s\n", susp.Editor, susp.Editor)
+
+	return nil
+}
+
+func resumeRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	resp, err := client.New(serverURL, herokuAPIToken).Resume(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Editor %s is resumed: %s\n", args[0], resp.URL)
+
+	return nil
+}
+
+func undeleteRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" || serverURL == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	resp, err := client.New(serverURL, herokuAPIToken).Undelete(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	if resp.URL == "" {
+		fmt.Printf("Editor %s is restored and still suspended, resume it with: cf resume %s\n", args[0], args[0])
+		return nil
+	}
+
+	fmt.Printf("Editor %s is restored: %s\n", args[0], resp.URL)
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/spf13/cobra"
+)
+
+func templateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "template",
+		Short: "Work with Codeface editor templates",
+	}
+
+	cmd.PersistentFlags().StringVarP(&templateDir, "template", "", "./template", "deployment template directory")
+
+	cmd.AddCommand(templateInitCmd())
+	cmd.AddCommand(templateLintCmd())
+	cmd.AddCommand(templateTestCmd())
+	cmd.AddCommand(templateDiffCmd())
+	cmd.AddCommand(templateRenderCmd())
+	cmd.AddCommand(templateVersionCmd())
+
+	return cmd
+}
+
+func templateVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of a template that artifacts are registered with",
+		RunE:  templateVersionRunE,
+	}
+}
+
+func templateVersionRunE(c *cobra.Command, args []string) error {
+	version, err := editor.TemplateVersion(templateDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(version)
+
+	return nil
+}
+
+func templateRenderCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "render [file]",
+		Short: "Print a file of a template as it's deployed, composed with the templates it extends",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  templateRenderRunE,
+	}
+}
+
+func templateRenderRunE(c *cobra.Command, args []string) error {
+	file := "Dockerfile"
+	if len(args) == 1 {
+		file = args[0]
+	}
+
+	b, err := editor.RenderTemplateFile(templateDir, file)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(b)
+	return err
+}
+
+func templateLintCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint",
+		Short: "Check a template for problems that would fail the deploy",
+		RunE:  templateLintRunE,
+	}
+}
+
+func templateLintRunE(c *cobra.Command, args []string) error {
+	if !lintTemplate() {
+		return fmt.Errorf("error: template %s has problems", templateDir)
+	}
+
+	fmt.Printf("Template %s looks good\n", templateDir)
+
+	return nil
+}
+
+var (
+	templateTestTimeout time.Duration
+	templateTestKeep    bool
+)
+
+func templateTestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Build a template with the local docker, boot an editor from it and run its smoke tests",
+		RunE:  templateTestRunE,
+	}
+
+	cmd.Flags().DurationVarP(&templateTestTimeout, "timeout", "", 5*time.Minute, "how long the editor may take to boot")
+	cmd.Flags().BoolVarP(&templateTestKeep, "keep", "", false, "keep the editor running after the test")
+
+	return cmd
+}
+
+func templateTestRunE(c *cobra.Command, args []string) error {
+	if !lintTemplate() {
+		return fmt.Errorf("error: template %s has problems", templateDir)
+	}
+
+	fmt.Fprintf(os.Stderr, "Building and booting template %s...\n", templateDir)
+	t, err := editor.RunTemplateTest(context.Background(), templateDir, editor.TemplateTestOptions{
+		Timeout: templateTestTimeout,
+		Keep:    templateTestKeep,
+		Output:  os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range t.Results {
+		if r.Err != nil {
+			fmt.Printf("FAIL  %s: %s\n", r.Test, r.Err)
+		} else {
+			fmt.Printf("PASS  %s\n", r.Test)
+		}
+	}
+
+	if templateTestKeep {
+		fmt.Printf("Editor is running at http://%s, remove it with: docker rm -f %s\n", t.Addr, t.Container)
+	}
+
+	if !t.Passed() {
+		return fmt.Errorf("error: template %s fails its tests", templateDir)
+	}
+
+	fmt.Printf("Template %s passes its tests\n", templateDir)
+
+	return nil
+}
+
+func templateDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show what changes in the pool with the next deploy of a template",
+		RunE:  templateDiffRunE,
+	}
+
+	cmd.Flags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+
+	return cmd
+}
+
+func templateDiffRunE(c *cobra.Command, args []string) error {
+	if herokuAPIToken == "" {
+		return fmt.Errorf("missing required flags")
+	}
+
+	ctx := context.Background()
+	lintTemplate()
+
+	local, err := editor.TemplateManifest(templateDir)
+	if err != nil {
+		return err
+	}
+
+	name := editor.TemplateName(templateDir)
+	client := editor.HerokuService(herokuAPIToken)
+	currentVersion, _, err := editor.AllIdledApps(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	for _, app := range currentVersion {
+		tmpl, err := editor.AppTemplate(ctx, client, app.Name)
+		if err != nil {
+			return err
+		}
+		if tmpl != name {
+			continue
+		}
+
+		deployed, err := editor.AppManifest(ctx, client, app.Name)
+		if err != nil {
+			return err
+		}
+		if deployed == nil {
+			continue
+		}
+
+		fmt.Printf("Comparing %s with pooled app %s\n", templateDir, app.Name)
+
+		changes := deployed.Diff(local)
+		if len(changes) == 0 {
+			fmt.Println("No changes")
+			return nil
+		}
+
+		for _, ch := range changes {
+			fmt.Printf("%s %s\n", diffMarks[ch.Op], ch.Path)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("error: no pooled app of template %s has a recorded manifest", name)
+}
+
+var diffMarks = map[string]string{
+	"added":   "+",
+	"removed": "-",
+	"changed": "~",
+}
+
+// lintTemplate prints the problems of the template and reports whether
+// there are none.
+func lintTemplate() bool {
+	errs := editor.LintTemplate(templateDir)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	return len(errs) == 0
+}
+
+func templateInitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "init <stack>",
+		Short:     "Scaffold a template for a stack into the template directory",
+		Long:      fmt.Sprintf("Scaffold a template for a stack into the template directory. Stacks: %s.", strings.Join(editor.Stacks(), ", ")),
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: editor.Stacks(),
+		RunE:      templateInitRunE,
+	}
+}
+
+func templateInitRunE(c *cobra.Command, args []string) error {
+	if err := editor.InitTemplate(templateDir, args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s template in %s\n", args[0], templateDir)
+	fmt.Printf("Deploy a preview of it with: cf deploy --preview --template %s\n", templateDir)
+
+	return nil
+}
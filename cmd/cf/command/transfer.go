@@ -0,0 +1,96 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func transferCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "transfer <editor> <recipient>",
+		Short: "Transfer a claimed editor to another user",
+		Long: `Transfer a claimed editor to another user, who has to accept it with:
+
+  cf transfer accept <editor>`,
+		Args: cobra.ExactArgs(2),
+		RunE: transferRunE,
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "accept <editor>",
+		Short: "Accept the transfer of an editor",
+		Args:  cobra.ExactArgs(1),
+		RunE:  transferAcceptRunE,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "cancel <editor>",
+		Short: "Cancel or decline the transfer of an editor",
+		Args:  cobra.ExactArgs(1),
+		RunE:  transferCancelRunE,
+	})
+
+	return cmd
+}
+
+func transferClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func transferRunE(c *cobra.Command, args []string) error {
+	cl, err := transferClient()
+	if err != nil {
+		return err
+	}
+
+	t, err := cl.Transfer(context.Background(), args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Started transfer of %s to %s, it expires at %s\n", t.Editor, t.To, t.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	fmt.Printf("Ask %s to run: cf transfer accept %s\n", t.To, t.Editor)
+
+	return nil
+}
+
+func transferAcceptRunE(c *cobra.Command, args []string) error {
+	cl, err := transferClient()
+	if err != nil {
+		return err
+	}
+
+	t, err := cl.AcceptTransfer(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Editor %s is transferred from %s to you\n", t.Editor, t.From)
+
+	return nil
+}
+
+func transferCancelRunE(c *cobra.Command, args []string) error {
+	cl, err := transferClient()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.CancelTransfer(context.Background(), args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Transfer of %s is canceled\n", args[0])
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jingweno/codeface/client"
+	"github.com/spf13/cobra"
+)
+
+func viewersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "viewers",
+		Short: "Share editors read-only with viewer links, e.g. for demos",
+	}
+
+	cmd.PersistentFlags().StringVarP(&herokuAPIToken, "token", "t", os.Getenv("HEROKU_API_KEY"), "Heroku API token (required)")
+	cmd.PersistentFlags().StringVarP(&serverURL, "server", "s", os.Getenv("CODEFACE_SERVER"), "Codeface server URL (required)")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "share <editor>",
+		Short: "Create a link to watch an editor read-only without logging in",
+		Args:  cobra.ExactArgs(1),
+		RunE:  viewersShareRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <editor>",
+		Short: "List the viewer links of an editor",
+		Args:  cobra.ExactArgs(1),
+		RunE:  viewersListRunE,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "revoke <editor> <link>",
+		Short: "End a viewer link before it expires",
+		Args:  cobra.ExactArgs(2),
+		RunE:  viewersRevokeRunE,
+	})
+
+	return cmd
+}
+
+func viewersClient() (*client.Client, error) {
+	if herokuAPIToken == "" || serverURL == "" {
+		return nil, fmt.Errorf("missing required flags")
+	}
+
+	return client.New(serverURL, herokuAPIToken), nil
+}
+
+func viewersShareRunE(c *cobra.Command, args []string) error {
+	cl, err := viewersClient()
+	if err != nil {
+		return err
+	}
+
+	link, err := cl.CreateViewerLink(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Anyone with the link can watch %s read-only until %s\n", link.Editor, link.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	fmt.Println(link.URL)
+
+	return nil
+}
+
+func viewersListRunE(c *cobra.Command, args []string) error {
+	cl, err := viewersClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := cl.ViewerLinks(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-20s %-24s %-20s %s\n", "ID", "CREATED BY", "CREATED", "EXPIRES")
+	for _, link := range resp.ViewerLinks {
+		fmt.Printf("%-20s %-24s %-20s %s\n", link.ID, link.CreatedBy, link.CreatedAt.Format("2006-01-02 15:04 MST"), link.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	}
+
+	return nil
+}
+
+func viewersRevokeRunE(c *cobra.Command, args []string) error {
+	cl, err := viewersClient()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.RevokeViewerLink(context.Background(), args[0], args[1]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Viewer link %s of %s is revoked\n", args[1], args[0])
+
+	return nil
+}
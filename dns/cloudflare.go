@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const cloudflareAPIURL = "https://api.cloudflare.com/client/v4/"
+
+// Cloudflare manages the CNAME records of vanity editor hosts in a zone
+// with the Cloudflare API. The token needs the Zone.DNS edit permission of
+// the zone.
+type Cloudflare struct {
+	Token  string
+	ZoneID string
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	// TTL 1 is automatic
+	TTL int `json:"ttl"`
+	// Proxied is off, Heroku has to see the requests to issue the
+	// certificate of the host
+	Proxied bool `json:"proxied"`
+}
+
+// Upsert points host at target with a CNAME record, replacing the record
+// of an earlier editor of the host.
+func (c *Cloudflare) Upsert(ctx context.Context, host, target string) error {
+	rec := cloudflareRecord{Type: "CNAME", Name: host, Content: target, TTL: 1}
+
+	existing, err := c.record(ctx, host)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return c.do(ctx, http.MethodPost, "dns_records", rec, nil)
+	}
+	if existing.Content == target {
+		return nil
+	}
+
+	return c.do(ctx, http.MethodPut, "dns_records/"+existing.ID, rec, nil)
+}
+
+// Delete deletes the CNAME record of host, it's a no-op if there's none.
+func (c *Cloudflare) Delete(ctx context.Context, host string) error {
+	existing, err := c.record(ctx, host)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodDelete, "dns_records/"+existing.ID, nil, nil)
+}
+
+func (c *Cloudflare) record(ctx context.Context, host string) (*cloudflareRecord, error) {
+	q := url.Values{}
+	q.Set("type", "CNAME")
+	q.Set("name", host)
+
+	var records []cloudflareRecord
+	if err := c.do(ctx, http.MethodGet, "dns_records?"+q.Encode(), nil, &records); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	return &records[0], nil
+}
+
+// do calls an endpoint of the zone, whose response wraps the result with
+// success and errors.
+func (c *Cloudflare) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, cloudflareAPIURL+"zones/"+url.PathEscape(c.ZoneID)+"/"+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(b, &status); err != nil {
+		return fmt.Errorf("error: Cloudflare API %s %s status=%d body=%s", method, path, resp.StatusCode, b)
+	}
+	if !status.Success {
+		msgs := make([]string, 0, len(status.Errors))
+		for _, e := range status.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("error: Cloudflare API %s %s status=%d: %s", method, path, resp.StatusCode, strings.Join(msgs, "; "))
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(status.Result, v)
+}
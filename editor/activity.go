@@ -0,0 +1,42 @@
+package editor
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// ActivityKey is the key of the last request served by an editor, which is
+// recorded from the router logs drained to the server.
+func ActivityKey(appName string) string {
+	return "activity/" + appName
+}
+
+// IdleWarningKey is the key of the warning shown in an editor before it's
+// released.
+func IdleWarningKey(appName string) string {
+	return "idlewarnings/" + appName
+}
+
+// SetRouterDrain adds a log drain to the apps deployed from now on, which
+// the router logs of editors are sent to.
+func (d *Deployer) SetRouterDrain(url string) {
+	d.routerDrain = url
+}
+
+// LastRequest returns when an editor last served a request, or the zero
+// time if it served none since it was created.
+func LastRequest(ctx context.Context, st store.Store, appName string) (time.Time, error) {
+	var a model.Activity
+	err := st.Get(ctx, ActivityKey(appName), &a)
+	if err == store.ErrNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return a.LastRequestAt, nil
+}
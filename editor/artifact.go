@@ -0,0 +1,390 @@
+package editor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const artifactConfigVar = "CF_ARTIFACT"
+
+// TemplateVersion returns the digest of the manifest of a template
+// directory, which changes with any of its rendered files.
+func TemplateVersion(dir string) (string, error) {
+	m, err := TemplateManifest(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// maps are marshaled with sorted keys
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:12], nil
+}
+
+// AppArtifact returns the version of the artifact an app is promoted from,
+// or an empty string if it was built on its own.
+func AppArtifact(ctx context.Context, client *heroku.Service, appIdentity string) (string, error) {
+	vars, err := client.ConfigVarInfoForApp(ctx, appIdentity)
+	if err != nil {
+		return "", err
+	}
+
+	if v := vars[artifactConfigVar]; v != nil {
+		return *v, nil
+	}
+
+	return "", nil
+}
+
+// newArtifact returns an artifact of the template on disk.
+func (d *Deployer) newArtifact(kind string) (*model.Artifact, error) {
+	version, err := TemplateVersion(d.templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := TemplateManifest(d.templateDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	stack, err := TemplateStack(d.templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Artifact{
+		Template:  TemplateName(d.templateDir),
+		Version:   version,
+		Kind:      kind,
+		Stack:     stack,
+		Manifest:  string(manifest),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// BuildArtifact builds the template once on a builder app that is coupled
+// to the staging stage of the pipeline of the template. Pool editors are
+// then promoted from it with DeployFromArtifact.
+func (d *Deployer) BuildArtifact(ctx context.Context) (*model.Artifact, error) {
+	art, err := d.newArtifact(artifact.Heroku)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline, err := d.pipeline(ctx, art.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	d.logger.Infof("Getting account")
+	acct, err := Account(ctx, d.heroku)
+	if err != nil {
+		return nil, err
+	}
+
+	d.logger.Infof("Creating builder app")
+	cfApp, err := d.createCFApp(ctx, acct, genBuilderAppName())
+	if err != nil {
+		return nil, err
+	}
+
+	dep := d.startDeploy(ctx, cfApp, art.Template, model.DeployKindArtifact)
+	logger := d.logger.WithFields(log.Fields{"app": cfApp.Name, "deploy": dep.ID, "version": art.Version})
+
+	// make sure failed app is cleaned up if there is any error
+	defer func() {
+		if err != nil {
+			logger.Info("Error building artifact, cleaning up")
+			DeleteApp(d.heroku, cfApp, d.logger)
+		}
+	}()
+
+	defer func() {
+		d.finishDeploy(dep, cfApp, err)
+	}()
+
+	logger.Infof("Coupling builder app")
+	if _, err = d.heroku.PipelineCouplingCreate(ctx, heroku.PipelineCouplingCreateOpts{
+		App:      cfApp.ID,
+		Pipeline: pipeline.ID,
+		Stage:    "staging",
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = d.buildAndScaleDown(ctx, cfApp, logger, dep); err != nil {
+		return nil, err
+	}
+
+	releases, err := d.heroku.ReleaseList(ctx, cfApp.Name, &heroku.ListRange{
+		Field:      "version",
+		Max:        1,
+		Descending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		err = fmt.Errorf("error: builder app %s has no release", cfApp.Name)
+		return nil, err
+	}
+
+	art.Ref = cfApp.Name
+	art.Pipeline = pipeline.ID
+	art.Release = releases[0].ID
+
+	logger.Info("Built artifact")
+
+	return art, nil
+}
+
+// SourceArtifact renders the template on disk into a source tarball, which
+// pool editors are built from with DeployFromSource.
+func (d *Deployer) SourceArtifact() (*model.Artifact, []byte, error) {
+	art, err := d.newArtifact(artifact.S3)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := TemplateData(d.templateDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := compress(d.templateDir, buf, data); err != nil {
+		return nil, nil, err
+	}
+
+	return art, buf.Bytes(), nil
+}
+
+// DeployFromSource creates an idle editor by building the source tarball
+// of an artifact, which is downloaded from sourceURL.
+func (d *Deployer) DeployFromSource(ctx context.Context, art *model.Artifact, sourceURL string) (*heroku.App, *model.DeployResult, error) {
+	d.logger.Infof("Getting account")
+	acct, err := Account(ctx, d.heroku)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.logger.Infof("Creating cf app")
+	cfApp, err := d.createCFApp(ctx, acct, genBuildingAppName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dep := d.startDeploy(ctx, cfApp, art.Template, model.DeployKindPool)
+	logger := d.logger.WithFields(log.Fields{"app": cfApp.Name, "deploy": dep.ID, "version": art.Version})
+
+	// make sure failed app is cleaned up if there is any error
+	defer func() {
+		if err != nil {
+			logger.Info("Error building artifact, cleaning up")
+			d.discardApp(dep, cfApp, err, logger)
+		}
+	}()
+
+	defer func() {
+		d.finishDeploy(dep, cfApp, err)
+	}()
+
+	logger.Infof("Tagging artifact")
+	if err = d.tagArtifact(ctx, cfApp, art); err != nil {
+		return nil, dep.Result, err
+	}
+
+	// a tarball swapped in the bucket fails the build
+	if err = d.buildSource(ctx, cfApp, sourceURL, art.Digest, logger, dep); err != nil {
+		return nil, dep.Result, err
+	}
+
+	defer dep.timed(&dep.Result.Readiness)()
+	logger.Infof("Scaling down app")
+	if err = d.scaleDownApp(ctx, cfApp.Name); err != nil {
+		return nil, dep.Result, err
+	}
+
+	logger.Infof("Marking app as idled")
+	cfApp, err = d.markAppAsIdled(ctx, cfApp)
+
+	return cfApp, dep.Result, err
+}
+
+// tagArtifact tags an app with the template of an artifact, which may be
+// of another version of the template than the one on disk, e.g. after a
+// rollback.
+func (d *Deployer) tagArtifact(ctx context.Context, cfApp *heroku.App, art *model.Artifact) error {
+	_, err := d.heroku.ConfigVarUpdate(ctx, cfApp.Name, d.withEnvironment(map[string]*string{
+		templateConfigVar:         &art.Template,
+		templateManifestConfigVar: &art.Manifest,
+		stackConfigVar:            &art.Stack,
+		artifactConfigVar:         &art.Version,
+	}))
+	return err
+}
+
+// DeployFromArtifact creates an idle editor by promoting the release of the
+// builder app of an artifact, which takes seconds instead of a build.
+func (d *Deployer) DeployFromArtifact(ctx context.Context, art *model.Artifact) (*heroku.App, *model.DeployResult, error) {
+	d.logger.Infof("Getting account")
+	acct, err := Account(ctx, d.heroku)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.logger.Infof("Creating cf app")
+	cfApp, err := d.createCFApp(ctx, acct, genBuildingAppName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dep := d.startDeploy(ctx, cfApp, art.Template, model.DeployKindPromotion)
+	logger := d.logger.WithFields(log.Fields{"app": cfApp.Name, "deploy": dep.ID, "version": art.Version})
+
+	// make sure failed app is cleaned up if there is any error
+	defer func() {
+		if err != nil {
+			logger.Info("Error promoting artifact, cleaning up")
+			d.discardApp(dep, cfApp, err, logger)
+		}
+	}()
+
+	defer func() {
+		d.finishDeploy(dep, cfApp, err)
+	}()
+
+	logger.Infof("Tagging artifact")
+	if err = d.tagArtifact(ctx, cfApp, art); err != nil {
+		return nil, dep.Result, err
+	}
+
+	logger.Infof("Coupling app")
+	if _, err = d.heroku.PipelineCouplingCreate(ctx, heroku.PipelineCouplingCreateOpts{
+		App:      cfApp.ID,
+		Pipeline: art.Pipeline,
+		Stage:    "production",
+	}); err != nil {
+		return nil, dep.Result, err
+	}
+
+	fmt.Fprintf(&dep.log, "Promoting %s of %s from %s\n", art.Version, art.Template, art.Ref)
+	promoted := dep.timed(&dep.Result.Release)
+	if err = d.promote(ctx, art, cfApp, logger); err != nil {
+		return nil, dep.Result, err
+	}
+	promoted()
+
+	defer dep.timed(&dep.Result.Readiness)()
+	logger.Infof("Scaling down app")
+	if err = d.scaleDownApp(ctx, cfApp.Name); err != nil {
+		return nil, dep.Result, err
+	}
+
+	logger.Infof("Marking app as idled")
+	cfApp, err = d.markAppAsIdled(ctx, cfApp)
+
+	return cfApp, dep.Result, err
+}
+
+func (d *Deployer) promote(ctx context.Context, art *model.Artifact, cfApp *heroku.App, logger log.FieldLogger) error {
+	builder, err := d.app(ctx, art.Ref)
+	if err != nil {
+		return err
+	}
+
+	// the current release of the builder app is promoted, which has to be
+	// the one the artifact was built into
+	releases, err := d.heroku.ReleaseList(ctx, builder.Name, &heroku.ListRange{
+		Field:      "version",
+		Max:        1,
+		Descending: true,
+	})
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 || releases[0].ID != art.Release {
+		return fmt.Errorf("error: builder app %s was released since artifact %s was built", builder.Name, art.Version)
+	}
+
+	opts := heroku.PipelinePromotionCreateOpts{}
+	opts.Pipeline.ID = art.Pipeline
+	opts.Source.App = &struct {
+		ID *string `json:"id,omitempty" url:"id,omitempty,key"`
+	}{ID: &builder.ID}
+	opts.Targets = []struct {
+		App *struct {
+			ID *string `json:"id,omitempty" url:"id,omitempty,key"`
+		} `json:"app,omitempty" url:"app,omitempty,key"`
+	}{{App: &struct {
+		ID *string `json:"id,omitempty" url:"id,omitempty,key"`
+	}{ID: &cfApp.ID}}}
+
+	promotion, err := d.heroku.PipelinePromotionCreate(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			targets, err := d.heroku.PipelinePromotionTargetList(ctx, promotion.ID, nil)
+			if err != nil {
+				continue
+			}
+
+			for _, t := range targets {
+				if t.App.ID != cfApp.ID {
+					continue
+				}
+
+				logger.WithField("promotion-status", t.Status).Info("Waiting for promotion")
+				switch t.Status {
+				case "succeeded":
+					return nil
+				case "failed":
+					msg := "unknown error"
+					if t.ErrorMessage != nil {
+						msg = *t.ErrorMessage
+					}
+					return fmt.Errorf("error: fail to promote %s to %s: %s", art.Ref, cfApp.Name, msg)
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pipeline returns the pipeline of a template, creating it if needed.
+func (d *Deployer) pipeline(ctx context.Context, template string) (*heroku.Pipeline, error) {
+	name := "cf-" + template
+	if p, err := d.heroku.PipelineInfo(ctx, name); err == nil {
+		return p, nil
+	}
+
+	d.logger.WithField("pipeline", name).Info("Creating pipeline")
+	return d.heroku.PipelineCreate(ctx, heroku.PipelineCreateOpts{Name: name})
+}
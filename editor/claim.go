@@ -2,18 +2,31 @@ package editor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/model"
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrNoIdleApp is returned by claims when the pool has no editor to take.
+var ErrNoIdleApp = fmt.Errorf("error: no qualified app is found in the pool")
+
+// claimRetries is how many more times the pool is listed when concurrent
+// claims took every idle app that qualified.
+const claimRetries = 3
+
 func NewClaimer(accessToken string) *Claimer {
 	client := &http.Client{
 		Transport: &heroku.Transport{
 			BearerToken: accessToken,
+			Transport:   &metrics.Transport{},
 		},
 	}
 
@@ -28,9 +41,188 @@ type Claimer struct {
 	heroku      *heroku.Service
 	logger      log.FieldLogger
 	accessToken string
+	hooks       ClaimHooks
+	envPolicy   EnvPolicy
+}
+
+type ClaimOptions struct {
+	// App is the app to claim, or empty to take one from the pool
+	App string
+	// Template is the template of the editor taken from the pool, or empty
+	// for any. ReservedTemplates are never taken, e.g. the pool of guest
+	// editors for the claims of users
+	Template          string
+	ReservedTemplates []string
+	Recipient         string
+	// RecipientToken is a Heroku token of the recipient, who accepts the
+	// transfer of the app with it. The pool account auto-accepts it if
+	// empty.
+	RecipientToken string
+	GitRepo        string
+	// GitRef is the branch, tag or commit checked out after cloning
+	GitRef string
+	// GitPath is the subdirectory the editor opens, which is checked out
+	// sparsely
+	GitPath string
+	// Clone overrides the clone options of the template
+	Clone *model.CloneOptions
+	// GitUpstream is added as the upstream remote, e.g. the base
+	// repository of a pull request opened from a fork
+	GitUpstream string
+	// GitSSHKey is a deploy key for cloning over SSH
+	GitSSHKey string
+	// GitUsername and GitPassword are what git of the editor authenticates
+	// to the host of GitRepo with over HTTPS, through the credential helper
+	// of the agent. GitRepo never holds them.
+	GitUsername string
+	GitPassword string
+	// CacheURL is a tarball of dependencies extracted into the cloned repo
+	CacheURL string
+	// DepsCacheURL and DepsCacheUploadURL are the download and upload URLs
+	// of the dependency cache of the repo, which the editor restores before
+	// installing dependencies and uploads when it's released
+	DepsCacheURL       string
+	DepsCacheUploadURL string
+	// Env is set on the editor before it's scaled up
+	Env map[string]string
+	// DynoSize is the dyno size the editor is scaled up with, or the size
+	// it's deployed with if empty
+	DynoSize string
+	// Secrets are set on the editor like Env, but they're looked up by the
+	// server and aren't subject to the env policy
+	Secrets map[string]string
+	// IPAllowList are the CIDRs the editor proxy accepts requests from
+	IPAllowList []string
+	// EgressDeny are the CIDRs the editor proxy refuses to connect to
+	EgressDeny []string
+	// TrustedHops is the number of proxies in front of the editor, e.g.
+	// the Heroku router, whose X-Forwarded-For entries are trusted
+	TrustedHops int
+	// ServerURL and AgentToken are what the agent of the editor reports to
+	// the server with
+	ServerURL  string
+	AgentToken string
+	// ViewerKey authenticates the requests of viewer links, which the
+	// editor proxy serves from a read-only code-server
+	ViewerKey string
+	// ExtensionGallery is the EXTENSIONS_GALLERY code-server installs
+	// extensions from, e.g. the extension registry of the server
+	ExtensionGallery string
+	// Command is run by a headless editor instead of code-server, its agent
+	// reports the exit status with AgentToken
+	Command string
+	// CanaryRepo is cloned by the canary claims of the worker before
+	// code-server starts, so that editors that can't clone never serve
+	CanaryRepo string
+}
+
+// Takes returns whether an idle editor of a template may be taken from the
+// pool for the claim.
+func (o ClaimOptions) Takes(template string) bool {
+	if template == "" {
+		template = DefaultTemplate
+	}
+
+	for _, t := range o.ReservedTemplates {
+		if t == template {
+			return false
+		}
+	}
+
+	return o.Template == "" || template == o.Template
+}
+
+// anyTemplate returns whether any idle editor may be taken for the claim,
+// so that their templates don't need to be looked up.
+func (o ClaimOptions) anyTemplate() bool {
+	return o.Template == "" && len(o.ReservedTemplates) == 0
+}
+
+// ConfigVars returns the environment of a claimed editor.
+func (o ClaimOptions) ConfigVars() map[string]string {
+	vars := map[string]string{
+		"GIT_REPO": o.GitRepo,
+	}
+	if o.GitRef != "" {
+		vars["GIT_REF"] = o.GitRef
+	}
+	if o.GitPath != "" {
+		vars["GIT_PATH"] = o.GitPath
+	}
+	if o.GitUpstream != "" {
+		vars["GIT_UPSTREAM"] = o.GitUpstream
+	}
+	if o.Clone != nil {
+		if o.Clone.Depth > 0 {
+			vars["CF_GIT_DEPTH"] = strconv.Itoa(o.Clone.Depth)
+		}
+		if o.Clone.SingleBranch {
+			vars["CF_GIT_SINGLE_BRANCH"] = "true"
+		}
+		if o.Clone.Filter != "" {
+			vars["CF_GIT_FILTER"] = o.Clone.Filter
+		}
+	}
+	if o.GitSSHKey != "" {
+		vars["CF_GIT_SSH_KEY"] = o.GitSSHKey
+	}
+	if o.GitPassword != "" {
+		vars["CF_GIT_USERNAME"] = o.GitUsername
+		vars["CF_GIT_PASSWORD"] = o.GitPassword
+	}
+	if o.CacheURL != "" {
+		vars["CF_CACHE_URL"] = o.CacheURL
+	}
+	if o.DepsCacheURL != "" {
+		vars["CF_DEPS_CACHE_URL"] = o.DepsCacheURL
+		vars["CF_DEPS_CACHE_UPLOAD_URL"] = o.DepsCacheUploadURL
+	}
+	for k, v := range o.Env {
+		vars[k] = v
+	}
+	for k, v := range o.Secrets {
+		vars[k] = v
+	}
+	// set after Env so that the network policy and the agent token can't be
+	// overridden
+	if len(o.IPAllowList) > 0 {
+		vars["CF_IP_ALLOWLIST"] = strings.Join(o.IPAllowList, ",")
+	}
+	if len(o.EgressDeny) > 0 {
+		vars["CF_EGRESS_DENY"] = strings.Join(o.EgressDeny, ",")
+	}
+	if o.TrustedHops > 0 {
+		vars["CF_TRUSTED_HOPS"] = strconv.Itoa(o.TrustedHops)
+	}
+	if o.AgentToken != "" {
+		vars["CF_SERVER_URL"] = o.ServerURL
+		vars["CF_AGENT_TOKEN"] = o.AgentToken
+	}
+	if o.ViewerKey != "" {
+		vars["CF_VIEWER_KEY"] = o.ViewerKey
+	}
+	if o.ExtensionGallery != "" {
+		vars["EXTENSIONS_GALLERY"] = o.ExtensionGallery
+	}
+	if o.Command != "" {
+		vars["CF_COMMAND"] = o.Command
+	}
+	if o.CanaryRepo != "" {
+		vars["CF_CANARY_REPO"] = o.CanaryRepo
+	}
+	if o.Recipient != "" {
+		vars[claimedByConfigVar] = o.Recipient
+	}
+
+	return vars
+}
+
+func (t *Claimer) SetEnvPolicy(p EnvPolicy) {
+	t.envPolicy = p
 }
 
-func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo string) (*heroku.App, error) {
+func (t *Claimer) Claim(ctx context.Context, opts ClaimOptions) (*heroku.App, error) {
+	appIdentity, recipient, gitRepo := opts.App, opts.Recipient, opts.GitRepo
 	logger := t.logger.WithFields(log.Fields{"app": appIdentity, "recipient": recipient})
 
 	var (
@@ -38,9 +230,21 @@ func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo str
 		err error
 	)
 
+	if err := t.envPolicy.Validate(opts.Env); err != nil {
+		return nil, err
+	}
+
+	if err := t.hooks.PreClaim.Run(ctx, PreClaimEvent, HookPayload{
+		App:       appIdentity,
+		Recipient: recipient,
+		GitRepo:   gitRepo,
+	}, t.logger); err != nil {
+		return nil, err
+	}
+
 	if appIdentity == "" {
 		logger.Info("Taking one app from the pool")
-		app, err = t.findOneIdledApp(ctx)
+		app, err = claimIdleApp(ctx, t.heroku, opts, logger)
 		if err != nil {
 			return app, err
 		}
@@ -52,8 +256,6 @@ func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo str
 		}
 	}
 
-	logger.WithField("app", app.Name).Infof("Marking app as claimed")
-
 	defer func() {
 		if r := recover(); r != nil {
 			if app != nil {
@@ -74,26 +276,39 @@ func (t *Claimer) Claim(ctx context.Context, appIdentity, recipient, gitRepo str
 		}
 	}()
 
-	app, err = t.markAppAsClaimed(ctx, app)
+	if opts.App != "" {
+		logger.WithField("app", app.Name).Infof("Marking app as claimed")
+		app, err = t.markAppAsClaimed(ctx, app)
+		if err != nil {
+			return app, err
+		}
+	}
+
+	err = t.transferOwnership(ctx, app, opts)
 	if err != nil {
 		return app, err
 	}
 
-	err = t.transferOwnership(ctx, app, recipient, gitRepo)
+	err = t.hooks.PostClaim.Run(ctx, PostClaimEvent, HookPayload{
+		App:       app.Name,
+		Recipient: recipient,
+		GitRepo:   gitRepo,
+	}, t.logger)
 
 	return app, err
 }
 
-func (t *Claimer) transferOwnership(ctx context.Context, app *heroku.App, recipient, gitRepo string) error {
+func (t *Claimer) transferOwnership(ctx context.Context, app *heroku.App, opts ClaimOptions) error {
 	logger := t.logger.WithField("app", app.Name)
+	recipient := opts.Recipient
 
-	logger.Infof("Adding Git repo")
-	if err := t.addGitRepo(ctx, app.Name, gitRepo); err != nil {
+	logger.Infof("Setting config vars")
+	if err := t.setConfigVars(ctx, app.Name, opts); err != nil {
 		return err
 	}
 
 	logger.Infof("Scaling up app")
-	if err := t.scaleUpApp(ctx, app.Name); err != nil {
+	if err := t.scaleUpApp(ctx, app.Name, opts.DynoSize); err != nil {
 		return err
 	}
 
@@ -115,28 +330,115 @@ func (t *Claimer) transferOwnership(ctx context.Context, app *heroku.App, recipi
 		return err
 	}
 
+	// the pool account stays on as a collaborator so that the worker
+	// can keep monitoring the editor after it's claimed
 	logger = logger.WithField("transfer", tr.ID)
 	logger.Infof("Accepting transfer")
-	if err := t.acceptTransfer(ctx, tr.ID); err != nil {
-		return err
-	}
-
-	logger.Infof("Removing owner")
-	return t.removeOwner(ctx, app.Name, tr.Owner.ID)
+	return t.acceptTransfer(ctx, tr.ID, opts.RecipientToken)
 }
 
-func (t *Claimer) findOneIdledApp(ctx context.Context) (*heroku.App, error) {
-	currentVersion, otherVersion, err := AllIdledApps(ctx, t.heroku)
+// Claim takes an idle app of a template from the pool, or of any template
+// if empty, and marks it claimed by owner. An app is marked by renaming it,
+// which only one of the claims of the same app wins, so that replicas of
+// the server never hand out the same editor. The claims that lose move on
+// to the next idle app, and list the pool again if there's none left.
+func Claim(ctx context.Context, client *heroku.Service, template, owner string) (*heroku.App, error) {
+	logger := log.New().WithFields(log.Fields{"com": "claimer", "recipient": owner})
+	app, err := claimIdleApp(ctx, client, ClaimOptions{Template: template}, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	apps := append(currentVersion, otherVersion...)
+	if _, err := client.ConfigVarUpdate(ctx, app.Name, map[string]*string{claimedByConfigVar: &owner}); err != nil {
+		DeleteApp(client, app, logger)
+		return nil, err
+	}
+
+	return app, nil
+}
+
+func claimIdleApp(ctx context.Context, client *heroku.Service, opts ClaimOptions, logger log.FieldLogger) (*heroku.App, error) {
+	for i := 0; i <= claimRetries; i++ {
+		if i > 0 {
+			// jittered so that the claims that collided don't collide again
+			wait := time.Duration(i)*100*time.Millisecond + time.Duration(time.Now().UnixNano()%int64(100*time.Millisecond))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		currentVersion, otherVersion, err := AllIdledApps(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+
+		// concurrent claims start at different apps, current ones first
+		apps := append(rotate(currentVersion), rotate(otherVersion)...)
+		lost := false
+		for j := range apps {
+			if !opts.anyTemplate() {
+				tmpl, err := AppTemplate(ctx, client, apps[j].Name)
+				if err != nil {
+					logger.WithError(err).WithField("app", apps[j].Name).Info("Fail to get app template")
+					continue
+				}
+				if !opts.Takes(tmpl) {
+					continue
+				}
+			}
+
+			logger.WithField("app", apps[j].Name).Infof("Marking app as claimed")
+			app, err := markClaimed(ctx, client, &apps[j])
+			if err == nil {
+				return app, nil
+			}
+			if !lostClaim(err) {
+				return nil, err
+			}
+
+			logger.WithField("app", apps[j].Name).Info("App was taken by another claim, trying the next one")
+			lost = true
+		}
+
+		if !lost {
+			break
+		}
+	}
+
+	return nil, ErrNoIdleApp
+}
+
+// markClaimed renames an idle app as claimed. It fails with a 404 if
+// another claim renamed it first.
+func markClaimed(ctx context.Context, client *heroku.Service, app *heroku.App) (*heroku.App, error) {
+	name, ok := ClaimedAppName(app.Name)
+	if !ok {
+		return nil, fmt.Errorf("error: app %s is not idle", app.Name)
+	}
+
+	return client.AppUpdate(ctx, app.Name, heroku.AppUpdateOpts{Name: &name})
+}
+
+// lostClaim returns whether marking an app failed because another claim
+// marked it first, after which the app is gone or its new name is taken.
+func lostClaim(err error) bool {
+	var herr heroku.Error
+	if errors.As(err, &herr) {
+		return herr.StatusCode == http.StatusNotFound || herr.StatusCode == http.StatusUnprocessableEntity
+	}
+
+	return false
+}
+
+func rotate(apps []heroku.App) []heroku.App {
 	if len(apps) == 0 {
-		return nil, fmt.Errorf("error: no qualified app is found in the pool")
+		return apps
 	}
 
-	return &apps[0], nil
+	n := int(time.Now().UnixNano() % int64(len(apps)))
+	return append(append([]heroku.App{}, apps[n:]...), apps[:n]...)
 }
 
 func (t *Claimer) app(ctx context.Context, appIdentity string) (*heroku.App, error) {
@@ -161,17 +463,26 @@ func (t *Claimer) markAppAsClaimed(ctx context.Context, app *heroku.App) (*herok
 	return app, nil
 }
 
-func (t *Claimer) addGitRepo(ctx context.Context, appIdentity, gitRepo string) error {
-	_, err := t.heroku.ConfigVarUpdate(ctx, appIdentity, map[string]*string{
-		"GIT_REPO": &gitRepo,
-	})
+func (t *Claimer) setConfigVars(ctx context.Context, appIdentity string, opts ClaimOptions) error {
+	vars := make(map[string]*string)
+	for k, v := range opts.ConfigVars() {
+		v := v
+		vars[k] = &v
+	}
+
+	_, err := t.heroku.ConfigVarUpdate(ctx, appIdentity, vars)
 	return err
 }
 
-func (t *Claimer) scaleUpApp(ctx context.Context, appIdentity string) error {
+func (t *Claimer) scaleUpApp(ctx context.Context, appIdentity, size string) error {
+	if size == "" {
+		return ScaleApp(ctx, t.heroku, appIdentity, 1)
+	}
+
 	qty := 1
 	_, err := t.heroku.FormationUpdate(ctx, appIdentity, "web", heroku.FormationUpdateOpts{
 		Quantity: &qty,
+		Size:     &size,
 	})
 	return err
 }
@@ -185,11 +496,6 @@ func (t *Claimer) addCollaborator(ctx context.Context, appIdentity, recipient st
 	return err
 }
 
-func (t *Claimer) removeOwner(ctx context.Context, appIdentity, owner string) error {
-	_, err := t.heroku.CollaboratorDelete(ctx, appIdentity, owner)
-	return err
-}
-
 func (t *Claimer) transferApp(ctx context.Context, appIdentity, recipient string) (*heroku.AppTransfer, error) {
 	silent := true
 	return t.heroku.AppTransferCreate(ctx, heroku.AppTransferCreateOpts{
@@ -199,7 +505,14 @@ func (t *Claimer) transferApp(ctx context.Context, appIdentity, recipient string
 	})
 }
 
-func (t *Claimer) acceptTransfer(ctx context.Context, transferID string) error {
+func (t *Claimer) acceptTransfer(ctx context.Context, transferID, recipientToken string) error {
+	if recipientToken != "" {
+		_, err := HerokuService(recipientToken).AppTransferUpdate(ctx, transferID, heroku.AppTransferUpdateOpts{
+			State: "accepted",
+		})
+		return err
+	}
+
 	_, err := t.heroku.AppTransferUpdate(ctx, transferID, heroku.AppTransferUpdateOpts{
 		State: "auto-accepted",
 	})
@@ -207,5 +520,37 @@ func (t *Claimer) acceptTransfer(ctx context.Context, transferID string) error {
 }
 
 func EditorAppURL(app *heroku.App) string {
-	return fmt.Sprintf("https://%s.herokuapp.com/?folder=/home/dyno/project", app.Name)
+	return EditorHostURL(app.Name + ".herokuapp.com")
+}
+
+// EditorHostURL returns the URL of an editor served at host, e.g. a vanity
+// name.
+func EditorHostURL(host string) string {
+	return fmt.Sprintf("https://%s/?folder=/home/dyno/project", host)
+}
+
+// AddDomain adds host to the domains of an app and manages its certificate
+// with Heroku ACM. It returns the DNS target host has to be a CNAME of. A
+// host the app already has is kept, e.g. of a retried claim.
+func AddDomain(ctx context.Context, client *heroku.Service, appIdentity, host string) (string, error) {
+	d, err := client.DomainCreate(ctx, appIdentity, heroku.DomainCreateOpts{Hostname: host})
+	if err != nil {
+		var herr heroku.Error
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusUnprocessableEntity {
+			return "", err
+		}
+		if d, err = client.DomainInfo(ctx, appIdentity, host); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := client.AppEnableACM(ctx, appIdentity); err != nil {
+		return "", err
+	}
+
+	if d.CName == nil || *d.CName == "" {
+		return "", fmt.Errorf("error: domain %s of app %s has no DNS target", host, appIdentity)
+	}
+
+	return *d.CName, nil
 }
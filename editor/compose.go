@@ -0,0 +1,252 @@
+package editor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// extendsFile names the template a template extends, e.g. _base, which
+	// is looked up next to it
+	extendsFile = "extends"
+	// layeredFile is composed of the files of every template of the chain
+	// instead of being overridden, i.e. a template adds its instructions to
+	// the Dockerfile of its base
+	layeredFile = "Dockerfile"
+	// maxExtendsDepth is how many templates a chain may have
+	maxExtendsDepth = 5
+)
+
+// composedFile is a file of the source bundle of a template, which comes
+// from the templates it extends unless the template overrides it.
+type composedFile struct {
+	// Rel is the slash-separated path of the file in the bundle, empty for
+	// the template directory itself
+	Rel  string
+	Info os.FileInfo
+	// Layers are the files it's composed of, from the base template to the
+	// template itself. Only the Dockerfile has more than one.
+	Layers []string
+}
+
+// seed reports whether the file is a seed file, which is copied into
+// workspaces as it is instead of being rendered.
+func (f composedFile) seed() bool {
+	return f.Rel == seedDir || strings.HasPrefix(f.Rel, seedDir+"/")
+}
+
+// content returns the content of the file as it's uploaded, i.e. rendered
+// unless it's a seed file.
+func (f composedFile) content(tmplData map[string]string) ([]byte, error) {
+	var b []byte
+	for i, l := range f.Layers {
+		lb, err := ioutil.ReadFile(l)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+			b = append(b, '\n')
+		}
+		b = append(b, lb...)
+	}
+
+	if f.seed() {
+		return b, nil
+	}
+
+	t, err := template.New(path.Base(f.Rel)).Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("error: fail to parse %s: %w", f.Rel, err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, tmplData); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// TemplateBase returns the name of the template a template extends, or an
+// empty string if it extends none.
+func TemplateBase(dir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, extendsFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	base := strings.TrimSpace(string(b))
+	if base == "." || base == ".." || strings.ContainsAny(base, `/\`) {
+		return "", fmt.Errorf("error: %s of template %s names %s, expected the name of a template next to it", extendsFile, TemplateName(dir), base)
+	}
+
+	return base, nil
+}
+
+// templateChain returns the directories of a template and of the templates
+// it extends, from the base template furthest up to the template itself.
+func templateChain(dir string) ([]string, error) {
+	chain := []string{dir}
+	seen := map[string]bool{filepath.Clean(dir): true}
+	for d := dir; ; {
+		base, err := TemplateBase(d)
+		if err != nil {
+			return nil, err
+		}
+		if base == "" {
+			return chain, nil
+		}
+
+		bd := filepath.Join(filepath.Dir(filepath.Clean(d)), base)
+		if seen[bd] {
+			return nil, fmt.Errorf("error: template %s extends itself through %s", TemplateName(dir), base)
+		}
+		if len(chain) == maxExtendsDepth {
+			return nil, fmt.Errorf("error: template %s extends more than %d templates", TemplateName(dir), maxExtendsDepth-1)
+		}
+		if fi, err := os.Stat(bd); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("error: template %s extends %s, which doesn't exist next to it", TemplateName(d), base)
+		}
+
+		seen[bd] = true
+		chain = append([]string{bd}, chain...)
+		d = bd
+	}
+}
+
+// composeTemplate returns the files of the source bundle of a template,
+// sorted by path. The files of a template override the ones of the same
+// path of the templates it extends, except for the Dockerfile: the one of
+// a template that extends another one has no FROM and its instructions are
+// added to the Dockerfile of the base.
+func composeTemplate(dir string) ([]composedFile, error) {
+	chain, err := templateChain(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*composedFile)
+	for i, d := range chain {
+		err := filepath.Walk(d, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(d, file)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel == "." {
+				rel = ""
+			}
+			if rel == extendsFile {
+				return nil
+			}
+
+			f, ok := files[rel]
+			if ok && f.Info.IsDir() != fi.IsDir() {
+				return fmt.Errorf("error: %s is a directory in one template of %s and a file in another", rel, TemplateName(dir))
+			}
+			if !ok || fi.IsDir() {
+				files[rel] = &composedFile{Rel: rel, Info: fi, Layers: []string{file}}
+				return nil
+			}
+
+			if rel != layeredFile {
+				f.Info = fi
+				f.Layers = []string{file}
+				return nil
+			}
+			if i > 0 && hasFrom(file) {
+				return fmt.Errorf("error: the %s of template %s has a FROM, but its instructions are added to the one of the template it extends", layeredFile, TemplateName(d))
+			}
+			f.Info = fi
+			f.Layers = append(f.Layers, file)
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	composed := make([]composedFile, 0, len(files))
+	for _, f := range files {
+		composed = append(composed, *f)
+	}
+	sort.Slice(composed, func(i, j int) bool { return composed[i].Rel < composed[j].Rel })
+
+	return composed, nil
+}
+
+// hasFrom reports whether a Dockerfile has a FROM instruction.
+func hasFrom(file string) bool {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// templatePath returns the file of a template at a path relative to it,
+// which is the one of the closest template of the chain that has it. It's
+// the path in the template itself if none has it.
+func templatePath(dir, rel string) (string, error) {
+	chain, err := templateChain(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		p := filepath.Join(chain[i], rel)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return filepath.Join(dir, rel), nil
+}
+
+// RenderTemplateFile returns a file of a template as it's uploaded, once
+// it's composed with the templates it extends.
+func RenderTemplateFile(dir, rel string) ([]byte, error) {
+	data, err := TemplateData(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := composeTemplate(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rel = path.Clean(filepath.ToSlash(rel))
+	for _, f := range files {
+		if f.Rel == rel && !f.Info.IsDir() {
+			return f.content(data)
+		}
+	}
+
+	return nil, fmt.Errorf("error: template %s has no %s", TemplateName(dir), rel)
+}
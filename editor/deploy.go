@@ -5,17 +5,21 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
+	"path"
 	"path/filepath"
-	"text/template"
 	"time"
 
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/logmux"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -24,10 +28,17 @@ var (
 	version        = "0.0.2" // TODO load from env var
 )
 
+// Version is the version editors are deployed with, the pool replaces
+// the editors of other versions.
+func Version() string {
+	return version
+}
+
 func NewDeployer(accessToken, templateDir string) *Deployer {
 	client := &http.Client{
 		Transport: &heroku.Transport{
 			BearerToken: accessToken,
+			Transport:   &metrics.Transport{},
 		},
 	}
 
@@ -41,27 +52,38 @@ func NewDeployer(accessToken, templateDir string) *Deployer {
 type Deployer struct {
 	templateDir string
 	heroku      *heroku.Service
-	logger      log.FieldLogger
+	store       store.Store
+	gitToken    string
+	routerDrain string
+	// quarantine is how long the apps of failed pool deploys are kept
+	quarantine time.Duration
+	// environment is the environment of the pool, e.g. staging
+	environment string
+	// output is where the build output is written line by line instead of
+	// the log, see SetOutput
+	output *logmux.Mux
+	logger log.FieldLogger
 }
 
 func (d *Deployer) buildInfo(ctx context.Context, appName, buildID string) (*heroku.Build, error) {
 	return d.heroku.BuildInfo(ctx, appName, buildID)
 }
 
-func (d *Deployer) DeployEditorAndScaleDown(ctx context.Context) (*heroku.App, error) {
+func (d *Deployer) DeployEditorAndScaleDown(ctx context.Context) (*heroku.App, *model.DeployResult, error) {
 	d.logger.Infof("Getting account")
 	acct, err := Account(ctx, d.heroku)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	d.logger.Infof("Creating cf app")
-	cfApp, err := d.createCFApp(ctx, acct)
+	cfApp, err := d.createCFApp(ctx, acct, genBuildingAppName())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	logger := d.logger.WithField("app", cfApp.Name)
+	dep := d.startDeploy(ctx, cfApp, TemplateName(d.templateDir), model.DeployKindPool)
+	logger := d.logger.WithFields(log.Fields{"app": cfApp.Name, "deploy": dep.ID})
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -79,19 +101,25 @@ func (d *Deployer) DeployEditorAndScaleDown(ctx context.Context) (*heroku.App, e
 	defer func() {
 		if err != nil && cfApp != nil {
 			logger.Info("Error deploying app, cleaning up")
-			DeleteApp(d.heroku, cfApp, d.logger)
+			d.discardApp(dep, cfApp, err, logger)
 		}
 	}()
 
-	err = d.buildAndScaleDown(ctx, cfApp, logger)
+	defer func() {
+		d.finishDeploy(dep, cfApp, err)
+	}()
+
+	err = d.buildAndScaleDown(ctx, cfApp, logger, dep)
 	if err != nil {
-		return cfApp, err
+		return cfApp, dep.Result, err
 	}
 
 	logger.Infof("Marking app as idled")
+	ready := dep.timed(&dep.Result.Readiness)
 	cfApp, err = d.markAppAsIdled(ctx, cfApp)
+	ready()
 
-	return cfApp, err
+	return cfApp, dep.Result, err
 }
 
 func (d *Deployer) markAppAsIdled(ctx context.Context, app *heroku.App) (*heroku.App, error) {
@@ -112,42 +140,177 @@ func (d *Deployer) markAppAsIdled(ctx context.Context, app *heroku.App) (*heroku
 	return app, nil
 }
 
-func (d *Deployer) buildAndScaleDown(ctx context.Context, cfApp *heroku.App, logger *log.Entry) error {
-	logger.Infof("Uploading source")
-	src, err := d.uploadSource(ctx, d.templateDir, map[string]string{})
+// DeployPreview deploys the template to a preview app that is left
+// running, optionally with a repository cloned, so template authors can try
+// out changes. Preview apps are never part of the pool.
+func (d *Deployer) DeployPreview(ctx context.Context, gitRepo string) (*heroku.App, *model.DeployResult, error) {
+	d.logger.Infof("Getting account")
+	acct, err := Account(ctx, d.heroku)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.logger.Infof("Creating preview app")
+	cfApp, err := d.createCFApp(ctx, acct, genPreviewAppName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dep := d.startDeploy(ctx, cfApp, TemplateName(d.templateDir), model.DeployKindPreview)
+	logger := d.logger.WithFields(log.Fields{"app": cfApp.Name, "deploy": dep.ID})
+
+	// make sure failed app is cleaned up if there is any error
+	defer func() {
+		if err != nil {
+			logger.Info("Error deploying preview app, cleaning up")
+			DeleteApp(d.heroku, cfApp, d.logger)
+		}
+	}()
+
+	defer func() {
+		d.finishDeploy(dep, cfApp, err)
+	}()
+
+	if gitRepo != "" {
+		logger.Infof("Setting repository")
+		if _, err = d.heroku.ConfigVarUpdate(ctx, cfApp.Name, map[string]*string{
+			"GIT_REPO": &gitRepo,
+		}); err != nil {
+			return nil, dep.Result, err
+		}
+	}
+
+	if err = d.build(ctx, cfApp, logger, dep); err != nil {
+		return nil, dep.Result, err
+	}
+
+	return cfApp, dep.Result, nil
+}
+
+func (d *Deployer) buildAndScaleDown(ctx context.Context, cfApp *heroku.App, logger *log.Entry, dep *deployment) error {
+	if err := d.build(ctx, cfApp, logger, dep); err != nil {
+		return err
+	}
+
+	logger.Infof("Scaling down app")
+	defer dep.timed(&dep.Result.Readiness)()
+	return d.scaleDownApp(ctx, cfApp.Name)
+}
+
+// build builds and releases the template on an app. The build and release
+// output is written to the logger and to the log of the deploy.
+func (d *Deployer) build(ctx context.Context, cfApp *heroku.App, logger *log.Entry, dep *deployment) error {
+	logger.Infof("Tagging template")
+	if err := d.tagTemplate(ctx, cfApp.Name); err != nil {
+		return err
+	}
+
+	data, err := TemplateData(d.templateDir)
+	if err != nil {
+		return err
+	}
+
+	sourceURL, err := d.sourceURL(ctx, data, logger, dep)
 	if err != nil {
 		return err
 	}
 
+	return d.buildSource(ctx, cfApp, sourceURL, "", logger, dep)
+}
+
+// buildSource builds and releases a source tarball that is downloaded
+// from sourceURL on an app.
+// buildSource builds the tarball at sourceURL, which Heroku verifies
+// against digest, the hex SHA-256 of the tarball, unless it's empty.
+func (d *Deployer) buildSource(ctx context.Context, cfApp *heroku.App, sourceURL, digest string, logger *log.Entry, dep *deployment) error {
+	built := dep.timed(&dep.Result.Build)
+
 	logger.Infof("Creating build")
-	build, err := d.createBuild(ctx, cfApp, src)
+	build, err := d.createBuild(ctx, cfApp, sourceURL, digest)
 	if err != nil {
 		return err
 	}
 
 	logger = logger.WithField("build", build.ID)
 
+	var w io.WriteCloser = logger.Writer()
+	if d.output != nil {
+		w = d.output.Writer(cfApp.Name)
+	}
+	defer w.Close()
+	output := io.MultiWriter(w, &dep.log)
+
 	logger.Infof("Building")
-	if err := d.streamBuildLog(ctx, build, logger.Writer()); err != nil {
+	if err := d.streamBuildLog(ctx, build, output); err != nil {
 		return err
 	}
+	built()
 
+	defer dep.timed(&dep.Result.Release)()
 	if err := d.waitForRelease(ctx, build, logger); err != nil {
 		return err
 	}
 
-	logger.Infof("Scaling down app")
-	return d.scaleDownApp(ctx, cfApp.Name)
+	if err := d.streamReleaseLog(ctx, cfApp, output); err != nil {
+		logger.WithError(err).Info("Fail to get release output")
+	}
+
+	return nil
 }
 
-func (d *Deployer) scaleDownApp(ctx context.Context, appIdentity string) error {
-	qty := 0
-	_, err := d.heroku.FormationUpdate(ctx, appIdentity, "web", heroku.FormationUpdateOpts{
-		Quantity: &qty,
-	})
+func (d *Deployer) tagTemplate(ctx context.Context, appIdentity string) error {
+	m, err := TemplateManifest(d.templateDir)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	stack, err := TemplateStack(d.templateDir)
+	if err != nil {
+		return err
+	}
+
+	name := TemplateName(d.templateDir)
+	manifest := string(b)
+	_, err = d.heroku.ConfigVarUpdate(ctx, appIdentity, d.withEnvironment(map[string]*string{
+		templateConfigVar:         &name,
+		templateManifestConfigVar: &manifest,
+		stackConfigVar:            &stack,
+	}))
 	return err
 }
 
+// SetEnvironment tags the apps deployed from now on with the environment of
+// the pool, e.g. staging.
+func (d *Deployer) SetEnvironment(env string) {
+	d.environment = env
+}
+
+// SetOutput writes the build and release output of deploys to m, each line
+// prefixed with the app, so that the output of concurrent deploys stays
+// readable.
+func (d *Deployer) SetOutput(m *logmux.Mux) {
+	d.output = m
+}
+
+// withEnvironment adds the environment of the pool to the config vars an
+// app is tagged with, if it has one.
+func (d *Deployer) withEnvironment(vars map[string]*string) map[string]*string {
+	if d.environment != "" {
+		vars[environmentConfigVar] = &d.environment
+	}
+
+	return vars
+}
+
+func (d *Deployer) scaleDownApp(ctx context.Context, appIdentity string) error {
+	return ScaleApp(ctx, d.heroku, appIdentity, 0)
+}
+
 func (d *Deployer) app(ctx context.Context, appName string) (*heroku.App, error) {
 	app, err := d.heroku.AppInfo(ctx, appName)
 	if err != nil {
@@ -160,9 +323,8 @@ func (d *Deployer) app(ctx context.Context, appName string) (*heroku.App, error)
 	return app, nil
 }
 
-func (d *Deployer) createCFApp(ctx context.Context, acct *heroku.Account) (*heroku.App, error) {
+func (d *Deployer) createCFApp(ctx context.Context, acct *heroku.Account, name string) (*heroku.App, error) {
 	region := "us"
-	name := genBuildingAppName()
 	cfApp, err := d.heroku.AppCreate(ctx, heroku.AppCreateOpts{
 		Name:   &name,
 		Region: &region,
@@ -171,17 +333,28 @@ func (d *Deployer) createCFApp(ctx context.Context, acct *heroku.Account) (*hero
 	if err != nil {
 		return nil, err
 	}
+
+	if d.routerDrain != "" {
+		if _, err := d.heroku.LogDrainCreate(ctx, cfApp.Name, heroku.LogDrainCreateOpts{URL: d.routerDrain}); err != nil {
+			DeleteApp(d.heroku, cfApp, d.logger)
+			return nil, err
+		}
+	}
+
 	return cfApp, nil
 }
 
-func (d *Deployer) uploadSource(ctx context.Context, dir string, tmplData map[string]string) (*heroku.Source, error) {
-	src, err := d.heroku.SourceCreate(ctx)
-	if err != nil {
+func (d *Deployer) uploadSource(ctx context.Context, dir string, tmplData map[string]string, dep *deployment) (*heroku.Source, error) {
+	compressed := dep.timed(&dep.Result.Tarball)
+	buf := bytes.NewBuffer(nil)
+	if err := compress(dir, buf, tmplData); err != nil {
 		return nil, err
 	}
+	compressed()
 
-	buf := bytes.NewBuffer(nil)
-	if err := compress("./template", buf, tmplData); err != nil {
+	defer dep.timed(&dep.Result.Upload)()
+	src, err := d.heroku.SourceCreate(ctx)
+	if err != nil {
 		return nil, err
 	}
 
@@ -207,16 +380,22 @@ func (d *Deployer) uploadSource(ctx context.Context, dir string, tmplData map[st
 	return src, nil
 }
 
-func (d *Deployer) createBuild(ctx context.Context, cfApp *heroku.App, src *heroku.Source) (*heroku.Build, error) {
+func (d *Deployer) createBuild(ctx context.Context, cfApp *heroku.App, sourceURL, digest string) (*heroku.Build, error) {
+	var checksum *string
+	if digest != "" {
+		c := "SHA256:" + digest
+		checksum = &c
+	}
+
 	return d.heroku.BuildCreate(ctx, cfApp.Name, heroku.BuildCreateOpts{
 		SourceBlob: struct {
 			Checksum *string `json:"checksum,omitempty" url:"checksum,omitempty,key"`
 			URL      *string `json:"url,omitempty" url:"url,omitempty,key"`
 			Version  *string `json:"version,omitempty" url:"version,omitempty,key"`
 		}{
-			URL:     &src.SourceBlob.GetURL,
-			Version: &version,
-			// TODO: add checksum and version
+			Checksum: checksum,
+			URL:      &sourceURL,
+			Version:  &version,
 		},
 	})
 }
@@ -247,6 +426,36 @@ func (d *Deployer) streamBuildLog(ctx context.Context, build *heroku.Build, buil
 	}
 }
 
+// streamReleaseLog copies the output of the release phase of the current
+// release of an app, if it has one.
+func (d *Deployer) streamReleaseLog(ctx context.Context, cfApp *heroku.App, releaseOutput io.Writer) error {
+	releases, err := d.heroku.ReleaseList(ctx, cfApp.Name, &heroku.ListRange{
+		Field:      "version",
+		Max:        1,
+		Descending: true,
+	})
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 || releases[0].OutputStreamURL == nil {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *releases[0].OutputStreamURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(releaseOutput, resp.Body)
+	return err
+}
+
 func (d *Deployer) waitForRelease(ctx context.Context, build *heroku.Build, logger log.FieldLogger) error {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -296,75 +505,50 @@ func (d *Deployer) cfAppURL(ctx context.Context, appID string) (string, error) {
 }
 
 func compress(src string, buf io.Writer, tmplData map[string]string) error {
+	files, err := composeTemplate(src)
+	if err != nil {
+		return err
+	}
+
 	// tar > gzip > buf
 	zr := gzip.NewWriter(buf)
 	tw := tar.NewWriter(zr)
 
-	// walk through every file in the folder
-	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-		path := filepath.ToSlash(file)
-
-		if !fi.IsDir() {
-			dir, err := ioutil.TempDir("", "tmp")
-			if err != nil {
-				return err
-			}
-			tmpf, err := os.OpenFile(filepath.Join(dir, fi.Name()), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
-			if err != nil {
-				return err
-			}
-			defer os.Remove(dir)
-
-			t := template.Must(template.New(filepath.Base(file)).ParseFiles(file))
-			if err := t.Execute(tmpf, tmplData); err != nil {
-				fmt.Println(err)
-				return err
-			}
+	// keep the files under a single top-level directory wherever the
+	// template directory is
+	top := filepath.Base(filepath.Clean(src))
+	for _, f := range files {
+		// generate tar header
+		header, err := tar.FileInfoHeader(f.Info, "")
+		if err != nil {
+			return err
+		}
 
-			fi, err = tmpf.Stat()
-			if err != nil {
-				return err
-			}
+		// tar.FileInfoHeader only keeps base name of a file
+		header.Name = path.Join(top, f.Rel)
 
-			if err := tmpf.Close(); err != nil {
+		if f.Info.IsDir() {
+			if err := tw.WriteHeader(header); err != nil {
 				return err
 			}
-
-			file = tmpf.Name()
+			continue
 		}
 
-		// generate tar header
-		header, err := tar.FileInfoHeader(fi, file)
+		// seed files are copied into workspaces as they are, the others are
+		// rendered
+		data, err := f.content(tmplData)
 		if err != nil {
 			return err
 		}
+		header.Size = int64(len(data))
 
-		// tar.FileInfoHeader only keeps base name of a file
-		header.Name = path
-
-		// write header
+		// write header and file content
 		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
-
-		// if not a dir, write file content
-		if !fi.IsDir() {
-			data, err := os.Open(file)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(tw, data); err != nil {
-				return err
-			}
-			if err := data.Close(); err != nil {
-				return err
-			}
+		if _, err := tw.Write(data); err != nil {
+			return err
 		}
-
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
 	// produce tar
@@ -0,0 +1,127 @@
+package editor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDeployOutput is how much of the output of a deploy is kept. Longer
+// output is cut from the start, where failures are the least likely.
+const maxDeployOutput = 1 << 20
+
+func DeployKey(id string) string {
+	return "deploys/" + id
+}
+
+// deployLog keeps the tail of the build and release output of a deploy.
+type deployLog struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (l *deployLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.buf, p...)
+	if n := len(l.buf) - maxDeployOutput; n > 0 {
+		l.buf = l.buf[n:]
+	}
+
+	return len(p), nil
+}
+
+func (l *deployLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return string(l.buf)
+}
+
+// deployment is a deploy in progress, which is recorded in the store of the
+// deployer if it has one.
+type deployment struct {
+	model.Deploy
+	log deployLog
+}
+
+func (d *Deployer) startDeploy(ctx context.Context, app *heroku.App, template, kind string) *deployment {
+	dep := &deployment{
+		Deploy: model.Deploy{
+			ID:        xid.New().String(),
+			App:       app.Name,
+			Template:  template,
+			Kind:      kind,
+			Status:    model.DeployStatusBuilding,
+			StartedAt: time.Now(),
+			Result:    &model.DeployResult{},
+		},
+	}
+
+	d.logger.WithField("deploy", dep.ID).WithField("app", app.Name).Info("Starting deploy")
+	d.saveDeploy(ctx, dep)
+
+	return dep
+}
+
+// timed adds the time until the returned func is called to a phase of the
+// result of a deploy, e.g. defer dep.timed(&dep.Result.Build)().
+func (dep *deployment) timed(phase *time.Duration) func() {
+	start := time.Now()
+	return func() {
+		*phase += time.Since(start)
+	}
+}
+
+func (d *Deployer) finishDeploy(dep *deployment, app *heroku.App, err error) {
+	now := time.Now()
+	dep.FinishedAt = &now
+	dep.Result.Total = now.Sub(dep.StartedAt)
+	dep.Output = dep.log.String()
+	if app != nil {
+		dep.App = app.Name
+	}
+
+	dep.Status = model.DeployStatusSucceeded
+	if err != nil {
+		dep.Status = model.DeployStatusFailed
+		dep.Error = err.Error()
+	}
+
+	d.logger.WithFields(log.Fields{
+		"deploy":    dep.ID,
+		"app":       dep.App,
+		"status":    dep.Status,
+		"tarball":   dep.Result.Tarball,
+		"upload":    dep.Result.Upload,
+		"build":     dep.Result.Build,
+		"release":   dep.Result.Release,
+		"readiness": dep.Result.Readiness,
+		"total":     dep.Result.Total,
+	}).Info("Finished deploy")
+
+	// use a new ctx to record deploys that are canceled
+	d.saveDeploy(context.Background(), dep)
+}
+
+func (d *Deployer) saveDeploy(ctx context.Context, dep *deployment) {
+	if d.store == nil {
+		return
+	}
+
+	if err := d.store.Put(ctx, DeployKey(dep.ID), dep.Deploy); err != nil {
+		d.logger.WithError(err).WithField("deploy", dep.ID).Info("Fail to record deploy")
+	}
+}
+
+// SetStore records deploys with their output in st, see DeployKey.
+func (d *Deployer) SetStore(st store.Store) {
+	d.store = st
+}
@@ -0,0 +1,56 @@
+package editor
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+)
+
+var (
+	envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// config vars managed by codeface can never be set by a claim
+	reservedEnv = []string{"PORT", "GIT_REPO", "GIT_REF", "GIT_PATH", "GIT_UPSTREAM", "CF_*", "HEROKU_*"}
+)
+
+// EnvPolicy decides which environment variables a claim may set on an
+// editor. Patterns are matched with path.Match, e.g. API_*. An empty Allow
+// list allows everything that is not denied.
+type EnvPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+func (p EnvPolicy) Validate(env map[string]string) error {
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("error: invalid environment variable name %q", name)
+		}
+
+		if matchAny(reservedEnv, name) || matchAny(p.Deny, name) {
+			return fmt.Errorf("error: environment variable %s is not allowed", name)
+		}
+
+		if len(p.Allow) > 0 && !matchAny(p.Allow, name) {
+			return fmt.Errorf("error: environment variable %s is not allowed", name)
+		}
+	}
+
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
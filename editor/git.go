@@ -0,0 +1,98 @@
+package editor
+
+import (
+	"context"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/github"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const templateSourceConfigVar = "CF_TEMPLATE_SOURCE"
+
+// GitTemplateName returns the name of the template in a repository, which
+// is the name of the repository.
+func GitTemplateName(gitURL string) string {
+	_, name, err := github.ParseRepoURL(gitURL)
+	if err != nil {
+		return DefaultTemplate
+	}
+
+	return name
+}
+
+// SetGitToken sets the GitHub token that private template repositories are
+// downloaded with by DeployFromGit.
+func (d *Deployer) SetGitToken(token string) {
+	d.gitToken = token
+}
+
+// DeployFromGit creates an idle editor by building a ref of a template
+// repository on GitHub, so that no template directory is needed on disk.
+// The root of the repository is the template. Its files are built as they
+// are, i.e. they aren't rendered like the files of a template directory.
+func (d *Deployer) DeployFromGit(ctx context.Context, gitURL, ref string) (*heroku.App, *model.DeployResult, error) {
+	owner, repo, err := github.ParseRepoURL(gitURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.logger.Infof("Getting account")
+	acct, err := Account(ctx, d.heroku)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.logger.Infof("Creating cf app")
+	cfApp, err := d.createCFApp(ctx, acct, genBuildingAppName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dep := d.startDeploy(ctx, cfApp, repo, model.DeployKindPool)
+	logger := d.logger.WithFields(log.Fields{"app": cfApp.Name, "deploy": dep.ID, "repo": owner + "/" + repo, "ref": ref})
+
+	// make sure failed app is cleaned up if there is any error
+	defer func() {
+		if err != nil {
+			logger.Info("Error deploying app from git, cleaning up")
+			d.discardApp(dep, cfApp, err, logger)
+		}
+	}()
+
+	defer func() {
+		d.finishDeploy(dep, cfApp, err)
+	}()
+
+	logger.Infof("Tagging template")
+	source := github.RepoURL(owner, repo) + "#" + ref
+	if _, err = d.heroku.ConfigVarUpdate(ctx, cfApp.Name, map[string]*string{
+		templateConfigVar:       &repo,
+		templateSourceConfigVar: &source,
+	}); err != nil {
+		return nil, dep.Result, err
+	}
+
+	// the URL of private repositories expires, so it's fetched right before
+	// the build downloads it
+	sourceURL, err := github.TarballURL(ctx, owner, repo, ref, d.gitToken)
+	if err != nil {
+		return nil, dep.Result, err
+	}
+
+	if err = d.buildSource(ctx, cfApp, sourceURL, "", logger, dep); err != nil {
+		return nil, dep.Result, err
+	}
+
+	defer dep.timed(&dep.Result.Readiness)()
+	logger.Infof("Scaling down app")
+	if err = d.scaleDownApp(ctx, cfApp.Name); err != nil {
+		return nil, dep.Result, err
+	}
+
+	logger.Infof("Marking app as idled")
+	cfApp, err = d.markAppAsIdled(ctx, cfApp)
+
+	return cfApp, dep.Result, err
+}
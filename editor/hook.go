@@ -0,0 +1,71 @@
+package editor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jingweno/codeface/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PreClaimEvent  = "claim.pre"
+	PostClaimEvent = "claim.post"
+)
+
+// Hook is a webhook called in the claim path. A hook rejects a claim by
+// responding with a 4xx status. When the hook can't be reached, times out
+// or responds with a 5xx status, the claim fails unless FailOpen is set.
+type Hook struct {
+	URL      string
+	Secret   string
+	Timeout  time.Duration
+	FailOpen bool
+}
+
+type ClaimHooks struct {
+	PreClaim  *Hook
+	PostClaim *Hook
+}
+
+type HookPayload struct {
+	App       string `json:",omitempty"`
+	Recipient string
+	GitRepo   string
+}
+
+func (t *Claimer) SetHooks(hooks ClaimHooks) {
+	t.hooks = hooks
+}
+
+// Run calls the hook. It's a no-op for a nil hook.
+func (hook *Hook) Run(ctx context.Context, event string, payload HookPayload, logger log.FieldLogger) error {
+	if hook == nil || hook.URL == "" {
+		return nil
+	}
+
+	logger = logger.WithField("hook", event)
+
+	c := &webhook.Client{
+		URL:     hook.URL,
+		Secret:  hook.Secret,
+		Timeout: hook.Timeout,
+	}
+
+	err := c.Send(ctx, event, payload)
+	if err == nil {
+		return nil
+	}
+
+	if se, ok := err.(*webhook.StatusError); ok && se.StatusCode >= 400 && se.StatusCode < 500 {
+		return fmt.Errorf("error: claim is rejected by %s hook: %s", event, se.Body)
+	}
+
+	if hook.FailOpen {
+		logger.WithError(err).Info("Hook failed, continuing")
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,79 @@
+package editor
+
+import (
+	"context"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// QuarantineKey is the key of the failed pool deploy of an app, which is
+// kept for inspection instead of being deleted.
+func QuarantineKey(appName string) string {
+	return "quarantine/" + appName
+}
+
+// Quarantined returns the failed pool deploys that are quarantined.
+func Quarantined(ctx context.Context, st store.Store) ([]model.Quarantine, error) {
+	keys, err := st.List(ctx, QuarantineKey(""))
+	if err != nil {
+		return nil, err
+	}
+
+	var qs []model.Quarantine
+	for _, key := range keys {
+		var q model.Quarantine
+		err := st.Get(ctx, key, &q)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+	}
+
+	return qs, nil
+}
+
+// SetQuarantine keeps the apps of failed pool deploys for d instead of
+// deleting them, see QuarantineKey. It needs a store, see SetStore.
+func (d *Deployer) SetQuarantine(dur time.Duration) {
+	d.quarantine = dur
+}
+
+// discardApp deletes the app of a failed deploy, or quarantines it with
+// the output of the deploy.
+func (d *Deployer) discardApp(dep *deployment, app *heroku.App, err error, logger log.FieldLogger) {
+	if d.quarantine == 0 || d.store == nil {
+		DeleteApp(d.heroku, app, d.logger)
+		return
+	}
+
+	// use a new ctx to quarantine deploys that are canceled
+	ctx := context.Background()
+	if err := d.scaleDownApp(ctx, app.Name); err != nil {
+		logger.WithError(err).Info("Fail to scale down quarantined app")
+	}
+
+	now := time.Now()
+	q := model.Quarantine{
+		App:           app.Name,
+		Template:      dep.Template,
+		Deploy:        dep.ID,
+		Error:         err.Error(),
+		Output:        dep.Output,
+		QuarantinedAt: now,
+		ExpiresAt:     now.Add(d.quarantine),
+	}
+	if err := d.store.Put(ctx, QuarantineKey(app.Name), q); err != nil {
+		logger.WithError(err).Info("Fail to quarantine app")
+		DeleteApp(d.heroku, app, d.logger)
+		return
+	}
+
+	logger.WithField("expires_at", q.ExpiresAt).Warn("Quarantined failed deploy")
+}
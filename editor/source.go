@@ -0,0 +1,73 @@
+package editor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sourceTTL is how long the source blob of a template version is reused
+// for. Heroku's URLs of source blobs expire after an hour, and builds
+// download them as soon as they start.
+const sourceTTL = 45 * time.Minute
+
+// uploadedSource is the last source blob uploaded for a template version.
+type uploadedSource struct {
+	// mu is held while the blob is uploaded, so that concurrent deploys
+	// of the version wait for the upload instead of uploading it too
+	mu         sync.Mutex
+	url        string
+	uploadedAt time.Time
+}
+
+// sources are the source blobs uploaded by the deployers of the process by
+// template version, which changes with any rendered file of the template.
+var sources = struct {
+	mu sync.Mutex
+	m  map[string]*uploadedSource
+}{m: make(map[string]*uploadedSource)}
+
+func uploadedSourceOf(version string) *uploadedSource {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+
+	src, ok := sources.m[version]
+	if !ok {
+		src = &uploadedSource{}
+		sources.m[version] = src
+	}
+
+	return src
+}
+
+// sourceURL returns the URL of the source blob of the template on disk.
+// It's only uploaded if no blob of the same version was uploaded within
+// sourceTTL.
+func (d *Deployer) sourceURL(ctx context.Context, tmplData map[string]string, logger log.FieldLogger, dep *deployment) (string, error) {
+	version, err := TemplateVersion(d.templateDir)
+	if err != nil {
+		return "", err
+	}
+
+	src := uploadedSourceOf(version)
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.url != "" && time.Since(src.uploadedAt) < sourceTTL {
+		logger.WithField("version", version).Info("Reusing uploaded source")
+		return src.url, nil
+	}
+
+	logger.Infof("Uploading source")
+	blob, err := d.uploadSource(ctx, d.templateDir, tmplData, dep)
+	if err != nil {
+		return "", err
+	}
+
+	src.url = blob.SourceBlob.GetURL
+	src.uploadedAt = time.Now()
+
+	return src.url, nil
+}
@@ -0,0 +1,95 @@
+package editor
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stacks are the scaffolds of new templates, one directory per stack. The
+// heroku.yml at the root is shared by all of them.
+//
+//go:embed stacks
+var stacks embed.FS
+
+// Stacks returns the stacks a template can be scaffolded for.
+func Stacks() []string {
+	entries, err := stacks.ReadDir("stacks")
+	if err != nil {
+		// the embedded directory always exists
+		panic(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// InitTemplate scaffolds a template for a stack into dir, which must not
+// exist or be empty.
+func InitTemplate(dir, stack string) error {
+	root := path.Join("stacks", stack)
+	if fi, err := fs.Stat(stacks, root); err != nil || !fi.IsDir() {
+		return fmt.Errorf("error: unknown stack %s, expected one of %s", stack, strings.Join(Stacks(), ", "))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("error: template directory %s is not empty", dir)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := writeStackFile(filepath.Join(dir, "heroku.yml"), "stacks/heroku.yml"); err != nil {
+		return err
+	}
+
+	files, err := fs.Sub(stacks, root)
+	if err != nil {
+		return err
+	}
+
+	return fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, name), 0755)
+		}
+
+		return writeStackFile(filepath.Join(dir, filepath.FromSlash(name)), path.Join(root, name))
+	})
+}
+
+func writeStackFile(dst, name string) error {
+	b, err := stacks.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	// embedded files don't keep their mode, scripts are made executable
+	mode := os.FileMode(0644)
+	if strings.HasPrefix(string(b), "#!") {
+		mode = 0755
+	}
+
+	return ioutil.WriteFile(dst, b, mode)
+}
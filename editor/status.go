@@ -0,0 +1,108 @@
+package editor
+
+import (
+	"fmt"
+	"time"
+)
+
+func StatusKey(appName string) string {
+	return "status/" + appName
+}
+
+// MaintenanceKey is the key of the last maintenance of the pool.
+const MaintenanceKey = "maintenance/last"
+
+// AppCapKey is the key of the cap on the total apps of the fleet while
+// it's reached, see model.AppCap.
+const AppCapKey = "capacity/apps"
+
+// ClaimKey is the key of a claim made in the background, see model.Claim.
+func ClaimKey(id string) string {
+	return "claims/" + id
+}
+
+// ClaimPollKey is the key of when the user last polled a claim, which is
+// kept apart from the claim so that polls don't overwrite its outcome.
+func ClaimPollKey(id string) string {
+	return "claimpolls/" + id
+}
+
+// WarmEditorKey is the key of an idle editor that's kept warm, see
+// model.WarmEditor.
+func WarmEditorKey(appName string) string {
+	return "keepwarm/editors/" + appName
+}
+
+// KeepWarmUsageKey is the key of what keeping editors warm cost on a UTC
+// day, e.g. 2006-01-02.
+func KeepWarmUsageKey(day string) string {
+	return "keepwarm/usage/" + day
+}
+
+// BootSLOKey is the key of the boot time objective of the pool of a
+// template, see model.BootSLO.
+func BootSLOKey(template string) string {
+	return "bootslo/" + template
+}
+
+// CanaryKey is the key of the canary of a version of the pool of a
+// template, see model.Canary.
+func CanaryKey(template, version string) string {
+	return "canaries/" + template + "/" + version
+}
+
+func SuspensionKey(appName string) string {
+	return "suspensions/" + appName
+}
+
+func DeletionKey(appName string) string {
+	return "deletions/" + appName
+}
+
+// ReleaseKey is the key of a claimed editor that's kept running after its
+// owner deleted it, until it's claimed again or the sticky window is over.
+func ReleaseKey(appName string) string {
+	return "releases/" + appName
+}
+
+// MigrationKey is the key of the editor a suspended editor was migrated to
+// when it was of an older version.
+func MigrationKey(appName string) string {
+	return "migrations/" + appName
+}
+
+// SessionExpiryKey is the key of when a claimed editor reaches its maximum
+// session duration, see model.SessionExpiry.
+func SessionExpiryKey(appName string) string {
+	return "sessionexpiries/" + appName
+}
+
+// SessionExtensionKey is the key of the extensions of the session of an
+// editor, see model.SessionExtension.
+func SessionExtensionKey(appName string) string {
+	return "sessionextensions/" + appName
+}
+
+// WorkspaceSnapshotKey is the key of the last snapshot the user of an
+// editor saved of its workspace, see model.WorkspaceSnapshot.
+func WorkspaceSnapshotKey(appName string) string {
+	return "workspacesnapshots/" + appName
+}
+
+// WIPPushKey is the key of the push of the uncommitted work of an editor
+// before it's released, see model.WIPPush.
+func WIPPushKey(appName string) string {
+	return "wippushes/" + appName
+}
+
+// PortsKey is the key of the ports the proxy of an editor serves, see
+// model.EditorPorts.
+func PortsKey(appName string) string {
+	return "ports/" + appName
+}
+
+// SnapshotObject returns the object a snapshot of the workspace of an
+// editor is uploaded to.
+func SnapshotObject(appName string, at time.Time) string {
+	return fmt.Sprintf("snapshots/%s/%s.tar.gz", appName, at.UTC().Format("20060102T150405Z"))
+}
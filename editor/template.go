@@ -0,0 +1,507 @@
+package editor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	heroku "github.com/heroku/heroku-go/v5"
+)
+
+const (
+	// DefaultStack is the Heroku stack the editor images of templates are
+	// based on unless their app.json picks another one. Editor apps
+	// themselves always run on the container stack.
+	DefaultStack = "heroku-20"
+
+	templateManifestConfigVar = "CF_TEMPLATE_MANIFEST"
+	stackConfigVar            = "CF_STACK"
+
+	// reposFile lists the repositories cloned into editors claimed without
+	// one, one URL per line
+	reposFile = "repos"
+	// seedDir holds the files editors start with in their workspace
+	seedDir = "seed"
+	// prewarmFile is a script run while the pool is built, in a copy of
+	// the seeded workspace, e.g. to download language servers and index the
+	// workspace. Its caches are baked into the image.
+	prewarmFile = "prewarm"
+	// smokeFile lists the checks cf template test runs in an editor booted
+	// from the template, one per line
+	smokeFile = "smoke"
+)
+
+var herokuStackRegexp = regexp.MustCompile(`^heroku-(\d+)$`)
+
+// Manifest maps the files of a template to the SHA-256 of their rendered
+// content.
+type Manifest map[string]string
+
+// TemplateManifest returns the manifest of the source bundle that is
+// uploaded for a template directory.
+func TemplateManifest(dir string) (Manifest, error) {
+	data, err := TemplateData(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := composeTemplate(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(Manifest)
+	for _, f := range files {
+		if f.Info.IsDir() {
+			continue
+		}
+
+		b, err := f.content(data)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(b)
+		m[f.Rel] = hex.EncodeToString(sum[:])
+	}
+
+	return m, nil
+}
+
+// AppManifest returns the manifest of the template an app is deployed from,
+// or nil if the app was deployed before manifests were recorded.
+func AppManifest(ctx context.Context, client *heroku.Service, appIdentity string) (Manifest, error) {
+	vars, err := client.ConfigVarInfoForApp(ctx, appIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	v := vars[templateManifestConfigVar]
+	if v == nil || *v == "" {
+		return nil, nil
+	}
+
+	var m Manifest
+	if err := json.Unmarshal([]byte(*v), &m); err != nil {
+		return nil, fmt.Errorf("error: fail to parse template manifest of app %s: %w", appIdentity, err)
+	}
+
+	return m, nil
+}
+
+// TemplateStack returns the Heroku stack the editor image of a template is
+// based on, which a template picks with the stack of its app.json.
+func TemplateStack(dir string) (string, error) {
+	p, err := templatePath(dir, "app.json")
+	if err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return DefaultStack, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var app struct {
+		Stack string
+	}
+	if err := json.Unmarshal(b, &app); err != nil {
+		return "", fmt.Errorf("error: app.json is invalid: %w", err)
+	}
+
+	switch {
+	case app.Stack == "" || app.Stack == containerStack:
+		return DefaultStack, nil
+	case herokuStackRegexp.MatchString(app.Stack):
+		return app.Stack, nil
+	default:
+		return "", fmt.Errorf("error: app.json sets stack %s, expected %s or a heroku-NN stack", app.Stack, containerStack)
+	}
+}
+
+// TemplateData returns the data the files of a template are rendered
+// with: the Stack of the template, e.g. heroku-22, and its StackVersion,
+// e.g. 22, so that a Dockerfile can be based on the editor image of the
+// stack. Repos and Seed are set if the template has default repositories
+// or seed files to bake into the image, and Prewarm if it has a prewarm
+// hook.
+func TemplateData(dir string) (map[string]string, error) {
+	stack, err := TemplateStack(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{
+		"Stack":        stack,
+		"StackVersion": herokuStackRegexp.FindStringSubmatch(stack)[1],
+	}
+	for file, key := range map[string]string{reposFile: "Repos", seedDir: "Seed", prewarmFile: "Prewarm"} {
+		p, err := templatePath(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() == (file == seedDir) {
+			data[key] = file
+		}
+	}
+
+	return data, nil
+}
+
+// TemplateBaseImage returns the editor image the Dockerfile of a template
+// is based on, which is the one of its stack.
+func TemplateBaseImage(dir string) (string, error) {
+	data, err := TemplateData(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return "jingweno/heroku-editor:" + data["StackVersion"], nil
+}
+
+// TemplateRepos returns the default repositories of a template, which are
+// cloned into editors claimed without a repository.
+func TemplateRepos(dir string) ([]string, error) {
+	p, err := templatePath(dir, reposFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		u, err := url.Parse(line)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "ssh") || u.Host == "" {
+			return nil, fmt.Errorf("error: %s lists %s, expected an https or ssh repository URL", reposFile, line)
+		}
+
+		repos = append(repos, line)
+	}
+
+	return repos, nil
+}
+
+// AppStack returns the Heroku stack the editor image of an app is based
+// on. Apps deployed before stacks were tagged are on the default stack.
+func AppStack(ctx context.Context, client *heroku.Service, appIdentity string) (string, error) {
+	vars, err := client.ConfigVarInfoForApp(ctx, appIdentity)
+	if err != nil {
+		return "", err
+	}
+
+	if v := vars[stackConfigVar]; v != nil && *v != "" {
+		return *v, nil
+	}
+
+	return DefaultStack, nil
+}
+
+const (
+	// SmokeTestExtension checks that an extension is installed, e.g.
+	// extension golang.go
+	SmokeTestExtension = "extension"
+	// SmokeTestCommand checks that a command exits with 0 in the editor,
+	// e.g. command go version
+	SmokeTestCommand = "command"
+)
+
+// SmokeTest is a check of the smoke file of a template.
+type SmokeTest struct {
+	Kind string
+	Arg  string
+}
+
+func (t SmokeTest) String() string {
+	return t.Kind + " " + t.Arg
+}
+
+// TemplateSmokeTests returns the checks of the smoke file of a template,
+// which cf template test runs in an editor booted from it.
+func TemplateSmokeTests(dir string) ([]SmokeTest, error) {
+	p, err := templatePath(dir, smokeFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tests []SmokeTest
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) != 2 || (kv[0] != SmokeTestExtension && kv[0] != SmokeTestCommand) {
+			return nil, fmt.Errorf("error: %s lists %s, expected %s <id> or %s <command>", smokeFile, line, SmokeTestExtension, SmokeTestCommand)
+		}
+
+		tests = append(tests, SmokeTest{Kind: kv[0], Arg: strings.TrimSpace(kv[1])})
+	}
+
+	return tests, nil
+}
+
+type ManifestChange struct {
+	Path string
+	// Op is one of "added", "removed" or "changed"
+	Op string
+}
+
+// Diff returns the changes from the deployed manifest m to other, sorted by
+// path.
+func (m Manifest) Diff(other Manifest) []ManifestChange {
+	var changes []ManifestChange
+	for path, sum := range other {
+		old, ok := m[path]
+		if !ok {
+			changes = append(changes, ManifestChange{Path: path, Op: "added"})
+		} else if old != sum {
+			changes = append(changes, ManifestChange{Path: path, Op: "changed"})
+		}
+	}
+	for path := range m {
+		if _, ok := other[path]; !ok {
+			changes = append(changes, ManifestChange{Path: path, Op: "removed"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// LintTemplate checks a template directory for problems that would fail
+// the deploy of an editor.
+func LintTemplate(dir string) []error {
+	var errs []error
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return []error{err}
+	}
+	if !fi.IsDir() {
+		return []error{fmt.Errorf("error: %s is not a directory", dir)}
+	}
+
+	data, err := TemplateData(dir)
+	if err != nil {
+		// the stack is reported by lintAppJSON
+		data = map[string]string{}
+	}
+
+	files, err := composeTemplate(dir)
+	if err != nil {
+		return []error{err}
+	}
+	for _, f := range files {
+		if f.Info.IsDir() {
+			continue
+		}
+		if _, err := f.content(data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, lintHerokuYML(dir)...)
+	errs = append(errs, lintProcfile(dir)...)
+	errs = append(errs, lintAppJSON(dir)...)
+	if _, err := TemplateRepos(dir); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, lintPrewarm(dir)...)
+	if _, err := TemplateSmokeTests(dir); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// lintPrewarm checks that the prewarm hook is a script, which the
+// Dockerfile runs directly.
+func lintPrewarm(dir string) []error {
+	p, err := templatePath(dir, prewarmFile)
+	if err != nil {
+		return []error{err}
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return []error{err}
+	}
+
+	if !bytes.HasPrefix(b, []byte("#!")) {
+		return []error{fmt.Errorf("error: %s has no shebang, e.g. #!/usr/bin/env bash", prewarmFile)}
+	}
+
+	return nil
+}
+
+// lintHerokuYML checks that the heroku.yml required by the container stack
+// builds a web process from a Dockerfile that exists.
+func lintHerokuYML(dir string) []error {
+	builds, err := herokuYMLBuilds(dir)
+	if err != nil {
+		return []error{err}
+	}
+
+	var (
+		errs      []error
+		processes []string
+	)
+	for process := range builds {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+
+	for _, process := range processes {
+		p, err := templatePath(dir, builds[process])
+		if err != nil {
+			return append(errs, err)
+		}
+		if _, err := os.Stat(p); err != nil {
+			errs = append(errs, fmt.Errorf("error: heroku.yml builds %s from %s, which doesn't exist", process, builds[process]))
+		}
+	}
+
+	if _, ok := builds["web"]; !ok {
+		errs = append(errs, fmt.Errorf("error: heroku.yml doesn't build a web process under build.docker"))
+	}
+
+	return errs
+}
+
+// herokuYMLBuilds returns the Dockerfiles the heroku.yml of a template
+// builds its processes from, by process.
+func herokuYMLBuilds(dir string) (map[string]string, error) {
+	p, err := templatePath(dir, "heroku.yml")
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("error: heroku.yml is missing, it's required by the %s stack", containerStack)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		section []string
+		indents []int
+	)
+
+	builds := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for len(indents) > 0 && indents[len(indents)-1] >= indent {
+			indents = indents[:len(indents)-1]
+			section = section[:len(section)-1]
+		}
+
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		key := strings.TrimSpace(kv[0])
+		var val string
+		if len(kv) == 2 {
+			val = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+
+		if val == "" {
+			section = append(section, key)
+			indents = append(indents, indent)
+			continue
+		}
+
+		if strings.Join(section, ".") == "build.docker" {
+			builds[key] = val
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return builds, nil
+}
+
+// lintProcfile checks that a Procfile, which is optional for the container
+// stack, declares a web process.
+func lintProcfile(dir string) []error {
+	p, err := templatePath(dir, "Procfile")
+	if err != nil {
+		return []error{err}
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return []error{err}
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "web" && strings.TrimSpace(kv[1]) != "" {
+			return nil
+		}
+	}
+
+	return []error{fmt.Errorf("error: Procfile doesn't declare a web process")}
+}
+
+// lintAppJSON checks that an app.json, which is optional, is valid and
+// picks a stack editor images can be based on.
+func lintAppJSON(dir string) []error {
+	if _, err := TemplateStack(dir); err != nil {
+		return []error{err}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package editor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// templateTestPort is the port editors booted by template tests listen
+	// on, as the PORT of a dyno
+	templateTestPort = "8080"
+	// templateTestPollInterval is how often a booted editor is polled until
+	// code-server responds
+	templateTestPollInterval = 2 * time.Second
+)
+
+// TemplateTestOptions are the options of RunTemplateTest.
+type TemplateTestOptions struct {
+	// Timeout is how long the editor may take to boot once it's built
+	Timeout time.Duration
+	// Keep leaves the editor running once it's tested, e.g. to look into a
+	// failure
+	Keep bool
+	// Output gets the output of docker, e.g. the build log
+	Output io.Writer
+}
+
+// SmokeResult is the result of a check of a template test, Err is nil if
+// it passed.
+type SmokeResult struct {
+	Test string
+	Err  error
+}
+
+// TemplateTest is a template that was built and booted by RunTemplateTest.
+type TemplateTest struct {
+	Image     string
+	Container string
+	// Addr is the address the editor is served at, e.g. 127.0.0.1:49153
+	Addr    string
+	Results []SmokeResult
+}
+
+// Passed reports whether every check of the test passed.
+func (t *TemplateTest) Passed() bool {
+	for _, r := range t.Results {
+		if r.Err != nil {
+			return false
+		}
+	}
+
+	return len(t.Results) > 0
+}
+
+// RunTemplateTest builds a template with the local docker the way it's
+// deployed and boots an editor from it, as the Docker provider does. It
+// checks that code-server responds, then runs the checks of the smoke file
+// of the template in the editor. The editor is removed afterwards unless
+// it's kept.
+func RunTemplateTest(ctx context.Context, dir string, opts TemplateTestOptions) (*TemplateTest, error) {
+	if opts.Output == nil {
+		opts.Output = ioutil.Discard
+	}
+
+	tests, err := TemplateSmokeTests(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := herokuYMLBuilds(dir)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile, ok := builds["web"]
+	if !ok {
+		return nil, fmt.Errorf("error: heroku.yml doesn't build a web process under build.docker")
+	}
+
+	src, err := ioutil.TempDir("", "cf-template-test-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(src)
+
+	if err := writeTemplate(dir, src); err != nil {
+		return nil, err
+	}
+
+	t := &TemplateTest{Image: "codeface-test/" + TemplateName(dir)}
+	if _, err := docker(ctx, opts.Output, "build", "-t", t.Image, "-f", filepath.Join(src, filepath.FromSlash(dockerfile)), src); err != nil {
+		return nil, err
+	}
+
+	t.Container, err = docker(ctx, nil, "run", "-d", "-e", "PORT="+templateTestPort, "-p", "127.0.0.1::"+templateTestPort, t.Image)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Keep {
+		defer func() {
+			// the context may be done already
+			if _, err := docker(context.Background(), nil, "rm", "-f", t.Container); err != nil {
+				fmt.Fprintf(opts.Output, "Fail to remove container %s: %s\n", t.Container, err)
+			}
+		}()
+	}
+
+	port, err := docker(ctx, nil, "port", t.Container, templateTestPort+"/tcp")
+	if err != nil {
+		return nil, err
+	}
+	// docker lists a port per address family
+	t.Addr = strings.Split(port, "\n")[0]
+
+	err = waitForEditor(ctx, t.Container, t.Addr, opts.Timeout)
+	t.Results = append(t.Results, SmokeResult{Test: "code-server responds", Err: err})
+	if err != nil {
+		if logs, lerr := docker(context.Background(), nil, "logs", "--tail", "50", t.Container); lerr == nil {
+			fmt.Fprintln(opts.Output, logs)
+		}
+		return t, nil
+	}
+
+	var extensions []string
+	for _, test := range tests {
+		var err error
+		switch test.Kind {
+		case SmokeTestExtension:
+			if extensions == nil {
+				out, lerr := docker(ctx, nil, "exec", t.Container, "code-server", "--list-extensions")
+				if lerr != nil {
+					t.Results = append(t.Results, SmokeResult{Test: test.String(), Err: lerr})
+					continue
+				}
+				extensions = strings.Fields(strings.ToLower(out))
+			}
+
+			err = fmt.Errorf("error: extension %s isn't installed", test.Arg)
+			for _, ext := range extensions {
+				if ext == strings.ToLower(test.Arg) {
+					err = nil
+					break
+				}
+			}
+		case SmokeTestCommand:
+			_, err = docker(ctx, nil, "exec", t.Container, "bash", "-c", test.Arg)
+		}
+
+		t.Results = append(t.Results, SmokeResult{Test: test.String(), Err: err})
+	}
+
+	return t, nil
+}
+
+// waitForEditor polls an editor until code-server responds without a
+// server error, which is when the editor is ready for its user.
+func waitForEditor(ctx context.Context, container, addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	t := time.NewTicker(templateTestPollInterval)
+	defer t.Stop()
+
+	last := "no response"
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/", nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return nil
+			}
+			last = resp.Status
+		}
+
+		// editors that exit never respond
+		if running, err := docker(ctx, nil, "inspect", "-f", "{{.State.Running}}", container); err == nil && running != "true" {
+			return fmt.Errorf("error: editor exited before code-server responded")
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return fmt.Errorf("error: code-server didn't respond within %s, last: %s", timeout, last)
+		}
+	}
+}
+
+// writeTemplate writes the source bundle of a template into dst, composed
+// with the templates it extends and rendered as it's deployed.
+func writeTemplate(dir, dst string) error {
+	data, err := TemplateData(dir)
+	if err != nil {
+		return err
+	}
+
+	files, err := composeTemplate(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		p := filepath.Join(dst, filepath.FromSlash(f.Rel))
+		if f.Info.IsDir() {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b, err := f.content(data)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, b, f.Info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// docker runs the docker CLI and returns its trimmed output. The output
+// is streamed to out instead if it's set.
+func docker(ctx context.Context, out io.Writer, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if out != nil {
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String() + " " + stdout.String())
+		return "", fmt.Errorf("error: fail to run docker %s: %w: %s", args[0], err, msg)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
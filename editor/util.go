@@ -3,21 +3,45 @@ package editor
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/metrics"
 	"github.com/rs/xid"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	DefaultTemplate = "default"
+
+	templateConfigVar = "CF_TEMPLATE"
+	// environmentConfigVar is the environment of the pool an app is
+	// deployed in, e.g. staging
+	environmentConfigVar = "CF_ENVIRONMENT"
+	// claimedByConfigVar is who an app was claimed for
+	claimedByConfigVar = "CF_CLAIMED_BY"
+)
+
 var (
 	// building app name is in the format of cf-#{ID}-#{VERSION}b
 	buildingAppCurrentVersionRegexp = regexp.MustCompile(fmt.Sprintf("cf-(.+)-%sb", dashizedVersion()))
+	// building app name is in the format of cf-#{ID}-#{VERSION}b
+	buildingAppRegexp = regexp.MustCompile(`^cf-(.+)-(\d+)b$`)
 	// idle app name is in the format of cf-#{ID}-#{VERSION}i
 	idleAppCurrentVersionRegexp = regexp.MustCompile(fmt.Sprintf(`cf-(.+)-%si`, dashizedVersion()))
 	// idle app name is in the format of cf-#{ID}-#{VERSION}i
 	idleAppRegexp = regexp.MustCompile(`cf-(.+)-(\d+)i`)
+	// claimed app name is in the format of cf-#{ID}-#{VERSION}
+	claimedAppCurrentVersionRegexp = regexp.MustCompile(fmt.Sprintf(`^cf-(.+)-%s$`, dashizedVersion()))
+	// claimed app name is in the format of cf-#{ID}-#{VERSION}
+	claimedAppRegexp = regexp.MustCompile(`^cf-(.+)-(\d+)$`)
+	// preview app name is in the format of cf-#{ID}-#{VERSION}p
+	previewAppRegexp = regexp.MustCompile(`^cf-(.+)-(\d+)p$`)
+	// builder app name is in the format of cf-#{ID}-#{VERSION}a
+	builderAppRegexp = regexp.MustCompile(`^cf-(.+)-(\d+)a$`)
 )
 
 func buildClaimedAppName(id string) string {
@@ -32,15 +56,178 @@ func genBuildingAppName() string {
 	return fmt.Sprintf("cf-%s-%sb", xid.New().String(), dashizedVersion())
 }
 
+func genPreviewAppName() string {
+	return fmt.Sprintf("cf-%s-%sp", xid.New().String(), dashizedVersion())
+}
+
+func genBuilderAppName() string {
+	return fmt.Sprintf("cf-%s-%sa", xid.New().String(), dashizedVersion())
+}
+
+// NewIdleAppName returns a name for an idle app of the current version
+// for providers that create editors idle right away.
+func NewIdleAppName() string {
+	return buildIdleAppName(xid.New().String())
+}
+
+// ResetAppName returns the name a claimed app is renamed to when it's put
+// back into the pool. It gets a new ID, so that what was stored about it
+// while it was claimed doesn't carry over, and keeps its version.
+func ResetAppName(claimedName string) (string, bool) {
+	m := claimedAppRegexp.FindStringSubmatch(claimedName)
+	if m == nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("cf-%s-%si", xid.New().String(), m[2]), true
+}
+
+// ClaimedAppName returns the name an idle app is renamed to once claimed.
+func ClaimedAppName(idleName string) (string, bool) {
+	if !idleAppRegexp.MatchString(idleName) {
+		return "", false
+	}
+
+	return buildClaimedAppName(idleAppRegexp.FindStringSubmatch(idleName)[1]), true
+}
+
+// IsIdleApp reports whether an app is idle and whether it's of the current
+// version.
+func IsIdleApp(name string) (idle bool, currentVersion bool) {
+	if idleAppCurrentVersionRegexp.MatchString(name) {
+		return true, true
+	}
+
+	return idleAppRegexp.MatchString(name), false
+}
+
+// IsPreviewApp reports whether an app is a preview of a template, which is
+// never part of the pool.
+func IsPreviewApp(name string) bool {
+	return previewAppRegexp.MatchString(name)
+}
+
+// IsBuilderApp reports whether an app holds a build of a template that
+// pool editors are promoted from.
+func IsBuilderApp(name string) bool {
+	return builderAppRegexp.MatchString(name)
+}
+
+func IsClaimedApp(name string) bool {
+	return claimedAppRegexp.MatchString(name)
+}
+
+// IsBuildingApp reports whether an app is being deployed to the pool, or
+// was left behind by a deploy that never finished.
+func IsBuildingApp(name string) bool {
+	return buildingAppRegexp.MatchString(name)
+}
+
+// IsCurrentVersionClaimedApp reports whether a claimed app is of the
+// current version.
+func IsCurrentVersionClaimedApp(name string) bool {
+	return claimedAppCurrentVersionRegexp.MatchString(name)
+}
+
+// CopyConfigVars sets the config vars of an app on another app that
+// doesn't have them, e.g. the ones set when it was claimed, and returns
+// them.
+func CopyConfigVars(ctx context.Context, client *heroku.Service, from, to string) (map[string]*string, error) {
+	src, err := client.ConfigVarInfoForApp(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := client.ConfigVarInfoForApp(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]*string)
+	for k, v := range src {
+		if _, ok := dst[k]; !ok {
+			vars[k] = v
+		}
+	}
+	if len(vars) == 0 {
+		return vars, nil
+	}
+
+	_, err = client.ConfigVarUpdate(ctx, to, vars)
+	return vars, err
+}
+
 func dashizedVersion() string {
 	return strings.ReplaceAll(version, ".", "")
 }
 
+func HerokuService(accessToken string) *heroku.Service {
+	client := &http.Client{
+		Transport: &heroku.Transport{
+			BearerToken: accessToken,
+			Transport:   &metrics.Transport{},
+		},
+	}
+
+	return heroku.NewService(client)
+}
+
+// TokenScopes returns the OAuth scopes of a Heroku API token, e.g. global
+// for the API key of an account.
+func TokenScopes(ctx context.Context, accessToken string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, heroku.DefaultURL+"/account", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.heroku+json; version=3")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := (&http.Client{Transport: &metrics.Transport{}}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error: fail to get account: %s", resp.Status)
+	}
+
+	var scopes []string
+	for _, s := range strings.Split(resp.Header.Get("OAuth-Scope"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes, nil
+}
+
+// appPageSize is the most apps the Heroku API returns per request.
+const appPageSize = 1000
+
+// AllApps lists every app of the account sorted by name, page by page.
+func AllApps(ctx context.Context, client *heroku.Service) ([]heroku.App, error) {
+	var apps []heroku.App
+
+	lr := &heroku.ListRange{Field: "name", Max: appPageSize}
+	for {
+		page, err := client.AppListOwnedAndCollaborated(ctx, "~", lr)
+		if err != nil {
+			return nil, err
+		}
+
+		apps = append(apps, page...)
+		if len(page) < appPageSize {
+			return apps, nil
+		}
+
+		// ] starts the next page after the last app of this one
+		lr = &heroku.ListRange{Field: "name", Max: appPageSize, FirstID: "]" + page[len(page)-1].Name}
+	}
+}
+
 func AllIdledApps(ctx context.Context, client *heroku.Service) (currentVersion []heroku.App, otherVersion []heroku.App, err error) {
-	apps, err := client.AppListOwnedAndCollaborated(ctx, "~", &heroku.ListRange{
-		Field: "name",
-		Max:   1000, // FIXME: hardcode
-	})
+	apps, err := AllApps(ctx, client)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -56,6 +243,46 @@ func AllIdledApps(ctx context.Context, client *heroku.Service) (currentVersion [
 	return currentVersion, otherVersion, nil
 }
 
+func AllClaimedApps(ctx context.Context, client *heroku.Service) ([]heroku.App, error) {
+	apps, err := AllApps(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []heroku.App
+	for _, app := range apps {
+		if claimedAppRegexp.MatchString(app.Name) {
+			claimed = append(claimed, app)
+		}
+	}
+
+	return claimed, nil
+}
+
+func TemplateName(templateDir string) string {
+	name := filepath.Base(filepath.Clean(templateDir))
+	if name == "." || name == string(filepath.Separator) {
+		return DefaultTemplate
+	}
+
+	return name
+}
+
+// AppTemplate returns the name of the template an app is deployed from.
+// Apps deployed before templates were tagged belong to the default template.
+func AppTemplate(ctx context.Context, client *heroku.Service, appIdentity string) (string, error) {
+	vars, err := client.ConfigVarInfoForApp(ctx, appIdentity)
+	if err != nil {
+		return "", err
+	}
+
+	if v := vars[templateConfigVar]; v != nil && *v != "" {
+		return *v, nil
+	}
+
+	return DefaultTemplate, nil
+}
+
 func Account(ctx context.Context, client *heroku.Service) (*heroku.Account, error) {
 	acct, err := client.AccountInfo(ctx)
 	if err != nil {
@@ -69,6 +296,13 @@ func Account(ctx context.Context, client *heroku.Service) (*heroku.Account, erro
 	return acct, nil
 }
 
+func ScaleApp(ctx context.Context, client *heroku.Service, appIdentity string, qty int) error {
+	_, err := client.FormationUpdate(ctx, appIdentity, "web", heroku.FormationUpdateOpts{
+		Quantity: &qty,
+	})
+	return err
+}
+
 func DeleteApp(client *heroku.Service, app *heroku.App, logger log.FieldLogger) {
 	logger = logger.WithField("app", app.Name)
 
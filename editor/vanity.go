@@ -0,0 +1,62 @@
+package editor
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+)
+
+// vanityClaimGrace is how long a vanity name is held for the editor it's
+// taken for before the session of the editor starts.
+const vanityClaimGrace = 10 * time.Minute
+
+// VanityKey is the key of a vanity name taken by an editor.
+func VanityKey(name string) string {
+	return "vanity/" + name
+}
+
+// VanityNames returns the vanity names that are taken or were taken and
+// aren't released yet.
+func VanityNames(ctx context.Context, st store.Store) ([]model.VanityName, error) {
+	keys, err := st.List(ctx, VanityKey(""))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []model.VanityName
+	for _, key := range keys {
+		var v model.VanityName
+		err := st.Get(ctx, key, &v)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, v)
+	}
+
+	return names, nil
+}
+
+// VanityInUse returns whether the editor a vanity name was taken for is
+// still claimed, i.e. its session hasn't ended.
+func VanityInUse(ctx context.Context, st store.Store, v model.VanityName, now time.Time) (bool, error) {
+	if v.Editor == "" {
+		return now.Sub(v.CreatedAt) < vanityClaimGrace, nil
+	}
+
+	var s model.Session
+	err := st.Get(ctx, usage.SessionKey(v.Editor), &s)
+	if err == store.ErrNotFound {
+		return now.Sub(v.CreatedAt) < vanityClaimGrace, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return s.EndedAt == nil, nil
+}
@@ -0,0 +1,140 @@
+// Package editorproxy is the proxy running in front of code-server inside
+// editors. It enforces the network policy of a deployment: an allow-list
+// of client IPs, and a forward proxy that refuses to connect editors to
+// denied CIDRs. It also serves viewer links from a read-only code-server
+// and the ports the user of the editor opened.
+package editorproxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ParseCIDRs parses CIDRs, e.g. 10.0.0.0/8. Plain IPs are single host
+// CIDRs.
+func ParseCIDRs(ss []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("error: invalid IP %q", s)
+			}
+
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid CIDR %q", s)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the IP of the client of a request behind the given
+// number of trusted proxies. Each proxy appends the address it received
+// the request from to X-Forwarded-For, so the client is the entry hops
+// from the end and entries before it can be forged.
+func ClientIP(r *http.Request, hops int) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if hops > 0 {
+		var xff []string
+		for _, h := range r.Header["X-Forwarded-For"] {
+			for _, s := range strings.Split(h, ",") {
+				xff = append(xff, strings.TrimSpace(s))
+			}
+		}
+
+		if len(xff) < hops {
+			return nil
+		}
+		host = xff[len(xff)-hops]
+	}
+
+	return net.ParseIP(host)
+}
+
+// AllowList only lets requests from clients in the allowed CIDRs through.
+// Requests are all let through when no CIDR is allowed.
+func AllowList(next http.Handler, allowed []*net.IPNet, hops int, logger log.FieldLogger) http.Handler {
+	if len(allowed) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r, hops)
+		if ip == nil || !contains(allowed, ip) {
+			logger.WithField("ip", ip).Info("Rejecting request from IP that is not allowed")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewEditorHandler proxies requests, including websockets, to code-server.
+func NewEditorHandler(backend string, allowed []*net.IPNet, hops int, logger log.FieldLogger) http.Handler {
+	return AllowList(httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: backend}), allowed, hops, logger)
+}
+
+// ViewerHeader carries the viewer key of an editor on the requests of its
+// viewer links, which the server proxies.
+const ViewerHeader = "X-Codeface-Viewer"
+
+// Viewers serves the requests of viewer links from the read-only
+// code-server at backend, and every other request with next. Requests with
+// a viewer header that doesn't match the key of the editor are refused.
+func Viewers(next http.Handler, backend, key string, logger log.FieldLogger) http.Handler {
+	viewer := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: backend})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(ViewerHeader)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(v), []byte(key)) != 1 {
+			logger.Info("Rejecting viewer request with an invalid viewer key")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.Header.Del(ViewerHeader)
+		viewer.ServeHTTP(w, r)
+	})
+}
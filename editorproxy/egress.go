@@ -0,0 +1,117 @@
+package editorproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Egress is a forward proxy for the editor, exported as HTTP_PROXY and
+// HTTPS_PROXY. It refuses connections to hosts resolving to denied CIDRs.
+// Hosts are resolved once and connected to by IP, so that a host can't
+// resolve to an allowed IP when checked and a denied one when dialed.
+//
+// It's only enforced for programs that honor the proxy environment.
+type Egress struct {
+	Deny   []*net.IPNet
+	Logger log.FieldLogger
+
+	dialer net.Dialer
+}
+
+func NewEgress(deny []*net.IPNet, logger log.FieldLogger) *Egress {
+	return &Egress{
+		Deny:   deny,
+		Logger: logger,
+		dialer: net.Dialer{Timeout: 30 * time.Second},
+	}
+}
+
+func (e *Egress) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if contains(e.Deny, ip.IP) {
+			return nil, fmt.Errorf("error: connecting to %s (%s) is denied", host, ip.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("error: no address for %s", host)
+	}
+
+	return e.dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+func (e *Egress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		e.connect(w, r)
+		return
+	}
+
+	if r.URL.Host == "" {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director:  func(*http.Request) {},
+		Transport: &http.Transport{DialContext: e.DialContext},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			e.Logger.WithError(err).WithField("host", r.URL.Host).Info("Fail to proxy request")
+			http.Error(w, err.Error(), http.StatusForbidden)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func (e *Egress) connect(w http.ResponseWriter, r *http.Request) {
+	upstream, err := e.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		e.Logger.WithError(err).WithField("host", r.Host).Info("Fail to connect")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+
+	go pipe(conn, upstream)
+	go pipe(upstream, conn)
+}
+
+func pipe(dst, src net.Conn) {
+	defer dst.Close()
+	defer src.Close()
+
+	_, _ = io.Copy(dst, src)
+}
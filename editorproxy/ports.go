@@ -0,0 +1,116 @@
+package editorproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PortsPrefix is the path the ports of an editor are served at, e.g.
+// /ports/3000/.
+const PortsPrefix = "/ports/"
+
+const (
+	// portsTTL is how long the open ports are kept before they're looked
+	// up again, which is how long a closed port may still be served
+	portsTTL = 30 * time.Second
+	// portsRetry is how often the open ports are looked up again for a
+	// port that isn't open, e.g. that was just opened
+	portsRetry = 2 * time.Second
+)
+
+// Ports serves the ports the user of an editor opened, e.g. with cf-agent
+// ports open, at PortsPrefix. Every other request is served by Next.
+type Ports struct {
+	Next http.Handler
+	// Lookup returns the open ports
+	Lookup func(context.Context) ([]int, error)
+	// Reserved are never served, e.g. the ports of code-server and of the
+	// proxy itself
+	Reserved []int
+	Logger   log.FieldLogger
+
+	mu          sync.Mutex
+	open        map[int]bool
+	refreshedAt time.Time
+}
+
+func (p *Ports) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, PortsPrefix) {
+		p.Next.ServeHTTP(w, r)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, PortsPrefix)
+	path := ""
+	slash := strings.Index(rest, "/")
+	if slash >= 0 {
+		rest, path = rest[:slash], rest[slash:]
+	}
+
+	port, err := strconv.Atoi(rest)
+	if err != nil || !p.isOpen(r.Context(), port) {
+		http.Error(w, "port is not open, open it with: cf-agent ports open <port>", http.StatusNotFound)
+		return
+	}
+
+	// relative URLs of the app need the trailing slash
+	if slash < 0 {
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	target := &url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(port)}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			req.Header.Set("X-Forwarded-Prefix", PortsPrefix+strconv.Itoa(port))
+		},
+	}
+
+	// websockets are proxied too, e.g. of live reloads
+	proxy.ServeHTTP(w, r)
+}
+
+func (p *Ports) isOpen(ctx context.Context, port int) bool {
+	for _, r := range p.Reserved {
+		if port == r {
+			return false
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	since := time.Since(p.refreshedAt)
+	if since < portsTTL && (p.open[port] || since < portsRetry) {
+		return p.open[port]
+	}
+
+	ports, err := p.Lookup(ctx)
+	if err != nil {
+		// the ports looked up last are kept until the server answers again
+		p.Logger.WithError(err).Info("Fail to look up open ports")
+		return p.open[port]
+	}
+
+	p.open = make(map[int]bool)
+	for _, o := range ports {
+		p.open[o] = true
+	}
+	p.refreshedAt = time.Now()
+
+	return p.open[port]
+}
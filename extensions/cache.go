@@ -0,0 +1,128 @@
+package extensions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps the files of extensions on disk, up to a size after which
+// the least recently used ones are evicted. Files of a version of an
+// extension never change, so they never expire.
+type Cache struct {
+	Dir      string
+	MaxBytes int64
+
+	mu sync.Mutex
+}
+
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Cache{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+// file returns the file a key is cached in, which keeps the extension of
+// the path for its content type.
+func (c *Cache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	ext := path.Ext(path.Base(key))
+	if len(ext) > 10 {
+		ext = ""
+	}
+
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+ext)
+}
+
+// Serve serves a cached file, and returns false if it isn't cached.
+func (c *Cache) Serve(w http.ResponseWriter, r *http.Request, key string) bool {
+	name := c.file(key)
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	// the modification time is when the file was last used
+	now := time.Now()
+	os.Chtimes(name, now, now)
+
+	http.ServeContent(w, r, filepath.Base(name), fi.ModTime(), f)
+	return true
+}
+
+// Store returns a writer that copies a file to w and to the cache, and a
+// func to call with the error of the copy, which keeps the file only if
+// the copy succeeded.
+func (c *Cache) Store(w io.Writer, key string) (io.Writer, func(error)) {
+	tmp, err := ioutil.TempFile(c.Dir, ".download-")
+	if err != nil {
+		return w, nil
+	}
+
+	return io.MultiWriter(w, tmp), func(err error) {
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+			return
+		}
+
+		if err := os.Rename(tmp.Name(), c.file(key)); err != nil {
+			os.Remove(tmp.Name())
+			return
+		}
+		c.evict()
+	}
+}
+
+// evict deletes the least recently used files while the cache is larger
+// than MaxBytes.
+func (c *Cache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return
+	}
+
+	// downloads in progress aren't evicted
+	var cached []os.FileInfo
+	var size int64
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		cached = append(cached, fi)
+		size += fi.Size()
+	}
+	if size <= c.MaxBytes {
+		return
+	}
+
+	sort.Slice(cached, func(i, j int) bool { return cached[i].ModTime().Before(cached[j].ModTime()) })
+	for _, fi := range cached {
+		if size <= c.MaxBytes {
+			return
+		}
+		if err := os.Remove(filepath.Join(c.Dir, fi.Name())); err == nil {
+			size -= fi.Size()
+		}
+	}
+}
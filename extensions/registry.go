@@ -0,0 +1,305 @@
+// Package extensions proxies an Open VSX extension registry for editors on
+// networks that block it. Only the extensions of an allow-list are found
+// and downloaded, and downloads are cached on disk.
+package extensions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Prefix is the path the registry is served at.
+const Prefix = "/extensions/"
+
+const (
+	// upstreamTimeout is how long the upstream registry has to answer,
+	// downloads of large extensions included
+	upstreamTimeout = 2 * time.Minute
+	// maxQueryBytes is the largest gallery response that's filtered
+	maxQueryBytes = 16 << 20
+)
+
+// Registry serves the gallery API of VS Code at Prefix, as code-server
+// uses it with EXTENSIONS_GALLERY, from an upstream Open VSX registry. The
+// URLs of the responses are rewritten to the registry so that editors never
+// reach the upstream one.
+type Registry struct {
+	// Upstream is the registry proxied, e.g. https://open-vsx.org
+	Upstream *url.URL
+	// BaseURL is the URL of the server the registry is served by
+	BaseURL string
+	// Allow are the extensions editors may find and install, as
+	// publisher.name or publisher.* for every extension of a publisher. Any
+	// extension is allowed if it's empty.
+	Allow []string
+	Cache *Cache
+
+	Logger log.FieldLogger
+	client *http.Client
+}
+
+func NewRegistry(upstream, baseURL string, allow []string, cache *Cache, logger log.FieldLogger) (*Registry, error) {
+	u, err := url.Parse(strings.TrimRight(upstream, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("error: EXTENSION_REGISTRY_URL %q isn't a URL", upstream)
+	}
+
+	return &Registry{
+		Upstream: u,
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		Allow:    allow,
+		Cache:    cache,
+		Logger:   logger,
+		client:   &http.Client{Timeout: upstreamTimeout},
+	}, nil
+}
+
+// Gallery returns the EXTENSIONS_GALLERY of editors that install their
+// extensions from the registry.
+func (g *Registry) Gallery() string {
+	base := g.BaseURL + Prefix
+	b, _ := json.Marshal(map[string]string{
+		"serviceUrl":          base + "gallery",
+		"itemUrl":             base + "item",
+		"resourceUrlTemplate": base + "files/vscode/unpkg/{publisher}/{name}/{version}/{path}",
+	})
+
+	return string(b)
+}
+
+// Allowed reports whether an extension is on the allow-list.
+func (g *Registry) Allowed(publisher, name string) bool {
+	if len(g.Allow) == 0 {
+		return true
+	}
+
+	for _, a := range g.Allow {
+		p := strings.SplitN(a, ".", 2)
+		if len(p) == 2 && strings.EqualFold(p[0], publisher) && (p[1] == "*" || strings.EqualFold(p[1], name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the web UI of code-server may query the gallery from the browser
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, X-Market-Client-Id, X-Market-User-Id, VSCode-SessionId")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, Prefix)
+	switch {
+	case p == "gallery/extensionquery" && r.Method == http.MethodPost:
+		g.serveQuery(w, r)
+	case p == "item" && r.Method == http.MethodGet:
+		name := strings.SplitN(r.URL.Query().Get("itemName"), ".", 2)
+		if len(name) != 2 || !g.Allowed(name[0], name[1]) {
+			http.Error(w, "extension is not allowed", http.StatusForbidden)
+			return
+		}
+		http.Redirect(w, r, g.Upstream.String()+"/vscode/item?"+r.URL.RawQuery, http.StatusFound)
+	case strings.HasPrefix(p, "gallery/") && r.Method == http.MethodGet:
+		g.serveFile(w, r, "/vscode/"+p)
+	case strings.HasPrefix(p, "files/") && r.Method == http.MethodGet:
+		g.serveFile(w, r, "/"+strings.TrimPrefix(p, "files/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// galleryExtension is the part of an extension of a gallery query that
+// the allow-list needs, the rest is passed on as it is.
+type galleryExtension struct {
+	ExtensionName string `json:"extensionName"`
+	Publisher     struct {
+		PublisherName string `json:"publisherName"`
+	} `json:"publisher"`
+}
+
+type galleryResult struct {
+	Extensions     []json.RawMessage `json:"extensions"`
+	ResultMetadata json.RawMessage   `json:"resultMetadata,omitempty"`
+}
+
+// serveQuery searches the upstream gallery and leaves out the extensions
+// that aren't allowed.
+func (g *Registry) serveQuery(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxQueryBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.upstream(r, http.MethodPost, "/vscode/gallery/extensionquery", bytes.NewReader(body))
+	if err != nil {
+		g.Logger.WithError(err).Info("Fail to query extension registry")
+		http.Error(w, "extension registry is unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxQueryBytes))
+	if err != nil {
+		http.Error(w, "extension registry is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if b, err = g.filterQuery(g.rewrite(b)); err != nil {
+			g.Logger.WithError(err).Info("Fail to filter extension query")
+			http.Error(w, "extension registry responded with an unknown query result", http.StatusBadGateway)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(b)
+}
+
+func (g *Registry) filterQuery(b []byte) ([]byte, error) {
+	if len(g.Allow) == 0 {
+		return b, nil
+	}
+
+	var q struct {
+		Results []galleryResult `json:"results"`
+	}
+	if err := json.Unmarshal(b, &q); err != nil {
+		return nil, err
+	}
+
+	for i, res := range q.Results {
+		var allowed []json.RawMessage
+		for _, raw := range res.Extensions {
+			var ext galleryExtension
+			if err := json.Unmarshal(raw, &ext); err != nil {
+				return nil, err
+			}
+			if g.Allowed(ext.Publisher.PublisherName, ext.ExtensionName) {
+				allowed = append(allowed, raw)
+			}
+		}
+		if allowed == nil {
+			allowed = []json.RawMessage{}
+		}
+		q.Results[i].Extensions = allowed
+	}
+
+	return json.Marshal(q)
+}
+
+// rewrite points the URLs of a response at the registry.
+func (g *Registry) rewrite(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte(g.Upstream.String()+"/"), []byte(g.BaseURL+Prefix+"files/"))
+}
+
+// serveFile serves a file of an allowed extension, e.g. its VSIX package
+// or its icon, from the cache or else from the upstream registry.
+func (g *Registry) serveFile(w http.ResponseWriter, r *http.Request, upstreamPath string) {
+	publisher, name, ok := extensionOf(upstreamPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !g.Allowed(publisher, name) {
+		http.Error(w, "extension is not allowed", http.StatusForbidden)
+		return
+	}
+
+	key := upstreamPath + "?" + r.URL.RawQuery
+	if g.Cache != nil && g.Cache.Serve(w, r, key) {
+		return
+	}
+
+	resp, err := g.upstream(r, http.MethodGet, upstreamPath, nil)
+	if err != nil {
+		g.Logger.WithError(err).WithField("path", upstreamPath).Info("Fail to get extension file")
+		http.Error(w, "extension registry is unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	// redirects of the upstream registry, e.g. to the VSIX of a version,
+	// are followed through the registry too
+	if loc := resp.Header.Get("Location"); loc != "" {
+		w.Header().Set("Location", string(g.rewrite([]byte(loc))))
+	}
+	for _, h := range []string{"Content-Type", "Content-Length", "Cache-Control", "ETag"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	var dst io.Writer = w
+	var done func(error)
+	if g.Cache != nil && resp.StatusCode == http.StatusOK {
+		dst, done = g.Cache.Store(w, key)
+	}
+
+	_, err = io.Copy(dst, resp.Body)
+	if done != nil {
+		done(err)
+	}
+}
+
+// extensionOf returns the publisher and the name of the extension a path
+// of the upstream registry belongs to, e.g. /api/{publisher}/{name}/...,
+// /vscode/asset/{publisher}/{name}/... or /vscode/gallery/publishers/
+// {publisher}/vsextensions/{name}/....
+func extensionOf(p string) (string, string, bool) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		return parts[1], parts[2], true
+	case len(parts) >= 4 && parts[0] == "vscode" && (parts[1] == "asset" || parts[1] == "unpkg"):
+		return parts[2], parts[3], true
+	case len(parts) >= 6 && parts[0] == "vscode" && parts[1] == "gallery" && parts[2] == "publishers" && parts[4] == "vsextensions":
+		return parts[3], parts[5], true
+	}
+
+	return "", "", false
+}
+
+func (g *Registry) upstream(r *http.Request, method, p string, body io.Reader) (*http.Response, error) {
+	u := g.Upstream.String() + p
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(r.Context())
+	for _, h := range []string{"Accept", "Content-Type", "User-Agent"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	// redirects are passed on to the editor, whose next request goes
+	// through the registry again
+	client := *g.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return client.Do(req)
+}
@@ -0,0 +1,203 @@
+// Package feature ramps behaviors of codeface up gradually. A flag is on
+// for a percentage of users, so that a behavior can be tried on some of
+// them, and optionally only on some templates, before it's turned on for
+// everyone.
+package feature
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Flags known to codeface.
+const (
+	// RouterIdleDetection tells whether editors are in use by the requests
+	// they served, see IDLE_DETECTION of the worker
+	RouterIdleDetection = "router-idle-detection"
+)
+
+// Known are the flags codeface checks, which are the only ones that may
+// be set.
+var Known = []string{RouterIdleDetection}
+
+const flagsPrefix = "flags/"
+
+// refreshInterval is how long flags are cached before they're read from
+// the store again.
+const refreshInterval = 30 * time.Second
+
+// Key is the state store key of a flag.
+func Key(name string) string {
+	return flagsPrefix + name
+}
+
+// IsKnown returns whether a flag is checked by codeface.
+func IsKnown(name string) bool {
+	for _, k := range Known {
+		if k == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// List returns the flags set in the store, by name.
+func List(ctx context.Context, st store.Store) ([]model.Flag, error) {
+	keys, err := st.List(ctx, flagsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags []model.Flag
+	for _, k := range keys {
+		var f model.Flag
+		if err := st.Get(ctx, k, &f); err != nil {
+			if err == store.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+
+	return flags, nil
+}
+
+// ParseFlags parses flags set by config as name=percent pairs, e.g.
+// router-idle-detection=10.
+func ParseFlags(specs []string) (map[string]model.Flag, error) {
+	flags := make(map[string]model.Flag)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("error: invalid feature flag %q", spec)
+		}
+		if !IsKnown(parts[0]) {
+			return nil, fmt.Errorf("error: unknown feature flag %q", parts[0])
+		}
+
+		pct, err := strconv.Atoi(parts[1])
+		if err != nil || pct < 0 || pct > 100 {
+			return nil, fmt.Errorf("error: invalid percentage of feature flag %q", spec)
+		}
+
+		flags[parts[0]] = model.Flag{Name: parts[0], Percent: pct}
+	}
+
+	return flags, nil
+}
+
+// Enabled returns whether a flag is on for a user of a template. Users
+// fall in or out of the ramp by a hash of their email, so that a user
+// keeps the behavior while the percentage goes up. Ramps without a user
+// are by template.
+func Enabled(f model.Flag, template, user string) bool {
+	for _, u := range f.Users {
+		if u == user {
+			return true
+		}
+	}
+
+	if len(f.Templates) > 0 {
+		found := false
+		for _, t := range f.Templates {
+			if t == template {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	subject := user
+	if subject == "" {
+		subject = template
+	}
+
+	return bucket(f.Name, subject) < f.Percent
+}
+
+func bucket(name, subject string) int {
+	sum := sha256.Sum256([]byte(name + "\n" + subject))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
+
+// Flags evaluates the flags set in the store, which take precedence over
+// the ones set by config.
+type Flags struct {
+	store    store.Store
+	defaults map[string]model.Flag
+	logger   log.FieldLogger
+
+	mu       sync.Mutex
+	flags    map[string]model.Flag
+	loadedAt time.Time
+}
+
+// New returns the flags of a store with defaults set by config, see
+// ParseFlags.
+func New(st store.Store, specs []string, logger log.FieldLogger) (*Flags, error) {
+	defaults, err := ParseFlags(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Flags{
+		store:    st,
+		defaults: defaults,
+		logger:   logger.WithField("com", "feature"),
+	}, nil
+}
+
+// Enabled returns whether a flag is on for a user of a template. Flags
+// that are set nowhere are off.
+func (fl *Flags) Enabled(ctx context.Context, name, template, user string) bool {
+	f, ok := fl.flag(ctx, name)
+	if !ok {
+		return false
+	}
+
+	return Enabled(f, template, user)
+}
+
+func (fl *Flags) flag(ctx context.Context, name string) (model.Flag, bool) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	now := time.Now()
+	if fl.flags == nil || now.Sub(fl.loadedAt) >= refreshInterval {
+		flags, err := List(ctx, fl.store)
+		if err != nil {
+			// the flags loaded last are kept until the store is back
+			fl.logger.WithError(err).Info("Fail to load feature flags")
+		} else {
+			fl.flags = make(map[string]model.Flag, len(flags))
+			for _, f := range flags {
+				fl.flags[f.Name] = f
+			}
+		}
+		fl.loadedAt = now
+	}
+
+	if f, ok := fl.flags[name]; ok {
+		return f, true
+	}
+	f, ok := fl.defaults[name]
+
+	return f, ok
+}
@@ -0,0 +1,158 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const apiURL = "https://api.github.com"
+
+var repoRegexp = regexp.MustCompile(`^([A-Za-z0-9-]+)/([A-Za-z0-9._-]+)$`)
+
+// ParseRepo parses a repository in the format of owner/name.
+func ParseRepo(s string) (owner, name string, err error) {
+	m := repoRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", fmt.Errorf("Please provide a GitHub repository in the format of owner/name")
+	}
+
+	return m[1], m[2], nil
+}
+
+func RepoURL(owner, name string) string {
+	return fmt.Sprintf("https://github.com/%s/%s", owner, name)
+}
+
+func NewApp(id int64, privateKeyPEM []byte) (*App, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("error: fail to decode GitHub App private key")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &App{
+		id:  id,
+		key: key,
+	}, nil
+}
+
+// App authenticates as a GitHub App to mint installation tokens.
+type App struct {
+	id  int64
+	key *rsa.PrivateKey
+}
+
+func (a *App) jwt(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		// allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.id, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signing := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signing + "." + enc.EncodeToString(sig), nil
+}
+
+// InstallationToken returns a short-lived token of the installation that
+// has access to the repository.
+func (a *App) InstallationToken(ctx context.Context, owner, name string) (string, error) {
+	tok, err := a.installationToken(ctx, owner, name, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return tok.Token, nil
+}
+
+// RepoToken returns a short-lived token of the installation that only has
+// access to the contents of the repository, and when it expires.
+func (a *App) RepoToken(ctx context.Context, owner, name string) (string, time.Time, error) {
+	tok, err := a.installationToken(ctx, owner, name, map[string]interface{}{
+		"repositories": []string{name},
+		"permissions":  map[string]string{"contents": "write"},
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tok.Token, tok.ExpiresAt, nil
+}
+
+// Permission returns the permission of a GitHub user on the repository as
+// the installation sees it: admin, write, read or none.
+func (a *App) Permission(ctx context.Context, owner, name, login string) (string, error) {
+	token, err := a.InstallationToken(ctx, owner, name)
+	if err != nil {
+		return "", err
+	}
+
+	var perm struct {
+		Permission string `json:"permission"`
+	}
+	if err := do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s/collaborators/%s/permission", owner, name, login), "token "+token, &perm); err != nil {
+		return "", err
+	}
+
+	return perm.Permission, nil
+}
+
+// CanPush returns whether a permission lets a user push. Maintainers have
+// the write permission.
+func CanPush(permission string) bool {
+	return permission == "admin" || permission == "write"
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (a *App) installationToken(ctx context.Context, owner, name string, scope interface{}) (*installationToken, error) {
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var inst struct {
+		ID int64 `json:"id"`
+	}
+	if err := do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s/installation", owner, name), "Bearer "+jwt, &inst); err != nil {
+		return nil, fmt.Errorf("error: Codeface GitHub App is not installed on %s/%s: %w", owner, name, err)
+	}
+
+	var tok installationToken
+	if err := doBody(ctx, http.MethodPost, fmt.Sprintf("/app/installations/%d/access_tokens", inst.ID), "Bearer "+jwt, scope, &tok); err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
@@ -0,0 +1,133 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewApp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		pem  []byte
+		err  string
+	}{
+		{"pkcs1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), ""},
+		{"not pem", []byte("key"), "fail to decode GitHub App private key"},
+		{"pkcs8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), "x509"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewApp(1, c.pem)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("NewApp = %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("NewApp = %v, want an error with %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewApp(12345, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)
+	jwt, err := app.jwt(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("jwt has %d parts, want 3", len(parts))
+	}
+
+	enc := base64.RawURLEncoding
+	var header struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+	}
+	var claims struct {
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+	}
+	for i, v := range []interface{}{&header, &claims} {
+		b, err := enc.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if header.Alg != "RS256" || header.Typ != "JWT" {
+		t.Errorf("header = %+v", header)
+	}
+	// GitHub takes the app ID as a string and refuses tokens that live
+	// longer than 10 minutes
+	if claims.Iss != "12345" {
+		t.Errorf("iss = %q, want 12345", claims.Iss)
+	}
+	if claims.Iat > now.Unix() || claims.Exp <= now.Unix() || claims.Exp-claims.Iat > int64((10*time.Minute).Seconds()) {
+		t.Errorf("iat = %d, exp = %d, want a window of 10 minutes or less around %d", claims.Iat, claims.Exp, now.Unix())
+	}
+
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("signature is invalid: %s", err)
+	}
+}
+
+func TestCanPush(t *testing.T) {
+	cases := []struct {
+		permission string
+		push       bool
+	}{
+		{"admin", true},
+		{"write", true},
+		{"read", false},
+		{"none", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if got := CanPush(c.permission); got != c.push {
+			t.Errorf("CanPush(%q) = %t, want %t", c.permission, got, c.push)
+		}
+	}
+}
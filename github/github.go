@@ -0,0 +1,57 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+func do(ctx context.Context, method, path, auth string, v interface{}) error {
+	return doBody(ctx, method, path, auth, nil, v)
+}
+
+// doBody is do with a JSON request body, unless body is nil.
+func doBody(ctx context.Context, method, path, auth string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, apiURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error: GitHub API %s %s status=%d body=%s", method, path, resp.StatusCode, b)
+	}
+
+	return json.Unmarshal(b, v)
+}
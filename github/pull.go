@@ -0,0 +1,77 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var pullRequestRegexp = regexp.MustCompile(`^([A-Za-z0-9-]+)/([A-Za-z0-9._-]+)#(\d+)$`)
+
+type PullRequest struct {
+	Owner  string
+	Repo   string
+	Number int
+
+	// HeadRepo is the clone URL of the repository the changes are in,
+	// which is a fork for most pull requests
+	HeadRepo string
+	HeadRef  string
+	BaseRepo string
+}
+
+// ParsePullRequest parses a pull request in the format of owner/repo#123.
+func ParsePullRequest(s string) (owner, repo string, number int, err error) {
+	m := pullRequestRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", 0, fmt.Errorf("Please provide a pull request in the format of owner/repo#123")
+	}
+
+	number, err = strconv.Atoi(m[3])
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return m[1], m[2], number, nil
+}
+
+// GetPullRequest looks up a pull request. The token is optional for
+// public repositories.
+func GetPullRequest(ctx context.Context, owner, repo string, number int, token string) (*PullRequest, error) {
+	var pr struct {
+		Head struct {
+			Ref  string `json:"ref"`
+			Repo *struct {
+				CloneURL string `json:"clone_url"`
+			} `json:"repo"`
+		} `json:"head"`
+		Base struct {
+			Repo struct {
+				CloneURL string `json:"clone_url"`
+			} `json:"repo"`
+		} `json:"base"`
+	}
+
+	auth := ""
+	if token != "" {
+		auth = "token " + token
+	}
+
+	if err := do(ctx, "GET", fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, number), auth, &pr); err != nil {
+		return nil, err
+	}
+
+	if pr.Head.Repo == nil {
+		return nil, fmt.Errorf("error: head repository of %s/%s#%d is deleted", owner, repo, number)
+	}
+
+	return &PullRequest{
+		Owner:    owner,
+		Repo:     repo,
+		Number:   number,
+		HeadRepo: pr.Head.Repo.CloneURL,
+		HeadRef:  pr.Head.Ref,
+		BaseRepo: pr.Base.Repo.CloneURL,
+	}, nil
+}
@@ -0,0 +1,56 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ParseRepoURL parses a repository URL like https://github.com/owner/name.
+func ParseRepoURL(s string) (owner, name string, err error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host != "github.com" {
+		return "", "", fmt.Errorf("Please provide a GitHub repository URL")
+	}
+
+	return ParseRepo(strings.TrimSuffix(strings.TrimPrefix(path.Clean(u.Path), "/"), ".git"))
+}
+
+// TarballURL returns a URL that the tarball of a ref of a repository can be
+// downloaded from without credentials. Private repositories need a token,
+// and the URL GitHub redirects to with it expires after a few minutes.
+func TarballURL(ctx context.Context, owner, repo, ref, token string) (string, error) {
+	if token == "" {
+		return fmt.Sprintf("https://github.com/%s/%s/archive/%s.tar.gz", owner, repo, ref), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/repos/%s/%s/tarball/%s", apiURL, owner, repo, ref), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+token)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	loc := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || loc == "" {
+		return "", fmt.Errorf("error: fail to get tarball of %s/%s@%s status=%d", owner, repo, ref, resp.StatusCode)
+	}
+
+	return loc, nil
+}
@@ -0,0 +1,19 @@
+package github
+
+import (
+	"context"
+	"net/http"
+)
+
+// User returns the login of the GitHub user of a token, e.g. a personal
+// access token or one of gh auth token.
+func User(ctx context.Context, token string) (string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := do(ctx, http.MethodGet, "/user", "token "+token, &user); err != nil {
+		return "", err
+	}
+
+	return user.Login, nil
+}
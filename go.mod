@@ -1,6 +1,6 @@
 module github.com/jingweno/codeface
 
-go 1.14
+go 1.16
 
 require (
 	github.com/golang/protobuf v1.3.2 // indirect
@@ -12,6 +12,7 @@ require (
 	github.com/heroku/heroku-go/v5 v5.2.0
 	github.com/joeshaw/envdecode v0.0.0-20200121155833-099f1fc765bd
 	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/lib/pq v1.10.0
 	github.com/oklog/run v1.1.0
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4
 	github.com/rs/xid v1.2.1
@@ -20,8 +21,7 @@ require (
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
-	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b // indirect
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	golang.org/x/sys v0.0.0-20200501052902-10377860bb8e // indirect
 	gopkg.in/yaml.v2 v2.2.7 // indirect
 )
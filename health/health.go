@@ -0,0 +1,104 @@
+// Package health serves the liveness and the readiness of the server and
+// the worker, for Kubernetes probes or load balancers to restart or route
+// around instances that can't do their work.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+)
+
+const (
+	// checkTimeout is how long a dependency has to answer
+	checkTimeout = 5 * time.Second
+	// cacheTTL keeps frequent probes from spending the API rate limit of
+	// the provider
+	cacheTTL = 15 * time.Second
+	// readyKey is read to check the store, it's never written
+	readyKey = "health/ready"
+)
+
+// Checker checks the dependencies of an instance: the state store and the
+// provider.
+type Checker struct {
+	Store    store.Store
+	Provider provider.Provider
+	// Leader returns whether the instance leads, or nil if there's no
+	// leader
+	Leader func() *bool
+
+	mu        sync.Mutex
+	last      model.Readiness
+	checkedAt time.Time
+}
+
+// Ready returns the readiness of the instance, which is cached for a
+// while.
+func (c *Checker) Ready(ctx context.Context) model.Readiness {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if !c.checkedAt.IsZero() && now.Sub(c.checkedAt) < cacheTTL {
+		return c.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	r := model.Readiness{Ready: true, Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			r.Ready = false
+			r.Checks[name] = err.Error()
+			return
+		}
+		r.Checks[name] = "ok"
+	}
+
+	var v struct{}
+	err := c.Store.Get(ctx, readyKey, &v)
+	if err == store.ErrNotFound {
+		err = nil
+	}
+	check("store", err)
+
+	check("provider", provider.Ping(ctx, c.Provider))
+
+	if c.Leader != nil {
+		r.Leader = c.Leader()
+	}
+
+	c.last, c.checkedAt = r, now
+
+	return r
+}
+
+// HandleHealthz answers as long as the process serves requests.
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, "ok")
+}
+
+// HandleReadyz answers with the readiness of the instance, with a 503 if it
+// isn't ready.
+func (c *Checker) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := c.Ready(r.Context())
+
+	status := http.StatusOK
+	if !ready.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ready)
+}
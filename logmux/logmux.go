@@ -0,0 +1,105 @@
+// Package logmux writes the output of concurrent tasks, e.g. parallel
+// deploys, to one writer line by line, so that their lines don't break
+// each other up and each line tells which task it's from.
+package logmux
+
+import (
+	"io"
+	"sync"
+)
+
+// maxLineBytes is how long a line gets before it's written without its
+// end, so that output without newlines isn't kept in memory.
+const maxLineBytes = 64 << 10
+
+// Mux is the writer the tasks share.
+type Mux struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func New(w io.Writer) *Mux {
+	return &Mux{w: w}
+}
+
+// Writer returns the writer of a task, whose lines are prefixed with
+// prefix, e.g. the app a deploy builds. It has to be closed to write the
+// last line if it doesn't end with a newline.
+func (m *Mux) Writer(prefix string) *Writer {
+	return &Writer{m: m, prefix: []byte(prefix + " | ")}
+}
+
+func (m *Mux) writeLine(prefix, line []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// one write per line, so that lines of other writers of the same file,
+	// e.g. the log, aren't cut into either
+	b := make([]byte, 0, len(prefix)+len(line)+1)
+	b = append(append(append(b, prefix...), line...), '\n')
+	_, err := m.w.Write(b)
+
+	return err
+}
+
+// Writer buffers the output of a task until a line is complete. Progress
+// that's redrawn with carriage returns only keeps its last state.
+type Writer struct {
+	m      *Mux
+	prefix []byte
+
+	mu  sync.Mutex
+	buf []byte
+	// cr is set after a carriage return, which the rest of the line
+	// overwrites unless the line ends there
+	cr bool
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, b := range p {
+		switch b {
+		case '\n':
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		case '\r':
+			w.cr = true
+		default:
+			if w.cr {
+				w.buf = w.buf[:0]
+				w.cr = false
+			}
+			w.buf = append(w.buf, b)
+			if len(w.buf) >= maxLineBytes {
+				if err := w.flush(); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *Writer) flush() error {
+	err := w.m.writeLine(w.prefix, w.buf)
+	w.buf = w.buf[:0]
+	w.cr = false
+
+	return err
+}
+
+// Close writes what's left of the last line.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	return w.flush()
+}
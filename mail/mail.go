@@ -0,0 +1,116 @@
+// Package mail sends the emails of Codeface, e.g. the links of editors
+// that took long to claim and the invitations to the seats of batches,
+// through SMTP or Amazon SES. The messages are the templates in
+// templates/.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Templates of the messages, named after their file in templates/. The
+// ones of editors are named after the type of the notification they email.
+const (
+	TemplateEditorReady     = "editor.ready"
+	TemplateEditorIdle      = "editor.idle"
+	TemplateEditorExpiring  = "editor.expiring"
+	TemplateEditorReleased  = "editor.released"
+	TemplateBatchInvitation = "batch.invitation"
+)
+
+// templatesFS are the templates of the messages. Each one defines its
+// subject in a subject block, the rest of it is the plain text body.
+//
+//go:embed templates
+var templatesFS embed.FS
+
+// templates are parsed one by one, since their subject blocks share a
+// name.
+var templates = parseTemplates()
+
+func parseTemplates() map[string]*template.Template {
+	entries, err := templatesFS.ReadDir("templates")
+	if err != nil {
+		// the embedded directory always exists
+		panic(err)
+	}
+
+	ts := make(map[string]*template.Template)
+	for _, e := range entries {
+		ts[strings.TrimSuffix(e.Name(), ".tmpl")] = template.Must(template.ParseFS(templatesFS, "templates/"+e.Name()))
+	}
+
+	return ts
+}
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config is how emails are sent: through the SMTP server at SMTPAddr, or
+// through SES in SESRegion if SMTPAddr is empty.
+type Config struct {
+	From         string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	SESRegion    string
+}
+
+// New returns the mailer of a config, nil if emails aren't set up.
+func New(cfg Config) (Mailer, error) {
+	if cfg.SMTPAddr == "" && cfg.SESRegion == "" {
+		return nil, nil
+	}
+	if cfg.From == "" {
+		return nil, fmt.Errorf("error: MAIL_FROM is required by SMTP_ADDR and MAIL_SES_REGION")
+	}
+
+	if cfg.SMTPAddr != "" {
+		return &SMTP{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}, nil
+	}
+
+	return NewSES(cfg.SESRegion, cfg.From), nil
+}
+
+// Render renders the message of a template to a recipient.
+func Render(name, to string, data interface{}) (Message, error) {
+	t, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("error: mail template %s is not found", name)
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+
+	var subject bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(body.String()) + "\n",
+	}, nil
+}
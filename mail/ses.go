@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/jingweno/codeface/aws"
+)
+
+// SES sends emails through Amazon SES, with the credentials of the AWS
+// SDKs. The sender has to be verified in SES.
+type SES struct {
+	Client *aws.Client
+	From   string
+}
+
+// NewSES returns a mailer of SES in a region.
+func NewSES(region, from string) *SES {
+	return &SES{
+		Client: &aws.Client{
+			Service:     "ses",
+			Region:      region,
+			Credentials: aws.NewCredentialsChain(),
+			Endpoint:    fmt.Sprintf("https://email.%s.amazonaws.com/", region),
+		},
+		From: from,
+	}
+}
+
+func (s *SES) Send(ctx context.Context, msg Message) error {
+	params := url.Values{
+		"Source":                           {s.From},
+		"Destination.ToAddresses.member.1": {msg.To},
+		"Message.Subject.Data":             {msg.Subject},
+		"Message.Subject.Charset":          {"UTF-8"},
+		"Message.Body.Text.Data":           {msg.Body},
+		"Message.Body.Text.Charset":        {"UTF-8"},
+	}
+
+	return s.Client.Query(ctx, "SendEmail", "2010-12-01", params, nil)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bootBuckets are the upper bounds of the boot time histograms, in
+// seconds. Editors boot in tens of seconds to minutes, much slower than
+// API calls.
+var bootBuckets = []float64{5, 10, 20, 30, 45, 60, 90, 120, 180, 300, 600}
+
+// BootRecorder keeps the boot times of editors by template since the
+// process started.
+type BootRecorder struct {
+	mu        sync.Mutex
+	templates map[string]*histogram
+}
+
+// Boots records the boot times that the agents of editors report to the
+// server, from the claim of an editor until it serves.
+var Boots = NewBootRecorder()
+
+func NewBootRecorder() *BootRecorder {
+	return &BootRecorder{templates: make(map[string]*histogram)}
+}
+
+// Observe records the boot time of an editor of a template.
+func (r *BootRecorder) Observe(template string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.templates[template]
+	if !ok {
+		h = newHistogram(bootBuckets)
+		r.templates[template] = h
+	}
+
+	h.observe(d)
+}
+
+// Write writes the boot times in the Prometheus text format as a histogram
+// named name, e.g. codeface_editor_boot, along with its estimated p50 and
+// p95 for dashboards that don't compute quantiles.
+func (r *BootRecorder) Write(w io.Writer, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	templates := make([]string, 0, len(r.templates))
+	for t := range r.templates {
+		templates = append(templates, t)
+	}
+	sort.Strings(templates)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s_duration_seconds Time from the claim of an editor until it serves, by template.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s_duration_seconds histogram\n", name)
+	for _, t := range templates {
+		h := r.templates[t]
+		for i, ub := range h.bounds {
+			fmt.Fprintf(&b, "%s_duration_seconds_bucket{template=%q,le=\"%g\"} %d\n", name, t, ub, h.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_duration_seconds_bucket{template=%q,le=\"+Inf\"} %d\n", name, t, h.count)
+		fmt.Fprintf(&b, "%s_duration_seconds_sum{template=%q} %g\n", name, t, h.sum)
+		fmt.Fprintf(&b, "%s_duration_seconds_count{template=%q} %d\n", name, t, h.count)
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_quantile_seconds Estimated quantiles of the boot times, by template.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s_quantile_seconds gauge\n", name)
+	for _, t := range templates {
+		h := r.templates[t]
+		for _, q := range []float64{0.5, 0.95} {
+			fmt.Fprintf(&b, "%s_quantile_seconds{template=%q,quantile=\"%g\"} %g\n", name, t, q, h.quantile(q).Seconds())
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var startedAt = time.Now()
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} { return int64(time.Since(startedAt).Seconds()) }))
+	expvar.Publish("heroku_api", expvar.Func(func() interface{} { return Heroku.Summaries() }))
+}
+
+// DebugHandler serves the pprof profiles of the process under
+// /debug/pprof/ and its runtime stats, including the memory stats, at
+// /debug/vars. Callers send the token as a bearer token or as the password
+// of basic auth, e.g. go tool pprof https://:<token>@<host>/debug/pprof/heap.
+//
+// net/http/pprof and expvar register themselves on http.DefaultServeMux,
+// which must not be served.
+func DebugHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			cred = password
+		}
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(cred), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,248 @@
+// Package metrics measures the calls codeface makes to the Heroku API:
+// their latency and how they fail, by operation. They're exposed in the
+// Prometheus text format by the server and logged by the worker, so that
+// rate limits and failover cooldowns can be tuned with data. The server
+// also exposes how long the editors it claims take to boot, see Boots.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Classes of the outcome of an API call.
+const (
+	ClassOK          = "ok"
+	ClassRateLimited = "429"
+	ClassClient      = "4xx"
+	ClassServer      = "5xx"
+	ClassNetwork     = "network"
+	// ClassCanceled are calls whose context was canceled or timed out,
+	// which aren't the API's fault
+	ClassCanceled = "canceled"
+)
+
+var classes = []string{ClassOK, ClassRateLimited, ClassClient, ClassServer, ClassNetwork, ClassCanceled}
+
+// latencyBuckets are the upper bounds of the latency histograms, in
+// seconds.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// singletons are the resources of the Heroku API that aren't followed by
+// an ID, e.g. /account/rate-limits.
+var singletons = map[string]bool{
+	"account": true,
+}
+
+// Operation returns the operation of an API call, which is its method and
+// its path with the IDs and names left out, e.g.
+// GET /apps/{id}/formation/{id}.
+func Operation(method, path string) string {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	id := false
+	for i, s := range segs {
+		if id {
+			segs[i] = "{id}"
+			id = false
+			continue
+		}
+		id = !singletons[s]
+	}
+
+	return method + " /" + strings.Join(segs, "/")
+}
+
+// Class returns the class of the outcome of an API call.
+func Class(ctx context.Context, resp *http.Response, err error) string {
+	switch {
+	case err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)):
+		return ClassCanceled
+	case err != nil:
+		return ClassNetwork
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return ClassRateLimited
+	case resp.StatusCode >= 500:
+		return ClassServer
+	case resp.StatusCode >= 400:
+		return ClassClient
+	default:
+		return ClassOK
+	}
+}
+
+type histogram struct {
+	// bounds are the upper bounds of the buckets, in seconds
+	bounds  []float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	for i, b := range h.bounds {
+		if s <= b {
+			h.buckets[i]++
+		}
+	}
+	h.sum += s
+	h.count++
+}
+
+// quantile estimates a quantile from the buckets, as the upper bound of
+// the bucket it falls in.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	for i, n := range h.buckets {
+		if n >= rank {
+			return time.Duration(h.bounds[i] * float64(time.Second))
+		}
+	}
+
+	return time.Duration(h.bounds[len(h.bounds)-1] * float64(time.Second))
+}
+
+type opStats struct {
+	latency *histogram
+	classes map[string]uint64
+}
+
+// Recorder keeps the metrics of API calls since the process started.
+type Recorder struct {
+	mu  sync.Mutex
+	ops map[string]*opStats
+}
+
+// Heroku records the calls to the Heroku API.
+var Heroku = NewRecorder()
+
+func NewRecorder() *Recorder {
+	return &Recorder{ops: make(map[string]*opStats)}
+}
+
+// Observe records an API call.
+func (r *Recorder) Observe(op, class string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.ops[op]
+	if !ok {
+		s = &opStats{
+			latency: newHistogram(latencyBuckets),
+			classes: make(map[string]uint64),
+		}
+		r.ops[op] = s
+	}
+
+	s.latency.observe(d)
+	s.classes[class]++
+}
+
+// Summary is what's recorded of an operation.
+type Summary struct {
+	Operation string
+	Count     uint64
+	P50       time.Duration
+	P95       time.Duration
+	// Classes counts the calls by class
+	Classes map[string]uint64
+}
+
+// Summaries returns what's recorded by operation.
+func (r *Recorder) Summaries() []Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sums []Summary
+	for op, s := range r.ops {
+		classes := make(map[string]uint64, len(s.classes))
+		for c, n := range s.classes {
+			classes[c] = n
+		}
+
+		sums = append(sums, Summary{
+			Operation: op,
+			Count:     s.latency.count,
+			P50:       s.latency.quantile(0.5),
+			P95:       s.latency.quantile(0.95),
+			Classes:   classes,
+		})
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Operation < sums[j].Operation })
+
+	return sums
+}
+
+// Write writes the metrics in the Prometheus text format, prefixed by
+// name, e.g. heroku_api.
+func (r *Recorder) Write(w io.Writer, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ops := make([]string, 0, len(r.ops))
+	for op := range r.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s_requests_total API calls by operation and class of outcome.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s_requests_total counter\n", name)
+	for _, op := range ops {
+		for _, c := range classes {
+			if n := r.ops[op].classes[c]; n > 0 {
+				fmt.Fprintf(&b, "%s_requests_total{operation=%q,class=%q} %d\n", name, op, c, n)
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "# HELP %s_request_duration_seconds Latency of API calls by operation.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s_request_duration_seconds histogram\n", name)
+	for _, op := range ops {
+		h := r.ops[op].latency
+		for i, ub := range h.bounds {
+			fmt.Fprintf(&b, "%s_request_duration_seconds_bucket{operation=%q,le=\"%g\"} %d\n", name, op, ub, h.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_request_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", name, op, h.count)
+		fmt.Fprintf(&b, "%s_request_duration_seconds_sum{operation=%q} %g\n", name, op, h.sum)
+		fmt.Fprintf(&b, "%s_request_duration_seconds_count{operation=%q} %d\n", name, op, h.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Transport records the calls made through it to Heroku. Base is
+// http.DefaultTransport if it's nil.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	Heroku.Observe(Operation(req.Method, req.URL.Path), Class(req.Context(), resp, err), time.Since(start))
+
+	return resp, err
+}
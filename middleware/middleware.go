@@ -0,0 +1,194 @@
+// Package middleware is the hardened HTTP stack shared by the server, the
+// editor gateway and the editor proxy.
+package middleware
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// editors accept file uploads, so their limit is generous
+	EditorMaxRequestBytes = 64 << 20
+)
+
+type Options struct {
+	// ContentSecurityPolicy is sent on responses when set
+	ContentSecurityPolicy string
+	// FrameOptions is the X-Frame-Options header, e.g. DENY
+	FrameOptions string
+	// MaxRequestBytes limits request bodies, 0 for no limit
+	MaxRequestBytes int64
+}
+
+// Harden wraps a handler with the security headers, body limit, request
+// IDs and audit logging.
+func Harden(next http.Handler, opts Options, logger log.FieldLogger) http.Handler {
+	h := Headers(next, opts)
+	if opts.MaxRequestBytes > 0 {
+		h = LimitBody(h, opts.MaxRequestBytes)
+	}
+
+	return RequestID(Audit(h, logger))
+}
+
+// Server returns a server with timeouts against slow clients. There's no
+// write timeout as claims can take minutes and editors hold websockets
+// open. The read timeout leaves time to upload files to editors, and
+// doesn't cut proxied websockets since hijacking clears it.
+func Server(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       5 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    1 << 20,
+	}
+}
+
+// IsHTTPS returns whether the client connected over https, either
+// directly or to a proxy like the Heroku router.
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func Headers(next http.Handler, opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		if opts.FrameOptions != "" {
+			h.Set("X-Frame-Options", opts.FrameOptions)
+		}
+		if opts.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", opts.ContentSecurityPolicy)
+		}
+		// HSTS is ignored over plain HTTP, and would break local setups
+		if IsHTTPS(r) {
+			h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func LimitBody(next http.Handler, n int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestID makes sure requests have an X-Request-ID, such as the one set
+// by the Heroku router, and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 200 {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Audit logs a line per request.
+func Audit(next http.Handler, logger log.FieldLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		logger.WithFields(log.Fields{
+			"request_id": r.Header.Get(RequestIDHeader),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     sw.status,
+			"remote":     r.RemoteAddr,
+			"duration":   time.Since(start).String(),
+		}).Info("Request")
+	})
+}
+
+// CSRF rejects state changing requests authenticated by cookies that come
+// from another origin. Requests with an Authorization header are API
+// clients and can't be forged by browsers without a CORS preflight.
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Header.Get("Referer")
+		}
+
+		u, err := url.Parse(origin)
+		if origin == "" || err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross-origin request is forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is needed to proxy websockets.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("error: hijacking is not supported")
+	}
+
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
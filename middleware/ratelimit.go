@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket per key, e.g. per client IP. Buckets hold up
+// to burst requests and refill at rate requests per second.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+type bucket struct {
+	tokens float64
+	seenAt time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a request off the bucket of key. Requests over the limit
+// aren't allowed and get how long to wait for the next one.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, seenAt: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.seenAt).Seconds()*l.rate)
+	b.seenAt = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the buckets that are full again, which are the same as new
+// ones, so that clients that are gone don't pile up.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < time.Minute {
+		return
+	}
+	l.sweptAt = now
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.seenAt) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
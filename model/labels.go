@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxLabels is how many labels a claim may have
+	MaxLabels = 20
+	// MaxLabelValueLength is how long the value of a label may be
+	MaxLabelValueLength = 256
+)
+
+// labelKeyRegexp allows keys like ticket, ci.run-id or team_name.
+var labelKeyRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// ValidateLabels checks the labels attached to a claim, e.g. the ticket it's
+// for or the ID of a CI run.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("Please provide at most %d labels", MaxLabels)
+	}
+
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return fmt.Errorf("Please provide label keys of lowercase letters, digits, ., _ and -, %q isn't one", k)
+		}
+		if len(v) > MaxLabelValueLength {
+			return fmt.Errorf("Please provide label values of at most %d characters, the one of %s is longer", MaxLabelValueLength, k)
+		}
+	}
+
+	return nil
+}
+
+// ParseLabelSelector parses key=value pairs that labels are matched with,
+// e.g. the label query parameters of listed editors.
+func ParseLabelSelector(pairs []string) (map[string]string, error) {
+	sel := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Please provide labels as key=value, %q isn't one", p)
+		}
+		sel[kv[0]] = kv[1]
+	}
+
+	return sel, nil
+}
+
+// MatchLabels reports whether labels have every key and value of sel.
+func MatchLabels(labels, sel map[string]string) bool {
+	for k, v := range sel {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FormatLabels formats labels as key=value pairs sorted by key and
+// separated by ;, e.g. for CSV exports.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ";")
+}
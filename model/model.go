@@ -6,10 +6,105 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 )
 
 type EditorRequest struct {
+	// GitRepo is optional, editors claimed without a repository start with
+	// the default repositories of their template
 	GitRepo string
+	GitAuth *GitAuth `json:",omitempty"`
+	// GitRef is the branch, tag or commit checked out, the default branch
+	// if empty
+	GitRef string `json:",omitempty"`
+	// GitPath is the subdirectory of the repository the editor opens, e.g.
+	// a package of a monorepo. Only the subdirectory is checked out.
+	GitPath string `json:",omitempty"`
+	// Clone overrides how the template clones the repository
+	Clone *CloneOptions `json:",omitempty"`
+	// PullRequest is in the format of owner/repo#123 and takes
+	// precedence over GitRepo
+	PullRequest string            `json:",omitempty"`
+	Env         map[string]string `json:",omitempty"`
+	// Template is the template of the editor, any if empty
+	Template string `json:",omitempty"`
+	// DynoSize is the Heroku dyno size of the editor, e.g. performance-m,
+	// the size of the template if empty
+	DynoSize string `json:",omitempty"`
+	// Labels are attached to the claim and kept with its session, e.g. the
+	// ticket it's for or the ID of a CI run, see ValidateLabels
+	Labels map[string]string `json:",omitempty"`
+	// VanityName serves the editor at #{VanityName}.#{VANITY_DOMAIN} on top
+	// of its app name, e.g. alice-payments, see ValidateVanityName
+	VanityName string `json:",omitempty"`
+}
+
+func (r *EditorRequest) Validate() error {
+	if r.GitAuth != nil && r.GitRepo == "" {
+		return fmt.Errorf("Please provide the repository the credentials are for")
+	}
+	if r.Clone != nil {
+		if err := r.Clone.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.GitPath != "" {
+		if r.GitRepo == "" && r.PullRequest == "" {
+			return fmt.Errorf("Please provide the repository the path is in")
+		}
+
+		p, err := CleanGitPath(r.GitPath)
+		if err != nil {
+			return err
+		}
+		r.GitPath = p
+	}
+	if r.DynoSize != "" {
+		r.DynoSize = strings.ToLower(r.DynoSize)
+		if DynoSizeRank(r.DynoSize) < 0 {
+			return fmt.Errorf("Please provide a dyno size of %s", strings.Join(DynoSizes, ", "))
+		}
+	}
+	if err := ValidateLabels(r.Labels); err != nil {
+		return err
+	}
+	if r.VanityName != "" {
+		r.VanityName = strings.ToLower(r.VanityName)
+		if err := ValidateVanityName(r.VanityName); err != nil {
+			return err
+		}
+	}
+	if r.GitAuth != nil {
+		return r.GitAuth.validate()
+	}
+
+	return nil
+}
+
+// DynoSizes are the Heroku dyno sizes editors may run on, from the
+// smallest to the largest.
+var DynoSizes = []string{
+	"eco",
+	"basic",
+	"standard-1x",
+	"standard-2x",
+	"performance-m",
+	"performance-l",
+	"performance-l-ram",
+	"performance-xl",
+	"performance-2xl",
+}
+
+// DynoSizeRank returns the position of a dyno size in DynoSizes, or -1 if
+// it's unknown.
+func DynoSizeRank(size string) int {
+	for i, s := range DynoSizes {
+		if strings.EqualFold(s, size) {
+			return i
+		}
+	}
+
+	return -1
 }
 
 func ParseGitHubRepoURL(s string) (string, error) {
@@ -44,8 +139,1221 @@ func ParseGitHubRepoURL(s string) (string, error) {
 
 type EditorResponse struct {
 	URL string
+	// Labels echo the labels of the claim
+	Labels map[string]string `json:",omitempty"`
+	// Approval is set instead of URL when the claim waits for an admin to
+	// approve it
+	Approval *ClaimApproval `json:",omitempty"`
+}
+
+// RunRequest claims a headless editor that runs Command instead of
+// code-server, e.g. a CI job, and is released once the command exits.
+type RunRequest struct {
+	EditorRequest
+	Command string
+}
+
+func (r *RunRequest) Validate() error {
+	if strings.TrimSpace(r.Command) == "" {
+		return fmt.Errorf("Please provide the command to run")
+	}
+
+	return r.EditorRequest.Validate()
+}
+
+// Run is a command run by a headless editor.
+type Run struct {
+	Editor    string
+	User      string
+	Template  string
+	Command   string
+	StartedAt time.Time
+	// FinishedAt and ExitCode are set once the command exited
+	FinishedAt *time.Time `json:",omitempty"`
+	ExitCode   *int       `json:",omitempty"`
+}
+
+// RunResult is reported by the agent of a headless editor when its command
+// exits.
+type RunResult struct {
+	ExitCode int
 }
 
 type ErrorResponse struct {
 	Error string
 }
+
+const (
+	EditorStateUp         = "up"
+	EditorStateCrashed    = "crashed"
+	EditorStateRestarting = "restarting"
+	EditorStateFailed     = "failed"
+)
+
+type EditorStatus struct {
+	Name          string
+	URL           string
+	State         string
+	Restarts      int
+	LastCrashAt   time.Time
+	NextRestartAt time.Time
+	Message       string
+	// Resources is the last reported resource usage of the editor
+	Resources *ResourceUsage `json:",omitempty"`
+}
+
+// GuestUser is the user of the sessions of guest editors, which are
+// claimed without logging in.
+const GuestUser = "guest"
+
+type Session struct {
+	App string
+	// Provider is empty for sessions started before providers were added,
+	// which are all on Heroku
+	Provider  string `json:",omitempty"`
+	User      string
+	Template  string
+	GitRepo   string
+	StartedAt time.Time
+	EndedAt   *time.Time
+	// Suspended is set on the ended session of a suspended editor
+	Suspended bool `json:",omitempty"`
+	// ClaimedAt is when the editor was claimed, sessions restart when an
+	// editor is transferred or resumed
+	ClaimedAt time.Time
+	// Batch is the batch the editor was provisioned in, if any
+	Batch string `json:",omitempty"`
+	// Labels are the labels of the claim, see EditorRequest
+	Labels map[string]string `json:",omitempty"`
+	// ReadyAt is when the agent of the editor first found it serving, the
+	// boot time of the editor is from ClaimedAt to then
+	ReadyAt *time.Time `json:",omitempty"`
+}
+
+type Usage struct {
+	User            string
+	Template        string
+	Day             string
+	EditorHours     float64
+	Claims          int
+	AvgSessionHours float64
+}
+
+type UsageResponse struct {
+	Usage []Usage
+	// Boots are the boot times of the claims of the usage by template
+	Boots []BootTimes
+}
+
+// BootTimes are how long editors of a template took from their claim to
+// serving.
+type BootTimes struct {
+	Template string
+	Boots    int
+	P50      time.Duration
+	P95      time.Duration
+}
+
+// BootSLO is the boot time objective of the pool of a template, see
+// BOOT_SLO of the worker. While it's breached, the pool is grown by
+// Boost editors on top of its size.
+type BootSLO struct {
+	Template   string
+	Objective  time.Duration
+	Percentile int
+	// Observed is the percentile of the boots the objective was last
+	// checked with
+	Observed time.Duration
+	Boots    int
+	Breached bool
+	// BreachedAt is when the current breach started
+	BreachedAt *time.Time `json:",omitempty"`
+	Boost      int
+	// ChangedAt is when Boost last changed, only boots of claims after it
+	// are checked
+	ChangedAt time.Time
+	CheckedAt time.Time
+}
+
+const (
+	CanaryStateRunning = "running"
+	CanaryStatePassed  = "passed"
+	CanaryStateFailed  = "failed"
+)
+
+// Canary is a synthetic claim of an editor of a new version of the pool of
+// a template, see CANARY_REPO of the worker. The editors of older versions
+// are only replaced once it passed.
+type Canary struct {
+	Template string
+	Version  string
+	State    string
+	Editor   string
+	Provider string
+	// URL is the URL the editor is claimed with
+	URL       string
+	StartedAt time.Time
+	// CompletedAt is when it passed or failed
+	CompletedAt *time.Time `json:",omitempty"`
+	Error       string     `json:",omitempty"`
+	Attempts    int
+}
+
+type PoolEditor struct {
+	Name     string
+	Provider string
+	Template string
+	// Outdated editors are of a previous version and are being replaced
+	Outdated bool
+	// Warm editors are kept running, see WarmEditor
+	Warm bool `json:",omitempty"`
+}
+
+// States of listed editors.
+const (
+	EditorStateIdle    = "idle"
+	EditorStateClaimed = "claimed"
+)
+
+// EditorSummary is an editor in a listing of the pool and claimed editors.
+type EditorSummary struct {
+	Name     string
+	Provider string
+	Template string
+	State    string
+	// Owner and ClaimedAt are set on claimed editors
+	Owner     string     `json:",omitempty"`
+	ClaimedAt *time.Time `json:",omitempty"`
+	// LastRequestAt is set on claimed editors whose router logs are
+	// drained to the server
+	LastRequestAt *time.Time `json:",omitempty"`
+	// Outdated idle editors are of a previous version and are being replaced
+	Outdated bool `json:",omitempty"`
+	// Labels are the labels of the claim of claimed editors
+	Labels map[string]string `json:",omitempty"`
+}
+
+// BulkDeleteResponse lists the editors a bulk delete deleted, or the ones
+// it would delete on a dry run.
+type BulkDeleteResponse struct {
+	DryRun  bool
+	Editors []EditorSummary
+	// Failed are the editors that matched but couldn't be deleted
+	Failed []BulkDeleteFailure `json:",omitempty"`
+}
+
+type BulkDeleteFailure struct {
+	Name  string
+	Error string
+}
+
+type EditorsResponse struct {
+	Editors []EditorSummary
+	// NextCursor fetches the next page, it's empty on the last page
+	NextCursor string `json:",omitempty"`
+}
+
+const (
+	DeployKindPool      = "pool"
+	DeployKindPreview   = "preview"
+	DeployKindArtifact  = "artifact"
+	DeployKindPromotion = "promotion"
+
+	DeployStatusBuilding  = "building"
+	DeployStatusSucceeded = "succeeded"
+	DeployStatusFailed    = "failed"
+)
+
+// Deploy is a build of a template onto an editor app.
+type Deploy struct {
+	ID         string
+	App        string
+	Template   string
+	Kind       string
+	Status     string
+	Error      string `json:",omitempty"`
+	StartedAt  time.Time
+	FinishedAt *time.Time `json:",omitempty"`
+	// Output is the tail of the build and release output, it's left out of
+	// lists of deploys
+	Output string `json:",omitempty"`
+	// Result is how long the phases of the deploy took
+	Result *DeployResult `json:",omitempty"`
+}
+
+// DeployResult breaks down how long a deploy took by phase, so that slow
+// phases can be pinpointed. Phases a deploy doesn't go through are 0, e.g.
+// the tarball of deploys from git, which Heroku downloads itself.
+type DeployResult struct {
+	// Tarball is rendering and compressing the template
+	Tarball time.Duration
+	// Upload is uploading the tarball to Heroku
+	Upload time.Duration
+	// Build is building the tarball until the build output ends
+	Build time.Duration
+	// Release is waiting for the release and the output of its release
+	// phase, or promoting the release of an artifact
+	Release time.Duration
+	// Readiness is making the app ready to be claimed, e.g. scaling it
+	// down and marking it idle for the pool
+	Readiness time.Duration
+	// Total is the whole deploy, from StartedAt to FinishedAt
+	Total time.Duration
+}
+
+// Quarantine is the app of a failed pool deploy, which is kept with the
+// output of the deploy until ExpiresAt instead of being deleted.
+type Quarantine struct {
+	App           string
+	Template      string
+	Deploy        string
+	Error         string
+	Output        string `json:",omitempty"`
+	QuarantinedAt time.Time
+	ExpiresAt     time.Time
+}
+
+// WarmEditor is an idle editor that's kept running and pinged so that
+// claims don't wait for its dyno to start, see KEEP_WARM_EDITORS of the
+// worker.
+type WarmEditor struct {
+	Editor     string
+	Template   string
+	WarmedAt   time.Time
+	PingedAt   *time.Time `json:",omitempty"`
+	NextPingAt time.Time
+	// Failures are the pings in a row that failed
+	Failures int `json:",omitempty"`
+	// AccountedAt is when the dyno hours of the editor were last added to
+	// its KeepWarmUsage
+	AccountedAt time.Time
+}
+
+// KeepWarmUsage is what keeping idle editors warm cost on a UTC day.
+type KeepWarmUsage struct {
+	Day         string
+	DynoHours   float64
+	Pings       int
+	FailedPings int `json:",omitempty"`
+}
+
+// Artifact is a build of a version of a template, which pool editors are
+// promoted from instead of being built one by one.
+type Artifact struct {
+	Template string
+	// Version is the digest of the manifest of the template
+	Version string
+	// Kind is where the artifact is kept, see the artifact package
+	Kind  string
+	Stack string `json:",omitempty"`
+	// Manifest is the JSON encoded manifest of the template
+	Manifest string `json:",omitempty"`
+	// Ref is the builder app of Heroku artifacts, the image of OCI
+	// artifacts and the object key of S3 artifacts
+	Ref string
+	// Pipeline and Release are set on Heroku artifacts
+	Pipeline string `json:",omitempty"`
+	Release  string `json:",omitempty"`
+	// Digest is the SHA-256 of the tarball of S3 artifacts
+	Digest string `json:",omitempty"`
+	// Signature is an Ed25519 signature of the artifact, see
+	// artifact.Signer
+	Signature string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// ScanReport is the vulnerability scan of an artifact, which isn't
+// promoted if the scan found any unless an admin overrode it.
+type ScanReport struct {
+	Template string
+	Version  string
+	// Severity are the severities scanned for, e.g. CRITICAL
+	Severity        string
+	Targets         []string
+	Vulnerabilities []Vulnerability
+	ScannedAt       time.Time
+	OverriddenBy    string     `json:",omitempty"`
+	OverriddenAt    *time.Time `json:",omitempty"`
+}
+
+type Vulnerability struct {
+	ID string
+	// Target is the scanned image or directory, followed by the file or
+	// the OS packages the vulnerability is found in
+	Target           string
+	Package          string
+	InstalledVersion string
+	FixedVersion     string `json:",omitempty"`
+	Severity         string
+	Title            string `json:",omitempty"`
+}
+
+// Promotion is the artifact of a template that pool editors are promoted
+// from.
+// Environments that pools are run in. Pools without an environment are
+// promoted like staging ones.
+const (
+	EnvironmentStaging    = "staging"
+	EnvironmentProduction = "production"
+)
+
+type Promotion struct {
+	Template   string
+	Version    string
+	PromotedAt time.Time
+	// Pinned promotions are rollbacks, which aren't replaced by newer
+	// builds of the template until they're unpinned
+	Pinned bool
+	// Environment is production for the promotions of production pools,
+	// which are promoted from staging by admins
+	Environment string `json:",omitempty"`
+	PromotedBy  string `json:",omitempty"`
+}
+
+type ArtifactsResponse struct {
+	Promotion *Promotion `json:",omitempty"`
+	// Production is the promotion of the production pool, if any
+	Production *Promotion `json:",omitempty"`
+	Artifacts  []Artifact
+}
+
+// Readiness is whether a server or a worker can do its work, by what it
+// depends on.
+type Readiness struct {
+	Ready bool
+	// Checks are ok or the error of each dependency, e.g. store
+	Checks map[string]string
+	// Leader is whether a sharded worker runs the duties that aren't per
+	// template
+	Leader *bool `json:",omitempty"`
+}
+
+// Flag ramps a behavior up to a percentage of users, optionally only on
+// some templates. Users it's turned on for are always in the ramp.
+type Flag struct {
+	Name      string
+	Percent   int
+	Templates []string `json:",omitempty"`
+	Users     []string `json:",omitempty"`
+	UpdatedBy string   `json:",omitempty"`
+	UpdatedAt time.Time
+}
+
+// PromoteRequest pins the pool to an artifact, or unpins it with an empty
+// version.
+type PromoteRequest struct {
+	// Version is pinned, or else the pin is removed
+	Version string `json:",omitempty"`
+}
+
+type DeploysResponse struct {
+	Deploys []Deploy
+}
+
+type PoolResponse struct {
+	Provider    string
+	Editors     []PoolEditor
+	Maintenance *Maintenance `json:",omitempty"`
+	// KeepWarm is what keeping editors warm cost today, if any are
+	KeepWarm *KeepWarmUsage `json:",omitempty"`
+}
+
+// AppCap is kept while the fleet reached MAX_TOTAL_APPS of the worker and
+// its pools need more editors than the cap allows. The worker deploys no
+// editor beyond the cap, and the server refuses claims until the fleet is
+// below it again.
+type AppCap struct {
+	Apps      int
+	Max       int
+	ReachedAt time.Time
+	// RetryAfter is how long until the cap is checked again
+	RetryAfter time.Duration
+}
+
+// Maintenance is a run of the pool maintenance in a maintenance window, in
+// which idle editors are replaced by ones deployed from the newest image.
+type Maintenance struct {
+	Window      string
+	WindowStart time.Time
+	WindowEnd   time.Time
+	StartedAt   time.Time
+	CompletedAt *time.Time `json:",omitempty"`
+	// UpdateError is set when the newest image couldn't be fetched
+	UpdateError string `json:",omitempty"`
+	// Pending editors are still to be recycled
+	Pending  []string
+	Recycled []string
+	Failed   []string
+	// Skipped editors were claimed before they were recycled, or were left
+	// when the window closed
+	Skipped []string
+}
+
+type SessionsResponse struct {
+	Sessions []Session
+	// Resources are the last reported resource usage of the editors of
+	// the sessions, by editor
+	Resources map[string]ResourceUsage `json:",omitempty"`
+}
+
+// Capabilities are the features supported by the provider editors run
+// on, so that clients can hide options that aren't supported.
+type Capabilities struct {
+	Provider string
+	// Regions editors can run in, empty when the provider has no regions
+	Regions []string
+	// PersistentDisk is whether files outlive an editor restart
+	PersistentDisk bool
+	// ScaleToZero is whether idle claimed editors stop running
+	ScaleToZero bool
+	// CrashRestarts is whether crashed editors are restarted by the worker
+	CrashRestarts bool
+	// DynoSizes is whether claims may pick the dyno size of the editor
+	DynoSizes bool
+	// CustomDomains is whether claimed editors may be served at a vanity
+	// name
+	CustomDomains bool
+	// ViewerLinks is whether editors run the code-server of viewer links as
+	// a user of its own, which can't write to the workspace
+	ViewerLinks bool
+	// EgressDeny is whether editors are kept from connecting to the CIDRs
+	// of EDITOR_EGRESS_DENY by the network, not only by their proxy
+	EgressDeny bool
+}
+
+type PrebuildRequest struct {
+	GitRepo string
+	Commit  string
+	// Object is set when completing an uploaded prebuild
+	Object string `json:",omitempty"`
+}
+
+func (r *PrebuildRequest) Validate() error {
+	if r.GitRepo == "" || r.Commit == "" {
+		return fmt.Errorf("Please provide a repository and a commit")
+	}
+
+	return nil
+}
+
+type PrebuildResponse struct {
+	Object    string
+	UploadURL string
+	ExpiresAt time.Time
+}
+
+// DiskUsage is reported by the agent of a claimed editor.
+type DiskUsage struct {
+	Editor string `json:",omitempty"`
+	// WorkspaceBytes is the size of the project directory
+	WorkspaceBytes int64
+	// UsedBytes and TotalBytes are of the filesystem the workspace is on,
+	// e.g. the ephemeral disk of a dyno
+	UsedBytes  int64
+	TotalBytes int64
+	ReportedAt time.Time
+	// Warning is set when the disk is nearly full
+	Warning string `json:",omitempty"`
+}
+
+func (du *DiskUsage) Validate() error {
+	if du.WorkspaceBytes < 0 || du.UsedBytes < 0 || du.TotalBytes < 0 {
+		return fmt.Errorf("error: disk usage can't be negative")
+	}
+
+	return nil
+}
+
+type DiskUsageResponse struct {
+	Warning string `json:",omitempty"`
+	// Cleanup asks the agent to run the cleanup hook of the editor
+	Cleanup bool
+}
+
+// ResourceUsage is the memory and CPU utilization of an editor, as
+// reported by its agent. Peaks are of every report of the editor.
+type ResourceUsage struct {
+	Editor   string `json:",omitempty"`
+	Template string `json:",omitempty"`
+	// MemoryLimitBytes is the memory of the dyno or container
+	MemoryBytes      int64
+	MemoryLimitBytes int64
+	// CPUPercent is of a single core since the previous report
+	CPUPercent      float64
+	PeakMemoryBytes int64
+	PeakCPUPercent  float64
+	ReportedAt      time.Time
+	// Warning is set when memory is nearly used up
+	Warning string `json:",omitempty"`
+}
+
+func (ru *ResourceUsage) Validate() error {
+	if ru.MemoryBytes < 0 || ru.MemoryLimitBytes < 0 || ru.CPUPercent < 0 {
+		return fmt.Errorf("error: resource usage can't be negative")
+	}
+
+	return nil
+}
+
+// TemplateResources sums up the resource usage of the claimed editors of
+// a template, to size the dynos of the template.
+type TemplateResources struct {
+	Template         string
+	Editors          int
+	AvgMemoryBytes   int64
+	PeakMemoryBytes  int64
+	MemoryLimitBytes int64
+	AvgCPUPercent    float64
+	PeakCPUPercent   float64
+}
+
+type ResourcesResponse struct {
+	Templates []TemplateResources
+}
+
+// GitCredential is a short-lived credential for the repository of an
+// editor, handed out to the git credential helper of its agent.
+type GitCredential struct {
+	Username  string
+	Password  string
+	ExpiresAt time.Time
+}
+
+type TransferRequest struct {
+	Recipient string
+}
+
+func (r *TransferRequest) Validate() error {
+	if strings.TrimSpace(r.Recipient) == "" {
+		return fmt.Errorf("Please provide a recipient")
+	}
+
+	return nil
+}
+
+// Transfer is a pending handover of a claimed editor to another user, who
+// has to accept it.
+type Transfer struct {
+	Editor    string
+	From      string
+	To        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// HerokuTransfer is the app transfer of editors on Heroku
+	HerokuTransfer string `json:",omitempty"`
+}
+
+const (
+	ApprovalStatePending  = "pending"
+	ApprovalStateApproved = "approved"
+	ApprovalStateDenied   = "denied"
+	ApprovalStateReady    = "ready"
+	ApprovalStateFailed   = "failed"
+)
+
+// ClaimApproval is a claim that waits for an admin to approve it, e.g. of
+// a large dyno size. The editor is claimed once it's approved.
+type ClaimApproval struct {
+	ID       string
+	User     string
+	Template string `json:",omitempty"`
+	DynoSize string `json:",omitempty"`
+	GitRepo  string `json:",omitempty"`
+	// Reason is why the claim needs approval
+	Reason    string
+	State     string
+	CreatedAt time.Time
+	DecidedBy string     `json:",omitempty"`
+	DecidedAt *time.Time `json:",omitempty"`
+	Editor    string     `json:",omitempty"`
+	URL       string     `json:",omitempty"`
+	Error     string     `json:",omitempty"`
+}
+
+type ApprovalsResponse struct {
+	Approvals []ClaimApproval
+}
+
+const (
+	ClaimStatePending   = "pending"
+	ClaimStateReady     = "ready"
+	ClaimStateFailed    = "failed"
+	ClaimStateCanceled  = "canceled"
+	ClaimStateAbandoned = "abandoned"
+)
+
+// Claim is an editor claimed in the background with POST /v1/claims, which
+// clients poll instead of waiting on a cold deploy in one request. They can
+// poll it again after a disconnect, or cancel it. Claims that aren't polled
+// for long are abandoned and their editors released.
+type Claim struct {
+	ID        string
+	User      string
+	Template  string `json:",omitempty"`
+	State     string
+	CreatedAt time.Time
+	// PolledAt is when the user last got the claim
+	PolledAt    time.Time
+	CompletedAt *time.Time        `json:",omitempty"`
+	Editor      string            `json:",omitempty"`
+	URL         string            `json:",omitempty"`
+	Labels      map[string]string `json:",omitempty"`
+	Error       string            `json:",omitempty"`
+}
+
+// Types of notifications pushed to users, see Notification.
+const (
+	NotificationEditorReady    = "editor.ready"
+	NotificationEditorIdle     = "editor.idle"
+	NotificationEditorExpiring = "editor.expiring"
+	NotificationEditorReleased = "editor.released"
+)
+
+// Notification is a change of the state of an editor that's pushed to its
+// user over GET /v1/notifications, e.g. that it's about to be released.
+type Notification struct {
+	ID      string
+	Type    string
+	User    string
+	Editor  string
+	Message string
+	URL     string `json:",omitempty"`
+	// ReleaseAt is when the editor is released, for idle and expiring
+	// editors
+	ReleaseAt *time.Time `json:",omitempty"`
+	At        time.Time
+}
+
+// Ways notifications are delivered, see NotificationSettings. Every
+// notification is shown in the editor, the dashboard and cf notifications,
+// the other ways deliver it on top.
+const (
+	DeliveryIDE   = "ide"
+	DeliverySlack = "slack"
+	DeliveryEmail = "email"
+)
+
+// Deliveries are the ways notifications are delivered.
+var Deliveries = []string{DeliveryIDE, DeliverySlack, DeliveryEmail}
+
+// NotificationSettings are how a user is told about their editors.
+type NotificationSettings struct {
+	// IdleWarnings is how the user is warned that an editor is idle or
+	// reaches its maximum session duration
+	IdleWarnings string
+	// RecycleNotices is how the user is told that an editor was released
+	RecycleNotices string
+}
+
+// Delivery returns how notifications of a type are delivered.
+func (s NotificationSettings) Delivery(typ string) string {
+	switch typ {
+	case NotificationEditorIdle, NotificationEditorExpiring:
+		return s.IdleWarnings
+	case NotificationEditorReleased:
+		return s.RecycleNotices
+	}
+
+	return DeliveryIDE
+}
+
+func (s *NotificationSettings) Validate() error {
+	for _, d := range []string{s.IdleWarnings, s.RecycleNotices} {
+		if !validDelivery(d) {
+			return fmt.Errorf("Please provide a delivery of %s", strings.Join(Deliveries, ", "))
+		}
+	}
+
+	return nil
+}
+
+func validDelivery(d string) bool {
+	for _, v := range Deliveries {
+		if d == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UserSettings are the settings of a user, GET and PUT /v1/settings.
+type UserSettings struct {
+	Notifications NotificationSettings
+	UpdatedAt     *time.Time `json:",omitempty"`
+}
+
+// DefaultUserSettings are the settings of users that haven't changed them,
+// notifications are only shown in the editor.
+func DefaultUserSettings() UserSettings {
+	return UserSettings{
+		Notifications: NotificationSettings{
+			IdleWarnings:   DeliveryIDE,
+			RecycleNotices: DeliveryIDE,
+		},
+	}
+}
+
+func (s *UserSettings) Validate() error {
+	return s.Notifications.Validate()
+}
+
+// HerokuKeyRequest stores a Heroku API key of the user, e.g. one from
+// heroku authorizations:create.
+type HerokuKeyRequest struct {
+	APIKey string
+}
+
+func (r *HerokuKeyRequest) Validate() error {
+	if r.APIKey == "" {
+		return fmt.Errorf("Please provide a Heroku API key")
+	}
+
+	return nil
+}
+
+// GitHubTokenRequest links the GitHub account of a token to the user, e.g.
+// a token of gh auth token. The token itself isn't kept.
+type GitHubTokenRequest struct {
+	Token string
+}
+
+func (r *GitHubTokenRequest) Validate() error {
+	if r.Token == "" {
+		return fmt.Errorf("Please provide a GitHub token")
+	}
+
+	return nil
+}
+
+// GitHubAccount is the GitHub account linked to a user, whose permission
+// on a repository is checked before its tokens and caches are handed out.
+type GitHubAccount struct {
+	Login    string
+	LinkedAt time.Time
+}
+
+type ImpersonationRequest struct {
+	User   string
+	Reason string
+}
+
+func (r *ImpersonationRequest) Validate() error {
+	r.User = strings.TrimSpace(r.User)
+	if r.User == "" || !strings.Contains(r.User, "@") {
+		return fmt.Errorf("Please provide the email of the user to impersonate")
+	}
+	if r.Reason == "" {
+		return fmt.Errorf("Please provide a reason, e.g. the support ticket")
+	}
+
+	return nil
+}
+
+// Impersonation lets a support user act as another user until it expires.
+// Token is only returned when it's created.
+type Impersonation struct {
+	ID        string
+	Support   string
+	User      string
+	Reason    string
+	Token     string `json:",omitempty"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time `json:",omitempty"`
+	// Requests are the requests made with the impersonation, they're only
+	// returned for a single impersonation
+	Requests []ImpersonatedRequest `json:",omitempty"`
+}
+
+// ImpersonatedRequest is the audit record of a request made with an
+// impersonation.
+type ImpersonatedRequest struct {
+	RequestID string
+	Method    string
+	Path      string
+	At        time.Time
+}
+
+type ImpersonationsResponse struct {
+	Impersonations []Impersonation
+}
+
+// ViewerLink lets anyone with its URL watch an editor read-only, without
+// a terminal, until it expires or the editor changes hands. URL is only
+// returned when it's created.
+type ViewerLink struct {
+	ID        string
+	Editor    string
+	URL       string `json:",omitempty"`
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type ViewerLinksResponse struct {
+	ViewerLinks []ViewerLink
+}
+
+// DeviceCode starts the login of a device such as the CLI. The user
+// enters UserCode at VerificationURL in a browser, while the device polls
+// for its token with DeviceCode.
+type DeviceCode struct {
+	DeviceCode      string
+	UserCode        string
+	VerificationURL string
+	ExpiresAt       time.Time
+	// Interval is the seconds to wait between polls
+	Interval int
+}
+
+type DeviceTokenRequest struct {
+	DeviceCode string
+}
+
+func (r *DeviceTokenRequest) Validate() error {
+	if r.DeviceCode == "" {
+		return fmt.Errorf("Please provide a device code")
+	}
+
+	return nil
+}
+
+type RefreshRequest struct {
+	RefreshToken string
+}
+
+func (r *RefreshRequest) Validate() error {
+	if r.RefreshToken == "" {
+		return fmt.Errorf("Please provide a refresh token")
+	}
+
+	return nil
+}
+
+// Token is the Heroku OAuth token a device authenticates with. Pending is
+// set while the user hasn't approved the device yet.
+type Token struct {
+	Pending      bool   `json:",omitempty"`
+	AccessToken  string `json:",omitempty"`
+	RefreshToken string `json:",omitempty"`
+	Expiry       time.Time
+	User         string `json:",omitempty"`
+}
+
+type BatchRequest struct {
+	// Template is the template of the editors, or empty for any
+	Template string `json:",omitempty"`
+	Count    int
+	GitRepo  string `json:",omitempty"`
+	GitRef   string `json:",omitempty"`
+	// Invitees are emailed the seat links of the editors, in the order of
+	// the seats, e.g. the attendees of a workshop
+	Invitees []string `json:",omitempty"`
+}
+
+func (r *BatchRequest) Validate() error {
+	if r.Count <= 0 {
+		return fmt.Errorf("Please provide the number of editors")
+	}
+	if r.GitRef != "" && r.GitRepo == "" {
+		return fmt.Errorf("Please provide a git repo to check out the ref of")
+	}
+	if len(r.Invitees) > r.Count {
+		return fmt.Errorf("Please provide at most as many invitees as editors")
+	}
+	for _, inv := range r.Invitees {
+		if !strings.Contains(inv, "@") {
+			return fmt.Errorf("Please provide the email addresses of the invitees")
+		}
+	}
+
+	return nil
+}
+
+const (
+	BatchStateProvisioning = "provisioning"
+	BatchStateReady        = "ready"
+	// BatchStateFailed is of batches whose editors couldn't all be claimed,
+	// the ones that were are kept
+	BatchStateFailed  = "failed"
+	BatchStateDeleted = "deleted"
+)
+
+// Batch is a set of editors provisioned at once, e.g. for the attendees of
+// a workshop, which are torn down together.
+type Batch struct {
+	ID        string
+	Owner     string
+	Template  string `json:",omitempty"`
+	GitRepo   string `json:",omitempty"`
+	Count     int
+	State     string
+	Error     string `json:",omitempty"`
+	CreatedAt time.Time
+	DeletedAt *time.Time `json:",omitempty"`
+	Editors   []BatchEditor
+}
+
+// BatchEditor is a seat of a batch. Its token opens the editor at
+// /seat?token=, which stops working once the batch is torn down.
+type BatchEditor struct {
+	Seat  int
+	Name  string
+	URL   string
+	Token string
+	// Invitee is who the seat link was emailed to, InviteError why it
+	// couldn't be
+	Invitee     string `json:",omitempty"`
+	InviteError string `json:",omitempty"`
+}
+
+type BatchesResponse struct {
+	Batches []Batch
+}
+
+const (
+	SuspensionStateSnapshotting = "snapshotting"
+	SuspensionStateSuspended    = "suspended"
+	// SuspensionStateReleased is of editors released by the worker after
+	// their maximum session duration, whose snapshot is kept
+	SuspensionStateReleased = "released"
+)
+
+// Suspension is a claimed editor that is stopped, or being stopped, until
+// its owner resumes it.
+type Suspension struct {
+	Editor      string
+	State       string
+	RequestedAt time.Time
+	SuspendedAt *time.Time `json:",omitempty"`
+	// Snapshot is the object the workspace is saved to, empty when the
+	// disk of the provider persists
+	Snapshot string `json:",omitempty"`
+}
+
+// Migration is a suspended editor of an older version whose workspace
+// snapshot was moved onto an editor of the current version, To.
+type Migration struct {
+	Editor     string
+	To         string
+	User       string
+	MigratedAt time.Time
+}
+
+// Deletion is a claimed editor deleted by a user, which is stopped and
+// kept until PurgeAt so that it can be undeleted.
+type Deletion struct {
+	Editor    string
+	User      string
+	DeletedAt time.Time
+	PurgeAt   time.Time
+	// Suspended editors stay suspended when they're undeleted
+	Suspended bool `json:",omitempty"`
+}
+
+// Release is a claimed editor deleted by its owner that's kept running
+// until ExpiresAt, so that it's handed back to them with what's running in
+// it if they claim an editor of the same template and repo again.
+type Release struct {
+	Editor     string
+	Provider   string
+	User       string
+	Template   string
+	GitRepo    string
+	ReleasedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Activity sums up the requests to an editor since Since, as logged by the
+// Heroku router.
+type Activity struct {
+	Editor string
+	// LastRequestAt is the last request served by the editor, router
+	// errors don't count
+	LastRequestAt time.Time
+	Since         time.Time
+	Requests      int64
+	// Errors are router errors, e.g. H14 when the dyno is down, and
+	// responses with a 5xx status
+	Errors int64
+	// LastError is the code or status of the last error
+	LastError string `json:",omitempty"`
+}
+
+// Recording is a terminal session recorded in an editor whose template
+// turns recording on, in the asciicast v2 format.
+type Recording struct {
+	ID         string
+	Editor     string
+	User       string
+	Template   string
+	Object     string
+	Bytes      int64
+	StartedAt  time.Time
+	UploadedAt time.Time
+	// DownloadURL is set when a recording is looked up by itself
+	DownloadURL string `json:",omitempty"`
+}
+
+type RecordingsResponse struct {
+	Recordings []Recording
+}
+
+// RecordingRequest asks for the upload URL of a recording of the editor of
+// an agent.
+type RecordingRequest struct {
+	ID        string
+	Bytes     int64
+	StartedAt time.Time
+}
+
+func (r *RecordingRequest) Validate() error {
+	if r.ID == "" || strings.Trim(r.ID, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") != "" {
+		return fmt.Errorf("Please provide a recording ID of letters, digits and dashes")
+	}
+	if r.Bytes < 0 {
+		return fmt.Errorf("Please provide the size of the recording")
+	}
+
+	return nil
+}
+
+type RecordingUploadResponse struct {
+	UploadURL string
+}
+
+// IdleWarning tells the user of an editor that it's about to be released,
+// which is shown in the editor before it happens.
+type IdleWarning struct {
+	Editor    string
+	Message   string
+	ReleaseAt time.Time
+	// KeepAlive is set when the release is postponed by keeping the editor
+	// in use, i.e. with router idle detection
+	KeepAlive bool
+	WarnedAt  time.Time
+}
+
+// AgentTokenResponse is the token an agent picks up once its token is
+// rotated, e.g. by a transfer of its editor.
+type AgentTokenResponse struct {
+	Token string
+}
+
+type IdleResponse struct {
+	Warning *IdleWarning `json:",omitempty"`
+}
+
+type SnapshotResponse struct {
+	// UploadURL is set when the agent is asked to save the workspace
+	UploadURL string `json:",omitempty"`
+	// DownloadURL is set for owners of suspended or released editors
+	DownloadURL string `json:",omitempty"`
+}
+
+// SessionExpiry is when a claimed editor reaches its maximum session
+// duration, which the worker keeps up to date.
+type SessionExpiry struct {
+	Editor string
+	// ClaimedAt is the claim of the editor the expiry is of
+	ClaimedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SessionExtension postpones when an editor reaches its maximum session
+// duration by ExtendedBy, which the user extended it by Extensions times.
+type SessionExtension struct {
+	Editor     string
+	Extensions int
+	ExtendedBy time.Duration
+	ExtendedAt time.Time
+}
+
+// WorkspaceSnapshot is a snapshot of the workspace of a running editor
+// that its user saved, e.g. with cf-agent snapshot.
+type WorkspaceSnapshot struct {
+	Editor string
+	// Object is the object of the snapshot last requested, Saved the one
+	// of the snapshot last uploaded
+	Object      string
+	RequestedAt time.Time
+	Saved       string     `json:",omitempty"`
+	SavedAt     *time.Time `json:",omitempty"`
+}
+
+// EditorPorts are the ports of an editor its proxy serves at
+// /ports/#{PORT}/ of the editor URL.
+type EditorPorts struct {
+	Editor    string
+	Ports     []int
+	UpdatedAt time.Time
+}
+
+type PortRequest struct {
+	Port int
+}
+
+func (r *PortRequest) Validate() error {
+	if r.Port < 1024 || r.Port > 65535 {
+		return fmt.Errorf("Please provide a port between 1024 and 65535")
+	}
+
+	return nil
+}
+
+// AgentSession is the session of an editor as its user sees it from the
+// editor, e.g. with cf-agent status.
+type AgentSession struct {
+	Editor    string
+	Template  string
+	User      string
+	ClaimedAt time.Time
+	// ExpiresAt is when the editor reaches its maximum session duration,
+	// it's not set for editors without one
+	ExpiresAt     *time.Time `json:",omitempty"`
+	Extensions    int
+	MaxExtensions int
+	Ports         []int
+}
+
+const (
+	WIPPushStateRequested = "requested"
+	WIPPushStatePushed    = "pushed"
+	// WIPPushStateSkipped is of workspaces with nothing to push, e.g. that
+	// aren't git repositories or whose work is pushed already
+	WIPPushStateSkipped = "skipped"
+	WIPPushStateFailed  = "failed"
+)
+
+// WIPPush is a push of the uncommitted work of an editor to Branch, which
+// the worker asks its agent for before the editor is released.
+type WIPPush struct {
+	Editor      string
+	State       string
+	Branch      string
+	RequestedAt time.Time
+	CompletedAt *time.Time `json:",omitempty"`
+	// Reason is why nothing was pushed
+	Reason string `json:",omitempty"`
+}
+
+type WIPPushResponse struct {
+	// Branch is set when the agent is asked to push the work of its editor
+	Branch string `json:",omitempty"`
+}
+
+// WIPPushResult is how the agent of an editor reports a WIPPush.
+type WIPPushResult struct {
+	State  string
+	Reason string `json:",omitempty"`
+}
+
+func (r *WIPPushResult) Validate() error {
+	switch r.State {
+	case WIPPushStatePushed, WIPPushStateSkipped, WIPPushStateFailed:
+		return nil
+	default:
+		return fmt.Errorf("Please provide a state of %s, %s or %s", WIPPushStatePushed, WIPPushStateSkipped, WIPPushStateFailed)
+	}
+}
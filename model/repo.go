@@ -0,0 +1,187 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	GitHostGitHub    = "github.com"
+	GitHostGitLab    = "gitlab.com"
+	GitHostBitbucket = "bitbucket.org"
+
+	GitAuthToken     = "token"
+	GitAuthBasic     = "basic"
+	GitAuthDeployKey = "deploy-key"
+)
+
+// GitAuth is the credential an editor clones a private repository with.
+type GitAuth struct {
+	// Type is one of token, basic or deploy-key
+	Type     string
+	Username string `json:",omitempty"`
+	// Password is the password for basic auth or the OAuth/access token
+	Password   string `json:",omitempty"`
+	PrivateKey string `json:",omitempty"`
+}
+
+// CloneOptions speed up the clone of large repositories. Templates set
+// their defaults with the CF_GIT_DEPTH, CF_GIT_SINGLE_BRANCH and
+// CF_GIT_FILTER environment variables of their image.
+type CloneOptions struct {
+	// Depth is the number of commits fetched, all of them if 0
+	Depth int `json:",omitempty"`
+	// SingleBranch only fetches the branch that is checked out
+	SingleBranch bool `json:",omitempty"`
+	// Filter is the filter of a partial clone, blob:none or tree:0
+	Filter string `json:",omitempty"`
+}
+
+func (o *CloneOptions) Validate() error {
+	if o.Depth < 0 {
+		return fmt.Errorf("Please provide a clone depth of 0 or more")
+	}
+	switch o.Filter {
+	case "", "blob:none", "tree:0":
+	default:
+		return fmt.Errorf("Please provide a clone filter of blob:none or tree:0")
+	}
+
+	return nil
+}
+
+// CleanGitPath normalizes the path of a subdirectory of a repository, which
+// must stay inside of the repository.
+func CleanGitPath(p string) (string, error) {
+	clean := path.Clean(strings.Trim(p, "/"))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Please provide a path inside of the repository")
+	}
+
+	return clean, nil
+}
+
+// ParseRepoURL normalizes a GitHub, GitLab or Bitbucket repository URL.
+// Unless the repository is accessed with credentials, it must be public.
+func ParseRepoURL(s string, auth *GitAuth) (string, error) {
+	host, owner, repo, err := splitRepoURL(s)
+	if err != nil {
+		return "", err
+	}
+
+	u := fmt.Sprintf("https://%s/%s/%s", host, owner, repo)
+	if auth != nil {
+		return u, auth.validate()
+	}
+
+	resp, err := http.Get(repoAPIURL(host, owner, repo))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		return u, nil
+	}
+
+	return "", fmt.Errorf("Repository is not found or accessible")
+}
+
+func splitRepoURL(s string) (host, owner, repo string, err error) {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	switch u.Host {
+	case GitHostGitHub, GitHostGitLab, GitHostBitbucket:
+	default:
+		return "", "", "", fmt.Errorf("Please provide a GitHub, GitLab or Bitbucket repository URL")
+	}
+
+	if u.Scheme != "https" {
+		return "", "", "", fmt.Errorf("Please provide a HTTPS repository URL")
+	}
+
+	split := strings.Split(strings.TrimLeft(path.Clean(u.Path), "/"), "/")
+	if len(split) < 2 {
+		return "", "", "", fmt.Errorf("Please provide a valid repository URL")
+	}
+
+	return u.Host, split[0], strings.TrimSuffix(split[1], ".git"), nil
+}
+
+func repoAPIURL(host, owner, repo string) string {
+	switch host {
+	case GitHostGitLab:
+		return fmt.Sprintf("https://gitlab.com/api/v4/projects/%s", url.PathEscape(owner+"/"+repo))
+	case GitHostBitbucket:
+		return fmt.Sprintf("https://api.bitbucket.org/2.0/repositories/%s/%s", owner, repo)
+	default:
+		return fmt.Sprintf("https://api.github.com/repos/%s/%s", owner, repo)
+	}
+}
+
+func (a *GitAuth) validate() error {
+	switch a.Type {
+	case GitAuthToken, GitAuthBasic:
+		if a.Password == "" {
+			return fmt.Errorf("Please provide a password or token for the repository")
+		}
+	case GitAuthDeployKey:
+		if a.PrivateKey == "" {
+			return fmt.Errorf("Please provide a deploy key for the repository")
+		}
+	default:
+		return fmt.Errorf("Unsupported Git auth type %q", a.Type)
+	}
+
+	return nil
+}
+
+// CloneURL returns the URL of a repository an editor clones with the
+// credential, which never holds the credential itself. Deploy keys clone
+// over SSH.
+func (a *GitAuth) CloneURL(repoURL string) (string, error) {
+	host, owner, repo, err := splitRepoURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	if a.Type == GitAuthDeployKey {
+		return fmt.Sprintf("git@%s:%s/%s.git", host, owner, repo), nil
+	}
+
+	return fmt.Sprintf("https://%s/%s/%s.git", host, owner, repo), nil
+}
+
+// HTTPCredential returns the user name and password git authenticates to
+// the host of a repository with over HTTPS, which are empty for deploy
+// keys.
+func (a *GitAuth) HTTPCredential(repoURL string) (string, string, error) {
+	if a.Type == GitAuthDeployKey {
+		return "", "", nil
+	}
+
+	host, _, _, err := splitRepoURL(repoURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	if a.Type != GitAuthToken {
+		return a.Username, a.Password, nil
+	}
+
+	// user names the hosts expect for token auth over HTTPS
+	switch host {
+	case GitHostGitLab:
+		return "oauth2", a.Password, nil
+	case GitHostBitbucket:
+		return "x-token-auth", a.Password, nil
+	default:
+		return "x-access-token", a.Password, nil
+	}
+}
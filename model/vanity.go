@@ -0,0 +1,34 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// vanityNameRegexp allows DNS labels like alice-payments, which are short
+// enough to stay readable and can't be mistaken for app names of the pool.
+var vanityNameRegexp = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// ValidateVanityName checks the vanity name an editor is requested to be
+// served at, e.g. alice-payments.editors.example.com.
+func ValidateVanityName(name string) error {
+	if !vanityNameRegexp.MatchString(name) || strings.Contains(name, "--") {
+		return fmt.Errorf("Please provide a vanity name of up to 32 lowercase letters, digits and single dashes that starts with a letter, %q isn't one", name)
+	}
+
+	return nil
+}
+
+// VanityName is a vanity name taken by the editor served at it. It's free
+// again once the session of the editor ends.
+type VanityName struct {
+	Name string
+	Host string
+	// Editor is empty while the editor is being claimed
+	Editor    string
+	Provider  string
+	User      string
+	CreatedAt time.Time
+}
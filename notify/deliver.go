@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/jingweno/codeface/mail"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/slack"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Deliverer publishes notifications and delivers them the way their users
+// chose in their settings on top, over Slack or email.
+type Deliverer struct {
+	Store store.Store
+	// Slack sends direct messages, nil if Slack isn't set up
+	Slack *slack.Client
+	// Mail sends emails, nil if email isn't set up
+	Mail   mail.Mailer
+	Logger log.FieldLogger
+}
+
+// Available returns whether notifications can be delivered a way.
+func (d *Deliverer) Available(delivery string) bool {
+	switch delivery {
+	case model.DeliverySlack:
+		return d.Slack != nil && d.Slack.Token != ""
+	case model.DeliveryEmail:
+		return d.Mail != nil
+	}
+
+	return delivery == model.DeliveryIDE
+}
+
+// Deliver publishes a notification, then sends it over Slack or email if
+// its user chose so. Failing to send it is only logged, since it's shown in
+// the editor all the same.
+func (d *Deliverer) Deliver(ctx context.Context, n model.Notification) error {
+	if err := Publish(ctx, d.Store, n); err != nil {
+		return err
+	}
+
+	s, err := Settings(ctx, d.Store, n.User)
+	if err != nil {
+		return err
+	}
+
+	delivery := s.Notifications.Delivery(n.Type)
+	if delivery == model.DeliveryIDE || !d.Available(delivery) {
+		return nil
+	}
+
+	switch delivery {
+	case model.DeliverySlack:
+		text := n.Message
+		if n.URL != "" {
+			text += " " + n.URL
+		}
+		if err := d.sendSlack(ctx, n.User, text); err != nil {
+			d.Logger.WithError(err).WithFields(log.Fields{"user": n.User, "app": n.Editor}).Info("Fail to send notification over Slack")
+		}
+	case model.DeliveryEmail:
+		d.Email(ctx, n)
+	}
+
+	return nil
+}
+
+// Email emails a notification to its user with the mail template of its
+// type, if email is set up. Failing to send it is only logged.
+func (d *Deliverer) Email(ctx context.Context, n model.Notification) {
+	if d.Mail == nil {
+		return
+	}
+
+	logger := d.Logger.WithFields(log.Fields{"user": n.User, "app": n.Editor})
+	msg, err := mail.Render(n.Type, n.User, n)
+	if err == nil {
+		err = d.Mail.Send(ctx, msg)
+	}
+	if err != nil {
+		logger.WithError(err).Info("Fail to email notification")
+	}
+}
+
+func (d *Deliverer) sendSlack(ctx context.Context, email, text string) error {
+	id, err := d.Slack.UserIDByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	return d.Slack.PostMessage(ctx, slack.Message{Channel: id, Text: text})
+}
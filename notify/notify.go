@@ -0,0 +1,100 @@
+// Package notify keeps the notifications of users in the store, which the
+// worker and the server publish and the server pushes to the clients of
+// the users.
+package notify
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/rs/xid"
+)
+
+const (
+	notificationsPrefix = "notifications/"
+	// Retention is how long notifications are kept for clients that
+	// reconnect
+	Retention = time.Hour
+)
+
+func userPrefix(user string) string {
+	return notificationsPrefix + user + "/"
+}
+
+// Publish saves a notification for its user. Its ID and time are set.
+func Publish(ctx context.Context, st store.Store, n model.Notification) error {
+	id := xid.New()
+	n.ID = id.String()
+	n.At = id.Time()
+
+	return st.Put(ctx, userPrefix(n.User)+n.ID, n)
+}
+
+// Unseen returns the notifications of a user published since a time that
+// aren't in seen, oldest first, and adds them to seen.
+func Unseen(ctx context.Context, st store.Store, user string, since time.Time, seen map[string]bool) ([]model.Notification, error) {
+	keys, err := st.List(ctx, userPrefix(user))
+	if err != nil {
+		return nil, err
+	}
+
+	var ns []model.Notification
+	for _, k := range keys {
+		id := strings.TrimPrefix(k, userPrefix(user))
+		if seen[id] {
+			continue
+		}
+		if x, err := xid.FromString(id); err != nil || x.Time().Before(since.Truncate(time.Second)) {
+			continue
+		}
+
+		var n model.Notification
+		err := st.Get(ctx, k, &n)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		seen[id] = true
+		ns = append(ns, n)
+	}
+
+	return ns, nil
+}
+
+// Since returns the time to push the notifications of a client from that
+// reconnects after the one of ID after. It's now if after isn't an ID.
+func Since(after string, now time.Time) time.Time {
+	x, err := xid.FromString(after)
+	if err != nil {
+		return now
+	}
+
+	return x.Time()
+}
+
+// Purge deletes the notifications published before Retention.
+func Purge(ctx context.Context, st store.Store, now time.Time) error {
+	keys, err := st.List(ctx, notificationsPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		x, err := xid.FromString(k[strings.LastIndex(k, "/")+1:])
+		if err == nil && now.Sub(x.Time()) < Retention {
+			continue
+		}
+
+		if err := st.Delete(ctx, k); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+func settingsKey(user string) string {
+	return "usersettings/" + user
+}
+
+// Settings returns the settings of a user, the defaults if they haven't
+// changed them.
+func Settings(ctx context.Context, st store.Store, user string) (model.UserSettings, error) {
+	s := model.DefaultUserSettings()
+	if err := st.Get(ctx, settingsKey(user), &s); err != nil && err != store.ErrNotFound {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// SaveSettings saves the settings of a user.
+func SaveSettings(ctx context.Context, st store.Store, user string, s model.UserSettings) (model.UserSettings, error) {
+	now := time.Now()
+	s.UpdatedAt = &now
+
+	return s, st.Put(ctx, settingsKey(user), s)
+}
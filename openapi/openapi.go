@@ -0,0 +1,292 @@
+// Package openapi generates an OpenAPI 3 document from the typed request
+// and response structs of an HTTP API.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var pathParamRegexp = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Operation is an endpoint of an API. Request and Response are values of
+// the JSON bodies, nil for none.
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Query   []Param
+	Headers []Param
+	// Auth is the security scheme of the operation, see Schemes
+	Auth     string
+	Request  interface{}
+	Response interface{}
+	// Status is the status of successful responses, 200 by default
+	Status int
+	// ContentType is the type of responses that aren't JSON, e.g.
+	// text/plain for streamed logs
+	ContentType string
+}
+
+type Param struct {
+	Name        string
+	Description string
+	// Type is the JSON schema type of the value, string by default
+	Type string
+}
+
+// Scheme is a security scheme of an API.
+type Scheme struct {
+	Name        string
+	Description string
+	// Header is the header an API key is sent in, or else a bearer token
+	// is sent in Authorization
+	Header string
+}
+
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// Document returns the OpenAPI document of the operations.
+func Document(info Info, schemes []Scheme, ops []Operation) map[string]interface{} {
+	g := &generator{schemas: make(map[string]interface{})}
+
+	paths := make(map[string]interface{})
+	for _, op := range ops {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op)
+	}
+
+	securitySchemes := make(map[string]interface{})
+	for _, s := range schemes {
+		scheme := map[string]interface{}{
+			"description": s.Description,
+		}
+		if s.Header != "" {
+			scheme["type"] = "apiKey"
+			scheme["in"] = "header"
+			scheme["name"] = s.Header
+		} else {
+			scheme["type"] = "http"
+			scheme["scheme"] = "bearer"
+		}
+		securitySchemes[s.Name] = scheme
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       info.Title,
+			"version":     info.Version,
+			"description": info.Description,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":         g.schemas,
+			"securitySchemes": securitySchemes,
+		},
+	}
+}
+
+type generator struct {
+	schemas map[string]interface{}
+}
+
+func (g *generator) operation(op Operation) map[string]interface{} {
+	var params []interface{}
+	for _, m := range pathParamRegexp.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, p := range op.Query {
+		typ := p.Type
+		if typ == "" {
+			typ = "string"
+		}
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"schema":      map[string]interface{}{"type": typ},
+		})
+	}
+	for _, p := range op.Headers {
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          "header",
+			"description": p.Description,
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	resp := map[string]interface{}{
+		"description": http.StatusText(status),
+	}
+	switch {
+	case op.ContentType != "":
+		resp["content"] = map[string]interface{}{
+			op.ContentType: map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			},
+		}
+	case op.Response != nil:
+		resp["content"] = jsonContent(g.schema(reflect.TypeOf(op.Response)))
+	}
+
+	o := map[string]interface{}{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+		"responses": map[string]interface{}{
+			strconv.Itoa(status): resp,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": jsonContent(map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"Error": map[string]interface{}{"type": "string"},
+					},
+				}),
+			},
+		},
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+	if op.Request != nil {
+		o["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(g.schema(reflect.TypeOf(op.Request))),
+		}
+	}
+	if op.Auth != "" {
+		o["security"] = []interface{}{map[string]interface{}{op.Auth: []string{}}}
+	}
+
+	return o
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// operationID is derived from the method and the path, e.g.
+// getV1EditorsNameDisk for GET /v1/editors/{name}/disk.
+func operationID(op Operation) string {
+	id := strings.ToLower(op.Method)
+	for _, seg := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '{' || r == '}' || r == '-' }) {
+		id += strings.ToUpper(seg[:1]) + seg[1:]
+	}
+
+	return id
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the JSON schema of a type as encoding/json marshals it.
+// Structs are added to the components and referred to.
+func (g *generator) schema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			// siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return g.object(t)
+		}
+
+		if _, ok := g.schemas[name]; !ok {
+			// reserve the name first for recursive types
+			g.schemas[name] = map[string]interface{}{}
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	g.fields(t, props)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+}
+
+func (g *generator) fields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+
+		// fields of embedded structs are promoted like encoding/json does
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			g.fields(f.Type, props)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		props[name] = g.schema(f.Type)
+	}
+}
@@ -0,0 +1,666 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jingweno/codeface/model"
+)
+
+// The expressions of rules are made of:
+//
+//	literals       "text", 'text', 42, 1.5, true, false, ["a", "b"]
+//	variables      user, template, dyno_size, repo, labels, hour, weekday
+//	labels         labels.team, labels["cost-center"], empty if not set
+//	operators      ! - == != < <= > >= in && || and parentheses
+//	functions      startsWith, endsWith, contains, matches, lower, dynoRank
+//
+// Functions are called as f(x, y) or x.f(y). Comparing operands of
+// different types fails to evaluate rather than being false, so that e.g.
+// comparing hour to "8" is caught.
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokArrow
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of policy"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+var operators = []string{"=>", "&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "-", "(", ")", "[", "]", ",", "."}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		var b strings.Builder
+		for l.pos++; l.pos < len(l.src); l.pos++ {
+			switch l.src[l.pos] {
+			case c:
+				l.pos++
+				return token{kind: tokString, text: b.String(), pos: start}, nil
+			case '\\':
+				if l.pos+1 < len(l.src) {
+					l.pos++
+				}
+			}
+			b.WriteByte(l.src[l.pos])
+		}
+		return token{}, fmt.Errorf("unterminated string at column %d", start+1)
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && (l.src[l.pos] >= '0' && l.src[l.pos] <= '9' || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || unicode.IsLetter(rune(l.src[l.pos])) || unicode.IsDigit(rune(l.src[l.pos]))) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			kind := tokOp
+			if op == "=>" {
+				kind = tokArrow
+			}
+			return token{kind: kind, text: op, pos: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("unexpected %q at column %d", c, start+1)
+}
+
+var variables = map[string]bool{
+	"user":      true,
+	"template":  true,
+	"dyno_size": true,
+	"repo":      true,
+	"labels":    true,
+	"hour":      true,
+	"weekday":   true,
+}
+
+type function struct {
+	args int
+	call func(args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"startsWith": {2, func(args []interface{}) (interface{}, error) {
+		s, p, err := strings2("startsWith", args)
+		return strings.HasPrefix(s, p), err
+	}},
+	"endsWith": {2, func(args []interface{}) (interface{}, error) {
+		s, p, err := strings2("endsWith", args)
+		return strings.HasSuffix(s, p), err
+	}},
+	"contains": {2, func(args []interface{}) (interface{}, error) {
+		if list, ok := args[0].([]interface{}); ok {
+			return in(args[1], list)
+		}
+		s, sub, err := strings2("contains", args)
+		return strings.Contains(s, sub), err
+	}},
+	"matches": {2, func(args []interface{}) (interface{}, error) {
+		s, pattern, err := strings2("matches", args)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	}},
+	"lower": {1, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("lower takes a string, not %s", typeName(args[0]))
+		}
+		return strings.ToLower(s), nil
+	}},
+	// dynoRank orders dyno sizes from the smallest, so that sizes can be
+	// compared, it's -1 for unknown sizes and the size of the template
+	"dynoRank": {1, func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("dynoRank takes a string, not %s", typeName(args[0]))
+		}
+		return float64(model.DynoSizeRank(s)), nil
+	}},
+}
+
+func strings2(name string, args []interface{}) (string, string, error) {
+	a, ok1 := args[0].(string)
+	b, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return "", "", fmt.Errorf("%s takes strings, not %s and %s", name, typeName(args[0]), typeName(args[1]))
+	}
+
+	return a, b, nil
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.lex.pos}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	return fmt.Errorf(format+" at column %d", append(args, p.tok.pos+1)...)
+}
+
+func (p *parser) is(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if !p.is(op) {
+		return p.errorf("expected '%s' instead of %s", op, p.tok)
+	}
+	p.next()
+
+	return nil
+}
+
+// binaryLevels are the binary operators from the lowest precedence.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, o := range binaryLevels[level] {
+			if p.is(o) || (o == "in" && p.tok.kind == tokIdent && p.tok.text == o) {
+				op = o
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.is("!") || p.is("-") {
+		op := p.tok.text
+		p.next()
+
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: op, x: x}, nil
+	}
+
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected a name instead of %s", p.tok)
+			}
+			name := p.tok.text
+			p.next()
+
+			if p.is("(") {
+				x, err = p.parseCall(name, x)
+			} else {
+				x = &index{x: x, key: &literal{v: name}}
+			}
+		case p.is("["):
+			p.next()
+			key, kerr := p.parseExpr()
+			if kerr != nil {
+				return nil, kerr
+			}
+			x, err = &index{x: x, key: key}, p.expect("]")
+		default:
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseCall(name string, recv node) (node, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var args []node
+	if recv != nil {
+		args = append(args, recv)
+	}
+	for n := 0; !p.is(")"); n++ {
+		if n > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if len(args) != fn.args {
+		return nil, p.errorf("%s takes %d arguments, not %d", name, fn.args, len(args))
+	}
+
+	// patterns are checked when the rules are parsed rather than claimed
+	if lit, ok := args[len(args)-1].(*literal); ok && name == "matches" {
+		if s, ok := lit.v.(string); ok {
+			if _, err := regexp.Compile(s); err != nil {
+				return nil, p.errorf("invalid regular expression %q: %s", s, err)
+			}
+		}
+	}
+
+	return &call{name: name, fn: fn, args: args}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		p.next()
+		return &literal{v: tok.text}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		p.next()
+		return &literal{v: n}, nil
+	case tokIdent:
+		p.next()
+		switch {
+		case tok.text == "true" || tok.text == "false":
+			return &literal{v: tok.text == "true"}, nil
+		case p.is("("):
+			return p.parseCall(tok.text, nil)
+		case variables[tok.text]:
+			return &variable{name: tok.text}, nil
+		}
+		return nil, fmt.Errorf("unknown variable %s at column %d", tok.text, tok.pos+1)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			p.next()
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			p.next()
+			l := &list{}
+			for !p.is("]") {
+				if len(l.elems) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				x, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				l.elems = append(l.elems, x)
+			}
+			p.next()
+			return l, nil
+		}
+	}
+
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	v interface{}
+}
+
+func (n *literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.v, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (n *variable) eval(vars map[string]interface{}) (interface{}, error) {
+	return vars[n.name], nil
+}
+
+type list struct {
+	elems []node
+}
+
+func (n *list) eval(vars map[string]interface{}) (interface{}, error) {
+	var vs []interface{}
+	for _, e := range n.elems {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+
+	return vs, nil
+}
+
+type index struct {
+	x, key node
+}
+
+func (n *index) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case map[string]interface{}:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("labels are looked up by string, not %s", typeName(key))
+		}
+		if v, ok := x[k]; ok {
+			return v, nil
+		}
+		return "", nil
+	case []interface{}:
+		i, ok := key.(float64)
+		if !ok || i < 0 || int(i) >= len(x) || i != float64(int(i)) {
+			return nil, fmt.Errorf("invalid list index %v", key)
+		}
+		return x[int(i)], nil
+	}
+
+	return nil, fmt.Errorf("%s can't be indexed", typeName(x))
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (n *unary) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case bool:
+		if n.op == "!" {
+			return !x, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s can't be applied to %s", n.op, typeName(x))
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n *binary) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || don't evaluate their right side unless it's needed
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s takes bools, not %s", n.op, typeName(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s takes bools, not %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		eq, err := equal(left, right)
+		return eq == (n.op == "=="), err
+	case "in":
+		switch r := right.(type) {
+		case []interface{}:
+			return in(left, r)
+		case map[string]interface{}:
+			k, ok := left.(string)
+			if !ok {
+				return nil, fmt.Errorf("labels are looked up by string, not %s", typeName(left))
+			}
+			_, ok = r[k]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("in takes a list or labels, not %s", typeName(right))
+	}
+
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			return compare(n.op, l < r, l == r), nil
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return compare(n.op, l < r, l == r), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s can't compare %s and %s", n.op, typeName(left), typeName(right))
+}
+
+func compare(op string, less, eq bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || eq
+	case ">":
+		return !less && !eq
+	default:
+		return !less
+	}
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *call) eval(vars map[string]interface{}) (interface{}, error) {
+	var args []interface{}
+	for _, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	return n.fn.call(args)
+}
+
+func equal(a, b interface{}) (bool, error) {
+	if typeName(a) != typeName(b) {
+		return false, fmt.Errorf("%s and %s can't be compared", typeName(a), typeName(b))
+	}
+
+	la, ok := a.([]interface{})
+	if !ok {
+		if _, ok := a.(map[string]interface{}); ok {
+			return false, fmt.Errorf("labels can't be compared")
+		}
+		return a == b, nil
+	}
+
+	lb := b.([]interface{})
+	if len(la) != len(lb) {
+		return false, nil
+	}
+	for i := range la {
+		if eq, err := equal(la[i], lb[i]); err != nil || !eq {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func in(x interface{}, list []interface{}) (interface{}, error) {
+	for _, v := range list {
+		eq, err := equal(x, v)
+		if err != nil {
+			return nil, err
+		}
+		if eq {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a bool"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "labels"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{"unknown variable", `owner == "a"`, "unknown variable owner"},
+		{"unknown function", `user.trim() == "a"`, "unknown function trim"},
+		{"unknown operator", `user = "a"`, "unexpected '=' at column 6"},
+		{"unterminated string", `user == "a`, "unterminated string"},
+		{"missing paren", `(user == "a"`, "expected ')' instead of end of policy"},
+		{"missing bracket", `["a", "b"`, "expected ',' instead of end of policy"},
+		{"trailing operand", `user == "a" "b"`, "unexpected"},
+		{"arguments", `startsWith(user)`, "startsWith takes 2 arguments, not 1"},
+		{"method arguments", `user.endsWith("a", "b")`, "endsWith takes 2 arguments, not 3"},
+		{"invalid pattern", `user.matches("(")`, "invalid regular expression"},
+		{"dangling message", `=> no`, "unexpected"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Parse(c.rule)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want an error with %q", c.rule, c.err)
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Parse(%q) = %q, want an error with %q", c.rule, err, c.err)
+			}
+		})
+	}
+}
+
+func TestDeny(t *testing.T) {
+	in := Input{
+		User:     "alice@ml.example.com",
+		Template: "gpu",
+		DynoSize: "performance-l",
+		GitRepo:  "https://github.com/acme/api",
+		Labels:   map[string]string{"team": "ml"},
+		Time:     time.Date(2021, 6, 7, 21, 30, 0, 0, time.UTC),
+	}
+
+	cases := []struct {
+		name string
+		rule string
+		deny bool
+	}{
+		{"equal", `template == "gpu"`, true},
+		{"not equal", `template != "gpu"`, false},
+		{"and", `template == "gpu" && !user.endsWith("@ml.example.com")`, false},
+		{"or", `template == "node" || user.startsWith("alice")`, true},
+		{"short circuit", `false && labels.team > 1`, false},
+		{"number", `hour >= 20 && hour < 24`, true},
+		{"negative", `-hour < 0`, true},
+		{"weekday", `weekday == "monday"`, true},
+		{"dyno rank", `dynoRank(dyno_size) > dynoRank("standard-2x")`, true},
+		{"unknown dyno", `dynoRank("huge") == -1`, true},
+		{"in list", `template in ["gpu", "cuda"]`, true},
+		{"in labels", `"team" in labels`, true},
+		{"label", `labels.team == "ml"`, true},
+		{"label by key", `labels["team"] == "ml"`, true},
+		{"missing label", `labels.cost == ""`, true},
+		{"list index", `["a", "b"][1] == "b"`, true},
+		{"nested list index", `[["a"], ["b", "c"]][1][0] == "b"`, true},
+		{"list equal", `["a", 1] == ["a", 1]`, true},
+		{"list contains", `["a", "b"].contains("b")`, true},
+		{"string contains", `repo.contains("acme")`, true},
+		{"matches", `user.matches("^[a-z]+@ml\\.")`, true},
+		{"matches anchored", `matches(repo, "^acme/")`, false},
+		{"lower", `lower("GPU") == template`, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rules, err := Parse(c.rule + " => denied")
+			if err != nil {
+				t.Fatalf("Parse(%q) = %s", c.rule, err)
+			}
+
+			msg, err := rules.Deny(in)
+			if err != nil {
+				t.Fatalf("Deny(%q) = %s", c.rule, err)
+			}
+			if got := msg != ""; got != c.deny {
+				t.Errorf("Deny(%q) denies = %t, want %t", c.rule, got, c.deny)
+			}
+		})
+	}
+}
+
+func TestDenyTypeErrors(t *testing.T) {
+	in := Input{
+		User:    "alice@example.com",
+		GitRepo: "(",
+		Labels:  map[string]string{"team": "ml"},
+		Time:    time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC),
+	}
+
+	cases := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{"not a bool", `user`, "is a string instead of a bool"},
+		{"compare types", `user < 1`, "can't compare a string and a number"},
+		{"equal types", `hour == "9"`, "a number and a string can't be compared"},
+		{"and", `user && true`, "&& takes bools, not a string"},
+		{"or right", `false || hour`, "|| takes bools, not a number"},
+		{"not", `!user`, "! can't be applied to a string"},
+		{"negate", `-user == 1`, "- can't be applied to a string"},
+		{"function argument", `lower(hour) == "9"`, "lower takes a string, not a number"},
+		{"method receiver", `hour.startsWith("9")`, "startsWith takes strings, not a number and a string"},
+		{"list index out of range", `["a"][1] == "a"`, "invalid list index 1"},
+		{"list index fraction", `["a", "b"][0.5] == "a"`, "invalid list index 0.5"},
+		{"list index string", `["a"]["x"] == "a"`, "invalid list index x"},
+		{"index a string", `user[0] == "a"`, "a string can't be indexed"},
+		{"labels by number", `labels[1] == "a"`, "labels are looked up by string, not a number"},
+		{"in a string", `"a" in user`, "in takes a list or labels, not a string"},
+		{"compare labels", `labels == labels`, "labels can't be compared"},
+		{"matches pattern", `user.matches(repo)`, "missing closing )"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rules, err := Parse(c.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q) = %s", c.rule, err)
+			}
+
+			msg, err := rules.Deny(in)
+			if err == nil {
+				t.Fatalf("Deny(%q) succeeded, want an error with %q", c.rule, c.err)
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Deny(%q) = %q, want an error with %q", c.rule, err, c.err)
+			}
+			// broken rules deny claims rather than let them through
+			if msg == "" {
+				t.Errorf("Deny(%q) has no message", c.rule)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := Parse(`
+# office hours
+hour < 8 => editors are for office hours
+
+template == "gpu"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+	if rules[0].Line != 3 || rules[0].Expr != "hour < 8" || rules[0].Message != "editors are for office hours" {
+		t.Errorf("rule 0 = %+v", rules[0])
+	}
+	if rules[1].Line != 5 || rules[1].Message != `claims where template == "gpu" are denied` {
+		t.Errorf("rule 1 = %+v", rules[1])
+	}
+}
@@ -0,0 +1,131 @@
+// Package policy evaluates the rules that claims are checked against,
+// written as small expressions, so that new restrictions of an org don't
+// need a change of Codeface, e.g.
+//
+//	template == "gpu" && !user.endsWith("@ml.example.com") => gpu editors are for the ML team
+//	dynoRank(dyno_size) > dynoRank("standard-2x") && (hour < 8 || hour >= 20) => large editors are for office hours
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Input is what the rules know about a claim.
+type Input struct {
+	User     string
+	Template string
+	DynoSize string
+	GitRepo  string
+	Labels   map[string]string
+	// Time is when the claim is made, in the time zone the rules are
+	// written in
+	Time time.Time
+}
+
+func (in Input) vars() map[string]interface{} {
+	labels := map[string]interface{}{}
+	for k, v := range in.Labels {
+		labels[k] = v
+	}
+
+	return map[string]interface{}{
+		"user":      in.User,
+		"template":  in.Template,
+		"dyno_size": in.DynoSize,
+		"repo":      in.GitRepo,
+		"labels":    labels,
+		"hour":      float64(in.Time.Hour()),
+		"weekday":   strings.ToLower(in.Time.Weekday().String()),
+	}
+}
+
+// Rule denies the claims its expression is true for.
+type Rule struct {
+	Expr    string
+	Message string
+	Line    int
+
+	expr node
+}
+
+// Rules are checked in order, the first rule that denies a claim is the
+// reason it's denied.
+type Rules []Rule
+
+// Parse parses rules, one per line, written as EXPR => MESSAGE. The message
+// is what users are told when the rule denies their claim. Empty lines and
+// lines starting with # are skipped.
+func Parse(s string) (Rules, error) {
+	var rules Rules
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("error: fail to parse policy on line %d: %w", i+1, err)
+		}
+		r.Line = i + 1
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func parseRule(line string) (Rule, error) {
+	p := &parser{lex: &lexer{src: line}}
+	p.next()
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	if p.err != nil {
+		return Rule{}, p.err
+	}
+
+	r := Rule{expr: expr}
+	switch p.tok.kind {
+	case tokEOF:
+		r.Expr = line
+	case tokArrow:
+		r.Expr = strings.TrimSpace(line[:p.tok.pos])
+		r.Message = strings.TrimSpace(line[p.tok.pos+len("=>"):])
+	default:
+		return Rule{}, p.errorf("unexpected %s", p.tok)
+	}
+	if r.Message == "" {
+		r.Message = "claims where " + r.Expr + " are denied"
+	}
+
+	return r, nil
+}
+
+// Deny returns the message of the first rule that denies a claim, or empty
+// if none does. Rules that fail to evaluate deny the claim, e.g. when a
+// regular expression is invalid, so that a broken rule doesn't let claims
+// through.
+func (rules Rules) Deny(in Input) (string, error) {
+	vars := in.vars()
+	for _, r := range rules {
+		v, err := r.expr.eval(vars)
+		if err != nil {
+			return r.Message, fmt.Errorf("error: fail to evaluate policy on line %d: %w", r.Line, err)
+		}
+
+		b, ok := v.(bool)
+		if !ok {
+			return r.Message, fmt.Errorf("error: policy on line %d is %s instead of a bool", r.Line, typeName(v))
+		}
+		if b {
+			return r.Message, nil
+		}
+	}
+
+	return "", nil
+}
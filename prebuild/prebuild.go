@@ -0,0 +1,81 @@
+package prebuild
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/store"
+)
+
+const keyPrefix = "prebuilds/"
+
+// Prebuild is a dependency cache snapshot of a repo built by CI.
+type Prebuild struct {
+	Repo      string
+	Commit    string
+	Object    string
+	CreatedAt time.Time
+}
+
+// NormalizeRepo strips credentials and the .git suffix so that clone URLs
+// and web URLs of the same repo share a prebuild.
+func NormalizeRepo(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return repo
+	}
+
+	u.User = nil
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+
+	return u.String()
+}
+
+func id(repo string) string {
+	return hash(NormalizeRepo(repo))
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+func Key(repo string) string {
+	return keyPrefix + id(repo)
+}
+
+// ObjectName returns the object name a new prebuild of the repo is
+// uploaded to.
+func ObjectName(repo string, at time.Time) string {
+	return "prebuilds/" + id(repo) + "/" + at.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// DepsCacheObject returns the object the dependency cache of a user for the
+// repo is kept in. Their editors of the repo restore it before installing
+// dependencies, and upload it again when they're released.
+func DepsCacheObject(repo, user string) string {
+	return "depcaches/" + id(repo) + "/" + hash(user) + ".tar.gz"
+}
+
+// ValidObject reports whether an object was handed out for the repo.
+func ValidObject(repo, object string) bool {
+	return strings.HasPrefix(object, "prebuilds/"+id(repo)+"/") && !strings.Contains(object, "..")
+}
+
+func Latest(ctx context.Context, st store.Store, repo string) (*Prebuild, error) {
+	var p Prebuild
+	if err := st.Get(ctx, Key(repo), &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func Save(ctx context.Context, st store.Store, p Prebuild) error {
+	return st.Put(ctx, Key(p.Repo), p)
+}
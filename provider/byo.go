@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// errPoolless is returned by the byo provider for what only pools have.
+var errPoolless = fmt.Errorf("error: byo editors are deployed when they're claimed, there's no pool")
+
+func newBYO(cfg Config) *byoProvider {
+	return &byoProvider{
+		cfg:    cfg,
+		logger: log.New().WithField("com", "byo"),
+	}
+}
+
+// byoProvider deploys an editor into the Heroku account of the user when
+// it's claimed, with their own token, so that there's neither a pool nor a
+// pool account. It's also managed with the token of the user.
+type byoProvider struct {
+	cfg    Config
+	logger log.FieldLogger
+}
+
+func (p *byoProvider) Name() string {
+	return BYO
+}
+
+func (p *byoProvider) Capabilities() model.Capabilities {
+	return model.Capabilities{
+		Provider: BYO,
+		// editor apps are always created in the us region
+		Regions:     []string{"us"},
+		ScaleToZero: true,
+		DynoSizes:   true,
+	}
+}
+
+func (p *byoProvider) Deploy(ctx context.Context) (*Editor, error) {
+	return nil, errPoolless
+}
+
+func (p *byoProvider) Pool(ctx context.Context) ([]Editor, []Editor, error) {
+	return nil, nil, nil
+}
+
+// Claim deploys an editor of the template into the account of the
+// recipient and claims it, which takes as long as a build.
+func (p *byoProvider) Claim(ctx context.Context, opts editor.ClaimOptions) (*Editor, error) {
+	token := opts.RecipientToken
+	if token == "" {
+		var err error
+		if token, err = p.cfg.UserToken(ctx, opts.Recipient); err != nil {
+			return nil, err
+		}
+	}
+
+	d := editor.NewDeployer(token, p.cfg.TemplateDir)
+	if p.cfg.Store != nil {
+		d.SetStore(p.cfg.Store)
+	}
+
+	var (
+		app *heroku.App
+		err error
+	)
+	if p.cfg.TemplateGitURL != "" {
+		d.SetGitToken(p.cfg.TemplateGitToken)
+		app, _, err = d.DeployFromGit(ctx, p.cfg.TemplateGitURL, p.cfg.TemplateGitRef)
+	} else {
+		app, _, err = d.DeployEditorAndScaleDown(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := editor.NewClaimer(token)
+	c.SetHooks(p.cfg.Hooks)
+	c.SetEnvPolicy(p.cfg.EnvPolicy)
+
+	// the app is already owned by the recipient, so it isn't transferred
+	opts.App = app.Name
+	claimed, err := c.Claim(ctx, p.cfg.withNetworkPolicy(opts, 1))
+	if err != nil {
+		editor.DeleteApp(editor.HerokuService(token), app, p.logger)
+		return nil, err
+	}
+
+	return &Editor{
+		Name:     claimed.Name,
+		Provider: BYO,
+		URL:      editor.EditorAppURL(claimed),
+		Template: p.template(),
+	}, nil
+}
+
+func (p *byoProvider) template() string {
+	if p.cfg.TemplateGitURL != "" {
+		return editor.GitTemplateName(p.cfg.TemplateGitURL)
+	}
+
+	return editor.TemplateName(p.cfg.TemplateDir)
+}
+
+// Delete deletes the app of an editor from the account of its user.
+func (p *byoProvider) Delete(ctx context.Context, name string) error {
+	hk, err := p.userHeroku(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = hk.AppDelete(ctx, name)
+	return err
+}
+
+func (p *byoProvider) Suspend(ctx context.Context, name string) error {
+	hk, err := p.userHeroku(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return editor.ScaleApp(ctx, hk, name, 0)
+}
+
+func (p *byoProvider) Resume(ctx context.Context, name string, env map[string]string) (*Editor, error) {
+	hk, err := p.userHeroku(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(env) > 0 {
+		vars := make(map[string]*string)
+		for k, v := range env {
+			v := v
+			vars[k] = &v
+		}
+
+		if _, err := hk.ConfigVarUpdate(ctx, name, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := editor.ScaleApp(ctx, hk, name, 1); err != nil {
+		return nil, err
+	}
+
+	app, err := hk.AppInfo(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Editor{
+		Name:     app.Name,
+		Provider: BYO,
+		URL:      editor.EditorAppURL(app),
+		Template: p.template(),
+	}, nil
+}
+
+// userHeroku returns a Heroku client of the user an editor is claimed by,
+// who's looked up by the session of the editor.
+func (p *byoProvider) userHeroku(ctx context.Context, name string) (*heroku.Service, error) {
+	var s model.Session
+	if err := p.cfg.Store.Get(ctx, usage.SessionKey(name), &s); err != nil {
+		return nil, fmt.Errorf("error: fail to get the user of editor %s: %w", name, err)
+	}
+
+	token, err := p.cfg.UserToken(ctx, s.User)
+	if err != nil {
+		return nil, err
+	}
+
+	return editor.HerokuService(token), nil
+}
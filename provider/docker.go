@@ -0,0 +1,664 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dockerEditorPort = "8080/tcp"
+	dockerHome       = "/home/dyno"
+	// editors are labeled with the image they run
+	dockerTemplateLabel = "codeface.template"
+	// images label the init that starts their containers as root
+	dockerInitLabel = "codeface.init"
+)
+
+// newDocker returns a provider running editors as containers on a Docker
+// host. Idle editors are created but not started, and claimed editors
+// are started with the claim's environment.
+func newDocker(cfg Config) (*dockerProvider, error) {
+	u, err := url.Parse(cfg.DockerHost)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &dockerProvider{
+		cfg:    cfg,
+		http:   &http.Client{},
+		logger: log.New().WithField("com", "docker"),
+	}
+
+	switch u.Scheme {
+	case "unix":
+		p.base = "http://docker"
+		p.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", u.Path)
+			},
+		}
+	case "tcp", "http":
+		p.base = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("error: unsupported docker host %s", cfg.DockerHost)
+	}
+	if cfg.DockerNetwork != "" && cfg.EditorDomain == "" {
+		return nil, fmt.Errorf("error: DOCKER_NETWORK needs EDITOR_DOMAIN, editors on it are only reached through the server")
+	}
+
+	return p, nil
+}
+
+type dockerProvider struct {
+	cfg    Config
+	base   string
+	http   *http.Client
+	logger log.FieldLogger
+}
+
+type dockerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("error: docker status=%d message=%s", e.StatusCode, e.Message)
+}
+
+func (p *dockerProvider) Name() string {
+	return Docker
+}
+
+func (p *dockerProvider) Capabilities() model.Capabilities {
+	return model.Capabilities{
+		Provider: Docker,
+		// the container filesystem is kept when a container is restarted
+		PersistentDisk: true,
+		ViewerLinks:    true,
+		// see Deploy
+		EgressDeny: true,
+	}
+}
+
+func (p *dockerProvider) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, p.base+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = json.Unmarshal(b, &e)
+		return &dockerError{StatusCode: resp.StatusCode, Message: e.Message}
+	}
+
+	if out == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *dockerProvider) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	return p.do(ctx, method, path, "application/json", body, out)
+}
+
+// image returns the image editors are created from, which is the one of
+// the promoted OCI artifact of the template if any.
+func (p *dockerProvider) image(ctx context.Context) (string, error) {
+	if !p.cfg.PromoteArtifacts || p.cfg.Artifacts.Kind() != artifact.OCI {
+		return p.cfg.DockerImage, nil
+	}
+
+	template := editor.TemplateName(p.cfg.TemplateDir)
+	version, err := editor.TemplateVersion(p.cfg.TemplateDir)
+	if err != nil {
+		return "", err
+	}
+
+	art, changed, err := artifact.Promoted(ctx, p.cfg.Artifacts, p.cfg.Store, p.cfg.Environment, template, version, p.cfg.scanGate(p.logger))
+	if err == store.ErrNotFound {
+		// the template on disk isn't pushed by CI yet, keep promoting the
+		// previous artifact
+		promo, err := artifact.Promotion(ctx, p.cfg.Store, p.cfg.Environment, template)
+		if err == store.ErrNotFound {
+			return p.cfg.DockerImage, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		art, err = p.cfg.Artifacts.Get(ctx, template, promo.Version)
+		if err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	if changed {
+		logger := p.logger.WithFields(log.Fields{"template": template, "version": art.Version})
+		logger.Info("Promoted artifact")
+
+		if err := artifact.Prune(ctx, p.cfg.Artifacts, template, keptArtifacts, art.Version); err != nil {
+			logger.WithError(err).Info("Fail to prune artifacts")
+		}
+	}
+
+	if p.cfg.Verifier != nil {
+		if err := p.cfg.Verifier.Verify(*art); err != nil {
+			return "", err
+		}
+	}
+
+	return art.Ref, nil
+}
+
+func (p *dockerProvider) Deploy(ctx context.Context) (*Editor, error) {
+	name := editor.NewIdleAppName()
+	logger := p.logger.WithField("app", name)
+
+	image, err := p.image(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := p.imageConfig(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	init := cfg.Labels[dockerInitLabel]
+	env := []string{"PORT=8080"}
+	hostConfig := p.hostConfig()
+	// the init rejects connections to the denied CIDRs with iptables before
+	// it drops root, and the capability along with it
+	if len(p.cfg.EgressDeny) > 0 {
+		if init == "" {
+			return nil, fmt.Errorf("error: EDITOR_EGRESS_DENY needs an image on the base image, %s has no %s label", image, dockerInitLabel)
+		}
+
+		env = append(env, "CF_EGRESS_DENY="+strings.Join(p.cfg.EgressDeny, ","))
+		hostConfig["CapAdd"] = []string{"NET_ADMIN"}
+	}
+
+	spec := map[string]interface{}{
+		"Image":        image,
+		"Env":          env,
+		"ExposedPorts": map[string]interface{}{dockerEditorPort: struct{}{}},
+		"Labels":       map[string]string{dockerTemplateLabel: image},
+		"HostConfig":   hostConfig,
+	}
+	// images on the base image start as root through its init, which runs
+	// the editor as dyno and the code-server of viewer links as a user of
+	// its own
+	if init != "" {
+		spec["User"] = "root"
+		spec["Entrypoint"] = []string{init}
+		spec["Cmd"] = append(append([]string{}, cfg.Entrypoint...), cfg.Cmd...)
+	}
+
+	logger.Info("Creating container")
+	if err := p.doJSON(ctx, http.MethodPost, "/containers/create?name="+name, spec, nil); err != nil {
+		return nil, err
+	}
+
+	return &Editor{
+		Name:     name,
+		Provider: Docker,
+		Template: image,
+	}, nil
+}
+
+// hostConfig publishes the port of editors on every interface of the host
+// unless they're served through the gateway of the server, which is then
+// the only way to reach them: they're either on DockerNetwork, without a
+// published port, or published on the loopback of the host. Editors trust
+// the X-Forwarded-For of the gateway, see Claim.
+func (p *dockerProvider) hostConfig() map[string]interface{} {
+	if p.cfg.DockerNetwork != "" {
+		return map[string]interface{}{"NetworkMode": p.cfg.DockerNetwork}
+	}
+
+	binding := map[string]string{"HostPort": ""}
+	if p.cfg.EditorDomain != "" {
+		binding["HostIp"] = "127.0.0.1"
+	}
+
+	return map[string]interface{}{
+		"PortBindings": map[string]interface{}{
+			dockerEditorPort: []map[string]string{binding},
+		},
+	}
+}
+
+type dockerImageConfig struct {
+	Entrypoint []string
+	Cmd        []string
+	Labels     map[string]string
+}
+
+// imageConfig inspects an image, which is pulled if it's not on the host.
+func (p *dockerProvider) imageConfig(ctx context.Context, image string) (*dockerImageConfig, error) {
+	var inspect struct {
+		Config dockerImageConfig
+	}
+	err := p.doJSON(ctx, http.MethodGet, "/images/"+image+"/json", nil, &inspect)
+	if de, ok := err.(*dockerError); ok && de.StatusCode == http.StatusNotFound {
+		if err := p.pull(ctx, image); err != nil {
+			return nil, err
+		}
+		err = p.doJSON(ctx, http.MethodGet, "/images/"+image+"/json", nil, &inspect)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &inspect.Config, nil
+}
+
+// Update pulls the newest editor image, which containers created afterwards
+// run on.
+func (p *dockerProvider) Update(ctx context.Context) error {
+	image, err := p.image(ctx)
+	if err != nil {
+		return err
+	}
+
+	return p.pull(ctx, image)
+}
+
+func (p *dockerProvider) pull(ctx context.Context, image string) error {
+	p.logger.WithField("image", image).Info("Pulling image")
+	return p.do(ctx, http.MethodPost, "/images/create?fromImage="+url.QueryEscape(image), "", nil, nil)
+}
+
+type dockerContainer struct {
+	ID     string `json:"Id"`
+	Names  []string
+	Labels map[string]string
+}
+
+func (p *dockerProvider) containers(ctx context.Context) ([]dockerContainer, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {dockerTemplateLabel}})
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []dockerContainer
+	err = p.doJSON(ctx, http.MethodGet, "/containers/json?all=1&filters="+url.QueryEscape(string(filters)), nil, &containers)
+	return containers, err
+}
+
+func (p *dockerProvider) Pool(ctx context.Context) ([]Editor, []Editor, error) {
+	containers, err := p.containers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var currentVersion, otherVersion []Editor
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+
+		ed := Editor{
+			Name:     strings.TrimPrefix(c.Names[0], "/"),
+			Provider: Docker,
+			Template: c.Labels[dockerTemplateLabel],
+		}
+
+		idle, current := editor.IsIdleApp(ed.Name)
+		if !idle {
+			continue
+		}
+
+		if current {
+			currentVersion = append(currentVersion, ed)
+		} else {
+			otherVersion = append(otherVersion, ed)
+		}
+	}
+
+	sortEditors(currentVersion)
+	sortEditors(otherVersion)
+
+	return currentVersion, otherVersion, nil
+}
+
+func sortEditors(editors []Editor) {
+	sort.Slice(editors, func(i, j int) bool { return editors[i].Name < editors[j].Name })
+}
+
+func (p *dockerProvider) Claim(ctx context.Context, opts editor.ClaimOptions) (*Editor, error) {
+	if err := p.cfg.EnvPolicy.Validate(opts.Env); err != nil {
+		return nil, err
+	}
+
+	payload := editor.HookPayload{
+		App:       opts.App,
+		Recipient: opts.Recipient,
+		GitRepo:   opts.GitRepo,
+	}
+	if err := p.cfg.Hooks.PreClaim.Run(ctx, editor.PreClaimEvent, payload, p.logger); err != nil {
+		return nil, err
+	}
+
+	name := opts.App
+	if name == "" {
+		currentVersion, otherVersion, err := p.Pool(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ed := range append(currentVersion, otherVersion...) {
+			if opts.Takes(ed.Template) {
+				name = ed.Name
+				break
+			}
+		}
+		if name == "" {
+			return nil, editor.ErrNoIdleApp
+		}
+	}
+
+	claimedName, ok := editor.ClaimedAppName(name)
+	if !ok {
+		return nil, fmt.Errorf("error: %s is not an idle editor", name)
+	}
+
+	logger := p.logger.WithField("app", name)
+
+	logger.Info("Marking container as claimed")
+	if err := p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/rename?name=%s", name, claimedName), "", nil, nil); err != nil {
+		return nil, err
+	}
+	logger = p.logger.WithField("app", claimedName)
+
+	// editors are either reached directly or through the server's gateway
+	hops := 0
+	if p.cfg.EditorDomain != "" {
+		hops = 1
+	}
+
+	logger.Info("Writing environment")
+	if err := p.writeEnv(ctx, claimedName, p.cfg.withNetworkPolicy(opts, hops).ConfigVars()); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Starting container")
+	if err := p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/start", claimedName), "", nil, nil); err != nil {
+		return nil, err
+	}
+
+	ed, err := p.editor(ctx, claimedName)
+	if err != nil {
+		return nil, err
+	}
+
+	payload.App = ed.Name
+	if err := p.cfg.Hooks.PostClaim.Run(ctx, editor.PostClaimEvent, payload, p.logger); err != nil {
+		return ed, err
+	}
+
+	return ed, nil
+}
+
+// editor returns a running claimed editor.
+func (p *dockerProvider) editor(ctx context.Context, name string) (*Editor, error) {
+	info, err := p.inspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := &Editor{
+		Name:     name,
+		Provider: Docker,
+		Template: info.Config.Labels[dockerTemplateLabel],
+	}
+	if p.cfg.EditorDomain != "" {
+		ed.URL = fmt.Sprintf("https://%s.%s/?folder=/home/dyno/project", name, p.cfg.EditorDomain)
+		return ed, nil
+	}
+
+	port, err := info.hostPort(name)
+	if err != nil {
+		return nil, err
+	}
+	ed.URL = fmt.Sprintf("http://%s:%s/?folder=/home/dyno/project", p.cfg.DockerPublicHost, port)
+
+	return ed, nil
+}
+
+// Backend returns the address of the container on DockerNetwork, or its
+// port published on the loopback of the host behind the gateway.
+func (p *dockerProvider) Backend(ctx context.Context, name string) (*url.URL, error) {
+	if !editor.IsClaimedApp(name) {
+		return nil, fmt.Errorf("error: %s is not a claimed editor", name)
+	}
+
+	info, err := p.inspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.cfg.DockerNetwork != "" {
+		n, ok := info.NetworkSettings.Networks[p.cfg.DockerNetwork]
+		if !ok || n.IPAddress == "" {
+			return nil, fmt.Errorf("error: %s has no address on %s", name, p.cfg.DockerNetwork)
+		}
+
+		return &url.URL{Scheme: "http", Host: net.JoinHostPort(n.IPAddress, strings.TrimSuffix(dockerEditorPort, "/tcp"))}, nil
+	}
+
+	port, err := info.hostPort(name)
+	if err != nil {
+		return nil, err
+	}
+
+	host := p.cfg.DockerPublicHost
+	if p.cfg.EditorDomain != "" {
+		host = "127.0.0.1"
+	}
+
+	return &url.URL{Scheme: "http", Host: net.JoinHostPort(host, port)}, nil
+}
+
+type dockerContainerInfo struct {
+	Config struct {
+		Labels map[string]string
+	}
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostPort string
+		}
+		Networks map[string]struct {
+			IPAddress string
+		}
+	}
+}
+
+func (p *dockerProvider) inspect(ctx context.Context, name string) (*dockerContainerInfo, error) {
+	var info dockerContainerInfo
+	if err := p.doJSON(ctx, http.MethodGet, fmt.Sprintf("/containers/%s/json", name), nil, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// hostPort returns the host port the editor port of a container is
+// published on.
+func (i *dockerContainerInfo) hostPort(name string) (string, error) {
+	bindings := i.NetworkSettings.Ports[dockerEditorPort]
+	if len(bindings) == 0 {
+		return "", fmt.Errorf("error: no port is published for %s", name)
+	}
+
+	return bindings[0].HostPort, nil
+}
+
+// writeEnv copies the claim environment into the container, which is
+// sourced by start-code-server since a container's environment can't be
+// changed after it's created.
+func (p *dockerProvider) writeEnv(ctx context.Context, name string, vars map[string]string) error {
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := bytes.NewBuffer(nil)
+	for _, k := range keys {
+		fmt.Fprintf(env, "export %s='%s'\n", k, strings.Replace(vars[k], "'", `'\''`, -1))
+	}
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     ".codeface/",
+		Mode:     0700,
+		Uid:      1000,
+		Gid:      1000,
+	})
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name: ".codeface/env",
+		Mode: 0600,
+		Size: int64(env.Len()),
+		Uid:  1000,
+		Gid:  1000,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(env.Bytes()); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return p.do(ctx, http.MethodPut, fmt.Sprintf("/containers/%s/archive?path=%s", name, dockerHome), "application/x-tar", buf, nil)
+}
+
+func (p *dockerProvider) Delete(ctx context.Context, name string) error {
+	p.logger.WithField("app", name).Info("Removing container")
+	return p.do(ctx, http.MethodDelete, fmt.Sprintf("/containers/%s?force=1", name), "", nil, nil)
+}
+
+// Suspend stops a container, its filesystem is kept until it's removed.
+func (p *dockerProvider) Suspend(ctx context.Context, name string) error {
+	p.logger.WithField("app", name).Info("Stopping container")
+	return p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/stop", name), "", nil, nil)
+}
+
+// Resume starts a stopped container. env isn't needed since the workspace
+// is still on the container filesystem.
+func (p *dockerProvider) Resume(ctx context.Context, name string, env map[string]string) (*Editor, error) {
+	p.logger.WithField("app", name).Info("Starting container")
+	if err := p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/start", name), "", nil, nil); err != nil {
+		return nil, err
+	}
+
+	// the published port may change when a container is started again
+	return p.editor(ctx, name)
+}
+
+// Logs streams the stdout and stderr of a container.
+func (p *dockerProvider) Logs(ctx context.Context, name string, lines int, follow bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	query.Set("tail", strconv.Itoa(lines))
+	if follow {
+		query.Set("follow", "1")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/containers/%s/logs?%s", p.base, name, query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &dockerError{StatusCode: resp.StatusCode, Message: "fail to get container logs"}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		pw.CloseWithError(demuxDockerLogs(pw, resp.Body))
+	}()
+
+	return pr, nil
+}
+
+// demuxDockerLogs copies the frames of the logs of a container without a
+// TTY, each of which has an 8 byte header with the size of the frame in the
+// last 4 bytes.
+func demuxDockerLogs(dst io.Writer, src io.Reader) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(dst, src, size); err != nil {
+			return err
+		}
+	}
+}
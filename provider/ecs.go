@@ -0,0 +1,809 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jingweno/codeface/aws"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ecsTarget  = "AmazonEC2ContainerServiceV20141113."
+	elbVersion = "2015-12-01"
+	ec2Version = "2016-11-15"
+
+	ecsEditorPort  = 8080
+	ecsEditorTag   = "codeface:editor"
+	ecsTemplateTag = "codeface:template"
+	// ecsClaimTag is the ID of the claim that holds a target group
+	ecsClaimTag = "codeface:claim"
+
+	ecsTaskTimeout = 5 * time.Minute
+	ecsPollPeriod  = 5 * time.Second
+	// listener rule priorities are 1-50000
+	ecsMaxPriority = 50000
+)
+
+// newECS returns a provider running editors as Fargate tasks behind one
+// Application Load Balancer. Each editor has a target group that is
+// routed to by host name, e.g. cf-abc-002.editors.example.com.
+//
+// Idle editors are target groups without a task. The task is run when an
+// editor is claimed since the environment of a Fargate task can't be
+// changed once it's started.
+func newECS(cfg Config) (*ecsProvider, error) {
+	required := [][2]string{
+		{"ECS_CLUSTER", cfg.ECSCluster},
+		{"ECS_TASK_DEFINITION", cfg.ECSTaskDefinition},
+		{"ECS_VPC_ID", cfg.ECSVPCID},
+		{"ECS_LISTENER_ARN", cfg.ECSListenerARN},
+		{"ECS_EDITOR_DOMAIN", cfg.ECSEditorDomain},
+	}
+	for _, r := range required {
+		if r[1] == "" {
+			return nil, fmt.Errorf("error: %s is required by the ecs provider", r[0])
+		}
+	}
+	if len(cfg.ECSSubnets) == 0 {
+		return nil, fmt.Errorf("error: ECS_SUBNETS is required by the ecs provider")
+	}
+
+	creds := aws.NewCredentialsChain()
+
+	p := &ecsProvider{
+		cfg: cfg,
+		ecs: &aws.Client{
+			Service:     "ecs",
+			Region:      cfg.AWSRegion,
+			Credentials: creds,
+		},
+		elb: &aws.Client{
+			Service:     "elasticloadbalancing",
+			Region:      cfg.AWSRegion,
+			Credentials: creds,
+		},
+		logger: log.New().WithField("com", "ecs"),
+	}
+
+	if len(cfg.EgressDeny) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		ec2 := &aws.Client{Service: "ec2", Region: cfg.AWSRegion, Credentials: creds}
+		if err := p.checkEgress(ctx, ec2); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+type ecsProvider struct {
+	cfg    Config
+	ecs    *aws.Client
+	elb    *aws.Client
+	logger log.FieldLogger
+}
+
+type ecsTargetGroup struct {
+	ARN      string
+	Editor   string
+	Template string
+}
+
+// errECSClaimLost is returned when another claim holds the same editor.
+var errECSClaimLost = fmt.Errorf("error: editor is claimed by another claim")
+
+func (p *ecsProvider) Name() string {
+	return ECS
+}
+
+func (p *ecsProvider) Capabilities() model.Capabilities {
+	return model.Capabilities{
+		Provider: ECS,
+		Regions:  []string{p.cfg.AWSRegion},
+		// see checkEgress
+		EgressDeny: true,
+	}
+}
+
+// checkEgress checks that the security groups of editor tasks keep them
+// from connecting to the CIDRs of EgressDeny. Security groups only allow
+// traffic, so none of their egress rules may allow any of the CIDRs, and
+// rules that allow other security groups or prefix lists can't be checked.
+func (p *ecsProvider) checkEgress(ctx context.Context, ec2 *aws.Client) error {
+	if len(p.cfg.ECSSecurityGroups) == 0 {
+		return fmt.Errorf("error: EDITOR_EGRESS_DENY needs ECS_SECURITY_GROUPS, the default security group of the VPC allows any egress")
+	}
+
+	deny, err := editorproxy.ParseCIDRs(p.cfg.EgressDeny)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	for i, id := range p.cfg.ECSSecurityGroups {
+		params.Set(fmt.Sprintf("GroupId.%d", i+1), id)
+	}
+
+	var resp struct {
+		SecurityGroups []struct {
+			GroupID string `xml:"groupId"`
+			Egress  []struct {
+				IPRanges    []string `xml:"ipRanges>item>cidrIp"`
+				IPv6Ranges  []string `xml:"ipv6Ranges>item>cidrIpv6"`
+				Groups      []string `xml:"groups>item>groupId"`
+				PrefixLists []string `xml:"prefixListIds>item>prefixListId"`
+			} `xml:"ipPermissionsEgress>item"`
+		} `xml:"securityGroupInfo>item"`
+	}
+	if err := ec2.Query(ctx, "DescribeSecurityGroups", ec2Version, params, &resp); err != nil {
+		return err
+	}
+
+	for _, sg := range resp.SecurityGroups {
+		for _, rule := range sg.Egress {
+			if len(rule.Groups) > 0 || len(rule.PrefixLists) > 0 {
+				return fmt.Errorf("error: EDITOR_EGRESS_DENY can't be checked against the egress rules of security group %s that allow other security groups or prefix lists", sg.GroupID)
+			}
+
+			for _, r := range append(rule.IPRanges, rule.IPv6Ranges...) {
+				_, allowed, err := net.ParseCIDR(r)
+				if err != nil {
+					return fmt.Errorf("error: invalid CIDR %q in security group %s", r, sg.GroupID)
+				}
+
+				for _, d := range deny {
+					if allowed.Contains(d.IP) || d.Contains(allowed.IP) {
+						return fmt.Errorf("error: security group %s allows egress to %s, which EDITOR_EGRESS_DENY denies", sg.GroupID, r)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *ecsProvider) Deploy(ctx context.Context) (*Editor, error) {
+	name := editor.NewIdleAppName()
+	p.logger.WithField("app", name).Info("Creating target group")
+
+	params := url.Values{}
+	params.Set("Name", name)
+	params.Set("Protocol", "HTTP")
+	params.Set("Port", strconv.Itoa(ecsEditorPort))
+	params.Set("VpcId", p.cfg.ECSVPCID)
+	params.Set("TargetType", "ip")
+	params.Set("HealthCheckPath", "/healthz")
+	params.Set("Tags.member.1.Key", ecsEditorTag)
+	params.Set("Tags.member.1.Value", name)
+	params.Set("Tags.member.2.Key", ecsTemplateTag)
+	params.Set("Tags.member.2.Value", p.cfg.ECSTaskDefinition)
+
+	if err := p.elb.Query(ctx, "CreateTargetGroup", elbVersion, params, nil); err != nil {
+		return nil, err
+	}
+
+	return &Editor{
+		Name:     name,
+		Provider: ECS,
+		Template: p.cfg.ECSTaskDefinition,
+	}, nil
+}
+
+func (p *ecsProvider) targetGroups(ctx context.Context) ([]ecsTargetGroup, error) {
+	var arns []string
+	var marker string
+	for {
+		params := url.Values{}
+		params.Set("PageSize", "400")
+		if marker != "" {
+			params.Set("Marker", marker)
+		}
+
+		var resp struct {
+			TargetGroups []struct {
+				TargetGroupArn string
+			} `xml:"DescribeTargetGroupsResult>TargetGroups>member"`
+			NextMarker string `xml:"DescribeTargetGroupsResult>NextMarker"`
+		}
+		if err := p.elb.Query(ctx, "DescribeTargetGroups", elbVersion, params, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, tg := range resp.TargetGroups {
+			arns = append(arns, tg.TargetGroupArn)
+		}
+
+		if resp.NextMarker == "" {
+			break
+		}
+		marker = resp.NextMarker
+	}
+
+	return p.describeTargetGroups(ctx, arns)
+}
+
+// describeTargetGroups returns the target groups of editors among arns
+// with their tags.
+func (p *ecsProvider) describeTargetGroups(ctx context.Context, arns []string) ([]ecsTargetGroup, error) {
+	// tags can be described for up to 20 resources at a time
+	var tgs []ecsTargetGroup
+	for i := 0; i < len(arns); i += 20 {
+		j := i + 20
+		if j > len(arns) {
+			j = len(arns)
+		}
+
+		params := url.Values{}
+		for k, arn := range arns[i:j] {
+			params.Set(fmt.Sprintf("ResourceArns.member.%d", k+1), arn)
+		}
+
+		var resp struct {
+			TagDescriptions []struct {
+				ResourceArn string
+				Tags        []struct {
+					Key   string
+					Value string
+				} `xml:"Tags>member"`
+			} `xml:"DescribeTagsResult>TagDescriptions>member"`
+		}
+		if err := p.elb.Query(ctx, "DescribeTags", elbVersion, params, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, d := range resp.TagDescriptions {
+			tg := ecsTargetGroup{ARN: d.ResourceArn}
+			for _, t := range d.Tags {
+				switch t.Key {
+				case ecsEditorTag:
+					tg.Editor = t.Value
+				case ecsTemplateTag:
+					tg.Template = t.Value
+				}
+			}
+
+			if tg.Editor != "" {
+				tgs = append(tgs, tg)
+			}
+		}
+	}
+
+	return tgs, nil
+}
+
+func (p *ecsProvider) targetGroup(ctx context.Context, name string) (*ecsTargetGroup, error) {
+	tgs, err := p.targetGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tg := range tgs {
+		if tg.Editor == name {
+			return &tg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error: editor %s is not found", name)
+}
+
+func (p *ecsProvider) Pool(ctx context.Context) ([]Editor, []Editor, error) {
+	tgs, err := p.targetGroups(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentVersion, otherVersion := ecsPool(tgs)
+	return currentVersion, otherVersion, nil
+}
+
+// ecsPool returns the idle editors among target groups, of the current
+// version and of others.
+func ecsPool(tgs []ecsTargetGroup) ([]Editor, []Editor) {
+	var currentVersion, otherVersion []Editor
+	for _, tg := range tgs {
+		idle, current := editor.IsIdleApp(tg.Editor)
+		if !idle {
+			continue
+		}
+
+		ed := Editor{
+			Name:     tg.Editor,
+			Provider: ECS,
+			Template: tg.Template,
+		}
+		if current {
+			currentVersion = append(currentVersion, ed)
+		} else {
+			otherVersion = append(otherVersion, ed)
+		}
+	}
+
+	sortEditors(currentVersion)
+	sortEditors(otherVersion)
+
+	return currentVersion, otherVersion
+}
+
+func (p *ecsProvider) Claim(ctx context.Context, opts editor.ClaimOptions) (*Editor, error) {
+	if err := p.cfg.EnvPolicy.Validate(opts.Env); err != nil {
+		return nil, err
+	}
+
+	payload := editor.HookPayload{
+		App:       opts.App,
+		Recipient: opts.Recipient,
+		GitRepo:   opts.GitRepo,
+	}
+	if err := p.cfg.Hooks.PreClaim.Run(ctx, editor.PreClaimEvent, payload, p.logger); err != nil {
+		return nil, err
+	}
+
+	tgs, err := p.targetGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]ecsTargetGroup)
+	for _, tg := range tgs {
+		byName[tg.Editor] = tg
+	}
+
+	candidates := []string{opts.App}
+	if opts.App == "" {
+		currentVersion, otherVersion := ecsPool(tgs)
+		candidates = nil
+		for _, ed := range append(currentVersion, otherVersion...) {
+			if opts.Takes(ed.Template) {
+				candidates = append(candidates, ed.Name)
+			}
+		}
+	}
+
+	// claims that lose an idle editor to another claim move on to the next
+	var name, claimedName string
+	var tg ecsTargetGroup
+	for _, c := range candidates {
+		var ok bool
+		if tg, ok = byName[c]; !ok {
+			return nil, fmt.Errorf("error: editor %s is not found", c)
+		}
+
+		claimedName, err = p.claimTargetGroup(ctx, tg)
+		if err == errECSClaimLost && opts.App == "" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name = c
+		break
+	}
+	if name == "" {
+		return nil, editor.ErrNoIdleApp
+	}
+
+	logger := p.logger.WithField("app", claimedName)
+
+	logger.Info("Running task")
+	// requests to editors go through the load balancer
+	ip, err := p.runTask(ctx, claimedName, p.cfg.withNetworkPolicy(opts, 1).ConfigVars())
+	if err != nil {
+		p.unclaimTargetGroup(ctx, tg.ARN, name, claimedName, logger)
+		return nil, err
+	}
+
+	logger.WithField("ip", ip).Info("Registering task")
+	target := url.Values{}
+	target.Set("TargetGroupArn", tg.ARN)
+	target.Set("Targets.member.1.Id", ip)
+	target.Set("Targets.member.1.Port", strconv.Itoa(ecsEditorPort))
+	if err := p.elb.Query(ctx, "RegisterTargets", elbVersion, target, nil); err != nil {
+		p.unclaimTargetGroup(ctx, tg.ARN, name, claimedName, logger)
+		return nil, err
+	}
+
+	host := claimedName + "." + p.cfg.ECSEditorDomain
+	logger.WithField("host", host).Info("Routing editor")
+	if err := p.createRule(ctx, host, tg.ARN); err != nil {
+		if err := p.elb.Query(ctx, "DeregisterTargets", elbVersion, target, nil); err != nil {
+			logger.WithError(err).Info("Fail to deregister task")
+		}
+		p.unclaimTargetGroup(ctx, tg.ARN, name, claimedName, logger)
+		return nil, err
+	}
+
+	ed := &Editor{
+		Name:     claimedName,
+		Provider: ECS,
+		URL:      fmt.Sprintf("https://%s/?folder=/home/dyno/project", host),
+		Template: tg.Template,
+	}
+
+	payload.App = ed.Name
+	if err := p.cfg.Hooks.PostClaim.Run(ctx, editor.PostClaimEvent, payload, p.logger); err != nil {
+		return ed, err
+	}
+
+	return ed, nil
+}
+
+// ecsClaimKey is the key of the lock a claim holds on the target group of
+// an editor.
+func ecsClaimKey(claimedName string) string {
+	return "ecsclaims/" + claimedName
+}
+
+// claimTargetGroup tags the target group of an idle editor as claimed and
+// returns the name of the claimed editor. Tags can't be changed
+// conditionally, so the claim first creates its lock in the store, which
+// only one of the competing claims of the editor does. A claim that dies
+// between the two leaves the editor locked, it's then never handed out
+// and is deleted with the idle editors of its version.
+func (p *ecsProvider) claimTargetGroup(ctx context.Context, tg ecsTargetGroup) (string, error) {
+	claimedName, ok := editor.ClaimedAppName(tg.Editor)
+	if !ok {
+		return "", fmt.Errorf("error: %s is not an idle editor", tg.Editor)
+	}
+
+	// the target groups may have been listed a while ago
+	cur, err := p.describeTargetGroups(ctx, []string{tg.ARN})
+	if err != nil {
+		return "", err
+	}
+	if len(cur) == 0 || cur[0].Editor != tg.Editor {
+		return "", errECSClaimLost
+	}
+
+	logger := p.logger.WithField("app", claimedName)
+
+	id := xid.New().String()
+	err = p.cfg.Store.Create(ctx, ecsClaimKey(claimedName), ecsClaim{ID: id, ClaimedAt: time.Now()})
+	if err == store.ErrExists {
+		logger.Info("Target group is claimed by another claim")
+		return "", errECSClaimLost
+	}
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("Marking target group as claimed")
+	params := url.Values{}
+	params.Set("ResourceArns.member.1", tg.ARN)
+	params.Set("Tags.member.1.Key", ecsEditorTag)
+	params.Set("Tags.member.1.Value", claimedName)
+	params.Set("Tags.member.2.Key", ecsClaimTag)
+	params.Set("Tags.member.2.Value", id)
+	if err := p.elb.Query(ctx, "AddTags", elbVersion, params, nil); err != nil {
+		p.unlockClaim(ctx, claimedName, logger)
+		return "", err
+	}
+
+	return claimedName, nil
+}
+
+type ecsClaim struct {
+	ID        string
+	ClaimedAt time.Time
+}
+
+func (p *ecsProvider) unlockClaim(ctx context.Context, claimedName string, logger log.FieldLogger) {
+	if err := p.cfg.Store.Delete(ctx, ecsClaimKey(claimedName)); err != nil {
+		logger.WithError(err).Info("Fail to delete claim lock")
+	}
+}
+
+// unclaimTargetGroup puts the target group of an editor whose task didn't
+// run back into the pool. Failing to do so is only logged, the editor is
+// then left claimed for it to be deleted.
+func (p *ecsProvider) unclaimTargetGroup(ctx context.Context, arn, name, claimedName string, logger log.FieldLogger) {
+	logger.Info("Putting target group back into the pool")
+
+	if err := p.stopTasks(ctx, claimedName, "editor claim failed"); err != nil {
+		logger.WithError(err).Info("Fail to stop task")
+		return
+	}
+
+	params := url.Values{}
+	params.Set("ResourceArns.member.1", arn)
+	params.Set("Tags.member.1.Key", ecsEditorTag)
+	params.Set("Tags.member.1.Value", name)
+	if err := p.elb.Query(ctx, "AddTags", elbVersion, params, nil); err != nil {
+		logger.WithError(err).Info("Fail to unclaim target group")
+		return
+	}
+
+	params = url.Values{}
+	params.Set("ResourceArns.member.1", arn)
+	params.Set("TagKeys.member.1", ecsClaimTag)
+	if err := p.elb.Query(ctx, "RemoveTags", elbVersion, params, nil); err != nil {
+		logger.WithError(err).Info("Fail to remove claim tag")
+	}
+
+	p.unlockClaim(ctx, claimedName, logger)
+}
+
+type ecsTask struct {
+	TaskArn       string `json:"taskArn"`
+	LastStatus    string `json:"lastStatus"`
+	StoppedReason string `json:"stoppedReason"`
+	Attachments   []struct {
+		Type    string `json:"type"`
+		Details []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"details"`
+	} `json:"attachments"`
+}
+
+func (t ecsTask) privateIP() string {
+	for _, a := range t.Attachments {
+		if a.Type != "ElasticNetworkInterface" {
+			continue
+		}
+
+		for _, d := range a.Details {
+			if d.Name == "privateIPv4Address" {
+				return d.Value
+			}
+		}
+	}
+
+	return ""
+}
+
+// runTask runs the editor task and waits for it to be running. It returns
+// the private IP of the task.
+func (p *ecsProvider) runTask(ctx context.Context, name string, vars map[string]string) (string, error) {
+	type kv struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	env := []kv{{Name: "PORT", Value: strconv.Itoa(ecsEditorPort)}}
+	for k, v := range vars {
+		env = append(env, kv{Name: k, Value: v})
+	}
+
+	in := map[string]interface{}{
+		"cluster":        p.cfg.ECSCluster,
+		"taskDefinition": p.cfg.ECSTaskDefinition,
+		"launchType":     "FARGATE",
+		"count":          1,
+		// tasks are looked up by editor name when the editor is deleted
+		"startedBy": name,
+		"networkConfiguration": map[string]interface{}{
+			"awsvpcConfiguration": map[string]interface{}{
+				"subnets":        p.cfg.ECSSubnets,
+				"securityGroups": p.cfg.ECSSecurityGroups,
+				"assignPublicIp": "DISABLED",
+			},
+		},
+		"overrides": map[string]interface{}{
+			"containerOverrides": []map[string]interface{}{
+				{"name": p.cfg.ECSContainerName, "environment": env},
+			},
+		},
+		"tags": []map[string]string{
+			{"key": ecsEditorTag, "value": name},
+		},
+	}
+
+	var out struct {
+		Tasks    []ecsTask `json:"tasks"`
+		Failures []struct {
+			Reason string `json:"reason"`
+		} `json:"failures"`
+	}
+	if err := p.ecs.JSON(ctx, ecsTarget+"RunTask", in, &out); err != nil {
+		return "", err
+	}
+	if len(out.Failures) > 0 {
+		return "", fmt.Errorf("error: fail to run task: %s", out.Failures[0].Reason)
+	}
+	if len(out.Tasks) == 0 {
+		return "", fmt.Errorf("error: no task is run for %s", name)
+	}
+
+	arn := out.Tasks[0].TaskArn
+
+	ctx, cancel := context.WithTimeout(ctx, ecsTaskTimeout)
+	defer cancel()
+
+	t := time.NewTicker(ecsPollPeriod)
+	defer t.Stop()
+
+	for {
+		var desc struct {
+			Tasks []ecsTask `json:"tasks"`
+		}
+		err := p.ecs.JSON(ctx, ecsTarget+"DescribeTasks", map[string]interface{}{
+			"cluster": p.cfg.ECSCluster,
+			"tasks":   []string{arn},
+		}, &desc)
+		if err != nil {
+			return "", err
+		}
+
+		if len(desc.Tasks) > 0 {
+			task := desc.Tasks[0]
+			switch task.LastStatus {
+			case "RUNNING":
+				if ip := task.privateIP(); ip != "" {
+					return ip, nil
+				}
+			case "STOPPED":
+				return "", fmt.Errorf("error: task %s stopped: %s", arn, task.StoppedReason)
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return "", fmt.Errorf("error: timeout waiting for task %s to run", arn)
+		}
+	}
+}
+
+func (p *ecsProvider) createRule(ctx context.Context, host, tgARN string) error {
+	var err error
+	// priorities have to be unique per listener, so probe from a priority
+	// derived from the host on collisions
+	h := crc32.ChecksumIEEE([]byte(host))
+	for i := 0; i < 5; i++ {
+		priority := (h+uint32(i)*7919)%ecsMaxPriority + 1
+
+		params := url.Values{}
+		params.Set("ListenerArn", p.cfg.ECSListenerARN)
+		params.Set("Priority", strconv.Itoa(int(priority)))
+		params.Set("Conditions.member.1.Field", "host-header")
+		params.Set("Conditions.member.1.HostHeaderConfig.Values.member.1", host)
+		params.Set("Actions.member.1.Type", "forward")
+		params.Set("Actions.member.1.TargetGroupArn", tgARN)
+
+		err = p.elb.Query(ctx, "CreateRule", elbVersion, params, nil)
+		if e, ok := err.(*aws.APIError); ok && e.Code == "PriorityInUse" {
+			continue
+		}
+
+		return err
+	}
+
+	return err
+}
+
+func (p *ecsProvider) Delete(ctx context.Context, name string) error {
+	logger := p.logger.WithField("app", name)
+
+	tg, err := p.targetGroup(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	rules, err := p.rulesOf(ctx, tg.ARN)
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range rules {
+		logger.Info("Deleting listener rule")
+		params := url.Values{}
+		params.Set("RuleArn", arn)
+		if err := p.elb.Query(ctx, "DeleteRule", elbVersion, params, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := p.stopTasks(ctx, name, "editor is deleted"); err != nil {
+		return err
+	}
+
+	logger.Info("Deleting target group")
+	params := url.Values{}
+	params.Set("TargetGroupArn", tg.ARN)
+	if err := p.elb.Query(ctx, "DeleteTargetGroup", elbVersion, params, nil); err != nil {
+		return err
+	}
+
+	claimedName := name
+	if n, ok := editor.ClaimedAppName(name); ok {
+		claimedName = n
+	}
+	p.unlockClaim(ctx, claimedName, logger)
+
+	return nil
+}
+
+// rulesOf returns the ARNs of the listener rules forwarding to a target
+// group.
+func (p *ecsProvider) rulesOf(ctx context.Context, tgARN string) ([]string, error) {
+	var arns []string
+	var marker string
+	for {
+		params := url.Values{}
+		params.Set("ListenerArn", p.cfg.ECSListenerARN)
+		params.Set("PageSize", "400")
+		if marker != "" {
+			params.Set("Marker", marker)
+		}
+
+		var resp struct {
+			Rules []struct {
+				RuleArn string
+				Actions []struct {
+					TargetGroupArn string
+				} `xml:"Actions>member"`
+			} `xml:"DescribeRulesResult>Rules>member"`
+			NextMarker string `xml:"DescribeRulesResult>NextMarker"`
+		}
+		if err := p.elb.Query(ctx, "DescribeRules", elbVersion, params, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.Rules {
+			for _, a := range r.Actions {
+				if a.TargetGroupArn == tgARN {
+					arns = append(arns, r.RuleArn)
+					break
+				}
+			}
+		}
+
+		if resp.NextMarker == "" {
+			return arns, nil
+		}
+		marker = resp.NextMarker
+	}
+}
+
+// stopTasks stops the tasks of an editor.
+func (p *ecsProvider) stopTasks(ctx context.Context, name, reason string) error {
+	var nextToken string
+	for {
+		in := map[string]interface{}{
+			"cluster":   p.cfg.ECSCluster,
+			"startedBy": name,
+		}
+		if nextToken != "" {
+			in["nextToken"] = nextToken
+		}
+
+		var tasks struct {
+			TaskArns  []string `json:"taskArns"`
+			NextToken string   `json:"nextToken"`
+		}
+		if err := p.ecs.JSON(ctx, ecsTarget+"ListTasks", in, &tasks); err != nil {
+			return err
+		}
+
+		for _, arn := range tasks.TaskArns {
+			p.logger.WithFields(log.Fields{"app": name, "task": arn}).Info("Stopping task")
+			err := p.ecs.JSON(ctx, ecsTarget+"StopTask", map[string]interface{}{
+				"cluster": p.cfg.ECSCluster,
+				"task":    arn,
+				"reason":  reason,
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if tasks.NextToken == "" {
+			return nil
+		}
+		nextToken = tasks.NextToken
+	}
+}
@@ -0,0 +1,403 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// keptArtifacts is how many artifacts of a template are kept to roll back
+// to.
+const keptArtifacts = 5
+
+func newHeroku(cfg Config) *herokuProvider {
+	return &herokuProvider{
+		cfg:    cfg,
+		heroku: editor.HerokuService(cfg.HerokuAPIKey),
+		logger: log.New().WithField("com", "heroku"),
+	}
+}
+
+type herokuProvider struct {
+	cfg    Config
+	heroku *heroku.Service
+	logger log.FieldLogger
+
+	// mu makes sure an artifact is built once for concurrent deploys
+	mu sync.Mutex
+
+	// templates caches the templates of idle apps for FilterPool, which
+	// never change
+	templatesMu sync.Mutex
+	templates   map[string]string
+}
+
+func (p *herokuProvider) Name() string {
+	return Heroku
+}
+
+func (p *herokuProvider) Capabilities() model.Capabilities {
+	return model.Capabilities{
+		Provider: Heroku,
+		// editor apps are always created in the us region
+		Regions:       []string{"us"},
+		ScaleToZero:   true,
+		CrashRestarts: true,
+		DynoSizes:     true,
+		CustomDomains: true,
+	}
+}
+
+func (p *herokuProvider) Deploy(ctx context.Context) (*Editor, error) {
+	d := editor.NewDeployer(p.cfg.HerokuAPIKey, p.cfg.TemplateDir)
+	if p.cfg.Store != nil {
+		d.SetStore(p.cfg.Store)
+	}
+	d.SetRouterDrain(p.cfg.RouterDrainURL)
+	d.SetQuarantine(p.cfg.Quarantine)
+	d.SetEnvironment(p.cfg.Environment)
+	d.SetOutput(p.cfg.DeployOutput)
+
+	var (
+		app *heroku.App
+		res *model.DeployResult
+		err error
+	)
+	if p.cfg.TemplateGitURL != "" {
+		d.SetGitToken(p.cfg.TemplateGitToken)
+		app, res, err = d.DeployFromGit(ctx, p.cfg.TemplateGitURL, p.cfg.TemplateGitRef)
+	} else if p.cfg.PromoteArtifacts {
+		app, res, err = p.deployArtifact(ctx, d)
+	} else {
+		app, res, err = d.DeployEditorAndScaleDown(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Editor{
+		Name:     app.Name,
+		Provider: Heroku,
+		URL:      editor.EditorAppURL(app),
+		Template: p.template(),
+		Deploy:   res,
+	}, nil
+}
+
+// template returns the name of the template editors are deployed from.
+func (p *herokuProvider) template() string {
+	if p.cfg.TemplateGitURL != "" {
+		return editor.GitTemplateName(p.cfg.TemplateGitURL)
+	}
+
+	return editor.TemplateName(p.cfg.TemplateDir)
+}
+
+func (p *herokuProvider) Pool(ctx context.Context) ([]Editor, []Editor, error) {
+	currentVersion, otherVersion, err := editor.AllIdledApps(ctx, p.heroku)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.cfg.FilterPool {
+		return p.filter(ctx, currentVersion, otherVersion)
+	}
+
+	return p.editors(currentVersion), p.editors(otherVersion), nil
+}
+
+// filter returns the editors of the idle apps that are deployed from the
+// template of the provider.
+func (p *herokuProvider) filter(ctx context.Context, currentVersion, otherVersion []heroku.App) ([]Editor, []Editor, error) {
+	p.templatesMu.Lock()
+	defer p.templatesMu.Unlock()
+
+	if p.templates == nil {
+		p.templates = make(map[string]string)
+	}
+
+	template := p.template()
+	seen := make(map[string]bool)
+
+	filter := func(apps []heroku.App) []Editor {
+		var editors []Editor
+		for _, ed := range p.editors(apps) {
+			seen[ed.Name] = true
+
+			tmpl, ok := p.templates[ed.Name]
+			if !ok {
+				var err error
+				tmpl, err = editor.AppTemplate(ctx, p.heroku, ed.Name)
+				if err != nil {
+					p.logger.WithError(err).WithField("app", ed.Name).Info("Fail to get app template")
+					continue
+				}
+				p.templates[ed.Name] = tmpl
+			}
+
+			if tmpl == template {
+				ed.Template = tmpl
+				editors = append(editors, ed)
+			}
+		}
+
+		return editors
+	}
+
+	current, other := filter(currentVersion), filter(otherVersion)
+
+	// forget apps that are claimed or deleted
+	for name := range p.templates {
+		if !seen[name] {
+			delete(p.templates, name)
+		}
+	}
+
+	return current, other, nil
+}
+
+func (p *herokuProvider) editors(apps []heroku.App) []Editor {
+	var editors []Editor
+	for _, app := range apps {
+		app := app
+		editors = append(editors, Editor{
+			Name:     app.Name,
+			Provider: Heroku,
+			URL:      editor.EditorAppURL(&app),
+		})
+	}
+
+	return editors
+}
+
+func (p *herokuProvider) Claim(ctx context.Context, opts editor.ClaimOptions) (*Editor, error) {
+	c := editor.NewClaimer(p.cfg.HerokuAPIKey)
+	c.SetHooks(p.cfg.Hooks)
+	c.SetEnvPolicy(p.cfg.EnvPolicy)
+
+	// requests to editors go through the Heroku router
+	app, err := c.Claim(ctx, p.cfg.withNetworkPolicy(opts, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := editor.AppTemplate(ctx, p.heroku, app.Name)
+	if err != nil {
+		p.logger.WithError(err).WithField("app", app.Name).Info("Fail to get app template")
+		tmpl = editor.DefaultTemplate
+	}
+
+	return &Editor{
+		Name:     app.Name,
+		Provider: Heroku,
+		URL:      editor.EditorAppURL(app),
+		Template: tmpl,
+	}, nil
+}
+
+// AddDomain adds host to the domains of a claimed app, whose certificate
+// is issued by Heroku once the DNS record of host points at the target.
+func (p *herokuProvider) AddDomain(ctx context.Context, name, host string) (string, error) {
+	return editor.AddDomain(ctx, p.heroku, name, host)
+}
+
+// Delete deletes an app. Claimed apps are owned by their users, who the
+// pool account is only a collaborator of, so they're deleted with a token
+// of their owner, see Config.UserToken. They're scaled down instead when
+// there's no token of the owner that can delete them.
+func (p *herokuProvider) Delete(ctx context.Context, name string) error {
+	if !editor.IsClaimedApp(name) {
+		editor.DeleteApp(p.heroku, &heroku.App{Name: name}, p.logger)
+		return nil
+	}
+
+	app, err := p.heroku.AppInfo(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	acct, err := editor.Account(ctx, p.heroku)
+	if err != nil {
+		return err
+	}
+
+	// guest editors stay with the pool account
+	if app.Owner.Email == acct.Email {
+		_, err := p.heroku.AppDelete(ctx, name)
+		return err
+	}
+
+	logger := p.logger.WithFields(log.Fields{"app": name, "user": app.Owner.Email})
+	if p.cfg.UserToken != nil {
+		token, err := p.cfg.UserToken(ctx, app.Owner.Email)
+		if err == nil {
+			_, err = editor.HerokuService(token).AppDelete(ctx, name)
+		}
+		if err == nil {
+			logger.Info("Deleted claimed app")
+			return nil
+		}
+		logger.WithError(err).Info("Fail to delete claimed app with a token of its owner")
+	}
+
+	logger.Info("Scaling down claimed app, its owner has to delete it")
+	return editor.ScaleApp(ctx, p.heroku, name, 0)
+}
+
+func (p *herokuProvider) Suspend(ctx context.Context, name string) error {
+	return editor.ScaleApp(ctx, p.heroku, name, 0)
+}
+
+func (p *herokuProvider) Resume(ctx context.Context, name string, env map[string]string) (*Editor, error) {
+	if len(env) > 0 {
+		vars := make(map[string]*string)
+		for k, v := range env {
+			v := v
+			vars[k] = &v
+		}
+
+		if _, err := p.heroku.ConfigVarUpdate(ctx, name, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := editor.ScaleApp(ctx, p.heroku, name, 1); err != nil {
+		return nil, err
+	}
+
+	app, err := p.heroku.AppInfo(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	eds := p.editors([]heroku.App{*app})
+	return &eds[0], nil
+}
+
+// Logs streams the Logplex output of an app through a log session of the
+// pool account, which stays on as a collaborator of claimed editors.
+func (p *herokuProvider) Logs(ctx context.Context, name string, lines int, follow bool) (io.ReadCloser, error) {
+	sess, err := p.heroku.LogSessionCreate(ctx, name, heroku.LogSessionCreateOpts{
+		Lines: &lines,
+		Tail:  &follow,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sess.LogplexURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error: fail to stream logs of %s status=%d", name, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// artifactURLExpiry is how long builds of S3 artifacts have to download
+// their source.
+const artifactURLExpiry = time.Hour
+
+// deployArtifact deploys an idle editor from the promoted artifact of the
+// template, which is a builder app that is promoted through the pipeline of
+// the template, or a source tarball that is built.
+func (p *herokuProvider) deployArtifact(ctx context.Context, d *editor.Deployer) (*heroku.App, *model.DeployResult, error) {
+	art, err := p.artifact(ctx, d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.cfg.Verifier != nil {
+		if err := p.cfg.Verifier.Verify(*art); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if src, ok := p.cfg.Artifacts.(artifact.SourceStore); ok {
+		return d.DeployFromSource(ctx, art, src.URL(*art, artifactURLExpiry))
+	}
+
+	return d.DeployFromArtifact(ctx, art)
+}
+
+// artifact returns the artifact pool editors are promoted from. It's the
+// pinned one after a rollback, or else the one of the template on disk,
+// which is built and promoted first if it's new.
+func (p *herokuProvider) artifact(ctx context.Context, d *editor.Deployer) (*model.Artifact, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	arts := p.cfg.Artifacts
+	template := editor.TemplateName(p.cfg.TemplateDir)
+	version, err := editor.TemplateVersion(p.cfg.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	art, changed, err := artifact.Promoted(ctx, arts, p.cfg.Store, p.cfg.Environment, template, version, p.cfg.scanGate(p.logger))
+	if err == store.ErrNotFound {
+		if err := p.buildArtifact(ctx, d); err != nil {
+			return nil, err
+		}
+
+		art, changed, err = artifact.Promoted(ctx, arts, p.cfg.Store, p.cfg.Environment, template, version, p.cfg.scanGate(p.logger))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		logger := p.logger.WithFields(log.Fields{"template": template, "version": art.Version})
+		logger.Info("Promoted artifact")
+
+		if err := artifact.Prune(ctx, arts, template, keptArtifacts, art.Version); err != nil {
+			logger.WithError(err).Info("Fail to prune artifacts")
+		}
+	}
+
+	return art, nil
+}
+
+// buildArtifact builds the template on disk into the artifact store.
+func (p *herokuProvider) buildArtifact(ctx context.Context, d *editor.Deployer) error {
+	if src, ok := p.cfg.Artifacts.(artifact.SourceStore); ok {
+		art, tarball, err := d.SourceArtifact()
+		if err != nil {
+			return err
+		}
+
+		art.Digest = artifact.Digest(tarball)
+		if err := src.Upload(ctx, art, tarball); err != nil {
+			return err
+		}
+
+		p.cfg.sign(art)
+		return src.Put(ctx, *art)
+	}
+
+	art, err := d.BuildArtifact(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.cfg.sign(art)
+	return p.cfg.Artifacts.Put(ctx, *art)
+}
@@ -0,0 +1,253 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultFailoverCooldown = 10 * time.Minute
+
+// hybrid is a pool of editors backed by multiple providers. Editors are
+// deployed to the first provider that is able to, and claimed from the
+// first provider that has idle editors.
+type hybrid struct {
+	providers []Provider
+	cooldown  time.Duration
+	// store has the sessions of claimed editors, which name their provider
+	store  store.Store
+	logger log.FieldLogger
+
+	mu       sync.Mutex
+	failedAt map[string]time.Time
+}
+
+func (h *hybrid) Name() string {
+	var names []string
+	for _, p := range h.providers {
+		names = append(names, p.Name())
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Capabilities are the ones supported by all of the providers as an editor
+// may be claimed from any of them.
+func (h *hybrid) Capabilities() model.Capabilities {
+	caps := model.Capabilities{
+		Provider:       h.Name(),
+		PersistentDisk: true,
+		ScaleToZero:    true,
+		CrashRestarts:  true,
+		DynoSizes:      true,
+		CustomDomains:  true,
+		ViewerLinks:    true,
+		EgressDeny:     true,
+	}
+
+	regions := make(map[string]bool)
+	for _, p := range h.providers {
+		c := p.Capabilities()
+		caps.PersistentDisk = caps.PersistentDisk && c.PersistentDisk
+		caps.ScaleToZero = caps.ScaleToZero && c.ScaleToZero
+		caps.CrashRestarts = caps.CrashRestarts && c.CrashRestarts
+		caps.DynoSizes = caps.DynoSizes && c.DynoSizes
+		caps.CustomDomains = caps.CustomDomains && c.CustomDomains
+		caps.ViewerLinks = caps.ViewerLinks && c.ViewerLinks
+		caps.EgressDeny = caps.EgressDeny && c.EgressDeny
+
+		for _, r := range c.Regions {
+			if !regions[r] {
+				regions[r] = true
+				caps.Regions = append(caps.Regions, r)
+			}
+		}
+	}
+
+	return caps
+}
+
+func (h *hybrid) available(p Provider, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return now.Sub(h.failedAt[p.Name()]) >= h.cooldown
+}
+
+func (h *hybrid) failed(p Provider, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failedAt[p.Name()] = now
+}
+
+// Deploy fails over to the next provider when a provider fails to deploy,
+// and skips the failed provider until the cooldown is over.
+func (h *hybrid) Deploy(ctx context.Context) (*Editor, error) {
+	var errs []string
+	for _, p := range h.providers {
+		if !h.available(p, time.Now()) {
+			continue
+		}
+
+		ed, err := p.Deploy(ctx)
+		if err == nil {
+			return ed, nil
+		}
+
+		// don't fail over deploys that are cancelled
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		h.logger.WithError(err).WithField("provider", p.Name()).Info("Fail to deploy editor, failing over")
+		h.failed(p, time.Now())
+		errs = append(errs, fmt.Sprintf("%s: %s", p.Name(), err))
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("error: all providers are cooling down after failures")
+	}
+
+	return nil, fmt.Errorf("error: fail to deploy editor on any provider: %s", strings.Join(errs, "; "))
+}
+
+func (h *hybrid) Pool(ctx context.Context) ([]Editor, []Editor, error) {
+	var currentVersion, otherVersion []Editor
+	for _, p := range h.providers {
+		c, o, err := p.Pool(ctx)
+		if err != nil {
+			// the pool is still usable when one of the providers is down
+			h.logger.WithError(err).WithField("provider", p.Name()).Info("Fail to get pool")
+			continue
+		}
+
+		currentVersion = append(currentVersion, c...)
+		otherVersion = append(otherVersion, o...)
+	}
+
+	return currentVersion, otherVersion, nil
+}
+
+func (h *hybrid) Claim(ctx context.Context, opts editor.ClaimOptions) (*Editor, error) {
+	if opts.App != "" {
+		p, err := h.owner(ctx, opts.App)
+		if err != nil {
+			return nil, err
+		}
+
+		return p.Claim(ctx, opts)
+	}
+
+	for _, p := range h.providers {
+		currentVersion, otherVersion, err := p.Pool(ctx)
+		if err != nil {
+			h.logger.WithError(err).WithField("provider", p.Name()).Info("Fail to get pool")
+			continue
+		}
+
+		idle := append(currentVersion, otherVersion...)
+		if len(idle) == 0 {
+			continue
+		}
+
+		// the templates of idle editors aren't always known from the pool,
+		// the provider picks one of the template
+		if opts.Template != "" || len(opts.ReservedTemplates) > 0 {
+			ed, err := p.Claim(ctx, opts)
+			if err == editor.ErrNoIdleApp {
+				continue
+			}
+			return ed, err
+		}
+
+		opts.App = idle[0].Name
+		return p.Claim(ctx, opts)
+	}
+
+	return nil, editor.ErrNoIdleApp
+}
+
+func (h *hybrid) Delete(ctx context.Context, name string) error {
+	p, err := h.owner(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return p.Delete(ctx, name)
+}
+
+// owner returns the provider of an editor. Claimed editors are on the
+// provider of their session, idle ones are looked up in the pools, and the
+// providers that route editors are asked for the backend of the rest.
+func (h *hybrid) owner(ctx context.Context, name string) (Provider, error) {
+	if h.store != nil {
+		var s model.Session
+		err := h.store.Get(ctx, usage.SessionKey(name), &s)
+		if err == nil {
+			if p := Lookup(h, OfSession(s)); p != nil {
+				return p, nil
+			}
+		} else if err != store.ErrNotFound {
+			h.logger.WithError(err).WithField("app", name).Info("Fail to get session")
+		}
+	}
+
+	for _, p := range h.providers {
+		currentVersion, otherVersion, err := p.Pool(ctx)
+		if err != nil {
+			h.logger.WithError(err).WithField("provider", p.Name()).Info("Fail to get pool")
+			continue
+		}
+
+		for _, ed := range append(currentVersion, otherVersion...) {
+			if ed.Name == name {
+				return p, nil
+			}
+		}
+	}
+
+	for _, p := range h.providers {
+		if r, ok := p.(Router); ok {
+			if _, err := r.Backend(ctx, name); err == nil {
+				return p, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("error: editor %s is not found on any provider", name)
+}
+
+func (h *hybrid) Backend(ctx context.Context, name string) (*url.URL, error) {
+	for _, p := range h.providers {
+		if r, ok := p.(Router); ok {
+			if u, err := r.Backend(ctx, name); err == nil {
+				return u, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("error: no backend is found for %s", name)
+}
+
+// Update updates the providers of the pool that implement Updater.
+func (h *hybrid) Update(ctx context.Context) error {
+	for _, p := range h.providers {
+		if u, ok := p.(Updater); ok {
+			if err := u.Update(ctx); err != nil {
+				return fmt.Errorf("error: fail to update %s: %w", p.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
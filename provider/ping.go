@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jingweno/codeface/editor"
+)
+
+// Pinger is implemented by providers that can tell whether their API is
+// reachable, which the server and the worker are ready by.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping returns an error if the API of a provider isn't reachable.
+// Providers that can't tell are reachable.
+func Ping(ctx context.Context, p Provider) error {
+	if pp, ok := p.(Pinger); ok {
+		return pp.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (p *herokuProvider) Ping(ctx context.Context) error {
+	_, err := editor.Account(ctx, p.heroku)
+	return err
+}
+
+func (p *dockerProvider) Ping(ctx context.Context) error {
+	return p.do(ctx, http.MethodGet, "/_ping", "", nil, nil)
+}
+
+func (p *ecsProvider) Ping(ctx context.Context) error {
+	var out struct {
+		Clusters []struct {
+			Status string `json:"status"`
+		} `json:"clusters"`
+	}
+	if err := p.ecs.JSON(ctx, ecsTarget+"DescribeClusters", map[string]interface{}{
+		"clusters": []string{p.cfg.ECSCluster},
+	}, &out); err != nil {
+		return err
+	}
+
+	if len(out.Clusters) == 0 || out.Clusters[0].Status != "ACTIVE" {
+		return fmt.Errorf("error: ecs cluster %s isn't active", p.cfg.ECSCluster)
+	}
+
+	return nil
+}
+
+// Ping of a hybrid pool succeeds if any of its providers is reachable, as
+// claims fail over to the others.
+func (h *hybrid) Ping(ctx context.Context) error {
+	var errs []string
+	for _, p := range h.providers {
+		err := Ping(ctx, p)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", p.Name(), err))
+	}
+
+	return fmt.Errorf("error: no provider is reachable: %s", strings.Join(errs, "; "))
+}
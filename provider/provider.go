@@ -0,0 +1,317 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/logmux"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	Heroku = "heroku"
+	Docker = "docker"
+	ECS    = "ecs"
+	// BYO deploys editors into the Heroku accounts of their users when
+	// they're claimed, see byoProvider
+	BYO = "byo"
+)
+
+// Editor is an editor app managed by a provider. Names follow the app
+// naming of the pool, e.g. cf-#{ID}-#{VERSION}i for idle editors.
+type Editor struct {
+	Name     string
+	Provider string
+	URL      string
+	Template string
+	// Deploy is how long the phases of the deploy of the editor took, it's
+	// set by Deploy on providers that break deploys down
+	Deploy *model.DeployResult
+}
+
+// Provider runs editors. The worker keeps the pool of idle editors filled
+// with Deploy and Delete, and the server claims editors from the pool.
+type Provider interface {
+	Name() string
+	Capabilities() model.Capabilities
+	Deploy(ctx context.Context) (*Editor, error)
+	Pool(ctx context.Context) (currentVersion []Editor, otherVersion []Editor, err error)
+	Claim(ctx context.Context, opts editor.ClaimOptions) (*Editor, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Router is implemented by providers whose editors are served through the
+// TLS proxy of the server, see Config.EditorDomain.
+type Router interface {
+	// Backend returns the URL the proxy forwards requests of a claimed
+	// editor to.
+	Backend(ctx context.Context, name string) (*url.URL, error)
+}
+
+// Domainer is implemented by providers that can serve a claimed editor at
+// a custom domain, see model.Capabilities.CustomDomains.
+type Domainer interface {
+	// AddDomain serves a claimed editor at host on top of its own URL, and
+	// returns the DNS target that host has to be a CNAME of.
+	AddDomain(ctx context.Context, name, host string) (target string, err error)
+}
+
+// Suspender is implemented by providers that can stop a claimed editor
+// and start it again later without releasing it.
+type Suspender interface {
+	Suspend(ctx context.Context, name string) error
+	// Resume starts a suspended editor with env added to its environment.
+	Resume(ctx context.Context, name string, env map[string]string) (*Editor, error)
+}
+
+// Resetter is implemented by providers that can put claimed editors back
+// into the pool. Reset wipes what the user left in an editor and verifies
+// it's gone before the editor is marked idle again, and deletes the editor
+// if any of it fails.
+type Resetter interface {
+	Reset(ctx context.Context, name string) (*Editor, error)
+}
+
+// Release puts a claimed editor of a provider back into the pool when
+// reset is set and the provider can reset it, and deletes it otherwise.
+func Release(ctx context.Context, p Provider, providerName, name string, reset bool) error {
+	lp := Lookup(p, providerName)
+	if r, ok := lp.(Resetter); ok && reset {
+		_, err := r.Reset(ctx, name)
+		return err
+	}
+	if lp != nil {
+		return lp.Delete(ctx, name)
+	}
+
+	return p.Delete(ctx, name)
+}
+
+// LogStreamer is implemented by providers that can stream the output of
+// the editor process.
+type LogStreamer interface {
+	// Logs returns the last lines of output of an editor, followed by new
+	// output until ctx is done when follow is set.
+	Logs(ctx context.Context, name string, lines int, follow bool) (io.ReadCloser, error)
+}
+
+// Updater is implemented by providers that fetch the base image of editors
+// ahead of deploys, so that editors deployed after Update run on the newest
+// image. Providers that build editors from scratch on every deploy don't
+// need it.
+type Updater interface {
+	Update(ctx context.Context) error
+}
+
+type Config struct {
+	Provider    string
+	TemplateDir string
+	// TemplateGitURL builds Heroku editors from a ref of a template
+	// repository on GitHub instead of TemplateDir, see
+	// editor.Deployer.DeployFromGit
+	TemplateGitURL   string
+	TemplateGitRef   string
+	TemplateGitToken string
+	// RouterDrainURL is the log drain of the server that the router logs
+	// of Heroku editors are sent to, see editor.ActivityKey
+	RouterDrainURL string
+	// FilterPool only counts the idle editors of the template towards the
+	// pool of the Heroku provider, for accounts that hold the pools of
+	// several templates
+	FilterPool bool
+	// Store records the deploys of editors with their output, see
+	// editor.DeployKey
+	Store     store.Store
+	Hooks     editor.ClaimHooks
+	EnvPolicy editor.EnvPolicy
+	// IPAllowList and EgressDeny are the network policy of editors, see
+	// editor.ClaimOptions
+	IPAllowList []string
+	EgressDeny  []string
+	// FailoverCooldown is how long a provider of a hybrid pool is skipped
+	// after failing to deploy an editor
+	FailoverCooldown time.Duration
+	// DeployOutput is where the build output of Heroku editors is written,
+	// instead of the log, when deploys run concurrently
+	DeployOutput *logmux.Mux
+	// Quarantine keeps failed pool deploys of Heroku editors in Store for
+	// as long instead of deleting them, see editor.QuarantineKey
+	Quarantine time.Duration
+
+	HerokuAPIKey string
+	// UserToken returns a Heroku token of a user, which the byo provider
+	// deploys and manages their editors with, and the heroku provider
+	// deletes the editors claimed into their account with
+	UserToken func(ctx context.Context, user string) (string, error)
+	// PromoteArtifacts builds templates once and promotes pool editors
+	// from the build, which is kept in Artifacts
+	PromoteArtifacts bool
+	Artifacts        artifact.Store
+	// Scanner scans artifacts before they're promoted, which holds back the
+	// ones with vulnerabilities
+	Scanner *artifact.Scanner
+	// Signer signs the artifacts that are built, and Verifier refuses to
+	// deploy from artifacts that aren't signed by a trusted key
+	Signer   *artifact.Signer
+	Verifier *artifact.Verifier
+	// Environment is the environment of the pool, which promotes artifacts
+	// on its own unless it's production, see artifact.Promoted. Heroku
+	// editors are tagged with it.
+	Environment string
+
+	DockerHost       string
+	DockerImage      string
+	DockerPublicHost string
+	// EditorDomain serves Docker editors at https://#{NAME}.#{EditorDomain}
+	// through the TLS proxy of the server instead of their published ports
+	EditorDomain string
+	// DockerNetwork is the network Docker editors served at EditorDomain
+	// run on, which the server reaches them on. Their port is published on
+	// the loopback of the host otherwise.
+	DockerNetwork string
+
+	AWSRegion         string
+	ECSCluster        string
+	ECSTaskDefinition string
+	ECSContainerName  string
+	ECSSubnets        []string
+	ECSSecurityGroups []string
+	ECSVPCID          string
+	ECSListenerARN    string
+	ECSEditorDomain   string
+}
+
+// New returns the provider named by cfg.Provider. A comma-separated list
+// of providers, e.g. heroku,docker, returns a hybrid pool backed by the
+// providers in order of priority.
+func New(cfg Config) (Provider, error) {
+	names := strings.Split(cfg.Provider, ",")
+	if len(names) == 1 {
+		return newProvider(cfg)
+	}
+
+	h := &hybrid{
+		cooldown: cfg.FailoverCooldown,
+		failedAt: make(map[string]time.Time),
+		store:    cfg.Store,
+		logger:   log.New().WithField("com", "hybrid"),
+	}
+	if h.cooldown == 0 {
+		h.cooldown = defaultFailoverCooldown
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == BYO {
+			return nil, fmt.Errorf("error: the byo provider has no pool to be part of a hybrid pool")
+		}
+		if Has(h, name) {
+			return nil, fmt.Errorf("error: provider %s is listed more than once", name)
+		}
+
+		c := cfg
+		c.Provider = name
+		p, err := newProvider(c)
+		if err != nil {
+			return nil, err
+		}
+		h.providers = append(h.providers, p)
+	}
+
+	return h, nil
+}
+
+func newProvider(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case Heroku, "":
+		if cfg.HerokuAPIKey == "" {
+			return nil, fmt.Errorf("error: HEROKU_API_KEY is required by the heroku provider")
+		}
+		if cfg.PromoteArtifacts && (cfg.Store == nil || cfg.Artifacts == nil) {
+			return nil, fmt.Errorf("error: promoting artifacts requires a store")
+		}
+		if cfg.PromoteArtifacts && cfg.TemplateGitURL != "" {
+			return nil, fmt.Errorf("error: artifacts can't be promoted from a template repository")
+		}
+		if cfg.PromoteArtifacts && cfg.Artifacts.Kind() == artifact.OCI {
+			return nil, fmt.Errorf("error: heroku editors can't be promoted from oci artifacts")
+		}
+		return newHeroku(cfg), nil
+	case Docker:
+		if cfg.PromoteArtifacts && (cfg.Store == nil || cfg.Artifacts == nil) {
+			return nil, fmt.Errorf("error: promoting artifacts requires a store")
+		}
+		return newDocker(cfg)
+	case ECS:
+		// claims lock the target groups of editors in the store
+		if cfg.Store == nil {
+			return nil, fmt.Errorf("error: the ecs provider requires a store")
+		}
+		return newECS(cfg)
+	case BYO:
+		if cfg.TemplateGitURL == "" && cfg.TemplateDir == "" {
+			return nil, fmt.Errorf("error: TEMPLATE_GIT_URL is required by the byo provider")
+		}
+		if cfg.Store == nil || cfg.UserToken == nil {
+			return nil, fmt.Errorf("error: the byo provider requires a store and the tokens of users")
+		}
+		return newBYO(cfg), nil
+	default:
+		return nil, fmt.Errorf("error: unknown provider %q", cfg.Provider)
+	}
+}
+
+// withNetworkPolicy sets the network policy of the deployment on the
+// options of a claim. hops is the number of proxies in front of editors of
+// the provider.
+func (cfg Config) withNetworkPolicy(opts editor.ClaimOptions, hops int) editor.ClaimOptions {
+	opts.IPAllowList = cfg.IPAllowList
+	opts.EgressDeny = cfg.EgressDeny
+	opts.TrustedHops = hops
+
+	return opts
+}
+
+// Has returns whether p is, or is a hybrid pool backed by, the named
+// provider.
+func Has(p Provider, name string) bool {
+	return Lookup(p, name) != nil
+}
+
+// OfSession returns the name of the provider the editor of a session runs
+// on.
+func OfSession(s model.Session) string {
+	// sessions started before providers were added are all on Heroku
+	if s.Provider == "" {
+		return Heroku
+	}
+
+	return s.Provider
+}
+
+// Lookup returns the named provider, which is either p itself or one of
+// the providers of a hybrid pool, or nil if there is none.
+func Lookup(p Provider, name string) Provider {
+	if h, ok := p.(*hybrid); ok {
+		for _, hp := range h.providers {
+			if hp.Name() == name {
+				return hp
+			}
+		}
+		return nil
+	}
+
+	if p.Name() == name {
+		return p
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/editor"
+)
+
+// dockerWipe removes what a user may have left in a container: the
+// workspace, the claim environment with its agent token, deploy keys, git
+// credentials, shell history and the state of code-server.
+var dockerWipe = []string{"sh", "-c", `rm -rf /home/dyno/project /home/dyno/.codeface/env /home/dyno/.ssh \
+  /home/dyno/.gitconfig /home/dyno/.git-credentials /home/dyno/.bash_history /home/dyno/.cache \
+  /home/dyno/.local/share/code-server/User/globalStorage /home/dyno/.local/share/code-server/User/workspaceStorage \
+  /home/dyno/.local/share/code-server/logs /tmp/* /var/tmp/* &&
+  mkdir -p /home/dyno/project && chown dyno:dyno /home/dyno/project`}
+
+// dockerWiped succeeds if the wipe left nothing behind.
+var dockerWiped = []string{"sh", "-c", `test -z "$(ls -A /home/dyno/project)" && test ! -e /home/dyno/.codeface/env &&
+  test ! -e /home/dyno/.ssh && test ! -e /home/dyno/.gitconfig && test ! -e /home/dyno/.git-credentials`}
+
+// Reset wipes a claimed container and stops it, which ends what's still
+// running in it, e.g. dev servers, before renaming it idle. The container is
+// removed if it can't be wiped.
+func (p *dockerProvider) Reset(ctx context.Context, name string) (*Editor, error) {
+	idleName, ok := editor.ResetAppName(name)
+	if !ok {
+		return nil, fmt.Errorf("error: %s is not a claimed editor", name)
+	}
+
+	logger := p.logger.WithField("app", name)
+	logger.Info("Resetting container")
+
+	if err := p.reset(ctx, name, idleName); err != nil {
+		logger.WithError(err).Info("Fail to reset container, removing it")
+		if err := p.Delete(ctx, name); err != nil {
+			logger.WithError(err).Info("Fail to remove container")
+		}
+		return nil, err
+	}
+
+	ed := &Editor{Name: idleName, Provider: Docker}
+	if p.cfg.Store != nil {
+		// tokens bound to the old name don't reach the editor anymore, but
+		// they're revoked all the same
+		if err := agent.Revoke(ctx, p.cfg.Store, name); err != nil {
+			logger.WithError(err).Info("Fail to revoke agent token")
+		}
+	}
+
+	logger.WithField("to", idleName).Info("Reset container into the pool")
+
+	return ed, nil
+}
+
+func (p *dockerProvider) reset(ctx context.Context, name, idleName string) error {
+	// suspended editors are started to be wiped
+	if err := p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/start", name), "", nil, nil); err != nil && !notModified(err) {
+		return err
+	}
+
+	code, err := p.exec(ctx, name, dockerWipe)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("error: wiping %s exited with %d", name, code)
+	}
+
+	code, err = p.exec(ctx, name, dockerWiped)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("error: %s isn't wiped", name)
+	}
+
+	if err := p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/stop", name), "", nil, nil); err != nil && !notModified(err) {
+		return err
+	}
+
+	return p.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/rename?name=%s", name, idleName), "", nil, nil)
+}
+
+// exec runs cmd as root in a running container and returns its exit code.
+func (p *dockerProvider) exec(ctx context.Context, name string, cmd []string) (int, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := p.doJSON(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/exec", name), map[string]interface{}{
+		"Cmd":          cmd,
+		"User":         "root",
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}, &created)
+	if err != nil {
+		return 0, err
+	}
+
+	// an attached start returns once the command exited
+	if err := p.doJSON(ctx, http.MethodPost, fmt.Sprintf("/exec/%s/start", created.ID), map[string]bool{"Detach": false}, nil); err != nil {
+		return 0, err
+	}
+
+	var info struct {
+		Running  bool
+		ExitCode int
+	}
+	if err := p.doJSON(ctx, http.MethodGet, fmt.Sprintf("/exec/%s/json", created.ID), nil, &info); err != nil {
+		return 0, err
+	}
+	if info.Running {
+		return 0, fmt.Errorf("error: command in %s is still running", name)
+	}
+
+	return info.ExitCode, nil
+}
+
+// notModified returns whether a container was already started or stopped.
+func notModified(err error) bool {
+	de, ok := err.(*dockerError)
+	return ok && de.StatusCode == http.StatusNotModified
+}
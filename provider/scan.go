@@ -0,0 +1,50 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// scanGate returns the gate of the promotions of artifacts, which holds
+// back artifacts with vulnerabilities or that fail to be scanned, or nil
+// if artifacts aren't scanned.
+func (cfg Config) scanGate(logger log.FieldLogger) func(context.Context, *model.Artifact) error {
+	if cfg.Scanner == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, art *model.Artifact) error {
+		logger := logger.WithFields(log.Fields{"template": art.Template, "version": art.Version})
+
+		targets := []artifact.ScanTarget{{Kind: artifact.ScanImage, Ref: art.Ref}}
+		if art.Kind != artifact.OCI {
+			image, err := editor.TemplateBaseImage(cfg.TemplateDir)
+			if err != nil {
+				return err
+			}
+
+			targets = []artifact.ScanTarget{
+				{Kind: artifact.ScanImage, Ref: image},
+				{Kind: artifact.ScanFS, Ref: cfg.TemplateDir},
+			}
+		}
+
+		if _, err := artifact.Gate(ctx, cfg.Store, cfg.Scanner, *art, targets); err != nil {
+			logger.WithError(err).Warn("Promotion of artifact is held back, see cf-admin scans")
+			return err
+		}
+
+		return nil
+	}
+}
+
+// sign signs an artifact that's built if artifacts are signed.
+func (cfg Config) sign(art *model.Artifact) {
+	if cfg.Signer != nil {
+		cfg.Signer.Sign(art)
+	}
+}
@@ -0,0 +1,144 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/aws"
+)
+
+// Client is a minimal S3 client signing requests with AWS Signature
+// Version 4. It only supports what codeface needs, i.e. putting and
+// deleting objects.
+type Client struct {
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	HTTPClient      *http.Client
+}
+
+func (c *Client) endpoint(key string) string {
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", c.Bucket, c.Region, escapePath(key))
+}
+
+func (c *Client) Put(ctx context.Context, key, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.endpoint(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+
+	c.sign(req, body, time.Now().UTC())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error: fail to put s3 object %s status=%d body=%s", key, resp.StatusCode, b)
+	}
+
+	return nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.endpoint(key), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	c.sign(req, nil, time.Now().UTC())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// deleting a missing object succeeds too
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error: fail to delete s3 object %s status=%d body=%s", key, resp.StatusCode, b)
+	}
+
+	return nil
+}
+
+func (c *Client) sign(req *http.Request, body []byte, now time.Time) {
+	aws.Sign(req, body, "s3", c.Region, aws.Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+	}, now)
+}
+
+// Presign returns a URL that allows anyone holding it to perform the
+// method on the object until it expires.
+func (c *Client) Presign(method, key string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, c.Region)
+
+	u, _ := url.Parse(c.endpoint(key))
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", c.AccessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	query := strings.Replace(q.Encode(), "+", "%20", -1)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		query,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		aws.HexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(aws.HMACSHA256(aws.SigningKey(c.SecretAccessKey, date, c.Region, "s3"), stringToSign))
+	u.RawQuery = query + "&X-Amz-Signature=" + signature
+
+	return u.String()
+}
+
+func escapePath(key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+
+	return strings.Join(segs, "/")
+}
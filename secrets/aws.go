@@ -0,0 +1,47 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jingweno/codeface/aws"
+)
+
+// SecretsManager reads secrets from AWS Secrets Manager. Secrets holding
+// JSON can be looked up by key, other secrets only as a whole.
+type SecretsManager struct {
+	Client *aws.Client
+}
+
+// NewSecretsManager returns a client of Secrets Manager in a region, with
+// the credentials of the AWS SDKs.
+func NewSecretsManager(region string) *SecretsManager {
+	return &SecretsManager{
+		Client: &aws.Client{
+			Service:     "secretsmanager",
+			Region:      region,
+			Credentials: aws.NewCredentialsChain(),
+		},
+	}
+}
+
+func (sm *SecretsManager) Secret(ctx context.Context, path, key string) (string, error) {
+	var out struct {
+		SecretString string
+	}
+	if err := sm.Client.JSON(ctx, "secretsmanager.GetSecretValue", map[string]string{"SecretId": path}, &out); err != nil {
+		return "", err
+	}
+
+	if key == "" {
+		return out.SecretString, nil
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out.SecretString), &data); err != nil {
+		return "", fmt.Errorf("error: secret %s isn't JSON, it can't be looked up by key", path)
+	}
+
+	return secretValue(data, path, key)
+}
@@ -0,0 +1,89 @@
+// Package secrets looks up the secrets that are injected into editors at
+// claim time in external secret stores, so that they don't have to be
+// mirrored into the config of Codeface.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Backend is a secret store.
+type Backend interface {
+	// Secret returns the value of a key of the secret at path. The whole
+	// secret is returned if key is empty.
+	Secret(ctx context.Context, path, key string) (string, error)
+}
+
+// Ref is an environment variable whose value is a secret, written as
+// NAME=backend:path#key, e.g. NPM_TOKEN=vault:secret/data/ci#npm_token.
+type Ref struct {
+	Name    string
+	Backend string
+	Path    string
+	Key     string
+}
+
+// ParseRefs parses secret references.
+func ParseRefs(refs []string) ([]Ref, error) {
+	var parsed []Ref
+	for _, s := range refs {
+		nv := strings.SplitN(s, "=", 2)
+		if len(nv) != 2 || nv[0] == "" {
+			return nil, fmt.Errorf("error: invalid secret %q, expected NAME=backend:path#key", s)
+		}
+
+		bp := strings.SplitN(nv[1], ":", 2)
+		if len(bp) != 2 || bp[1] == "" {
+			return nil, fmt.Errorf("error: invalid secret %q, expected NAME=backend:path#key", s)
+		}
+
+		r := Ref{Name: nv[0], Backend: bp[0], Path: bp[1]}
+		if i := strings.LastIndex(r.Path, "#"); i >= 0 {
+			r.Path, r.Key = r.Path[:i], r.Path[i+1:]
+		}
+
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+// Resolver looks up secret references in their backends.
+type Resolver struct {
+	backends map[string]Backend
+	refs     []Ref
+}
+
+// NewResolver returns a resolver of refs, whose backends must be one of
+// backends by name.
+func NewResolver(refs []Ref, backends map[string]Backend) (*Resolver, error) {
+	for _, r := range refs {
+		if backends[r.Backend] == nil {
+			return nil, fmt.Errorf("error: secret %s is in backend %s, which isn't configured", r.Name, r.Backend)
+		}
+	}
+
+	return &Resolver{backends: backends, refs: refs}, nil
+}
+
+// Env returns the environment variables of the secrets. It fails if any
+// secret can't be looked up, so that editors don't start without them.
+func (r *Resolver) Env(ctx context.Context) (map[string]string, error) {
+	if r == nil || len(r.refs) == 0 {
+		return nil, nil
+	}
+
+	env := make(map[string]string)
+	for _, ref := range r.refs {
+		v, err := r.backends[ref.Backend].Secret(ctx, ref.Path, ref.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error: fail to look up secret %s: %w", ref.Name, err)
+		}
+
+		env[ref.Name] = v
+	}
+
+	return env, nil
+}
@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Vault reads secrets from the KV secrets engine of HashiCorp Vault, both
+// version 1 and 2. Paths of version 2 include its data segment, e.g.
+// secret/data/ci.
+type Vault struct {
+	Addr  string
+	Token string
+	// Namespace is the namespace of Vault Enterprise, if any
+	Namespace  string
+	HTTPClient *http.Client
+}
+
+func (v *Vault) Secret(ctx context.Context, path, key string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(v.Addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error: vault %s status=%d body=%s", path, resp.StatusCode, b)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return "", err
+	}
+
+	data := body.Data
+	// version 2 nests the secret under data
+	if nested, ok := data["data"]; ok {
+		data = nil
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return "", err
+		}
+	}
+
+	return secretValue(data, path, key)
+}
+
+// secretValue returns the value of a key of a secret, or the whole secret
+// as JSON if key is empty.
+func secretValue(data map[string]json.RawMessage, path, key string) (string, error) {
+	if key == "" {
+		b, err := json.Marshal(data)
+		return string(b), err
+	}
+
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("error: secret %s has no key %s", path, key)
+	}
+
+	// strings are unquoted, other values are kept as JSON
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+
+	return string(raw), nil
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/github"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// withAgent hands out an agent token to the editor being claimed, if
+// agent reports are on.
+func (h *handlers) withAgent(opts *editor.ClaimOptions) {
+	if h.serverURL == "" {
+		return
+	}
+
+	token, err := agent.NewToken()
+	if err != nil {
+		h.logger.WithError(err).Info("Fail to generate agent token")
+		return
+	}
+
+	opts.ServerURL = h.serverURL
+	opts.AgentToken = token
+}
+
+func (h *handlers) registerAgent(ctx context.Context, opts editor.ClaimOptions, ed *provider.Editor) {
+	if opts.AgentToken == "" {
+		return
+	}
+
+	if err := agent.Register(ctx, h.state, opts.AgentToken, ed.Name); err != nil {
+		h.logger.WithError(err).WithField("app", ed.Name).Info("Fail to register agent")
+	}
+}
+
+// agentEditor returns the name of the editor whose agent made the request.
+func (h *handlers) agentEditor(r *http.Request) (string, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		return "", false
+	}
+
+	name, err := agent.Editor(r.Context(), h.state, token)
+	if err != nil {
+		return "", false
+	}
+
+	// agents of terminated editors may not report anymore
+	var s model.Session
+	if err := h.state.Get(r.Context(), usage.SessionKey(name), &s); err != nil || s.EndedAt != nil {
+		return "", false
+	}
+
+	return name, true
+}
+
+func (h *handlers) HandleAgentDisk(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var du model.DiskUsage
+	if !decodeJSON(w, r, &du) {
+		return
+	}
+
+	du.Editor = name
+	du.ReportedAt = time.Now()
+	du.Warning = ""
+
+	var resp model.DiskUsageResponse
+	if du.TotalBytes > 0 && du.UsedBytes*100 >= du.TotalBytes*int64(h.diskWarnPercent) {
+		du.Warning = fmt.Sprintf("Disk is %d%% full, free up space before the editor runs out of it", du.UsedBytes*100/du.TotalBytes)
+		resp = model.DiskUsageResponse{Warning: du.Warning, Cleanup: true}
+
+		h.logger.WithFields(log.Fields{
+			"app":   name,
+			"used":  du.UsedBytes,
+			"total": du.TotalBytes,
+		}).Warn("Editor disk is nearly full")
+	}
+
+	if err := h.state.Put(r.Context(), agent.DiskKey(name), du); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleAgentReady records the boot time of the editor of an agent, which
+// its agent reports once the editor first serves. Editors restarted in a
+// session, e.g. by a resume, report again but keep the boot of the claim.
+func (h *handlers) HandleAgentReady(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var s model.Session
+	if err := h.state.Get(r.Context(), usage.SessionKey(name), &s); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if s.ReadyAt == nil {
+		now := time.Now()
+		s.ReadyAt = &now
+		if err := h.state.Put(r.Context(), usage.SessionKey(name), s); err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		boot := now.Sub(s.ClaimedAt)
+		metrics.Boots.Observe(s.Template, boot)
+		h.logger.WithFields(log.Fields{
+			"app":      name,
+			"template": s.Template,
+			"boot":     boot,
+		}).Info("Editor is ready")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleEditorDisk returns the last disk usage reported by an editor of
+// the user, or of anyone for admins.
+func (h *handlers) HandleEditorDisk(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	var s model.Session
+	err := h.state.Get(r.Context(), usage.SessionKey(name), &s)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && s.User != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var du model.DiskUsage
+	err = h.state.Get(r.Context(), agent.DiskKey(name), &du)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor hasn't reported disk usage"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, du)
+}
+
+// HandleAgentGitCredential hands out a short-lived token of the GitHub App
+// installation to the git credential helper of an editor. The token only
+// has access to the repository the editor was claimed for, and only if the
+// owner of the editor can push to it.
+func (h *handlers) HandleAgentGitCredential(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	if h.githubApp == nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "GitHub App is not configured"})
+		return
+	}
+
+	var s model.Session
+	if err := h.state.Get(r.Context(), usage.SessionKey(name), &s); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	owner, repo, err := github.ParseRepoURL(s.GitRepo)
+	if err != nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor has no GitHub repository"})
+		return
+	}
+
+	if err := h.checkPush(r.Context(), s.User, s.GitRepo); err != nil {
+		h.logger.WithError(err).WithFields(log.Fields{"app": name, "user": s.User}).Info("Fail to verify push access")
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	token, expiresAt, err := h.githubApp.RepoToken(r.Context(), owner, repo)
+	if err != nil {
+		h.logger.WithError(err).WithField("app", name).Info("Fail to get repository token")
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.GitCredential{
+		Username:  "x-access-token",
+		Password:  token,
+		ExpiresAt: expiresAt,
+	})
+}
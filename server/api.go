@@ -0,0 +1,595 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/openapi"
+	"github.com/jingweno/codeface/prebuild"
+)
+
+// Security schemes of the API.
+const (
+	// users authenticate with a Heroku API token, or else the session
+	// cookie of the dashboard
+	userAuth = "heroku"
+	// editor agents authenticate with their agent token
+	agentAuth = "agent"
+)
+
+// apiRoute is an endpoint of the API. Request and Response are values of
+// the JSON bodies, which the OpenAPI spec is generated from.
+type apiRoute struct {
+	Method   string
+	Path     string
+	Summary  string
+	Auth     string
+	Query    []openapi.Param
+	Headers  []openapi.Param
+	Request  interface{}
+	Response interface{}
+	Status   int
+	// ContentType is set for responses that aren't JSON
+	ContentType string
+	// Claims is set for the routes that claim editors, which have to be
+	// rate limited
+	Claims  bool
+	Handler func(*handlers, http.ResponseWriter, *http.Request)
+}
+
+var apiRoutes = []apiRoute{
+	{
+		Method: "POST", Path: "/editor", Summary: "Claim an editor of a repository, claims that need approval are accepted with a 202",
+		Auth: userAuth, Request: model.EditorRequest{}, Response: model.EditorResponse{}, Status: http.StatusCreated,
+		Headers: []openapi.Param{
+			{Name: idempotencyHeader, Description: "Unique key of the claim, retries with it get the response of the first claim that succeeded"},
+		},
+		Claims:  true,
+		Handler: (*handlers).HandleEditor,
+	},
+	{
+		Method: "GET", Path: "/v1/capabilities", Summary: "Get the capabilities of the provider",
+		Auth: userAuth, Response: model.Capabilities{},
+		Handler: (*handlers).HandleCapabilities,
+	},
+	{
+		Method: "GET", Path: "/v1/editors", Summary: "List the claimed editors of the user, or all editors for admins",
+		Auth: userAuth, Response: model.EditorsResponse{},
+		Query: []openapi.Param{
+			{Name: "template", Description: "Only editors of the template"},
+			{Name: "state", Description: "Only idle or claimed editors"},
+			{Name: "owner", Description: "Only editors claimed by the user (admin)"},
+			{Name: "label", Description: "Only editors claimed with the label, as key=value, may be repeated"},
+			{Name: "sort", Description: "name, template or claimed, or in reverse with a leading -"},
+			{Name: "limit", Description: fmt.Sprintf("Editors per page, at most %d", maxEditorsLimit), Type: "integer"},
+			{Name: "cursor", Description: "NextCursor of the previous page"},
+		},
+		Handler: (*handlers).HandleEditors,
+	},
+	{
+		Method: "DELETE", Path: "/v1/editors", Summary: "Delete the claimed editors that match every filter (admin)",
+		Auth: userAuth, Response: model.BulkDeleteResponse{},
+		Query: []openapi.Param{
+			{Name: "owner", Description: "Only editors claimed by the user"},
+			{Name: "template", Description: "Only editors of the template"},
+			{Name: "label", Description: "Only editors claimed with the label, as key=value, may be repeated"},
+			{Name: "older_than", Description: "Only editors claimed longer ago than the duration, e.g. 24h"},
+			{Name: "dry_run", Description: "List the editors that would be deleted without deleting them", Type: "boolean"},
+		},
+		Handler: (*handlers).HandleDeleteEditors,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}", Summary: "Get the status of an editor",
+		Auth: userAuth, Response: model.EditorStatus{},
+		Handler: (*handlers).HandleEditorStatus,
+	},
+	{
+		Method: "DELETE", Path: "/v1/editors/{name}", Summary: "Delete an editor",
+		Auth: userAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleDeleteEditor,
+	},
+	{
+		Method: "POST", Path: "/v1/editors/{name}/undelete", Summary: "Restore an editor deleted within the grace period",
+		Auth: userAuth, Response: model.EditorResponse{},
+		Handler: (*handlers).HandleUndelete,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}/activity", Summary: "Get the requests and errors of an editor from its router logs",
+		Auth: userAuth, Response: model.Activity{},
+		Handler: (*handlers).HandleEditorActivity,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}/disk", Summary: "Get the disk usage of an editor",
+		Auth: userAuth, Response: model.DiskUsage{},
+		Handler: (*handlers).HandleEditorDisk,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}/runtime-logs", Summary: "Stream the runtime logs of an editor",
+		Auth: userAuth, ContentType: "text/plain",
+		Query: []openapi.Param{
+			{Name: "lines", Description: fmt.Sprintf("Number of recent lines, at most %d", maxRuntimeLogLines), Type: "integer"},
+			{Name: "follow", Description: "Keep streaming new lines unless false", Type: "boolean"},
+		},
+		Handler: (*handlers).HandleEditorRuntimeLogs,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/disk", Summary: "Report the disk usage of the editor of an agent",
+		Auth: agentAuth, Request: model.DiskUsage{}, Response: model.DiskUsageResponse{},
+		Handler: (*handlers).HandleAgentDisk,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/ready", Summary: "Report that the editor of an agent serves, which records its boot time",
+		Auth: agentAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleAgentReady,
+	},
+	{
+		Method: "GET", Path: "/v1/agent/git-credential", Summary: "Get a short-lived token of the repository of the editor of an agent",
+		Auth: agentAuth, Response: model.GitCredential{},
+		Handler: (*handlers).HandleAgentGitCredential,
+	},
+	{
+		Method: "POST", Path: "/v1/agent/recordings", Summary: "Record a terminal recording of the editor of an agent and get its upload URL",
+		Auth: agentAuth, Request: model.RecordingRequest{}, Response: model.RecordingUploadResponse{},
+		Handler: (*handlers).HandleAgentRecording,
+	},
+	{
+		Method: "GET", Path: "/v1/recordings", Summary: "List the terminal recordings of the editors, newest first (admin)",
+		Auth: userAuth, Response: model.RecordingsResponse{},
+		Query: []openapi.Param{
+			{Name: "editor", Description: "Only recordings of the editor"},
+		},
+		Handler: (*handlers).HandleRecordings,
+	},
+	{
+		Method: "GET", Path: "/v1/recordings/{name}/{id}", Summary: "Get a terminal recording with its download URL (admin)",
+		Auth: userAuth, Response: model.Recording{},
+		Handler: (*handlers).HandleRecording,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}/resources", Summary: "Get the memory and CPU utilization of an editor",
+		Auth: userAuth, Response: model.ResourceUsage{},
+		Handler: (*handlers).HandleEditorResources,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/resources", Summary: "Report the memory and CPU utilization of the editor of an agent",
+		Auth: agentAuth, Request: model.ResourceUsage{}, Response: model.ResourceUsage{},
+		Handler: (*handlers).HandleAgentResources,
+	},
+	{
+		Method: "GET", Path: "/v1/resources", Summary: "Sum up the resource usage of the claimed editors of each template (admin)",
+		Auth: userAuth, Response: model.ResourcesResponse{},
+		Handler: (*handlers).HandleTemplateResources,
+	},
+	{
+		Method: "POST", Path: "/v1/batches", Summary: "Provision editors for the seats of a workshop or a class",
+		Auth: userAuth, Request: model.BatchRequest{}, Response: model.Batch{}, Status: http.StatusAccepted,
+		Claims:  true,
+		Handler: (*handlers).HandleCreateBatch,
+	},
+	{
+		Method: "GET", Path: "/v1/batches", Summary: "List the batches of the user, or all batches for admins",
+		Auth: userAuth, Response: model.BatchesResponse{},
+		Handler: (*handlers).HandleBatches,
+	},
+	{
+		Method: "GET", Path: "/v1/batches/{id}", Summary: "Get a batch with the URLs and seat tokens of its editors",
+		Auth: userAuth, Response: model.Batch{},
+		Handler: (*handlers).HandleBatch,
+	},
+	{
+		Method: "GET", Path: "/v1/batches/{id}/editors.csv", Summary: "Get the editors of a batch as CSV",
+		Auth: userAuth, ContentType: "text/csv",
+		Handler: (*handlers).HandleBatchCSV,
+	},
+	{
+		Method: "DELETE", Path: "/v1/batches/{id}", Summary: "Tear down every editor of a batch",
+		Auth: userAuth, Response: model.Batch{},
+		Handler: (*handlers).HandleDeleteBatch,
+	},
+	{
+		Method: "POST", Path: "/v1/editors/{name}/transfer", Summary: "Start the transfer of an editor to another user",
+		Auth: userAuth, Request: model.TransferRequest{}, Response: model.Transfer{}, Status: http.StatusAccepted,
+		Handler: (*handlers).HandleTransfer,
+	},
+	{
+		Method: "POST", Path: "/v1/editors/{name}/transfer/accept", Summary: "Accept the transfer of an editor",
+		Auth: userAuth, Response: model.Transfer{},
+		Handler: (*handlers).HandleAcceptTransfer,
+	},
+	{
+		Method: "DELETE", Path: "/v1/editors/{name}/transfer", Summary: "Cancel the transfer of an editor",
+		Auth: userAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleCancelTransfer,
+	},
+	{
+		Method: "POST", Path: "/v1/editors/{name}/viewers", Summary: "Create a link to watch an editor read-only without logging in, e.g. for demos",
+		Auth: userAuth, Response: model.ViewerLink{}, Status: http.StatusCreated,
+		Handler: (*handlers).HandleCreateViewerLink,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}/viewers", Summary: "List the viewer links of an editor",
+		Auth: userAuth, Response: model.ViewerLinksResponse{},
+		Handler: (*handlers).HandleViewerLinks,
+	},
+	{
+		Method: "DELETE", Path: "/v1/editors/{name}/viewers/{id}", Summary: "Revoke a viewer link of an editor",
+		Auth: userAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleRevokeViewerLink,
+	},
+	{
+		Method: "POST", Path: "/v1/editors/{name}/suspend", Summary: "Suspend an editor",
+		Auth: userAuth, Response: model.Suspension{}, Status: http.StatusAccepted,
+		Handler: (*handlers).HandleSuspend,
+	},
+	{
+		Method: "POST", Path: "/v1/editors/{name}/resume", Summary: "Resume a suspended editor",
+		Auth: userAuth, Response: model.EditorResponse{},
+		Handler: (*handlers).HandleResume,
+	},
+	{
+		Method: "GET", Path: "/v1/editors/{name}/snapshot", Summary: "Get a download URL of the snapshot of an editor",
+		Auth: userAuth, Response: model.SnapshotResponse{},
+		Handler: (*handlers).HandleEditorSnapshot,
+	},
+	{
+		Method: "GET", Path: "/v1/agent/idle", Summary: "Get the warning to show in the editor of an agent before it's released",
+		Auth: agentAuth, Response: model.IdleResponse{},
+		Handler: (*handlers).HandleAgentIdle,
+	},
+	{
+		Method: "POST", Path: "/v1/agent/keep-alive", Summary: "Keep the editor of an agent in use on behalf of its user",
+		Auth: agentAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleAgentKeepAlive,
+	},
+	{
+		Method: "POST", Path: "/v1/agent/token", Summary: "Get a new token of the editor of an agent with the token it had before it was rotated, e.g. by a transfer",
+		Auth: agentAuth, Response: model.AgentTokenResponse{},
+		Handler: (*handlers).HandleAgentToken,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/run", Summary: "Report the exit status of the command of a headless editor, which terminates it",
+		Auth: agentAuth, Request: model.RunResult{}, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleAgentRun,
+	},
+	{
+		Method: "GET", Path: "/v1/agent/snapshot", Summary: "Get an upload URL of the snapshot of the editor of an agent",
+		Auth: agentAuth, Response: model.SnapshotResponse{},
+		Handler: (*handlers).HandleAgentSnapshot,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/snapshot", Summary: "Complete the snapshot of the editor of an agent",
+		Auth: agentAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleCompleteSnapshot,
+	},
+	{
+		Method: "GET", Path: "/v1/agent/wip-push", Summary: "Get the branch the agent of an editor pushes its uncommitted work to before the editor is released",
+		Auth: agentAuth, Response: model.WIPPushResponse{},
+		Handler: (*handlers).HandleAgentWIPPush,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/wip-push", Summary: "Report how the agent of an editor pushed its uncommitted work",
+		Auth: agentAuth, Request: model.WIPPushResult{}, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleCompleteWIPPush,
+	},
+	{
+		Method: "POST", Path: "/v1/runs", Summary: "Run a command in a headless editor from the pool",
+		Auth: userAuth, Request: model.RunRequest{}, Response: model.Run{}, Status: http.StatusCreated,
+		Claims:  true,
+		Handler: (*handlers).HandleCreateRun,
+	},
+	{
+		Method: "GET", Path: "/v1/agent/session", Summary: "Get the session of the editor of an agent, e.g. when it reaches its maximum session duration",
+		Auth: agentAuth, Response: model.AgentSession{},
+		Handler: (*handlers).HandleAgentSession,
+	},
+	{
+		Method: "POST", Path: "/v1/agent/extend", Summary: "Extend the maximum session duration of the editor of an agent by SESSION_EXTENSION",
+		Auth: agentAuth, Response: model.AgentSession{},
+		Handler: (*handlers).HandleAgentExtend,
+	},
+	{
+		Method: "POST", Path: "/v1/agent/snapshots", Summary: "Get an upload URL of a snapshot the user saves of the workspace of the editor of an agent",
+		Auth: agentAuth, Response: model.SnapshotResponse{},
+		Handler: (*handlers).HandleAgentSaveSnapshot,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/snapshots", Summary: "Complete a snapshot the user saves of the workspace of the editor of an agent",
+		Auth: agentAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleAgentCompleteSnapshot,
+	},
+	{
+		Method: "GET", Path: "/v1/agent/ports", Summary: "List the ports the proxy of the editor of an agent serves",
+		Auth: agentAuth, Response: model.EditorPorts{},
+		Handler: (*handlers).HandleAgentPorts,
+	},
+	{
+		Method: "PUT", Path: "/v1/agent/ports", Summary: "Serve a port of the editor of an agent at /ports/{port}/ of the editor URL",
+		Auth: agentAuth, Request: model.PortRequest{}, Response: model.EditorPorts{},
+		Handler: (*handlers).HandleAgentOpenPort,
+	},
+	{
+		Method: "DELETE", Path: "/v1/agent/ports/{port}", Summary: "Stop serving a port of the editor of an agent",
+		Auth: agentAuth, Response: model.EditorPorts{},
+		Handler: (*handlers).HandleAgentClosePort,
+	},
+	{
+		Method: "GET", Path: "/v1/runs/{name}", Summary: "Get a run with the exit status of its command",
+		Auth: userAuth, Response: model.Run{},
+		Handler: (*handlers).HandleRun,
+	},
+	{
+		Method: "GET", Path: "/v1/pool", Summary: "List the editors of the pool (admin)",
+		Auth: userAuth, Response: model.PoolResponse{},
+		Handler: (*handlers).HandlePool,
+	},
+	{
+		Method: "GET", Path: "/v1/artifacts/{template}", Summary: "List the artifacts of a template (admin)",
+		Auth: userAuth, Response: model.ArtifactsResponse{},
+		Handler: (*handlers).HandleArtifacts,
+	},
+	{
+		Method: "POST", Path: "/v1/artifacts/{template}", Summary: "Register an artifact built elsewhere (admin)",
+		Auth: userAuth, Request: model.Artifact{}, Response: model.Artifact{}, Status: http.StatusCreated,
+		Handler: (*handlers).HandleRegisterArtifact,
+	},
+	{
+		Method: "POST", Path: "/v1/artifacts/{template}/promote", Summary: "Pin or unpin the promoted artifact of a template (admin)",
+		Auth: userAuth, Request: model.PromoteRequest{}, Response: model.Promotion{},
+		Handler: (*handlers).HandlePromote,
+	},
+	{
+		Method: "GET", Path: "/v1/deploys", Summary: "List deploys (admin)",
+		Auth: userAuth, Response: model.DeploysResponse{},
+		Query: []openapi.Param{
+			{Name: "template", Description: "Only deploys of the template"},
+		},
+		Handler: (*handlers).HandleDeploys,
+	},
+	{
+		Method: "GET", Path: "/v1/deploys/{id}", Summary: "Get a deploy with its log (admin)",
+		Auth: userAuth, Response: model.Deploy{},
+		Handler: (*handlers).HandleDeploy,
+	},
+	{
+		Method: "GET", Path: "/v1/sessions", Summary: "List the sessions of the user, or all of them for admins",
+		Auth: userAuth, Response: model.SessionsResponse{},
+		Query: []openapi.Param{
+			{Name: "label", Description: "Only sessions of claims with the label, as key=value, may be repeated"},
+		},
+		Handler: (*handlers).HandleSessions,
+	},
+	{
+		Method: "GET", Path: "/v1/usage", Summary: "Get the usage of the user, or of anyone for admins",
+		Auth: userAuth, Response: model.UsageResponse{},
+		Query: []openapi.Param{
+			{Name: "user", Description: "Only usage of the user (admin)"},
+			{Name: "template", Description: "Only usage of the template"},
+			{Name: "label", Description: "Only usage of claims with the label, as key=value, may be repeated"},
+			{Name: "from", Description: "First day, e.g. 2006-01-02"},
+			{Name: "to", Description: "Last day, e.g. 2006-01-02"},
+		},
+		Handler: (*handlers).HandleUsage,
+	},
+	{
+		Method: "POST", Path: "/v1/prebuilds", Summary: "Get an upload URL of a prebuild of a repository",
+		Auth: userAuth, Request: model.PrebuildRequest{}, Response: model.PrebuildResponse{}, Status: http.StatusCreated,
+		Handler: (*handlers).HandlePrebuild,
+	},
+	{
+		Method: "PUT", Path: "/v1/prebuilds", Summary: "Complete an uploaded prebuild of a repository",
+		Auth: userAuth, Request: model.PrebuildRequest{}, Response: prebuild.Prebuild{},
+		Handler: (*handlers).HandleCompletePrebuild,
+	},
+	{
+		Method: "PUT", Path: "/v1/credentials/heroku", Summary: "Store a Heroku API key of the user to act on their account with",
+		Auth: userAuth, Request: model.HerokuKeyRequest{}, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleSaveHerokuKey,
+	},
+	{
+		Method: "DELETE", Path: "/v1/credentials/heroku", Summary: "Delete the stored Heroku API key of the user",
+		Auth: userAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleDeleteHerokuKey,
+	},
+	{
+		Method: "PUT", Path: "/v1/credentials/github", Summary: "Link the GitHub account of a token to the user, which has to be able to push to the repositories it gets tokens and caches of",
+		Auth: userAuth, Request: model.GitHubTokenRequest{}, Response: model.GitHubAccount{},
+		Handler: (*handlers).HandleSaveGitHubToken,
+	},
+	{
+		Method: "DELETE", Path: "/v1/credentials/github", Summary: "Unlink the GitHub account of the user",
+		Auth: userAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleDeleteGitHubToken,
+	},
+	{
+		Method: "GET", Path: "/v1/settings", Summary: "Get the settings of the user, e.g. how they're notified about their editors",
+		Auth: userAuth, Response: model.UserSettings{},
+		Handler: (*handlers).HandleSettings,
+	},
+	{
+		Method: "PUT", Path: "/v1/settings", Summary: "Update the settings of the user",
+		Auth: userAuth, Request: model.UserSettings{}, Response: model.UserSettings{},
+		Handler: (*handlers).HandleUpdateSettings,
+	},
+	{
+		Method: "GET", Path: "/v1/notifications", Summary: "Push the notifications of the user over a WebSocket, e.g. that an editor is ready or about to be released",
+		Auth: userAuth, Response: model.Notification{}, Status: http.StatusSwitchingProtocols,
+		Query: []openapi.Param{
+			{Name: "after", Description: "ID of the last notification a reconnecting client got, to get the ones it missed"},
+		},
+		Handler: (*handlers).HandleNotifications,
+	},
+	{
+		Method: "POST", Path: "/v1/claims", Summary: "Claim an editor in the background, the claim is polled until it's ready",
+		Auth: userAuth, Request: model.EditorRequest{}, Response: model.Claim{}, Status: http.StatusAccepted,
+		Headers: []openapi.Param{
+			{Name: idempotencyHeader, Description: "Unique key of the claim, retries with it get the claim made first"},
+		},
+		Claims:  true,
+		Handler: (*handlers).HandleCreateClaim,
+	},
+	{
+		Method: "GET", Path: "/v1/claims/{id}", Summary: "Poll a claim, claims that aren't polled for long are abandoned",
+		Auth: userAuth, Response: model.Claim{},
+		Query: []openapi.Param{
+			{Name: "wait", Description: "How long to wait for a pending claim to complete, e.g. 20s, at most 30s"},
+		},
+		Handler: (*handlers).HandleClaim,
+	},
+	{
+		Method: "DELETE", Path: "/v1/claims/{id}", Summary: "Cancel a pending claim, its editor is released once it's claimed",
+		Auth: userAuth, Response: model.Claim{},
+		Handler: (*handlers).HandleCancelClaim,
+	},
+	{
+		Method: "GET", Path: "/v1/approvals", Summary: "List the claims of the user waiting for approval, or of everyone for admins",
+		Auth: userAuth, Response: model.ApprovalsResponse{},
+		Query: []openapi.Param{
+			{Name: "state", Description: "Only approvals in the state, e.g. pending"},
+		},
+		Handler: (*handlers).HandleApprovals,
+	},
+	{
+		Method: "GET", Path: "/v1/approvals/{id}", Summary: "Get a claim waiting for approval",
+		Auth: userAuth, Response: model.ClaimApproval{},
+		Handler: (*handlers).HandleApproval,
+	},
+	{
+		Method: "POST", Path: "/v1/approvals/{id}/approve", Summary: "Approve a claim, which claims the editor (admin)",
+		Auth: userAuth, Response: model.ClaimApproval{},
+		Claims:  true,
+		Handler: (*handlers).HandleApprove,
+	},
+	{
+		Method: "POST", Path: "/v1/approvals/{id}/deny", Summary: "Deny a claim (admin)",
+		Auth: userAuth, Response: model.ClaimApproval{},
+		Handler: (*handlers).HandleDeny,
+	},
+	{
+		Method: "POST", Path: "/v1/impersonations", Summary: "Get a time-limited token to act as another user (support)",
+		Auth: userAuth, Request: model.ImpersonationRequest{}, Response: model.Impersonation{}, Status: http.StatusCreated,
+		Handler: (*handlers).HandleCreateImpersonation,
+	},
+	{
+		Method: "GET", Path: "/v1/impersonations", Summary: "List impersonations (support and admin)",
+		Auth: userAuth, Response: model.ImpersonationsResponse{},
+		Handler: (*handlers).HandleImpersonations,
+	},
+	{
+		Method: "GET", Path: "/v1/impersonations/{id}", Summary: "Get an impersonation and its requests (support and admin)",
+		Auth: userAuth, Response: model.Impersonation{},
+		Handler: (*handlers).HandleImpersonation,
+	},
+	{
+		Method: "DELETE", Path: "/v1/impersonations/{id}", Summary: "Revoke an impersonation (support and admin)",
+		Auth: userAuth, Status: http.StatusNoContent,
+		Handler: (*handlers).HandleRevokeImpersonation,
+	},
+	{
+		Method: "POST", Path: "/v1/device/code", Summary: "Start the login of a device such as the CLI",
+		Response: model.DeviceCode{},
+		Handler:  (*handlers).HandleDeviceCode,
+	},
+	{
+		Method: "POST", Path: "/v1/device/token", Summary: "Poll for the token of an approved device login",
+		Request: model.DeviceTokenRequest{}, Response: model.Token{},
+		Handler: (*handlers).HandleDeviceToken,
+	},
+	{
+		Method: "POST", Path: "/v1/device/refresh", Summary: "Refresh the token of a logged in device",
+		Request: model.RefreshRequest{}, Response: model.Token{},
+		Handler: (*handlers).HandleRefreshToken,
+	},
+}
+
+func (h *handlers) registerAPI(r *mux.Router) {
+	for _, route := range apiRoutes {
+		handle := route.Handler
+		r.Methods(route.Method).Path(route.Path).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(h, w, r)
+		})
+	}
+
+	r.Methods("GET").Path("/openapi.json").HandlerFunc(h.HandleOpenAPI)
+}
+
+var (
+	spec     []byte
+	specOnce sync.Once
+)
+
+// HandleOpenAPI serves the OpenAPI spec of the API, which is generated
+// from apiRoutes once.
+func (h *handlers) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	specOnce.Do(func() {
+		var ops []openapi.Operation
+		for _, route := range apiRoutes {
+			ops = append(ops, openapi.Operation{
+				Method:      route.Method,
+				Path:        route.Path,
+				Summary:     route.Summary,
+				Query:       route.Query,
+				Headers:     route.Headers,
+				Auth:        route.Auth,
+				Request:     route.Request,
+				Response:    route.Response,
+				Status:      route.Status,
+				ContentType: route.ContentType,
+			})
+		}
+
+		doc := openapi.Document(openapi.Info{
+			Title:       "codeface",
+			Version:     "1",
+			Description: "Claim and manage editors of repositories.",
+		}, []openapi.Scheme{
+			{Name: userAuth, Description: "A Heroku API token"},
+			{Name: agentAuth, Description: "The agent token of an editor"},
+		}, ops)
+
+		var err error
+		spec, err = json.Marshal(doc)
+		if err != nil {
+			h.logger.WithError(err).Info("Fail to generate OpenAPI spec")
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(spec)
+}
+
+// validator is implemented by requests that check their fields once
+// decoded.
+type validator interface {
+	Validate() error
+}
+
+// decodeJSON strictly decodes the JSON request body into v: unknown fields
+// and trailing data are rejected, and v is validated if it's a validator.
+// It responds with an error and returns false if the request is invalid.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("invalid request body: %s", err)})
+		return false
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "invalid request body: only a single JSON value is allowed"})
+		return false
+	}
+
+	if val, ok := v.(validator); ok {
+		if err := val.Validate(); err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return false
+		}
+	}
+
+	return true
+}
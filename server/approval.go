@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/slack"
+	"github.com/jingweno/codeface/store"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const approvalsPrefix = "approvals/"
+
+var (
+	errApprovalNotFound = fmt.Errorf("approval is not found")
+	errApprovalDecided  = fmt.Errorf("approval is already decided")
+)
+
+func approvalKey(id string) string {
+	return approvalsPrefix + id
+}
+
+// approval is a claim waiting for approval along with its request, which
+// isn't shown since it may have credentials.
+type approval struct {
+	model.ClaimApproval
+	Request model.EditorRequest
+	// SlackUser is told about the decision if the claim is from Slack
+	SlackUser string `json:",omitempty"`
+}
+
+// approvalReason returns why a claim needs an admin to approve it, or
+// empty if it doesn't. Admins don't need approval.
+func (h *handlers) approvalReason(acct *hkclient.Account, template, dynoSize string) string {
+	if h.isAdmin(acct) {
+		return ""
+	}
+
+	for _, t := range h.approvalTemplates {
+		if t == template {
+			return fmt.Sprintf("template %s needs approval", template)
+		}
+	}
+
+	if h.approvalDynoSize != "" && model.DynoSizeRank(dynoSize) > model.DynoSizeRank(h.approvalDynoSize) {
+		return fmt.Sprintf("dyno sizes above %s need approval", h.approvalDynoSize)
+	}
+
+	return ""
+}
+
+// requestApproval saves a claim that needs approval and tells the admins
+// about it. The editor isn't claimed until an admin approves it.
+func (h *handlers) requestApproval(w http.ResponseWriter, r *http.Request, acct *hkclient.Account, opt model.EditorRequest, reason string) {
+	a, err := h.createApproval(r.Context(), acct.Email, "", opt, reason)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusAccepted, model.EditorResponse{Approval: a})
+}
+
+func (h *handlers) createApproval(ctx context.Context, user, slackUser string, opt model.EditorRequest, reason string) (*model.ClaimApproval, error) {
+	gitRepo := opt.GitRepo
+	if opt.PullRequest != "" {
+		gitRepo = opt.PullRequest
+	}
+
+	a := approval{
+		ClaimApproval: model.ClaimApproval{
+			ID:        xid.New().String(),
+			User:      user,
+			Template:  opt.Template,
+			DynoSize:  opt.DynoSize,
+			GitRepo:   gitRepo,
+			Reason:    reason,
+			State:     model.ApprovalStatePending,
+			CreatedAt: time.Now(),
+		},
+		Request:   opt,
+		SlackUser: slackUser,
+	}
+
+	if err := h.state.Put(ctx, approvalKey(a.ID), a); err != nil {
+		return nil, err
+	}
+
+	logger := h.logger.WithFields(log.Fields{"approval": a.ID, "user": user, "reason": reason})
+	logger.Info("Claim is waiting for approval")
+
+	if h.approvalChannel != "" {
+		text := fmt.Sprintf("%s wants to claim an editor, %s: %s\nApprove it with `/codeface approve %s` or deny it with `/codeface deny %s`, or in the dashboard.",
+			user, reason, describeApproval(a.ClaimApproval), a.ID, a.ID)
+		if err := h.slack.PostMessage(ctx, slack.Message{Channel: h.approvalChannel, Text: text}); err != nil {
+			logger.WithError(err).Info("Fail to send Slack message")
+		}
+	}
+
+	return &a.ClaimApproval, nil
+}
+
+func describeApproval(a model.ClaimApproval) string {
+	desc := "template " + a.Template
+	if a.Template == "" {
+		desc = "any template"
+	}
+	if a.DynoSize != "" {
+		desc += ", dyno size " + a.DynoSize
+	}
+	if a.GitRepo != "" {
+		desc += ", " + a.GitRepo
+	}
+
+	return desc
+}
+
+// decideApproval approves or denies a claim for an admin. An approved claim
+// is claimed in the background, since it takes longer than the request.
+func (h *handlers) decideApproval(ctx context.Context, id, admin string, approve bool) (*model.ClaimApproval, error) {
+	var a approval
+	err := h.state.Get(ctx, approvalKey(id), &a)
+	if err == store.ErrNotFound {
+		return nil, errApprovalNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.State != model.ApprovalStatePending {
+		return nil, errApprovalDecided
+	}
+
+	now := time.Now()
+	a.DecidedBy = admin
+	a.DecidedAt = &now
+	a.State = model.ApprovalStateDenied
+	if approve {
+		a.State = model.ApprovalStateApproved
+	}
+
+	if err := h.state.Put(ctx, approvalKey(id), a); err != nil {
+		return nil, err
+	}
+
+	h.logger.WithFields(log.Fields{"approval": id, "user": a.User, "admin": admin, "state": a.State}).Info("Decided claim approval")
+
+	if approve {
+		go h.claimApproved(context.Background(), a)
+	} else {
+		h.notifyApproval(ctx, a, fmt.Sprintf("Your claim `%s` was denied by %s.", a.ID, admin))
+	}
+
+	return &a.ClaimApproval, nil
+}
+
+func (h *handlers) claimApproved(ctx context.Context, a approval) {
+	logger := h.logger.WithFields(log.Fields{"approval": a.ID, "user": a.User})
+
+	ed, _, err := h.claimEditor(ctx, a.User, a.Request, "")
+	if err != nil {
+		a.State = model.ApprovalStateFailed
+		a.Error = err.Error()
+	} else {
+		a.State = model.ApprovalStateReady
+		a.Editor = ed.Name
+		a.URL = ed.URL
+	}
+
+	// the request isn't needed anymore
+	a.Request = model.EditorRequest{}
+	if err := h.state.Put(ctx, approvalKey(a.ID), a); err != nil {
+		logger.WithError(err).Info("Fail to save approval")
+		return
+	}
+
+	logger.WithField("state", a.State).Info("Claimed approved editor")
+
+	if a.State == model.ApprovalStateReady {
+		h.notifyApproval(ctx, a, fmt.Sprintf("Your claim `%s` was approved, your editor %s is ready: %s", a.ID, a.Editor, a.URL))
+	} else {
+		h.notifyApproval(ctx, a, fmt.Sprintf("Your claim `%s` was approved, but it failed: %s", a.ID, a.Error))
+	}
+}
+
+// notifyApproval sends a direct message about a decision to the Slack user
+// who made the claim.
+func (h *handlers) notifyApproval(ctx context.Context, a approval, text string) {
+	if a.SlackUser == "" {
+		return
+	}
+
+	if err := h.slack.PostMessage(ctx, slack.Message{Channel: a.SlackUser, Text: text}); err != nil {
+		h.logger.WithError(err).WithField("approval", a.ID).Info("Fail to send Slack message")
+	}
+}
+
+// HandleApprovals lists the claims of the user waiting for approval or
+// approved, or of everyone for admins, newest first.
+func (h *handlers) HandleApprovals(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	state := r.URL.Query().Get("state")
+
+	approvals, err := h.approvals(r.Context())
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := model.ApprovalsResponse{Approvals: []model.ClaimApproval{}}
+	for _, a := range approvals {
+		if (h.isAdmin(acct) || a.User == acct.Email) && (state == "" || a.State == state) {
+			resp.Approvals = append(resp.Approvals, a)
+		}
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+func (h *handlers) approvals(ctx context.Context) ([]model.ClaimApproval, error) {
+	keys, err := h.state.List(ctx, approvalsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var approvals []model.ClaimApproval
+	for _, k := range keys {
+		var a approval
+		if err := h.state.Get(ctx, k, &a); err != nil {
+			continue
+		}
+		approvals = append(approvals, a.ClaimApproval)
+	}
+
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].CreatedAt.After(approvals[j].CreatedAt)
+	})
+
+	return approvals, nil
+}
+
+func (h *handlers) HandleApproval(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var a approval
+	err := h.state.Get(r.Context(), approvalKey(mux.Vars(r)["id"]), &a)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && a.User != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: errApprovalNotFound.Error()})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, a.ClaimApproval)
+}
+
+func (h *handlers) HandleApprove(w http.ResponseWriter, r *http.Request) {
+	h.handleDecision(w, r, true)
+}
+
+func (h *handlers) HandleDeny(w http.ResponseWriter, r *http.Request) {
+	h.handleDecision(w, r, false)
+}
+
+func (h *handlers) handleDecision(w http.ResponseWriter, r *http.Request, approve bool) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may approve claims"})
+		return
+	}
+
+	a, err := h.decideApproval(r.Context(), mux.Vars(r)["id"], acct.Email, approve)
+	switch err {
+	case nil:
+		jsonResp(w, http.StatusOK, a)
+	case errApprovalNotFound:
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+	case errApprovalDecided:
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: err.Error()})
+	default:
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// HandleArtifacts lists the artifacts of a template, newest first, along
+// with the one pool editors are promoted from.
+func (h *handlers) HandleArtifacts(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at artifacts"})
+		return
+	}
+
+	template := mux.Vars(r)["template"]
+
+	arts, err := artifact.List(r.Context(), h.state, template)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := model.ArtifactsResponse{Artifacts: []model.Artifact{}}
+	for _, art := range arts {
+		art.Manifest = ""
+		resp.Artifacts = append(resp.Artifacts, art)
+	}
+
+	if promo, err := artifact.Promotion(r.Context(), h.state, "", template); err == nil {
+		resp.Promotion = promo
+	}
+	if promo, err := artifact.Promotion(r.Context(), h.state, model.EnvironmentProduction, template); err == nil {
+		resp.Production = promo
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandlePromote pins the pool of a template to one of its artifacts to roll
+// it back, or unpins it so that the newest build of the template is
+// promoted again. Idle editors are replaced by the worker.
+func (h *handlers) HandlePromote(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may promote artifacts"})
+		return
+	}
+
+	template := mux.Vars(r)["template"]
+
+	var req model.PromoteRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	promo, err := artifact.Promotion(r.Context(), h.state, "", template)
+	if err == store.ErrNotFound {
+		promo = &model.Promotion{Template: template}
+	} else if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if req.Version == "" {
+		// the worker promotes the newest build on its next deploy
+		promo.Pinned = false
+	} else {
+		art, err := artifact.Get(r.Context(), h.state, template, req.Version)
+		if err == store.ErrNotFound {
+			jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "artifact is not found"})
+			return
+		}
+		if err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		promo = &model.Promotion{
+			Template:   template,
+			Version:    art.Version,
+			PromotedAt: time.Now(),
+			Pinned:     true,
+		}
+	}
+
+	if err := artifact.Promote(r.Context(), h.state, *promo); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithField("template", template).WithField("version", promo.Version).WithField("pinned", promo.Pinned).Info("Promoted artifact")
+
+	jsonResp(w, http.StatusOK, promo)
+}
+
+// HandleRegisterArtifact records an artifact that is built outside of the
+// worker, e.g. an editor image pushed to a registry by CI. It's promoted by
+// the worker once it's the version of the template on disk.
+func (h *handlers) HandleRegisterArtifact(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may register artifacts"})
+		return
+	}
+
+	var art model.Artifact
+	if !decodeJSON(w, r, &art) {
+		return
+	}
+
+	art.Template = mux.Vars(r)["template"]
+	if art.Version == "" {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "artifact has no version"})
+		return
+	}
+	// heroku artifacts are coupled to the pipeline of the template by the
+	// worker
+	if art.Kind != artifact.OCI && art.Kind != artifact.S3 {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "only oci and s3 artifacts may be registered"})
+		return
+	}
+	if err := artifact.ValidateRef(art.Kind, art.Ref); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	art.CreatedAt = time.Now()
+
+	if err := h.state.Put(r.Context(), artifact.Key(art.Template, art.Version), art); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithField("template", art.Template).WithField("version", art.Version).WithField("ref", art.Ref).Info("Registered artifact")
+
+	jsonResp(w, http.StatusCreated, art)
+}
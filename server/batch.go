@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/mail"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const batchesPrefix = "batches/"
+
+func batchKey(id string) string {
+	return batchesPrefix + id
+}
+
+// seatKey is the key of the seat a token opens, only a hash of the token
+// is stored.
+func seatKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "seats/" + hex.EncodeToString(sum[:])
+}
+
+type seat struct {
+	Batch  string
+	Editor string
+}
+
+// HandleCreateBatch provisions editors for the seats of a workshop or a
+// class. They're claimed for the user one after the other in the
+// background, and the batch is polled until it's ready.
+func (h *handlers) HandleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var req model.BatchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Count > h.batchMaxEditors {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("batches have at most %d editors", h.batchMaxEditors)})
+		return
+	}
+	if req.Template != "" && req.Template == h.guestTemplate {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "the guest pool is kept for guests"})
+		return
+	}
+	if c := h.checkClaim(r.Context(), acct, model.EditorRequest{Template: req.Template, GitRepo: req.GitRepo}); c != nil {
+		c.refuse(w, "%s, which batches can't wait for")
+		return
+	}
+	if len(req.Invitees) > 0 && (h.notifier.Mail == nil || h.serverURL == "") {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "batch invitations need email and SERVER_URL to be set up on the server"})
+		return
+	}
+
+	claimOpts := editor.ClaimOptions{
+		Template:  req.Template,
+		Recipient: acct.Email,
+	}
+	var url string
+	if req.GitRepo != "" {
+		var err error
+		url, err = model.ParseRepoURL(req.GitRepo, nil)
+		if err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		claimOpts.GitRepo = url
+		claimOpts.GitRef = req.GitRef
+	}
+	claimOpts.CacheURL = h.cacheURL(r.Context(), url)
+	h.withDepsCache(&claimOpts)
+	// the seats are used by others than the owner, who'd all upload to the
+	// cache of the owner
+	claimOpts.DepsCacheUploadURL = ""
+	h.withPool(&claimOpts)
+
+	b := model.Batch{
+		ID:        xid.New().String(),
+		Owner:     acct.Email,
+		Template:  req.Template,
+		GitRepo:   url,
+		Count:     req.Count,
+		State:     model.BatchStateProvisioning,
+		CreatedAt: time.Now(),
+		Editors:   []model.BatchEditor{},
+	}
+	if err := h.state.Put(r.Context(), batchKey(b.ID), b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"batch": b.ID, "user": acct.Email, "count": b.Count}).Info("Provisioning batch")
+
+	// claims outlive the request, which would time out at the router
+	go h.provisionBatch(context.Background(), b, claimOpts, url, req.Invitees)
+
+	jsonResp(w, http.StatusAccepted, b)
+}
+
+func (h *handlers) provisionBatch(ctx context.Context, b model.Batch, base editor.ClaimOptions, gitRepo string, invitees []string) {
+	logger := h.logger.WithField("batch", b.ID)
+
+	fail := func(err error) {
+		logger.WithError(err).Info("Fail to provision batch")
+		b.State = model.BatchStateFailed
+		b.Error = err.Error()
+		if err := h.state.Put(ctx, batchKey(b.ID), b); err != nil {
+			logger.WithError(err).Info("Fail to save batch")
+		}
+	}
+
+	for i := len(b.Editors); i < b.Count; i++ {
+		// stop once the batch is torn down
+		var cur model.Batch
+		if err := h.state.Get(ctx, batchKey(b.ID), &cur); err != nil || cur.State == model.BatchStateDeleted {
+			return
+		}
+
+		opts := base
+		h.withAgent(&opts)
+		h.withViewer(&opts)
+		if err := h.withSecrets(ctx, &opts); err != nil {
+			fail(err)
+			return
+		}
+		if err := h.withUser(ctx, &opts); err != nil {
+			fail(err)
+			return
+		}
+
+		claimedAt := time.Now()
+		ed, err := h.provider.Claim(ctx, opts)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		h.registerAgent(ctx, opts, ed)
+		h.registerViewer(ctx, opts, ed)
+		h.startSession(ctx, ed, b.Owner, gitRepo, nil, claimedAt)
+		h.markBatchSession(ctx, ed.Name, b.ID)
+
+		// the batch may be torn down while the editor is claimed
+		if err := h.state.Get(ctx, batchKey(b.ID), &cur); err != nil || cur.State == model.BatchStateDeleted {
+			logger.WithField("app", ed.Name).Info("Batch is torn down, releasing editor")
+			if err := h.provider.Delete(ctx, ed.Name); err != nil {
+				logger.WithError(err).WithField("app", ed.Name).Info("Fail to terminate editor")
+			}
+			if _, err := usage.EndSession(ctx, h.state, ed.Name, time.Now()); err != nil {
+				logger.WithError(err).WithField("app", ed.Name).Info("Fail to end session")
+			}
+			return
+		}
+
+		token, err := newSeatToken()
+		if err == nil {
+			err = h.state.Put(ctx, seatKey(token), seat{Batch: b.ID, Editor: ed.Name})
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		be := model.BatchEditor{
+			Seat:  i + 1,
+			Name:  ed.Name,
+			URL:   ed.URL,
+			Token: token,
+		}
+		if i < len(invitees) {
+			be.Invitee = invitees[i]
+			if err := h.inviteToSeat(ctx, b, be); err != nil {
+				logger.WithError(err).WithField("invitee", be.Invitee).Info("Fail to email batch invitation")
+				be.InviteError = err.Error()
+			}
+		}
+		b.Editors = append(b.Editors, be)
+		if i == b.Count-1 {
+			b.State = model.BatchStateReady
+		}
+		if err := h.state.Put(ctx, batchKey(b.ID), b); err != nil {
+			logger.WithError(err).Info("Fail to save batch")
+			return
+		}
+	}
+
+	logger.Info("Batch is ready")
+}
+
+// batchInvitation is the data of the batch.invitation mail template.
+type batchInvitation struct {
+	Owner    string
+	Template string
+	GitRepo  string
+	Seat     int
+	SeatURL  string
+}
+
+// inviteToSeat emails the seat link of an editor of a batch to its invitee.
+func (h *handlers) inviteToSeat(ctx context.Context, b model.Batch, be model.BatchEditor) error {
+	msg, err := mail.Render(mail.TemplateBatchInvitation, be.Invitee, batchInvitation{
+		Owner:    b.Owner,
+		Template: b.Template,
+		GitRepo:  b.GitRepo,
+		Seat:     be.Seat,
+		SeatURL:  h.serverURL + "/seat?token=" + be.Token,
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.notifier.Mail.Send(ctx, msg)
+}
+
+func (h *handlers) markBatchSession(ctx context.Context, name, batch string) {
+	var s model.Session
+	err := h.state.Get(ctx, usage.SessionKey(name), &s)
+	if err == nil {
+		s.Batch = batch
+		err = h.state.Put(ctx, usage.SessionKey(name), s)
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("app", name).Info("Fail to mark session of batch")
+	}
+}
+
+func newSeatToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// HandleBatches lists the batches of the user, or of everyone for admins,
+// newest first.
+func (h *handlers) HandleBatches(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	keys, err := h.state.List(r.Context(), batchesPrefix)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := model.BatchesResponse{Batches: []model.Batch{}}
+	for _, k := range keys {
+		var b model.Batch
+		if err := h.state.Get(r.Context(), k, &b); err != nil {
+			continue
+		}
+		if h.isAdmin(acct) || b.Owner == acct.Email {
+			resp.Batches = append(resp.Batches, b)
+		}
+	}
+
+	sort.Slice(resp.Batches, func(i, j int) bool {
+		return resp.Batches[i].CreatedAt.After(resp.Batches[j].CreatedAt)
+	})
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+func (h *handlers) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	b, ok := h.ownedBatch(w, r)
+	if !ok {
+		return
+	}
+
+	jsonResp(w, http.StatusOK, b)
+}
+
+// HandleBatchCSV returns the seats of a batch as CSV, e.g. to mail merge
+// the seat links.
+func (h *handlers) HandleBatchCSV(w http.ResponseWriter, r *http.Request) {
+	b, ok := h.ownedBatch(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=batch-%s.csv", b.ID))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seat", "editor", "url", "token", "seat_url", "invitee"})
+	for _, ed := range b.Editors {
+		cw.Write([]string{strconv.Itoa(ed.Seat), ed.Name, ed.URL, ed.Token, h.serverURL + "/seat?token=" + ed.Token, ed.Invitee})
+	}
+	cw.Flush()
+}
+
+// HandleDeleteBatch tears down every editor of a batch at once. Editors
+// are deleted right away, even with a delete grace period.
+func (h *handlers) HandleDeleteBatch(w http.ResponseWriter, r *http.Request) {
+	b, ok := h.ownedBatch(w, r)
+	if !ok {
+		return
+	}
+
+	if b.State == model.BatchStateDeleted {
+		jsonResp(w, http.StatusOK, b)
+		return
+	}
+
+	now := time.Now()
+	logger := h.logger.WithField("batch", b.ID)
+	logger.Info("Tearing down batch")
+
+	// stop the provisioning first so that no editor is claimed after
+	b.State = model.BatchStateDeleted
+	b.DeletedAt = &now
+	if err := h.state.Put(r.Context(), batchKey(b.ID), b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	for _, ed := range b.Editors {
+		if err := h.state.Delete(r.Context(), seatKey(ed.Token)); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).WithField("app", ed.Name).Info("Fail to delete seat")
+		}
+
+		var s model.Session
+		if err := h.state.Get(r.Context(), usage.SessionKey(ed.Name), &s); err != nil || s.EndedAt != nil {
+			continue
+		}
+
+		if err := h.provider.Delete(r.Context(), ed.Name); err != nil {
+			logger.WithError(err).WithField("app", ed.Name).Info("Fail to terminate editor")
+			continue
+		}
+
+		if _, err := usage.EndSession(r.Context(), h.state, ed.Name, now); err != nil {
+			logger.WithError(err).WithField("app", ed.Name).Info("Fail to end session")
+		}
+	}
+
+	jsonResp(w, http.StatusOK, b)
+}
+
+func (h *handlers) ownedBatch(w http.ResponseWriter, r *http.Request) (*model.Batch, bool) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var b model.Batch
+	err := h.state.Get(r.Context(), batchKey(mux.Vars(r)["id"]), &b)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && b.Owner != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "batch is not found"})
+		return nil, false
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+
+	return &b, true
+}
+
+// HandleSeat redirects the holder of a seat token to the editor of the
+// seat, without logging in.
+func (h *handlers) HandleSeat(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "seat token is missing", http.StatusUnauthorized)
+		return
+	}
+
+	var st seat
+	if err := h.state.Get(r.Context(), seatKey(token), &st); err != nil {
+		http.Error(w, "seat is not found", http.StatusNotFound)
+		return
+	}
+
+	var b model.Batch
+	if err := h.state.Get(r.Context(), batchKey(st.Batch), &b); err != nil || b.State == model.BatchStateDeleted {
+		http.Error(w, "seat is not found", http.StatusNotFound)
+		return
+	}
+
+	for _, ed := range b.Editors {
+		if ed.Name == st.Editor {
+			http.Redirect(w, r, ed.URL, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	http.Error(w, "seat is not found", http.StatusNotFound)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// bulkDeleteConcurrency is how many editors a bulk delete deletes at once,
+// so that a large cleanup finishes within the timeout of the router
+// without hitting the rate limits of the provider.
+const bulkDeleteConcurrency = 10
+
+// HandleDeleteEditors deletes the claimed editors that match every filter
+// for admins, e.g. the editors of an offboarded user or of a workshop,
+// like DELETE /v1/editors/{name} does one at a time. A dry run lists them
+// without deleting them.
+func (h *handlers) HandleDeleteEditors(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	query := r.URL.Query()
+
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may delete editors in bulk"})
+		return
+	}
+
+	owner := query.Get("owner")
+	template := query.Get("template")
+	labels, err := model.ParseLabelSelector(query["label"])
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var olderThan time.Duration
+	if v := query.Get("older_than"); v != "" {
+		if olderThan, err = time.ParseDuration(v); err != nil || olderThan <= 0 {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "older_than must be a positive duration, e.g. 24h"})
+			return
+		}
+	}
+
+	dryRun := false
+	if v := query.Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "dry_run must be true or false"})
+			return
+		}
+	}
+
+	// a request without filters would delete every claimed editor
+	if owner == "" && template == "" && len(labels) == 0 && olderThan == 0 {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "Please provide at least one of owner, template, label or older_than"})
+		return
+	}
+
+	sessions, err := usage.OpenSessions(r.Context(), h.state)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	var matched []model.Session
+	for _, s := range sessions {
+		claimedAt := s.ClaimedAt
+		if claimedAt.IsZero() {
+			claimedAt = s.StartedAt
+		}
+
+		if owner != "" && s.User != owner {
+			continue
+		}
+		if template != "" && s.Template != template {
+			continue
+		}
+		if !model.MatchLabels(s.Labels, labels) {
+			continue
+		}
+		if olderThan > 0 && now.Sub(claimedAt) < olderThan {
+			continue
+		}
+		matched = append(matched, s)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].App < matched[j].App })
+
+	logger := h.logger.WithFields(log.Fields{"user": acct.Email, "editors": len(matched), "dry_run": dryRun})
+	logger.Info("Deleting editors in bulk")
+
+	var failed map[string]error
+	if !dryRun {
+		failed = h.deleteEditors(r.Context(), matched, acct.Email)
+	}
+
+	resp := model.BulkDeleteResponse{DryRun: dryRun, Editors: []model.EditorSummary{}}
+	for _, s := range matched {
+		if err, ok := failed[s.App]; ok {
+			resp.Failed = append(resp.Failed, model.BulkDeleteFailure{Name: s.App, Error: err.Error()})
+			continue
+		}
+
+		claimedAt := s.ClaimedAt
+		if claimedAt.IsZero() {
+			claimedAt = s.StartedAt
+		}
+		resp.Editors = append(resp.Editors, model.EditorSummary{
+			Name:      s.App,
+			Provider:  provider.OfSession(s),
+			Template:  s.Template,
+			State:     model.EditorStateClaimed,
+			Owner:     s.User,
+			ClaimedAt: &claimedAt,
+			Labels:    s.Labels,
+		})
+	}
+	if len(resp.Failed) > 0 {
+		logger.WithField("failed", len(resp.Failed)).Info("Fail to delete some editors in bulk")
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// deleteEditors deletes the editors of sessions a few at a time, and
+// returns the errors of the ones that failed by name.
+func (h *handlers) deleteEditors(ctx context.Context, sessions []model.Session, user string) map[string]error {
+	var (
+		mu     sync.Mutex
+		failed = make(map[string]error)
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, bulkDeleteConcurrency)
+	)
+
+	for _, s := range sessions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(s model.Session) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			logger := h.logger.WithFields(log.Fields{"app": s.App, "user": user})
+			if err := h.deleteEditor(ctx, s, user, logger); err != nil {
+				mu.Lock()
+				failed[s.App] = err
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	return failed
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// appCap returns the cap on the total apps of the fleet while it's
+// reached, see model.AppCap, and nil otherwise.
+func (h *handlers) appCap(ctx context.Context) *model.AppCap {
+	var c model.AppCap
+	err := h.state.Get(ctx, editor.AppCapKey, &c)
+	if err == store.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		// claims aren't refused because the store is flaky
+		h.logger.WithError(err).Info("Fail to get app cap")
+		return nil
+	}
+
+	return &c
+}
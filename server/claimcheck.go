@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+)
+
+// claimCheck is why a claim is refused before an editor is claimed, see
+// checkClaim.
+type claimCheck struct {
+	Status int
+	Error  string
+	// RetryAfter is set when the claim may be retried later
+	RetryAfter time.Duration
+	// ApprovalReason is set instead of Error when the claim needs an admin
+	// to approve it
+	ApprovalReason string
+}
+
+// checkClaim runs the checks every claim goes through before an editor is
+// claimed, whichever way it's made: the fleet must be below its app cap,
+// the provider must support the claim, CLAIM_POLICIES must allow it and
+// it mustn't need approval. It returns nil if the claim may go on.
+func (h *handlers) checkClaim(ctx context.Context, acct *hkclient.Account, opt model.EditorRequest) *claimCheck {
+	// clients retry once the worker checked the cap again
+	if c := h.appCap(ctx); c != nil {
+		retry := c.RetryAfter
+		if retry <= 0 {
+			retry = time.Minute
+		}
+		return &claimCheck{
+			Status:     http.StatusServiceUnavailable,
+			Error:      fmt.Sprintf("the fleet reached its cap of %d apps, retry later", c.Max),
+			RetryAfter: retry,
+		}
+	}
+
+	if opt.DynoSize != "" && !h.provider.Capabilities().DynoSizes {
+		return &claimCheck{Status: http.StatusUnprocessableEntity, Error: "the provider doesn't support dyno sizes"}
+	}
+	if reason := h.policyDenial(acct, opt); reason != "" {
+		return &claimCheck{Status: http.StatusForbidden, Error: reason}
+	}
+	if reason := h.approvalReason(acct, opt.Template, opt.DynoSize); reason != "" {
+		return &claimCheck{Status: http.StatusForbidden, ApprovalReason: reason}
+	}
+
+	return nil
+}
+
+// message returns the status and the message to refuse the claim with.
+// Claims that need approval are refused with approvalFormat, which tells
+// why the claim can't wait for it.
+func (c *claimCheck) message(approvalFormat string) (int, string) {
+	if c.ApprovalReason != "" {
+		return c.Status, fmt.Sprintf(approvalFormat, c.ApprovalReason)
+	}
+
+	return c.Status, c.Error
+}
+
+func (c *claimCheck) setRetryAfter(w http.ResponseWriter) {
+	if c.RetryAfter > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(c.RetryAfter.Seconds()))))
+	}
+}
+
+// refuse refuses the claim of an API request.
+func (c *claimCheck) refuse(w http.ResponseWriter, approvalFormat string) {
+	status, msg := c.message(approvalFormat)
+	c.setRetryAfter(w)
+	jsonResp(w, status, model.ErrorResponse{Error: msg})
+}
+
+// refusePage refuses the claim of a page opened in the browser.
+func (c *claimCheck) refusePage(w http.ResponseWriter, approvalFormat string) {
+	status, msg := c.message(approvalFormat)
+	c.setRetryAfter(w)
+	http.Error(w, msg, status)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/policy"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// unclaimableProvider fails the tests that claim an editor from it.
+type unclaimableProvider struct {
+	provider.Provider
+	t    *testing.T
+	caps model.Capabilities
+}
+
+func (p *unclaimableProvider) Capabilities() model.Capabilities {
+	return p.caps
+}
+
+func (p *unclaimableProvider) Claim(ctx context.Context, opts editor.ClaimOptions) (*provider.Editor, error) {
+	p.t.Errorf("claimed an editor for %s, want the claim refused", opts.Recipient)
+	return nil, editor.ErrNoIdleApp
+}
+
+func newCheckedHandlers(t *testing.T, rules string) *handlers {
+	policies, err := policy.Parse(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return &handlers{
+		state:             store.NewMemory(),
+		provider:          &unclaimableProvider{t: t},
+		logger:            logger,
+		adminUsers:        []string{"admin@example.com"},
+		approvalTemplates: []string{"gpu"},
+		approvalDynoSize:  "performance-m",
+		policies:          policies,
+		policyLocation:    time.UTC,
+		serverURL:         "https://codeface.example.com",
+		batchMaxEditors:   10,
+	}
+}
+
+func TestCheckClaim(t *testing.T) {
+	ctx := context.Background()
+	user := &hkclient.Account{Email: "a@example.com"}
+	admin := &hkclient.Account{Email: "admin@example.com"}
+
+	cases := []struct {
+		name     string
+		acct     *hkclient.Account
+		opt      model.EditorRequest
+		capped   bool
+		dynos    bool
+		status   int
+		approval string
+	}{
+		{name: "allowed", acct: user, opt: model.EditorRequest{Template: "go"}, dynos: true},
+		{name: "capped", acct: admin, opt: model.EditorRequest{Template: "go"}, capped: true, status: http.StatusServiceUnavailable},
+		{name: "dyno sizes unsupported", acct: user, opt: model.EditorRequest{DynoSize: "standard-2x"}, status: http.StatusUnprocessableEntity},
+		{name: "denied by policy", acct: user, opt: model.EditorRequest{Template: "python"}, dynos: true, status: http.StatusForbidden},
+		{name: "template needs approval", acct: user, opt: model.EditorRequest{Template: "gpu"}, dynos: true, status: http.StatusForbidden, approval: "template gpu needs approval"},
+		{name: "dyno size needs approval", acct: user, opt: model.EditorRequest{DynoSize: "performance-l"}, dynos: true, status: http.StatusForbidden, approval: "dyno sizes above performance-m need approval"},
+		{name: "admins skip policies and approvals", acct: admin, opt: model.EditorRequest{Template: "gpu", DynoSize: "performance-l"}, dynos: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newCheckedHandlers(t, `template == "python" => no python editors`)
+			h.provider = &unclaimableProvider{t: t, caps: model.Capabilities{DynoSizes: c.dynos}}
+			if c.capped {
+				if err := h.state.Put(ctx, editor.AppCapKey, model.AppCap{Apps: 100, Max: 100, RetryAfter: 90 * time.Second}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			check := h.checkClaim(ctx, c.acct, c.opt)
+			if c.status == 0 {
+				if check != nil {
+					t.Fatalf("checkClaim = %+v, want the claim allowed", check)
+				}
+				return
+			}
+
+			if check == nil {
+				t.Fatalf("checkClaim allowed the claim, want %d", c.status)
+			}
+			if check.Status != c.status || check.ApprovalReason != c.approval {
+				t.Errorf("checkClaim = %+v, want %d with the approval reason %q", check, c.status, c.approval)
+			}
+			if c.capped && check.RetryAfter != 90*time.Second {
+				t.Errorf("RetryAfter = %s, want the one of the cap", check.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestClaimPathsAreChecked(t *testing.T) {
+	paths := []struct {
+		name    string
+		handler func(h *handlers) http.HandlerFunc
+		body    string
+	}{
+		{"POST /editor", func(h *handlers) http.HandlerFunc { return h.handleEditor }, `{"Template": "python"}`},
+		{"POST /v1/claims", func(h *handlers) http.HandlerFunc { return h.handleCreateClaim }, `{"Template": "python"}`},
+		{"POST /v1/runs", func(h *handlers) http.HandlerFunc { return h.HandleCreateRun }, `{"Template": "python", "Command": "make test"}`},
+		{"POST /v1/batches", func(h *handlers) http.HandlerFunc { return h.HandleCreateBatch }, `{"Template": "python", "Count": 2}`},
+	}
+
+	for _, p := range paths {
+		t.Run(p.name, func(t *testing.T) {
+			h := newCheckedHandlers(t, `template == "python" => no python editors`)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(p.body))
+			req = req.WithContext(context.WithValue(req.Context(), accountKey, &hkclient.Account{Email: "a@example.com"}))
+			w := httptest.NewRecorder()
+			p.handler(h)(w, req)
+
+			if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "no python editors") {
+				t.Errorf("response = %d %s, want the claim denied by the policy", w.Code, w.Body)
+			}
+		})
+	}
+}
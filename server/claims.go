@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// claimTimeout is how long a claim made in the background may take,
+	// e.g. a cold deploy of a template whose pool is empty
+	claimTimeout = 30 * time.Minute
+	// maxClaimWait is how long a poll of a pending claim may wait for it
+	// to complete
+	maxClaimWait = 30 * time.Second
+	// claimWaitInterval is how often a waiting poll checks the claim
+	claimWaitInterval = time.Second
+)
+
+var (
+	errClaimNotFound = fmt.Errorf("claim is not found")
+	errClaimDone     = fmt.Errorf("claim is already completed")
+)
+
+func (h *handlers) HandleCreateClaim(w http.ResponseWriter, r *http.Request) {
+	h.idempotent(w, r, h.handleCreateClaim)
+}
+
+// handleCreateClaim accepts a claim and claims the editor in the
+// background. The client polls the claim until it's ready, and can poll it
+// again after a disconnect.
+func (h *handlers) handleCreateClaim(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var opt model.EditorRequest
+	if !decodeJSON(w, r, &opt) {
+		return
+	}
+
+	if c := h.checkClaim(r.Context(), acct, opt); c != nil {
+		c.refuse(w, "claims that need approval are made with POST /editor, %s")
+		return
+	}
+
+	now := time.Now()
+	c := model.Claim{
+		ID:        xid.New().String(),
+		User:      acct.Email,
+		Template:  opt.Template,
+		State:     model.ClaimStatePending,
+		CreatedAt: now,
+		PolledAt:  now,
+		Labels:    opt.Labels,
+	}
+	if err := h.state.Put(r.Context(), editor.ClaimKey(c.ID), c); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"claim": c.ID, "user": c.User}).Info("Claiming editor in the background")
+	go h.completeClaim(context.Background(), c, opt)
+
+	w.Header().Set("Location", "/v1/claims/"+c.ID)
+	jsonResp(w, http.StatusAccepted, c)
+}
+
+// completeClaim claims the editor of a claim and saves the outcome. The
+// editor is released right away if the claim was canceled or abandoned
+// meanwhile.
+func (h *handlers) completeClaim(ctx context.Context, c model.Claim, opt model.EditorRequest) {
+	logger := h.logger.WithFields(log.Fields{"claim": c.ID, "user": c.User})
+
+	claimCtx, cancel := context.WithTimeout(ctx, claimTimeout)
+	defer cancel()
+
+	ed, _, err := h.claimEditor(claimCtx, c.User, opt, "")
+
+	// the claim may have been canceled or abandoned while it was claimed
+	var cur model.Claim
+	if gerr := h.state.Get(ctx, editor.ClaimKey(c.ID), &cur); gerr == nil {
+		c = cur
+	} else if gerr != store.ErrNotFound {
+		logger.WithError(gerr).Info("Fail to get claim")
+	}
+
+	if c.State != model.ClaimStatePending {
+		if err == nil {
+			logger = logger.WithField("app", ed.Name)
+			logger.WithField("state", c.State).Info("Releasing editor of claim that's not waited for anymore")
+			h.releaseClaimed(ctx, ed.Name, logger)
+		}
+		return
+	}
+
+	now := time.Now()
+	c.CompletedAt = &now
+	if err != nil {
+		c.State = model.ClaimStateFailed
+		c.Error = err.Error()
+	} else {
+		c.State = model.ClaimStateReady
+		c.Editor = ed.Name
+		c.URL = ed.URL
+	}
+
+	if err := h.state.Put(ctx, editor.ClaimKey(c.ID), c); err != nil {
+		logger.WithError(err).Info("Fail to save claim")
+		return
+	}
+
+	logger.WithField("state", c.State).Info("Completed claim")
+}
+
+// releaseClaimed terminates an editor claimed for a claim that nobody waits
+// for anymore.
+func (h *handlers) releaseClaimed(ctx context.Context, name string, logger log.FieldLogger) {
+	var s model.Session
+	if err := h.state.Get(ctx, usage.SessionKey(name), &s); err != nil {
+		logger.WithError(err).Info("Fail to get session")
+		return
+	}
+
+	h.terminate(ctx, s, logger)
+}
+
+// HandleClaim returns a claim to its user and to admins. Polls of the user
+// keep the claim from being abandoned. A poll of a pending claim with wait
+// set waits up to that long for it to complete.
+func (h *handlers) HandleClaim(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	id := mux.Vars(r)["id"]
+
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			jsonResp(w, http.StatusBadRequest, model.ErrorResponse{Error: "Please provide wait as a duration, e.g. 20s"})
+			return
+		}
+		wait = d
+		if wait > maxClaimWait {
+			wait = maxClaimWait
+		}
+	}
+
+	c, err := h.claim(r.Context(), acct, id)
+	if err == errClaimNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if c.User == acct.Email {
+		now := time.Now()
+		if err := h.state.Put(r.Context(), editor.ClaimPollKey(id), now); err != nil {
+			h.logger.WithError(err).WithField("claim", id).Info("Fail to save claim poll")
+		}
+		c.PolledAt = now
+	}
+
+	for deadline := time.Now().Add(wait); c.State == model.ClaimStatePending && time.Now().Before(deadline); {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(claimWaitInterval):
+		}
+
+		if c, err = h.claim(r.Context(), acct, id); err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	jsonResp(w, http.StatusOK, c)
+}
+
+func (h *handlers) claim(ctx context.Context, acct *hkclient.Account, id string) (*model.Claim, error) {
+	var c model.Claim
+	err := h.state.Get(ctx, editor.ClaimKey(id), &c)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && c.User != acct.Email) {
+		return nil, errClaimNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var polledAt time.Time
+	if err := h.state.Get(ctx, editor.ClaimPollKey(id), &polledAt); err == nil && polledAt.After(c.PolledAt) {
+		c.PolledAt = polledAt
+	}
+
+	return &c, nil
+}
+
+// HandleCancelClaim cancels a pending claim. Its editor is released once
+// it's claimed, ready editors are deleted like any other.
+func (h *handlers) HandleCancelClaim(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	c, err := h.claim(r.Context(), acct, mux.Vars(r)["id"])
+	if err == errClaimNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if c.State == model.ClaimStateReady {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: fmt.Sprintf("claim is already ready, delete editor %s instead", c.Editor)})
+		return
+	}
+	if c.State != model.ClaimStatePending {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: errClaimDone.Error()})
+		return
+	}
+
+	now := time.Now()
+	c.State = model.ClaimStateCanceled
+	c.CompletedAt = &now
+	if err := h.state.Put(r.Context(), editor.ClaimKey(c.ID), c); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"claim": c.ID, "user": acct.Email}).Info("Canceled claim")
+
+	jsonResp(w, http.StatusOK, c)
+}
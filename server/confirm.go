@@ -0,0 +1,37 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var confirmTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Codeface</title></head>
+<body>
+<form method="POST" action="{{.Action}}">
+<p>{{.Message}}</p>
+<button type="submit" autofocus>Open editor</button>
+</form>
+</body>
+</html>
+`))
+
+type confirmPage struct {
+	Action  string
+	Message string
+}
+
+// confirm shows a page that posts a GET request back to its URL. Links
+// like /open are followed by browsers from any site, so the editors they
+// claim are only claimed by the POST, which is checked by the CSRF
+// middleware.
+func confirm(w http.ResponseWriter, r *http.Request, message string) {
+	action := r.URL.Path
+	if r.URL.RawQuery != "" {
+		action += "?" + r.URL.RawQuery
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	confirmTemplate.Execute(w, confirmPage{Action: action, Message: message})
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboard is the single page dashboard served at /dashboard/. It talks
+// to the same API as the CLI with the session cookie of the web UI.
+//
+//go:embed dashboard
+var dashboard embed.FS
+
+func dashboardHandler() http.Handler {
+	sub, err := fs.Sub(dashboard, "dashboard")
+	if err != nil {
+		// the embedded directory always exists
+		panic(err)
+	}
+
+	return http.FileServer(http.FS(sub))
+}
@@ -0,0 +1,247 @@
+'use strict';
+
+const usageDays = 30;
+
+async function api(method, path, body) {
+    const resp = await fetch(path, {
+        method: method,
+        headers: {'Accept': 'application/json', 'Content-Type': 'application/json'},
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (resp.status === 204) {
+        return null;
+    }
+
+    const data = await resp.json();
+    if (!resp.ok) {
+        const err = new Error(data.Error || resp.statusText);
+        err.status = resp.status;
+        throw err;
+    }
+    return data;
+}
+
+function showError(err) {
+    const el = document.getElementById('error');
+    el.textContent = err.message;
+    el.classList.remove('d-none');
+}
+
+function showInfo(text) {
+    const el = document.getElementById('info');
+    el.textContent = text;
+    el.classList.remove('d-none');
+}
+
+function el(tag, text, attrs) {
+    const e = document.createElement(tag);
+    if (text !== undefined) {
+        e.textContent = text;
+    }
+    for (const k in attrs || {}) {
+        e.setAttribute(k, attrs[k]);
+    }
+    return e;
+}
+
+function row(cells) {
+    const tr = el('tr');
+    for (const c of cells) {
+        const td = el('td');
+        if (c instanceof Node) {
+            td.appendChild(c);
+        } else {
+            td.textContent = c;
+        }
+        tr.appendChild(td);
+    }
+    return tr;
+}
+
+function day(d) {
+    return d.toISOString().slice(0, 10);
+}
+
+async function loadPool() {
+    let pool;
+    try {
+        pool = await api('GET', '/v1/pool');
+    } catch (err) {
+        // only admins can see the pool
+        if (err.status === 403) {
+            return;
+        }
+        throw err;
+    }
+
+    document.getElementById('pool-section').classList.remove('d-none');
+    document.getElementById('provider').textContent = 'Provider: ' + pool.Provider;
+
+    const ready = pool.Editors.filter(e => !e.Outdated).length;
+    const summary = document.getElementById('pool-summary');
+    summary.replaceChildren();
+    for (const [label, n] of [['Ready', ready], ['Outdated', pool.Editors.length - ready]]) {
+        const col = el('div', undefined, {'class': 'col'});
+        col.appendChild(el('div', String(n), {'class': 'summary'}));
+        col.appendChild(el('div', label));
+        summary.appendChild(col);
+    }
+
+    const tbody = document.getElementById('pool');
+    tbody.replaceChildren(...pool.Editors.map(e => row([
+        e.Name, e.Provider, e.Template || '-', e.Outdated ? 'outdated' : 'ready',
+    ])));
+}
+
+async function loadApprovals() {
+    const resp = await api('GET', '/v1/approvals?state=pending');
+    document.getElementById('approvals-section').classList.toggle('d-none', resp.Approvals.length === 0);
+
+    const tbody = document.getElementById('approvals');
+    tbody.replaceChildren(...resp.Approvals.map(a => {
+        // only admins can decide, the API refuses anyone else
+        const actions = el('div');
+        for (const [label, action, style] of [['Approve', 'approve', 'btn-outline-success'], ['Deny', 'deny', 'btn-outline-danger']]) {
+            const btn = el('button', label, {'class': 'btn btn-sm mr-1 ' + style});
+            btn.addEventListener('click', async () => {
+                try {
+                    await api('POST', '/v1/approvals/' + encodeURIComponent(a.ID) + '/' + action);
+                    await refresh();
+                } catch (err) {
+                    showError(err);
+                }
+            });
+            actions.appendChild(btn);
+        }
+
+        return row([a.ID, a.User, a.Template || '-', a.DynoSize || '-', a.GitRepo || '-', a.Reason, new Date(a.CreatedAt).toLocaleString(), actions]);
+    }));
+}
+
+async function loadSessions() {
+    const resp = await api('GET', '/v1/sessions');
+    const tbody = document.getElementById('sessions');
+
+    tbody.replaceChildren(...resp.Sessions.map(s => {
+        const btn = el('button', 'Terminate', {'class': 'btn btn-sm btn-outline-danger'});
+        btn.addEventListener('click', async () => {
+            if (!confirm('Terminate ' + s.App + '?')) {
+                return;
+            }
+            try {
+                await api('DELETE', '/v1/editors/' + encodeURIComponent(s.App));
+                await refresh();
+            } catch (err) {
+                showError(err);
+            }
+        });
+
+        const res = (resp.Resources || {})[s.App];
+        return row([s.App, s.User, s.Template, s.GitRepo, new Date(s.StartedAt).toLocaleString(), memory(res), cpu(res), btn]);
+    }));
+}
+
+function memory(res) {
+    if (!res || !res.MemoryLimitBytes) {
+        return '-';
+    }
+    return Math.round(res.MemoryBytes * 100 / res.MemoryLimitBytes) + '% of ' + Math.round(res.MemoryLimitBytes / 1048576) + 'MB';
+}
+
+function cpu(res) {
+    if (!res) {
+        return '-';
+    }
+    return res.CPUPercent.toFixed(1) + '%';
+}
+
+async function loadUsage() {
+    const to = new Date();
+    const from = new Date(to.getTime() - (usageDays - 1) * 24 * 3600 * 1000);
+    const resp = await api('GET', '/v1/usage?from=' + day(from) + '&to=' + day(to));
+
+    const hours = {};
+    for (const u of resp.Usage) {
+        hours[u.Day] = (hours[u.Day] || 0) + u.EditorHours;
+    }
+
+    const days = [];
+    for (let i = 0; i < usageDays; i++) {
+        days.push(day(new Date(from.getTime() + i * 24 * 3600 * 1000)));
+    }
+
+    const max = Math.max(1, ...days.map(d => hours[d] || 0));
+    const svg = document.getElementById('usage');
+    const ns = 'http://www.w3.org/2000/svg';
+    const width = 600 / usageDays;
+    svg.replaceChildren();
+
+    days.forEach((d, i) => {
+        const h = (hours[d] || 0) / max * 180;
+        const rect = document.createElementNS(ns, 'rect');
+        rect.setAttribute('x', i * width + 1);
+        rect.setAttribute('y', 190 - h);
+        rect.setAttribute('width', width - 2);
+        rect.setAttribute('height', h);
+
+        const title = document.createElementNS(ns, 'title');
+        title.textContent = d + ': ' + (hours[d] || 0).toFixed(1) + 'h';
+        rect.appendChild(title);
+        svg.appendChild(rect);
+    });
+
+    const label = document.createElementNS(ns, 'text');
+    label.setAttribute('x', 2);
+    label.setAttribute('y', 10);
+    label.textContent = max.toFixed(1) + 'h';
+    svg.appendChild(label);
+}
+
+async function refresh() {
+    try {
+        await Promise.all([loadPool(), loadApprovals(), loadSessions(), loadUsage()]);
+    } catch (err) {
+        showError(err);
+    }
+}
+
+document.getElementById('claim').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    try {
+        const resp = await api('POST', '/editor', {GitRepo: document.getElementById('repo').value});
+        if (resp.Approval) {
+            showInfo('The claim is waiting for an admin to approve it, ' + resp.Approval.Reason + '.');
+        } else {
+            window.open(resp.URL, '_blank');
+        }
+        await refresh();
+    } catch (err) {
+        showError(err);
+    }
+});
+
+// listen shows the notifications of the user's editors as they're pushed,
+// and refreshes the sessions with them. Dropped channels are reconnected
+// with the ID of the last notification so that none are missed.
+function listen(after) {
+    const proto = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    let url = proto + '//' + window.location.host + '/v1/notifications';
+    if (after) {
+        url += '?after=' + encodeURIComponent(after);
+    }
+
+    const ws = new WebSocket(url);
+    ws.addEventListener('message', async (e) => {
+        const n = JSON.parse(e.data);
+        after = n.ID;
+        showInfo(n.Message);
+        await refresh();
+    });
+    ws.addEventListener('close', () => {
+        setTimeout(() => listen(after), 5000);
+    });
+}
+
+refresh();
+listen();
+setInterval(refresh, 30000);
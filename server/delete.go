@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// softDelete stops a claimed editor and ends its session, and records its
+// deletion for the worker to purge it after the grace period. Suspended
+// editors are already stopped.
+func (h *handlers) softDelete(ctx context.Context, s model.Session, user string) error {
+	logger := h.logger.WithFields(log.Fields{"app": s.App, "user": user})
+
+	var del model.Deletion
+	if err := h.state.Get(ctx, editor.DeletionKey(s.App), &del); err == nil {
+		return nil
+	}
+
+	susp, err := h.suspension(ctx, s.App)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	suspended := err == nil && susp.State == model.SuspensionStateSuspended
+
+	if !suspended {
+		logger.Info("Stopping deleted editor")
+		sp := provider.Lookup(h.provider, provider.OfSession(s)).(provider.Suspender)
+		if err := sp.Suspend(ctx, s.App); err != nil {
+			return err
+		}
+
+		if _, err := usage.EndSession(ctx, h.state, s.App, time.Now()); err != nil {
+			logger.WithError(err).Info("Fail to end session")
+		}
+
+		// a snapshot being taken is called off
+		if err := h.state.Delete(ctx, editor.SuspensionKey(s.App)); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).Info("Fail to delete suspension")
+		}
+	}
+
+	now := time.Now()
+	del = model.Deletion{
+		Editor:    s.App,
+		User:      user,
+		DeletedAt: now,
+		PurgeAt:   now.Add(h.deleteGrace),
+		Suspended: suspended,
+	}
+	if err := h.state.Put(ctx, editor.DeletionKey(s.App), del); err != nil {
+		return err
+	}
+
+	logger.WithField("purge-at", del.PurgeAt).Info("Deleted editor")
+
+	return nil
+}
+
+// HandleUndelete restores an editor deleted within the delete grace
+// period. It's started again, unless it was suspended when it was deleted.
+func (h *handlers) HandleUndelete(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	s, ok := h.ownedSession(w, r, name, acct)
+	if !ok {
+		return
+	}
+
+	var del model.Deletion
+	err := h.state.Get(r.Context(), editor.DeletionKey(name), &del)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor isn't deleted"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if time.Now().After(del.PurgeAt) {
+		jsonResp(w, http.StatusGone, model.ErrorResponse{Error: "editor is purged"})
+		return
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": name, "user": acct.Email})
+	logger.Info("Undeleting editor")
+
+	var resp model.EditorResponse
+	if !del.Suspended {
+		sp, ok := provider.Lookup(h.provider, provider.OfSession(*s)).(provider.Suspender)
+		if !ok {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("editors on %s can't be undeleted", provider.OfSession(*s))})
+			return
+		}
+
+		ed, err := sp.Resume(r.Context(), name, nil)
+		if err != nil {
+			logger.WithError(err).Info("Fail to undelete editor")
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		resp.URL = ed.URL
+
+		if _, err := usage.RestoreSession(r.Context(), h.state, name, time.Now()); err != nil {
+			logger.WithError(err).Info("Fail to restore session")
+		}
+	}
+
+	if err := h.state.Delete(r.Context(), editor.DeletionKey(name)); err != nil {
+		logger.WithError(err).Info("Fail to delete deletion")
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// HandleDeploys lists the recorded deploys of the pool, newest first,
+// optionally of a template.
+func (h *handlers) HandleDeploys(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at deploys"})
+		return
+	}
+
+	keys, err := h.state.List(r.Context(), editor.DeployKey(""))
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	template := r.URL.Query().Get("template")
+	resp := model.DeploysResponse{Deploys: []model.Deploy{}}
+	for _, key := range keys {
+		var dep model.Deploy
+		err := h.state.Get(r.Context(), key, &dep)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		if template != "" && dep.Template != template {
+			continue
+		}
+
+		dep.Output = ""
+		resp.Deploys = append(resp.Deploys, dep)
+	}
+
+	sort.Slice(resp.Deploys, func(i, j int) bool {
+		return resp.Deploys[i].StartedAt.After(resp.Deploys[j].StartedAt)
+	})
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleDeploy returns a deploy with its build and release output.
+func (h *handlers) HandleDeploy(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at deploys"})
+		return
+	}
+
+	var dep model.Deploy
+	err := h.state.Get(r.Context(), editor.DeployKey(mux.Vars(r)["id"]), &dep)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "deploy is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, dep)
+}
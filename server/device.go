@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	deviceCodeExpiry = 10 * time.Minute
+	devicePollSecs   = 5
+	// userCodeChars leave out vowels and look-alikes, so that user codes
+	// spell no words and are easy to type
+	userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// device is a pending device login, which holds the token of the user
+// once they approve it.
+type device struct {
+	UserCode  string
+	ExpiresAt time.Time
+	User      string        `json:",omitempty"`
+	Token     *oauth2.Token `json:",omitempty"`
+}
+
+// deviceHash is what a device login is stored by, its device code isn't
+// stored at all.
+func deviceHash(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return hex.EncodeToString(sum[:])
+}
+
+const devicesPrefix = "devices/"
+
+func deviceKey(hash string) string {
+	return devicesPrefix + hash
+}
+
+// userCodeKey maps a user code to the hash of the device code.
+func userCodeKey(userCode string) string {
+	return "devicecodes/" + userCode
+}
+
+// HandleDeviceCode starts the login of a device.
+func (h *handlers) HandleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	deviceCode := hex.EncodeToString(b)
+
+	userCode, err := newUserCode()
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// logins are only started here, so they're purged here too
+	now := time.Now()
+	if err := h.purgeDevices(r.Context(), now); err != nil {
+		h.logger.WithError(err).Info("Fail to purge device logins")
+	}
+
+	hash := deviceHash(deviceCode)
+	expiresAt := now.Add(deviceCodeExpiry)
+	if err := h.state.Put(r.Context(), deviceKey(hash), device{UserCode: userCode, ExpiresAt: expiresAt}); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.state.Put(r.Context(), userCodeKey(userCode), hash); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	base := h.serverURL
+	if base == "" {
+		base = "https://" + r.Host
+	}
+
+	jsonResp(w, http.StatusOK, model.DeviceCode{
+		DeviceCode:      deviceCode,
+		UserCode:        userCode,
+		VerificationURL: base + "/device",
+		ExpiresAt:       expiresAt,
+		Interval:        devicePollSecs,
+	})
+}
+
+// purgeDevices deletes the device logins that expired, along with the
+// tokens of the approved ones that were never picked up.
+func (h *handlers) purgeDevices(ctx context.Context, now time.Time) error {
+	keys, err := h.state.List(ctx, devicesPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var d device
+		err := h.state.Get(ctx, key, &d)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if now.Before(d.ExpiresAt) {
+			continue
+		}
+
+		h.deleteDevice(ctx, key, d)
+	}
+
+	return nil
+}
+
+func (h *handlers) deleteDevice(ctx context.Context, key string, d device) {
+	for _, k := range []string{key, userCodeKey(d.UserCode)} {
+		if err := h.state.Delete(ctx, k); err != nil && err != store.ErrNotFound {
+			h.logger.WithError(err).Info("Fail to delete device login")
+		}
+	}
+}
+
+func newUserCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeChars))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeChars[n.Int64()]
+	}
+
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// HandleDeviceToken is polled by a device until the user approves its
+// login, and hands out the token of the user once.
+func (h *handlers) HandleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	var req model.DeviceTokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	key := deviceKey(deviceHash(req.DeviceCode))
+
+	var d device
+	err := h.state.Get(r.Context(), key, &d)
+	if err == nil && time.Now().After(d.ExpiresAt) {
+		h.deleteDevice(r.Context(), key, d)
+		err = store.ErrNotFound
+	}
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "device code is expired, log in again"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if d.Token == nil {
+		jsonResp(w, http.StatusOK, model.Token{Pending: true})
+		return
+	}
+
+	h.deleteDevice(r.Context(), key, d)
+
+	h.logger.WithField("user", d.User).Info("Logged in device")
+
+	jsonResp(w, http.StatusOK, model.Token{
+		AccessToken:  d.Token.AccessToken,
+		RefreshToken: d.Token.RefreshToken,
+		Expiry:       d.Token.Expiry,
+		User:         d.User,
+	})
+}
+
+// HandleRefreshToken exchanges the refresh token of a device for a new
+// token, with the OAuth client secret that only the server has.
+func (h *handlers) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req model.RefreshRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	tok, err := h.oauthConf.TokenSource(r.Context(), &oauth2.Token{RefreshToken: req.RefreshToken}).Token()
+	if err != nil {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "refresh token is invalid, log in again"})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.Token{
+		AccessToken:  tok.AccessToken,
+		RefreshToken: tok.RefreshToken,
+		Expiry:       tok.Expiry,
+	})
+}
+
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Codeface device login</title></head>
+<body>
+{{if .Done}}
+<p>{{.Done}}</p>
+{{else}}
+<form method="POST" action="/device">
+<p>Enter the code shown by the device to log it in as {{.User}}.</p>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input name="code" value="{{.Code}}" autocomplete="off" autofocus>
+<button type="submit">Approve</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePage struct {
+	User  string
+	Code  string
+	Error string
+	Done  string
+}
+
+// HandleDevice is where users approve the login of a device in their
+// browser, which hands the device the Heroku OAuth token of their session.
+func (h *handlers) HandleDevice(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	page := devicePage{User: acct.Email, Code: r.URL.Query().Get("code")}
+
+	if r.Method == http.MethodPost {
+		page.Code = strings.ToUpper(strings.TrimSpace(r.FormValue("code")))
+		if err := h.approveDevice(r, acct, page.Code); err != nil {
+			page.Error = err.Error()
+		} else {
+			page.Done = "The device is logged in, you can close this window."
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	deviceTemplate.Execute(w, page)
+}
+
+func (h *handlers) approveDevice(r *http.Request, acct *hkclient.Account, userCode string) error {
+	invalid := errString("The code is invalid or expired.")
+
+	// only browser sessions have a refresh token to hand out
+	session, err := h.store.Get(r, "session")
+	if err != nil {
+		return err
+	}
+	tok, ok := session.Values["token"].(*oauth2.Token)
+	if !ok {
+		return errString("Log in with your browser to approve devices.")
+	}
+
+	var hash string
+	if err := h.state.Get(r.Context(), userCodeKey(userCode), &hash); err != nil {
+		return invalid
+	}
+
+	var d device
+	if err := h.state.Get(r.Context(), deviceKey(hash), &d); err != nil || time.Now().After(d.ExpiresAt) || d.Token != nil {
+		return invalid
+	}
+
+	d.User = acct.Email
+	d.Token = tok
+	if err := h.state.Put(r.Context(), deviceKey(hash), d); err != nil {
+		return err
+	}
+
+	h.logger.WithFields(log.Fields{"user": acct.Email, "code": userCode}).Info("Approved device login")
+
+	return nil
+}
+
+type errString string
+
+func (e errString) Error() string {
+	return string(e)
+}
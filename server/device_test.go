@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+func TestDeviceCode(t *testing.T) {
+	ctx := context.Background()
+	h := newCheckedHandlers(t, "")
+
+	start := func() model.DeviceCode {
+		w := httptest.NewRecorder()
+		h.HandleDeviceCode(w, httptest.NewRequest(http.MethodPost, "/v1/device/code", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("HandleDeviceCode = %d %s", w.Code, w.Body)
+		}
+
+		var dc model.DeviceCode
+		if err := json.NewDecoder(w.Body).Decode(&dc); err != nil {
+			t.Fatal(err)
+		}
+		return dc
+	}
+
+	expired := start()
+
+	var hash string
+	if err := h.state.Get(ctx, userCodeKey(expired.UserCode), &hash); err != nil {
+		t.Fatal(err)
+	}
+	if hash == expired.DeviceCode || hash != deviceHash(expired.DeviceCode) {
+		t.Errorf("user code maps to %q, want the hash of the device code", hash)
+	}
+
+	var d device
+	if err := h.state.Get(ctx, deviceKey(hash), &d); err != nil {
+		t.Fatal(err)
+	}
+	d.ExpiresAt = time.Now().Add(-time.Second)
+	if err := h.state.Put(ctx, deviceKey(hash), d); err != nil {
+		t.Fatal(err)
+	}
+
+	current := start()
+
+	for _, key := range []string{deviceKey(hash), userCodeKey(expired.UserCode)} {
+		if err := h.state.Get(ctx, key, new(json.RawMessage)); err != store.ErrNotFound {
+			t.Errorf("Get(%s) = %v, want the expired login purged", key, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleDeviceToken(w, httptest.NewRequest(http.MethodPost, "/v1/device/token", strings.NewReader(`{"DeviceCode": "`+current.DeviceCode+`"}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Pending":true`) {
+		t.Errorf("HandleDeviceToken = %d %s, want the login pending", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+)
+
+// activityInterval is how often the activity of an editor is written to
+// the store. Requests are counted in memory in between, editors busy with
+// requests would otherwise write on every drained batch.
+const activityInterval = time.Minute
+
+// routerDrain sums up the requests to each editor from the router logs
+// that Logplex drains to the server.
+type routerDrain struct {
+	token string
+	state store.Store
+
+	mu      sync.Mutex
+	pending map[string]*model.Activity
+	written map[string]time.Time
+}
+
+func newRouterDrain(token string, st store.Store) *routerDrain {
+	return &routerDrain{
+		token:   token,
+		state:   st,
+		pending: make(map[string]*model.Activity),
+		written: make(map[string]time.Time),
+	}
+}
+
+// HandleRouterDrain accepts batches of logplex messages. The drain URL
+// carries the token as the password of basic auth.
+func (h *handlers) HandleRouterDrain(w http.ResponseWriter, r *http.Request) {
+	d := h.routerDrain
+	if d == nil || d.token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, password, _ := r.BasicAuth()
+	if subtle.ConstantTimeCompare([]byte(password), []byte(d.token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	batch, err := parseRouterLogs(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	for app, a := range batch {
+		if err := d.record(r.Context(), a, now); err != nil {
+			h.logger.WithError(err).WithField("app", app).Info("Fail to record editor activity")
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// record adds a batch of requests to an editor to its activity, which is
+// written once per activityInterval.
+func (d *routerDrain) record(ctx context.Context, batch *model.Activity, now time.Time) error {
+	app := batch.Editor
+
+	d.mu.Lock()
+	p, ok := d.pending[app]
+	if !ok {
+		p = &model.Activity{Editor: app}
+		d.pending[app] = p
+	}
+	addActivity(p, batch)
+
+	if now.Sub(d.written[app]) < activityInterval {
+		d.mu.Unlock()
+		return nil
+	}
+	delete(d.pending, app)
+	d.written[app] = now
+	d.mu.Unlock()
+
+	var a model.Activity
+	if err := d.state.Get(ctx, editor.ActivityKey(app), &a); err == store.ErrNotFound {
+		a = model.Activity{Editor: app, Since: now}
+	} else if err != nil {
+		return err
+	}
+	addActivity(&a, p)
+
+	return d.state.Put(ctx, editor.ActivityKey(app), a)
+}
+
+func addActivity(a, b *model.Activity) {
+	a.Requests += b.Requests
+	a.Errors += b.Errors
+	if b.LastRequestAt.After(a.LastRequestAt) {
+		a.LastRequestAt = b.LastRequestAt
+	}
+	if b.LastError != "" {
+		a.LastError = b.LastError
+	}
+}
+
+// parseRouterLogs sums up the requests to each app in a batch of logplex
+// messages, which are framed by their length, e.g.
+//
+//	83 <158>1 2020-01-02T15:04:05.000000+00:00 host heroku router - at=info method=GET path="/" host=cf-abc.herokuapp.com status=200 ...
+func parseRouterLogs(r io.Reader) (map[string]*model.Activity, error) {
+	batch := make(map[string]*model.Activity)
+
+	br := bufio.NewReader(r)
+	for {
+		prefix, err := br.ReadString(' ')
+		if err == io.EOF && strings.TrimSpace(prefix) == "" {
+			return batch, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid logplex frame: %w", err)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(prefix))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid logplex frame length %q", prefix)
+		}
+
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return nil, fmt.Errorf("invalid logplex frame: %w", err)
+		}
+
+		req, ok := parseRouterLine(string(msg))
+		if !ok {
+			continue
+		}
+
+		a, ok := batch[req.Editor]
+		if !ok {
+			a = &model.Activity{Editor: req.Editor}
+			batch[req.Editor] = a
+		}
+		addActivity(a, req)
+	}
+}
+
+// parseRouterLine parses a router log line into the activity of a single
+// request. Router errors such as H14 (no web dynos) aren't requests served
+// by the editor.
+func parseRouterLine(line string) (*model.Activity, bool) {
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID MSG
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 7)
+	if len(fields) < 7 || fields[4] != "router" {
+		return nil, false
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, fields[1])
+	if err != nil {
+		return nil, false
+	}
+
+	kvs := make(map[string]string)
+	for _, kv := range strings.Fields(fields[6]) {
+		if i := strings.Index(kv, "="); i > 0 {
+			kvs[kv[:i]] = strings.Trim(kv[i+1:], `"`)
+		}
+	}
+
+	app := strings.SplitN(kvs["host"], ".", 2)[0]
+	if app == "" {
+		return nil, false
+	}
+
+	a := &model.Activity{Editor: app, Requests: 1}
+	switch {
+	case kvs["at"] == "error":
+		a.Errors = 1
+		a.LastError = kvs["code"]
+	case strings.HasPrefix(kvs["status"], "5"):
+		a.Errors = 1
+		a.LastError = kvs["status"]
+		a.LastRequestAt = at
+	default:
+		a.LastRequestAt = at
+	}
+
+	return a, true
+}
+
+// HandleEditorActivity returns the requests and errors of a claimed editor
+// of the user, or of anyone for admins, that are drained from its router
+// logs.
+func (h *handlers) HandleEditorActivity(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	var s model.Session
+	err := h.state.Get(r.Context(), usage.SessionKey(name), &s)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && s.User != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var a model.Activity
+	err = h.state.Get(r.Context(), editor.ActivityKey(name), &a)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "no activity of the editor is recorded"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, a)
+}
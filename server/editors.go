@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/usage"
+)
+
+const (
+	defaultEditorsLimit = 100
+	maxEditorsLimit     = 1000
+)
+
+// editorSorts are the keys listed editors can be sorted by, ties are
+// broken by name. Keys are compared as strings, so times are formatted
+// with a fixed width.
+var editorSorts = map[string]func(model.EditorSummary) string{
+	"name": func(ed model.EditorSummary) string {
+		return ""
+	},
+	"template": func(ed model.EditorSummary) string {
+		return ed.Template
+	},
+	"claimed": func(ed model.EditorSummary) string {
+		if ed.ClaimedAt == nil {
+			return ""
+		}
+		return ed.ClaimedAt.UTC().Format("2006-01-02T15:04:05.000000000Z")
+	},
+}
+
+// editorCursor is the position after the last editor of a page.
+type editorCursor struct {
+	Sort string
+	Key  string
+	Name string
+}
+
+func (c editorCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeEditorCursor(s string) (*editorCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var c editorCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// HandleEditors lists the claimed editors of the user, or the pool and all
+// claimed editors for admins, a page at a time. Editors are filtered by
+// template, state and owner, and sorted by name, template or claimed, or
+// in reverse with a leading -.
+func (h *handlers) HandleEditors(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	query := r.URL.Query()
+
+	template := query.Get("template")
+	state := query.Get("state")
+	owner := query.Get("owner")
+	// only admins may look at the pool and editors of other users
+	if !h.isAdmin(acct) {
+		owner = acct.Email
+	}
+
+	labels, err := model.ParseLabelSelector(query["label"])
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if state != "" && state != model.EditorStateIdle && state != model.EditorStateClaimed {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("state must be %s or %s", model.EditorStateIdle, model.EditorStateClaimed)})
+		return
+	}
+
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	desc := strings.HasPrefix(sortBy, "-")
+	key, ok := editorSorts[strings.TrimPrefix(sortBy, "-")]
+	if !ok {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("unknown sort %q", sortBy)})
+		return
+	}
+
+	limit := defaultEditorsLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEditorsLimit {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("limit must be between 1 and %d", maxEditorsLimit)})
+			return
+		}
+		limit = n
+	}
+
+	var cursor *editorCursor
+	if v := query.Get("cursor"); v != "" {
+		var err error
+		cursor, err = decodeEditorCursor(v)
+		if err == nil && cursor.Sort != sortBy {
+			err = fmt.Errorf("cursor is of another sort")
+		}
+		if err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	var eds []model.EditorSummary
+
+	// idle editors have no owner and no labels
+	if owner == "" && len(labels) == 0 && state != model.EditorStateClaimed {
+		currentVersion, otherVersion, err := h.provider.Pool(r.Context())
+		if err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		add := func(pool []provider.Editor, outdated bool) {
+			for _, ed := range pool {
+				eds = append(eds, model.EditorSummary{
+					Name:     ed.Name,
+					Provider: ed.Provider,
+					Template: ed.Template,
+					State:    model.EditorStateIdle,
+					Outdated: outdated,
+				})
+			}
+		}
+		add(currentVersion, false)
+		add(otherVersion, true)
+	}
+
+	if state != model.EditorStateIdle {
+		sessions, err := usage.OpenSessions(r.Context(), h.state)
+		if err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		for _, s := range sessions {
+			if owner != "" && s.User != owner {
+				continue
+			}
+			if !model.MatchLabels(s.Labels, labels) {
+				continue
+			}
+
+			claimedAt := s.ClaimedAt
+			if claimedAt.IsZero() {
+				claimedAt = s.StartedAt
+			}
+			ed := model.EditorSummary{
+				Name:      s.App,
+				Provider:  provider.OfSession(s),
+				Template:  s.Template,
+				State:     model.EditorStateClaimed,
+				Owner:     s.User,
+				ClaimedAt: &claimedAt,
+				Labels:    s.Labels,
+			}
+			if last, err := editor.LastRequest(r.Context(), h.state, s.App); err == nil && !last.IsZero() {
+				ed.LastRequestAt = &last
+			}
+			eds = append(eds, ed)
+		}
+	}
+
+	resp := model.EditorsResponse{Editors: []model.EditorSummary{}}
+	for _, ed := range eds {
+		if template == "" || ed.Template == template {
+			resp.Editors = append(resp.Editors, ed)
+		}
+	}
+
+	// editors are ordered by their sort key, then by name
+	less := func(ka, na, kb, nb string) bool {
+		if ka != kb {
+			return (ka < kb) != desc
+		}
+		return (na < nb) != desc
+	}
+	sort.Slice(resp.Editors, func(i, j int) bool {
+		a, b := resp.Editors[i], resp.Editors[j]
+		return less(key(a), a.Name, key(b), b.Name)
+	})
+
+	if cursor != nil {
+		i := sort.Search(len(resp.Editors), func(i int) bool {
+			ed := resp.Editors[i]
+			return less(cursor.Key, cursor.Name, key(ed), ed.Name)
+		})
+		resp.Editors = resp.Editors[i:]
+	}
+
+	if len(resp.Editors) > limit {
+		resp.Editors = resp.Editors[:limit]
+		last := resp.Editors[limit-1]
+		resp.NextCursor = editorCursor{Sort: sortBy, Key: key(last), Name: last.Name}.encode()
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/acme"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/middleware"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const certRenewInterval = 12 * time.Hour
+
+// gateway terminates TLS for editors served at #{NAME}.#{EDITOR_DOMAIN}
+// and proxies them to their backends. Certificates are obtained from an
+// ACME CA, Let's Encrypt by default, the first time an editor host is
+// requested.
+type gateway struct {
+	domain  string
+	router  provider.Router
+	state   store.Store
+	manager *acme.Manager
+	// allowed are the client IPs allowed to reach editors
+	allowed []*net.IPNet
+	logger  log.FieldLogger
+}
+
+func newGateway(cfg Config, router provider.Router, st store.Store, logger log.FieldLogger) (*gateway, error) {
+	allowed, err := editorproxy.ParseCIDRs(cfg.EditorIPAllowList)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gateway{
+		domain:  strings.ToLower(cfg.EditorDomain),
+		router:  router,
+		state:   st,
+		allowed: allowed,
+		logger:  logger.WithField("com", "gateway"),
+	}
+	g.manager = acme.NewManager(cfg.ACMEDirectoryURL, cfg.ACMEEmail, st, g.hostPolicy)
+
+	return g, nil
+}
+
+func (g *gateway) editorName(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	name := strings.TrimSuffix(host, "."+g.domain)
+	if name == host || name == "" || strings.Contains(name, ".") {
+		return "", false
+	}
+
+	return name, true
+}
+
+// hostPolicy only allows certificates for editors that exist, so that
+// random subdomains don't use up the rate limits of the CA. Editors whose
+// session is over for good are gone.
+func (g *gateway) hostPolicy(ctx context.Context, host string) error {
+	name, ok := g.editorName(host)
+	if !ok {
+		return fmt.Errorf("%w: %s is not an editor host", acme.ErrHostGone, host)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := g.router.Backend(ctx, name)
+	if err == nil {
+		return nil
+	}
+
+	var s model.Session
+	if serr := g.state.Get(ctx, usage.SessionKey(name), &s); serr == store.ErrNotFound || (serr == nil && s.EndedAt != nil && !s.Suspended) {
+		return fmt.Errorf("%w: editor %s is deleted", acme.ErrHostGone, name)
+	}
+
+	return err
+}
+
+// forget forgets the certificate of a deleted editor.
+func (g *gateway) forget(ctx context.Context, name string) {
+	host := name + "." + g.domain
+	if err := g.manager.Forget(ctx, host); err != nil {
+		g.logger.WithError(err).WithField("host", host).Info("Fail to forget certificate")
+	}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := g.editorName(r.Host)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	backend, err := g.router.Backend(r.Context(), name)
+	if err != nil {
+		g.logger.WithError(err).WithField("app", name).Info("Fail to get editor backend")
+		http.Error(w, "editor is not found", http.StatusBadGateway)
+		return
+	}
+
+	// websockets are proxied too, code-server relies on them
+	httputil.NewSingleHostReverseProxy(backend).ServeHTTP(w, r)
+}
+
+func (g *gateway) renewCerts(ctx context.Context) {
+	t := time.NewTicker(certRenewInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := g.manager.Renew(ctx); err != nil {
+				g.logger.WithError(err).Info("Fail to renew certificates")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *gateway) servers(cfg Config) (tlsServer *http.Server, httpServer *http.Server) {
+	// the gateway is the edge, so clients connect to it directly
+	h := editorproxy.AllowList(g, g.allowed, 0, g.logger)
+	tlsServer = middleware.Server(":"+cfg.TLSPort, middleware.Harden(h, middleware.Options{
+		FrameOptions:    "SAMEORIGIN",
+		MaxRequestBytes: middleware.EditorMaxRequestBytes,
+	}, g.logger))
+	tlsServer.TLSConfig = g.manager.TLSConfig()
+
+	// plain HTTP is only for challenges and redirects to https
+	httpServer = middleware.Server(":"+cfg.ACMEHTTPPort, g.manager.HTTPHandler())
+
+	return tlsServer, httpServer
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/github"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+var errNoGitHubAccount = fmt.Errorf("link your GitHub account with cf credentials github first")
+
+// githubAccountKey is the key of the GitHub account a user linked.
+func githubAccountKey(email string) string {
+	return "githubaccounts/" + email
+}
+
+// checkPush returns an error unless the user linked a GitHub account that
+// can push to the repo, so that the tokens and caches of a repo are only
+// handed out to its collaborators. Anything that can't be verified is
+// refused.
+func (h *handlers) checkPush(ctx context.Context, user, repo string) error {
+	if h.githubApp == nil {
+		return fmt.Errorf("push access to %s can't be verified without the GitHub App", repo)
+	}
+
+	owner, name, err := github.ParseRepoURL(repo)
+	if err != nil {
+		return fmt.Errorf("push access to %s can't be verified, it isn't a GitHub repository", repo)
+	}
+
+	var acct model.GitHubAccount
+	err = h.state.Get(ctx, githubAccountKey(user), &acct)
+	if err == store.ErrNotFound {
+		return errNoGitHubAccount
+	}
+	if err != nil {
+		return err
+	}
+
+	perm, err := h.githubApp.Permission(ctx, owner, name, acct.Login)
+	if err != nil {
+		h.logger.WithError(err).WithFields(log.Fields{"user": user, "repo": repo}).Info("Fail to get repository permission")
+		return fmt.Errorf("push access of %s to %s/%s can't be verified", acct.Login, owner, name)
+	}
+	if !github.CanPush(perm) {
+		return fmt.Errorf("%s can't push to %s/%s", acct.Login, owner, name)
+	}
+
+	return nil
+}
+
+// HandleSaveGitHubToken links the GitHub account of a token to the user.
+// Only the login is kept.
+func (h *handlers) HandleSaveGitHubToken(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var req model.GitHubTokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	login, err := github.User(r.Context(), req.Token)
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "GitHub token is invalid"})
+		return
+	}
+
+	ga := model.GitHubAccount{Login: login, LinkedAt: time.Now()}
+	if err := h.state.Put(r.Context(), githubAccountKey(acct.Email), ga); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"user": acct.Email, "github": login}).Info("Linked GitHub account")
+
+	jsonResp(w, http.StatusOK, ga)
+}
+
+func (h *handlers) HandleDeleteGitHubToken(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	if err := h.state.Delete(r.Context(), githubAccountKey(acct.Email)); err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"golang.org/x/oauth2"
+)
+
+var errNoGrant = fmt.Errorf("log in to Codeface in the browser once to let it create editors in your Heroku account")
+
+// grantKey is the key of the OAuth token a user granted the server.
+func grantKey(email string) string {
+	return "grants/" + email
+}
+
+// herokuKeyKey is the key of the Heroku API key a user stored, which is
+// used instead of their grant.
+func herokuKeyKey(email string) string {
+	return "herokukeys/" + email
+}
+
+// actsAsUsers returns whether the server acts on the Heroku accounts of
+// users with their OAuth grants, which takes more than the identity scope.
+func (h *handlers) actsAsUsers() bool {
+	for _, s := range h.oauthConf.Scopes {
+		if s != "identity" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// saveGrant keeps the OAuth token of a user who logged in, so that the
+// server can act on their behalf outside of their requests, e.g. for
+// approved claims.
+func (h *handlers) saveGrant(ctx context.Context, email string, tok *oauth2.Token) {
+	if !h.actsAsUsers() || tok.RefreshToken == "" {
+		return
+	}
+
+	if err := h.state.Put(ctx, grantKey(email), tok); err != nil {
+		h.logger.WithError(err).WithField("user", email).Info("Fail to save OAuth grant")
+	}
+}
+
+// userToken returns the Heroku API key a user stored, or else a token of
+// their grant, refreshed if it's expired.
+func (h *handlers) userToken(ctx context.Context, email string) (string, error) {
+	var key string
+	err := h.state.Get(ctx, herokuKeyKey(email), &key)
+	if err == nil {
+		return key, nil
+	}
+	if err != store.ErrNotFound {
+		return "", err
+	}
+
+	var tok oauth2.Token
+	err = h.state.Get(ctx, grantKey(email), &tok)
+	if err == store.ErrNotFound {
+		return "", errNoGrant
+	}
+	if err != nil {
+		return "", err
+	}
+
+	fresh, err := h.oauthConf.TokenSource(ctx, &tok).Token()
+	if err != nil {
+		h.logger.WithError(err).WithField("user", email).Info("Fail to refresh OAuth grant")
+		return "", errNoGrant
+	}
+
+	if fresh.AccessToken != tok.AccessToken {
+		h.saveGrant(ctx, email, fresh)
+	}
+
+	return fresh.AccessToken, nil
+}
+
+// withUser has the recipient of a claim accept the editor into their own
+// Heroku account, which is billed for it, when the server acts as users.
+// The token of the request is used if there's one, else their grant.
+func (h *handlers) withUser(ctx context.Context, opts *editor.ClaimOptions) error {
+	if !h.actsAsUsers() {
+		return nil
+	}
+
+	if token, _ := ctx.Value(tokenKey).(string); token != "" {
+		opts.RecipientToken = token
+		return nil
+	}
+
+	token, err := h.userToken(ctx, opts.Recipient)
+	if err != nil {
+		return err
+	}
+
+	opts.RecipientToken = token
+	return nil
+}
+
+// HandleSaveHerokuKey stores a Heroku API key of the user, which the
+// server acts on their account with outside of their requests.
+func (h *handlers) HandleSaveHerokuKey(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var req model.HerokuKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// the key has to be of the account of the user
+	keyAcct, err := editor.Account(r.Context(), h.heroku(req.APIKey))
+	if err != nil || keyAcct.Email != acct.Email {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "API key isn't one of your Heroku account"})
+		return
+	}
+
+	if err := h.state.Put(r.Context(), herokuKeyKey(acct.Email), req.APIKey); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithField("user", acct.Email).Info("Saved Heroku API key")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) HandleDeleteHerokuKey(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	if err := h.state.Delete(r.Context(), herokuKeyKey(acct.Email)); err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/middleware"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/usage"
+)
+
+// withPool keeps the editors of the guest pool for guests, and those of
+// templates that need approval for the claims that name them, which are
+// approved first.
+func (h *handlers) withPool(opts *editor.ClaimOptions) {
+	if h.guestTemplate != "" {
+		opts.ReservedTemplates = []string{h.guestTemplate}
+	}
+	for _, t := range h.approvalTemplates {
+		if t != opts.Template {
+			opts.ReservedTemplates = append(opts.ReservedTemplates, t)
+		}
+	}
+}
+
+// newGuestLimiter limits the guest editors claimed per client IP and hour,
+// nil for no limit.
+func newGuestLimiter(perHour int) *middleware.Limiter {
+	if perHour <= 0 {
+		return nil
+	}
+
+	return middleware.NewLimiter(float64(perHour)/3600, perHour)
+}
+
+// HandleGuest claims an editor of the guest template for a visitor who
+// isn't logged in, e.g. an attendee of a workshop, and redirects to it.
+// Guest editors stay with the pool account, get no secrets and are
+// released by the worker after GUEST_SESSION_DURATION. A browser gets one
+// guest editor at a time, and a GET asks to confirm before claiming it.
+// Visitors need the code of the event if there is one, and every client IP
+// claims a few guest editors an hour.
+func (h *handlers) HandleGuest(w http.ResponseWriter, r *http.Request) {
+	if h.guestTemplate == "" {
+		http.Error(w, "guest editors are not configured", http.StatusNotFound)
+		return
+	}
+
+	if h.guestCode != "" && subtle.ConstantTimeCompare([]byte(r.FormValue("code")), []byte(h.guestCode)) != 1 {
+		http.Error(w, "guest code is invalid", http.StatusForbidden)
+		return
+	}
+
+	session, err := h.store.Get(r, "session")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if name, ok := session.Values["guest"].(string); ok {
+		var s model.Session
+		err := h.state.Get(r.Context(), usage.SessionKey(name), &s)
+		if url, ok := session.Values["guest-url"].(string); ok && err == nil && s.EndedAt == nil && s.User == model.GuestUser {
+			http.Redirect(w, r, url, http.StatusSeeOther)
+			return
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		confirm(w, r, "Open a guest editor?")
+		return
+	}
+
+	// guests are held to the policies of the guest user
+	if c := h.checkClaim(r.Context(), &hkclient.Account{Email: model.GuestUser}, model.EditorRequest{Template: h.guestTemplate}); c != nil {
+		c.refusePage(w, "guest editors can't wait for approval, %s")
+		return
+	}
+
+	if h.guestLimiter != nil {
+		ip := editorproxy.ClientIP(r, h.trustedHops)
+		if ip == nil {
+			http.Error(w, "client IP is unknown", http.StatusBadRequest)
+			return
+		}
+
+		if ok, wait := h.guestLimiter.Allow(ip.String(), time.Now()); !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many guest editors were claimed from your network, retry later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Heroku editors are claimed for the pool account so that they're not
+	// transferred to anyone
+	recipient := model.GuestUser
+	if h.herokuAPIKey != "" {
+		acct, err := editor.Account(r.Context(), h.heroku(h.herokuAPIKey))
+		if err != nil {
+			h.logger.WithError(err).Info("Fail to get pool account")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recipient = acct.Email
+	}
+
+	claimOpts := editor.ClaimOptions{
+		Template:  h.guestTemplate,
+		Recipient: recipient,
+	}
+	h.withAgent(&claimOpts)
+	h.withViewer(&claimOpts)
+
+	claimedAt := time.Now()
+	ed, err := h.provider.Claim(r.Context(), claimOpts)
+	if err != nil {
+		h.logger.WithError(err).Info("error: fail to claim a guest app")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	h.registerAgent(r.Context(), claimOpts, ed)
+	h.registerViewer(r.Context(), claimOpts, ed)
+
+	h.startSession(r.Context(), ed, model.GuestUser, "", nil, claimedAt)
+
+	h.logger.WithField("app", ed.Name).Info("Claimed guest editor")
+
+	session.Values["guest"] = ed.Name
+	session.Values["guest-url"] = ed.URL
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, ed.URL, http.StatusSeeOther)
+}
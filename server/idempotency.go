@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// maxIdempotencyKey is the longest key a client may send, e.g. a UUID
+	// or the ID of a CI job
+	maxIdempotencyKey = 255
+	// idempotencyLockTTL is how long a request holds its key unless it's
+	// refreshed, after which the key of a request that never finished, e.g.
+	// of a restarted server, can be retried
+	idempotencyLockTTL = 2 * time.Minute
+	// idempotencyLockRefresh is how often a request in progress refreshes
+	// its key
+	idempotencyLockRefresh = idempotencyLockTTL / 4
+)
+
+// idempotentRequest is a request made with an Idempotency-Key. Its
+// response is kept once it succeeded and replayed to the retries of the
+// request.
+type idempotentRequest struct {
+	// Fingerprint is a hash of the body, retries have to send the same one
+	Fingerprint string
+	// Lock identifies the request holding the key, a request taking over
+	// an expired key claims it once by its lock
+	Lock      string          `json:",omitempty"`
+	Status    int             `json:",omitempty"`
+	Response  json.RawMessage `json:",omitempty"`
+	ExpiresAt time.Time
+}
+
+// idempotencyKey is the key of an Idempotency-Key of a user, keys are
+// unique per user and only a hash of them is stored.
+func idempotencyKey(email, key string) string {
+	sum := sha256.Sum256([]byte(email + "\n" + key))
+	return "idempotency/" + hex.EncodeToString(sum[:])
+}
+
+// keyLocks serializes the requests of the same key within the server.
+type keyLocks struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{keys: make(map[string]bool)}
+}
+
+func (l *keyLocks) lock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.keys[key] {
+		return false
+	}
+	l.keys[key] = true
+
+	return true
+}
+
+func (l *keyLocks) unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.keys, key)
+}
+
+// responseRecorder keeps a copy of the response written to w.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent serves a request with an Idempotency-Key once, retries of it
+// get the response of the first request that succeeded instead of
+// claiming another editor. Failed requests may be retried with the same
+// key. Requests without a key are served as they are.
+func (h *handlers) idempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(idempotencyHeader)
+	if key == "" {
+		next(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKey {
+		jsonResp(w, http.StatusBadRequest, model.ErrorResponse{Error: "Idempotency-Key is too long"})
+		return
+	}
+
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	k := idempotencyKey(acct.Email, key)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		jsonResp(w, http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	if !h.idempotencyLocks.lock(k) {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: "a request with the Idempotency-Key is in progress, retry later"})
+		return
+	}
+	defer h.idempotencyLocks.unlock(k)
+
+	// hold the key across replicas sharing the store
+	id, err := newIdempotencyLock()
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	lock := idempotentRequest{Fingerprint: fingerprint, Lock: id, ExpiresAt: time.Now().Add(idempotencyLockTTL)}
+
+	prev, err := h.lockIdempotencyKey(r.Context(), k, lock)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if prev != nil {
+		if prev.Fingerprint != fingerprint {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "Idempotency-Key was used for a different request"})
+			return
+		}
+
+		// another replica is serving the request
+		if prev.Status == 0 {
+			jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: "a request with the Idempotency-Key is in progress, retry later"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(prev.Status)
+		w.Write(prev.Response)
+		return
+	}
+
+	// the key is refreshed until the request is done, so that long claims
+	// don't lose it
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		h.refreshIdempotencyKey(refreshCtx, k, lock)
+	}()
+
+	rec := &responseRecorder{ResponseWriter: w}
+	next(rec, r)
+
+	stopRefresh()
+	<-refreshed
+
+	// the store is written to even if the client went away, which is
+	// when it retries
+	//
+	// requests that failed are served again when they're retried
+	if rec.status < 200 || rec.status >= 300 {
+		if err := h.state.Delete(context.Background(), k); err != nil && err != store.ErrNotFound {
+			h.logger.WithError(err).Info("Fail to release idempotency key")
+		}
+		return
+	}
+
+	done := idempotentRequest{
+		Fingerprint: fingerprint,
+		Status:      rec.status,
+		Response:    json.RawMessage(rec.body.Bytes()),
+		ExpiresAt:   time.Now().Add(h.idempotencyTTL),
+	}
+	if err := h.state.Put(context.Background(), k, done); err != nil {
+		h.logger.WithError(err).Info("Fail to save idempotent response")
+	}
+}
+
+func newIdempotencyLock() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// lockIdempotencyKey creates the key with the lock of a request, and
+// returns the request of the key instead if it's held or done. An expired
+// key is taken over by the first request to create its takeover key, so
+// that two retries of a crashed request can't both take it over.
+func (h *handlers) lockIdempotencyKey(ctx context.Context, k string, lock idempotentRequest) (*idempotentRequest, error) {
+	for {
+		err := h.state.Create(ctx, k, lock)
+		if err == nil {
+			return nil, nil
+		}
+		if err != store.ErrExists {
+			return nil, err
+		}
+
+		var prev idempotentRequest
+		err = h.state.Get(ctx, k, &prev)
+		if err == store.ErrNotFound {
+			// released meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if time.Now().Before(prev.ExpiresAt) {
+			return &prev, nil
+		}
+
+		// keys from before locks had IDs are told apart by their expiry
+		takeover := prev.Lock
+		if takeover == "" {
+			takeover = strconv.FormatInt(prev.ExpiresAt.UnixNano(), 10)
+		}
+		err = h.state.Create(ctx, k+"/takeovers/"+takeover, lock.Lock)
+		// another request is taking it over
+		if err == store.ErrExists {
+			return &idempotentRequest{Fingerprint: lock.Fingerprint}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, h.state.Put(ctx, k, lock)
+	}
+}
+
+// refreshIdempotencyKey pushes back the expiry of the key of a request in
+// progress until ctx is done.
+func (h *handlers) refreshIdempotencyKey(ctx context.Context, k string, lock idempotentRequest) {
+	ticker := time.NewTicker(idempotencyLockRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lock.ExpiresAt = time.Now().Add(idempotencyLockTTL)
+			if err := h.state.Put(ctx, k, lock); err != nil {
+				h.logger.WithError(err).Info("Fail to refresh idempotency key")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// HandleAgentIdle returns the warning the worker left for the editor of an
+// agent before releasing it, if any.
+func (h *handlers) HandleAgentIdle(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var resp model.IdleResponse
+	var iw model.IdleWarning
+	err := h.state.Get(r.Context(), editor.IdleWarningKey(name), &iw)
+	if err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err == nil {
+		resp.Warning = &iw
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleAgentKeepAlive counts as a request served by the editor of an
+// agent, which postpones its release with router idle detection. It's
+// called when the user dismisses the idle warning in the editor.
+func (h *handlers) HandleAgentKeepAlive(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	now := time.Now()
+	var a model.Activity
+	if err := h.state.Get(r.Context(), editor.ActivityKey(name), &a); err == store.ErrNotFound {
+		a = model.Activity{Editor: name, Since: now}
+	} else if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if now.After(a.LastRequestAt) {
+		a.LastRequestAt = now
+	}
+	if err := h.state.Put(r.Context(), editor.ActivityKey(name), a); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.state.Delete(r.Context(), editor.IdleWarningKey(name)); err != nil && err != store.ErrNotFound {
+		h.logger.WithError(err).WithField("app", name).Info("Fail to delete idle warning")
+	}
+
+	h.logger.WithField("app", name).Info("Editor is kept alive")
+
+	w.WriteHeader(http.StatusNoContent)
+}
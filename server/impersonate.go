@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/middleware"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	impersonationsPrefix = "impersonations/"
+	// impersonationTokenPrefix tells impersonation tokens apart from Heroku
+	// API tokens
+	impersonationTokenPrefix = "cfimp_"
+)
+
+func impersonationKey(id string) string {
+	return impersonationsPrefix + id
+}
+
+// impersonatedRequestKey is the audit record of a request made with an
+// impersonation. xids sort by time.
+func impersonatedRequestKey(id, requestID string) string {
+	return "impersonationrequests/" + id + "/" + requestID
+}
+
+// impersonationTokenKey maps an impersonation token to its impersonation,
+// only a hash of the token is stored.
+func impersonationTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "impersonationtokens/" + hex.EncodeToString(sum[:])
+}
+
+func (h *handlers) isSupport(acct *hkclient.Account) bool {
+	for _, u := range h.supportUsers {
+		if acct.Email == u {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HandleCreateImpersonation hands a support user a token to act as another
+// user with.
+func (h *handlers) HandleCreateImpersonation(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isSupport(acct) || impersonating(r) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only support users may impersonate"})
+		return
+	}
+
+	var req model.ImpersonationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// impersonations don't get more than the user has
+	target := &hkclient.Account{Email: req.User}
+	if h.isAdmin(target) || h.isSupport(target) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "admins and support users can't be impersonated"})
+		return
+	}
+	if !h.allowed(req.User) {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "user isn't allowed to use Codeface"})
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	token := impersonationTokenPrefix + hex.EncodeToString(b)
+
+	now := time.Now()
+	imp := model.Impersonation{
+		ID:        xid.New().String(),
+		Support:   acct.Email,
+		User:      req.User,
+		Reason:    req.Reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.impersonationTTL),
+	}
+
+	if err := h.state.Put(r.Context(), impersonationKey(imp.ID), imp); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.state.Put(r.Context(), impersonationTokenKey(token), imp.ID); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{
+		"impersonation": imp.ID,
+		"support":       imp.Support,
+		"user":          imp.User,
+		"reason":        imp.Reason,
+		"expires_at":    imp.ExpiresAt,
+	}).Warn("Started impersonation")
+
+	imp.Token = token
+	jsonResp(w, http.StatusCreated, imp)
+}
+
+// HandleImpersonations lists every impersonation for support users and
+// admins, who audit them.
+func (h *handlers) HandleImpersonations(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if (!h.isSupport(acct) && !h.isAdmin(acct)) || impersonating(r) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only support users and admins may list impersonations"})
+		return
+	}
+
+	keys, err := h.state.List(r.Context(), impersonationsPrefix)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := model.ImpersonationsResponse{Impersonations: []model.Impersonation{}}
+	for _, k := range keys {
+		var imp model.Impersonation
+		if err := h.state.Get(r.Context(), k, &imp); err != nil {
+			continue
+		}
+		resp.Impersonations = append(resp.Impersonations, imp)
+	}
+
+	sort.Slice(resp.Impersonations, func(i, j int) bool {
+		return resp.Impersonations[i].CreatedAt.After(resp.Impersonations[j].CreatedAt)
+	})
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleImpersonation returns an impersonation with the requests that were
+// made with it, oldest first, for support users and admins.
+func (h *handlers) HandleImpersonation(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if (!h.isSupport(acct) && !h.isAdmin(acct)) || impersonating(r) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only support users and admins may audit impersonations"})
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var imp model.Impersonation
+	err := h.state.Get(r.Context(), impersonationKey(id), &imp)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "impersonation is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	keys, err := h.state.List(r.Context(), impersonatedRequestKey(id, ""))
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	for _, k := range keys {
+		var req model.ImpersonatedRequest
+		if err := h.state.Get(r.Context(), k, &req); err != nil {
+			continue
+		}
+		imp.Requests = append(imp.Requests, req)
+	}
+
+	jsonResp(w, http.StatusOK, imp)
+}
+
+// HandleRevokeImpersonation ends an impersonation before it expires. The
+// record is kept for the audit trail.
+func (h *handlers) HandleRevokeImpersonation(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if (!h.isSupport(acct) && !h.isAdmin(acct)) || impersonating(r) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only support users and admins may revoke impersonations"})
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var imp model.Impersonation
+	err := h.state.Get(r.Context(), impersonationKey(id), &imp)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "impersonation is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if imp.RevokedAt == nil {
+		now := time.Now()
+		imp.RevokedAt = &now
+		if err := h.state.Put(r.Context(), impersonationKey(id), imp); err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		h.logger.WithFields(log.Fields{
+			"impersonation": imp.ID,
+			"support":       imp.Support,
+			"user":          imp.User,
+			"revoked_by":    acct.Email,
+		}).Warn("Revoked impersonation")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveImpersonation serves a request of a support user as the user they
+// impersonate, and logs it. The request is recorded in the store first, and
+// refused if it can't be, so that none goes unaudited.
+func (h *handlers) serveImpersonation(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	var id string
+	if err := h.state.Get(r.Context(), impersonationTokenKey(token), &id); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var imp model.Impersonation
+	if err := h.state.Get(r.Context(), impersonationKey(id), &imp); err != nil || imp.RevokedAt != nil || time.Now().After(imp.ExpiresAt) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.logger.WithFields(log.Fields{
+		"request_id":    r.Header.Get(middleware.RequestIDHeader),
+		"impersonation": imp.ID,
+		"support":       imp.Support,
+		"user":          imp.User,
+		"method":        r.Method,
+		"path":          r.URL.Path,
+	}).Warn("Impersonated request")
+
+	req := model.ImpersonatedRequest{
+		RequestID: r.Header.Get(middleware.RequestIDHeader),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		At:        time.Now(),
+	}
+	if err := h.state.Put(r.Context(), impersonatedRequestKey(imp.ID, xid.New().String()), req); err != nil {
+		h.logger.WithError(err).WithField("impersonation", imp.ID).Info("Fail to record impersonated request")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// there's no Heroku API token of the user, so requests that act on
+	// their Heroku account such as transfers fail
+	r = r.WithContext(context.WithValue(r.Context(), supportKey, &imp))
+	h.serveAccount(w, r, next, &hkclient.Account{Email: imp.User})
+}
+
+func impersonating(r *http.Request) bool {
+	return r.Context().Value(supportKey) != nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+)
+
+const (
+	defaultRuntimeLogLines = 100
+	maxRuntimeLogLines     = 1500
+)
+
+// HandleEditorRuntimeLogs streams the output of the process of a claimed
+// editor to its owner, so that it can be debugged without access to the
+// account of the provider. New output is streamed until the client goes
+// away unless follow=false is set.
+func (h *handlers) HandleEditorRuntimeLogs(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	s, ok := h.ownedSession(w, r, name, acct)
+	if !ok {
+		return
+	}
+	if s.EndedAt != nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not running"})
+		return
+	}
+
+	query := r.URL.Query()
+	lines := defaultRuntimeLogLines
+	if v := query.Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxRuntimeLogLines {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("lines must be between 0 and %d", maxRuntimeLogLines)})
+			return
+		}
+		lines = n
+	}
+	follow := query.Get("follow") != "false"
+
+	ls, ok := provider.Lookup(h.provider, provider.OfSession(*s)).(provider.LogStreamer)
+	if !ok {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("logs of editors on %s can't be streamed", provider.OfSession(*s))})
+		return
+	}
+
+	logs, err := ls.Logs(r.Context(), name, lines, follow)
+	if err != nil {
+		h.logger.WithError(err).WithField("app", name).Info("Fail to stream runtime logs")
+		jsonResp(w, http.StatusBadGateway, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := logs.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/jingweno/codeface/metrics"
+)
+
+// HandleMetrics serves the metrics of the calls the server made to the
+// Heroku API and the boot times agents reported to it in the
+// Prometheus text format. Scrapers send the metrics
+// token as a bearer token.
+func (h *handlers) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.metricsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(h.metricsToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.Heroku.Write(w, "codeface_heroku_api"); err != nil {
+		h.logger.WithError(err).Info("Fail to write metrics")
+		return
+	}
+	if err := metrics.Boots.Write(w, "codeface_editor_boot"); err != nil {
+		h.logger.WithError(err).Info("Fail to write metrics")
+	}
+}
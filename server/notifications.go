@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/notify"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// notificationPollInterval is how often the notifications of a
+	// connected user are looked up in the store
+	notificationPollInterval = 2 * time.Second
+	// notificationPingInterval keeps idle channels open, the Heroku router
+	// closes connections that are idle for 55s
+	notificationPingInterval = 30 * time.Second
+)
+
+// HandleNotifications pushes the notifications of the user over a
+// WebSocket as JSON messages, e.g. that their editor is ready or about to
+// be released. Clients that reconnect pass the ID of the last one they got
+// as after to get the ones they missed.
+func (h *handlers) HandleNotifications(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	after := r.URL.Query().Get("after")
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	logger := h.logger.WithField("user", acct.Email)
+	logger.Info("Pushing notifications")
+
+	// the connection is hijacked, the request context isn't canceled
+	// when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	since := notify.Since(after, time.Now())
+	seen := map[string]bool{after: true}
+	poll := time.NewTicker(notificationPollInterval)
+	defer poll.Stop()
+	ping := time.NewTicker(notificationPingInterval)
+	defer ping.Stop()
+
+	for {
+		ns, err := notify.Unseen(ctx, h.state, acct.Email, since, seen)
+		if err != nil && ctx.Err() == nil {
+			logger.WithError(err).Info("Fail to get notifications")
+		}
+		for _, n := range ns {
+			b, err := json.Marshal(n)
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(b); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		case <-poll.C:
+		}
+	}
+}
+
+// notifyReady tells the user that their editor is ready. Editors that took
+// longer than READY_EMAIL_AFTER to claim since claimedAt, e.g. cold
+// deploys, are emailed as well, since the user may have stopped waiting.
+func (h *handlers) notifyReady(ctx context.Context, user string, ed *provider.Editor, claimedAt time.Time) {
+	n := model.Notification{
+		Type:    model.NotificationEditorReady,
+		User:    user,
+		Editor:  ed.Name,
+		Message: "Your editor " + ed.Name + " is ready.",
+		URL:     ed.URL,
+	}
+	if err := h.notifier.Deliver(ctx, n); err != nil {
+		h.logger.WithError(err).WithFields(log.Fields{"app": ed.Name, "user": user}).Info("Fail to publish notification")
+	}
+
+	if h.readyEmailAfter > 0 && time.Since(claimedAt) >= h.readyEmailAfter {
+		h.notifier.Email(ctx, n)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/github"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/policy"
+	log "github.com/sirupsen/logrus"
+)
+
+// policyDenial returns why CLAIM_POLICIES deny a claim, or empty if they
+// don't. Admins aren't held to the policies, as they don't need approval.
+func (h *handlers) policyDenial(acct *hkclient.Account, opt model.EditorRequest) string {
+	if len(h.policies) == 0 || h.isAdmin(acct) {
+		return ""
+	}
+
+	in := policy.Input{
+		User:     acct.Email,
+		Template: opt.Template,
+		DynoSize: opt.DynoSize,
+		GitRepo:  strings.TrimSuffix(strings.TrimSuffix(opt.GitRepo, "/"), ".git"),
+		Labels:   opt.Labels,
+		Time:     time.Now().In(h.policyLocation),
+	}
+	if opt.PullRequest != "" {
+		if owner, repo, _, err := github.ParsePullRequest(opt.PullRequest); err == nil {
+			in.GitRepo = github.RepoURL(owner, repo)
+		}
+	}
+
+	reason, err := h.policies.Deny(in)
+	logger := h.logger.WithFields(log.Fields{"user": in.User, "template": in.Template})
+	if err != nil {
+		logger.WithError(err).Info("Fail to evaluate claim policy")
+	}
+	if reason != "" {
+		logger.WithField("reason", reason).Info("Denied claim by policy")
+	}
+
+	return reason
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/middleware"
+	"github.com/jingweno/codeface/model"
+)
+
+// rateLimitedRoutes are the routes that claim editors or list what's on
+// the providers, which cost Heroku API calls on every request.
+var rateLimitedRoutes = map[string]bool{
+	"POST /editor":                    true,
+	"POST /open":                      true,
+	"POST /guest":                     true,
+	"GET /seat":                       true,
+	"POST /v1/batches":                true,
+	"POST /v1/claims":                 true,
+	"POST /v1/runs":                   true,
+	"POST /v1/approvals/{id}/approve": true,
+	"PUT /v1/credentials/heroku":      true,
+	"POST /v1/device/code":            true,
+	"POST /v1/device/token":           true,
+	"POST /v1/device/refresh":         true,
+	"GET /v1/editors":                 true,
+	"GET /v1/pool":                    true,
+	"GET /v1/sessions":                true,
+	"GET /v1/usage":                   true,
+	"GET /v1/resources":               true,
+	"GET /v1/recordings":              true,
+	"GET /v1/deploys":                 true,
+	"GET /v1/artifacts/{template}":    true,
+}
+
+// rateLimiter limits requests per client IP, and per API token or session
+// once it's authenticated. Either limiter is off when its rate is 0.
+type rateLimiter struct {
+	token *middleware.Limiter
+	ip    *middleware.Limiter
+	// hops is the number of proxies in front of the server, e.g. 1 for the
+	// Heroku router
+	hops int
+}
+
+func newRateLimiter(cfg Config) *rateLimiter {
+	l := &rateLimiter{hops: cfg.trustedHops()}
+	if cfg.RateLimitTokenRate > 0 {
+		l.token = middleware.NewLimiter(cfg.RateLimitTokenRate/60, cfg.RateLimitTokenBurst)
+	}
+	if cfg.RateLimitIPRate > 0 {
+		l.ip = middleware.NewLimiter(cfg.RateLimitIPRate/60, cfg.RateLimitIPBurst)
+	}
+
+	return l
+}
+
+// trustedHops is the number of proxies in front of the server. Dynos are
+// always behind the Heroku router, and telling clients apart by the address
+// of the router would give all of them one limit.
+func (cfg Config) trustedHops() int {
+	if cfg.RateLimitTrustedHops >= 0 {
+		return cfg.RateLimitTrustedHops
+	}
+	if cfg.Dyno != "" {
+		return 1
+	}
+
+	return 0
+}
+
+// Middleware rejects requests over the limit of their client IP with a 429
+// and the seconds to wait in Retry-After. It runs before the requests are
+// authenticated, which takes a Heroku API call too.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.ip == nil || !l.limited(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := editorproxy.ClientIP(r, l.hops)
+		if ip == nil {
+			jsonResp(w, http.StatusBadRequest, model.ErrorResponse{Error: "client IP is unknown"})
+			return
+		}
+
+		if ok, wait := l.ip.Allow(ip.String(), time.Now()); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TokenMiddleware rejects authenticated requests over the limit of their
+// API token or session. It runs after the requests are authenticated, so
+// that made up tokens can't use up the limits of others or dodge the limit
+// of their IP.
+func (l *rateLimiter) TokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.token == nil || !l.limited(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if key := tokenLimitKey(r); key != "" {
+			if ok, wait := l.token.Allow(key, time.Now()); !ok {
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *rateLimiter) limited(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+
+	return rateLimitedRoutes[r.Method+" "+tmpl]
+}
+
+// tokenLimitKey identifies the credential of an authenticated request,
+// which is the API token or the OAuth token of the session. It's empty for
+// requests that aren't authenticated.
+func tokenLimitKey(r *http.Request) string {
+	if _, ok := r.Context().Value(accountKey).(*hkclient.Account); !ok {
+		return ""
+	}
+
+	// impersonation tokens are checked without a Heroku token
+	cred, _ := r.Context().Value(tokenKey).(string)
+	if cred == "" {
+		cred = bearerToken(r)
+	}
+	if cred == "" {
+		return ""
+	}
+
+	// keep credentials out of memory dumps
+	sum := sha256.Sum256([]byte(cred))
+	return hex.EncodeToString(sum[:])
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	jsonResp(w, http.StatusTooManyRequests, model.ErrorResponse{Error: "too many requests, retry later"})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestClaimRoutesAreRateLimited(t *testing.T) {
+	claimHandlers := []func(*handlers, http.ResponseWriter, *http.Request){
+		(*handlers).HandleEditor,
+		(*handlers).HandleCreateClaim,
+		(*handlers).HandleCreateRun,
+		(*handlers).HandleCreateBatch,
+		(*handlers).HandleApprove,
+	}
+
+	claims := make(map[uintptr]bool)
+	for _, h := range claimHandlers {
+		claims[reflect.ValueOf(h).Pointer()] = true
+	}
+
+	for _, route := range apiRoutes {
+		name := route.Method + " " + route.Path
+		if claims[reflect.ValueOf(route.Handler).Pointer()] && !route.Claims {
+			t.Errorf("%s claims editors but isn't marked Claims", name)
+		}
+		if route.Claims && !rateLimitedRoutes[name] {
+			t.Errorf("%s claims editors but isn't in rateLimitedRoutes", name)
+		}
+	}
+}
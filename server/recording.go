@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// recordingUploadTimeout is how long the agent of an editor has to
+	// upload a recording, and to upload the last ones after the session ends
+	recordingUploadTimeout = 10 * time.Minute
+	recordingExpiry        = time.Hour
+)
+
+// HandleAgentRecording records a terminal recording of the editor of an
+// agent and returns the URL it's uploaded to.
+func (h *handlers) HandleAgentRecording(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	// editors upload the last recordings as they stop, which is after their
+	// session has ended
+	var s model.Session
+	name, err := agent.Editor(r.Context(), h.state, bearerToken(r))
+	if err == nil {
+		err = h.state.Get(r.Context(), usage.SessionKey(name), &s)
+	}
+	if err != nil || (s.EndedAt != nil && now.Sub(*s.EndedAt) > recordingUploadTimeout) {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	if h.cache == nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "recordings need CACHE_S3_BUCKET"})
+		return
+	}
+
+	var req model.RecordingRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	rec := model.Recording{
+		ID:         req.ID,
+		Editor:     name,
+		User:       s.User,
+		Template:   s.Template,
+		Object:     agent.RecordingObject(name, req.ID),
+		Bytes:      req.Bytes,
+		StartedAt:  req.StartedAt,
+		UploadedAt: now,
+	}
+	if err := h.state.Put(r.Context(), agent.RecordingKey(name, req.ID), rec); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"app": name, "recording": req.ID, "user": s.User}).Info("Uploading terminal recording")
+
+	jsonResp(w, http.StatusOK, model.RecordingUploadResponse{
+		UploadURL: h.cache.Presign(http.MethodPut, rec.Object, recordingUploadTimeout, now),
+	})
+}
+
+// HandleRecordings lists the terminal recordings of every editor, or of
+// one, newest first. Only admins may look at recordings.
+func (h *handlers) HandleRecordings(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at recordings"})
+		return
+	}
+
+	prefix := agent.RecordingsPrefix
+	if editor := r.URL.Query().Get("editor"); editor != "" {
+		prefix += editor + "/"
+	}
+
+	keys, err := h.state.List(r.Context(), prefix)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := model.RecordingsResponse{Recordings: []model.Recording{}}
+	for _, k := range keys {
+		var rec model.Recording
+		if err := h.state.Get(r.Context(), k, &rec); err != nil {
+			continue
+		}
+		resp.Recordings = append(resp.Recordings, rec)
+	}
+
+	sort.Slice(resp.Recordings, func(i, j int) bool {
+		return resp.Recordings[i].StartedAt.After(resp.Recordings[j].StartedAt)
+	})
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleRecording returns a terminal recording with a download URL.
+func (h *handlers) HandleRecording(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at recordings"})
+		return
+	}
+
+	vars := mux.Vars(r)
+	var rec model.Recording
+	err := h.state.Get(r.Context(), agent.RecordingKey(vars["name"], vars["id"]), &rec)
+	if err == store.ErrNotFound || (err == nil && h.cache == nil) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "recording is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	rec.DownloadURL = h.cache.Presign(http.MethodGet, rec.Object, recordingExpiry, time.Now())
+
+	h.logger.WithFields(log.Fields{"app": rec.Editor, "recording": rec.ID, "admin": acct.Email}).Info("Downloading terminal recording")
+
+	jsonResp(w, http.StatusOK, rec)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// HandleAgentResources records the memory and CPU utilization reported by
+// the agent of an editor, along with its peaks.
+func (h *handlers) HandleAgentResources(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var ru model.ResourceUsage
+	if !decodeJSON(w, r, &ru) {
+		return
+	}
+
+	var s model.Session
+	if err := h.state.Get(r.Context(), usage.SessionKey(name), &s); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var last model.ResourceUsage
+	if err := h.state.Get(r.Context(), agent.ResourcesKey(name), &last); err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ru.Editor = name
+	ru.Template = s.Template
+	ru.ReportedAt = time.Now()
+	ru.PeakMemoryBytes = max64(last.PeakMemoryBytes, ru.MemoryBytes)
+	ru.PeakCPUPercent = last.PeakCPUPercent
+	if ru.CPUPercent > ru.PeakCPUPercent {
+		ru.PeakCPUPercent = ru.CPUPercent
+	}
+	ru.Warning = ""
+
+	if ru.MemoryLimitBytes > 0 && ru.MemoryBytes*100 >= ru.MemoryLimitBytes*int64(h.resourceWarnPercent) {
+		ru.Warning = fmt.Sprintf("Memory is %d%% used, the editor may need a larger dyno", ru.MemoryBytes*100/ru.MemoryLimitBytes)
+
+		h.logger.WithFields(log.Fields{
+			"app":   name,
+			"used":  ru.MemoryBytes,
+			"limit": ru.MemoryLimitBytes,
+		}).Warn("Editor memory is nearly used up")
+	}
+
+	if err := h.state.Put(r.Context(), agent.ResourcesKey(name), ru); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, ru)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// HandleEditorResources returns the last resource usage reported by an
+// editor of the user, or of anyone for admins.
+func (h *handlers) HandleEditorResources(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	if _, ok := h.ownedSession(w, r, name, acct); !ok {
+		return
+	}
+
+	var ru model.ResourceUsage
+	err := h.state.Get(r.Context(), agent.ResourcesKey(name), &ru)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor hasn't reported resource usage"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, ru)
+}
+
+// HandleTemplateResources sums up the resource usage of the claimed
+// editors of each template, so that admins can size the dynos of
+// templates.
+func (h *handlers) HandleTemplateResources(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at the resources of templates"})
+		return
+	}
+
+	resources, err := h.editorResources(r)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	byTemplate := make(map[string]*model.TemplateResources)
+	var cpu = make(map[string]float64)
+	var memory = make(map[string]int64)
+	for _, ru := range resources {
+		t, ok := byTemplate[ru.Template]
+		if !ok {
+			t = &model.TemplateResources{Template: ru.Template}
+			byTemplate[ru.Template] = t
+		}
+
+		t.Editors++
+		memory[ru.Template] += ru.MemoryBytes
+		cpu[ru.Template] += ru.CPUPercent
+		t.PeakMemoryBytes = max64(t.PeakMemoryBytes, ru.PeakMemoryBytes)
+		t.MemoryLimitBytes = max64(t.MemoryLimitBytes, ru.MemoryLimitBytes)
+		if ru.PeakCPUPercent > t.PeakCPUPercent {
+			t.PeakCPUPercent = ru.PeakCPUPercent
+		}
+	}
+
+	resp := model.ResourcesResponse{Templates: []model.TemplateResources{}}
+	for name, t := range byTemplate {
+		t.AvgMemoryBytes = memory[name] / int64(t.Editors)
+		t.AvgCPUPercent = cpu[name] / float64(t.Editors)
+		resp.Templates = append(resp.Templates, *t)
+	}
+
+	sort.Slice(resp.Templates, func(i, j int) bool {
+		return resp.Templates[i].Template < resp.Templates[j].Template
+	})
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// editorResources returns the last resource usage reported by each claimed
+// editor, by editor.
+func (h *handlers) editorResources(r *http.Request) (map[string]model.ResourceUsage, error) {
+	sessions, err := usage.OpenSessions(r.Context(), h.state)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string]model.ResourceUsage)
+	for _, s := range sessions {
+		var ru model.ResourceUsage
+		err := h.state.Get(r.Context(), agent.ResourcesKey(s.App), &ru)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resources[s.App] = ru
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// runTerminateTimeout is how long terminating the editor of a finished run
+// may take.
+const runTerminateTimeout = 5 * time.Minute
+
+// runKey is the key of the run of a headless editor.
+func runKey(appName string) string {
+	return "runs/" + appName
+}
+
+// HandleCreateRun claims a headless editor from the pool that runs a
+// command instead of code-server, e.g. a CI job. Its output is streamed with
+// the runtime logs of the editor, and the editor is terminated once the
+// agent reports the exit status of the command.
+func (h *handlers) HandleCreateRun(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var req model.RunRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// the agent reports the exit status to the server
+	if h.serverURL == "" {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "headless runs need SERVER_URL to be set on the server"})
+		return
+	}
+	if c := h.checkClaim(r.Context(), acct, req.EditorRequest); c != nil {
+		c.refuse(w, "runs can't wait for approval, %s")
+		return
+	}
+
+	ed, status, err := h.claimEditor(r.Context(), acct.Email, req.EditorRequest, req.Command)
+	if err != nil {
+		jsonResp(w, status, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	tmpl := ed.Template
+	if tmpl == "" {
+		tmpl = editor.DefaultTemplate
+	}
+	run := model.Run{
+		Editor:    ed.Name,
+		User:      acct.Email,
+		Template:  tmpl,
+		Command:   req.Command,
+		StartedAt: time.Now(),
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": ed.Name, "user": acct.Email})
+	if err := h.state.Put(r.Context(), runKey(ed.Name), run); err != nil {
+		logger.WithError(err).Info("Fail to save run")
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	logger.Info("Running command in headless editor")
+
+	jsonResp(w, http.StatusCreated, run)
+}
+
+// HandleRun returns a run to its user and to admins, with its exit status
+// once the command exited.
+func (h *handlers) HandleRun(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	var run model.Run
+	err := h.state.Get(r.Context(), runKey(name), &run)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && run.User != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "run is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, run)
+}
+
+// HandleAgentRun completes the run of the editor of an agent with the exit
+// status of its command, and terminates the editor.
+func (h *handlers) HandleAgentRun(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var res model.RunResult
+	if !decodeJSON(w, r, &res) {
+		return
+	}
+
+	var run model.Run
+	err := h.state.Get(r.Context(), runKey(name), &run)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "the editor has no run"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if run.FinishedAt == nil {
+		now := time.Now()
+		run.FinishedAt = &now
+		run.ExitCode = &res.ExitCode
+		if err := h.state.Put(r.Context(), runKey(name), run); err != nil {
+			jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": name, "user": run.User, "exit_code": *run.ExitCode})
+	logger.Info("Command of headless editor exited")
+
+	// the editor is terminated after the agent got its response
+	go h.terminateRun(name, logger)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) terminateRun(name string, logger log.FieldLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), runTerminateTimeout)
+	defer cancel()
+
+	var s model.Session
+	if err := h.state.Get(ctx, usage.SessionKey(name), &s); err != nil {
+		logger.WithError(err).Info("Fail to get session")
+		return
+	}
+	if s.EndedAt != nil {
+		return
+	}
+
+	// the error is logged by terminate, an editor that isn't terminated is
+	// released by the worker once it's idle
+	h.terminate(ctx, s, logger)
+}
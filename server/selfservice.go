@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// userSnapshotTimeout is how long the agent has to upload a snapshot the
+// user of its editor saves.
+const userSnapshotTimeout = 30 * time.Minute
+
+// agentSession returns the session of an editor as its user sees it from
+// the editor. Records of earlier claims of the editor don't count.
+func (h *handlers) agentSession(ctx context.Context, name string) (*model.AgentSession, error) {
+	var s model.Session
+	if err := h.state.Get(ctx, usage.SessionKey(name), &s); err != nil {
+		return nil, err
+	}
+
+	claimedAt := s.ClaimedAt
+	if claimedAt.IsZero() {
+		claimedAt = s.StartedAt
+	}
+	as := &model.AgentSession{
+		Editor:        name,
+		Template:      s.Template,
+		User:          s.User,
+		ClaimedAt:     claimedAt,
+		MaxExtensions: h.maxExtensions,
+		Ports:         []int{},
+	}
+
+	var exp model.SessionExpiry
+	err := h.state.Get(ctx, editor.SessionExpiryKey(name), &exp)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	if err == nil && exp.ClaimedAt.Equal(claimedAt) {
+		as.ExpiresAt = &exp.ExpiresAt
+	}
+
+	ext, err := h.sessionExtension(ctx, name, claimedAt)
+	if err != nil {
+		return nil, err
+	}
+	as.Extensions = ext.Extensions
+
+	ports, err := h.editorPorts(ctx, name, claimedAt)
+	if err != nil {
+		return nil, err
+	}
+	as.Ports = ports.Ports
+
+	return as, nil
+}
+
+// sessionExtension returns the extensions of the session of an editor
+// claimed at claimedAt.
+func (h *handlers) sessionExtension(ctx context.Context, name string, claimedAt time.Time) (*model.SessionExtension, error) {
+	var ext model.SessionExtension
+	err := h.state.Get(ctx, editor.SessionExtensionKey(name), &ext)
+	if err == store.ErrNotFound || (err == nil && ext.ExtendedAt.Before(claimedAt)) {
+		return &model.SessionExtension{Editor: name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ext, nil
+}
+
+// editorPorts returns the ports opened in an editor claimed at claimedAt.
+func (h *handlers) editorPorts(ctx context.Context, name string, claimedAt time.Time) (*model.EditorPorts, error) {
+	var ports model.EditorPorts
+	err := h.state.Get(ctx, editor.PortsKey(name), &ports)
+	if err == store.ErrNotFound || (err == nil && ports.UpdatedAt.Before(claimedAt)) {
+		return &model.EditorPorts{Editor: name, Ports: []int{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ports, nil
+}
+
+// HandleAgentSession returns the session of the editor of an agent, e.g.
+// the time it has left.
+func (h *handlers) HandleAgentSession(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	as, err := h.agentSession(r.Context(), name)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, as)
+}
+
+// HandleAgentExtend extends the maximum session duration of the editor of
+// an agent by SESSION_EXTENSION, up to MAX_SESSION_EXTENSIONS times. The
+// worker picks the new expiry up with its next check.
+func (h *handlers) HandleAgentExtend(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	as, err := h.agentSession(r.Context(), name)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	logger := h.logger.WithFields(log.Fields{"app": name, "user": as.User})
+
+	if as.User == model.GuestUser {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "guest editors can't be extended"})
+		return
+	}
+	if as.ExpiresAt == nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "editor has no maximum session duration"})
+		return
+	}
+	if as.Extensions >= h.maxExtensions {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("editor was extended the most times it may be, %d", h.maxExtensions)})
+		return
+	}
+
+	// the worker already takes editors that are being snapshotted down
+	var susp model.Suspension
+	if err := h.state.Get(r.Context(), editor.SuspensionKey(name), &susp); err == nil {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: "editor is being released"})
+		return
+	}
+
+	ext, err := h.sessionExtension(r.Context(), name, as.ClaimedAt)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ext.Extensions++
+	ext.ExtendedBy += h.sessionExtensionBy
+	ext.ExtendedAt = time.Now()
+	if err := h.state.Put(r.Context(), editor.SessionExtensionKey(name), ext); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// the warning before the release is for the expiry that was extended
+	if err := h.state.Delete(r.Context(), editor.IdleWarningKey(name)); err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to delete idle warning")
+	}
+
+	expiresAt := as.ExpiresAt.Add(h.sessionExtensionBy)
+	as.ExpiresAt = &expiresAt
+	as.Extensions = ext.Extensions
+
+	logger.WithFields(log.Fields{
+		"extensions": ext.Extensions,
+		"expires_at": expiresAt,
+	}).Info("Extended session")
+
+	jsonResp(w, http.StatusOK, as)
+}
+
+// HandleAgentSaveSnapshot hands out an upload URL for a snapshot of the
+// workspace of an editor that keeps running, which its user saves from the
+// editor. Its owner downloads the last one from /v1/editors/{name}/snapshot.
+func (h *handlers) HandleAgentSaveSnapshot(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	if h.cache == nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "snapshots need CACHE_S3_BUCKET to be set on the server"})
+		return
+	}
+
+	now := time.Now()
+	snap := model.WorkspaceSnapshot{
+		Editor:      name,
+		Object:      editor.SnapshotObject(name, now),
+		RequestedAt: now,
+	}
+
+	var prev model.WorkspaceSnapshot
+	err := h.state.Get(r.Context(), editor.WorkspaceSnapshotKey(name), &prev)
+	if err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	// the last saved snapshot is kept until the next one is saved
+	if err == nil && prev.SavedAt != nil {
+		snap.SavedAt = prev.SavedAt
+		snap.Saved = prev.Saved
+	}
+
+	if err := h.state.Put(r.Context(), editor.WorkspaceSnapshotKey(name), snap); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.SnapshotResponse{
+		UploadURL: h.cache.Presign(http.MethodPut, snap.Object, userSnapshotTimeout, now),
+	})
+}
+
+// HandleAgentCompleteSnapshot records that the snapshot saved by the user
+// of an editor is uploaded.
+func (h *handlers) HandleAgentCompleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var snap model.WorkspaceSnapshot
+	err := h.state.Get(r.Context(), editor.WorkspaceSnapshotKey(name), &snap)
+	if err == store.ErrNotFound || (err == nil && time.Since(snap.RequestedAt) > userSnapshotTimeout) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "snapshot is not being saved"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	snap.SavedAt = &now
+	snap.Saved = snap.Object
+	if err := h.state.Put(r.Context(), editor.WorkspaceSnapshotKey(name), snap); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"app": name, "object": snap.Object}).Info("Saved workspace snapshot")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAgentPorts lists the ports the proxy of the editor of an agent
+// serves.
+func (h *handlers) HandleAgentPorts(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	as, err := h.agentSession(r.Context(), name)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.EditorPorts{Editor: name, Ports: as.Ports})
+}
+
+// HandleAgentOpenPort has the proxy of the editor of an agent serve a port
+// at /ports/#{PORT}/ of the editor URL, e.g. of a dev server. It's behind
+// the same network policy as the editor.
+func (h *handlers) HandleAgentOpenPort(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var req model.PortRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	h.updatePorts(w, r, name, func(ports []int) []int {
+		for _, p := range ports {
+			if p == req.Port {
+				return ports
+			}
+		}
+		return append(ports, req.Port)
+	})
+}
+
+// HandleAgentClosePort stops serving a port of the editor of an agent.
+func (h *handlers) HandleAgentClosePort(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	port, err := strconv.Atoi(mux.Vars(r)["port"])
+	if err != nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "port is not open"})
+		return
+	}
+
+	h.updatePorts(w, r, name, func(ports []int) []int {
+		var kept []int
+		for _, p := range ports {
+			if p != port {
+				kept = append(kept, p)
+			}
+		}
+		return kept
+	})
+}
+
+func (h *handlers) updatePorts(w http.ResponseWriter, r *http.Request, name string, update func([]int) []int) {
+	as, err := h.agentSession(r.Context(), name)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ports := model.EditorPorts{
+		Editor:    name,
+		Ports:     update(as.Ports),
+		UpdatedAt: time.Now(),
+	}
+	if ports.Ports == nil {
+		ports.Ports = []int{}
+	}
+	sort.Ints(ports.Ports)
+
+	if err := h.state.Put(r.Context(), editor.PortsKey(name), ports); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"app": name, "ports": ports.Ports}).Info("Updated editor ports")
+
+	jsonResp(w, http.StatusOK, ports)
+}
@@ -7,7 +7,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/securecookie"
@@ -16,8 +19,27 @@ import (
 	"golang.org/x/oauth2/heroku"
 
 	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/dns"
 	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/extensions"
+	"github.com/jingweno/codeface/github"
+	"github.com/jingweno/codeface/health"
+	"github.com/jingweno/codeface/mail"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/middleware"
 	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/notify"
+	"github.com/jingweno/codeface/policy"
+	"github.com/jingweno/codeface/prebuild"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/s3"
+	"github.com/jingweno/codeface/secrets"
+	"github.com/jingweno/codeface/slack"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/oklog/run"
 	"github.com/shurcooL/httpgzip"
 	log "github.com/sirupsen/logrus"
 )
@@ -26,6 +48,11 @@ type contextKey int
 
 const (
 	accountKey contextKey = iota
+	// tokenKey is the Heroku API token of the user making the request
+	tokenKey
+	// supportKey is the impersonation a support user makes the request
+	// with
+	supportKey
 )
 
 func init() {
@@ -35,14 +62,280 @@ func init() {
 
 type Config struct {
 	Port               string   `env:"PORT,required"`
-	HerokuAPIKey       string   `env:"HEROKU_API_KEY,required"`
+	Provider           string   `env:"PROVIDER,default=heroku"`
+	HerokuAPIKey       string   `env:"HEROKU_API_KEY"`
 	HerokuClientID     string   `env:"HEROKU_CLIENT_ID,required"`
 	HerokuClientSecret string   `env:"HEROKU_CLIENT_SECRET,required"`
 	WhitelistUsers     []string `env:"WHITELIST_USERS"`
+	AdminUsers         []string `env:"ADMIN_USERS"`
+	StoreURL           string   `env:"STORE_URL,default=mem://"`
+	// SingleProcess runs the server without a worker, which is the only
+	// way it can run on a STORE_URL of mem://
+	SingleProcess bool `env:"SINGLE_PROCESS"`
+	// StoreEncryptionKeys encrypt the values of the store, e.g.
+	// kms:alias/codeface;file:/etc/codeface/old.key. The first one
+	// encrypts new values.
+	StoreEncryptionKeys []string `env:"STORE_ENCRYPTION_KEYS"`
+	// StoreAllowPlaintext reads the values written before the store was
+	// encrypted, until cf-admin rotate-store-key encrypts them
+	StoreAllowPlaintext bool `env:"STORE_ALLOW_PLAINTEXT"`
+
+	PreClaimHookURL   string        `env:"PRE_CLAIM_HOOK_URL"`
+	PostClaimHookURL  string        `env:"POST_CLAIM_HOOK_URL"`
+	ClaimHookSecret   string        `env:"CLAIM_HOOK_SECRET"`
+	ClaimHookTimeout  time.Duration `env:"CLAIM_HOOK_TIMEOUT,default=10s"`
+	ClaimHookFailOpen bool          `env:"CLAIM_HOOK_FAIL_OPEN,default=false"`
+
+	GitHubAppID         int64  `env:"GITHUB_APP_ID"`
+	GitHubAppPrivateKey string `env:"GITHUB_APP_PRIVATE_KEY"`
+
+	CacheS3Bucket      string `env:"CACHE_S3_BUCKET"`
+	AWSRegion          string `env:"AWS_REGION,default=us-east-1"`
+	AWSAccessKeyID     string `env:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey string `env:"AWS_SECRET_ACCESS_KEY"`
+
+	// IdempotencyKeyTTL is how long the response of a claim with an
+	// Idempotency-Key is replayed to its retries
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL,default=24h"`
+
+	// BatchMaxEditors is how many editors a batch may provision
+	BatchMaxEditors int `env:"BATCH_MAX_EDITORS,default=50"`
+
+	// HerokuOAuthScopes are granted by users logging in, e.g. global to
+	// claim editors into their own Heroku account with their grant
+	HerokuOAuthScopes []string `env:"HEROKU_OAUTH_SCOPES,default=identity"`
+
+	// TemplateGitURL is the template repository the byo provider deploys
+	// editors from when they're claimed
+	TemplateGitURL   string `env:"TEMPLATE_GIT_URL"`
+	TemplateGitRef   string `env:"TEMPLATE_GIT_REF,default=main"`
+	TemplateGitToken string `env:"TEMPLATE_GIT_TOKEN"`
+
+	// SupportUsers may impersonate other users for ImpersonationDuration,
+	// e.g. to reproduce their claim issues. Being an admin doesn't make one
+	// a support user.
+	SupportUsers          []string      `env:"SUPPORT_USERS"`
+	ImpersonationDuration time.Duration `env:"IMPERSONATION_DURATION,default=1h"`
+
+	// ViewerHost serves the viewer links of editors, which users share to
+	// let others watch their editor read-only for ViewerLinkDuration, e.g.
+	// view.codeface.example.com. Viewer links are off when it's empty. It
+	// must be another host than the one of the server, so that the pages of
+	// editors don't run on the origin of the dashboard.
+	ViewerHost         string        `env:"VIEWER_HOST"`
+	ViewerLinkDuration time.Duration `env:"VIEWER_LINK_DURATION,default=24h"`
+
+	// SlackSigningSecret verifies the slash commands of the Slack app at
+	// /slack/commands, which uses SlackBotToken to look up users and send
+	// them direct messages
+	SlackSigningSecret string `env:"SLACK_SIGNING_SECRET"`
+	SlackBotToken      string `env:"SLACK_BOT_TOKEN"`
+
+	// Emails are sent from MailFrom through the SMTP server at SMTPAddr,
+	// e.g. smtp.mailgun.org:587, or through SES in MailSESRegion. They
+	// carry the notifications of users who chose email in their settings,
+	// the editors of claims that took longer than ReadyEmailAfter, e.g.
+	// cold deploys, 0 to never email them, and the invitations to batches.
+	MailFrom        string        `env:"MAIL_FROM"`
+	SMTPAddr        string        `env:"SMTP_ADDR"`
+	SMTPUsername    string        `env:"SMTP_USERNAME"`
+	SMTPPassword    string        `env:"SMTP_PASSWORD"`
+	MailSESRegion   string        `env:"MAIL_SES_REGION"`
+	ReadyEmailAfter time.Duration `env:"READY_EMAIL_AFTER,default=2m"`
+
+	// ApprovalTemplates and dyno sizes above ApprovalDynoSize, e.g.
+	// standard-2x, are only claimed once an admin approves the claim.
+	// SlackApprovalChannel is told about the claims waiting for approval.
+	ApprovalTemplates    []string `env:"APPROVAL_TEMPLATES"`
+	ApprovalDynoSize     string   `env:"APPROVAL_DYNO_SIZE"`
+	SlackApprovalChannel string   `env:"SLACK_APPROVAL_CHANNEL"`
+
+	// ClaimPolicies deny claims with rules written as expressions, one per
+	// line, see the policy package. PolicyTimezone is the time zone of the
+	// hour and weekday of claims, e.g. America/Los_Angeles.
+	ClaimPolicies  string `env:"CLAIM_POLICIES"`
+	PolicyTimezone string `env:"POLICY_TIMEZONE,default=UTC"`
+
+	// GuestTemplate is the template of the editors claimed by guests at
+	// /guest without logging in, guest mode is off when it's empty
+	GuestTemplate string `env:"GUEST_TEMPLATE"`
+	// GuestCode is the code of the event guests are let in for, which the
+	// links to /guest carry as ?code=
+	GuestCode string `env:"GUEST_CODE"`
+	// GuestIPLimit is how many guest editors a client IP claims per hour,
+	// 0 for no limit
+	GuestIPLimit int `env:"GUEST_IP_LIMIT,default=3"`
+
+	ClaimEnvAllow []string `env:"CLAIM_ENV_ALLOW"`
+	ClaimEnvDeny  []string `env:"CLAIM_ENV_DENY"`
+
+	// SecretEnv are set on every claimed editor from a secret backend, e.g.
+	// NPM_TOKEN=vault:secret/data/ci#npm_token or DB_URL=aws:dev/db#url
+	SecretEnv      []string `env:"SECRET_ENV"`
+	VaultAddr      string   `env:"VAULT_ADDR"`
+	VaultToken     string   `env:"VAULT_TOKEN"`
+	VaultNamespace string   `env:"VAULT_NAMESPACE"`
+
+	EditorIPAllowList []string `env:"EDITOR_IP_ALLOWLIST"`
+	EditorEgressDeny  []string `env:"EDITOR_EGRESS_DENY"`
+
+	DockerHost       string `env:"DOCKER_HOST,default=unix:///var/run/docker.sock"`
+	DockerImage      string `env:"DOCKER_IMAGE,default=jingweno/heroku-editor:20"`
+	DockerPublicHost string `env:"DOCKER_PUBLIC_HOST,default=localhost"`
+	DockerNetwork    string `env:"DOCKER_NETWORK"`
+
+	EditorDomain     string `env:"EDITOR_DOMAIN"`
+	TLSPort          string `env:"TLS_PORT,default=443"`
+	ACMEHTTPPort     string `env:"ACME_HTTP_PORT,default=80"`
+	ACMEEmail        string `env:"ACME_EMAIL"`
+	ACMEDirectoryURL string `env:"ACME_DIRECTORY_URL,default=https://acme-v02.api.letsencrypt.org/directory"`
+
+	// VanityDomain serves editors claimed with a vanity name at
+	// #{NAME}.#{VANITY_DOMAIN}, whose CNAME records are kept in the
+	// Cloudflare zone of the domain
+	VanityDomain       string `env:"VANITY_DOMAIN"`
+	CloudflareAPIToken string `env:"CLOUDFLARE_API_TOKEN"`
+	CloudflareZoneID   string `env:"CLOUDFLARE_ZONE_ID"`
+
+	ECSCluster        string   `env:"ECS_CLUSTER"`
+	ECSTaskDefinition string   `env:"ECS_TASK_DEFINITION"`
+	ECSContainerName  string   `env:"ECS_CONTAINER_NAME,default=editor"`
+	ECSSubnets        []string `env:"ECS_SUBNETS"`
+	ECSSecurityGroups []string `env:"ECS_SECURITY_GROUPS"`
+	ECSVPCID          string   `env:"ECS_VPC_ID"`
+	ECSListenerARN    string   `env:"ECS_LISTENER_ARN"`
+	ECSEditorDomain   string   `env:"ECS_EDITOR_DOMAIN"`
+	// ServerURL is the URL editor agents report to, reports are off when
+	// it's empty
+	ServerURL       string `env:"SERVER_URL"`
+	DiskWarnPercent int    `env:"DISK_WARN_PERCENT,default=90"`
+	// ExtensionRegistryURL is an Open VSX registry, e.g.
+	// https://open-vsx.org, that editors install extensions from through
+	// the server at SERVER_URL/extensions/, for networks that block it. Only
+	// the extensions of ExtensionAllowList are allowed, as publisher.name or
+	// publisher.*, when it's set. Downloads are cached in
+	// ExtensionCacheDir up to ExtensionCacheMB.
+	ExtensionRegistryURL string   `env:"EXTENSION_REGISTRY_URL"`
+	ExtensionAllowList   []string `env:"EXTENSION_ALLOWLIST"`
+	ExtensionCacheDir    string   `env:"EXTENSION_CACHE_DIR"`
+	ExtensionCacheMB     int64    `env:"EXTENSION_CACHE_MB,default=1024"`
+	// ResourceWarnPercent is how much of the memory of an editor may be
+	// used before its owner is warned
+	ResourceWarnPercent int `env:"RESOURCE_WARN_PERCENT,default=90"`
+	// SessionExtension is how much users may extend the maximum session
+	// duration of their editor by from inside it, e.g. with cf-agent
+	// extend, up to MaxSessionExtensions times per claim
+	SessionExtension     time.Duration `env:"SESSION_EXTENSION,default=1h"`
+	MaxSessionExtensions int           `env:"MAX_SESSION_EXTENSIONS,default=2"`
+	// MaxSessionDuration is the one of the worker, which the upload URLs
+	// of dependency caches expire with
+	MaxSessionDuration time.Duration `env:"MAX_SESSION_DURATION,default=0s"`
+	// DeleteGracePeriod keeps editors deleted by users stopped this long
+	// before they're purged, so that they can be undeleted
+	DeleteGracePeriod time.Duration `env:"DELETE_GRACE_PERIOD,default=0s"`
+	// StickyClaims keep editors of templates running this long after their
+	// owners delete them, e.g. node=15m, and hand them back if they claim an
+	// editor of the template and repo again
+	StickyClaims []string `env:"STICKY_CLAIMS"`
+	// ResetReleasedEditors wipes editors deleted by users and puts them back
+	// into the pool on providers that can, instead of deleting them
+	ResetReleasedEditors bool `env:"RESET_RELEASED_EDITORS,default=false"`
+	// SingleUseTemplates are never reused, their editors are destroyed once
+	// they're deleted instead of being reset or kept for sticky claims
+	SingleUseTemplates []string `env:"SINGLE_USE_TEMPLATES"`
+	// RouterDrainToken authenticates the router logs of editors drained to
+	// /v1/drains/router, the drain is off when it's empty
+	RouterDrainToken string `env:"ROUTER_DRAIN_TOKEN"`
+
+	// MetricsToken authenticates the scrapes of /metrics, which are off
+	// when it's empty
+	MetricsToken string `env:"METRICS_TOKEN"`
+	// DebugToken authenticates the pprof profiles and the runtime stats
+	// served under /debug/, which are off when it's empty
+	DebugToken string `env:"DEBUG_TOKEN"`
+
+	// RateLimitTokenRate and RateLimitIPRate are the sustained requests per
+	// minute to claim and list endpoints, 0 for no limit.
+	// RateLimitTrustedHops is -1 for 1 on Heroku dynos and 0 elsewhere
+	RateLimitTokenRate   float64 `env:"RATE_LIMIT_TOKEN_RATE,default=30"`
+	RateLimitTokenBurst  int     `env:"RATE_LIMIT_TOKEN_BURST,default=10"`
+	RateLimitIPRate      float64 `env:"RATE_LIMIT_IP_RATE,default=60"`
+	RateLimitIPBurst     int     `env:"RATE_LIMIT_IP_BURST,default=20"`
+	RateLimitTrustedHops int     `env:"RATE_LIMIT_TRUSTED_HOPS,default=-1"`
+	// Dyno is set by Heroku on dynos
+	Dyno string `env:"DYNO"`
+
+	SecureCookies   bool  `env:"SECURE_COOKIES,default=true"`
+	MaxRequestBytes int64 `env:"MAX_REQUEST_BYTES,default=1048576"`
 	// cat /dev/urandom | base64 | head -c 64
 	SessionKey string `env:"SESSION_KEY,required"`
 }
 
+// secrets returns the resolver of SecretEnv, whose backends are vault with
+// VAULT_ADDR set and aws.
+func (c Config) secrets() (*secrets.Resolver, error) {
+	refs, err := secrets.ParseRefs(c.SecretEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := map[string]secrets.Backend{
+		"aws": secrets.NewSecretsManager(c.AWSRegion),
+	}
+	if c.VaultAddr != "" {
+		backends["vault"] = &secrets.Vault{
+			Addr:      c.VaultAddr,
+			Token:     c.VaultToken,
+			Namespace: c.VaultNamespace,
+		}
+	}
+
+	return secrets.NewResolver(refs, backends)
+}
+
+func (c Config) claimHooks() editor.ClaimHooks {
+	hook := func(url string) *editor.Hook {
+		if url == "" {
+			return nil
+		}
+
+		return &editor.Hook{
+			URL:      url,
+			Secret:   c.ClaimHookSecret,
+			Timeout:  c.ClaimHookTimeout,
+			FailOpen: c.ClaimHookFailOpen,
+		}
+	}
+
+	return editor.ClaimHooks{
+		PreClaim:  hook(c.PreClaimHookURL),
+		PostClaim: hook(c.PostClaimHookURL),
+	}
+}
+
+// notifier returns the deliverer of the notifications of users, which
+// delivers them over Slack with SlackBotToken and over email once it's set
+// up.
+func (c Config) notifier(st store.Store, logger log.FieldLogger) (*notify.Deliverer, error) {
+	m, err := mail.New(mail.Config{
+		From:         c.MailFrom,
+		SMTPAddr:     c.SMTPAddr,
+		SMTPUsername: c.SMTPUsername,
+		SMTPPassword: c.SMTPPassword,
+		SESRegion:    c.MailSESRegion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &notify.Deliverer{Store: st, Mail: m, Logger: logger}
+	if c.SlackBotToken != "" {
+		d.Slack = &slack.Client{Token: c.SlackBotToken}
+	}
+
+	return d, nil
+}
+
 func New(cfg Config) *Server {
 	return &Server{
 		cfg:    cfg,
@@ -56,48 +349,416 @@ type Server struct {
 }
 
 func (s *Server) Serve() error {
+	if !s.cfg.SingleProcess && !store.Shared(s.cfg.StoreURL) {
+		return fmt.Errorf("error: %s isn't shared with the worker, please provide a STORE_URL such as postgres://, or set SINGLE_PROCESS=true to run the server alone", s.cfg.StoreURL)
+	}
+
+	st, err := store.OpenEncrypted(s.cfg.StoreURL, s.cfg.StoreEncryptionKeys, s.cfg.AWSRegion, s.cfg.StoreAllowPlaintext)
+	if err != nil {
+		return err
+	}
+
+	// fail early on invalid CIDRs rather than in every editor
+	if _, err := editorproxy.ParseCIDRs(s.cfg.EditorIPAllowList); err != nil {
+		return err
+	}
+	if _, err := editorproxy.ParseCIDRs(s.cfg.EditorEgressDeny); err != nil {
+		return err
+	}
+
+	notifier, err := s.cfg.notifier(st, s.logger)
+	if err != nil {
+		return err
+	}
+
+	policies, err := policy.Parse(s.cfg.ClaimPolicies)
+	if err != nil {
+		return err
+	}
+	policyLocation, err := time.LoadLocation(s.cfg.PolicyTimezone)
+	if err != nil {
+		return fmt.Errorf("error: invalid POLICY_TIMEZONE: %w", err)
+	}
+
+	// the byo provider acts with the tokens of users, which the handlers
+	// keep
+	var users *handlers
+	p, err := provider.New(provider.Config{
+		Provider:     s.cfg.Provider,
+		HerokuAPIKey: s.cfg.HerokuAPIKey,
+		Hooks:        s.cfg.claimHooks(),
+		UserToken: func(ctx context.Context, user string) (string, error) {
+			// the token of a request of the user themselves comes first
+			if acct, ok := ctx.Value(accountKey).(*hkclient.Account); ok && acct.Email == user {
+				if token, ok := ctx.Value(tokenKey).(string); ok && token != "" {
+					return token, nil
+				}
+			}
+			return users.userToken(ctx, user)
+		},
+		Store:            st,
+		TemplateGitURL:   s.cfg.TemplateGitURL,
+		TemplateGitRef:   s.cfg.TemplateGitRef,
+		TemplateGitToken: s.cfg.TemplateGitToken,
+		EnvPolicy: editor.EnvPolicy{
+			Allow: s.cfg.ClaimEnvAllow,
+			Deny:  s.cfg.ClaimEnvDeny,
+		},
+		IPAllowList:       s.cfg.EditorIPAllowList,
+		EgressDeny:        s.cfg.EditorEgressDeny,
+		DockerHost:        s.cfg.DockerHost,
+		DockerImage:       s.cfg.DockerImage,
+		DockerPublicHost:  s.cfg.DockerPublicHost,
+		EditorDomain:      s.cfg.EditorDomain,
+		DockerNetwork:     s.cfg.DockerNetwork,
+		AWSRegion:         s.cfg.AWSRegion,
+		ECSCluster:        s.cfg.ECSCluster,
+		ECSTaskDefinition: s.cfg.ECSTaskDefinition,
+		ECSContainerName:  s.cfg.ECSContainerName,
+		ECSSubnets:        s.cfg.ECSSubnets,
+		ECSSecurityGroups: s.cfg.ECSSecurityGroups,
+		ECSVPCID:          s.cfg.ECSVPCID,
+		ECSListenerARN:    s.cfg.ECSListenerARN,
+		ECSEditorDomain:   s.cfg.ECSEditorDomain,
+	})
+	if err != nil {
+		return err
+	}
+
+	var gw *gateway
+	if s.cfg.EditorDomain != "" {
+		router, ok := p.(provider.Router)
+		if !ok {
+			return fmt.Errorf("error: EDITOR_DOMAIN is not supported by the %s provider", p.Name())
+		}
+
+		if gw, err = newGateway(s.cfg, router, st, s.logger); err != nil {
+			return err
+		}
+	}
+
+	var ghApp *github.App
+	if s.cfg.GitHubAppID != 0 {
+		ghApp, err = github.NewApp(s.cfg.GitHubAppID, []byte(s.cfg.GitHubAppPrivateKey))
+		if err != nil {
+			return err
+		}
+	}
+
+	var cache *s3.Client
+	if s.cfg.CacheS3Bucket != "" {
+		cache = &s3.Client{
+			Bucket:          s.cfg.CacheS3Bucket,
+			Region:          s.cfg.AWSRegion,
+			AccessKeyID:     s.cfg.AWSAccessKeyID,
+			SecretAccessKey: s.cfg.AWSSecretAccessKey,
+		}
+	}
+
+	secretEnv, err := s.cfg.secrets()
+	if err != nil {
+		return err
+	}
+
+	sticky, err := parseStickyClaims(s.cfg.StickyClaims)
+	if err != nil {
+		return err
+	}
+
+	cookies := sessions.NewCookieStore([]byte(s.cfg.SessionKey))
+	cookies.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 30,
+		HttpOnly: true,
+		Secure:   s.cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+
 	h := handlers{
-		herokuAPIKey:   s.cfg.HerokuAPIKey,
-		whitelistUsers: s.cfg.WhitelistUsers,
-		store:          sessions.NewCookieStore([]byte(s.cfg.SessionKey)),
+		githubApp:           ghApp,
+		gateway:             gw,
+		cache:               cache,
+		herokuAPIKey:        s.cfg.HerokuAPIKey,
+		state:               st,
+		whitelistUsers:      s.cfg.WhitelistUsers,
+		adminUsers:          s.cfg.AdminUsers,
+		supportUsers:        s.cfg.SupportUsers,
+		impersonationTTL:    s.cfg.ImpersonationDuration,
+		viewerHost:          strings.ToLower(s.cfg.ViewerHost),
+		viewerLinkTTL:       s.cfg.ViewerLinkDuration,
+		idempotencyTTL:      s.cfg.IdempotencyKeyTTL,
+		idempotencyLocks:    newKeyLocks(),
+		vanityDomain:        strings.ToLower(s.cfg.VanityDomain),
+		vanityLocks:         newKeyLocks(),
+		dns:                 &dns.Cloudflare{Token: s.cfg.CloudflareAPIToken, ZoneID: s.cfg.CloudflareZoneID},
+		provider:            p,
+		serverURL:           s.cfg.ServerURL,
+		diskWarnPercent:     s.cfg.DiskWarnPercent,
+		deleteGrace:         s.cfg.DeleteGracePeriod,
+		sessionExtensionBy:  s.cfg.SessionExtension,
+		maxExtensions:       s.cfg.MaxSessionExtensions,
+		maxSession:          s.cfg.MaxSessionDuration,
+		stickyClaims:        sticky,
+		resetReleased:       s.cfg.ResetReleasedEditors,
+		singleUseTemplates:  s.cfg.SingleUseTemplates,
+		resourceWarnPercent: s.cfg.ResourceWarnPercent,
+		routerDrain:         newRouterDrain(s.cfg.RouterDrainToken, st),
+		metricsToken:        s.cfg.MetricsToken,
+		secrets:             secretEnv,
+		guestTemplate:       s.cfg.GuestTemplate,
+		guestCode:           s.cfg.GuestCode,
+		guestLimiter:        newGuestLimiter(s.cfg.GuestIPLimit),
+		trustedHops:         s.cfg.trustedHops(),
+		batchMaxEditors:     s.cfg.BatchMaxEditors,
+		slackSecret:         s.cfg.SlackSigningSecret,
+		slack:               &slack.Client{Token: s.cfg.SlackBotToken},
+		approvalTemplates:   s.cfg.ApprovalTemplates,
+		approvalDynoSize:    s.cfg.ApprovalDynoSize,
+		approvalChannel:     s.cfg.SlackApprovalChannel,
+		notifier:            notifier,
+		readyEmailAfter:     s.cfg.ReadyEmailAfter,
+		policies:            policies,
+		policyLocation:      policyLocation,
+		store:               cookies,
 		oauthConf: &oauth2.Config{
 			ClientID:     s.cfg.HerokuClientID,
 			ClientSecret: s.cfg.HerokuClientSecret,
-			Scopes:       []string{"identity"},
+			Scopes:       s.cfg.HerokuOAuthScopes,
 			Endpoint:     heroku.Endpoint,
 		},
 		logger: s.logger,
 	}
+	users = &h
+	if provider.Has(p, provider.BYO) && !h.actsAsUsers() {
+		return fmt.Errorf("error: the byo provider requires HEROKU_OAUTH_SCOPES to act as users, e.g. global")
+	}
 
 	r := mux.NewRouter()
 
 	r.Use(mux.CORSMethodMiddleware(r))
+	limiter := newRateLimiter(s.cfg)
+	r.Use(limiter.Middleware)
+	r.Use(middleware.CSRF)
 	r.Use(h.AuthMiddleware)
+	r.Use(limiter.TokenMiddleware)
 
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", httpgzip.FileServer(
 		AssetFile(),
 		httpgzip.FileServerOptions{},
 	)))
 	r.Path("/").Handler(http.FileServer(AssetFile())) // for index.html
+	r.PathPrefix("/dashboard/").Handler(http.StripPrefix("/dashboard/", dashboardHandler()))
+	r.Path("/dashboard").Handler(http.RedirectHandler("/dashboard/", http.StatusMovedPermanently))
 
-	r.Methods("POST").Path("/editor").HandlerFunc(h.HandleEditor)
 	r.Methods("GET").Path("/login").HandlerFunc(h.HandleLogin)
 	r.Methods("GET").Path("/callback").HandlerFunc(h.HandleCallback)
 	r.Methods("GET").Path("/health").HandlerFunc(h.HandleHealth)
+	r.Methods("GET", "POST").Path("/open").HandlerFunc(h.HandleOpen)
+	r.Methods("GET", "POST").Path("/guest").HandlerFunc(h.HandleGuest)
+	r.Methods("GET").Path("/seat").HandlerFunc(h.HandleSeat)
+	r.Methods("GET", "POST").Path("/device").HandlerFunc(h.HandleDevice)
+	r.Methods("POST").Path("/v1/drains/router").HandlerFunc(h.HandleRouterDrain)
+	r.Methods("GET").Path("/metrics").HandlerFunc(h.HandleMetrics)
+
+	h.registerAPI(r)
 
-	http.Handle("/", r)
+	// the handlers are served off their own mux, net/http/pprof and expvar
+	// register themselves on http.DefaultServeMux
+	mux := http.NewServeMux()
+	mux.Handle("/", middleware.Harden(r, middleware.Options{
+		ContentSecurityPolicy: webCSP,
+		FrameOptions:          "DENY",
+		MaxRequestBytes:       s.cfg.MaxRequestBytes,
+	}, s.logger))
+
+	if s.cfg.ApprovalDynoSize != "" && model.DynoSizeRank(s.cfg.ApprovalDynoSize) < 0 {
+		return fmt.Errorf("error: APPROVAL_DYNO_SIZE is none of %s", strings.Join(model.DynoSizes, ", "))
+	}
+	if s.cfg.VanityDomain != "" && (s.cfg.CloudflareAPIToken == "" || s.cfg.CloudflareZoneID == "") {
+		return fmt.Errorf("error: CLOUDFLARE_API_TOKEN and CLOUDFLARE_ZONE_ID are required by VANITY_DOMAIN")
+	}
+	if s.cfg.VanityDomain != "" && !p.Capabilities().CustomDomains {
+		return fmt.Errorf("error: VANITY_DOMAIN is not supported by the %s provider", p.Name())
+	}
+	if s.cfg.ViewerHost != "" && !p.Capabilities().ViewerLinks {
+		return fmt.Errorf("error: VIEWER_HOST is not supported by the %s provider", p.Name())
+	}
+	// editors on the other providers could connect around their proxy
+	if len(s.cfg.EditorEgressDeny) > 0 && !p.Capabilities().EgressDeny {
+		return fmt.Errorf("error: EDITOR_EGRESS_DENY can't be enforced by the %s provider", p.Name())
+	}
+	if s.cfg.MaxSessionExtensions < 0 || (s.cfg.MaxSessionExtensions > 0 && s.cfg.SessionExtension <= 0) {
+		return fmt.Errorf("error: MAX_SESSION_EXTENSIONS can't be negative and needs a positive SESSION_EXTENSION")
+	}
+	if s.cfg.SlackApprovalChannel != "" && s.cfg.SlackBotToken == "" {
+		return fmt.Errorf("error: SLACK_BOT_TOKEN is required by SLACK_APPROVAL_CHANNEL")
+	}
+
+	// Slack signs its requests instead of sending credentials or an
+	// origin, so they skip the middlewares of the router
+	if s.cfg.SlackSigningSecret != "" {
+		if s.cfg.SlackBotToken == "" {
+			return fmt.Errorf("error: SLACK_BOT_TOKEN is required by the Slack app")
+		}
+
+		mux.Handle("/slack/commands", middleware.Harden(http.HandlerFunc(h.HandleSlackCommand), middleware.Options{
+			FrameOptions:    "DENY",
+			MaxRequestBytes: s.cfg.MaxRequestBytes,
+		}, s.logger))
+	}
+
+	// viewers don't log in, the pages of editors are served off their own
+	// host like by the gateway
+	if s.cfg.ViewerHost != "" {
+		if strings.Contains(s.cfg.ViewerHost, "/") || strings.Contains(s.cfg.ViewerHost, ":") {
+			return fmt.Errorf("error: VIEWER_HOST is a host name, e.g. view.codeface.example.com")
+		}
+
+		mux.Handle(h.viewerHost+"/", middleware.Harden(http.HandlerFunc(h.HandleViewer), middleware.Options{
+			FrameOptions:    "SAMEORIGIN",
+			MaxRequestBytes: middleware.EditorMaxRequestBytes,
+		}, s.logger))
+	}
+
+	// editors install extensions without credentials, the registry only
+	// serves public extensions of the allow-list
+	if s.cfg.ExtensionRegistryURL != "" {
+		if s.cfg.ServerURL == "" {
+			return fmt.Errorf("error: SERVER_URL is required by EXTENSION_REGISTRY_URL")
+		}
+
+		dir := s.cfg.ExtensionCacheDir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "codeface-extensions")
+		}
+		cache, err := extensions.NewCache(dir, s.cfg.ExtensionCacheMB<<20)
+		if err != nil {
+			return err
+		}
+		reg, err := extensions.NewRegistry(s.cfg.ExtensionRegistryURL, s.cfg.ServerURL, s.cfg.ExtensionAllowList, cache, s.logger)
+		if err != nil {
+			return err
+		}
+		h.extensionGallery = reg.Gallery()
+
+		mux.Handle(extensions.Prefix, middleware.Harden(reg, middleware.Options{
+			FrameOptions:    "DENY",
+			MaxRequestBytes: s.cfg.MaxRequestBytes,
+		}, s.logger))
+	}
+
+	// probes skip the middlewares, they'd fill the audit log
+	checker := &health.Checker{Store: st, Provider: p}
+	mux.HandleFunc("/healthz", health.HandleHealthz)
+	mux.HandleFunc("/readyz", checker.HandleReadyz)
+
+	if s.cfg.DebugToken != "" {
+		mux.Handle("/debug/", middleware.Harden(metrics.DebugHandler(s.cfg.DebugToken), middleware.Options{
+			FrameOptions:    "DENY",
+			MaxRequestBytes: s.cfg.MaxRequestBytes,
+		}, s.logger))
+	}
 
 	s.logger.Infof("Starting server on %s", s.cfg.Port)
 
-	return http.ListenAndServe(":"+s.cfg.Port, nil)
+	server := middleware.Server(":"+s.cfg.Port, mux)
+	if gw == nil {
+		return server.ListenAndServe()
+	}
+	tlsServer, httpServer := gw.servers(s.cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var g run.Group
+	g.Add(server.ListenAndServe, func(error) {
+		server.Close()
+	})
+	g.Add(func() error {
+		s.logger.Infof("Starting editor gateway on %s for *.%s", s.cfg.TLSPort, s.cfg.EditorDomain)
+		return tlsServer.ListenAndServeTLS("", "")
+	}, func(error) {
+		tlsServer.Close()
+	})
+	g.Add(httpServer.ListenAndServe, func(error) {
+		httpServer.Close()
+	})
+	g.Add(func() error {
+		gw.renewCerts(ctx)
+		return nil
+	}, func(error) {
+		cancel()
+	})
+
+	return g.Run()
 }
 
+const (
+	prebuildUploadExpiry = 15 * time.Minute
+	// depsCacheUploadExpiry is the expiry of the upload URLs of
+	// dependency caches when sessions have no limit
+	depsCacheUploadExpiry = 12 * time.Hour
+	// depsCacheUploadGrace is how long editors have to upload their
+	// dependency cache once their session ends
+	depsCacheUploadGrace = 15 * time.Minute
+)
+
+// webCSP is the content security policy of the web UI. Instantiating the
+// wasm app needs unsafe-eval in browsers without wasm-unsafe-eval.
+const webCSP = "default-src 'self'; script-src 'self' 'wasm-unsafe-eval' 'unsafe-eval'; " +
+	"style-src 'self' https://stackpath.bootstrapcdn.com; img-src 'self' data:; connect-src 'self'; " +
+	"frame-ancestors 'none'; base-uri 'self'; form-action 'self'"
+
 type handlers struct {
-	herokuAPIKey   string
-	whitelistUsers []string
-	store          sessions.Store
-	oauthConf      *oauth2.Config
-	logger         log.FieldLogger
+	herokuAPIKey     string
+	whitelistUsers   []string
+	adminUsers       []string
+	supportUsers     []string
+	impersonationTTL time.Duration
+	viewerHost       string
+	viewerLinkTTL    time.Duration
+	idempotencyTTL   time.Duration
+	idempotencyLocks *keyLocks
+	vanityDomain     string
+	vanityLocks      *keyLocks
+	dns              *dns.Cloudflare
+	// extensionGallery is set on editors that install their extensions
+	// from the extension registry of the server
+	extensionGallery    string
+	provider            provider.Provider
+	serverURL           string
+	diskWarnPercent     int
+	deleteGrace         time.Duration
+	sessionExtensionBy  time.Duration
+	maxExtensions       int
+	maxSession          time.Duration
+	stickyClaims        map[string]time.Duration
+	resetReleased       bool
+	singleUseTemplates  []string
+	resourceWarnPercent int
+	routerDrain         *routerDrain
+	metricsToken        string
+	secrets             *secrets.Resolver
+	guestTemplate       string
+	guestCode           string
+	guestLimiter        *middleware.Limiter
+	trustedHops         int
+	batchMaxEditors     int
+	slackSecret         string
+	slack               *slack.Client
+	approvalTemplates   []string
+	approvalDynoSize    string
+	approvalChannel     string
+	notifier            *notify.Deliverer
+	readyEmailAfter     time.Duration
+	policies            policy.Rules
+	policyLocation      *time.Location
+	githubApp           *github.App
+	gateway             *gateway
+	cache               *s3.Client
+	store               sessions.Store
+	state               store.Store
+	oauthConf           *oauth2.Config
+	logger              log.FieldLogger
 }
 
 func (h *handlers) HandleHome(w http.ResponseWriter, r *http.Request) {
@@ -105,40 +766,686 @@ func (h *handlers) HandleHome(w http.ResponseWriter, r *http.Request) {
 	fmt.Println(acct)
 }
 
+// HandleEditor claims an editor, a retry with the Idempotency-Key of a
+// claim gets the same editor.
 func (h *handlers) HandleEditor(w http.ResponseWriter, r *http.Request) {
+	h.idempotent(w, r, h.handleEditor)
+}
+
+func (h *handlers) handleEditor(w http.ResponseWriter, r *http.Request) {
 	acct := r.Context().Value(accountKey).(*hkclient.Account)
 
 	var opt model.EditorRequest
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&opt); err != nil {
-		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{err.Error()})
+	if !decodeJSON(w, r, &opt) {
 		return
 	}
 
-	fmt.Println(opt.GitRepo)
-	url, err := model.ParseGitHubRepoURL(opt.GitRepo)
+	if c := h.checkClaim(r.Context(), acct, opt); c != nil {
+		if c.ApprovalReason != "" {
+			h.requestApproval(w, r, acct, opt, c.ApprovalReason)
+			return
+		}
+		c.refuse(w, "%s")
+		return
+	}
+
+	ed, status, err := h.claimEditor(r.Context(), acct.Email, opt, "")
 	if err != nil {
-		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{err.Error()})
+		jsonResp(w, status, model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c := editor.NewClaimer(h.herokuAPIKey)
-	app, err := c.Claim(r.Context(), "", acct.Email, url)
+	jsonResp(w, http.StatusCreated, model.EditorResponse{
+		URL:    ed.URL,
+		Labels: opt.Labels,
+	})
+}
+
+// claimEditor claims an editor for a user and starts their session. The
+// editor runs command instead of code-server if it's set. The status is the
+// one to respond with if it fails.
+func (h *handlers) claimEditor(ctx context.Context, user string, opt model.EditorRequest, command string) (*provider.Editor, int, error) {
+	claimOpts := editor.ClaimOptions{
+		Template:  opt.Template,
+		Recipient: user,
+		GitPath:   opt.GitPath,
+		Clone:     opt.Clone,
+		Env:       opt.Env,
+		DynoSize:  opt.DynoSize,
+		Command:   command,
+	}
+
+	var url string
+	if opt.PullRequest != "" {
+		pr, err := h.pullRequest(ctx, opt.PullRequest)
+		if err != nil {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+
+		url = github.RepoURL(pr.Owner, pr.Repo)
+		claimOpts.GitRepo = pr.HeadRepo
+		claimOpts.GitRef = pr.HeadRef
+		claimOpts.GitUpstream = pr.BaseRepo
+	} else if opt.GitRepo != "" {
+		var err error
+		url, err = model.ParseRepoURL(opt.GitRepo, opt.GitAuth)
+		if err != nil {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+
+		claimOpts.GitRepo = url
+		claimOpts.GitRef = opt.GitRef
+		if opt.GitAuth != nil {
+			claimOpts.GitRepo, err = opt.GitAuth.CloneURL(url)
+			if err != nil {
+				return nil, http.StatusUnprocessableEntity, err
+			}
+			claimOpts.GitSSHKey = opt.GitAuth.PrivateKey
+			claimOpts.GitUsername, claimOpts.GitPassword, err = opt.GitAuth.HTTPCredential(url)
+			if err != nil {
+				return nil, http.StatusUnprocessableEntity, err
+			}
+		}
+	}
+
+	// editors claimed with their own environment, dyno size or command
+	// aren't warm ones that were released, nor are claims with labels, whose
+	// session would keep the labels of the previous claim, or with a vanity
+	// name
+	if len(h.stickyClaims) > 0 && len(opt.Env) == 0 && opt.DynoSize == "" && command == "" && len(opt.Labels) == 0 && opt.VanityName == "" {
+		if ed := h.reclaim(ctx, user, opt.Template, url); ed != nil {
+			h.notifyReady(ctx, user, ed, time.Now())
+			return ed, 0, nil
+		}
+	}
+
+	claimOpts.CacheURL = h.cacheURL(ctx, url)
+	h.withDepsCache(&claimOpts)
+	h.withPool(&claimOpts)
+	h.withAgent(&claimOpts)
+	h.withViewer(&claimOpts)
+	claimOpts.ExtensionGallery = h.extensionGallery
+	if err := h.withSecrets(ctx, &claimOpts); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err := h.withUser(ctx, &claimOpts); err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	if opt.VanityName != "" {
+		if status, err := h.holdVanityName(ctx, user, opt.VanityName); err != nil {
+			return nil, status, err
+		}
+	}
+
+	claimedAt := time.Now()
+	ed, err := h.provider.Claim(ctx, claimOpts)
 	if err != nil {
 		h.logger.WithError(err).Info("error: fail to claim an app")
-		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{err.Error()})
+		if opt.VanityName != "" {
+			h.releaseVanityName(ctx, opt.VanityName)
+		}
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	if opt.VanityName != "" {
+		if err := h.serveVanityName(ctx, ed, user, opt.VanityName); err != nil {
+			logger := h.logger.WithFields(log.Fields{"app": ed.Name, "vanity": opt.VanityName})
+			logger.WithError(err).Info("Fail to serve editor at vanity name")
+			if err := provider.Release(ctx, h.provider, ed.Provider, ed.Name, false); err != nil {
+				logger.WithError(err).Info("Fail to release editor")
+			}
+			h.releaseVanityName(ctx, opt.VanityName)
+			return nil, http.StatusBadGateway, err
+		}
+	}
+
+	h.registerAgent(ctx, claimOpts, ed)
+	h.registerViewer(ctx, claimOpts, ed)
+
+	h.startSession(ctx, ed, user, url, opt.Labels, claimedAt)
+	h.notifyReady(ctx, user, ed, claimedAt)
+
+	return ed, 0, nil
+}
+
+// HandleOpen claims an editor for a repo the Codeface GitHub App is
+// installed on and redirects to it, e.g. /open?repo=owner/name&ref=branch.
+// A path opens a subdirectory of the repo, e.g. a package of a monorepo.
+// The user has to be able to push to the repo, and the editor clones it
+// with the tokens of the git credential helper of its agent only. A GET
+// asks the user to confirm, the POST of the confirmation claims.
+func (h *handlers) HandleOpen(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	query := r.URL.Query()
+
+	if h.githubApp == nil {
+		http.Error(w, "GitHub App is not configured", http.StatusNotFound)
 		return
 	}
 
-	jsonResp(w, http.StatusCreated, model.EditorResponse{
-		URL: editor.EditorAppURL(app),
+	owner, name, err := github.ParseRepo(query.Get("repo"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var gitPath string
+	if p := query.Get("path"); p != "" {
+		if gitPath, err = model.CleanGitPath(p); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		confirm(w, r, fmt.Sprintf("Open %s/%s in an editor as %s?", owner, name, acct.Email))
+		return
+	}
+
+	if h.serverURL == "" {
+		http.Error(w, "SERVER_URL is required for the git credentials of editors", http.StatusNotFound)
+		return
+	}
+
+	if err := h.checkPush(r.Context(), acct.Email, github.RepoURL(owner, name)); err != nil {
+		h.logger.WithError(err).WithField("user", acct.Email).Info("Fail to verify push access")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if c := h.checkClaim(r.Context(), acct, model.EditorRequest{GitRepo: github.RepoURL(owner, name)}); c != nil {
+		c.refusePage(w, "%s, please claim the editor with cf claim to request approval")
+		return
+	}
+
+	// the git credential helper of the agent gets tokens on demand, so
+	// that none is kept in the config of the editor
+	claimOpts := editor.ClaimOptions{
+		Recipient: acct.Email,
+		GitRepo:   github.RepoURL(owner, name) + ".git",
+		GitRef:    query.Get("ref"),
+		GitPath:   gitPath,
+		CacheURL:  h.cacheURL(r.Context(), github.RepoURL(owner, name)),
+	}
+	h.withDepsCache(&claimOpts)
+	h.withPool(&claimOpts)
+	h.withAgent(&claimOpts)
+	h.withViewer(&claimOpts)
+
+	if claimOpts.AgentToken == "" {
+		http.Error(w, "fail to generate agent token", http.StatusInternalServerError)
+		return
+	}
+	if err := h.withSecrets(r.Context(), &claimOpts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.withUser(r.Context(), &claimOpts); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	claimedAt := time.Now()
+	ed, err := h.provider.Claim(r.Context(), claimOpts)
+	if err != nil {
+		h.logger.WithError(err).Info("error: fail to claim an app")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	h.registerAgent(r.Context(), claimOpts, ed)
+	h.registerViewer(r.Context(), claimOpts, ed)
+
+	h.startSession(r.Context(), ed, acct.Email, github.RepoURL(owner, name), nil, claimedAt)
+
+	http.Redirect(w, r, ed.URL, http.StatusSeeOther)
+}
+
+// withSecrets looks up the secrets set on every claimed editor.
+func (h *handlers) withSecrets(ctx context.Context, opts *editor.ClaimOptions) error {
+	env, err := h.secrets.Env(ctx)
+	if err != nil {
+		h.logger.WithError(err).Info("Fail to look up secrets")
+		return err
+	}
+
+	opts.Secrets = env
+	return nil
+}
+
+func (h *handlers) pullRequest(ctx context.Context, s string) (*github.PullRequest, error) {
+	owner, repo, number, err := github.ParsePullRequest(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// use an installation token for private repos when the app is configured
+	var token string
+	if h.githubApp != nil {
+		if token, err = h.githubApp.InstallationToken(ctx, owner, repo); err != nil {
+			h.logger.WithError(err).Info("Fail to get installation token, falling back to public access")
+		}
+	}
+
+	return github.GetPullRequest(ctx, owner, repo, number, token)
+}
+
+// HandlePrebuild returns a URL the caller uploads the dependency tarball
+// of a repo to. The caller then completes the prebuild with
+// HandleCompletePrebuild so that claims start to use it. Both take push
+// access to the repo, since every editor of the repo restores the
+// prebuild.
+func (h *handlers) HandlePrebuild(w http.ResponseWriter, r *http.Request) {
+	opt, url, ok := h.decodePrebuild(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	object := prebuild.ObjectName(url, now)
+
+	jsonResp(w, http.StatusCreated, model.PrebuildResponse{
+		Object:    object,
+		UploadURL: h.cache.Presign(http.MethodPut, object, prebuildUploadExpiry, now),
+		ExpiresAt: now.Add(prebuildUploadExpiry),
+	})
+
+	h.logger.WithFields(log.Fields{"repo": url, "commit": opt.Commit}).Info("Started prebuild")
+}
+
+func (h *handlers) HandleCompletePrebuild(w http.ResponseWriter, r *http.Request) {
+	opt, url, ok := h.decodePrebuild(w, r)
+	if !ok {
+		return
+	}
+
+	if !prebuild.ValidObject(url, opt.Object) {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "invalid prebuild object"})
+		return
+	}
+
+	p := prebuild.Prebuild{
+		Repo:      prebuild.NormalizeRepo(url),
+		Commit:    opt.Commit,
+		Object:    opt.Object,
+		CreatedAt: time.Now(),
+	}
+	if err := prebuild.Save(r.Context(), h.state, p); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, p)
+}
+
+func (h *handlers) decodePrebuild(w http.ResponseWriter, r *http.Request) (*model.PrebuildRequest, string, bool) {
+	if h.cache == nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "prebuilds are not configured"})
+		return nil, "", false
+	}
+
+	var opt model.PrebuildRequest
+	if !decodeJSON(w, r, &opt) {
+		return nil, "", false
+	}
+
+	url, err := model.ParseGitHubRepoURL(opt.GitRepo)
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return nil, "", false
+	}
+
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if err := h.checkPush(r.Context(), acct.Email, url); err != nil {
+		h.logger.WithError(err).WithFields(log.Fields{"user": acct.Email, "repo": url}).Info("Fail to verify push access")
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: err.Error()})
+		return nil, "", false
+	}
+
+	return &opt, url, true
+}
+
+// cacheURL returns a download URL of the latest prebuild of a repo, if any.
+func (h *handlers) cacheURL(ctx context.Context, repo string) string {
+	if h.cache == nil || repo == "" {
+		return ""
+	}
+
+	p, err := prebuild.Latest(ctx, h.state, repo)
+	if err != nil {
+		if err != store.ErrNotFound {
+			h.logger.WithError(err).Info("Fail to get prebuild")
+		}
+		return ""
+	}
+
+	return h.cache.Presign(http.MethodGet, p.Object, time.Hour, time.Now())
+}
+
+// withDepsCache hands out the dependency cache of the recipient of a claim
+// for its repo, and an upload URL that expires with the session of the
+// editor. Caches aren't shared between users, since whatever an editor
+// uploads ends up in the editors restoring it.
+func (h *handlers) withDepsCache(opts *editor.ClaimOptions) {
+	if h.cache == nil || opts.GitRepo == "" {
+		return
+	}
+
+	now := time.Now()
+	object := prebuild.DepsCacheObject(opts.GitRepo, opts.Recipient)
+	opts.DepsCacheURL = h.cache.Presign(http.MethodGet, object, time.Hour, now)
+	opts.DepsCacheUploadURL = h.cache.Presign(http.MethodPut, object, h.depsCacheUploadExpiry(), now)
+}
+
+// depsCacheUploadExpiry returns how long a session may last, extensions
+// included, and the time to upload the cache after it.
+func (h *handlers) depsCacheUploadExpiry() time.Duration {
+	if h.maxSession == 0 {
+		return depsCacheUploadExpiry
+	}
+
+	return h.maxSession + time.Duration(h.maxExtensions)*h.sessionExtensionBy + depsCacheUploadGrace
+}
+
+// startSession starts the session of a claimed editor. claimedAt is when
+// the provider was asked for the editor, which its boot time is measured
+// from.
+func (h *handlers) startSession(ctx context.Context, ed *provider.Editor, user, gitRepo string, labels map[string]string, claimedAt time.Time) {
+	tmpl := ed.Template
+	if tmpl == "" {
+		tmpl = editor.DefaultTemplate
+	}
+
+	err := usage.StartSession(ctx, h.state, model.Session{
+		App:       ed.Name,
+		Provider:  ed.Provider,
+		User:      user,
+		Template:  tmpl,
+		GitRepo:   gitRepo,
+		StartedAt: time.Now(),
+		ClaimedAt: claimedAt,
+		Labels:    labels,
 	})
+	if err != nil {
+		h.logger.WithError(err).WithField("app", ed.Name).Info("Fail to start session")
+	}
+}
+
+func (h *handlers) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	query := r.URL.Query()
+
+	labels, err := model.ParseLabelSelector(query["label"])
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	f := usage.Filter{
+		User:     query.Get("user"),
+		Template: query.Get("template"),
+		Labels:   labels,
+	}
+
+	// only admins may look at the usage of other users
+	if !h.isAdmin(acct) {
+		f.User = acct.Email
+	}
+
+	for param, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+
+		d, err := time.Parse(usage.DayFormat, v)
+		if err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("invalid %s date %q", param, v)})
+			return
+		}
+
+		// to is inclusive
+		if param == "to" {
+			d = d.AddDate(0, 0, 1)
+		}
+		*t = d
+	}
+
+	sessions, err := usage.Sessions(r.Context(), h.state)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.UsageResponse{
+		Usage: usage.Aggregate(sessions, f, time.Now()),
+		Boots: usage.BootTimesByTemplate(sessions, f),
+	})
+}
+
+func (h *handlers) isAdmin(acct *hkclient.Account) bool {
+	for _, u := range h.adminUsers {
+		if acct.Email == u {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *handlers) HandleEditorStatus(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	app, err := h.heroku(h.herokuAPIKey).AppInfo(r.Context(), name)
+	if err != nil || app.Owner.Email != acct.Email {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not found"})
+		return
+	}
+
+	status := model.EditorStatus{
+		Name:  app.Name,
+		URL:   editor.EditorAppURL(app),
+		State: model.EditorStateUp,
+	}
+	if err := h.state.Get(r.Context(), editor.StatusKey(app.Name), &status); err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	var ru model.ResourceUsage
+	if err := h.state.Get(r.Context(), agent.ResourcesKey(app.Name), &ru); err == nil {
+		status.Resources = &ru
+	}
+
+	jsonResp(w, http.StatusOK, status)
+}
+
+func (h *handlers) HandlePool(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	if !h.isAdmin(acct) {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only admins may look at the pool"})
+		return
+	}
+
+	currentVersion, otherVersion, err := h.provider.Pool(r.Context())
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	warmKeys, err := h.state.List(r.Context(), editor.WarmEditorKey(""))
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	warm := make(map[string]bool)
+	for _, key := range warmKeys {
+		warm[strings.TrimPrefix(key, editor.WarmEditorKey(""))] = true
+	}
+
+	resp := model.PoolResponse{
+		Provider: h.provider.Name(),
+		Editors:  []model.PoolEditor{},
+	}
+	add := func(eds []provider.Editor, outdated bool) {
+		for _, ed := range eds {
+			resp.Editors = append(resp.Editors, model.PoolEditor{
+				Name:     ed.Name,
+				Provider: ed.Provider,
+				Template: ed.Template,
+				Outdated: outdated,
+				Warm:     warm[ed.Name],
+			})
+		}
+	}
+	add(currentVersion, false)
+	add(otherVersion, true)
+
+	var m model.Maintenance
+	if err := h.state.Get(r.Context(), editor.MaintenanceKey, &m); err == nil {
+		resp.Maintenance = &m
+	}
+
+	var kw model.KeepWarmUsage
+	if err := h.state.Get(r.Context(), editor.KeepWarmUsageKey(time.Now().UTC().Format(usage.DayFormat)), &kw); err == nil {
+		resp.KeepWarm = &kw
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+func (h *handlers) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	labels, err := model.ParseLabelSelector(r.URL.Query()["label"])
+	if err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	sessions, err := usage.OpenSessions(r.Context(), h.state)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resources, err := h.editorResources(r)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := model.SessionsResponse{
+		Sessions:  []model.Session{},
+		Resources: make(map[string]model.ResourceUsage),
+	}
+	for _, s := range sessions {
+		if !model.MatchLabels(s.Labels, labels) {
+			continue
+		}
+
+		// only admins may look at the sessions of other users
+		if h.isAdmin(acct) || s.User == acct.Email {
+			resp.Sessions = append(resp.Sessions, s)
+			if ru, ok := resources[s.App]; ok {
+				resp.Resources[s.App] = ru
+			}
+		}
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleDeleteEditor terminates a claimed editor of the user, or of anyone
+// for admins. Editors of templates with sticky claims are kept running for
+// their owner, and with a delete grace period the editor is only stopped,
+// and purged by the worker once the grace period is over.
+func (h *handlers) HandleDeleteEditor(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	var s model.Session
+	err := h.state.Get(r.Context(), usage.SessionKey(name), &s)
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && s.User != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": name, "user": acct.Email})
+	if err := h.deleteEditor(r.Context(), s, acct.Email, logger); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteEditor deletes the editor of a session for a user. The owner's
+// sticky editors are released, editors are soft deleted with a delete
+// grace period on providers that can stop them, and terminated otherwise.
+func (h *handlers) deleteEditor(ctx context.Context, s model.Session, user string, logger log.FieldLogger) error {
+	if window, ok := h.sticky(s); ok && s.User == user {
+		if err := h.release(ctx, s, window); err != nil {
+			logger.WithError(err).Info("Fail to release editor")
+			return err
+		}
+
+		return nil
+	}
+
+	// released editors deleted again aren't handed back anymore
+	if err := h.state.Delete(ctx, editor.ReleaseKey(s.App)); err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to delete release")
+	}
+
+	if _, ok := provider.Lookup(h.provider, provider.OfSession(s)).(provider.Suspender); ok && h.deleteGrace > 0 {
+		if err := h.softDelete(ctx, s, user); err != nil {
+			logger.WithError(err).Info("Fail to delete editor")
+			return err
+		}
+
+		return nil
+	}
+
+	return h.terminate(ctx, s, logger)
+}
+
+// terminate releases the editor of a session back to its provider and
+// ends the session.
+func (h *handlers) terminate(ctx context.Context, s model.Session, logger log.FieldLogger) error {
+	logger.Info("Terminating editor")
+
+	if err := provider.Release(ctx, h.provider, provider.OfSession(s), s.App, h.resets(s.Template)); err != nil {
+		logger.WithError(err).Info("Fail to terminate editor")
+		return err
+	}
+
+	if _, err := usage.EndSession(ctx, h.state, s.App, time.Now()); err != nil {
+		logger.WithError(err).Info("Fail to end session")
+	}
+
+	if err := h.state.Delete(ctx, editor.SuspensionKey(s.App)); err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to delete suspension")
+	}
+
+	if h.gateway != nil {
+		h.gateway.forget(ctx, s.App)
+	}
+
+	return nil
 }
 
 func (h *handlers) heroku(token string) *hkclient.Service {
 	client := &http.Client{
 		Transport: &hkclient.Transport{
 			BearerToken: token,
+			Transport:   &metrics.Transport{},
 		},
 	}
 
@@ -196,6 +1503,15 @@ func (h *handlers) HandleCallback(w http.ResponseWriter, r *http.Request) {
 
 	session.Values["token"] = tok
 
+	if h.actsAsUsers() {
+		acct, err := editor.Account(r.Context(), h.heroku(tok.AccessToken))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.saveGrant(r.Context(), acct.Email, tok)
+	}
+
 	redirect := "/"
 	if uri := session.Flashes("redirect-uri"); len(uri) > 0 {
 		redirect = uri[0].(string)
@@ -209,6 +1525,10 @@ func (h *handlers) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirect, http.StatusTemporaryRedirect)
 }
 
+func (h *handlers) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	jsonResp(w, http.StatusOK, h.provider.Capabilities())
+}
+
 func (h *handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "hello owen")
 }
@@ -216,11 +1536,37 @@ func (h *handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
 func (h *handlers) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
-		if path == "/login" || path == "/callback" {
+		if path == "/login" || path == "/callback" || path == "/openapi.json" || path == "/guest" || path == "/seat" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// editor agents authenticate with their agent token, log drains with
+		// their drain token and scrapes with the metrics token, in the
+		// handlers. Devices log in before they have a token.
+		if strings.HasPrefix(path, "/v1/agent/") || strings.HasPrefix(path, "/v1/drains/") || strings.HasPrefix(path, "/v1/device/") || path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// API clients such as CI jobs authenticate with a Heroku API token
+		if token := bearerToken(r); token != "" {
+			if strings.HasPrefix(token, impersonationTokenPrefix) {
+				h.serveImpersonation(w, r, next, token)
+				return
+			}
+
+			acct, err := editor.Account(r.Context(), h.heroku(token))
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), tokenKey, token))
+			h.serveAccount(w, r, next, acct)
+			return
+		}
+
 		session, err := h.store.Get(r, "session")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -228,6 +1574,18 @@ func (h *handlers) AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		tok, ok := session.Values["token"].(*oauth2.Token)
+		// refresh expired tokens instead of logging in again
+		if ok && !tok.Valid() && tok.RefreshToken != "" {
+			if fresh, err := h.oauthConf.TokenSource(r.Context(), tok).Token(); err == nil {
+				tok = fresh
+				session.Values["token"] = tok
+				if err := session.Save(r, w); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
 		// Redirect to login when no token in cookies or token expires
 		if !ok || !tok.Valid() {
 			// Store current uri after oauth callback for GET method
@@ -255,22 +1613,39 @@ func (h *handlers) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		allowed := len(h.whitelistUsers) == 0
-		for _, u := range h.whitelistUsers {
-			if strings.Contains(acct.Email, u) {
-				allowed = true
-				break
-			}
-		}
+		r = r.WithContext(context.WithValue(r.Context(), tokenKey, tok.AccessToken))
+		h.serveAccount(w, r, next, acct)
+	})
+}
 
-		if allowed {
-			ctx := context.WithValue(r.Context(), accountKey, acct)
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
-		} else {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+func (h *handlers) serveAccount(w http.ResponseWriter, r *http.Request, next http.Handler, acct *hkclient.Account) {
+	if h.allowed(acct.Email) {
+		ctx := context.WithValue(r.Context(), accountKey, acct)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	} else {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// allowed returns whether a user is on the whitelist, if there is one.
+func (h *handlers) allowed(email string) bool {
+	for _, u := range h.whitelistUsers {
+		if strings.Contains(email, u) {
+			return true
 		}
-	})
+	}
+
+	return len(h.whitelistUsers) == 0
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimPrefix(auth, "Bearer ")
 }
 
 func jsonResp(w http.ResponseWriter, status int, i interface{}) {
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// HandleSettings returns the settings of the user, the defaults if they
+// haven't changed them.
+func (h *handlers) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	s, err := notify.Settings(r.Context(), h.state, acct.Email)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, s)
+}
+
+// HandleUpdateSettings saves the settings of the user. Notifications can
+// only be delivered over Slack and email if the server is set up for them.
+func (h *handlers) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+
+	var s model.UserSettings
+	if !decodeJSON(w, r, &s) {
+		return
+	}
+
+	for _, d := range []string{s.Notifications.IdleWarnings, s.Notifications.RecycleNotices} {
+		if !h.notifier.Available(d) {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("%s notifications aren't set up on the server", d)})
+			return
+		}
+	}
+
+	s, err := notify.SaveSettings(r.Context(), h.state, acct.Email, s)
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{
+		"user":            acct.Email,
+		"idle_warnings":   s.Notifications.IdleWarnings,
+		"recycle_notices": s.Notifications.RecycleNotices,
+	}).Info("Updated user settings")
+
+	jsonResp(w, http.StatusOK, s)
+}
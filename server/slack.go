@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/slack"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const slackUsage = "Usage: `/codeface claim [template] [repo]`, e.g. `/codeface claim go github.com/org/repo`, or `/codeface list`. Admins can `/codeface approvals`, `/codeface approve <id>` and `/codeface deny <id>`."
+
+// HandleSlackCommand handles the /codeface slash command of the Slack app.
+// Slack users are Codeface users by their email address, which has to be
+// the one of their Heroku account on Heroku.
+func (h *handlers) HandleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := slack.Verify(h.slackSecret, r.Header, body, time.Now()); err != nil {
+		h.logger.WithError(err).Info("Fail to verify Slack request")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cmd, err := slack.ParseCommand(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	args := strings.Fields(cmd.Text)
+	if len(args) == 0 {
+		slackResp(w, slackUsage)
+		return
+	}
+
+	switch args[0] {
+	case "claim":
+		tmpl, repo, ok := parseSlackClaim(args[1:])
+		if !ok {
+			slackResp(w, slackUsage)
+			return
+		}
+
+		// Slack gives up on responses after 3 seconds, the editor is sent
+		// once it's claimed
+		go h.slackClaim(context.Background(), *cmd, tmpl, repo)
+		slackResp(w, "Claiming an editor, I'll send you its URL in a moment.")
+	case "list":
+		text, err := h.slackList(r.Context(), *cmd)
+		if err != nil {
+			slackResp(w, err.Error())
+			return
+		}
+		slackResp(w, text)
+	case "approvals":
+		text, err := h.slackApprovals(r.Context(), *cmd)
+		if err != nil {
+			slackResp(w, err.Error())
+			return
+		}
+		slackResp(w, text)
+	case "approve", "deny":
+		if len(args) != 2 {
+			slackResp(w, slackUsage)
+			return
+		}
+
+		text, err := h.slackDecide(r.Context(), *cmd, args[1], args[0] == "approve")
+		if err != nil {
+			slackResp(w, err.Error())
+			return
+		}
+		slackResp(w, text)
+	default:
+		slackResp(w, slackUsage)
+	}
+}
+
+// parseSlackClaim parses the arguments of claim, where the repo is told
+// from the template by its slashes.
+func parseSlackClaim(args []string) (tmpl, repo string, ok bool) {
+	if len(args) > 2 {
+		return "", "", false
+	}
+
+	for _, arg := range args {
+		if !strings.Contains(arg, "/") {
+			if tmpl != "" {
+				return "", "", false
+			}
+			tmpl = arg
+			continue
+		}
+
+		if repo != "" {
+			return "", "", false
+		}
+		repo = arg
+		// Slack wraps links in angle brackets, e.g. <https://github.com/org/repo>
+		repo = strings.TrimSuffix(strings.TrimPrefix(repo, "<"), ">")
+		if i := strings.Index(repo, "|"); i >= 0 {
+			repo = repo[:i]
+		}
+		if !strings.Contains(repo, "://") {
+			repo = "https://" + repo
+		}
+	}
+
+	return tmpl, repo, true
+}
+
+// slackUser returns the email address of the user of a command if they're
+// allowed to use Codeface.
+func (h *handlers) slackUser(ctx context.Context, cmd slack.Command) (string, error) {
+	email, err := h.slack.UserEmail(ctx, cmd.UserID)
+	if err != nil {
+		h.logger.WithError(err).WithField("slack_user", cmd.UserID).Info("Fail to get Slack user")
+		return "", fmt.Errorf("Your email address couldn't be looked up in Slack.")
+	}
+
+	if !h.allowed(email) {
+		return "", fmt.Errorf("%s isn't allowed to use Codeface.", email)
+	}
+
+	return email, nil
+}
+
+func (h *handlers) slackClaim(ctx context.Context, cmd slack.Command, tmpl, repo string) {
+	logger := h.logger.WithField("slack_user", cmd.UserID)
+	reply := func(text string) {
+		if err := slack.Respond(ctx, cmd.ResponseURL, slack.Message{Text: text, ResponseType: "ephemeral"}); err != nil {
+			logger.WithError(err).Info("Fail to respond to Slack command")
+		}
+	}
+
+	email, err := h.slackUser(ctx, cmd)
+	if err != nil {
+		reply(err.Error())
+		return
+	}
+	logger = logger.WithField("user", email)
+
+	var url string
+	if repo != "" {
+		if url, err = model.ParseRepoURL(repo, nil); err != nil {
+			reply(err.Error())
+			return
+		}
+	}
+
+	opt := model.EditorRequest{Template: tmpl, GitRepo: url}
+	if c := h.checkClaim(ctx, &hkclient.Account{Email: email}, opt); c != nil {
+		if c.ApprovalReason == "" {
+			reply("Fail to claim an editor: " + c.Error)
+			return
+		}
+
+		a, err := h.createApproval(ctx, email, cmd.UserID, opt, c.ApprovalReason)
+		if err != nil {
+			reply("Fail to request approval: " + err.Error())
+			return
+		}
+
+		reply(fmt.Sprintf("The claim `%s` is waiting for an admin to approve it, %s. I'll send you the editor once it's approved.", a.ID, c.ApprovalReason))
+		return
+	}
+
+	claimOpts := editor.ClaimOptions{
+		Template:  tmpl,
+		Recipient: email,
+		GitRepo:   url,
+	}
+	claimOpts.CacheURL = h.cacheURL(ctx, url)
+	h.withDepsCache(&claimOpts)
+	h.withPool(&claimOpts)
+	h.withAgent(&claimOpts)
+	h.withViewer(&claimOpts)
+	if err := h.withSecrets(ctx, &claimOpts); err != nil {
+		reply("Fail to claim an editor: " + err.Error())
+		return
+	}
+	if err := h.withUser(ctx, &claimOpts); err != nil {
+		reply("Fail to claim an editor: " + err.Error())
+		return
+	}
+
+	claimedAt := time.Now()
+	ed, err := h.provider.Claim(ctx, claimOpts)
+	if err != nil {
+		logger.WithError(err).Info("error: fail to claim an app")
+		reply("Fail to claim an editor: " + err.Error())
+		return
+	}
+
+	h.registerAgent(ctx, claimOpts, ed)
+	h.registerViewer(ctx, claimOpts, ed)
+
+	h.startSession(ctx, ed, email, url, nil, claimedAt)
+
+	logger.WithFields(log.Fields{"app": ed.Name, "template": ed.Template}).Info("Claimed editor from Slack")
+
+	text := fmt.Sprintf("Your editor %s is ready: %s", ed.Name, ed.URL)
+	if err := h.slack.PostMessage(ctx, slack.Message{Channel: cmd.UserID, Text: text}); err != nil {
+		logger.WithError(err).Info("Fail to send Slack message")
+		reply(text)
+	}
+}
+
+func (h *handlers) slackList(ctx context.Context, cmd slack.Command) (string, error) {
+	email, err := h.slackUser(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	sessions, err := usage.OpenSessions(ctx, h.state)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, s := range sessions {
+		if s.User != email {
+			continue
+		}
+
+		claimedAt := s.ClaimedAt
+		if claimedAt.IsZero() {
+			claimedAt = s.StartedAt
+		}
+
+		line := fmt.Sprintf("• `%s` %s", s.App, s.Template)
+		if s.GitRepo != "" {
+			line += " " + s.GitRepo
+		}
+		lines = append(lines, line+", claimed "+claimedAt.UTC().Format("2006-01-02 15:04 MST"))
+	}
+
+	if len(lines) == 0 {
+		return "You have no running editors.", nil
+	}
+
+	return "Your editors:\n" + strings.Join(lines, "\n"), nil
+}
+
+// slackAdmin returns the email address of the user of a command if
+// they're an admin.
+func (h *handlers) slackAdmin(ctx context.Context, cmd slack.Command) (string, error) {
+	email, err := h.slackUser(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if !h.isAdmin(&hkclient.Account{Email: email}) {
+		return "", fmt.Errorf("Only admins may approve claims.")
+	}
+
+	return email, nil
+}
+
+func (h *handlers) slackApprovals(ctx context.Context, cmd slack.Command) (string, error) {
+	if _, err := h.slackAdmin(ctx, cmd); err != nil {
+		return "", err
+	}
+
+	approvals, err := h.approvals(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, a := range approvals {
+		if a.State == model.ApprovalStatePending {
+			lines = append(lines, fmt.Sprintf("• `%s` %s, %s", a.ID, a.User, describeApproval(a)))
+		}
+	}
+
+	if len(lines) == 0 {
+		return "No claims are waiting for approval.", nil
+	}
+
+	return "Claims waiting for approval:\n" + strings.Join(lines, "\n"), nil
+}
+
+func (h *handlers) slackDecide(ctx context.Context, cmd slack.Command, id string, approve bool) (string, error) {
+	email, err := h.slackAdmin(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	a, err := h.decideApproval(ctx, id, email, approve)
+	if err != nil {
+		return "", err
+	}
+
+	if !approve {
+		return fmt.Sprintf("Denied the claim of %s.", a.User), nil
+	}
+
+	return fmt.Sprintf("Approved the claim of %s, the editor is being claimed.", a.User), nil
+}
+
+func slackResp(w http.ResponseWriter, text string) {
+	jsonResp(w, http.StatusOK, slack.Message{Text: text, ResponseType: "ephemeral"})
+}
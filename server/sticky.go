@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseStickyClaims parses template=duration pairs.
+func parseStickyClaims(pairs []string) (map[string]time.Duration, error) {
+	windows := make(map[string]time.Duration)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("error: invalid sticky claim %q, expected template=duration", pair)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("error: invalid sticky claim %q, expected a positive duration", pair)
+		}
+
+		windows[strings.TrimSpace(kv[0])] = d
+	}
+
+	return windows, nil
+}
+
+// sticky returns the sticky window of the editor of a session, which is
+// only kept running when it's running on a provider that can start it
+// again and its template isn't single-use.
+func (h *handlers) sticky(s model.Session) (time.Duration, bool) {
+	window, ok := h.stickyClaims[s.Template]
+	if !ok || s.EndedAt != nil || h.singleUse(s.Template) {
+		return 0, false
+	}
+
+	if _, ok := provider.Lookup(h.provider, provider.OfSession(s)).(provider.Suspender); !ok {
+		return 0, false
+	}
+
+	return window, true
+}
+
+// release ends the session of an editor deleted by its owner but keeps the
+// editor running for the sticky window of its template. The worker deletes
+// it once the window is over.
+func (h *handlers) release(ctx context.Context, s model.Session, window time.Duration) error {
+	now := time.Now()
+	rel := model.Release{
+		Editor:     s.App,
+		Provider:   provider.OfSession(s),
+		User:       s.User,
+		Template:   s.Template,
+		GitRepo:    s.GitRepo,
+		ReleasedAt: now,
+		ExpiresAt:  now.Add(window),
+	}
+	if err := h.state.Put(ctx, editor.ReleaseKey(s.App), rel); err != nil {
+		return err
+	}
+
+	if _, err := usage.EndSession(ctx, h.state, s.App, now); err != nil {
+		h.logger.WithError(err).WithField("app", s.App).Info("Fail to end session")
+	}
+
+	h.logger.WithFields(log.Fields{"app": s.App, "user": s.User, "expires-at": rel.ExpiresAt}).Info("Released editor")
+
+	return nil
+}
+
+// reclaim hands an editor the user released back to them if it's of the
+// template and repo they claim, or nil if there's none. Editors that fail
+// to be handed back are left to the worker and a new editor is claimed.
+func (h *handlers) reclaim(ctx context.Context, user, template, gitRepo string) *provider.Editor {
+	keys, err := h.state.List(ctx, editor.ReleaseKey(""))
+	if err != nil {
+		h.logger.WithError(err).Info("Fail to list released editors")
+		return nil
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		var rel model.Release
+		if err := h.state.Get(ctx, key, &rel); err != nil {
+			if err != store.ErrNotFound {
+				h.logger.WithError(err).WithField("key", key).Info("Fail to get released editor")
+			}
+			continue
+		}
+
+		if rel.User != user || rel.GitRepo != gitRepo || (template != "" && rel.Template != template) || now.After(rel.ExpiresAt) {
+			continue
+		}
+
+		logger := h.logger.WithFields(log.Fields{"app": rel.Editor, "user": user})
+
+		// releases of providers that went away or can't resume are left to
+		// expire
+		sp, ok := provider.Lookup(h.provider, rel.Provider).(provider.Suspender)
+		if !ok {
+			logger.WithField("provider", rel.Provider).Info("Skip release of provider that can't resume editors")
+			continue
+		}
+
+		if err := h.state.Delete(ctx, key); err != nil {
+			logger.WithError(err).Info("Fail to delete release")
+			continue
+		}
+
+		// the editor is still running, starting it again is a no-op that
+		// returns its URL
+		ed, err := sp.Resume(ctx, rel.Editor, nil)
+		if err != nil {
+			logger.WithError(err).Info("Fail to reclaim released editor")
+			if err := h.state.Put(ctx, key, rel); err != nil {
+				logger.WithError(err).Info("Fail to restore release")
+			}
+			continue
+		}
+
+		if _, err := usage.RestoreSession(ctx, h.state, rel.Editor, now); err != nil {
+			logger.WithError(err).Info("Fail to restore session")
+		}
+
+		logger.Info("Reclaimed released editor")
+
+		return ed
+	}
+
+	return nil
+}
+
+// singleUse returns whether the editors of a template are destroyed once
+// they're deleted, never to be reused.
+func (h *handlers) singleUse(template string) bool {
+	for _, t := range h.singleUseTemplates {
+		if t == template {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resets returns whether deleted editors of a template are reset into the
+// pool instead of being destroyed.
+func (h *handlers) resets(template string) bool {
+	return h.resetReleased && !h.singleUse(template)
+}
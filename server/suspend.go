@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// snapshotTimeout is how long the agent of an editor has to save the
+	// workspace before the suspension is called off
+	snapshotTimeout = 10 * time.Minute
+	snapshotExpiry  = time.Hour
+)
+
+// HandleSuspend stops a claimed editor of the user, or of anyone for
+// admins, without releasing it. Workspaces on disks that don't persist are
+// saved by the agent of the editor first, and the editor is stopped once
+// the snapshot is uploaded.
+func (h *handlers) HandleSuspend(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	s, ok := h.ownedSession(w, r, name, acct)
+	if !ok {
+		return
+	}
+
+	susp, err := h.suspension(r.Context(), name)
+	if err == nil {
+		jsonResp(w, http.StatusOK, susp)
+		return
+	}
+	if err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if s.EndedAt != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "editor is terminated"})
+		return
+	}
+
+	p := provider.Lookup(h.provider, provider.OfSession(*s))
+	if _, ok := p.(provider.Suspender); !ok {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("editors on %s can't be suspended", provider.OfSession(*s))})
+		return
+	}
+
+	now := time.Now()
+	susp = &model.Suspension{
+		Editor:      name,
+		State:       model.SuspensionStateSnapshotting,
+		RequestedAt: now,
+	}
+
+	if p.Capabilities().PersistentDisk {
+		if err := h.suspend(r.Context(), susp); err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		jsonResp(w, http.StatusOK, susp)
+		return
+	}
+
+	if h.cache == nil || h.serverURL == "" {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "saving workspaces of suspended editors needs CACHE_S3_BUCKET and SERVER_URL"})
+		return
+	}
+
+	susp.Snapshot = editor.SnapshotObject(name, now)
+	if err := h.state.Put(r.Context(), editor.SuspensionKey(name), susp); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{"app": name, "user": acct.Email}).Info("Requested workspace snapshot")
+
+	jsonResp(w, http.StatusAccepted, susp)
+}
+
+// HandleResume starts a suspended editor again and restores its workspace
+// if it was saved.
+func (h *handlers) HandleResume(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	s, ok := h.ownedSession(w, r, name, acct)
+	if !ok {
+		return
+	}
+
+	susp, err := h.suspension(r.Context(), name)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor isn't suspended"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if susp.State == model.SuspensionStateReleased {
+		jsonResp(w, http.StatusGone, model.ErrorResponse{Error: "editor was released after its maximum session duration"})
+		return
+	}
+	if susp.State != model.SuspensionStateSuspended {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: "editor is being suspended"})
+		return
+	}
+
+	var del model.Deletion
+	if err := h.state.Get(r.Context(), editor.DeletionKey(name), &del); err == nil {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: "editor is deleted, undelete it first"})
+		return
+	}
+
+	sp, ok := provider.Lookup(h.provider, provider.OfSession(*s)).(provider.Suspender)
+	if !ok {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: fmt.Sprintf("editors on %s can't be resumed", provider.OfSession(*s))})
+		return
+	}
+
+	env := make(map[string]string)
+	if susp.Snapshot != "" && h.cache != nil {
+		env["CF_SNAPSHOT_URL"] = h.cache.Presign(http.MethodGet, susp.Snapshot, snapshotExpiry, time.Now())
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": name, "user": acct.Email})
+	logger.Info("Resuming editor")
+
+	ed, err := sp.Resume(r.Context(), name, env)
+	if err != nil {
+		logger.WithError(err).Info("Fail to resume editor")
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if _, err := usage.ResumeSession(r.Context(), h.state, name, time.Now()); err != nil {
+		logger.WithError(err).Info("Fail to resume session")
+	}
+
+	if err := h.state.Delete(r.Context(), editor.SuspensionKey(name)); err != nil {
+		logger.WithError(err).Info("Fail to delete suspension")
+	}
+
+	jsonResp(w, http.StatusOK, model.EditorResponse{
+		URL: ed.URL,
+	})
+}
+
+// HandleEditorSnapshot returns a download URL of the workspace snapshot of
+// a suspended editor, or of one released after its maximum session
+// duration, or else of the last snapshot its user saved from the editor.
+func (h *handlers) HandleEditorSnapshot(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	if _, ok := h.ownedSession(w, r, name, acct); !ok {
+		return
+	}
+
+	// the snapshot of a suspension is newer than the ones the user saved
+	// before it
+	object := ""
+	susp, err := h.suspension(r.Context(), name)
+	if err == nil && susp.State != model.SuspensionStateSnapshotting {
+		object = susp.Snapshot
+	}
+	var snap model.WorkspaceSnapshot
+	if object == "" && h.state.Get(r.Context(), editor.WorkspaceSnapshotKey(name), &snap) == nil {
+		object = snap.Saved
+	}
+	if object == "" || h.cache == nil {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor has no snapshot"})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.SnapshotResponse{
+		DownloadURL: h.cache.Presign(http.MethodGet, object, snapshotExpiry, time.Now()),
+	})
+}
+
+// HandleAgentSnapshot asks the agent of an editor being suspended to save
+// its workspace.
+func (h *handlers) HandleAgentSnapshot(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var resp model.SnapshotResponse
+	susp, err := h.suspension(r.Context(), name)
+	if err == nil && susp.State == model.SuspensionStateSnapshotting && h.cache != nil {
+		resp.UploadURL = h.cache.Presign(http.MethodPut, susp.Snapshot, snapshotTimeout, time.Now())
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleCompleteSnapshot stops an editor once its workspace is saved.
+func (h *handlers) HandleCompleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	susp, err := h.suspension(r.Context(), name)
+	if err != nil || susp.State != model.SuspensionStateSnapshotting {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor isn't being suspended"})
+		return
+	}
+
+	if err := h.suspend(r.Context(), susp); err != nil {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// suspend stops an editor and ends its session until it's resumed.
+func (h *handlers) suspend(ctx context.Context, susp *model.Suspension) error {
+	logger := h.logger.WithField("app", susp.Editor)
+
+	var s model.Session
+	if err := h.state.Get(ctx, usage.SessionKey(susp.Editor), &s); err != nil {
+		return err
+	}
+
+	sp, ok := provider.Lookup(h.provider, provider.OfSession(s)).(provider.Suspender)
+	if !ok {
+		return fmt.Errorf("error: editors on %s can't be suspended", provider.OfSession(s))
+	}
+
+	logger.Info("Suspending editor")
+	if err := sp.Suspend(ctx, susp.Editor); err != nil {
+		logger.WithError(err).Info("Fail to suspend editor")
+		return err
+	}
+
+	now := time.Now()
+	if _, err := usage.SuspendSession(ctx, h.state, susp.Editor, now); err != nil {
+		logger.WithError(err).Info("Fail to suspend session")
+	}
+
+	susp.State = model.SuspensionStateSuspended
+	susp.SuspendedAt = &now
+
+	return h.state.Put(ctx, editor.SuspensionKey(susp.Editor), susp)
+}
+
+// suspension returns the suspension of an editor. Snapshots that aren't
+// uploaded in time are called off, and the editor keeps running.
+func (h *handlers) suspension(ctx context.Context, name string) (*model.Suspension, error) {
+	var susp model.Suspension
+	if err := h.state.Get(ctx, editor.SuspensionKey(name), &susp); err != nil {
+		return nil, err
+	}
+
+	if susp.State == model.SuspensionStateSnapshotting && time.Since(susp.RequestedAt) > snapshotTimeout {
+		if err := h.state.Delete(ctx, editor.SuspensionKey(name)); err != nil {
+			return nil, err
+		}
+
+		return nil, store.ErrNotFound
+	}
+
+	return &susp, nil
+}
+
+// ownedSession returns the session of an editor of the user, or of anyone
+// for admins.
+func (h *handlers) ownedSession(w http.ResponseWriter, r *http.Request, name string, acct *hkclient.Account) (*model.Session, bool) {
+	var s model.Session
+	err := h.state.Get(r.Context(), usage.SessionKey(name), &s)
+	if err == store.ErrNotFound {
+		// suspended editors of older versions move onto editors of the
+		// current version
+		var m model.Migration
+		if err := h.state.Get(r.Context(), editor.MigrationKey(name), &m); err == nil && (h.isAdmin(acct) || m.User == acct.Email) {
+			jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: fmt.Sprintf("editor was migrated to %s when editors were upgraded", m.To)})
+			return nil, false
+		}
+	}
+	if err == store.ErrNotFound || (err == nil && !h.isAdmin(acct) && s.User != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not found"})
+		return nil, false
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+
+	return &s, true
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const transferExpiry = 24 * time.Hour
+
+func transferKey(name string) string {
+	return "transfers/" + name
+}
+
+// onHeroku reports whether the editor of a session is a Heroku app, which
+// is owned by the Heroku account of the user.
+func onHeroku(s model.Session) bool {
+	return provider.OfSession(s) == provider.Heroku
+}
+
+// HandleTransfer starts the transfer of a claimed editor of the user to
+// another user, who has to accept it.
+func (h *handlers) HandleTransfer(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	var req model.TransferRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	req.Recipient = strings.TrimSpace(req.Recipient)
+	if req.Recipient == "" || req.Recipient == acct.Email {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "recipient must be another user"})
+		return
+	}
+
+	var s model.Session
+	err := h.state.Get(r.Context(), usage.SessionKey(name), &s)
+	if err == store.ErrNotFound || (err == nil && (s.User != acct.Email || s.EndedAt != nil)) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "editor is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	t := model.Transfer{
+		Editor:    name,
+		From:      acct.Email,
+		To:        req.Recipient,
+		CreatedAt: now,
+		ExpiresAt: now.Add(transferExpiry),
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": name, "from": t.From, "to": t.To})
+
+	if onHeroku(s) {
+		silent := true
+		tr, err := h.heroku(requestToken(r)).AppTransferCreate(r.Context(), hkclient.AppTransferCreateOpts{
+			App:       name,
+			Recipient: req.Recipient,
+			Silent:    &silent,
+		})
+		if err != nil {
+			logger.WithError(err).Info("Fail to create app transfer")
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		t.HerokuTransfer = tr.ID
+	}
+
+	if err := h.state.Put(r.Context(), transferKey(name), t); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	logger.Info("Started editor transfer")
+
+	jsonResp(w, http.StatusAccepted, t)
+}
+
+// HandleAcceptTransfer hands an editor over to the recipient of its
+// transfer. The previous owner loses access and the agent token of the
+// editor is rotated.
+func (h *handlers) HandleAcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	t, ok := h.pendingTransfer(w, r, name, acct)
+	if !ok {
+		return
+	}
+
+	if t.To != acct.Email {
+		jsonResp(w, http.StatusForbidden, model.ErrorResponse{Error: "only the recipient may accept the transfer"})
+		return
+	}
+
+	logger := h.logger.WithFields(log.Fields{"app": name, "from": t.From, "to": t.To})
+
+	if t.HerokuTransfer != "" {
+		hk := h.heroku(requestToken(r))
+		if _, err := hk.AppTransferUpdate(r.Context(), t.HerokuTransfer, hkclient.AppTransferUpdateOpts{
+			State: "accepted",
+		}); err != nil {
+			logger.WithError(err).Info("Fail to accept app transfer")
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		if _, err := hk.CollaboratorDelete(r.Context(), name, t.From); err != nil {
+			logger.WithError(err).Info("Fail to remove previous owner")
+		}
+	}
+
+	// the previous owner may have copied the agent token of the editor
+	if err := agent.Rotate(r.Context(), h.state, name, time.Now()); err != nil {
+		logger.WithError(err).Info("Fail to rotate agent token")
+	}
+
+	if _, err := usage.TransferSession(r.Context(), h.state, name, t.To, time.Now()); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.state.Delete(r.Context(), transferKey(name)); err != nil {
+		logger.WithError(err).Info("Fail to delete transfer")
+	}
+
+	logger.Info("Transferred editor")
+
+	jsonResp(w, http.StatusOK, t)
+}
+
+// HandleCancelTransfer cancels a transfer on behalf of the owner, or
+// declines it on behalf of the recipient.
+func (h *handlers) HandleCancelTransfer(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	t, ok := h.pendingTransfer(w, r, name, acct)
+	if !ok {
+		return
+	}
+
+	if t.HerokuTransfer != "" {
+		hk := h.heroku(requestToken(r))
+
+		var err error
+		if acct.Email == t.From {
+			_, err = hk.AppTransferDelete(r.Context(), t.HerokuTransfer)
+		} else {
+			_, err = hk.AppTransferUpdate(r.Context(), t.HerokuTransfer, hkclient.AppTransferUpdateOpts{
+				State: "declined",
+			})
+		}
+		if err != nil {
+			jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	if err := h.state.Delete(r.Context(), transferKey(name)); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingTransfer returns the transfer of an editor if the user is one of
+// its parties.
+func (h *handlers) pendingTransfer(w http.ResponseWriter, r *http.Request, name string, acct *hkclient.Account) (*model.Transfer, bool) {
+	var t model.Transfer
+	err := h.state.Get(r.Context(), transferKey(name), &t)
+	if err == nil && time.Now().After(t.ExpiresAt) {
+		// expired transfers are only cleaned up here, the app transfer of
+		// Heroku is left for the parties to decline
+		if err := h.state.Delete(r.Context(), transferKey(name)); err != nil {
+			h.logger.WithError(err).WithField("app", name).Info("Fail to delete expired transfer")
+		}
+		err = store.ErrNotFound
+	}
+	if err == store.ErrNotFound || (err == nil && t.From != acct.Email && t.To != acct.Email) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "transfer is not found"})
+		return nil, false
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+
+	return &t, true
+}
+
+// HandleAgentToken hands a new token to the agent of an editor whose
+// token was rotated, which it makes the request with. The agent keeps
+// reporting without a restart that way.
+func (h *handlers) HandleAgentToken(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	newToken, err := agent.PickUp(r.Context(), h.state, token, time.Now())
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	jsonResp(w, http.StatusOK, model.AgentTokenResponse{Token: newToken})
+}
+
+func requestToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenKey).(string)
+	return token
+}
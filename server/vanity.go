@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+)
+
+func (h *handlers) vanityHost(name string) string {
+	return name + "." + h.vanityDomain
+}
+
+// holdVanityName takes a vanity name for a claim before the editor is
+// claimed, so that claims of other replicas sharing the store see it's
+// taken. The status is the one to respond with if it's refused.
+func (h *handlers) holdVanityName(ctx context.Context, user, name string) (int, error) {
+	if h.vanityDomain == "" {
+		return http.StatusUnprocessableEntity, fmt.Errorf("vanity names need VANITY_DOMAIN to be set on the server")
+	}
+	if !h.provider.Capabilities().CustomDomains {
+		return http.StatusUnprocessableEntity, fmt.Errorf("the provider doesn't support vanity names")
+	}
+
+	// claims of the server are serialized, other replicas see the name is
+	// held once it's put
+	if !h.vanityLocks.lock(name) {
+		return http.StatusConflict, fmt.Errorf("%s is being claimed, please pick another vanity name", h.vanityHost(name))
+	}
+	defer h.vanityLocks.unlock(name)
+
+	now := time.Now()
+	var prev model.VanityName
+	err := h.state.Get(ctx, editor.VanityKey(name), &prev)
+	if err != nil && err != store.ErrNotFound {
+		return http.StatusInternalServerError, err
+	}
+	if err == nil {
+		inUse, err := editor.VanityInUse(ctx, h.state, prev, now)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if inUse {
+			return http.StatusConflict, fmt.Errorf("%s is taken, please pick another vanity name", h.vanityHost(name))
+		}
+	}
+
+	v := model.VanityName{
+		Name:      name,
+		Host:      h.vanityHost(name),
+		User:      user,
+		CreatedAt: now,
+	}
+	if err := h.state.Put(ctx, editor.VanityKey(name), v); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// releaseVanityName gives up a vanity name held for a claim that failed.
+func (h *handlers) releaseVanityName(ctx context.Context, name string) {
+	if err := h.state.Delete(ctx, editor.VanityKey(name)); err != nil && err != store.ErrNotFound {
+		h.logger.WithError(err).WithField("vanity", name).Info("Fail to release vanity name")
+	}
+}
+
+// serveVanityName serves a claimed editor at the vanity name held for it:
+// the host is added to the editor, its CNAME record points at the editor,
+// and the URL of the editor is the one of the host. The DNS record is
+// deleted by the worker once the session of the editor ends.
+func (h *handlers) serveVanityName(ctx context.Context, ed *provider.Editor, user, name string) error {
+	d, ok := provider.Lookup(h.provider, ed.Provider).(provider.Domainer)
+	if !ok {
+		return fmt.Errorf("error: the %s provider doesn't support vanity names", ed.Provider)
+	}
+
+	host := h.vanityHost(name)
+	target, err := d.AddDomain(ctx, ed.Name, host)
+	if err != nil {
+		return err
+	}
+	if err := h.dns.Upsert(ctx, host, target); err != nil {
+		return err
+	}
+
+	v := model.VanityName{
+		Name:      name,
+		Host:      host,
+		Editor:    ed.Name,
+		Provider:  ed.Provider,
+		User:      user,
+		CreatedAt: time.Now(),
+	}
+	if err := h.state.Put(ctx, editor.VanityKey(name), v); err != nil {
+		return err
+	}
+
+	ed.URL = editor.EditorHostURL(host)
+
+	return nil
+}
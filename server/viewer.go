@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	hkclient "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/editorproxy"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+// viewerAccessKey is the key of how the viewer links of an editor reach
+// it.
+func viewerAccessKey(name string) string {
+	return "viewers/" + name
+}
+
+func viewerLinksPrefix(name string) string {
+	return "viewerlinks/" + name + "/"
+}
+
+func viewerLinkKey(name, id string) string {
+	return viewerLinksPrefix(name) + id
+}
+
+// viewerTokenKey maps the token of a viewer link to the link, only a hash
+// of the token is stored.
+func viewerTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "viewertokens/" + hex.EncodeToString(sum[:])
+}
+
+// viewerAccess is the URL of an editor and the viewer key its proxy serves
+// the read-only code-server with.
+type viewerAccess struct {
+	URL string
+	Key string
+}
+
+type viewerToken struct {
+	Editor string
+	ID     string
+}
+
+// withViewer hands out a viewer key to the editor being claimed, if viewer
+// links are on.
+func (h *handlers) withViewer(opts *editor.ClaimOptions) {
+	if h.viewerHost == "" {
+		return
+	}
+
+	key, err := agent.NewToken()
+	if err != nil {
+		h.logger.WithError(err).Info("Fail to generate viewer key")
+		return
+	}
+
+	opts.ViewerKey = key
+}
+
+func (h *handlers) registerViewer(ctx context.Context, opts editor.ClaimOptions, ed *provider.Editor) {
+	if opts.ViewerKey == "" {
+		return
+	}
+
+	if err := h.state.Put(ctx, viewerAccessKey(ed.Name), viewerAccess{URL: ed.URL, Key: opts.ViewerKey}); err != nil {
+		h.logger.WithError(err).WithField("app", ed.Name).Info("Fail to register viewer key")
+	}
+}
+
+// validViewerLink returns whether a link still opens its editor: it hasn't
+// expired and the session it was created in is still going. Sessions
+// restart when editors are transferred or resumed, which ends their links.
+func validViewerLink(link model.ViewerLink, s model.Session, now time.Time) bool {
+	return now.Before(link.ExpiresAt) && s.EndedAt == nil && !link.CreatedAt.Before(s.StartedAt)
+}
+
+// HandleCreateViewerLink creates a link to watch an editor read-only, e.g.
+// for demos and code walkthroughs, without logging in.
+func (h *handlers) HandleCreateViewerLink(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	if h.viewerHost == "" {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "viewer links need VIEWER_HOST to be set on the server"})
+		return
+	}
+
+	s, ok := h.ownedSession(w, r, name, acct)
+	if !ok {
+		return
+	}
+	if s.EndedAt != nil {
+		jsonResp(w, http.StatusConflict, model.ErrorResponse{Error: "editor is not running"})
+		return
+	}
+
+	var access viewerAccess
+	err := h.state.Get(r.Context(), viewerAccessKey(name), &access)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusUnprocessableEntity, model.ErrorResponse{Error: "editor was claimed before viewer links were turned on"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	now := time.Now()
+	link := model.ViewerLink{
+		ID:        xid.New().String(),
+		Editor:    name,
+		CreatedBy: acct.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.viewerLinkTTL),
+	}
+
+	if err := h.state.Put(r.Context(), viewerLinkKey(name, link.ID), link); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.state.Put(r.Context(), viewerTokenKey(token), viewerToken{Editor: name, ID: link.ID}); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{
+		"app":         name,
+		"viewer_link": link.ID,
+		"user":        acct.Email,
+		"expires_at":  link.ExpiresAt,
+	}).Info("Created viewer link")
+
+	// viewers open the folder the editor opens
+	link.URL = "https://" + h.viewerHost + "/" + token + "/"
+	if u, err := url.Parse(access.URL); err == nil && u.RawQuery != "" {
+		link.URL += "?" + u.RawQuery
+	}
+	jsonResp(w, http.StatusCreated, link)
+}
+
+// HandleViewerLinks lists the viewer links of an editor that still open
+// it, newest first. Links that don't are deleted.
+func (h *handlers) HandleViewerLinks(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	name := mux.Vars(r)["name"]
+
+	s, ok := h.ownedSession(w, r, name, acct)
+	if !ok {
+		return
+	}
+
+	keys, err := h.state.List(r.Context(), viewerLinksPrefix(name))
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	resp := model.ViewerLinksResponse{ViewerLinks: []model.ViewerLink{}}
+	for _, k := range keys {
+		var link model.ViewerLink
+		if err := h.state.Get(r.Context(), k, &link); err != nil {
+			continue
+		}
+
+		if !validViewerLink(link, *s, now) {
+			if err := h.state.Delete(r.Context(), k); err != nil && err != store.ErrNotFound {
+				h.logger.WithError(err).WithField("viewer_link", link.ID).Info("Fail to delete viewer link")
+			}
+			continue
+		}
+		resp.ViewerLinks = append(resp.ViewerLinks, link)
+	}
+
+	sort.Slice(resp.ViewerLinks, func(i, j int) bool {
+		return resp.ViewerLinks[i].CreatedAt.After(resp.ViewerLinks[j].CreatedAt)
+	})
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleRevokeViewerLink ends a viewer link before it expires. Its next
+// requests are refused, which includes the reconnects of open pages.
+func (h *handlers) HandleRevokeViewerLink(w http.ResponseWriter, r *http.Request) {
+	acct := r.Context().Value(accountKey).(*hkclient.Account)
+	vars := mux.Vars(r)
+
+	if _, ok := h.ownedSession(w, r, vars["name"], acct); !ok {
+		return
+	}
+
+	key := viewerLinkKey(vars["name"], vars["id"])
+	var link model.ViewerLink
+	err := h.state.Get(r.Context(), key, &link)
+	if err == store.ErrNotFound {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "viewer link is not found"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.state.Delete(r.Context(), key); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{
+		"app":         link.Editor,
+		"viewer_link": link.ID,
+		"user":        acct.Email,
+	}).Info("Revoked viewer link")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleViewer proxies the requests of viewer links, at
+// VIEWER_HOST/#{TOKEN}/, to the read-only code-server of their editor.
+// Every request is checked against the link, so that revoked and expired
+// links stop opening the editor.
+func (h *handlers) HandleViewer(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/")
+	rest := ""
+	if i := strings.Index(token, "/"); i >= 0 {
+		token, rest = token[:i], token[i+1:]
+	} else if token != "" {
+		// the relative URLs of code-server need the trailing slash
+		u := *r.URL
+		u.Path = "/" + token + "/"
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return
+	}
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var vt viewerToken
+	if err := h.state.Get(r.Context(), viewerTokenKey(token), &vt); err != nil {
+		http.Error(w, "viewer link is not found", http.StatusNotFound)
+		return
+	}
+
+	var link model.ViewerLink
+	if err := h.state.Get(r.Context(), viewerLinkKey(vt.Editor, vt.ID), &link); err != nil {
+		http.Error(w, "viewer link is not found", http.StatusNotFound)
+		return
+	}
+
+	var s model.Session
+	if err := h.state.Get(r.Context(), usage.SessionKey(link.Editor), &s); err != nil || !validViewerLink(link, s, time.Now()) {
+		http.Error(w, "viewer link has expired", http.StatusGone)
+		return
+	}
+
+	var access viewerAccess
+	if err := h.state.Get(r.Context(), viewerAccessKey(link.Editor), &access); err != nil {
+		http.Error(w, "viewer link is not found", http.StatusNotFound)
+		return
+	}
+	target, err := url.Parse(access.URL)
+	if err != nil {
+		http.Error(w, "viewer link is not found", http.StatusNotFound)
+		return
+	}
+
+	host := r.Host
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = "/" + rest
+			req.URL.RawPath = ""
+			req.Host = target.Host
+
+			// code-server checks the origin of websockets against the host
+			// the viewer requested
+			req.Header.Set("X-Forwarded-Host", host)
+			req.Header.Set(editorproxy.ViewerHeader, access.Key)
+			req.Header.Del("Authorization")
+		},
+	}
+
+	// websockets are proxied too, code-server relies on them
+	proxy.ServeHTTP(w, r)
+}
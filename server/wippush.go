@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// HandleAgentWIPPush returns the branch the agent of an editor is asked to
+// push the uncommitted work of its editor to, before the editor is
+// released.
+func (h *handlers) HandleAgentWIPPush(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var resp model.WIPPushResponse
+	var push model.WIPPush
+	err := h.state.Get(r.Context(), editor.WIPPushKey(name), &push)
+	if err != nil && err != store.ErrNotFound {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err == nil && push.State == model.WIPPushStateRequested {
+		resp.Branch = push.Branch
+	}
+
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// HandleCompleteWIPPush records how the agent of an editor pushed its work,
+// which the worker tells the owner about once the editor is released.
+func (h *handlers) HandleCompleteWIPPush(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.agentEditor(r)
+	if !ok {
+		jsonResp(w, http.StatusUnauthorized, model.ErrorResponse{Error: "invalid agent token"})
+		return
+	}
+
+	var result model.WIPPushResult
+	if !decodeJSON(w, r, &result) {
+		return
+	}
+
+	var push model.WIPPush
+	err := h.state.Get(r.Context(), editor.WIPPushKey(name), &push)
+	if err == store.ErrNotFound || (err == nil && push.State != model.WIPPushStateRequested) {
+		jsonResp(w, http.StatusNotFound, model.ErrorResponse{Error: "work is not being pushed"})
+		return
+	}
+	if err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	push.State = result.State
+	push.Reason = result.Reason
+	push.CompletedAt = &now
+	if err := h.state.Put(r.Context(), editor.WIPPushKey(name), push); err != nil {
+		jsonResp(w, http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	h.logger.WithFields(log.Fields{
+		"app":    name,
+		"branch": push.Branch,
+		"state":  push.State,
+		"reason": push.Reason,
+	}).Info("Completed WIP push")
+
+	w.WriteHeader(http.StatusNoContent)
+}
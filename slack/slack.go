@@ -0,0 +1,218 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Slack-Signature"
+	TimestampHeader = "X-Slack-Request-Timestamp"
+
+	apiURL = "https://slack.com/api/"
+	// maxSkew is how old a signed request may be, so that it can't be
+	// replayed later
+	maxSkew = 5 * time.Minute
+)
+
+// Verify checks the signature of a request from Slack, which is an
+// HMAC-SHA256 of its timestamp and body with the signing secret of the
+// app.
+func Verify(secret string, header http.Header, body []byte, now time.Time) error {
+	ts := header.Get(TimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("error: invalid Slack request timestamp %q", ts)
+	}
+
+	if d := now.Sub(time.Unix(sec, 0)); d > maxSkew || d < -maxSkew {
+		return fmt.Errorf("error: Slack request is too old")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(want), []byte(header.Get(SignatureHeader))) {
+		return fmt.Errorf("error: invalid Slack signature")
+	}
+
+	return nil
+}
+
+// Command is a slash command invoked by a Slack user.
+type Command struct {
+	Command     string
+	Text        string
+	UserID      string
+	UserName    string
+	TeamID      string
+	ChannelID   string
+	ResponseURL string
+}
+
+// ParseCommand parses the form Slack posts slash commands as.
+func ParseCommand(body []byte) (*Command, error) {
+	v, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Command{
+		Command:     v.Get("command"),
+		Text:        v.Get("text"),
+		UserID:      v.Get("user_id"),
+		UserName:    v.Get("user_name"),
+		TeamID:      v.Get("team_id"),
+		ChannelID:   v.Get("channel_id"),
+		ResponseURL: v.Get("response_url"),
+	}, nil
+}
+
+// Message is a message to a channel or user, or the response to a
+// command.
+type Message struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+	// ResponseType of responses to commands is ephemeral, shown to the
+	// user only, or in_channel
+	ResponseType string `json:"response_type,omitempty"`
+}
+
+// Respond sends a delayed response to a command to its response URL,
+// which is valid for 30 minutes.
+func Respond(ctx context.Context, responseURL string, msg Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, responseURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error: Slack response status=%d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Client calls the Web API of Slack with the bot token of the app, which
+// needs the chat:write, im:write and users:read.email scopes.
+type Client struct {
+	Token string
+}
+
+// PostMessage posts a message, to a user ID for a direct message.
+func (c *Client) PostMessage(ctx context.Context, msg Message) error {
+	return c.do(ctx, "chat.postMessage", msg, nil)
+}
+
+// UserEmail returns the email address of a user.
+func (c *Client) UserEmail(ctx context.Context, userID string) (string, error) {
+	var resp struct {
+		User struct {
+			Profile struct {
+				Email string `json:"email"`
+			} `json:"profile"`
+		} `json:"user"`
+	}
+	if err := c.do(ctx, "users.info?user="+url.QueryEscape(userID), nil, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.User.Profile.Email == "" {
+		return "", fmt.Errorf("error: Slack user %s has no email address", userID)
+	}
+
+	return resp.User.Profile.Email, nil
+}
+
+// UserIDByEmail returns the ID of the user with an email address, e.g. to
+// send them a direct message.
+func (c *Client) UserIDByEmail(ctx context.Context, email string) (string, error) {
+	var resp struct {
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err := c.do(ctx, "users.lookupByEmail?email="+url.QueryEscape(email), nil, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.User.ID, nil
+}
+
+// do calls a method of the Web API, which answers errors with a 200 and
+// ok set to false.
+func (c *Client) do(ctx context.Context, method string, body, v interface{}) error {
+	httpMethod := http.MethodGet
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		httpMethod, r = http.MethodPost, bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(httpMethod, apiURL+method, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(b, &status); err != nil {
+		return fmt.Errorf("error: Slack API %s status=%d body=%s", method, resp.StatusCode, b)
+	}
+	if !status.OK {
+		return fmt.Errorf("error: Slack API %s: %s", method, status.Error)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNotSealed is returned when a value of an encrypted store isn't
+// encrypted, unless the store allows plaintext.
+var ErrNotSealed = errors.New("error: value isn't encrypted")
+
+// KeyWrapper is a key encryption key, which encrypts the data keys that
+// values are encrypted with.
+type KeyWrapper interface {
+	// ID identifies the key among the keys of a store
+	ID() string
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// sealed is a value encrypted with AES-256-GCM by a data key, which is
+// wrapped by the key encryption key KeyID. The key of the value is the
+// additional data, so sealed values can't be swapped between keys.
+type sealed struct {
+	KeyID   string
+	DataKey []byte
+	Nonce   []byte
+	Value   []byte
+}
+
+type envelope struct {
+	Sealed *sealed
+}
+
+// NewEncrypted returns a store that encrypts the values of st with an
+// envelope scheme. The first key wraps the data keys of new values, and
+// the others are only used to read values written before a rotation.
+// Values written without encryption aren't read unless AllowPlaintext is
+// set, since anyone who can write to st could plant them.
+func NewEncrypted(st Store, keys ...KeyWrapper) (*Encrypted, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("error: no store encryption key is given")
+	}
+
+	e := &Encrypted{
+		Store:    st,
+		keys:     make(map[string]KeyWrapper),
+		primary:  keys[0],
+		dataKeys: make(map[string][]byte),
+	}
+	for _, k := range keys {
+		e.keys[k.ID()] = k
+	}
+
+	return e, nil
+}
+
+type Encrypted struct {
+	Store
+	// AllowPlaintext reads the values written before encryption was turned
+	// on, until Rotate encrypts them
+	AllowPlaintext bool
+
+	keys    map[string]KeyWrapper
+	primary KeyWrapper
+
+	mu sync.Mutex
+	// current is the data key of new values, it's generated once per
+	// process so that the key encryption key isn't called on every write
+	current *sealed
+	// dataKeys caches unwrapped data keys by their wrapped key
+	dataKeys map[string][]byte
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string, v interface{}) error {
+	var raw json.RawMessage
+	if err := e.Store.Get(ctx, key, &raw); err != nil {
+		return err
+	}
+
+	b, err := e.open(ctx, key, raw, e.AllowPlaintext)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (e *Encrypted) Put(ctx context.Context, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s, err := e.seal(ctx, key, b)
+	if err != nil {
+		return err
+	}
+
+	return e.Store.Put(ctx, key, envelope{Sealed: s})
+}
+
+func (e *Encrypted) Create(ctx context.Context, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s, err := e.seal(ctx, key, b)
+	if err != nil {
+		return err
+	}
+
+	return e.Store.Create(ctx, key, envelope{Sealed: s})
+}
+
+// Rotate encrypts every value that isn't encrypted with the first key
+// again, e.g. after a key is added, and returns how many were. Values
+// written before encryption was turned on are encrypted too, whether or
+// not the store allows plaintext. The old key and AllowPlaintext can be
+// removed afterwards.
+func (e *Encrypted) Rotate(ctx context.Context) (int, error) {
+	keys, err := e.Store.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, key := range keys {
+		var raw json.RawMessage
+		if err := e.Store.Get(ctx, key, &raw); err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+
+		// values that aren't objects aren't encrypted
+		var env envelope
+		if json.Unmarshal(raw, &env) == nil && env.Sealed != nil && env.Sealed.KeyID == e.primary.ID() {
+			continue
+		}
+
+		b, err := e.open(ctx, key, raw, true)
+		if err != nil {
+			return n, err
+		}
+		if err := e.Put(ctx, key, json.RawMessage(b)); err != nil {
+			return n, fmt.Errorf("error: fail to write %s: %w", key, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+func (e *Encrypted) seal(ctx context.Context, key string, b []byte) (*sealed, error) {
+	dk, plain, err := e.currentDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &sealed{
+		KeyID:   dk.KeyID,
+		DataKey: dk.DataKey,
+		Nonce:   nonce,
+		Value:   gcm.Seal(nil, nonce, b, []byte(key)),
+	}, nil
+}
+
+func (e *Encrypted) open(ctx context.Context, key string, raw []byte, allowPlaintext bool) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.Sealed == nil {
+		// written before encryption was turned on
+		if !allowPlaintext {
+			return nil, fmt.Errorf("%w: %s, run cf-admin rotate-store-key to encrypt it", ErrNotSealed, key)
+		}
+		return raw, nil
+	}
+
+	dk, err := e.dataKey(ctx, env.Sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := gcm.Open(nil, env.Sealed.Nonce, env.Sealed.Value, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error: fail to decrypt %s: %w", key, err)
+	}
+
+	return b, nil
+}
+
+// currentDataKey returns the wrapped data key of new values and the data
+// key itself.
+func (e *Encrypted) currentDataKey(ctx context.Context) (*sealed, []byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != nil {
+		return e.current, e.dataKeys[string(e.current.DataKey)], nil
+	}
+
+	dk := make([]byte, 32)
+	if _, err := rand.Read(dk); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := e.primary.Wrap(ctx, dk)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error: fail to wrap data key with %s: %w", e.primary.ID(), err)
+	}
+
+	e.dataKeys[string(wrapped)] = dk
+	e.current = &sealed{KeyID: e.primary.ID(), DataKey: wrapped}
+
+	return e.current, dk, nil
+}
+
+func (e *Encrypted) dataKey(ctx context.Context, s *sealed) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if dk, ok := e.dataKeys[string(s.DataKey)]; ok {
+		return dk, nil
+	}
+
+	k := e.keys[s.KeyID]
+	if k == nil {
+		return nil, fmt.Errorf("error: value is encrypted with key %s, which isn't configured", s.KeyID)
+	}
+
+	dk, err := k.Unwrap(ctx, s.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error: fail to unwrap data key with %s: %w", s.KeyID, err)
+	}
+	e.dataKeys[string(s.DataKey)] = dk
+
+	return dk, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NewFile returns a store that keeps one JSON file per key under dir.
+// Writes are atomic so that several processes on the same host can
+// share a directory.
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &File{dir: dir}, nil
+}
+
+type File struct {
+	dir string
+}
+
+func (f *File) path(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(key)+".json")
+}
+
+func (f *File) Get(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (f *File) Put(ctx context.Context, key string, v interface{}) error {
+	return f.write(key, v, os.Rename)
+}
+
+// Create links the file of the value into place, which fails if the file
+// exists.
+func (f *File) Create(ctx context.Context, key string, v interface{}) error {
+	err := f.write(key, v, os.Link)
+	if os.IsExist(err) {
+		return ErrExists
+	}
+
+	return err
+}
+
+// write writes a value to a temporary file and moves it into place with
+// move.
+func (f *File) write(key string, v interface{}, move func(oldpath, newpath string) error) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	p := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	tmpf, err := ioutil.TempFile(filepath.Dir(p), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpf.Name())
+
+	if _, err := tmpf.Write(b); err != nil {
+		tmpf.Close()
+		return err
+	}
+	if err := tmpf.Close(); err != nil {
+		return err
+	}
+
+	return move(tmpf.Name(), p)
+}
+
+func (f *File) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (f *File) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(f.dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || !strings.HasSuffix(file, ".json") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.dir, file)
+		if err != nil {
+			return err
+		}
+
+		key := strings.TrimSuffix(filepath.ToSlash(rel), ".json")
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
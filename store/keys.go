@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jingweno/codeface/aws"
+)
+
+// ParseKeys returns the key encryption keys of specs, which are either
+// file:/path/to/key for a local key or kms:<key ID or ARN> for an AWS KMS
+// key in region.
+func ParseKeys(specs []string, region string) ([]KeyWrapper, error) {
+	var keys []KeyWrapper
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, ":", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("error: invalid store encryption key %q, expected file:<path> or kms:<key>", spec)
+		}
+
+		switch kv[0] {
+		case "file":
+			k, err := NewKeyFile(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		case "kms":
+			keys = append(keys, &KMSKey{
+				KeyID: kv[1],
+				Client: &aws.Client{
+					Service:     "kms",
+					Region:      region,
+					Credentials: aws.NewCredentialsChain(),
+				},
+			})
+		default:
+			return nil, fmt.Errorf("error: unsupported store encryption key %q, expected file:<path> or kms:<key>", spec)
+		}
+	}
+
+	return keys, nil
+}
+
+// KeyFile is a 256-bit key read from a file, either raw or base64 encoded.
+type KeyFile struct {
+	id  string
+	key []byte
+}
+
+func NewKeyFile(path string) (*KeyFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := b
+	if len(key) != 32 {
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("error: key file %s doesn't hold a 32 byte key", path)
+		}
+	}
+
+	// the ID doesn't depend on where the file is, so that it can be moved
+	sum := sha256.Sum256(key)
+	return &KeyFile{id: "file:" + hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+func (k *KeyFile) ID() string {
+	return k.id
+}
+
+func (k *KeyFile) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (k *KeyFile) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("error: wrapped data key is too short")
+	}
+
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+}
+
+// KMSKey is a symmetric AWS KMS key.
+type KMSKey struct {
+	KeyID  string
+	Client *aws.Client
+}
+
+func (k *KMSKey) ID() string {
+	return "kms:" + k.KeyID
+}
+
+func (k *KMSKey) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var out struct {
+		CiphertextBlob []byte
+	}
+	err := k.Client.JSON(ctx, "TrentService.Encrypt", map[string]interface{}{
+		"KeyId":     k.KeyID,
+		"Plaintext": dataKey,
+	}, &out)
+
+	return out.CiphertextBlob, err
+}
+
+func (k *KMSKey) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext []byte
+	}
+	err := k.Client.JSON(ctx, "TrentService.Decrypt", map[string]interface{}{
+		"KeyId":          k.KeyID,
+		"CiphertextBlob": wrapped,
+	}, &out)
+
+	return out.Plaintext, err
+}
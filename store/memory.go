@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		data: make(map[string][]byte),
+	}
+}
+
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func (m *Memory) Get(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	b, ok := m.data[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (m *Memory) Put(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.data[key] = b
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Memory) Create(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[key]; ok {
+		return ErrExists
+	}
+	m.data[key] = b
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	delete(m.data, key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
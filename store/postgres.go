@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	// registers the postgres driver
+	_ "github.com/lib/pq"
+)
+
+// NewPostgres returns a store that keeps its values in a table of a
+// Postgres database, e.g. Heroku Postgres, so that the server and the
+// worker can share it across hosts. The table is created if it doesn't
+// exist.
+func NewPostgres(rawurl string) (*Postgres, error) {
+	db, err := sql.Open("postgres", rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS codeface_store (key text PRIMARY KEY, value bytea NOT NULL)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Postgres{db: db}, nil
+}
+
+type Postgres struct {
+	db *sql.DB
+}
+
+func (p *Postgres) Get(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	var b []byte
+	err := p.db.QueryRowContext(ctx, `SELECT value FROM codeface_store WHERE key = $1`, key).Scan(&b)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (p *Postgres) Put(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.ExecContext(ctx, `INSERT INTO codeface_store (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`, key, b)
+	return err
+}
+
+// Create inserts the value unless the key exists, which the primary key
+// makes atomic across every process of the database.
+func (p *Postgres) Create(ctx context.Context, key string, v interface{}) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.db.ExecContext(ctx, `INSERT INTO codeface_store (key, value) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING`, key, b)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrExists
+	}
+
+	return nil
+}
+
+func (p *Postgres) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	_, err := p.db.ExecContext(ctx, `DELETE FROM codeface_store WHERE key = $1`, key)
+	return err
+}
+
+// List sorts the keys bytewise, the way the other stores do, rather than
+// by the collation of the database.
+func (p *Postgres) List(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT key FROM codeface_store WHERE key LIKE $1 ESCAPE '\' ORDER BY key COLLATE "C"`, likePrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// likePrefix returns a LIKE pattern that matches the strings starting with
+// prefix. Keys often have underscores, which LIKE would match any
+// character with.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStores returns every backend, along with a prefix that the keys of
+// a test go under. Postgres is only tested with STORE_TEST_POSTGRES_URL,
+// whose table the keys are removed from afterwards.
+func testStores(t *testing.T) map[string]Store {
+	stores := map[string]Store{"memory": NewMemory()}
+
+	f, err := NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["file"] = f
+
+	if u := os.Getenv("STORE_TEST_POSTGRES_URL"); u != "" {
+		p, err := NewPostgres(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores["postgres"] = p
+	}
+
+	return stores
+}
+
+func testPrefix(t *testing.T, st Store) string {
+	prefix := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+	t.Cleanup(func() {
+		keys, _ := st.List(context.Background(), prefix)
+		for _, k := range keys {
+			st.Delete(context.Background(), k)
+		}
+	})
+
+	return prefix
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			p := testPrefix(t, st)
+
+			var v map[string]string
+			if err := st.Get(ctx, p+"missing", &v); err != ErrNotFound {
+				t.Errorf("Get of a missing key = %v, want ErrNotFound", err)
+			}
+			if err := st.Delete(ctx, p+"missing"); err != nil {
+				t.Errorf("Delete of a missing key = %s", err)
+			}
+
+			for _, k := range []string{"b", "a_1", "a/2", "ab", "a%"} {
+				if err := st.Put(ctx, p+k, map[string]string{"key": k}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := st.Put(ctx, p+"b", map[string]string{"key": "b2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Get(ctx, p+"b", &v); err != nil || v["key"] != "b2" {
+				t.Errorf("Get = %v, %v, want the last Put", v, err)
+			}
+
+			// _ and % only match themselves
+			keys, err := st.List(ctx, p+"a_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{p + "a_1"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("List = %v, want %v", keys, want)
+			}
+			keys, err = st.List(ctx, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{p + "a%", p + "a/2", p + "a_1", p + "ab", p + "b"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("List = %v, want %v", keys, want)
+			}
+
+			if err := st.Delete(ctx, p+"b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Get(ctx, p+"b", &v); err != ErrNotFound {
+				t.Errorf("Get of a deleted key = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+
+	for name, st := range testStores(t) {
+		for _, k := range []string{"", "/editors/a", "editors/../a"} {
+			t.Run(name+"/"+k, func(t *testing.T) {
+				var v string
+				if err := st.Get(ctx, k, &v); err == nil || err == ErrNotFound {
+					t.Errorf("Get = %v, want an invalid key", err)
+				}
+				if err := st.Put(ctx, k, "v"); err == nil {
+					t.Errorf("Put succeeded, want an invalid key")
+				}
+				if err := st.Create(ctx, k, "v"); err == nil {
+					t.Errorf("Create succeeded, want an invalid key")
+				}
+				if err := st.Delete(ctx, k); err == nil {
+					t.Errorf("Delete succeeded, want an invalid key")
+				}
+			})
+		}
+	}
+}
+
+func TestShared(t *testing.T) {
+	cases := []struct {
+		url    string
+		shared bool
+	}{
+		{"mem://", false},
+		{"", false},
+		{"file:///var/lib/codeface", true},
+		{"postgres://u:p@db.example.com:5432/codeface", true},
+	}
+
+	for _, c := range cases {
+		if got := Shared(c.url); got != c.shared {
+			t.Errorf("Shared(%q) = %t, want %t", c.url, got, c.shared)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			p := testPrefix(t, st)
+
+			// of the concurrent creates of a key, exactly one wins, and the
+			// value is the one of the winner
+			const n = 20
+			errs := make([]error, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = st.Create(ctx, p+"lock", i)
+				}(i)
+			}
+			wg.Wait()
+
+			winner := -1
+			for i, err := range errs {
+				switch {
+				case err == nil && winner >= 0:
+					t.Fatalf("creates %d and %d both won", winner, i)
+				case err == nil:
+					winner = i
+				case err != ErrExists:
+					t.Fatalf("Create = %s", err)
+				}
+			}
+			if winner < 0 {
+				t.Fatalf("no create won")
+			}
+
+			var v int
+			if err := st.Get(ctx, p+"lock", &v); err != nil || v != winner {
+				t.Errorf("Get = %d, %v, want the value of the winner %d", v, err, winner)
+			}
+
+			if err := st.Delete(ctx, p+"lock"); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Create(ctx, p+"lock", 1); err != nil {
+				t.Errorf("Create of a deleted key = %s", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedRejectsPlaintext(t *testing.T) {
+	ctx := context.Background()
+
+	path := t.TempDir() + "/store.key"
+	if err := ioutil.WriteFile(path, make([]byte, 32), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := NewMemory()
+	if err := mem.Put(ctx, "plain", "planted"); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewEncrypted(mem, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Put(ctx, "sealed", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	var v string
+	if err := enc.Get(ctx, "plain", &v); !errors.Is(err, ErrNotSealed) {
+		t.Errorf("Get of a plaintext value = %v, want ErrNotSealed", err)
+	}
+
+	enc.AllowPlaintext = true
+	if err := enc.Get(ctx, "plain", &v); err != nil || v != "planted" {
+		t.Errorf("Get of a plaintext value allowing plaintext = %q, %v", v, err)
+	}
+	enc.AllowPlaintext = false
+
+	n, err := enc.Rotate(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("Rotate = %d, %v, want the plaintext value encrypted", n, err)
+	}
+
+	for key, want := range map[string]string{"plain": "planted", "sealed": "secret"} {
+		if err := enc.Get(ctx, key, &v); err != nil || v != want {
+			t.Errorf("Get(%s) after Rotate = %q, %v, want %q", key, v, err, want)
+		}
+	}
+}
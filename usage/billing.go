@@ -0,0 +1,23 @@
+package usage
+
+import (
+	"fmt"
+
+	"github.com/jingweno/codeface/model"
+)
+
+// PendingBillingPrefix is the prefix of the ended sessions whose billing
+// event the worker hasn't sent yet.
+const PendingBillingPrefix = "billing/pending/"
+
+// BillingID tells sessions apart by when they started, since an editor
+// restarts its session e.g. when it's transferred.
+func BillingID(s *model.Session) string {
+	return fmt.Sprintf("%s.%d", s.App, s.StartedAt.Unix())
+}
+
+// PendingBillingKey is the key of the billing event of a session that the
+// worker hasn't sent yet.
+func PendingBillingKey(s *model.Session) string {
+	return PendingBillingPrefix + BillingID(s)
+}
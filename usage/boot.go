@@ -0,0 +1,81 @@
+package usage
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+)
+
+// BootTime returns how long the editor of a session took from its claim to
+// serving. It's not known for editors whose agent didn't report it, and
+// sessions restarted after the boot, e.g. of a resumed editor, don't count
+// it again.
+func BootTime(s model.Session) (time.Duration, bool) {
+	if s.ReadyAt == nil || s.StartedAt.After(*s.ReadyAt) {
+		return 0, false
+	}
+
+	return s.ReadyAt.Sub(s.ClaimedAt), true
+}
+
+// Percentile returns the p-th percentile of ds by nearest rank, 0 if
+// there are none. It sorts ds.
+func Percentile(ds []time.Duration, p int) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(ds))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return ds[rank-1]
+}
+
+// Boots returns the boot times of the sessions matching f that were
+// claimed within its range.
+func Boots(sessions []model.Session, f Filter) []time.Duration {
+	var ds []time.Duration
+	for _, s := range sessions {
+		if !f.match(s) || !f.inRange(s.ClaimedAt) {
+			continue
+		}
+
+		if d, ok := BootTime(s); ok {
+			ds = append(ds, d)
+		}
+	}
+
+	return ds
+}
+
+// BootTimesByTemplate summarizes the boot times of the sessions matching f
+// by template.
+func BootTimesByTemplate(sessions []model.Session, f Filter) []model.BootTimes {
+	byTemplate := make(map[string][]model.Session)
+	for _, s := range sessions {
+		byTemplate[s.Template] = append(byTemplate[s.Template], s)
+	}
+
+	result := []model.BootTimes{}
+	for tmpl, ss := range byTemplate {
+		ds := Boots(ss, f)
+		if len(ds) == 0 {
+			continue
+		}
+
+		result = append(result, model.BootTimes{
+			Template: tmpl,
+			Boots:    len(ds),
+			P50:      Percentile(ds, 50),
+			P95:      Percentile(ds, 95),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Template < result[j].Template })
+
+	return result
+}
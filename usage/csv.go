@@ -0,0 +1,69 @@
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+)
+
+func WriteCSV(w io.Writer, rows []model.Usage) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"day", "user", "template", "editor_hours", "claims", "avg_session_hours"}); err != nil {
+		return err
+	}
+
+	for _, u := range rows {
+		err := cw.Write([]string{
+			u.Day,
+			u.User,
+			u.Template,
+			strconv.FormatFloat(u.EditorHours, 'f', 4, 64),
+			strconv.Itoa(u.Claims),
+			strconv.FormatFloat(u.AvgSessionHours, 'f', 4, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteSessionsCSV writes sessions one per row with the labels of their
+// claim, which the aggregates of WriteCSV leave out. Sessions that haven't
+// ended have no end and are counted up to now.
+func WriteSessionsCSV(w io.Writer, sessions []model.Session, now time.Time) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"editor", "user", "template", "started_at", "ended_at", "editor_hours", "labels"}); err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		end := now
+		var ended string
+		if s.EndedAt != nil {
+			end = *s.EndedAt
+			ended = s.EndedAt.UTC().Format(time.RFC3339)
+		}
+
+		err := cw.Write([]string{
+			s.App,
+			s.User,
+			s.Template,
+			s.StartedAt.UTC().Format(time.RFC3339),
+			ended,
+			strconv.FormatFloat(end.Sub(s.StartedAt).Hours(), 'f', 4, 64),
+			model.FormatLabels(s.Labels),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,296 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+const (
+	DayFormat = "2006-01-02"
+
+	sessionPrefix = "sessions/"
+)
+
+func SessionKey(appName string) string {
+	return sessionPrefix + appName
+}
+
+func StartSession(ctx context.Context, st store.Store, s model.Session) error {
+	if s.ClaimedAt.IsZero() {
+		s.ClaimedAt = s.StartedAt
+	}
+
+	return st.Put(ctx, SessionKey(s.App), s)
+}
+
+// EndSession ends the session of an editor. Every session that ends is
+// queued under PendingBillingKey before it does, whichever of the server
+// and the worker ends it, so that the worker sends its billing event even
+// if the process ending it fails right after.
+func EndSession(ctx context.Context, st store.Store, appName string, at time.Time) (*model.Session, error) {
+	var s model.Session
+	if err := st.Get(ctx, SessionKey(appName), &s); err != nil {
+		return nil, err
+	}
+
+	if s.EndedAt != nil {
+		return &s, nil
+	}
+
+	s.EndedAt = &at
+	if err := st.Put(ctx, PendingBillingKey(&s), s); err != nil {
+		return nil, err
+	}
+
+	return &s, st.Put(ctx, SessionKey(appName), s)
+}
+
+func Sessions(ctx context.Context, st store.Store) ([]model.Session, error) {
+	keys, err := st.List(ctx, sessionPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []model.Session
+	for _, key := range keys {
+		var s model.Session
+		if err := st.Get(ctx, key, &s); err != nil {
+			if err == store.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, nil
+}
+
+func OpenSessions(ctx context.Context, st store.Store) ([]model.Session, error) {
+	sessions, err := Sessions(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+
+	var open []model.Session
+	for _, s := range sessions {
+		if s.EndedAt == nil {
+			open = append(open, s)
+		}
+	}
+
+	return open, nil
+}
+
+// Filter narrows down the aggregated usage. From is inclusive and To is
+// exclusive.
+type Filter struct {
+	User     string
+	Template string
+	From     time.Time
+	To       time.Time
+	// Labels only keeps the sessions of claims with all of them
+	Labels map[string]string
+}
+
+func (f Filter) match(s model.Session) bool {
+	if f.User != "" && f.User != s.User {
+		return false
+	}
+	if f.Template != "" && f.Template != s.Template {
+		return false
+	}
+
+	return model.MatchLabels(s.Labels, f.Labels)
+}
+
+func (f Filter) inRange(t time.Time) bool {
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false
+	}
+
+	return true
+}
+
+// Aggregate groups sessions by user, template and UTC day. Editor hours
+// of a session spanning midnight are split across the days, while claims
+// and the average session length are counted on the day a session starts.
+// Sessions that haven't ended are counted up to now.
+func Aggregate(sessions []model.Session, f Filter, now time.Time) []model.Usage {
+	type group struct {
+		usage model.Usage
+		total time.Duration
+	}
+
+	groups := make(map[[3]string]*group)
+	get := func(s model.Session, day string) *group {
+		k := [3]string{s.User, s.Template, day}
+		g, ok := groups[k]
+		if !ok {
+			g = &group{usage: model.Usage{User: s.User, Template: s.Template, Day: day}}
+			groups[k] = g
+		}
+		return g
+	}
+
+	for _, s := range sessions {
+		if !f.match(s) {
+			continue
+		}
+
+		start := s.StartedAt.UTC()
+		end := now.UTC()
+		if s.EndedAt != nil {
+			end = s.EndedAt.UTC()
+		}
+
+		if f.inRange(start) {
+			g := get(s, start.Format(DayFormat))
+			g.usage.Claims++
+			g.total += end.Sub(start)
+		}
+
+		for t := start; t.Before(end); {
+			day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			next := day.AddDate(0, 0, 1)
+			if next.After(end) {
+				next = end
+			}
+
+			if f.inRange(day) {
+				g := get(s, day.Format(DayFormat))
+				g.usage.EditorHours += next.Sub(t).Hours()
+			}
+
+			t = next
+		}
+	}
+
+	result := make([]model.Usage, 0, len(groups))
+	for _, g := range groups {
+		if g.usage.Claims > 0 {
+			g.usage.AvgSessionHours = g.total.Hours() / float64(g.usage.Claims)
+		}
+		result = append(result, g.usage)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		return a.Template < b.Template
+	})
+
+	return result
+}
+
+// TransferSession ends the session of an editor and starts one for the
+// user it's transferred to.
+func TransferSession(ctx context.Context, st store.Store, appName, to string, at time.Time) (*model.Session, error) {
+	ended, err := EndSession(ctx, st, appName, at)
+	if err != nil {
+		return nil, err
+	}
+
+	s := *ended
+	s.User = to
+	return restartSession(ctx, st, *ended, s, at)
+}
+
+// SuspendSession ends the session of an editor that is suspended, so that
+// it's not counted until the editor is resumed.
+func SuspendSession(ctx context.Context, st store.Store, appName string, at time.Time) (*model.Session, error) {
+	s, err := EndSession(ctx, st, appName, at)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Suspended = true
+	return s, st.Put(ctx, SessionKey(appName), s)
+}
+
+// ReleaseSession ends the session of an editor that is released. Suspended
+// editors ended their session when they were suspended, and they aren't
+// suspended anymore once they're released.
+func ReleaseSession(ctx context.Context, st store.Store, appName string, at time.Time) (*model.Session, error) {
+	s, err := EndSession(ctx, st, appName, at)
+	if err != nil || !s.Suspended {
+		return s, err
+	}
+
+	s.Suspended = false
+	return s, st.Put(ctx, SessionKey(appName), s)
+}
+
+// ResumeSession starts a session for a suspended editor.
+func ResumeSession(ctx context.Context, st store.Store, appName string, at time.Time) (*model.Session, error) {
+	var ended model.Session
+	if err := st.Get(ctx, SessionKey(appName), &ended); err != nil {
+		return nil, err
+	}
+
+	if !ended.Suspended {
+		return nil, fmt.Errorf("error: session of %s isn't suspended", appName)
+	}
+
+	s := ended
+	s.Suspended = false
+	return restartSession(ctx, st, ended, s, at)
+}
+
+// RestoreSession starts a session for an undeleted editor.
+func RestoreSession(ctx context.Context, st store.Store, appName string, at time.Time) (*model.Session, error) {
+	var ended model.Session
+	if err := st.Get(ctx, SessionKey(appName), &ended); err != nil {
+		return nil, err
+	}
+
+	if ended.EndedAt == nil {
+		return &ended, nil
+	}
+
+	return restartSession(ctx, st, ended, ended, at)
+}
+
+// MoveSession moves the session of an editor onto another editor, which
+// takes its place, e.g. when a suspended editor is migrated.
+func MoveSession(ctx context.Context, st store.Store, appName, to string) (*model.Session, error) {
+	var s model.Session
+	if err := st.Get(ctx, SessionKey(appName), &s); err != nil {
+		return nil, err
+	}
+
+	s.App = to
+	if err := st.Put(ctx, SessionKey(to), s); err != nil {
+		return nil, err
+	}
+
+	return &s, st.Delete(ctx, SessionKey(appName))
+}
+
+// restartSession starts s in place of the ended session of the same
+// editor. The ended session is kept under its own key so that it's still
+// counted in the usage.
+func restartSession(ctx context.Context, st store.Store, ended, s model.Session, at time.Time) (*model.Session, error) {
+	ended.Suspended = false
+	if err := st.Put(ctx, fmt.Sprintf("%s.%d", SessionKey(ended.App), ended.StartedAt.Unix()), ended); err != nil {
+		return nil, err
+	}
+
+	s.StartedAt = at
+	s.EndedAt = nil
+
+	return &s, StartSession(ctx, st, s)
+}
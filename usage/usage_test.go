@@ -0,0 +1,192 @@
+package usage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+func TestAggregate(t *testing.T) {
+	day := func(d, h int) time.Time {
+		return time.Date(2021, 6, d, h, 0, 0, 0, time.UTC)
+	}
+	ended := func(d, h int) *time.Time {
+		t := day(d, h)
+		return &t
+	}
+
+	sessions := []model.Session{
+		{User: "a", Template: "go", StartedAt: day(7, 10), EndedAt: ended(7, 12)},
+		{User: "a", Template: "go", StartedAt: day(7, 14), EndedAt: ended(7, 18)},
+		// spans midnight
+		{User: "b", Template: "node", StartedAt: day(7, 22), EndedAt: ended(8, 4), Labels: map[string]string{"ticket": "OPS-1"}},
+		// hasn't ended, counted up to now
+		{User: "a", Template: "node", StartedAt: day(8, 9)},
+	}
+	now := day(8, 12)
+
+	cases := []struct {
+		name   string
+		filter Filter
+		want   []model.Usage
+	}{
+		{
+			name: "all",
+			want: []model.Usage{
+				{User: "a", Template: "go", Day: "2021-06-07", Claims: 2, EditorHours: 6, AvgSessionHours: 3},
+				{User: "b", Template: "node", Day: "2021-06-07", Claims: 1, EditorHours: 2, AvgSessionHours: 6},
+				{User: "a", Template: "node", Day: "2021-06-08", Claims: 1, EditorHours: 3, AvgSessionHours: 3},
+				{User: "b", Template: "node", Day: "2021-06-08", EditorHours: 4},
+			},
+		},
+		{
+			name:   "day",
+			filter: Filter{From: day(8, 0), To: day(9, 0)},
+			want: []model.Usage{
+				{User: "a", Template: "node", Day: "2021-06-08", Claims: 1, EditorHours: 3, AvgSessionHours: 3},
+				{User: "b", Template: "node", Day: "2021-06-08", EditorHours: 4},
+			},
+		},
+		{
+			name:   "user and template",
+			filter: Filter{User: "a", Template: "go"},
+			want: []model.Usage{
+				{User: "a", Template: "go", Day: "2021-06-07", Claims: 2, EditorHours: 6, AvgSessionHours: 3},
+			},
+		},
+		{
+			name:   "labels",
+			filter: Filter{Labels: map[string]string{"ticket": "OPS-1"}},
+			want: []model.Usage{
+				{User: "b", Template: "node", Day: "2021-06-07", Claims: 1, EditorHours: 2, AvgSessionHours: 6},
+				{User: "b", Template: "node", Day: "2021-06-08", EditorHours: 4},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Aggregate(sessions, c.filter, now)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Aggregate =\n%+v\nwant\n%+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRestartSession(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		restart func(st store.Store, at time.Time) (*model.Session, error)
+		user    string
+	}{
+		{
+			name: "transfer",
+			restart: func(st store.Store, at time.Time) (*model.Session, error) {
+				return TransferSession(ctx, st, "cf-1", "b@example.com", at)
+			},
+			user: "b@example.com",
+		},
+		{
+			name: "resume",
+			restart: func(st store.Store, at time.Time) (*model.Session, error) {
+				if _, err := SuspendSession(ctx, st, "cf-1", at); err != nil {
+					return nil, err
+				}
+				return ResumeSession(ctx, st, "cf-1", at.Add(time.Hour))
+			},
+			user: "a@example.com",
+		},
+		{
+			name: "restore",
+			restart: func(st store.Store, at time.Time) (*model.Session, error) {
+				if _, err := EndSession(ctx, st, "cf-1", at); err != nil {
+					return nil, err
+				}
+				return RestoreSession(ctx, st, "cf-1", at.Add(time.Hour))
+			},
+			user: "a@example.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := store.NewMemory()
+			if err := StartSession(ctx, st, model.Session{App: "cf-1", User: "a@example.com", Template: "go", StartedAt: start}); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := c.restart(st, start.Add(2*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.User != c.user || s.EndedAt != nil || s.Suspended {
+				t.Errorf("restarted session = %+v", s)
+			}
+
+			// the ended session is kept under its own key, and its billing
+			// event is queued
+			sessions, err := Sessions(ctx, st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessions) != 2 {
+				t.Fatalf("got %d sessions, want the ended and the restarted one", len(sessions))
+			}
+			var first model.Session
+			if err := st.Get(ctx, SessionKey("cf-1")+".1623056400", &first); err != nil {
+				t.Fatalf("ended session = %s", err)
+			}
+			if first.EndedAt == nil || !first.EndedAt.Equal(start.Add(2*time.Hour)) || first.Suspended || first.User != "a@example.com" {
+				t.Errorf("ended session = %+v", first)
+			}
+			if err := st.Get(ctx, PendingBillingKey(&first), &model.Session{}); err != nil {
+				t.Errorf("billing event of the ended session = %s", err)
+			}
+
+			hours := 0.0
+			for _, u := range Aggregate(sessions, Filter{User: "a@example.com"}, s.StartedAt.Add(time.Hour)) {
+				hours += u.EditorHours
+			}
+			want := 2.0
+			if c.user == "a@example.com" {
+				want = 3
+			}
+			if hours != want {
+				t.Errorf("editor hours of a@example.com = %v, want %v", hours, want)
+			}
+		})
+	}
+}
+
+func TestSuspendedSessionIsReleased(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	start := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+
+	if err := StartSession(ctx, st, model.Session{App: "cf-1", StartedAt: start}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SuspendSession(ctx, st, "cf-1", start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// released editors aren't suspended, and keep the end of the suspension
+	s, err := ReleaseSession(ctx, st, "cf-1", start.Add(5*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Suspended || !s.EndedAt.Equal(start.Add(time.Hour)) {
+		t.Errorf("released session = %+v", s)
+	}
+	if _, err := ResumeSession(ctx, st, "cf-1", start.Add(6*time.Hour)); err == nil {
+		t.Errorf("ResumeSession of a released session succeeded")
+	}
+}
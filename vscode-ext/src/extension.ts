@@ -2,9 +2,44 @@ import * as vscode from 'vscode';
 import * as path from 'path';
 import * as os from 'os';
 import * as fs from 'fs';
+import * as cp from 'child_process';
 
 export function activate(context: vscode.ExtensionContext) {
-	let disposable = vscode.commands.registerCommand('codeface.setup', async (gitUrl?: string, parentDir?: string) => {
+	let disposable = vscode.commands.registerCommand('codeface.setup', async (gitUrl?: string, parentDir?: string, gitRef?: string, repoName?: string, gitPath?: string) => {
+		let cacheUrl = process.env.CF_CACHE_URL;
+		let args = cloneArgs();
+		if (gitUrl && parentDir && repoName && (gitRef || cacheUrl || process.env.CF_DEPS_CACHE_URL || gitPath || args.length > 0)) {
+			let dir = path.join(parentDir, repoName);
+			if (gitPath) {
+				// only check out the subdirectory so that monorepos don't
+				// download every blob
+				if (!process.env.CF_GIT_FILTER) {
+					args.push('--filter=blob:none');
+				}
+				args.push('--sparse');
+			}
+			await clone(gitUrl, dir, args, gitRef);
+			if (gitPath) {
+				await git(['-C', dir, 'sparse-checkout', 'set', gitPath]);
+			}
+			if (process.env.GIT_UPSTREAM) {
+				await git(['-C', dir, 'remote', 'add', 'upstream', process.env.GIT_UPSTREAM]);
+			}
+			if (cacheUrl) {
+				await restoreCache(cacheUrl, dir).catch((err) => {
+					vscode.window.showWarningMessage(`Codeface: fail to restore dependency cache: ${err}`);
+				});
+			}
+			if (process.env.CF_DEPS_CACHE_URL) {
+				// the cache is missing until an editor of the repo is released
+				await restoreDepsCache(process.env.CF_DEPS_CACHE_URL).catch(() => {});
+			}
+			let folder = gitPath ? path.join(dir, gitPath) : dir;
+			installDeps(folder);
+			vscode.commands.executeCommand("vscode.openFolder", vscode.Uri.file(folder));
+			return;
+		}
+
 		vscode.commands.executeCommand("git.clone", gitUrl, parentDir);
 		// TODO: download Go
 	});
@@ -12,14 +47,236 @@ export function activate(context: vscode.ExtensionContext) {
 
 	// Trigger a setup after activate
 	let gitUrl = process.env.GIT_REPO;
+	let parentDir = path.join(os.homedir(), "project");
 	if (gitUrl) {
-		let repoName = decodeURI(gitUrl).replace(/[\/]+$/, '').replace(/^.*[\/\\]/, '').replace(/\.git$/, '') || 'repository';
-		let parentDir = path.join(os.homedir(), "project");
+		let repoName = repositoryName(gitUrl);
 		// Don't git clone if a folder already exists
 		if (!fs.existsSync(path.join(parentDir, repoName))) {
-			vscode.commands.executeCommand("codeface.setup", gitUrl, parentDir);
+			vscode.commands.executeCommand("codeface.setup", gitUrl, parentDir, process.env.GIT_REF, repoName, process.env.GIT_PATH);
 		}
+	} else {
+		cloneDefaultRepos(parentDir);
 	}
+
+	if (process.env.CF_AGENT_TOKEN) {
+		let timer = setInterval(checkIdle, idleCheckInterval);
+		context.subscriptions.push({ dispose: () => clearInterval(timer) });
+		checkIdle();
+	}
+}
+
+const idleCheckInterval = 60 * 1000;
+
+// warnedAt is when the last warning that was shown was left by the worker
+let warnedAt = '';
+
+// The worker leaves a warning before an editor is released, which is
+// shown once. With router idle detection, keeping the editor alive
+// postpones its release.
+function checkIdle() {
+	cp.execFile('cf-proxy', ['idle-warning'], async (err, stdout) => {
+		if (err || !stdout.trim()) {
+			return;
+		}
+
+		let warning = JSON.parse(stdout);
+		if (warning.WarnedAt === warnedAt) {
+			return;
+		}
+		warnedAt = warning.WarnedAt;
+
+		if (!warning.KeepAlive) {
+			vscode.window.showWarningMessage(`Codeface: ${warning.Message}`);
+			return;
+		}
+
+		let choice = await vscode.window.showWarningMessage(`Codeface: ${warning.Message}`, 'Keep working');
+		if (choice) {
+			cp.execFile('cf-proxy', ['keep-alive'], (err) => {
+				if (err) {
+					vscode.window.showErrorMessage(`Codeface: fail to keep the editor alive: ${err}`);
+				}
+			});
+		}
+	});
+}
+
+function repositoryName(gitUrl: string): string {
+	return decodeURI(gitUrl).replace(/[\/]+$/, '').replace(/^.*[\/\\]/, '').replace(/\.git$/, '') || 'repository';
+}
+
+// Editors claimed without a repository start with the default repositories
+// of their template, which are listed one per line
+async function cloneDefaultRepos(parentDir: string) {
+	let file = path.join(os.homedir(), ".codeface", "repos");
+	if (!fs.existsSync(file)) {
+		return;
+	}
+
+	let repos = fs.readFileSync(file, 'utf8').split('\n').map((line) => line.trim()).filter((line) => line && !line.startsWith('#'));
+	for (let gitUrl of repos) {
+		let dir = path.join(parentDir, repositoryName(gitUrl));
+		if (fs.existsSync(dir)) {
+			continue;
+		}
+
+		try {
+			await clone(gitUrl, dir, cloneArgs());
+			installDeps(dir);
+		} catch (err) {
+			vscode.window.showWarningMessage(`Codeface: fail to clone ${gitUrl}: ${err}`);
+		}
+	}
+}
+
+// Templates may ship a hook installing the dependencies of a freshly
+// cloned project, e.g. npm install or go mod download
+function installDeps(dir: string) {
+	let hook = path.join(os.homedir(), ".codeface", "hooks", "install-deps");
+	if (!fs.existsSync(hook)) {
+		return;
+	}
+
+	let terminal = vscode.window.createTerminal({ name: "Codeface: install dependencies", cwd: dir });
+	terminal.sendText(hook);
+	terminal.show();
+}
+
+async function restoreCache(url: string, dir: string) {
+	let archive = path.join(os.tmpdir(), `codeface-prebuild-${process.pid}.tar.gz`);
+	try {
+		await run('curl', ['-sfL', '-o', archive, url]);
+		await run('tar', ['-xzf', archive, '-C', dir]);
+	} finally {
+		if (fs.existsSync(archive)) {
+			fs.unlinkSync(archive);
+		}
+	}
+}
+
+// The dependency cache of the repository is archived from / by the editor
+// proxy, see CF_DEPS_CACHE_PATHS of the template. It's extracted into a
+// directory of its own, and only the paths of the template that are missing
+// are moved into place from there
+async function restoreDepsCache(url: string) {
+	let dir = path.join(os.homedir(), ".codeface", "deps-cache");
+	let archive = dir + ".tar.gz";
+	if (fs.existsSync(dir)) {
+		fs.rmdirSync(dir, { recursive: true });
+	}
+	fs.mkdirSync(dir, { recursive: true });
+
+	try {
+		await run('curl', ['-sfL', '-o', archive, url]);
+		await run('tar', ['-xzf', archive, '-C', dir]);
+
+		let patterns = (process.env.CF_DEPS_CACHE_PATHS || '').split(',').map((p) => p.trim()).filter((p) => path.isAbsolute(p));
+		for (let pattern of patterns) {
+			for (let rel of globPaths(dir, pattern.split('/').filter((s) => s))) {
+				let src = path.join(dir, rel);
+				let dst = path.join('/', rel);
+				if (fs.existsSync(dst) || fs.lstatSync(src).isSymbolicLink()) {
+					continue;
+				}
+				fs.mkdirSync(path.dirname(dst), { recursive: true });
+				fs.renameSync(src, dst);
+			}
+		}
+	} finally {
+		fs.rmdirSync(dir, { recursive: true });
+		if (fs.existsSync(archive)) {
+			fs.unlinkSync(archive);
+		}
+	}
+}
+
+// globPaths returns the paths under root matching the segments of a glob,
+// relative to root. Symbolic links aren't followed.
+function globPaths(root: string, segments: string[], rel = ''): string[] {
+	if (segments.length === 0) {
+		return [rel];
+	}
+
+	let [segment, ...rest] = segments;
+	let dir = path.join(root, rel);
+	let names = [segment];
+	if (/[*?]/.test(segment)) {
+		let re = new RegExp('^' + segment.split('').map((c) => c === '*' ? '[^/]*' : c === '?' ? '[^/]' : c.replace(/[\\^$.|+()[\]{}]/g, '\\$&')).join('') + '$');
+		names = fs.existsSync(dir) ? fs.readdirSync(dir).filter((name) => re.test(name)) : [];
+	}
+
+	let paths: string[] = [];
+	for (let name of names) {
+		let p = path.join(rel, name);
+		let stat: fs.Stats;
+		try {
+			stat = fs.lstatSync(path.join(root, p));
+		} catch (err) {
+			continue;
+		}
+		if (rest.length > 0 && !stat.isDirectory()) {
+			continue;
+		}
+		paths.push(...globPaths(root, rest, p));
+	}
+	return paths;
+}
+
+// The clone of large repositories is sped up with the CF_GIT_DEPTH,
+// CF_GIT_SINGLE_BRANCH and CF_GIT_FILTER settings of the claim, or of the
+// template
+function cloneArgs(): string[] {
+	let args: string[] = [];
+	if (process.env.CF_GIT_DEPTH) {
+		args.push('--depth', process.env.CF_GIT_DEPTH);
+	}
+	if (process.env.CF_GIT_SINGLE_BRANCH === 'true') {
+		args.push('--single-branch');
+	}
+	if (process.env.CF_GIT_FILTER) {
+		args.push('--filter=' + process.env.CF_GIT_FILTER);
+	}
+	return args;
+}
+
+async function clone(gitUrl: string, dir: string, args: string[], gitRef?: string) {
+	let shallow = args.includes('--depth') || args.includes('--single-branch');
+	if (!gitRef) {
+		await git(['clone', ...args, gitUrl, dir]);
+		return;
+	}
+	if (!shallow) {
+		await git(['clone', ...args, gitUrl, dir]);
+		await git(['-C', dir, 'checkout', gitRef]);
+		return;
+	}
+
+	// shallow clones may not have the ref, branches and tags are cloned
+	// directly while commits are fetched after cloning
+	try {
+		await git(['clone', ...args, '--branch', gitRef, gitUrl, dir]);
+	} catch (err) {
+		if (fs.existsSync(dir)) {
+			fs.rmdirSync(dir, { recursive: true });
+		}
+		await git(['clone', ...args, '--no-checkout', gitUrl, dir]);
+		let fetch = ['-C', dir, 'fetch', 'origin', gitRef];
+		if (process.env.CF_GIT_DEPTH) {
+			fetch.push('--depth', process.env.CF_GIT_DEPTH);
+		}
+		await git(fetch);
+		await git(['-C', dir, 'checkout', 'FETCH_HEAD']);
+	}
+}
+
+function git(args: string[]): Promise<void> {
+	return run('git', args);
+}
+
+function run(command: string, args: string[]): Promise<void> {
+	return new Promise((resolve, reject) => {
+		cp.execFile(command, args, (err) => err ? reject(err) : resolve());
+	});
 }
 
 // this method is called when your extension is deactivated
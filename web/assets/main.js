@@ -0,0 +1,14 @@
+// loaded as a file rather than inline to comply with the content security policy
+(async () => {
+    const resp = await fetch('/assets/main.wasm');
+    if (!resp.ok) {
+        const pre = document.createElement('pre');
+        pre.innerText = await resp.text();
+        document.body.appendChild(pre);
+        return;
+    }
+    const src = await resp.arrayBuffer();
+    const go = new Go();
+    const result = await WebAssembly.instantiate(src, go.importObject);
+    go.run(result.instance);
+})();
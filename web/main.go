@@ -57,7 +57,7 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 			prop.Type(prop.TypeURL),
 			prop.ID("inputRepo"),
 			vecty.Class("form-control"),
-			prop.Placeholder("GitHub repository URL"),
+			prop.Placeholder("Git repository URL"),
 			prop.Autofocus(true),
 			vecty.Property("required", true),
 			prop.Value(p.GitHubRepoURL),
@@ -109,7 +109,7 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 							vecty.Class("form-text"),
 							vecty.Class("text-muted"),
 						),
-						vecty.Text("The GitHub repository URL must be a valid public repository URL, e.g., https://github.com/jingweno/upterm."),
+						vecty.Text("The repository URL must be a valid public GitHub, GitLab or Bitbucket repository URL, e.g., https://github.com/jingweno/upterm."),
 					),
 					vecty.If(
 						p.ValidFeedback != "",
@@ -133,7 +133,7 @@ func (p *PageView) Render() vecty.ComponentOrHTML {
 						vecty.Markup(
 							prop.For("inputRepo"),
 						),
-						vecty.Text("GitHub repository URL"),
+						vecty.Text("Git repository URL"),
 					),
 				),
 				elem.Button(
@@ -224,9 +224,15 @@ func (p *PageView) claimEditor(repo string) {
 }
 
 func claimEditor(url string) (string, error) {
-	u, err := model.ParseGitHubRepoURL(url)
-	if err != nil {
-		return "", err
+	// editors claimed without a repository start with the default
+	// repositories of their template
+	var u string
+	if url != "" {
+		var err error
+		u, err = model.ParseRepoURL(url, nil)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	req := model.EditorRequest{
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	EventHeader     = "X-Codeface-Event"
+	SignatureHeader = "X-Codeface-Signature"
+)
+
+// Client posts JSON events to a webhook URL. When Secret is set the body
+// is signed with HMAC-SHA256 and the hex digest is sent in the
+// X-Codeface-Signature header.
+type Client struct {
+	URL     string
+	Secret  string
+	Timeout time.Duration
+}
+
+func (c *Client) Send(ctx context.Context, event string, payload interface{}) error {
+	_, err := c.Do(ctx, event, payload)
+	return err
+}
+
+// Do sends an event and returns the response body of a successful
+// delivery.
+func (c *Client) Do(ctx context.Context, event string, payload interface{}) ([]byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	if c.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(c.Secret, b))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &StatusError{Event: event, StatusCode: resp.StatusCode, Body: body}
+	}
+
+	return body, nil
+}
+
+type StatusError struct {
+	Event      string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error: webhook %s returned status=%d body=%s", e.Event, e.StatusCode, e.Body)
+}
+
+func Sign(secret string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,316 @@
+// Package websocket is a minimal WebSocket (RFC 6455) implementation for
+// the push channels of the server. Messages are text, and fragmented or
+// binary messages that are received are read as text too.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// acceptGUID is the GUID of RFC 6455 the Sec-WebSocket-Accept of a
+// handshake is derived with.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxMessageSize is the largest message that's read, the channels only
+// receive control frames and small messages.
+const maxMessageSize = 1 << 20
+
+// ErrBadHandshake is returned by Dial when the server doesn't switch to
+// the WebSocket protocol.
+var ErrBadHandshake = fmt.Errorf("error: websocket handshake failed")
+
+// Conn is a WebSocket connection. Messages may be written from several
+// goroutines, and read from one.
+type Conn struct {
+	rw io.ReadWriteCloser
+	br *bufio.Reader
+	// client is set on the client end, whose frames are masked
+	client bool
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Upgrade switches a request to the WebSocket protocol. It responds with a
+// 400 if the request isn't a WebSocket handshake, and with a 403 if it's
+// made by a page of another origin.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, fmt.Errorf("error: %s %s isn't a websocket handshake", r.Method, r.URL.Path)
+	}
+
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket handshakes aren't allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("error: websocket handshake of %s is cross-origin", r.Header.Get("Origin"))
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets aren't supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("error: response writer can't be hijacked")
+	}
+
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := buf.WriteString(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{rw: conn, br: buf.Reader}, nil
+}
+
+// sameOrigin reports whether a handshake may be made by the page it
+// comes from. Browsers send the cookies of the server along with the
+// handshakes of any page, which the same-origin policy doesn't apply to,
+// so handshakes with an Origin are only accepted from the origin of the
+// server. Clients sending an Authorization header, like cf, authenticate
+// with it rather than with cookies.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || r.Header.Get("Authorization") != "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Dial makes a WebSocket handshake with req, e.g. a GET of a URL with
+// authorization headers. When the server doesn't switch protocols, the
+// response is returned along with ErrBadHandshake and the caller has to
+// close its body.
+func Dial(client *http.Client, req *http.Request) (*Conn, *http.Response, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp, ErrBadHandshake
+	}
+
+	// the body of a 101 response is the connection itself
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		resp.Body.Close()
+		return nil, nil, ErrBadHandshake
+	}
+
+	return &Conn{rw: rw, br: bufio.NewReader(rw), client: true}, resp, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether a comma-separated header has a token, e.g.
+// Connection: keep-alive, Upgrade.
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WriteMessage writes a text message.
+func (c *Conn) WriteMessage(b []byte) error {
+	return c.writeFrame(opText, b)
+}
+
+// Ping writes a ping, e.g. to keep the connection from timing out on idle
+// routers.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// ReadMessage reads the next message. Pings are answered meanwhile, and
+// io.EOF is returned once the peer closes the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		case opText, opBinary, opContinuation:
+		default:
+			return nil, fmt.Errorf("error: unknown websocket opcode %d", op)
+		}
+
+		if len(msg)+len(payload) > maxMessageSize {
+			return nil, fmt.Errorf("error: websocket message is larger than %d bytes", maxMessageSize)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// Close closes the connection after telling the peer.
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, nil)
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	return c.rw.Close()
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := head[0]&0x80 != 0
+	op := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessageSize {
+		return false, 0, nil, fmt.Errorf("error: websocket frame is larger than %d bytes", maxMessageSize)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, op, payload, nil
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+
+	frame := []byte{0x80 | op}
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+
+	// clients mask their frames so that caching proxies can't be poisoned
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	_, err := c.rw.Write(append(frame, payload...))
+	return err
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name          string
+		origin        string
+		authorization string
+		ok            bool
+	}{
+		{name: "no origin", ok: true},
+		{name: "same origin", origin: srv.URL, ok: true},
+		{name: "other origin", origin: "https://evil.example.com"},
+		{name: "opaque origin", origin: "null"},
+		{name: "other origin with authorization", origin: "https://evil.example.com", authorization: "Bearer token", ok: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.origin != "" {
+				req.Header.Set("Origin", c.origin)
+			}
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+
+			conn, resp, err := Dial(http.DefaultClient, req)
+			if c.ok {
+				if err != nil {
+					t.Fatalf("Dial = %s, want the handshake accepted", err)
+				}
+				conn.Close()
+				return
+			}
+
+			if err != ErrBadHandshake || resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("Dial = %v, want a 403", err)
+			}
+			resp.Body.Close()
+		})
+	}
+}
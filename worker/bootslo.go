@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/jingweno/codeface/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+// Events sent to ALERT_WEBHOOK_URL when the boot SLO of a pool is breached
+// and when it's met again.
+const (
+	bootSLOBreachedEvent  = "boot_slo.breached"
+	bootSLORecoveredEvent = "boot_slo.recovered"
+)
+
+// poolSize returns how many idle editors the pool keeps, its size and the
+// boost of its boot SLO. Under a pool budget the boost is part of the size
+// the budget gives the pool, see applyPoolBudget.
+func (w *Worker) poolSize() int {
+	if w.template != "" && w.cfg.PoolBudget > 0 {
+		return w.cfg.PoolSize
+	}
+
+	return w.cfg.PoolSize + w.poolBoost
+}
+
+// checkBootSLO checks the boot times of the claims of the pool against
+// BootSLO and grows or shrinks the boost of the pool. Each change is only
+// judged by the boots of the claims after it, so that the pool isn't grown
+// again before the editors it was grown by made a difference. The state
+// is kept in the store for the replica that owns the pool next.
+func (w *Worker) checkBootSLO(ctx context.Context, now time.Time) error {
+	if w.cfg.BootSLO == 0 {
+		w.poolBoost = 0
+		return nil
+	}
+
+	tmpl := w.poolTemplate()
+	slo := model.BootSLO{Template: tmpl}
+	if err := w.store.Get(ctx, editor.BootSLOKey(tmpl), &slo); err != nil && err != store.ErrNotFound {
+		return err
+	}
+	slo.Objective = w.cfg.BootSLO
+	slo.Percentile = w.cfg.BootSLOPercentile
+	if slo.Boost > w.cfg.BootSLOMaxBoost {
+		slo.Boost = w.cfg.BootSLOMaxBoost
+	}
+	w.poolBoost = slo.Boost
+
+	sessions, err := usage.Sessions(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	since := now.Add(-w.cfg.BootSLOWindow)
+	if slo.ChangedAt.After(since) {
+		since = slo.ChangedAt
+	}
+	boots := usage.Boots(sessions, usage.Filter{Template: tmpl, From: since})
+	slo.Boots = len(boots)
+	slo.CheckedAt = now
+	judged := len(boots) >= w.cfg.BootSLOMinBoots
+	if judged {
+		slo.Observed = usage.Percentile(boots, w.cfg.BootSLOPercentile)
+	}
+
+	logger := w.logger.WithFields(log.Fields{
+		"template":   tmpl,
+		"percentile": slo.Percentile,
+		"observed":   slo.Observed,
+		"objective":  slo.Objective,
+		"boots":      slo.Boots,
+	})
+
+	event := ""
+	switch {
+	case !judged && slo.Breached:
+		// a breach lasts until enough boots tell otherwise
+	case judged && slo.Observed > slo.Objective:
+		if !slo.Breached {
+			slo.Breached = true
+			slo.BreachedAt = &now
+			event = bootSLOBreachedEvent
+			logger.Warn("Boot SLO is breached")
+		}
+
+		if slo.Boost < w.cfg.BootSLOMaxBoost {
+			slo.Boost += w.cfg.BootSLOPoolStep
+			if slo.Boost > w.cfg.BootSLOMaxBoost {
+				slo.Boost = w.cfg.BootSLOMaxBoost
+			}
+			slo.ChangedAt = now
+			logger.WithField("boost", slo.Boost).Info("Growing pool to meet boot SLO")
+		}
+	default:
+		if slo.Breached {
+			slo.Breached = false
+			slo.BreachedAt = nil
+			event = bootSLORecoveredEvent
+			logger.Info("Boot SLO is met again")
+		}
+
+		// the boost is kept for a window after the SLO is met, which it
+		// may be met thanks to, or after the last change if there are too
+		// few claims to tell
+		if slo.Boost > 0 && now.Sub(slo.ChangedAt) >= w.cfg.BootSLOWindow {
+			slo.Boost -= w.cfg.BootSLOPoolStep
+			if slo.Boost < 0 {
+				slo.Boost = 0
+			}
+			slo.ChangedAt = now
+			logger.WithField("boost", slo.Boost).Info("Shrinking pool back while boot SLO is met")
+		}
+	}
+	w.poolBoost = slo.Boost
+
+	if err := w.store.Put(ctx, editor.BootSLOKey(tmpl), slo); err != nil {
+		return err
+	}
+
+	if event == "" || w.cfg.AlertWebhookURL == "" {
+		return nil
+	}
+
+	wh := &webhook.Client{
+		URL:     w.cfg.AlertWebhookURL,
+		Secret:  w.cfg.AlertWebhookSecret,
+		Timeout: 10 * time.Second,
+	}
+	if err := wh.Send(ctx, event, slo); err != nil {
+		logger.WithError(err).Info("Fail to send boot SLO alert")
+	}
+
+	return nil
+}
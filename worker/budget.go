@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// claimRates returns the recent claims of templates. Claims decay
+// exponentially with their age, counting half as much every halfLife.
+func claimRates(sessions []model.Session, halfLife time.Duration, now time.Time) map[string]float64 {
+	rates := make(map[string]float64)
+	for _, s := range sessions {
+		age := now.Sub(s.StartedAt)
+		if age < 0 {
+			age = 0
+		}
+
+		rates[s.Template] += math.Exp2(-float64(age) / float64(halfLife))
+	}
+
+	return rates
+}
+
+// budgetPools shrinks the sizes of pools until they add up to budget,
+// starting with the pools of the templates claimed the least recently.
+// Pools keep an editor until all of them are down to one, then they're
+// emptied in the same order.
+func budgetPools(sizes map[string]int, rates map[string]float64, budget int) map[string]int {
+	shrunk := make(map[string]int)
+	var templates []string
+	total := 0
+	for t, n := range sizes {
+		shrunk[t] = n
+		templates = append(templates, t)
+		total += n
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		a, b := templates[i], templates[j]
+		if rates[a] != rates[b] {
+			return rates[a] < rates[b]
+		}
+		return a < b
+	})
+
+	for _, floor := range []int{1, 0} {
+		for _, t := range templates {
+			if total <= budget {
+				return shrunk
+			}
+
+			cut := shrunk[t] - floor
+			if cut <= 0 {
+				continue
+			}
+			if cut > total-budget {
+				cut = total - budget
+			}
+			shrunk[t] -= cut
+			total -= cut
+		}
+	}
+
+	return shrunk
+}
+
+// applyPoolBudget sizes the pools of the shards to fit PoolBudget. Every
+// replica sizes all the pools from the sessions in the store, so that
+// they agree on the sizes of the pools they own.
+func (w *Worker) applyPoolBudget(ctx context.Context) error {
+	if w.cfg.PoolBudget == 0 || w.shards == nil {
+		return nil
+	}
+
+	sessions, err := usage.Sessions(ctx, w.store)
+	if err != nil {
+		return err
+	}
+	rates := claimRates(sessions, w.cfg.ClaimHalfLife, time.Now())
+
+	sizes := make(map[string]int)
+	for name := range w.shards {
+		// editors the pool is boosted by to meet its boot SLO are within
+		// the budget too, see poolSize
+		sizes[name] = w.settings.apply(w.cfg, name).PoolSize + w.shards[name].poolBoost
+	}
+
+	for name, n := range budgetPools(sizes, rates, w.cfg.PoolBudget) {
+		s := w.shards[name]
+		if s.cfg.PoolSize != n {
+			s.logger.WithFields(log.Fields{
+				"pool_size":   n,
+				"configured":  sizes[name],
+				"claim_rate":  rates[name],
+				"pool_budget": w.cfg.PoolBudget,
+			}).Info("Resizing pool to fit the pool budget")
+		}
+		s.cfg.PoolSize = n
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+// canaryFailedEvent is sent to ALERT_WEBHOOK_URL when the canary of a new
+// version fails.
+const canaryFailedEvent = "canary.failed"
+
+// canaryProbeTimeout is how long a request to the canary editor may take.
+const canaryProbeTimeout = 10 * time.Second
+
+// canaryPassed returns whether the current version of the pool passed its
+// canary, which the editors of older versions are only culled after. The
+// canary is moved on a step per check: an editor of the current version is
+// claimed once the pool has one, and probed until code-server serves the
+// workbench or the canary times out. The editor is deleted either way.
+func (w *Worker) canaryPassed(ctx context.Context, currentVersion []provider.Editor, now time.Time) (bool, error) {
+	if w.cfg.CanaryRepo == "" {
+		return true, nil
+	}
+
+	tmpl, version := w.poolTemplate(), editor.Version()
+	c := model.Canary{Template: tmpl, Version: version}
+	err := w.store.Get(ctx, editor.CanaryKey(tmpl, version), &c)
+	if err != nil && err != store.ErrNotFound {
+		return false, err
+	}
+
+	switch c.State {
+	case model.CanaryStatePassed:
+		return true, nil
+	case model.CanaryStateFailed:
+		if now.Sub(*c.CompletedAt) < w.cfg.CanaryRetryAfter {
+			return false, nil
+		}
+		fallthrough
+	case "":
+		// the canary is claimed from the editors of the new version
+		if len(currentVersion) == 0 {
+			return false, nil
+		}
+		return false, w.startCanary(ctx, c, currentVersion[0], now)
+	default:
+		return w.probeCanary(ctx, c, now)
+	}
+}
+
+func (w *Worker) startCanary(ctx context.Context, c model.Canary, ed provider.Editor, now time.Time) error {
+	logger := w.logger.WithFields(log.Fields{"template": c.Template, "version": c.Version, "app": ed.Name})
+	logger.Info("Claiming canary of new version")
+
+	claimed, err := w.provider.Claim(ctx, editor.ClaimOptions{
+		App:        ed.Name,
+		GitRepo:    w.cfg.CanaryRepo,
+		CanaryRepo: w.cfg.CanaryRepo,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.State = model.CanaryStateRunning
+	c.Editor = claimed.Name
+	c.Provider = claimed.Provider
+	c.URL = claimed.URL
+	c.StartedAt = now
+	c.CompletedAt = nil
+	c.Error = ""
+	c.Attempts++
+
+	return w.store.Put(ctx, editor.CanaryKey(c.Template, c.Version), c)
+}
+
+func (w *Worker) probeCanary(ctx context.Context, c model.Canary, now time.Time) (bool, error) {
+	logger := w.logger.WithFields(log.Fields{"template": c.Template, "version": c.Version, "app": c.Editor})
+
+	err := w.probeWorkbench(ctx, c)
+	if err != nil && now.Sub(c.StartedAt) < w.cfg.CanaryTimeout {
+		logger.WithError(err).Info("Canary isn't serving yet")
+		return false, nil
+	}
+
+	completedAt := now
+	c.CompletedAt = &completedAt
+	if err != nil {
+		c.State = model.CanaryStateFailed
+		c.Error = err.Error()
+		logger.WithError(err).Warn("Canary of new version failed, keeping outdated apps")
+	} else {
+		c.State = model.CanaryStatePassed
+		logger.WithField("elapsed", now.Sub(c.StartedAt)).Info("Canary of new version passed")
+	}
+
+	w.deleteCanary(ctx, c, logger)
+
+	if err := w.store.Put(ctx, editor.CanaryKey(c.Template, c.Version), c); err != nil {
+		return false, err
+	}
+
+	if c.State == model.CanaryStateFailed && w.cfg.AlertWebhookURL != "" {
+		wh := &webhook.Client{
+			URL:     w.cfg.AlertWebhookURL,
+			Secret:  w.cfg.AlertWebhookSecret,
+			Timeout: 10 * time.Second,
+		}
+		if err := wh.Send(ctx, canaryFailedEvent, c); err != nil {
+			logger.WithError(err).Info("Fail to send canary alert")
+		}
+	}
+
+	return c.State == model.CanaryStatePassed, nil
+}
+
+// probeWorkbench checks that the canary editor serves the workbench of
+// code-server.
+func (w *Worker) probeWorkbench(ctx context.Context, c model.Canary) error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+
+	// editors behind the proxy of the server are probed directly
+	if r, ok := provider.Lookup(w.provider, c.Provider).(provider.Router); ok {
+		backend, err := r.Backend(ctx, c.Editor)
+		if err != nil {
+			return err
+		}
+		backend.RawQuery = u.RawQuery
+		u = backend
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, canaryProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error: workbench responded with status=%d", resp.StatusCode)
+	}
+	if !strings.Contains(strings.ToLower(string(b)), "<html") {
+		return fmt.Errorf("error: workbench responded without a page")
+	}
+
+	return nil
+}
+
+// deleteCanary deletes the canary editor. Heroku editors are claimed by
+// the pool account and are deleted rather than scaled down.
+func (w *Worker) deleteCanary(ctx context.Context, c model.Canary, logger log.FieldLogger) {
+	if c.Provider == provider.Heroku {
+		editor.DeleteApp(w.heroku, &heroku.App{Name: c.Editor}, logger)
+		return
+	}
+
+	p := provider.Lookup(w.provider, c.Provider)
+	if p == nil {
+		logger.Info("Fail to delete canary, its provider isn't configured")
+		return
+	}
+	if err := p.Delete(ctx, c.Editor); err != nil {
+		logger.WithError(err).Info("Fail to delete canary")
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/jingweno/codeface/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+// appCappedEvent is sent to ALERT_WEBHOOK_URL when the fleet reaches
+// MaxTotalApps.
+const appCappedEvent = "apps.capped"
+
+// totalApps counts the editors of the fleet, the idle ones of every pool
+// and the claimed ones.
+func (w *Worker) totalApps(ctx context.Context) (int, error) {
+	currentVersion, otherVersion, err := w.provider.Pool(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sessions, err := usage.OpenSessions(ctx, w.store)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(currentVersion) + len(otherVersion) + len(sessions), nil
+}
+
+// capDeploys returns how many of n deploys to the pools fit under
+// MaxTotalApps. When the cap holds deploys back it's marked as reached for
+// the server, and an alert is sent the first time. The mark is cleared once
+// the fleet is below the cap again.
+func (w *Worker) capDeploys(ctx context.Context, n int) int {
+	if w.cfg.MaxTotalApps == 0 || n == 0 {
+		return n
+	}
+
+	total, err := w.totalApps(ctx)
+	if err != nil {
+		// nothing is deployed while the fleet can't be counted, that's
+		// what the cap protects against
+		w.logger.WithError(err).Info("Fail to count apps")
+		return 0
+	}
+
+	headroom := w.cfg.MaxTotalApps - total
+	if headroom < 0 {
+		headroom = 0
+	}
+	if n <= headroom {
+		if err := w.store.Delete(ctx, editor.AppCapKey); err != nil && err != store.ErrNotFound {
+			w.logger.WithError(err).Info("Fail to clear app cap")
+		}
+		return n
+	}
+
+	w.reachAppCap(ctx, total)
+
+	return headroom
+}
+
+func (w *Worker) reachAppCap(ctx context.Context, total int) {
+	logger := w.logger.WithFields(log.Fields{"apps": total, "max": w.cfg.MaxTotalApps})
+	logger.Info("Reached MAX_TOTAL_APPS, holding back deploys")
+
+	var prev model.AppCap
+	err := w.store.Get(ctx, editor.AppCapKey, &prev)
+	if err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to get app cap")
+		return
+	}
+
+	c := model.AppCap{
+		Apps:       total,
+		Max:        w.cfg.MaxTotalApps,
+		ReachedAt:  time.Now(),
+		RetryAfter: w.tickInterval(),
+	}
+	alert := err == store.ErrNotFound
+	if !alert {
+		c.ReachedAt = prev.ReachedAt
+	}
+	if err := w.store.Put(ctx, editor.AppCapKey, c); err != nil {
+		logger.WithError(err).Info("Fail to save app cap")
+		return
+	}
+
+	if !alert || w.cfg.AlertWebhookURL == "" {
+		return
+	}
+
+	wh := &webhook.Client{
+		URL:     w.cfg.AlertWebhookURL,
+		Secret:  w.cfg.AlertWebhookSecret,
+		Timeout: 10 * time.Second,
+	}
+	if err := wh.Send(ctx, appCappedEvent, c); err != nil {
+		logger.WithError(err).Info("Fail to send app cap alert")
+	}
+}
@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// claimInterruptedAfter is how long a claim may be pending before it's
+	// failed, the server that claims it was restarted by then
+	claimInterruptedAfter = time.Hour
+	// claimRetention is how long completed claims are kept
+	claimRetention = 24 * time.Hour
+)
+
+// purgeClaims garbage collects the claims made with POST /v1/claims.
+// Pending claims nobody polls are abandoned, and so are ready ones whose
+// user never got the editor, which is released. Claims are deleted once
+// they're completed for claimRetention.
+func (w *Worker) purgeClaims(ctx context.Context) error {
+	keys, err := w.store.List(ctx, editor.ClaimKey(""))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		var c model.Claim
+		err := w.store.Get(ctx, key, &c)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var polledAt time.Time
+		if err := w.store.Get(ctx, editor.ClaimPollKey(c.ID), &polledAt); err == nil && polledAt.After(c.PolledAt) {
+			c.PolledAt = polledAt
+		}
+
+		logger := w.logger.WithFields(log.Fields{"claim": c.ID, "user": c.User})
+		switch {
+		case c.State == model.ClaimStatePending && now.Sub(c.CreatedAt) > claimInterruptedAfter:
+			logger.Info("Failing interrupted claim")
+			c.State = model.ClaimStateFailed
+			c.Error = "the claim was interrupted, make it again"
+		case c.State == model.ClaimStatePending && now.Sub(c.PolledAt) > w.cfg.ClaimAbandonTimeout:
+			// the server releases the editor once it's claimed
+			logger.Info("Abandoning pending claim")
+			c.State = model.ClaimStateAbandoned
+		case c.State == model.ClaimStateReady && c.PolledAt.Before(*c.CompletedAt) && now.Sub(*c.CompletedAt) > w.cfg.ClaimAbandonTimeout:
+			logger = logger.WithField("app", c.Editor)
+			logger.Info("Abandoning ready claim, releasing editor")
+			// releasing is retried on the next check
+			if err := w.releaseAbandoned(ctx, c.Editor); err != nil {
+				logger.WithError(err).Info("Fail to release editor")
+				continue
+			}
+			c.State = model.ClaimStateAbandoned
+		case c.State != model.ClaimStatePending && c.CompletedAt != nil && now.Sub(*c.CompletedAt) > claimRetention:
+			if err := w.store.Delete(ctx, editor.ClaimPollKey(c.ID)); err != nil && err != store.ErrNotFound {
+				logger.WithError(err).Info("Fail to delete claim poll")
+			}
+			if err := w.store.Delete(ctx, key); err != nil && err != store.ErrNotFound {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+
+		if c.CompletedAt == nil || c.State == model.ClaimStateAbandoned {
+			c.CompletedAt = &now
+		}
+		if err := w.store.Put(ctx, key, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseAbandoned releases the editor of an abandoned claim and ends its
+// session, unless it's ended already.
+func (w *Worker) releaseAbandoned(ctx context.Context, name string) error {
+	var s model.Session
+	err := w.store.Get(ctx, usage.SessionKey(name), &s)
+	if err == store.ErrNotFound || (err == nil && s.EndedAt != nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := provider.Release(ctx, w.provider, provider.OfSession(s), name, w.resets(s.Template)); err != nil {
+		return err
+	}
+
+	if _, err := w.billSession(ctx, s, time.Now(), usage.EndSession); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+func (w *Worker) restartCrashedEditors(ctx context.Context) error {
+	apps, err := editor.AllClaimedApps(ctx, w.heroku)
+	if err != nil {
+		return err
+	}
+
+	for _, app := range apps {
+		app := app
+		if err := w.checkEditor(ctx, &app); err != nil {
+			w.logger.WithError(err).WithField("app", app.Name).Info("Fail to check editor")
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) checkEditor(ctx context.Context, app *heroku.App) error {
+	logger := w.logger.WithField("app", app.Name)
+
+	dynos, err := w.heroku.DynoList(ctx, app.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	crashed := false
+	for _, d := range dynos {
+		if d.Type == "web" && d.State == "crashed" {
+			crashed = true
+			break
+		}
+	}
+
+	key := editor.StatusKey(app.Name)
+	var st model.EditorStatus
+	if err := w.store.Get(ctx, key, &st); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	now := time.Now()
+
+	if !crashed {
+		if st.State == "" {
+			return nil
+		}
+
+		// forget about crashes once the editor has been stable for a while
+		if now.Sub(st.LastCrashAt) > w.cfg.CrashResetAfter {
+			logger.Info("Editor is stable, clearing crash history")
+			return w.store.Delete(ctx, key)
+		}
+
+		if st.State == model.EditorStateUp {
+			return nil
+		}
+
+		st.State = model.EditorStateUp
+		st.Message = "Editor has recovered"
+		return w.store.Put(ctx, key, st)
+	}
+
+	if st.State == model.EditorStateFailed {
+		return nil
+	}
+
+	st.Name = app.Name
+	st.URL = editor.EditorAppURL(app)
+	if st.State != model.EditorStateCrashed {
+		st.LastCrashAt = now
+	}
+	st.State = model.EditorStateCrashed
+
+	if st.Restarts >= w.cfg.MaxRestarts {
+		logger.WithField("restarts", st.Restarts).Info("Editor keeps crashing, giving up")
+		st.State = model.EditorStateFailed
+		st.Message = fmt.Sprintf("Editor crashed %d times and will not be restarted", st.Restarts)
+		return w.store.Put(ctx, key, st)
+	}
+
+	if now.Before(st.NextRestartAt) {
+		st.Message = fmt.Sprintf("Editor crashed, restarting at %s", st.NextRestartAt.Format(time.RFC3339))
+		return w.store.Put(ctx, key, st)
+	}
+
+	logger.WithFields(log.Fields{"restarts": st.Restarts}).Info("Restarting crashed editor")
+	if _, err := w.heroku.DynoRestartAll(ctx, app.Name); err != nil {
+		return err
+	}
+
+	st.State = model.EditorStateRestarting
+	st.NextRestartAt = now.Add(restartBackoff(w.cfg.RestartBackoff, st.Restarts))
+	st.Restarts++
+	st.Message = fmt.Sprintf("Editor crashed and was restarted (%d/%d)", st.Restarts, w.cfg.MaxRestarts)
+
+	return w.store.Put(ctx, key, st)
+}
+
+func restartBackoff(base time.Duration, restarts int) time.Duration {
+	return base * time.Duration(1<<uint(restarts))
+}
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+)
+
+// purgeDeletions deletes the editors deleted by users whose grace period
+// is over. They were stopped by the server meanwhile.
+func (w *Worker) purgeDeletions(ctx context.Context) error {
+	keys, err := w.store.List(ctx, editor.DeletionKey(""))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		var del model.Deletion
+		err := w.store.Get(ctx, key, &del)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if now.Before(del.PurgeAt) {
+			continue
+		}
+
+		logger := w.logger.WithField("app", del.Editor)
+		logger.Info("Purging deleted editor")
+
+		var s model.Session
+		if err := w.store.Get(ctx, usage.SessionKey(del.Editor), &s); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).Info("Fail to get session")
+			continue
+		}
+
+		// purging is retried on the next check
+		if err := provider.Release(ctx, w.provider, provider.OfSession(s), del.Editor, w.resets(s.Template)); err != nil {
+			logger.WithError(err).Info("Fail to purge deleted editor")
+			continue
+		}
+
+		if err := w.store.Delete(ctx, editor.SuspensionKey(del.Editor)); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).Info("Fail to delete suspension")
+		}
+
+		if err := w.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeReleases deletes the editors released by their owners whose sticky
+// window is over. They were kept running by the server meanwhile.
+func (w *Worker) purgeReleases(ctx context.Context) error {
+	keys, err := w.store.List(ctx, editor.ReleaseKey(""))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		var rel model.Release
+		err := w.store.Get(ctx, key, &rel)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if now.Before(rel.ExpiresAt) {
+			continue
+		}
+
+		logger := w.logger.WithField("app", rel.Editor)
+		logger.Info("Deleting released editor")
+
+		// deleting is retried on the next check
+		if err := provider.Release(ctx, w.provider, rel.Provider, rel.Editor, w.resets(rel.Template)); err != nil {
+			logger.WithError(err).Info("Fail to delete released editor")
+			continue
+		}
+
+		if err := w.store.Delete(ctx, editor.SuspensionKey(rel.Editor)); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).Info("Fail to delete suspension")
+		}
+
+		if err := w.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resets returns whether purged editors of a template are reset into the
+// pool instead of being destroyed.
+func (w *Worker) resets(template string) bool {
+	return w.cfg.ResetReleasedEditors && !singleUse(w.cfg, template)
+}
+
+func singleUse(cfg Config, template string) bool {
+	for _, t := range cfg.SingleUseTemplates {
+		if t == template {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+)
+
+// pruneDeploys deletes the deploys that are older than the build log
+// retention.
+func (w *Worker) pruneDeploys(ctx context.Context) error {
+	keys, err := w.store.List(ctx, editor.DeployKey(""))
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-w.cfg.BuildLogRetention)
+	for _, key := range keys {
+		var dep model.Deploy
+		err := w.store.Get(ctx, key, &dep)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// deploys that never finished are pruned by when they started
+		at := dep.StartedAt
+		if dep.FinishedAt != nil {
+			at = *dep.FinishedAt
+		}
+		if at.After(cutoff) {
+			continue
+		}
+
+		if err := w.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
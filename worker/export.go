@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/s3"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/jingweno/codeface/webhook"
+)
+
+const lastExportKey = "exports/usage"
+
+type lastExport struct {
+	Day string
+}
+
+type billingEvent struct {
+	// ID is the same for every delivery of the event of a session, which
+	// receivers dedupe retries with
+	ID          string
+	Session     model.Session
+	EditorHours float64
+}
+
+// billedKey is the key of when the billing event of a session was sent.
+func billedKey(s *model.Session) string {
+	return "billed/" + usage.BillingID(s)
+}
+
+// billSession ends the session of an editor with end and sends its billing
+// event right away. Ending it queues the event, which retryBillingEvents
+// sends until the webhook takes it.
+func (w *Worker) billSession(ctx context.Context, s model.Session, at time.Time, end func(context.Context, store.Store, string, time.Time) (*model.Session, error)) (*model.Session, error) {
+	ended, err := end(ctx, w.store, s.App, at)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.sendBillingEvent(ctx, ended); err != nil {
+		w.logger.WithError(err).WithField("app", s.App).Info("Fail to send billing event, retrying later")
+	}
+
+	return ended, nil
+}
+
+// retryBillingEvents sends the billing events of the sessions that ended
+// anywhere, including those the server ended. They're dropped when there's
+// no webhook to send them to.
+func (w *Worker) retryBillingEvents(ctx context.Context) error {
+	keys, err := w.store.List(ctx, usage.PendingBillingPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if w.cfg.BillingWebhookURL == "" {
+			if err := w.store.Delete(ctx, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var s model.Session
+		err := w.store.Get(ctx, key, &s)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// the session didn't end if ending it failed, it's queued again
+		// when it does
+		var cur model.Session
+		err = w.store.Get(ctx, usage.SessionKey(s.App), &cur)
+		if err != nil && err != store.ErrNotFound {
+			return err
+		}
+		if err == nil && cur.EndedAt == nil && cur.StartedAt.Equal(s.StartedAt) {
+			continue
+		}
+
+		if err := w.sendBillingEvent(ctx, &s); err != nil {
+			w.logger.WithError(err).WithField("app", s.App).Info("Fail to send billing event, retrying later")
+		}
+	}
+
+	return nil
+}
+
+// sendBillingEvent sends the billing event of an ended session, once.
+func (w *Worker) sendBillingEvent(ctx context.Context, s *model.Session) error {
+	if w.cfg.BillingWebhookURL == "" || s.EndedAt == nil {
+		return nil
+	}
+
+	var sentAt time.Time
+	err := w.store.Get(ctx, billedKey(s), &sentAt)
+	if err == nil {
+		return w.deletePendingBilling(ctx, s)
+	}
+	if err != store.ErrNotFound {
+		return err
+	}
+
+	c := &webhook.Client{
+		URL:     w.cfg.BillingWebhookURL,
+		Secret:  w.cfg.BillingWebhookSecret,
+		Timeout: 10 * time.Second,
+	}
+
+	if err := c.Send(ctx, "session.ended", billingEvent{
+		ID:          usage.BillingID(s),
+		Session:     *s,
+		EditorHours: s.EndedAt.Sub(s.StartedAt).Hours(),
+	}); err != nil {
+		return err
+	}
+
+	if err := w.store.Put(ctx, billedKey(s), time.Now()); err != nil {
+		return err
+	}
+
+	return w.deletePendingBilling(ctx, s)
+}
+
+func (w *Worker) deletePendingBilling(ctx context.Context, s *model.Session) error {
+	if err := w.store.Delete(ctx, usage.PendingBillingKey(s)); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// exportUsage uploads one CSV of usage aggregates per UTC day to S3,
+// catching up on every full day since the last export.
+func (w *Worker) exportUsage(ctx context.Context) error {
+	if w.cfg.UsageExportS3Bucket == "" {
+		return nil
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+
+	var last lastExport
+	if err := w.store.Get(ctx, lastExportKey, &last); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	day := yesterday
+	if last.Day != "" {
+		d, err := time.Parse(usage.DayFormat, last.Day)
+		if err != nil {
+			return err
+		}
+		day = d.AddDate(0, 0, 1)
+	}
+
+	if day.After(yesterday) {
+		return nil
+	}
+
+	sessions, err := usage.Sessions(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	c := &s3.Client{
+		Bucket:          w.cfg.UsageExportS3Bucket,
+		Region:          w.cfg.AWSRegion,
+		AccessKeyID:     w.cfg.AWSAccessKeyID,
+		SecretAccessKey: w.cfg.AWSSecretAccessKey,
+	}
+
+	for ; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+		name := day.Format(usage.DayFormat)
+		rows := usage.Aggregate(sessions, usage.Filter{From: day, To: day.AddDate(0, 0, 1)}, time.Now())
+
+		buf := bytes.NewBuffer(nil)
+		if err := usage.WriteCSV(buf, rows); err != nil {
+			return err
+		}
+
+		w.logger.WithField("day", name).Info("Exporting usage")
+		if err := c.Put(ctx, "usage/"+name+".csv", "text/csv", buf.Bytes()); err != nil {
+			return err
+		}
+
+		// the sessions started on the day are exported with the labels of
+		// their claim
+		var started []model.Session
+		for _, s := range sessions {
+			if !s.StartedAt.Before(day) && s.StartedAt.Before(day.AddDate(0, 0, 1)) {
+				started = append(started, s)
+			}
+		}
+
+		buf.Reset()
+		if err := usage.WriteSessionsCSV(buf, started, time.Now()); err != nil {
+			return err
+		}
+		if err := c.Put(ctx, "sessions/"+name+".csv", "text/csv", buf.Bytes()); err != nil {
+			return err
+		}
+
+		if err := w.store.Put(ctx, lastExportKey, lastExport{Day: name}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// billingWebhook records the billing events it takes, and fails while
+// down is set.
+type billingWebhook struct {
+	mu     sync.Mutex
+	down   bool
+	events []billingEvent
+}
+
+func (b *billingWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var e billingEvent
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	b.events = append(b.events, e)
+}
+
+func (b *billingWebhook) setDown(down bool) {
+	b.mu.Lock()
+	b.down = down
+	b.mu.Unlock()
+}
+
+func (b *billingWebhook) received() []billingEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]billingEvent(nil), b.events...)
+}
+
+func newBillingWorker(t *testing.T, url string) *Worker {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return &Worker{
+		cfg:    Config{BillingWebhookURL: url},
+		store:  store.NewMemory(),
+		logger: logger,
+	}
+}
+
+func pendingBilling(t *testing.T, st store.Store) []string {
+	keys, err := st.List(context.Background(), usage.PendingBillingPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestRetryBillingEvents(t *testing.T) {
+	ctx := context.Background()
+	hook := &billingWebhook{down: true}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	w := newBillingWorker(t, srv.URL)
+	start := time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC)
+	if err := usage.StartSession(ctx, w.store, model.Session{App: "cf-1", User: "a@example.com", StartedAt: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server ends sessions without sending their billing events
+	if _, err := usage.EndSession(ctx, w.store, "cf-1", start.Add(90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pendingBilling(t, w.store); len(keys) != 1 {
+		t.Fatalf("pending billing events = %v, want the ended session", keys)
+	}
+
+	// the event stays queued while the webhook fails
+	if err := w.retryBillingEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pendingBilling(t, w.store); len(keys) != 1 {
+		t.Fatalf("pending billing events = %v after a failed delivery, want the event", keys)
+	}
+
+	hook.setDown(false)
+	for i := 0; i < 2; i++ {
+		if err := w.retryBillingEvents(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := hook.received()
+	if len(events) != 1 {
+		t.Fatalf("got %d billing events, want 1", len(events))
+	}
+	if events[0].ID != "cf-1.1623056400" || events[0].EditorHours != 1.5 || events[0].Session.User != "a@example.com" {
+		t.Errorf("billing event = %+v", events[0])
+	}
+	if keys := pendingBilling(t, w.store); len(keys) != 0 {
+		t.Errorf("pending billing events = %v after the delivery, want none", keys)
+	}
+
+	// a session that is ended again, e.g. by another path, isn't billed twice
+	if _, err := w.billSession(ctx, events[0].Session, start.Add(2*time.Hour), usage.ReleaseSession); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(hook.received()); got != 1 {
+		t.Errorf("got %d billing events after ending the session again, want 1", got)
+	}
+}
+
+func TestRetryBillingEventsOfOpenSessions(t *testing.T) {
+	ctx := context.Background()
+	hook := &billingWebhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	w := newBillingWorker(t, srv.URL)
+	s := model.Session{App: "cf-1", User: "a@example.com", StartedAt: time.Now().Add(-time.Hour)}
+	if err := usage.StartSession(ctx, w.store, s); err != nil {
+		t.Fatal(err)
+	}
+
+	// ending the session failed after its event was queued
+	end := time.Now()
+	queued := s
+	queued.EndedAt = &end
+	if err := w.store.Put(ctx, usage.PendingBillingKey(&queued), queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.retryBillingEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(hook.received()); got != 0 {
+		t.Fatalf("got %d billing events of a session that didn't end, want 0", got)
+	}
+
+	if _, err := usage.EndSession(ctx, w.store, "cf-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.retryBillingEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(hook.received()); got != 1 {
+		t.Errorf("got %d billing events once the session ended, want 1", got)
+	}
+}
+
+func TestRetryBillingEventsWithoutWebhook(t *testing.T) {
+	ctx := context.Background()
+	w := newBillingWorker(t, "")
+
+	if err := usage.StartSession(ctx, w.store, model.Session{App: "cf-1", StartedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := usage.EndSession(ctx, w.store, "cf-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.retryBillingEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := pendingBilling(t, w.store); len(keys) != 0 {
+		t.Errorf("pending billing events = %v without a webhook, want them dropped", keys)
+	}
+}
@@ -0,0 +1,37 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/jingweno/codeface/health"
+	"github.com/jingweno/codeface/middleware"
+)
+
+// serveHealth serves the liveness and the readiness of the worker until
+// it's stopped. Sharded workers report whether they lead.
+func (w *Worker) serveHealth(ctx context.Context) {
+	checker := &health.Checker{Store: w.store, Provider: w.provider}
+	if w.shards != nil {
+		checker.Leader = func() *bool {
+			leader := atomic.LoadInt32(&w.leading) == 1
+			return &leader
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.HandleHealthz)
+	mux.HandleFunc("/readyz", checker.HandleReadyz)
+	server := middleware.Server(":"+w.cfg.HealthPort, mux)
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	w.logger.Infof("Serving health checks on %s", w.cfg.HealthPort)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		w.logger.WithError(err).Info("Fail to serve health checks")
+	}
+}
@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/feature"
+	"github.com/jingweno/codeface/mail"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/notify"
+	"github.com/jingweno/codeface/slack"
+	"github.com/jingweno/codeface/store"
+)
+
+// Ways to tell whether a claimed editor is in use, see
+// Config.IdleDetection.
+const (
+	idleDetectionFormation = "formation"
+	idleDetectionRouter    = "router"
+)
+
+// routerIdle returns whether the editor of a session is told to be in use
+// by the requests it served, which is ramped up with a feature flag before
+// IDLE_DETECTION is set to router. Requests are only drained with a
+// RouterDrainURL.
+func (w *Worker) routerIdle(ctx context.Context, s model.Session) bool {
+	if w.cfg.IdleDetection == idleDetectionRouter {
+		return true
+	}
+
+	return w.cfg.RouterDrainURL != "" && w.flags.Enabled(ctx, feature.RouterIdleDetection, s.Template, s.User)
+}
+
+// active returns whether a claimed editor served a request within the idle
+// timeout. It's only known with router idle detection, otherwise editors
+// count as in use while their dyno is up.
+func (w *Worker) active(ctx context.Context, s model.Session, now time.Time) (bool, error) {
+	if !w.routerIdle(ctx, s) {
+		return false, nil
+	}
+
+	last, err := editor.LastRequest(ctx, w.store, s.App)
+	if err != nil {
+		return false, err
+	}
+
+	return now.Sub(last) < w.cfg.IdleTimeout, nil
+}
+
+// warnExpiring leaves a warning for the agent of an editor to show that
+// its session is about to expire.
+func (w *Worker) warnExpiring(ctx context.Context, s model.Session, expiresAt, now time.Time) error {
+	msg := fmt.Sprintf("This editor reaches its maximum session duration and is released at %s.", expiresAt.UTC().Format("15:04 MST"))
+	if s.User != model.GuestUser && w.routerIdle(ctx, s) {
+		msg = fmt.Sprintf("This editor reaches its maximum session duration at %s and is released once it's idle after that.", expiresAt.UTC().Format("15:04 MST"))
+	}
+
+	if err := w.store.Put(ctx, editor.IdleWarningKey(s.App), model.IdleWarning{
+		Editor:    s.App,
+		Message:   msg,
+		ReleaseAt: expiresAt,
+		WarnedAt:  now,
+	}); err != nil {
+		return err
+	}
+
+	return w.notify(ctx, s, model.NotificationEditorExpiring, msg, &expiresAt)
+}
+
+// warnIdle leaves a warning for the agent of an expired editor that's
+// released once it's idle for the idle timeout, which the user can keep
+// alive from the editor. Warnings of editors that are used again are taken
+// back.
+func (w *Worker) warnIdle(ctx context.Context, s model.Session, now time.Time) error {
+	last, err := editor.LastRequest(ctx, w.store, s.App)
+	if err != nil {
+		return err
+	}
+	releaseAt := last.Add(w.cfg.IdleTimeout)
+
+	var iw model.IdleWarning
+	err = w.store.Get(ctx, editor.IdleWarningKey(s.App), &iw)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	warned := err == nil
+
+	if warned && iw.KeepAlive && iw.ReleaseAt.Equal(releaseAt) {
+		return nil
+	}
+
+	if now.Before(releaseAt.Add(-w.cfg.IdleWarnBefore)) {
+		if warned {
+			return w.store.Delete(ctx, editor.IdleWarningKey(s.App))
+		}
+		return nil
+	}
+
+	w.logger.WithField("app", s.App).WithField("release_at", releaseAt).Info("Warning idle editor")
+
+	msg := fmt.Sprintf("This editor is idle and is released at %s unless you keep working in it.", releaseAt.UTC().Format("15:04 MST"))
+	if err := w.store.Put(ctx, editor.IdleWarningKey(s.App), model.IdleWarning{
+		Editor:    s.App,
+		Message:   msg,
+		ReleaseAt: releaseAt,
+		KeepAlive: true,
+		WarnedAt:  now,
+	}); err != nil {
+		return err
+	}
+
+	return w.notify(ctx, s, model.NotificationEditorIdle, msg, &releaseAt)
+}
+
+// newNotifier returns the deliverer of the notifications of users, over
+// Slack with SLACK_BOT_TOKEN and over email once it's set up.
+func (w *Worker) newNotifier() (*notify.Deliverer, error) {
+	m, err := mail.New(mail.Config{
+		From:         w.cfg.MailFrom,
+		SMTPAddr:     w.cfg.SMTPAddr,
+		SMTPUsername: w.cfg.SMTPUsername,
+		SMTPPassword: w.cfg.SMTPPassword,
+		SESRegion:    w.cfg.MailSESRegion,
+	})
+
+	d := &notify.Deliverer{Store: w.store, Mail: m, Logger: w.logger}
+	if w.cfg.SlackBotToken != "" {
+		d.Slack = &slack.Client{Token: w.cfg.SlackBotToken}
+	}
+
+	return d, err
+}
+
+// notify tells the user of a session about its editor the way they chose,
+// guests have nobody to tell.
+func (w *Worker) notify(ctx context.Context, s model.Session, typ, msg string, releaseAt *time.Time) error {
+	if s.User == model.GuestUser {
+		return nil
+	}
+
+	return w.notifier.Deliver(ctx, model.Notification{
+		Type:      typ,
+		User:      s.User,
+		Editor:    s.App,
+		Message:   msg,
+		ReleaseAt: releaseAt,
+	})
+}
@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// keepWarmPingTimeout is how long a warm editor has to answer a ping
+	keepWarmPingTimeout = 30 * time.Second
+	// maxKeepWarmFailures is how many pings in a row a warm editor may
+	// fail before it's scaled down and another one is warmed instead
+	maxKeepWarmFailures = 3
+)
+
+// nextPing returns when a warm editor is pinged next, somewhere in the
+// second half of KeepWarmInterval, so that the pings of editors warmed
+// together spread out instead of hitting the Heroku router at once.
+func (w *Worker) nextPing(now time.Time) time.Time {
+	half := w.cfg.KeepWarmInterval / 2
+	jitter := time.Duration(now.UnixNano() % int64(half+1))
+
+	return now.Add(half + jitter)
+}
+
+// warmEditors returns the warm editors of the template of the pool.
+func (w *Worker) warmEditors(ctx context.Context) ([]model.WarmEditor, error) {
+	keys, err := w.store.List(ctx, editor.WarmEditorKey(""))
+	if err != nil {
+		return nil, err
+	}
+
+	var eds []model.WarmEditor
+	for _, key := range keys {
+		var we model.WarmEditor
+		err := w.store.Get(ctx, key, &we)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if we.Template == w.poolTemplate() {
+			eds = append(eds, we)
+		}
+	}
+
+	return eds, nil
+}
+
+// keepWarm keeps KeepWarmEditors idle Heroku editors of the pool running
+// and pings the ones that are due. Editors that are claimed or deleted
+// stop being warm, and their places are taken by other idle editors. The
+// dyno hours of warm editors are added to the KeepWarmUsage of the day
+// they're accounted on.
+func (w *Worker) keepWarm(ctx context.Context, now time.Time) error {
+	if !provider.Has(w.provider, provider.Heroku) {
+		return nil
+	}
+
+	warm, err := w.warmEditors(ctx)
+	if err != nil {
+		return err
+	}
+	if w.cfg.KeepWarmEditors == 0 && len(warm) == 0 {
+		return nil
+	}
+
+	currentVersion, _, err := w.provider.Pool(ctx)
+	if err != nil {
+		return err
+	}
+	idle := make(map[string]provider.Editor)
+	for _, ed := range currentVersion {
+		if ed.Provider == provider.Heroku {
+			idle[ed.Name] = ed
+		}
+	}
+
+	day := now.UTC().Format(usage.DayFormat)
+	u := model.KeepWarmUsage{Day: day}
+	if err := w.store.Get(ctx, editor.KeepWarmUsageKey(day), &u); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	kept := 0
+	isWarm := make(map[string]bool)
+	for _, we := range warm {
+		isWarm[we.Editor] = true
+		logger := w.logger.WithField("app", we.Editor)
+
+		if now.After(we.AccountedAt) {
+			u.DynoHours += now.Sub(we.AccountedAt).Hours()
+			we.AccountedAt = now
+		}
+
+		ed, ok := idle[we.Editor]
+		if !ok {
+			// claimed editors keep running for their users, deleted ones
+			// are gone
+			if err := w.store.Delete(ctx, editor.WarmEditorKey(we.Editor)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// pools that got fewer warm editors scale the rest down
+		if kept >= w.cfg.KeepWarmEditors {
+			logger.Info("Scaling down warm editor")
+			if err := w.coolDown(ctx, we.Editor); err != nil {
+				logger.WithError(err).Info("Fail to scale down warm editor")
+			}
+			continue
+		}
+
+		if !now.Before(we.NextPingAt) {
+			u.Pings++
+			if err := pingEditor(ctx, ed.URL); err != nil {
+				u.FailedPings++
+				we.Failures++
+				logger.WithError(err).WithField("failures", we.Failures).Info("Fail to ping warm editor")
+			} else {
+				pinged := now
+				we.PingedAt = &pinged
+				we.Failures = 0
+			}
+			we.NextPingAt = w.nextPing(now)
+		}
+
+		if we.Failures >= maxKeepWarmFailures {
+			logger.Info("Scaling down warm editor that doesn't answer pings")
+			if err := w.coolDown(ctx, we.Editor); err != nil {
+				logger.WithError(err).Info("Fail to scale down warm editor")
+			}
+			continue
+		}
+
+		if err := w.store.Put(ctx, editor.WarmEditorKey(we.Editor), we); err != nil {
+			return err
+		}
+		kept++
+	}
+
+	for _, ed := range currentVersion {
+		if kept >= w.cfg.KeepWarmEditors {
+			break
+		}
+		if ed.Provider != provider.Heroku || isWarm[ed.Name] {
+			continue
+		}
+
+		logger := w.logger.WithField("app", ed.Name)
+		logger.Info("Warming idle editor")
+		if err := editor.ScaleApp(ctx, w.heroku, ed.Name, 1); err != nil {
+			logger.WithError(err).Info("Fail to warm idle editor")
+			continue
+		}
+
+		// the first ping is spread over the interval, it wakes the dyno up
+		// if it didn't start yet
+		if err := w.store.Put(ctx, editor.WarmEditorKey(ed.Name), model.WarmEditor{
+			Editor:      ed.Name,
+			Template:    w.poolTemplate(),
+			WarmedAt:    now,
+			NextPingAt:  now.Add(time.Duration(now.UnixNano() % int64(w.cfg.KeepWarmInterval+1))),
+			AccountedAt: now,
+		}); err != nil {
+			return err
+		}
+		kept++
+	}
+
+	if err := w.store.Put(ctx, editor.KeepWarmUsageKey(day), u); err != nil {
+		return err
+	}
+
+	w.logger.WithFields(log.Fields{
+		"template":     w.poolTemplate(),
+		"warm":         kept,
+		"dyno_hours":   u.DynoHours,
+		"pings":        u.Pings,
+		"failed_pings": u.FailedPings,
+	}).Info("Kept editors warm")
+
+	return nil
+}
+
+// coolDown scales a warm editor back down and stops keeping it warm.
+func (w *Worker) coolDown(ctx context.Context, name string) error {
+	if err := editor.ScaleApp(ctx, w.heroku, name, 0); err != nil {
+		return err
+	}
+
+	return w.store.Delete(ctx, editor.WarmEditorKey(name))
+}
+
+// pingEditor requests the page of an editor, which counts as traffic for
+// the sleep of eco dynos. Any answer short of a server error means it's up.
+func pingEditor(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, keepWarmPingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("error: editor at %s answered status=%d", url, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// maintenanceWindow is a daily or weekly window in UTC, e.g. 02:00-04:00 or
+// Sun 02:00-04:00. Windows that end before they start run past midnight.
+type maintenanceWindow struct {
+	spec    string
+	weekday *time.Weekday
+	start   time.Duration
+	end     time.Duration
+}
+
+func parseMaintenanceWindows(specs []string) ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		mw := maintenanceWindow{spec: spec}
+
+		fields := strings.Fields(spec)
+		if len(fields) == 2 {
+			day := strings.ToLower(fields[0])
+			if len(day) > 3 {
+				day = day[:3]
+			}
+
+			wd, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("error: invalid weekday in maintenance window %q", spec)
+			}
+			mw.weekday = &wd
+			fields = fields[1:]
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("error: invalid maintenance window %q, expected [weekday] hh:mm-hh:mm", spec)
+		}
+
+		times := strings.SplitN(fields[0], "-", 2)
+		if len(times) != 2 {
+			return nil, fmt.Errorf("error: invalid maintenance window %q, expected [weekday] hh:mm-hh:mm", spec)
+		}
+
+		var err error
+		if mw.start, err = parseClock(times[0]); err != nil {
+			return nil, fmt.Errorf("error: invalid maintenance window %q: %w", spec, err)
+		}
+		if mw.end, err = parseClock(times[1]); err != nil {
+			return nil, fmt.Errorf("error: invalid maintenance window %q: %w", spec, err)
+		}
+		if mw.end <= mw.start {
+			mw.end += 24 * time.Hour
+		}
+
+		windows = append(windows, mw)
+	}
+
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// at returns the bounds of the window that now is in, if any.
+func (mw maintenanceWindow) at(now time.Time) (start, end time.Time, ok bool) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	// windows past midnight may have started the day before
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if mw.weekday != nil && day.Weekday() != *mw.weekday {
+			continue
+		}
+
+		start, end = day.Add(mw.start), day.Add(mw.end)
+		if !now.Before(start) && now.Before(end) {
+			return start, end, true
+		}
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
+// runMaintenance recycles the idle editors of the pool during maintenance
+// windows. At the start of a window the newest editor image is fetched and
+// the idle editors are recorded. They are then deleted a batch per check,
+// and replaced by maintainPool with editors deployed from the newest image.
+// A summary is reported once all of them are recycled or the window closes.
+func (w *Worker) runMaintenance(ctx context.Context) error {
+	if len(w.maintenanceWindows) == 0 {
+		return nil
+	}
+
+	var m model.Maintenance
+	if err := w.store.Get(ctx, w.key(editor.MaintenanceKey), &m); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	now := time.Now()
+	if m.CompletedAt == nil && !m.StartedAt.IsZero() {
+		if !now.Before(m.WindowEnd) {
+			m.Skipped = append(m.Skipped, m.Pending...)
+			m.Pending = nil
+			return w.completeMaintenance(ctx, &m, now)
+		}
+
+		return w.continueMaintenance(ctx, &m, now)
+	}
+
+	for _, mw := range w.maintenanceWindows {
+		start, end, ok := mw.at(now)
+		if !ok || !m.WindowStart.Before(start) {
+			continue
+		}
+
+		return w.startMaintenance(ctx, mw, start, end, now)
+	}
+
+	return nil
+}
+
+func (w *Worker) startMaintenance(ctx context.Context, mw maintenanceWindow, start, end, now time.Time) error {
+	logger := w.logger.WithField("window", mw.spec)
+	logger.Info("Starting pool maintenance")
+
+	m := model.Maintenance{
+		Window:      mw.spec,
+		WindowStart: start,
+		WindowEnd:   end,
+		StartedAt:   now,
+		Pending:     []string{},
+	}
+
+	if u, ok := w.provider.(provider.Updater); ok {
+		if err := u.Update(ctx); err != nil {
+			// editors are still recycled onto whatever image the provider has
+			logger.WithError(err).Info("Fail to update editor image")
+			m.UpdateError = err.Error()
+		}
+	}
+
+	currentVersion, otherVersion, err := w.provider.Pool(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ed := range append(currentVersion, otherVersion...) {
+		m.Pending = append(m.Pending, ed.Name)
+	}
+
+	if err := w.store.Put(ctx, w.key(editor.MaintenanceKey), m); err != nil {
+		return err
+	}
+
+	return w.continueMaintenance(ctx, &m, now)
+}
+
+func (w *Worker) continueMaintenance(ctx context.Context, m *model.Maintenance, now time.Time) error {
+	currentVersion, otherVersion, err := w.provider.Pool(ctx)
+	if err != nil {
+		return err
+	}
+
+	idle := make(map[string]provider.Editor)
+	for _, ed := range append(currentVersion, otherVersion...) {
+		idle[ed.Name] = ed
+	}
+
+	n := w.cfg.BatchSize
+	for n > 0 && len(m.Pending) > 0 {
+		name := m.Pending[0]
+		m.Pending = m.Pending[1:]
+
+		ed, ok := idle[name]
+		if !ok {
+			m.Skipped = append(m.Skipped, name)
+			continue
+		}
+
+		p := provider.Lookup(w.provider, ed.Provider)
+		if p == nil {
+			p = w.provider
+		}
+
+		w.logger.WithFields(log.Fields{"app": name, "window": m.Window}).Info("Recycling idle editor")
+		if err := p.Delete(ctx, name); err != nil {
+			w.logger.WithError(err).WithField("app", name).Info("Fail to recycle idle editor")
+			m.Failed = append(m.Failed, name)
+		} else {
+			m.Recycled = append(m.Recycled, name)
+		}
+		n--
+	}
+
+	if len(m.Pending) == 0 {
+		return w.completeMaintenance(ctx, m, now)
+	}
+
+	return w.store.Put(ctx, w.key(editor.MaintenanceKey), m)
+}
+
+func (w *Worker) completeMaintenance(ctx context.Context, m *model.Maintenance, now time.Time) error {
+	m.CompletedAt = &now
+	if err := w.store.Put(ctx, w.key(editor.MaintenanceKey), m); err != nil {
+		return err
+	}
+
+	w.logger.WithFields(log.Fields{
+		"window":   m.Window,
+		"recycled": len(m.Recycled),
+		"failed":   len(m.Failed),
+		"skipped":  len(m.Skipped),
+		"duration": now.Sub(m.StartedAt),
+	}).Info("Completed pool maintenance")
+
+	if w.cfg.MaintenanceWebhookURL == "" {
+		return nil
+	}
+
+	c := &webhook.Client{
+		URL:     w.cfg.MaintenanceWebhookURL,
+		Secret:  w.cfg.MaintenanceWebhookSecret,
+		Timeout: 10 * time.Second,
+	}
+
+	if err := c.Send(ctx, "maintenance.completed", m); err != nil {
+		w.logger.WithError(err).Info("Fail to send maintenance summary")
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+// logMetrics logs the Heroku API calls of the worker by operation every
+// MetricsLogInterval, since the worker serves no HTTP to be scraped.
+// Counts are since the worker started.
+func (w *Worker) logMetrics(ctx context.Context) {
+	t := time.NewTicker(w.cfg.MetricsLogInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			for _, s := range metrics.Heroku.Summaries() {
+				w.logger.WithFields(log.Fields{
+					"operation": s.Operation,
+					"count":     s.Count,
+					"p50":       s.P50,
+					"p95":       s.P95,
+					"429":       s.Classes[metrics.ClassRateLimited],
+					"4xx":       s.Classes[metrics.ClassClient],
+					"5xx":       s.Classes[metrics.ClassServer],
+					"network":   s.Classes[metrics.ClassNetwork],
+				}).Info("Heroku API calls")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serveDebug serves the profiles and the runtime stats of the worker until
+// it's stopped.
+func (w *Worker) serveDebug(ctx context.Context) {
+	server := middleware.Server(":"+w.cfg.DebugPort, middleware.Harden(metrics.DebugHandler(w.cfg.DebugToken), middleware.Options{
+		FrameOptions: "DENY",
+	}, w.logger))
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	w.logger.Infof("Serving diagnostics on %s", w.cfg.DebugPort)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		w.logger.WithError(err).Info("Fail to serve diagnostics")
+	}
+}
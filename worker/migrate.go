@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrateSuspended moves the workspace snapshots of editors of older
+// versions that are suspended onto editors of the current version, so
+// that they're restored on the current version when they're resumed
+// instead of being left behind by the rollover. Up to BatchSize editors
+// are migrated per check.
+func (w *Worker) migrateSuspended(ctx context.Context) error {
+	if w.cfg.MigrateSnapshotsWithin == 0 {
+		return nil
+	}
+
+	p := provider.Lookup(w.provider, provider.Heroku)
+	if p == nil {
+		return nil
+	}
+
+	sessions, err := usage.Sessions(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	n := 0
+	for _, s := range sessions {
+		if n >= w.cfg.BatchSize {
+			break
+		}
+
+		if !s.Suspended || provider.OfSession(s) != provider.Heroku || editor.IsCurrentVersionClaimedApp(s.App) {
+			continue
+		}
+
+		var susp model.Suspension
+		if err := w.store.Get(ctx, editor.SuspensionKey(s.App), &susp); err != nil {
+			if err != store.ErrNotFound {
+				w.logger.WithError(err).WithField("app", s.App).Info("Fail to get suspension")
+			}
+			continue
+		}
+		if susp.State != model.SuspensionStateSuspended || susp.Snapshot == "" || susp.SuspendedAt == nil || now.Sub(*susp.SuspendedAt) > w.cfg.MigrateSnapshotsWithin {
+			continue
+		}
+
+		// deleted editors are purged instead
+		var del model.Deletion
+		if err := w.store.Get(ctx, editor.DeletionKey(s.App), &del); err == nil {
+			continue
+		}
+
+		n++
+		if err := w.migrateEditor(ctx, p, s, susp, now); err != nil {
+			w.logger.WithError(err).WithField("app", s.App).Info("Fail to migrate suspended editor")
+		}
+	}
+
+	return nil
+}
+
+// migrateEditor claims an editor of the current version for the owner of
+// a suspended editor and suspends it with the snapshot of the workspace.
+// The config vars set when the old editor was claimed are carried over,
+// including its agent token.
+func (w *Worker) migrateEditor(ctx context.Context, p provider.Provider, s model.Session, susp model.Suspension, now time.Time) error {
+	sp, ok := p.(provider.Suspender)
+	if !ok {
+		return fmt.Errorf("error: editors on %s can't be suspended", p.Name())
+	}
+
+	logger := w.logger.WithFields(log.Fields{"app": s.App, "user": s.User})
+
+	ed, err := p.Claim(ctx, editor.ClaimOptions{
+		Template:  s.Template,
+		Recipient: s.User,
+	})
+	if err != nil {
+		return err
+	}
+	logger = logger.WithField("to", ed.Name)
+
+	// the editor that took the place of the old one is released if the
+	// migration fails, the old one stays suspended
+	release := func() {
+		if err := p.Delete(ctx, ed.Name); err != nil {
+			logger.WithError(err).Info("Fail to release editor")
+		}
+	}
+
+	vars, err := editor.CopyConfigVars(ctx, w.heroku, s.App, ed.Name)
+	if err != nil {
+		release()
+		return err
+	}
+
+	if err := sp.Suspend(ctx, ed.Name); err != nil {
+		release()
+		return err
+	}
+
+	// the token copied over may have been rotated, e.g. by a transfer, so
+	// the agent of the editor gets a new one
+	if token := vars["CF_AGENT_TOKEN"]; token != nil && *token != "" {
+		w.renewAgentToken(ctx, ed.Name, logger)
+	}
+
+	susp.Editor = ed.Name
+	if err := w.store.Put(ctx, editor.SuspensionKey(ed.Name), susp); err != nil {
+		release()
+		return err
+	}
+
+	if _, err := usage.MoveSession(ctx, w.store, s.App, ed.Name); err != nil {
+		return err
+	}
+
+	if err := w.store.Put(ctx, editor.MigrationKey(s.App), model.Migration{
+		Editor:     s.App,
+		To:         ed.Name,
+		User:       s.User,
+		MigratedAt: now,
+	}); err != nil {
+		logger.WithError(err).Info("Fail to save migration")
+	}
+
+	if err := w.store.Delete(ctx, editor.SuspensionKey(s.App)); err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to delete suspension")
+	}
+
+	logger.Info("Migrated suspended editor to the current version")
+
+	return p.Delete(ctx, s.App)
+}
+
+func (w *Worker) renewAgentToken(ctx context.Context, name string, logger log.FieldLogger) {
+	token, err := agent.NewToken()
+	if err != nil {
+		logger.WithError(err).Info("Fail to generate agent token")
+		return
+	}
+
+	if _, err := w.heroku.ConfigVarUpdate(ctx, name, map[string]*string{
+		"CF_AGENT_TOKEN": &token,
+	}); err != nil {
+		logger.WithError(err).Info("Fail to set agent token")
+		return
+	}
+
+	if err := agent.Register(ctx, w.store, token, name); err != nil {
+		logger.WithError(err).Info("Fail to register agent")
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+)
+
+// replaceUnpromotedApps replaces the idle editors that aren't on the
+// promoted artifact of the template, e.g. after a rollback. They're deleted
+// a batch per check and promoted again by maintainPool.
+func (w *Worker) replaceUnpromotedApps(ctx context.Context) error {
+	if !w.cfg.PromoteArtifacts {
+		return nil
+	}
+
+	template := editor.TemplateName(w.cfg.TemplateDir)
+	promo, err := artifact.Promotion(ctx, w.store, w.cfg.Environment, template)
+	if err == store.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name := provider.Heroku
+	if w.cfg.ArtifactStore == artifact.OCI {
+		name = provider.Docker
+	}
+
+	p := provider.Lookup(w.provider, name)
+	if p == nil {
+		return nil
+	}
+
+	// docker editors are labeled with the image of their artifact
+	var image string
+	if name == provider.Docker {
+		art, err := artifact.Get(ctx, w.store, template, promo.Version)
+		if err != nil {
+			return err
+		}
+		image = art.Ref
+	}
+
+	currentVersion, _, err := p.Pool(ctx)
+	if err != nil {
+		return err
+	}
+
+	n := w.cfg.BatchSize
+	for _, ed := range currentVersion {
+		if n == 0 {
+			break
+		}
+
+		if name == provider.Docker {
+			if ed.Template == image {
+				continue
+			}
+		} else {
+			version, err := editor.AppArtifact(ctx, w.heroku, ed.Name)
+			if err != nil {
+				w.logger.WithError(err).WithField("app", ed.Name).Info("Fail to get app artifact")
+				continue
+			}
+			if version == promo.Version {
+				continue
+			}
+		}
+
+		w.logger.WithField("app", ed.Name).WithField("version", promo.Version).Info("Replacing idle editor with promoted artifact")
+		if err := p.Delete(ctx, ed.Name); err != nil {
+			w.logger.WithError(err).WithField("app", ed.Name).Info("Fail to delete app")
+			continue
+		}
+		n--
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/store"
+)
+
+// poolTemplate is the template of the pool the worker maintains.
+func (w *Worker) poolTemplate() string {
+	if w.cfg.TemplateGitURL != "" {
+		return editor.GitTemplateName(w.cfg.TemplateGitURL)
+	}
+
+	return editor.TemplateName(w.cfg.TemplateDir)
+}
+
+// poolQuarantined returns whether enough deploys of the template of the
+// pool are quarantined that it's likely broken, in which case no more
+// editors are deployed to the pool until they're purged.
+func (w *Worker) poolQuarantined(ctx context.Context) (bool, error) {
+	if w.cfg.QuarantineDuration == 0 || w.cfg.QuarantineMaxDeploys == 0 {
+		return false, nil
+	}
+
+	qs, err := editor.Quarantined(ctx, w.store)
+	if err != nil {
+		return false, err
+	}
+
+	template, n := w.poolTemplate(), 0
+	for _, q := range qs {
+		if q.Template == template {
+			n++
+		}
+	}
+
+	return n >= w.cfg.QuarantineMaxDeploys, nil
+}
+
+// purgeQuarantine deletes the apps of quarantined deploys once they
+// expire.
+func (w *Worker) purgeQuarantine(ctx context.Context) error {
+	qs, err := editor.Quarantined(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, q := range qs {
+		if now.Before(q.ExpiresAt) {
+			continue
+		}
+
+		w.logger.WithField("app", q.App).Info("Quarantine expired")
+		editor.DeleteApp(w.heroku, &heroku.App{Name: q.App}, w.logger)
+
+		if err := w.store.Delete(ctx, editor.QuarantineKey(q.App)); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Modes of the reconciliation on start.
+const (
+	reconcileOff    = "off"
+	reconcileReport = "report"
+	reconcileRepair = "repair"
+)
+
+// reconcileGrace is how long apps are left alone after they changed, so
+// that deploys and claims in progress on other replicas or the server
+// aren't taken for leftovers.
+const reconcileGrace = time.Hour
+
+// repairReport sums up what the reconciliation found, and fixed unless it
+// only reports.
+type repairReport struct {
+	Relabeled  []string
+	ScaledDown []string
+	Adopted    []string
+	Deleted    []string
+}
+
+// reconcile compares the apps of the Heroku account with the state store
+// and repairs what's inconsistent, e.g. after a crash in the middle of a
+// claim or a deploy:
+//
+//   - idle apps owned by a user are renamed as claimed
+//   - idle apps that are running are scaled down
+//   - running claimed apps owned by a user without a session are adopted
+//     by starting a session for their owner
+//   - apps of the pool account with a codeface name that aren't idle,
+//     claimed, a preview or a builder, or are claimed by nobody, are
+//     deleted, unless their deploy is quarantined
+func (w *Worker) reconcile(ctx context.Context) error {
+	if w.cfg.Reconcile == reconcileOff || !provider.Has(w.provider, provider.Heroku) {
+		return nil
+	}
+	repair := w.cfg.Reconcile == reconcileRepair
+
+	acct, err := editor.Account(ctx, w.heroku)
+	if err != nil {
+		return err
+	}
+
+	apps, err := editor.AllApps(ctx, w.heroku)
+	if err != nil {
+		return err
+	}
+
+	var report repairReport
+	now := time.Now()
+	for _, app := range apps {
+		app := app
+		if !strings.HasPrefix(app.Name, "cf-") || now.Sub(app.UpdatedAt) < reconcileGrace {
+			continue
+		}
+
+		logger := w.logger.WithFields(log.Fields{"app": app.Name, "owner": app.Owner.Email})
+		pooled := app.Owner.ID == acct.ID
+
+		idle, _ := editor.IsIdleApp(app.Name)
+		switch {
+		case idle && !pooled:
+			name, _ := editor.ClaimedAppName(app.Name)
+			logger.WithField("to", name).Info("Idle app is owned by a user, relabeling it as claimed")
+			report.Relabeled = append(report.Relabeled, app.Name)
+			if !repair {
+				continue
+			}
+
+			if _, err := w.heroku.AppUpdate(ctx, app.Name, heroku.AppUpdateOpts{Name: &name}); err != nil {
+				logger.WithError(err).Info("Fail to relabel app")
+				continue
+			}
+			app.Name = name
+			if err := w.adopt(ctx, app, now, repair, &report, logger); err != nil {
+				logger.WithError(err).Info("Fail to adopt editor")
+			}
+		case idle:
+			running, err := w.running(ctx, app.Name)
+			if err != nil {
+				logger.WithError(err).Info("Fail to get formation")
+				continue
+			}
+			if !running {
+				continue
+			}
+
+			logger.Info("Idle app is running, scaling it down")
+			report.ScaledDown = append(report.ScaledDown, app.Name)
+			if repair {
+				if err := editor.ScaleApp(ctx, w.heroku, app.Name, 0); err != nil {
+					logger.WithError(err).Info("Fail to scale down app")
+				}
+			}
+		case editor.IsClaimedApp(app.Name) && !pooled:
+			if err := w.adopt(ctx, app, now, repair, &report, logger); err != nil {
+				logger.WithError(err).Info("Fail to adopt editor")
+			}
+		case editor.IsPreviewApp(app.Name) || editor.IsBuilderApp(app.Name):
+		case pooled:
+			var q model.Quarantine
+			if err := w.store.Get(ctx, editor.QuarantineKey(app.Name), &q); err == nil {
+				continue
+			}
+
+			// claimed apps the pool account still owns failed to be
+			// transferred
+			reason := "App is unknown, deleting it"
+			if editor.IsBuildingApp(app.Name) {
+				reason = "Deploy of app never finished, deleting it"
+			}
+			logger.Info(reason)
+			report.Deleted = append(report.Deleted, app.Name)
+			if repair {
+				editor.DeleteApp(w.heroku, &app, w.logger)
+			}
+		}
+	}
+
+	w.logger.WithFields(log.Fields{
+		"mode":        w.cfg.Reconcile,
+		"relabeled":   len(report.Relabeled),
+		"scaled_down": len(report.ScaledDown),
+		"adopted":     len(report.Adopted),
+		"deleted":     len(report.Deleted),
+	}).Info(fmt.Sprintf("Reconciled state: relabeled %v, scaled down %v, adopted %v, deleted %v", report.Relabeled, report.ScaledDown, report.Adopted, report.Deleted))
+
+	return nil
+}
+
+// adopt starts a session for the owner of a running claimed editor that
+// has none, e.g. when the server crashed before starting it.
+func (w *Worker) adopt(ctx context.Context, app heroku.App, now time.Time, repair bool, report *repairReport, logger log.FieldLogger) error {
+	var s model.Session
+	err := w.store.Get(ctx, usage.SessionKey(app.Name), &s)
+	if err == nil {
+		return nil
+	}
+	if err != store.ErrNotFound {
+		return err
+	}
+
+	running, err := w.running(ctx, app.Name)
+	if err != nil || !running {
+		return err
+	}
+
+	logger.Info("Claimed editor has no session, adopting it")
+	report.Adopted = append(report.Adopted, app.Name)
+	if !repair {
+		return nil
+	}
+
+	tmpl, err := editor.AppTemplate(ctx, w.heroku, app.Name)
+	if err != nil {
+		logger.WithError(err).Info("Fail to get app template")
+		tmpl = editor.DefaultTemplate
+	}
+
+	return usage.StartSession(ctx, w.store, model.Session{
+		App:       app.Name,
+		Provider:  provider.Heroku,
+		User:      app.Owner.Email,
+		Template:  tmpl,
+		StartedAt: now,
+	})
+}
+
+func (w *Worker) running(ctx context.Context, appName string) (bool, error) {
+	f, err := w.heroku.FormationInfo(ctx, appName, "web")
+	if err != nil {
+		return false, err
+	}
+
+	return f.Quantity > 0, nil
+}
@@ -0,0 +1,377 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	"github.com/jingweno/codeface/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+// recycleGrace is how long the agent of an expired editor has to save the
+// workspace before the editor is released anyway.
+const recycleGrace = 15 * time.Minute
+
+// wipPushGrace is how long the agent of an expired editor has to push its
+// work before the editor is released anyway.
+const wipPushGrace = 5 * time.Minute
+
+func recycleKey(appName string) string {
+	return "recycles/" + appName
+}
+
+// recycle is the progress of releasing an editor that reached its maximum
+// session duration.
+type recycle struct {
+	WarnedAt time.Time
+	// WarnedFor is the expiry that was warned about, sessions extended
+	// after the warning are warned again
+	WarnedFor           time.Time
+	WIPPushRequestedAt  time.Time
+	SnapshotRequestedAt time.Time
+}
+
+type sessionEvent struct {
+	Session   model.Session
+	ExpiresAt time.Time
+}
+
+// parseSessionLimits parses template=duration pairs.
+func parseSessionLimits(pairs []string) (map[string]time.Duration, error) {
+	limits := make(map[string]time.Duration)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("error: invalid session duration %q, expected template=duration", pair)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid session duration %q: %w", pair, err)
+		}
+
+		limits[strings.TrimSpace(kv[0])] = d
+	}
+
+	return limits, nil
+}
+
+func (w *Worker) sessionLimit(template string) time.Duration {
+	if d, ok := w.sessionLimits[template]; ok {
+		return d
+	}
+
+	return w.cfg.MaxSessionDuration
+}
+
+// sessionExpiry returns when the session of an editor claimed at claimedAt
+// reaches its limit, which its user may have extended. It's kept in the
+// store for the user to see from the editor.
+func (w *Worker) sessionExpiry(ctx context.Context, appName string, claimedAt time.Time, limit time.Duration) (time.Time, error) {
+	expiresAt := claimedAt.Add(limit)
+
+	var ext model.SessionExtension
+	err := w.store.Get(ctx, editor.SessionExtensionKey(appName), &ext)
+	if err != nil && err != store.ErrNotFound {
+		return time.Time{}, err
+	}
+	// extensions of earlier claims of the editor don't count
+	if err == nil && !ext.ExtendedAt.Before(claimedAt) {
+		expiresAt = expiresAt.Add(ext.ExtendedBy)
+	}
+
+	var exp model.SessionExpiry
+	err = w.store.Get(ctx, editor.SessionExpiryKey(appName), &exp)
+	if err != nil && err != store.ErrNotFound {
+		return time.Time{}, err
+	}
+	if err == nil && exp.ClaimedAt.Equal(claimedAt) && exp.ExpiresAt.Equal(expiresAt) {
+		return expiresAt, nil
+	}
+
+	return expiresAt, w.store.Put(ctx, editor.SessionExpiryKey(appName), model.SessionExpiry{
+		Editor:    appName,
+		ClaimedAt: claimedAt,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// recycleSessions warns about and releases editors that are claimed for
+// longer than the maximum session duration of their template. Workspaces on
+// disks that don't persist are saved by the agent of the editor first.
+func (w *Worker) recycleSessions(ctx context.Context) error {
+	sessions, err := usage.Sessions(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, s := range sessions {
+		// suspended editors are still claimed
+		if s.EndedAt != nil && !s.Suspended {
+			continue
+		}
+
+		limit, warnBefore := w.sessionLimit(s.Template), w.cfg.SessionWarnBefore
+		if s.User == model.GuestUser {
+			limit, warnBefore = w.cfg.GuestSessionDuration, w.cfg.IdleWarnBefore
+		}
+		if limit == 0 {
+			continue
+		}
+
+		claimedAt := s.ClaimedAt
+		if claimedAt.IsZero() {
+			claimedAt = s.StartedAt
+		}
+		expiresAt, err := w.sessionExpiry(ctx, s.App, claimedAt, limit)
+		if err != nil {
+			w.logger.WithError(err).WithField("app", s.App).Info("Fail to get session expiry")
+			continue
+		}
+
+		if now.Before(expiresAt.Add(-warnBefore)) {
+			continue
+		}
+
+		if err := w.recycleSession(ctx, s, expiresAt, now); err != nil {
+			w.logger.WithError(err).WithField("app", s.App).Info("Fail to recycle session")
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) recycleSession(ctx context.Context, s model.Session, expiresAt, now time.Time) error {
+	logger := w.logger.WithFields(log.Fields{"app": s.App, "user": s.User})
+
+	var rec recycle
+	if err := w.store.Get(ctx, recycleKey(s.App), &rec); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	if now.Before(expiresAt) {
+		if !rec.WarnedAt.IsZero() && (rec.WarnedFor.IsZero() || rec.WarnedFor.Equal(expiresAt)) {
+			return nil
+		}
+
+		logger.WithField("expires_at", expiresAt).Info("Session is about to expire")
+		if err := w.sendSessionEvent(ctx, "session.expiring", s, expiresAt); err != nil {
+			logger.WithError(err).Info("Fail to send session event")
+		}
+
+		if err := w.warnExpiring(ctx, s, expiresAt, now); err != nil {
+			logger.WithError(err).Info("Fail to warn editor")
+		}
+
+		rec.WarnedAt = now
+		rec.WarnedFor = expiresAt
+		return w.store.Put(ctx, recycleKey(s.App), rec)
+	}
+
+	p := provider.Lookup(w.provider, provider.OfSession(s))
+	if p == nil {
+		return fmt.Errorf("error: provider %s isn't configured", provider.OfSession(s))
+	}
+
+	// editors whose owner is still working in them are released once
+	// they're idle, guest editors are released right away
+	guest := s.User == model.GuestUser
+	if !guest && !s.Suspended && rec.SnapshotRequestedAt.IsZero() && p.Name() == provider.Heroku {
+		active, err := w.active(ctx, s, now)
+		if err != nil {
+			return err
+		}
+		if active {
+			if err := w.warnIdle(ctx, s, now); err != nil {
+				logger.WithError(err).Info("Fail to warn editor")
+			}
+
+			logger.Info("Session expired but editor is in use, postponing release")
+			return nil
+		}
+	}
+
+	// the uncommitted work of running editors is pushed before their
+	// workspace is snapshotted and they're deleted
+	if w.cfg.WIPPush && !guest && !s.Suspended {
+		pushed, err := w.pushWorkInProgress(ctx, s, &rec, now)
+		if err != nil || !pushed {
+			return err
+		}
+	}
+
+	// save the workspace of running editors on disks that don't persist
+	// before releasing them, nobody comes back for the workspace of a guest
+	if !guest && !s.Suspended && !p.Capabilities().PersistentDisk {
+		if rec.SnapshotRequestedAt.IsZero() {
+			logger.Info("Session expired, requesting workspace snapshot")
+			if err := w.store.Put(ctx, editor.SuspensionKey(s.App), model.Suspension{
+				Editor:      s.App,
+				State:       model.SuspensionStateSnapshotting,
+				RequestedAt: now,
+				Snapshot:    editor.SnapshotObject(s.App, now),
+			}); err != nil {
+				return err
+			}
+
+			rec.SnapshotRequestedAt = now
+			return w.store.Put(ctx, recycleKey(s.App), rec)
+		}
+
+		var susp model.Suspension
+		err := w.store.Get(ctx, editor.SuspensionKey(s.App), &susp)
+		if err != nil && err != store.ErrNotFound {
+			return err
+		}
+
+		snapshotted := err == nil && susp.State == model.SuspensionStateSuspended
+		if !snapshotted && now.Sub(rec.SnapshotRequestedAt) < recycleGrace {
+			return nil
+		}
+		if !snapshotted {
+			logger.Info("Workspace snapshot isn't saved in time")
+		}
+	}
+
+	logger.Info("Session expired, releasing editor")
+	if err := p.Delete(ctx, s.App); err != nil {
+		return err
+	}
+
+	// suspended sessions ended when they were suspended, and their
+	// billing event is only queued then
+	ended, err := w.billSession(ctx, s, now, usage.ReleaseSession)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Your editor %s reached its maximum session duration and was released.", s.App) + w.wipPushMessage(ctx, s.App)
+	if err := w.notify(ctx, s, model.NotificationEditorReleased, msg, nil); err != nil {
+		logger.WithError(err).Info("Fail to publish notification")
+	}
+
+	if err := w.releaseSuspension(ctx, s.App); err != nil {
+		logger.WithError(err).Info("Fail to keep workspace snapshot")
+	}
+
+	// released editors that were deleted by their owner are purged already
+	if err := w.store.Delete(ctx, editor.DeletionKey(s.App)); err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to delete deletion")
+	}
+
+	if err := w.store.Delete(ctx, editor.IdleWarningKey(s.App)); err != nil && err != store.ErrNotFound {
+		logger.WithError(err).Info("Fail to delete idle warning")
+	}
+
+	w.forgetSession(ctx, s.App, logger)
+
+	if err := w.sendSessionEvent(ctx, "session.released", *ended, expiresAt); err != nil {
+		logger.WithError(err).Info("Fail to send session event")
+	}
+
+	return w.store.Delete(ctx, recycleKey(s.App))
+}
+
+// pushWorkInProgress asks the agent of an expired editor to push its
+// uncommitted work to a branch, and returns whether it's done or its time
+// is up.
+func (w *Worker) pushWorkInProgress(ctx context.Context, s model.Session, rec *recycle, now time.Time) (bool, error) {
+	logger := w.logger.WithFields(log.Fields{"app": s.App, "user": s.User})
+
+	if rec.WIPPushRequestedAt.IsZero() {
+		branch := "wip/" + s.App + "-" + now.UTC().Format("20060102-150405")
+		logger.WithField("branch", branch).Info("Session expired, requesting push of work in progress")
+		if err := w.store.Put(ctx, editor.WIPPushKey(s.App), model.WIPPush{
+			Editor:      s.App,
+			State:       model.WIPPushStateRequested,
+			Branch:      branch,
+			RequestedAt: now,
+		}); err != nil {
+			return false, err
+		}
+
+		rec.WIPPushRequestedAt = now
+		return false, w.store.Put(ctx, recycleKey(s.App), *rec)
+	}
+
+	var push model.WIPPush
+	err := w.store.Get(ctx, editor.WIPPushKey(s.App), &push)
+	if err != nil && err != store.ErrNotFound {
+		return false, err
+	}
+
+	if err == nil && push.State == model.WIPPushStateRequested {
+		if now.Sub(rec.WIPPushRequestedAt) < wipPushGrace {
+			return false, nil
+		}
+		logger.Info("Work in progress isn't pushed in time")
+	}
+
+	return true, nil
+}
+
+// wipPushMessage tells the owner of a released editor where its work was
+// pushed to.
+func (w *Worker) wipPushMessage(ctx context.Context, appName string) string {
+	var push model.WIPPush
+	if err := w.store.Get(ctx, editor.WIPPushKey(appName), &push); err != nil {
+		return ""
+	}
+
+	switch push.State {
+	case model.WIPPushStatePushed:
+		return fmt.Sprintf(" Its uncommitted work was pushed to the branch %s.", push.Branch)
+	case model.WIPPushStateFailed:
+		return fmt.Sprintf(" Its uncommitted work couldn't be pushed: %s", push.Reason)
+	case model.WIPPushStateRequested:
+		return " Its uncommitted work couldn't be pushed in time."
+	default:
+		return ""
+	}
+}
+
+// releaseSuspension keeps the snapshot of a released editor for its owner
+// to download.
+func (w *Worker) releaseSuspension(ctx context.Context, appName string) error {
+	var susp model.Suspension
+	err := w.store.Get(ctx, editor.SuspensionKey(appName), &susp)
+	if err == store.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// snapshots that weren't uploaded don't exist
+	if susp.State != model.SuspensionStateSuspended {
+		return w.store.Delete(ctx, editor.SuspensionKey(appName))
+	}
+
+	susp.State = model.SuspensionStateReleased
+	return w.store.Put(ctx, editor.SuspensionKey(appName), susp)
+}
+
+func (w *Worker) sendSessionEvent(ctx context.Context, event string, s model.Session, expiresAt time.Time) error {
+	if w.cfg.SessionWebhookURL == "" {
+		return nil
+	}
+
+	c := &webhook.Client{
+		URL:     w.cfg.SessionWebhookURL,
+		Secret:  w.cfg.SessionWebhookSecret,
+		Timeout: 10 * time.Second,
+	}
+
+	return c.Send(ctx, event, sessionEvent{
+		Session:   s,
+		ExpiresAt: expiresAt,
+	})
+}
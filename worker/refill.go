@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// parseTemplateWeights parses template=weight pairs.
+func parseTemplateWeights(pairs []string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("error: invalid template weight %q, expected template=weight", pair)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("error: invalid template weight %q, expected a positive integer", pair)
+		}
+
+		weights[strings.TrimSpace(kv[0])] = n
+	}
+
+	return weights, nil
+}
+
+// refillShards deploys editors to the pools of the owned shards that are
+// short of editors, all at once. The deploys of a check are shared among
+// the pools round-robin, in proportion to the weights of their templates,
+// so that a big deficit in one pool doesn't starve the others.
+func (w *Worker) refillShards(ctx context.Context, owned []*Worker) {
+	var templates []string
+	deficits := make(map[string]int)
+	for _, s := range owned {
+		n, err := s.poolDeficit(ctx)
+		if err != nil {
+			s.logger.WithError(err).Info("Fail to get pool deficit")
+			continue
+		}
+		// a pool gets at most the batch size of its template per check
+		if n > s.cfg.BatchSize {
+			n = s.cfg.BatchSize
+		}
+		if n > 0 {
+			templates = append(templates, s.template)
+			deficits[s.template] = n
+		}
+	}
+
+	budget := w.cfg.DeployConcurrency
+	if budget == 0 {
+		budget = w.cfg.BatchSize
+	}
+	want := 0
+	for _, n := range deficits {
+		want += n
+	}
+	if want < budget {
+		budget = want
+	}
+	budget = w.capDeploys(ctx, budget)
+
+	shares := fairShares(templates, deficits, w.templateWeights, budget, w.refillRound)
+	w.refillRound++
+
+	var deploys []*Worker
+	for _, s := range owned {
+		n := shares[s.template]
+		if n == 0 {
+			continue
+		}
+
+		s.logger.WithField("num", n).Info("Adding apps to pool")
+		for i := 0; i < n; i++ {
+			deploys = append(deploys, s)
+		}
+	}
+	w.deployAll(ctx, deploys)
+}
+
+// deployAll deploys an editor to the pool of each worker of deploys, all at
+// once, within RefillTimeout overall. The provider puts an editor in the
+// pool as soon as its own deploy is ready, so a failing or slow deploy
+// doesn't hold up the others: the ones still deploying at the deadline are
+// called off, and the ones that were ready stay in the pool all the same.
+func (w *Worker) deployAll(ctx context.Context, deploys []*Worker) {
+	if len(deploys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.RefillTimeout)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		deployed int
+		timedOut int
+	)
+	for _, s := range deploys {
+		wg.Add(1)
+		go func(s *Worker) {
+			defer wg.Done()
+
+			start := time.Now()
+			ed, err := s.provider.Deploy(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if ctx.Err() == context.DeadlineExceeded {
+					timedOut++
+				}
+				s.logger.WithError(err).Info("Fail to add app to pool")
+				return
+			}
+			deployed++
+			s.logger.WithFields(log.Fields{"app": ed.Name, "duration": time.Since(start)}).Info("Added app to pool")
+		}(s)
+	}
+	wg.Wait()
+
+	w.logger.WithFields(log.Fields{
+		"num":       len(deploys),
+		"deployed":  deployed,
+		"failed":    len(deploys) - deployed - timedOut,
+		"timed-out": timedOut,
+	}).Info("Refilled pool")
+}
+
+// fairShares shares budget deploys among templates by weighted
+// round-robin. Each round gives every template as many deploys as it
+// weighs, up to its deficit, starting from the template after the one
+// that started the previous check.
+func fairShares(templates []string, deficits, weights map[string]int, budget, round int) map[string]int {
+	shares := make(map[string]int)
+	if len(templates) == 0 {
+		return shares
+	}
+
+	for budget > 0 {
+		progressed := false
+		for i := range templates {
+			t := templates[(i+round)%len(templates)]
+
+			weight := weights[t]
+			if weight == 0 {
+				weight = 1
+			}
+			for j := 0; j < weight && budget > 0 && shares[t] < deficits[t]; j++ {
+				shares[t]++
+				budget--
+				progressed = true
+			}
+		}
+
+		if !progressed {
+			break
+		}
+	}
+
+	return shares
+}
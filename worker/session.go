@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	"github.com/jingweno/codeface/usage"
+	log "github.com/sirupsen/logrus"
+)
+
+// endSessions closes sessions of Heroku editors that are gone or scaled
+// down.
+func (w *Worker) endSessions(ctx context.Context) error {
+	sessions, err := usage.OpenSessions(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	apps, err := editor.AllClaimedApps(ctx, w.heroku)
+	if err != nil {
+		return err
+	}
+
+	claimed := make(map[string]bool)
+	for _, app := range apps {
+		claimed[app.Name] = true
+	}
+
+	for _, s := range sessions {
+		if s.Provider != "" && s.Provider != provider.Heroku {
+			continue
+		}
+
+		logger := w.logger.WithField("app", s.App)
+
+		if claimed[s.App] {
+			f, err := w.heroku.FormationInfo(ctx, s.App, "web")
+			if err != nil {
+				logger.WithError(err).Info("Fail to get formation")
+				continue
+			}
+
+			if f.Quantity > 0 {
+				continue
+			}
+		}
+
+		logger.Info("Ending session")
+		if _, err := w.billSession(ctx, s, time.Now(), usage.EndSession); err != nil {
+			logger.WithError(err).Info("Fail to end session")
+			continue
+		}
+
+		if err := w.store.Delete(ctx, editor.ActivityKey(s.App)); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).Info("Fail to delete editor activity")
+		}
+
+		w.forgetSession(ctx, s.App, logger)
+	}
+
+	return nil
+}
+
+// forgetSession deletes what the user of an editor changed about its
+// session from the editor, once the session ends.
+func (w *Worker) forgetSession(ctx context.Context, appName string, logger log.FieldLogger) {
+	for _, key := range []string{
+		editor.SessionExpiryKey(appName),
+		editor.SessionExtensionKey(appName),
+		editor.PortsKey(appName),
+		editor.WIPPushKey(appName),
+	} {
+		if err := w.store.Delete(ctx, key); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).WithField("key", key).Info("Fail to delete session state")
+		}
+	}
+}
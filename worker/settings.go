@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templateSettings override the pool settings of the config per template,
+// so that pools that are rarely claimed from aren't maintained as
+// aggressively as busy ones.
+type templateSettings struct {
+	poolSizes      map[string]int
+	batchSizes     map[string]int
+	checkIntervals map[string]time.Duration
+}
+
+func parseTemplateSettings(cfg Config) (*templateSettings, error) {
+	poolSizes, err := parseTemplateInts(cfg.PoolSizes, "pool size")
+	if err != nil {
+		return nil, err
+	}
+
+	batchSizes, err := parseTemplateInts(cfg.BatchSizes, "batch size")
+	if err != nil {
+		return nil, err
+	}
+
+	intervals := make(map[string]time.Duration)
+	for _, pair := range cfg.CheckIntervals {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("error: invalid check interval %q, expected template=duration", pair)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("error: invalid check interval %q, expected a positive duration", pair)
+		}
+
+		intervals[strings.TrimSpace(kv[0])] = d
+	}
+
+	return &templateSettings{
+		poolSizes:      poolSizes,
+		batchSizes:     batchSizes,
+		checkIntervals: intervals,
+	}, nil
+}
+
+// parseTemplateInts parses template=n pairs of what.
+func parseTemplateInts(pairs []string, what string) (map[string]int, error) {
+	values := make(map[string]int)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("error: invalid %s %q, expected template=number", what, pair)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("error: invalid %s %q, expected a number", what, pair)
+		}
+
+		values[strings.TrimSpace(kv[0])] = n
+	}
+
+	return values, nil
+}
+
+// apply returns cfg with the settings of a template.
+func (s *templateSettings) apply(cfg Config, template string) Config {
+	if n, ok := s.poolSizes[template]; ok {
+		cfg.PoolSize = n
+	}
+	if n, ok := s.batchSizes[template]; ok {
+		cfg.BatchSize = n
+	}
+	if d, ok := s.checkIntervals[template]; ok {
+		cfg.CheckInterval = d
+	}
+	// editors of single-use templates never come back to the pool
+	if singleUse(cfg, template) && cfg.SingleUseRefillFactor > 1 {
+		cfg.BatchSize *= cfg.SingleUseRefillFactor
+	}
+
+	return cfg
+}
+
+// tickInterval is how often the worker checks, which is as often as the
+// template checked the most often.
+func (w *Worker) tickInterval() time.Duration {
+	tick := w.cfg.CheckInterval
+	for _, s := range w.shards {
+		if s.cfg.CheckInterval < tick {
+			tick = s.cfg.CheckInterval
+		}
+	}
+
+	return tick
+}
+
+// due returns whether the check of the worker is due at a tick, and
+// schedules the next one if it is. Checks are scheduled half a tick early
+// so that ticks that come a little late don't skip them.
+func (w *Worker) due(now time.Time, tick time.Duration) bool {
+	if now.Before(w.nextCheck) {
+		return false
+	}
+
+	w.nextCheck = now.Add(w.cfg.CheckInterval - tick/2)
+	return true
+}
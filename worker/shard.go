@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jingweno/codeface/store"
+)
+
+const (
+	workerKeyPrefix = "workers/"
+	// globalShard is the shard of the duties that aren't per template, e.g.
+	// ending sessions
+	globalShard = ""
+)
+
+// heartbeat is a worker replica seen by the others through the store.
+type heartbeat struct {
+	ID     string
+	SeenAt time.Time
+}
+
+// listTemplates returns the templates of a directory of templates by name.
+func listTemplates(dir string) (map[string]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]string)
+	for _, fi := range fis {
+		// templates prefixed with _ are only extended by others, e.g. _base
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") && !strings.HasPrefix(fi.Name(), "_") {
+			templates[fi.Name()] = filepath.Join(dir, fi.Name())
+		}
+	}
+
+	return templates, nil
+}
+
+// replicas records the heartbeat of the worker and returns the IDs of
+// the replicas that are alive, including itself.
+func (w *Worker) replicas(ctx context.Context, now time.Time) ([]string, error) {
+	if err := w.store.Put(ctx, workerKeyPrefix+w.cfg.WorkerID, heartbeat{ID: w.cfg.WorkerID, SeenAt: now}); err != nil {
+		return nil, err
+	}
+
+	keys, err := w.store.List(ctx, workerKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// replicas that missed a few checks are gone
+	ttl := 3 * w.cfg.CheckInterval
+
+	var ids []string
+	for _, key := range keys {
+		var hb heartbeat
+		err := w.store.Get(ctx, key, &hb)
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if now.Sub(hb.SeenAt) > ttl {
+			if err := w.store.Delete(ctx, key); err != nil {
+				w.logger.WithError(err).WithField("worker", hb.ID).Info("Fail to delete heartbeat")
+			}
+			continue
+		}
+
+		ids = append(ids, hb.ID)
+	}
+
+	return ids, nil
+}
+
+// owner returns the replica that owns a shard with rendezvous hashing, so
+// that only the shards of a replica move when it joins or leaves.
+func owner(ids []string, shard string) string {
+	var (
+		best   string
+		weight uint64
+	)
+	for _, id := range ids {
+		sum := sha256.Sum256([]byte(id + "/" + shard))
+		if wt := binary.BigEndian.Uint64(sum[:8]); best == "" || wt > weight {
+			best, weight = id, wt
+		}
+	}
+
+	return best
+}
+
+// assignShards returns the templates the worker maintains the pools of,
+// and whether it runs the duties that aren't per template. Templates are
+// assigned statically with SHARD_TEMPLATES, or else hashed onto the
+// replicas that are alive.
+func (w *Worker) assignShards(ctx context.Context) ([]*Worker, bool) {
+	ids, err := w.replicas(ctx, time.Now())
+	if err != nil {
+		// keep the previous assignment rather than have no replica do the
+		// work
+		w.logger.WithError(err).Info("Fail to list worker replicas")
+		return w.owned, w.leader
+	}
+
+	var owned []*Worker
+	for _, name := range w.shardNames() {
+		if w.ownsTemplate(ids, name) {
+			owned = append(owned, w.shards[name])
+		}
+	}
+
+	leader := owner(ids, globalShard) == w.cfg.WorkerID
+	if names(owned) != names(w.owned) || leader != w.leader {
+		w.logger.WithField("templates", names(owned)).WithField("leader", leader).WithField("replicas", len(ids)).Info("Assigned shards")
+	}
+	w.owned, w.leader = owned, leader
+	var leading int32
+	if leader {
+		leading = 1
+	}
+	atomic.StoreInt32(&w.leading, leading)
+
+	return owned, leader
+}
+
+func names(shards []*Worker) string {
+	var list []string
+	for _, s := range shards {
+		list = append(list, s.template)
+	}
+
+	return strings.Join(list, ",")
+}
+
+func (w *Worker) ownsTemplate(ids []string, name string) bool {
+	if len(w.cfg.ShardTemplates) == 0 {
+		return owner(ids, name) == w.cfg.WorkerID
+	}
+
+	for _, t := range w.cfg.ShardTemplates {
+		if strings.TrimSpace(t) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *Worker) shardNames() []string {
+	var names []string
+	for name := range w.shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// key scopes a store key of per template state to the template of a
+// shard. Workers of a single template keep the unscoped keys.
+func (w *Worker) key(k string) string {
+	if w.template == "" {
+		return k
+	}
+
+	return k + "/" + w.template
+}
@@ -0,0 +1,269 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stackMigrationKey = "stacks/migration"
+	// stackMigrationRetry is how long a migration waits after its canary
+	// failed before deploying another one
+	stackMigrationRetry = time.Hour
+	// stackCheckInterval is how often the stack of the template is checked
+	// for deprecation, and the stacks of idle editors are looked up again
+	stackCheckInterval = 24 * time.Hour
+	// canaryBootTimeout is how long the canary of a migration has to serve
+	// requests
+	canaryBootTimeout = 5 * time.Minute
+)
+
+// appStack is the stack an idle editor was found on.
+type appStack struct {
+	Stack     string
+	CheckedAt time.Time
+}
+
+// stackMigration is the progress of moving the idle Heroku editors of the
+// pool onto the stack of the template.
+type stackMigration struct {
+	Stack     string
+	StartedAt time.Time
+	// Canary is the first editor deployed on the new stack. It has to build
+	// and boot before any editor on the old stack is deleted.
+	Canary      string
+	VerifiedAt  *time.Time
+	FailedAt    *time.Time
+	Error       string
+	Migrated    []string
+	CompletedAt *time.Time
+}
+
+// migrateStacks replaces the idle Heroku editors whose image is based on
+// another stack than the one of the template, e.g. after the template moved
+// from heroku-20 to heroku-22. A canary editor is deployed and verified on
+// the new stack first, so that a broken stack never drains the pool.
+func (w *Worker) migrateStacks(ctx context.Context) error {
+	// the stack of templates isn't known without a template directory
+	p := provider.Lookup(w.provider, provider.Heroku)
+	if p == nil || w.cfg.TemplateGitURL != "" {
+		return nil
+	}
+
+	target, err := editor.TemplateStack(w.cfg.TemplateDir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w.checkStack(ctx, target, now)
+
+	currentVersion, _, err := p.Pool(ctx)
+	if err != nil {
+		return err
+	}
+
+	var outdated []provider.Editor
+	pooled := make(map[string]bool)
+	for _, ed := range currentVersion {
+		pooled[ed.Name] = true
+
+		stack, err := w.appStack(ctx, ed.Name, now)
+		if err != nil {
+			w.logger.WithError(err).WithField("app", ed.Name).Info("Fail to get app stack")
+			continue
+		}
+
+		if stack != target {
+			outdated = append(outdated, ed)
+		}
+	}
+
+	for name := range w.appStacks {
+		if !pooled[name] {
+			delete(w.appStacks, name)
+		}
+	}
+
+	var mig stackMigration
+	if err := w.store.Get(ctx, w.key(stackMigrationKey), &mig); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	logger := w.logger.WithField("stack", target)
+
+	if len(outdated) == 0 {
+		if mig.Stack == target && mig.CompletedAt == nil {
+			logger.WithField("migrated", len(mig.Migrated)).Info("Completed stack migration")
+			mig.CompletedAt = &now
+			return w.store.Put(ctx, w.key(stackMigrationKey), mig)
+		}
+
+		return nil
+	}
+
+	if mig.Stack != target {
+		logger.WithField("num", len(outdated)).Info("Starting stack migration")
+		mig = stackMigration{
+			Stack:     target,
+			StartedAt: now,
+		}
+	}
+
+	if mig.VerifiedAt == nil {
+		if mig.FailedAt != nil && now.Sub(*mig.FailedAt) < stackMigrationRetry {
+			return nil
+		}
+
+		canary, err := w.deployCanary(ctx, p, target)
+		if err != nil {
+			logger.WithError(err).Info("Fail to verify stack, keeping editors on the old stack")
+			mig.FailedAt = &now
+			mig.Error = err.Error()
+			return w.store.Put(ctx, w.key(stackMigrationKey), mig)
+		}
+
+		logger.WithField("app", canary).Info("Verified stack")
+		verifiedAt := time.Now()
+		mig.Canary = canary
+		mig.VerifiedAt = &verifiedAt
+		mig.FailedAt = nil
+		mig.Error = ""
+	}
+
+	n := w.cfg.BatchSize
+	if n > len(outdated) {
+		n = len(outdated)
+	}
+
+	for _, ed := range outdated[0:n] {
+		logger.WithField("app", ed.Name).Info("Migrating idle editor")
+		if err := p.Delete(ctx, ed.Name); err != nil {
+			logger.WithError(err).WithField("app", ed.Name).Info("Fail to delete app")
+			continue
+		}
+
+		mig.Migrated = append(mig.Migrated, ed.Name)
+	}
+
+	return w.store.Put(ctx, w.key(stackMigrationKey), mig)
+}
+
+// deployCanary deploys an idle editor and checks that it's on the stack
+// and that it boots. The canary stays in the pool once it's verified.
+func (w *Worker) deployCanary(ctx context.Context, p provider.Provider, stack string) (string, error) {
+	ed, err := p.Deploy(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	logger := w.logger.WithFields(log.Fields{"app": ed.Name, "stack": stack})
+
+	verify := func() error {
+		got, err := editor.AppStack(ctx, w.heroku, ed.Name)
+		if err != nil {
+			return err
+		}
+		if got != stack {
+			return fmt.Errorf("error: canary %s is on stack %s, expected %s", ed.Name, got, stack)
+		}
+
+		logger.Info("Booting canary")
+		if err := editor.ScaleApp(ctx, w.heroku, ed.Name, 1); err != nil {
+			return err
+		}
+		defer func() {
+			if err := editor.ScaleApp(ctx, w.heroku, ed.Name, 0); err != nil {
+				logger.WithError(err).Info("Fail to scale down canary")
+			}
+		}()
+
+		return waitForBoot(ctx, ed.URL, canaryBootTimeout)
+	}
+
+	if err := verify(); err != nil {
+		if err := p.Delete(ctx, ed.Name); err != nil {
+			logger.WithError(err).Info("Fail to delete canary")
+		}
+
+		return "", err
+	}
+
+	return ed.Name, nil
+}
+
+// waitForBoot waits for an editor to serve requests without a server error.
+func waitForBoot(ctx context.Context, url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return nil
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("error: editor at %s didn't boot within %s", url, timeout)
+		}
+	}
+}
+
+// appStack returns the stack of an idle editor, which is cached for
+// stackCheckInterval. Names of idle editors change with their version, so
+// an editor deployed from another image isn't served a cached stack.
+func (w *Worker) appStack(ctx context.Context, name string, now time.Time) (string, error) {
+	if st, ok := w.appStacks[name]; ok && now.Sub(st.CheckedAt) < stackCheckInterval {
+		return st.Stack, nil
+	}
+
+	stack, err := editor.AppStack(ctx, w.heroku, name)
+	if err != nil {
+		return "", err
+	}
+
+	if w.appStacks == nil {
+		w.appStacks = make(map[string]appStack)
+	}
+	w.appStacks[name] = appStack{Stack: stack, CheckedAt: now}
+
+	return stack, nil
+}
+
+// checkStack warns when the stack of the template is deprecated by Heroku.
+func (w *Worker) checkStack(ctx context.Context, stack string, now time.Time) {
+	if now.Sub(w.stackCheckedAt) < stackCheckInterval {
+		return
+	}
+	w.stackCheckedAt = now
+
+	st, err := w.heroku.StackInfo(ctx, stack)
+	if err != nil {
+		w.logger.WithError(err).WithField("stack", stack).Info("Fail to get stack")
+		return
+	}
+
+	if st.State == "deprecated" {
+		w.logger.WithField("stack", stack).Warn("Template stack is deprecated by Heroku, move the template to a newer stack in its app.json")
+	}
+}
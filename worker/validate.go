@@ -0,0 +1,241 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/health"
+	"github.com/jingweno/codeface/model"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+)
+
+// configProblems are what's wrong with the config of the worker, which are
+// reported at once instead of one restart at a time.
+type configProblems []string
+
+func (ps *configProblems) add(format string, args ...interface{}) {
+	*ps = append(*ps, fmt.Sprintf(format, args...))
+}
+
+// check adds err of what, if any.
+func (ps *configProblems) check(what string, err error) {
+	if err != nil {
+		ps.add("%s: %s", what, strings.TrimPrefix(err.Error(), "error: "))
+	}
+}
+
+func (ps configProblems) err() error {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("error: the worker isn't started, fix its config:\n  - %s", strings.Join(ps, "\n  - "))
+}
+
+// validateConfig checks the settings of the config that don't depend on
+// anything outside of the worker.
+func (w *Worker) validateConfig(ps *configProblems) {
+	if !w.cfg.SingleProcess && !store.Shared(w.cfg.StoreURL) {
+		ps.add("STORE_URL %s isn't shared with the server, use a store such as postgres://, or set SINGLE_PROCESS=true to run the worker alone", w.cfg.StoreURL)
+	}
+	if w.cfg.BatchSize < 1 {
+		ps.add("BATCH_SIZE must be at least 1, it's %d", w.cfg.BatchSize)
+	}
+	if w.cfg.PoolSize < 0 {
+		ps.add("POOL_SIZE must not be negative, it's %d", w.cfg.PoolSize)
+	}
+	if w.cfg.PoolSize > 0 && w.cfg.BatchSize > w.cfg.PoolSize {
+		ps.add("BATCH_SIZE (%d) is larger than POOL_SIZE (%d), lower it to at most the pool size", w.cfg.BatchSize, w.cfg.PoolSize)
+	}
+	if w.cfg.MaxTotalApps < 0 {
+		ps.add("MAX_TOTAL_APPS must not be negative, it's %d", w.cfg.MaxTotalApps)
+	}
+	if w.cfg.RefillTimeout <= 0 {
+		ps.add("REFILL_TIMEOUT must be positive, it's %s", w.cfg.RefillTimeout)
+	}
+	if w.cfg.ClaimAbandonTimeout <= 0 {
+		ps.add("CLAIM_ABANDON_TIMEOUT must be positive, it's %s", w.cfg.ClaimAbandonTimeout)
+	}
+	if w.cfg.CheckInterval <= 0 {
+		ps.add("CHECK_INTERVAL must be positive, it's %s", w.cfg.CheckInterval)
+	}
+	if w.cfg.BootSLO < 0 {
+		ps.add("BOOT_SLO must not be negative, it's %s", w.cfg.BootSLO)
+	}
+	if w.cfg.BootSLO > 0 {
+		if w.cfg.BootSLOPercentile < 1 || w.cfg.BootSLOPercentile > 100 {
+			ps.add("BOOT_SLO_PERCENTILE must be between 1 and 100, it's %d", w.cfg.BootSLOPercentile)
+		}
+		if w.cfg.BootSLOWindow <= 0 {
+			ps.add("BOOT_SLO_WINDOW must be positive, it's %s", w.cfg.BootSLOWindow)
+		}
+		if w.cfg.BootSLOMinBoots < 1 {
+			ps.add("BOOT_SLO_MIN_BOOTS must be at least 1, it's %d", w.cfg.BootSLOMinBoots)
+		}
+		if w.cfg.BootSLOPoolStep < 1 {
+			ps.add("BOOT_SLO_POOL_STEP must be at least 1, it's %d", w.cfg.BootSLOPoolStep)
+		}
+		if w.cfg.BootSLOMaxBoost < 0 {
+			ps.add("BOOT_SLO_MAX_BOOST must not be negative, it's %d", w.cfg.BootSLOMaxBoost)
+		}
+	}
+	if w.cfg.CanaryRepo != "" {
+		if w.cfg.CanaryTimeout <= 0 {
+			ps.add("CANARY_TIMEOUT must be positive, it's %s", w.cfg.CanaryTimeout)
+		}
+		if w.cfg.CanaryRetryAfter <= 0 {
+			ps.add("CANARY_RETRY_AFTER must be positive, it's %s", w.cfg.CanaryRetryAfter)
+		}
+		if u, err := url.Parse(w.cfg.CanaryRepo); err != nil || u.Scheme != "https" || u.Host == "" {
+			ps.add("CANARY_REPO must be an https repository URL, it's %s", w.cfg.CanaryRepo)
+		}
+	}
+	if w.cfg.KeepWarmEditors < 0 {
+		ps.add("KEEP_WARM_EDITORS must not be negative, it's %d", w.cfg.KeepWarmEditors)
+	}
+	if w.cfg.KeepWarmEditors > 0 && w.cfg.KeepWarmInterval <= 0 {
+		ps.add("KEEP_WARM_INTERVAL must be positive, it's %s", w.cfg.KeepWarmInterval)
+	}
+	if w.cfg.KeepWarmEditors > 0 && w.cfg.KeepWarmInterval < w.cfg.CheckInterval {
+		ps.add("KEEP_WARM_INTERVAL (%s) is shorter than CHECK_INTERVAL (%s), which editors are pinged at most once per", w.cfg.KeepWarmInterval, w.cfg.CheckInterval)
+	}
+
+	switch w.cfg.IdleDetection {
+	case idleDetectionFormation:
+	case idleDetectionRouter:
+		if w.cfg.RouterDrainURL == "" {
+			ps.add("ROUTER_DRAIN_URL is required by IDLE_DETECTION=router")
+		}
+	default:
+		ps.add("IDLE_DETECTION is %q, expected %s or %s", w.cfg.IdleDetection, idleDetectionFormation, idleDetectionRouter)
+	}
+
+	if w.cfg.DebugPort != "" && w.cfg.DebugToken == "" {
+		ps.add("DEBUG_TOKEN is required by DEBUG_PORT")
+	}
+	if (w.cfg.CloudflareAPIToken == "") != (w.cfg.CloudflareZoneID == "") {
+		ps.add("CLOUDFLARE_API_TOKEN and CLOUDFLARE_ZONE_ID are required by each other")
+	}
+
+	if w.cfg.PoolBudget > 0 && w.cfg.ClaimHalfLife <= 0 {
+		ps.add("CLAIM_HALF_LIFE must be positive with a POOL_BUDGET")
+	}
+
+	switch w.cfg.Reconcile {
+	case reconcileOff, reconcileReport, reconcileRepair:
+	default:
+		ps.add("RECONCILE is %q, expected %s, %s or %s", w.cfg.Reconcile, reconcileOff, reconcileReport, reconcileRepair)
+	}
+
+	switch w.cfg.Environment {
+	case "", model.EnvironmentStaging:
+	case model.EnvironmentProduction:
+		if !w.cfg.PromoteArtifacts {
+			ps.add("PROMOTE_ARTIFACTS=true is required by ENVIRONMENT=production, production pools are promoted from staging")
+		}
+	default:
+		ps.add("ENVIRONMENT is %q, expected %s or %s", w.cfg.Environment, model.EnvironmentStaging, model.EnvironmentProduction)
+	}
+
+	if w.cfg.Provider == provider.Heroku && w.cfg.HerokuAPIKey == "" {
+		ps.add("HEROKU_API_KEY is required by the heroku provider")
+	}
+}
+
+// validateTemplateSettings checks the batch sizes of templates against
+// their pool sizes.
+func validateTemplateSettings(cfg Config, settings *templateSettings, ps *configProblems) {
+	seen := make(map[string]bool)
+	var templates []string
+	for t := range settings.poolSizes {
+		seen[t] = true
+		templates = append(templates, t)
+	}
+	for t := range settings.batchSizes {
+		if !seen[t] {
+			templates = append(templates, t)
+		}
+	}
+	sort.Strings(templates)
+
+	for _, t := range templates {
+		c := settings.apply(cfg, t)
+		if c.PoolSize > 0 && c.BatchSize > c.PoolSize {
+			ps.add("batch size of %s (%d) is larger than its pool size (%d), see BATCH_SIZES and POOL_SIZES", t, c.BatchSize, c.PoolSize)
+		}
+	}
+}
+
+// validateTemplates checks that the templates deploy, which are only
+// deployed from their directory on Heroku.
+func (w *Worker) validateTemplates(ps *configProblems) {
+	if w.cfg.TemplateGitURL != "" {
+		return
+	}
+
+	dirs := map[string]string{w.poolTemplate(): w.cfg.TemplateDir}
+	if w.cfg.TemplatesDir != "" {
+		templates, err := listTemplates(w.cfg.TemplatesDir)
+		if err != nil {
+			ps.check("TEMPLATES_DIR", err)
+			return
+		}
+		if len(templates) == 0 {
+			ps.add("TEMPLATES_DIR %s has no templates", w.cfg.TemplatesDir)
+		}
+		dirs = templates
+	} else if _, err := os.Stat(w.cfg.TemplateDir); os.IsNotExist(err) {
+		ps.add("template directory %s does not exist", w.cfg.TemplateDir)
+		return
+	}
+
+	if w.provider == nil || !provider.Has(w.provider, provider.Heroku) {
+		return
+	}
+
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, err := range editor.LintTemplate(dirs[name]) {
+			ps.check("template "+name, err)
+		}
+	}
+}
+
+// validateConnectivity checks that the store and the provider are
+// reachable, and that the Heroku API key may create apps and set their
+// config vars.
+func (w *Worker) validateConnectivity(ctx context.Context, ps *configProblems) {
+	checker := &health.Checker{Store: w.store, Provider: w.provider}
+	ready := checker.Ready(ctx)
+	for _, name := range []string{"store", "provider"} {
+		if msg := ready.Checks[name]; msg != "ok" {
+			ps.add("%s isn't reachable: %s", name, strings.TrimPrefix(msg, "error: "))
+		}
+	}
+
+	if !provider.Has(w.provider, provider.Heroku) || ready.Checks["provider"] != "ok" {
+		return
+	}
+
+	scopes, err := editor.TokenScopes(ctx, w.cfg.HerokuAPIKey)
+	if err != nil {
+		ps.check("HEROKU_API_KEY", err)
+		return
+	}
+	for _, s := range scopes {
+		if s == "global" || s == "write-protected" {
+			return
+		}
+	}
+	ps.add("HEROKU_API_KEY has the scopes %s, the worker needs global or write-protected to create apps and set their config vars", strings.Join(scopes, ","))
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/jingweno/codeface/dns"
+	"github.com/jingweno/codeface/editor"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// releaseVanityNames frees the vanity names of editors whose sessions
+// ended: their CNAME records are deleted before the host is removed from
+// the app, so that no record is left pointing at a host another Heroku app
+// could add.
+func (w *Worker) releaseVanityNames(ctx context.Context) error {
+	if w.cfg.CloudflareAPIToken == "" {
+		return nil
+	}
+
+	names, err := editor.VanityNames(ctx, w.store)
+	if err != nil {
+		return err
+	}
+
+	records := &dns.Cloudflare{Token: w.cfg.CloudflareAPIToken, ZoneID: w.cfg.CloudflareZoneID}
+	now := time.Now()
+	for _, v := range names {
+		inUse, err := editor.VanityInUse(ctx, w.store, v, now)
+		if err != nil || inUse {
+			continue
+		}
+
+		logger := w.logger.WithFields(log.Fields{"app": v.Editor, "vanity": v.Name})
+		logger.Info("Releasing vanity name")
+
+		if err := records.Delete(ctx, v.Host); err != nil {
+			logger.WithError(err).Info("Fail to delete DNS record")
+			continue
+		}
+
+		if v.Editor != "" && (v.Provider == "" || v.Provider == provider.Heroku) {
+			var herr heroku.Error
+			if _, err := w.heroku.DomainDelete(ctx, v.Editor, v.Host); err != nil && !(errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound) {
+				logger.WithError(err).Info("Fail to delete domain")
+				continue
+			}
+		}
+
+		if err := w.store.Delete(ctx, editor.VanityKey(v.Name)); err != nil && err != store.ErrNotFound {
+			logger.WithError(err).Info("Fail to delete vanity name")
+		}
+	}
+
+	return nil
+}
@@ -8,76 +8,755 @@ import (
 	"time"
 
 	heroku "github.com/heroku/heroku-go/v5"
-	"github.com/jingweno/codeface/editor"
-	"github.com/oklog/run"
+	"github.com/jingweno/codeface/agent"
+	"github.com/jingweno/codeface/artifact"
+	"github.com/jingweno/codeface/feature"
+	"github.com/jingweno/codeface/logmux"
+	"github.com/jingweno/codeface/metrics"
+	"github.com/jingweno/codeface/notify"
+	"github.com/jingweno/codeface/provider"
+	"github.com/jingweno/codeface/s3"
+	"github.com/jingweno/codeface/store"
 	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	HerokuAPIKey  string        `env:"HEROKU_API_KEY,required"`
+	Provider      string        `env:"PROVIDER,default=heroku"`
+	HerokuAPIKey  string        `env:"HEROKU_API_KEY"`
 	BatchSize     int           `env:"BATCH_SIZE,default=2"`
 	PoolSize      int           `env:"POOL_SIZE,default=5"`
 	CheckInterval time.Duration `env:"CHECK_INTERVAL,default=1m"`
-	TemplateDir   string
+	StoreURL      string        `env:"STORE_URL,default=mem://"`
+	// SingleProcess runs the worker without a server, which is the only
+	// way it can run on a STORE_URL of mem://
+	SingleProcess bool `env:"SINGLE_PROCESS"`
+	// PoolSizes, BatchSizes and CheckIntervals override PoolSize, BatchSize
+	// and CheckInterval per template, e.g. rust=1;node=20
+	PoolSizes      []string `env:"POOL_SIZES"`
+	BatchSizes     []string `env:"BATCH_SIZES"`
+	CheckIntervals []string `env:"CHECK_INTERVALS"`
+	// StoreEncryptionKeys and StoreAllowPlaintext must be the ones of the
+	// server
+	StoreEncryptionKeys []string `env:"STORE_ENCRYPTION_KEYS"`
+	StoreAllowPlaintext bool     `env:"STORE_ALLOW_PLAINTEXT"`
+	TemplateDir         string
+
+	// TemplateGitURL builds editors from a ref of a template repository on
+	// GitHub, so the worker runs without a template directory
+	TemplateGitURL   string `env:"TEMPLATE_GIT_URL"`
+	TemplateGitRef   string `env:"TEMPLATE_GIT_REF,default=main"`
+	TemplateGitToken string `env:"TEMPLATE_GIT_TOKEN"`
+
+	// TemplatesDir is a directory of templates that replicas of the worker
+	// share the pools of, each template being maintained by one of them.
+	// WorkerID identifies the replica, the hostname by default.
+	// ShardTemplates assigns templates to the replica statically, e.g.
+	// go;python, instead of hashing them onto the replicas that are alive.
+	TemplatesDir   string   `env:"TEMPLATES_DIR"`
+	WorkerID       string   `env:"WORKER_ID"`
+	ShardTemplates []string `env:"SHARD_TEMPLATES"`
+
+	// DeployConcurrency is how many editors a replica deploys at once to
+	// the pools of its templates, BatchSize if 0. They're shared among the
+	// pools that are short of editors in proportion to TemplateWeights,
+	// e.g. go=3;python=1, where templates weigh 1 by default.
+	DeployConcurrency int      `env:"DEPLOY_CONCURRENCY,default=0"`
+	TemplateWeights   []string `env:"TEMPLATE_WEIGHTS"`
+	// RefillTimeout is how long the deploys of a refill have to get their
+	// editors ready, all together. The ones that aren't by then are called
+	// off, the ones that are stay in the pool.
+	RefillTimeout time.Duration `env:"REFILL_TIMEOUT,default=30m"`
+
+	// PoolBudget caps the idle editors of the pools of all the templates,
+	// 0 for no cap. Pools over it are shrunk starting with the templates
+	// claimed the least recently, where claims count half as much every
+	// ClaimHalfLife.
+	PoolBudget    int           `env:"POOL_BUDGET,default=0"`
+	ClaimHalfLife time.Duration `env:"CLAIM_HALF_LIFE,default=24h"`
+
+	// KeepWarmEditors is how many idle Heroku editors of each pool are kept
+	// running instead of being scaled down, 0 for none, so that claims of
+	// them don't wait for a dyno to start. They're pinged about every
+	// KeepWarmInterval, which keeps eco dynos from sleeping after 30
+	// minutes without requests. Their dyno hours are accounted by day, see
+	// GET /v1/pool.
+	KeepWarmEditors  int           `env:"KEEP_WARM_EDITORS,default=0"`
+	KeepWarmInterval time.Duration `env:"KEEP_WARM_INTERVAL,default=20m"`
+
+	// BootSLO is the objective for the BootSLOPercentile of the boot times
+	// of editors, from their claim until they serve, 0 for none. It's
+	// checked per pool with the boots of the claims within BootSLOWindow,
+	// once there are BootSLOMinBoots of them. While it's breached an alert
+	// is sent to ALERT_WEBHOOK_URL and the pool grows by BootSLOPoolStep
+	// editors, up to BootSLOMaxBoost on top of its size, and it shrinks back
+	// a step per window once it's met.
+	BootSLO           time.Duration `env:"BOOT_SLO,default=0s"`
+	BootSLOPercentile int           `env:"BOOT_SLO_PERCENTILE,default=95"`
+	BootSLOWindow     time.Duration `env:"BOOT_SLO_WINDOW,default=1h"`
+	BootSLOMinBoots   int           `env:"BOOT_SLO_MIN_BOOTS,default=5"`
+	BootSLOPoolStep   int           `env:"BOOT_SLO_POOL_STEP,default=1"`
+	BootSLOMaxBoost   int           `env:"BOOT_SLO_MAX_BOOST,default=5"`
+
+	// CanaryRepo has the worker claim an editor of a new version with the
+	// repository cloned before the editors of older versions are culled.
+	// The canary passes once code-server serves the workbench within
+	// CanaryTimeout, which is retried CanaryRetryAfter it fails.
+	CanaryRepo       string        `env:"CANARY_REPO"`
+	CanaryTimeout    time.Duration `env:"CANARY_TIMEOUT,default=10m"`
+	CanaryRetryAfter time.Duration `env:"CANARY_RETRY_AFTER,default=1h"`
+
+	// PromoteArtifacts builds the template once and promotes pool editors
+	// from the build instead of building each of them
+	PromoteArtifacts bool `env:"PROMOTE_ARTIFACTS,default=false"`
+	// Environment is the environment of the pool, staging or production.
+	// Production pools are only promoted from staging with cf-admin
+	// environments promote.
+	Environment string `env:"ENVIRONMENT"`
+	// ArtifactStore is where builds are kept, one of heroku, s3 or oci
+	ArtifactStore       string `env:"ARTIFACT_STORE,default=heroku"`
+	ArtifactS3Bucket    string `env:"ARTIFACT_S3_BUCKET"`
+	ArtifactOCIUsername string `env:"ARTIFACT_OCI_USERNAME"`
+	ArtifactOCIPassword string `env:"ARTIFACT_OCI_PASSWORD"`
+
+	// ScanTrivyPath is the trivy binary that artifacts are scanned with
+	// before they're promoted, which are held back if vulnerabilities of
+	// ScanSeverity are found. Artifacts aren't scanned if it's empty.
+	ScanTrivyPath string `env:"SCAN_TRIVY_PATH"`
+	ScanSeverity  string `env:"SCAN_SEVERITY,default=CRITICAL"`
+
+	// ArtifactSigningKey signs the artifacts that are built, and pool
+	// editors are only deployed from artifacts signed by it or by one of
+	// ArtifactVerifyKeys. Artifacts aren't verified if both are empty.
+	ArtifactSigningKey string   `env:"ARTIFACT_SIGNING_KEY"`
+	ArtifactVerifyKeys []string `env:"ARTIFACT_VERIFY_KEYS"`
+
+	// how long a failed provider of a hybrid pool is skipped
+	FailoverCooldown time.Duration `env:"PROVIDER_FAILOVER_COOLDOWN,default=10m"`
+
+	// MetricsLogInterval is how often the latency and the failures of the
+	// Heroku API calls of the worker are logged, 0 for never
+	MetricsLogInterval time.Duration `env:"METRICS_LOG_INTERVAL,default=5m"`
+
+	// DebugPort serves the pprof profiles and the runtime stats of the
+	// worker under /debug/ to callers with DebugToken, e.g. through heroku
+	// ps:forward. It's off when it's empty.
+	DebugPort  string `env:"DEBUG_PORT"`
+	DebugToken string `env:"DEBUG_TOKEN"`
+
+	// HealthPort serves /healthz and /readyz for probes, e.g. of
+	// Kubernetes. It's off when it's empty.
+	HealthPort string `env:"HEALTH_PORT"`
+
+	DockerHost       string `env:"DOCKER_HOST,default=unix:///var/run/docker.sock"`
+	DockerImage      string `env:"DOCKER_IMAGE,default=jingweno/heroku-editor:20"`
+	DockerPublicHost string `env:"DOCKER_PUBLIC_HOST,default=localhost"`
+	// EditorDomain and DockerNetwork are the ones of the server, which the
+	// containers of the pool are created for
+	EditorDomain  string `env:"EDITOR_DOMAIN"`
+	DockerNetwork string `env:"DOCKER_NETWORK"`
+
+	ECSCluster        string   `env:"ECS_CLUSTER"`
+	ECSTaskDefinition string   `env:"ECS_TASK_DEFINITION"`
+	ECSContainerName  string   `env:"ECS_CONTAINER_NAME,default=editor"`
+	ECSSubnets        []string `env:"ECS_SUBNETS"`
+	ECSSecurityGroups []string `env:"ECS_SECURITY_GROUPS"`
+	ECSVPCID          string   `env:"ECS_VPC_ID"`
+	ECSListenerARN    string   `env:"ECS_LISTENER_ARN"`
+	ECSEditorDomain   string   `env:"ECS_EDITOR_DOMAIN"`
+
+	// CloudflareAPIToken and CloudflareZoneID release the vanity names of
+	// editors whose sessions ended, see the VANITY_DOMAIN of the server
+	CloudflareAPIToken string `env:"CLOUDFLARE_API_TOKEN"`
+	CloudflareZoneID   string `env:"CLOUDFLARE_ZONE_ID"`
+
+	// MaxSessionDuration is how long an editor may stay claimed, 0 for no
+	// limit. MaxSessionDurations overrides it per template, e.g. go=8h;python=4h.
+	MaxSessionDuration   time.Duration `env:"MAX_SESSION_DURATION,default=0s"`
+	MaxSessionDurations  []string      `env:"MAX_SESSION_DURATIONS"`
+	SessionWarnBefore    time.Duration `env:"SESSION_WARN_BEFORE,default=1h"`
+	SessionWebhookURL    string        `env:"SESSION_WEBHOOK_URL"`
+	SessionWebhookSecret string        `env:"SESSION_WEBHOOK_SECRET"`
+
+	// WIPPush has the agent of an expired editor push its uncommitted work
+	// to a branch before the editor is released
+	WIPPush bool `env:"WIP_PUSH,default=false"`
+
+	// GuestSessionDuration is how long guest editors stay claimed, they're
+	// released then even if they're in use
+	GuestSessionDuration time.Duration `env:"GUEST_SESSION_DURATION,default=1h"`
+
+	// ClaimAbandonTimeout is how long claims made with POST /v1/claims may
+	// go unpolled, they're abandoned then and their editors released
+	ClaimAbandonTimeout time.Duration `env:"CLAIM_ABANDON_TIMEOUT,default=5m"`
+
+	// IdleDetection is how the worker tells whether a claimed editor is in
+	// use: formation by its dyno being up, or router by the requests it
+	// served within IdleTimeout, which are drained to RouterDrainURL, e.g.
+	// https://:token@codeface.example.com/v1/drains/router. Users are
+	// warned in the editor IdleWarnBefore it's released for being idle.
+	IdleDetection  string        `env:"IDLE_DETECTION,default=formation"`
+	IdleTimeout    time.Duration `env:"IDLE_TIMEOUT,default=30m"`
+	IdleWarnBefore time.Duration `env:"IDLE_WARN_BEFORE,default=5m"`
+	RouterDrainURL string        `env:"ROUTER_DRAIN_URL"`
+
+	// FeatureFlags ramp behaviors up to a percentage of users unless
+	// they're set in the store, e.g. router-idle-detection=10, see
+	// cf-admin flags
+	FeatureFlags []string `env:"FEATURE_FLAGS"`
+
+	// MaintenanceWindows are UTC windows in which idle editors are recycled
+	// onto the newest image, e.g. Sun 02:00-04:00;Wed 02:00-04:00.
+	MaintenanceWindows       []string `env:"MAINTENANCE_WINDOWS"`
+	MaintenanceWebhookURL    string   `env:"MAINTENANCE_WEBHOOK_URL"`
+	MaintenanceWebhookSecret string   `env:"MAINTENANCE_WEBHOOK_SECRET"`
+
+	// MigrateSnapshotsWithin is how recently suspended editors of older
+	// versions have to be saved to be migrated onto editors of the current
+	// version, 0 to keep them on their version
+	MigrateSnapshotsWithin time.Duration `env:"MIGRATE_SNAPSHOTS_WITHIN,default=720h"`
+
+	// BuildLogRetention is how long deploys are kept with their output
+	BuildLogRetention time.Duration `env:"BUILD_LOG_RETENTION,default=168h"`
+
+	// QuarantineDuration is how long the apps of failed pool deploys are
+	// kept for inspection instead of being deleted, 0 to delete them right
+	// away. No more editors are deployed to a pool once QuarantineMaxDeploys
+	// of its deploys are quarantined.
+	QuarantineDuration   time.Duration `env:"QUARANTINE_DURATION,default=24h"`
+	QuarantineMaxDeploys int           `env:"QUARANTINE_MAX_DEPLOYS,default=3"`
+
+	// Reconcile is what's done with the apps that are inconsistent with the
+	// state store when the worker starts: off, report to only log them, or
+	// repair
+	Reconcile string `env:"RECONCILE,default=repair"`
+
+	// ResetReleasedEditors wipes editors purged after they were deleted or
+	// released and puts them back into the pool on providers that can,
+	// instead of deleting them
+	ResetReleasedEditors bool `env:"RESET_RELEASED_EDITORS,default=false"`
+
+	// SingleUseTemplates are never reset, their editors are destroyed
+	// instead. Their pools are refilled SingleUseRefillFactor times their
+	// batch size per check, since none of their editors come back.
+	SingleUseTemplates    []string `env:"SINGLE_USE_TEMPLATES"`
+	SingleUseRefillFactor int      `env:"SINGLE_USE_REFILL_FACTOR,default=2"`
+
+	// MaxTotalApps caps the idle and claimed editors of the fleet, 0 for no
+	// cap, so that a typo in the pool sizes can't create hundreds of apps.
+	// Reaching it is alerted to ALERT_WEBHOOK_URL.
+	MaxTotalApps       int    `env:"MAX_TOTAL_APPS,default=0"`
+	AlertWebhookURL    string `env:"ALERT_WEBHOOK_URL"`
+	AlertWebhookSecret string `env:"ALERT_WEBHOOK_SECRET"`
+
+	// SlackBotToken and the mail settings deliver the idle warnings and
+	// recycle notices of users who chose Slack or email in their settings,
+	// as the server does
+	SlackBotToken string `env:"SLACK_BOT_TOKEN"`
+	MailFrom      string `env:"MAIL_FROM"`
+	SMTPAddr      string `env:"SMTP_ADDR"`
+	SMTPUsername  string `env:"SMTP_USERNAME"`
+	SMTPPassword  string `env:"SMTP_PASSWORD"`
+	MailSESRegion string `env:"MAIL_SES_REGION"`
+
+	MaxRestarts     int           `env:"MAX_RESTARTS,default=3"`
+	RestartBackoff  time.Duration `env:"RESTART_BACKOFF,default=30s"`
+	CrashResetAfter time.Duration `env:"CRASH_RESET_AFTER,default=30m"`
+
+	UsageExportS3Bucket  string `env:"USAGE_EXPORT_S3_BUCKET"`
+	AWSRegion            string `env:"AWS_REGION,default=us-east-1"`
+	AWSAccessKeyID       string `env:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey   string `env:"AWS_SECRET_ACCESS_KEY"`
+	BillingWebhookURL    string `env:"BILLING_WEBHOOK_URL"`
+	BillingWebhookSecret string `env:"BILLING_WEBHOOK_SECRET"`
 }
 
 func New(cfg Config) *Worker {
 	client := &http.Client{
 		Transport: &heroku.Transport{
 			BearerToken: cfg.HerokuAPIKey,
+			Transport:   &metrics.Transport{},
 		},
 	}
 
 	return &Worker{
-		cfg:    cfg,
-		heroku: heroku.NewService(client),
-		logger: log.New().WithField("com", "worker"),
+		cfg:          cfg,
+		heroku:       heroku.NewService(client),
+		logger:       log.New().WithField("com", "worker"),
+		deployOutput: logmux.New(os.Stderr),
 	}
 }
 
 type Worker struct {
-	cfg    Config
-	heroku *heroku.Service
-	logger log.FieldLogger
+	cfg      Config
+	heroku   *heroku.Service
+	provider provider.Provider
+	store    store.Store
+	logger   log.FieldLogger
+	// notifier delivers the notifications of users
+	notifier *notify.Deliverer
+	// deployOutput is shared by the deploys of the shards, which run
+	// concurrently
+	deployOutput *logmux.Mux
+
+	// sessionLimits are the maximum session durations by template
+	sessionLimits map[string]time.Duration
+	// maintenanceWindows are the windows in which the pool is recycled
+	maintenanceWindows []maintenanceWindow
+	// stackCheckedAt is when the template stack was checked for deprecation
+	stackCheckedAt time.Time
+	// appStacks are the stacks of idle editors by name, see appStack
+	appStacks map[string]appStack
+
+	// template is the template of a shard, whose pool is maintained by the
+	// replica owning it
+	template string
+	shards   map[string]*Worker
+	owned    []*Worker
+	leader   bool
+	// leading is leader for the health checks, which read it while shards
+	// are assigned
+	leading int32
+
+	// templateWeights are the shares of the deploys of a check that the
+	// pools of templates get, see refillShards
+	templateWeights map[string]int
+	// refillRound rotates the template that's refilled first
+	refillRound int
+
+	// settings are the pool settings of templates, and nextCheck is when
+	// the pool of a shard is checked next
+	settings  *templateSettings
+	nextCheck time.Time
+
+	// flags are the feature flags that behaviors are ramped with
+	flags *feature.Flags
+
+	// poolBoost are the editors the pool has on top of its size while its
+	// boot SLO is breached, see checkBootSLO
+	poolBoost int
 }
 
 func (w *Worker) Start(ctx context.Context) error {
 	w.logger.Info("Starting worker")
 
-	if _, err := os.Stat(w.cfg.TemplateDir); os.IsNotExist(err) {
-		return fmt.Errorf("template directory %s does not exist", w.cfg.TemplateDir)
+	// everything that's wrong with the config is reported at once
+	var problems configProblems
+	w.validateConfig(&problems)
+
+	st, err := store.OpenEncrypted(w.cfg.StoreURL, w.cfg.StoreEncryptionKeys, w.cfg.AWSRegion, w.cfg.StoreAllowPlaintext)
+	problems.check("store", err)
+	w.store = st
+	notifier, err := w.newNotifier()
+	problems.check("mail", err)
+	w.notifier = notifier
+
+	flags, err := feature.New(st, w.cfg.FeatureFlags, w.logger)
+	problems.check("FEATURE_FLAGS", err)
+	w.flags = flags
+
+	p, err := w.newProvider(w.cfg.TemplateDir, false)
+	problems.check("provider", err)
+	w.provider = p
+
+	limits, err := parseSessionLimits(w.cfg.MaxSessionDurations)
+	problems.check("MAX_SESSION_DURATIONS", err)
+	w.sessionLimits = limits
+
+	weights, err := parseTemplateWeights(w.cfg.TemplateWeights)
+	problems.check("TEMPLATE_WEIGHTS", err)
+	w.templateWeights = weights
+
+	settings, err := parseTemplateSettings(w.cfg)
+	problems.check("template settings", err)
+	if settings != nil {
+		validateTemplateSettings(w.cfg, settings, &problems)
 	}
+	w.settings = settings
 
-	work := func() {
-		if err := w.addAppsToPool(ctx); err != nil {
-			w.logger.WithError(err).Info("Fail to add apps to pool")
+	windows, err := parseMaintenanceWindows(w.cfg.MaintenanceWindows)
+	problems.check("MAINTENANCE_WINDOWS", err)
+	w.maintenanceWindows = windows
+
+	w.validateTemplates(&problems)
+	if st != nil && p != nil {
+		w.validateConnectivity(ctx, &problems)
+	}
+	if err := problems.err(); err != nil {
+		return err
+	}
+
+	if err := w.reconcile(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to reconcile state")
+	}
+
+	if w.cfg.TemplatesDir != "" {
+		if err := w.startShards(); err != nil {
+			return err
+		}
+	} else {
+		w.cfg = settings.apply(w.cfg, w.poolTemplate())
+	}
+
+	tick := w.tickInterval()
+	work := func(now time.Time) {
+		if w.shards == nil {
+			w.maintainTemplate(ctx)
+			w.runDuties(ctx)
 			return
 		}
 
-		if err := w.removeOutdatedApps(ctx); err != nil {
-			w.logger.WithError(err).Info("Fail to remove outdated apps from pool")
+		if err := w.applyPoolBudget(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to apply pool budget")
 		}
+
+		// shards are checked as often as their template is set to
+		owned, leader := w.assignShards(ctx)
+		var due []*Worker
+		for _, s := range owned {
+			if s.due(now, tick) {
+				due = append(due, s)
+			}
+		}
+
+		w.refillShards(ctx, due)
+		for _, s := range due {
+			s.maintainTemplate(ctx)
+		}
+		if leader && w.due(now, tick) {
+			w.runDuties(ctx)
+		}
+	}
+
+	if w.cfg.MetricsLogInterval > 0 {
+		go w.logMetrics(ctx)
+	}
+	if w.cfg.DebugPort != "" {
+		go w.serveDebug(ctx)
+	}
+	if w.cfg.HealthPort != "" {
+		go w.serveHealth(ctx)
 	}
 
-	t := time.NewTicker(w.cfg.CheckInterval)
+	t := time.NewTicker(tick)
 	defer t.Stop()
 
-	work() // immediate first tick
+	work(time.Now()) // immediate first tick
 	for {
 		select {
-		case <-t.C:
-			work()
+		case now := <-t.C:
+			work(now)
 		case <-ctx.Done():
+			if w.shards != nil {
+				// hand the shards over to the other replicas right away
+				if err := w.store.Delete(context.Background(), workerKeyPrefix+w.cfg.WorkerID); err != nil {
+					w.logger.WithError(err).Info("Fail to delete heartbeat")
+				}
+			}
 			return nil
 		}
 	}
 }
 
+func (w *Worker) newProvider(templateDir string, filterPool bool) (provider.Provider, error) {
+	if w.cfg.Provider == provider.BYO {
+		return nil, fmt.Errorf("error: the byo provider has no pools, run the server without the worker")
+	}
+
+	var arts artifact.Store
+	if w.cfg.PromoteArtifacts {
+		var err error
+		arts, err = artifact.New(w.store, artifact.Config{
+			Kind:         w.cfg.ArtifactStore,
+			HerokuAPIKey: w.cfg.HerokuAPIKey,
+			OCIUsername:  w.cfg.ArtifactOCIUsername,
+			OCIPassword:  w.cfg.ArtifactOCIPassword,
+			S3: &s3.Client{
+				Bucket:          w.cfg.ArtifactS3Bucket,
+				Region:          w.cfg.AWSRegion,
+				AccessKeyID:     w.cfg.AWSAccessKeyID,
+				SecretAccessKey: w.cfg.AWSSecretAccessKey,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var scanner *artifact.Scanner
+	if w.cfg.PromoteArtifacts && w.cfg.ScanTrivyPath != "" {
+		scanner = &artifact.Scanner{Path: w.cfg.ScanTrivyPath, Severity: w.cfg.ScanSeverity}
+	}
+
+	var (
+		signer   *artifact.Signer
+		verifier *artifact.Verifier
+	)
+	if w.cfg.PromoteArtifacts && (w.cfg.ArtifactSigningKey != "" || len(w.cfg.ArtifactVerifyKeys) > 0) {
+		keys := w.cfg.ArtifactVerifyKeys
+		if w.cfg.ArtifactSigningKey != "" {
+			var err error
+			signer, err = artifact.NewSigner(w.cfg.ArtifactSigningKey)
+			if err != nil {
+				return nil, err
+			}
+			keys = append([]string{signer.PublicKey()}, keys...)
+		}
+
+		var err error
+		verifier, err = artifact.NewVerifier(keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return provider.New(provider.Config{
+		Provider:          w.cfg.Provider,
+		Store:             w.store,
+		PromoteArtifacts:  w.cfg.PromoteArtifacts,
+		Artifacts:         arts,
+		Scanner:           scanner,
+		Signer:            signer,
+		Verifier:          verifier,
+		Environment:       w.cfg.Environment,
+		FailoverCooldown:  w.cfg.FailoverCooldown,
+		Quarantine:        w.cfg.QuarantineDuration,
+		DeployOutput:      w.deployOutput,
+		TemplateDir:       templateDir,
+		TemplateGitURL:    w.cfg.TemplateGitURL,
+		TemplateGitRef:    w.cfg.TemplateGitRef,
+		TemplateGitToken:  w.cfg.TemplateGitToken,
+		RouterDrainURL:    w.cfg.RouterDrainURL,
+		FilterPool:        filterPool,
+		HerokuAPIKey:      w.cfg.HerokuAPIKey,
+		DockerHost:        w.cfg.DockerHost,
+		DockerImage:       w.cfg.DockerImage,
+		DockerPublicHost:  w.cfg.DockerPublicHost,
+		EditorDomain:      w.cfg.EditorDomain,
+		DockerNetwork:     w.cfg.DockerNetwork,
+		AWSRegion:         w.cfg.AWSRegion,
+		ECSCluster:        w.cfg.ECSCluster,
+		ECSTaskDefinition: w.cfg.ECSTaskDefinition,
+		ECSContainerName:  w.cfg.ECSContainerName,
+		ECSSubnets:        w.cfg.ECSSubnets,
+		ECSSecurityGroups: w.cfg.ECSSecurityGroups,
+		ECSVPCID:          w.cfg.ECSVPCID,
+		ECSListenerARN:    w.cfg.ECSListenerARN,
+		ECSEditorDomain:   w.cfg.ECSEditorDomain,
+	})
+}
+
+// startShards sets up a shard for every template of the templates
+// directory, which maintains the pool of the template with a provider of
+// its own.
+func (w *Worker) startShards() error {
+	if w.cfg.Provider != provider.Heroku {
+		return fmt.Errorf("error: templates are only sharded on the heroku provider")
+	}
+	if w.cfg.TemplateGitURL != "" {
+		return fmt.Errorf("error: templates are sharded from TEMPLATES_DIR or built from TEMPLATE_GIT_URL, not both")
+	}
+
+	if w.cfg.WorkerID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		w.cfg.WorkerID = host
+	}
+
+	templates, err := listTemplates(w.cfg.TemplatesDir)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return fmt.Errorf("error: templates directory %s has no templates", w.cfg.TemplatesDir)
+	}
+
+	w.shards = make(map[string]*Worker)
+	for name, dir := range templates {
+		cfg := w.settings.apply(w.cfg, name)
+		cfg.TemplateDir = dir
+
+		s := &Worker{
+			cfg:                cfg,
+			heroku:             w.heroku,
+			store:              w.store,
+			notifier:           w.notifier,
+			logger:             w.logger.WithField("template", name),
+			deployOutput:       w.deployOutput,
+			sessionLimits:      w.sessionLimits,
+			maintenanceWindows: w.maintenanceWindows,
+			template:           name,
+		}
+
+		p, err := s.newProvider(dir, true)
+		if err != nil {
+			return err
+		}
+		s.provider = p
+
+		w.shards[name] = s
+	}
+
+	return nil
+}
+
+// maintainTemplate keeps the pool of the template of the worker full and
+// up to date.
+func (w *Worker) maintainTemplate(ctx context.Context) {
+	if err := w.checkBootSLO(ctx, time.Now()); err != nil {
+		w.logger.WithError(err).Info("Fail to check boot SLO")
+	}
+
+	w.maintainPool(ctx)
+
+	if err := w.runMaintenance(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to run pool maintenance")
+	}
+
+	if provider.Has(w.provider, provider.Heroku) {
+		if err := w.migrateStacks(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to migrate stacks")
+		}
+
+		if err := w.replaceUnpromotedApps(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to replace unpromoted apps")
+		}
+	}
+}
+
+// runDuties looks after the claimed editors and the records of the
+// deployment, which aren't per template.
+func (w *Worker) runDuties(ctx context.Context) {
+	// crashes are watched with the Heroku platform API, on the Heroku
+	// editors of a hybrid pool too
+	if hp := provider.Lookup(w.provider, provider.Heroku); hp != nil && hp.Capabilities().CrashRestarts {
+		if err := w.restartCrashedEditors(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to restart crashed editors")
+		}
+	}
+
+	// sessions are tracked with the Heroku platform API
+	if provider.Has(w.provider, provider.Heroku) {
+		if err := w.endSessions(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to end sessions")
+		}
+
+		if err := w.migrateSuspended(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to migrate suspended editors")
+		}
+
+		if err := w.purgeQuarantine(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to purge quarantine")
+		}
+
+		if err := w.releaseVanityNames(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to release vanity names")
+		}
+	}
+
+	if err := w.recycleSessions(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to recycle sessions")
+	}
+
+	if err := w.retryBillingEvents(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to retry billing events")
+	}
+
+	if err := w.purgeDeletions(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to purge deleted editors")
+	}
+
+	if err := w.purgeReleases(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to purge released editors")
+	}
+
+	if err := w.purgeClaims(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to purge claims")
+	}
+
+	if err := notify.Purge(ctx, w.store, time.Now()); err != nil {
+		w.logger.WithError(err).Info("Fail to purge notifications")
+	}
+
+	if err := w.pruneDeploys(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to prune deploys")
+	}
+
+	if err := agent.PruneRotations(ctx, w.store, time.Now()); err != nil {
+		w.logger.WithError(err).Info("Fail to prune agent token rotations")
+	}
+
+	if err := w.exportUsage(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to export usage")
+	}
+}
+
+func (w *Worker) maintainPool(ctx context.Context) {
+	// the pools of shards are refilled together, see refillShards
+	if w.template == "" {
+		if err := w.addAppsToPool(ctx); err != nil {
+			w.logger.WithError(err).Info("Fail to add apps to pool")
+			return
+		}
+	}
+
+	if err := w.removeOutdatedApps(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to remove outdated apps from pool")
+	}
+
+	if err := w.removeExcessApps(ctx); err != nil {
+		w.logger.WithError(err).Info("Fail to remove excess apps from pool")
+	}
+
+	if err := w.keepWarm(ctx, time.Now()); err != nil {
+		w.logger.WithError(err).Info("Fail to keep editors warm")
+	}
+}
+
+// removeExcessApps deletes the editors the pool has over its size, e.g.
+// once it's shrunk to fit the pool budget.
+func (w *Worker) removeExcessApps(ctx context.Context) error {
+	currentVersion, _, err := w.provider.Pool(ctx)
+	if err != nil {
+		return err
+	}
+
+	n := len(currentVersion) - w.poolSize()
+	if n <= 0 {
+		return nil
+	}
+	if n > w.cfg.BatchSize {
+		n = w.cfg.BatchSize
+	}
+
+	w.logger.WithField("num", n).Info("Removing excess apps from pool")
+	for _, ed := range currentVersion[0:n] {
+		if err := w.provider.Delete(ctx, ed.Name); err != nil {
+			w.logger.WithError(err).WithField("app", ed.Name).Info("Fail to delete app")
+		}
+	}
+
+	return nil
+}
+
 func (w *Worker) removeOutdatedApps(ctx context.Context) error {
-	_, otherVersion, err := editor.AllIdledApps(ctx, w.heroku)
+	currentVersion, otherVersion, err := w.provider.Pool(ctx)
+	if err != nil {
+		return err
+	}
+	if len(otherVersion) == 0 {
+		return nil
+	}
+
+	passed, err := w.canaryPassed(ctx, currentVersion, time.Now())
 	if err != nil {
 		return err
 	}
+	if !passed {
+		w.logger.WithField("num", len(otherVersion)).Info("Keeping outdated apps until the canary of the new version passes")
+		return nil
+	}
 
 	i := len(otherVersion)
 	n := w.cfg.BatchSize
@@ -86,41 +765,53 @@ func (w *Worker) removeOutdatedApps(ctx context.Context) error {
 	}
 
 	w.logger.WithField("num", n).Info("Removing outdated apps from pool")
-	for _, app := range otherVersion[0:n] {
-		editor.DeleteApp(w.heroku, &app, w.logger)
+	for _, ed := range otherVersion[0:n] {
+		if err := w.provider.Delete(ctx, ed.Name); err != nil {
+			w.logger.WithError(err).WithField("app", ed.Name).Info("Fail to delete app")
+		}
 	}
 
 	return nil
 }
 
+// poolDeficit returns how many editors the pool is short of.
+func (w *Worker) poolDeficit(ctx context.Context) (int, error) {
+	// failing deploys aren't retried until they're looked into
+	stop, err := w.poolQuarantined(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if stop {
+		w.logger.WithField("template", w.poolTemplate()).Warn("Pool deploys are quarantined, purge them with cf-admin quarantine purge to deploy again")
+		return 0, nil
+	}
+
+	currentVersion, _, err := w.provider.Pool(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.poolSize() - len(currentVersion), nil
+}
+
 func (w *Worker) addAppsToPool(ctx context.Context) error {
-	currentVersion, _, err := editor.AllIdledApps(ctx, w.heroku)
+	i, err := w.poolDeficit(ctx)
 	if err != nil {
 		return err
 	}
 
-	i := w.cfg.PoolSize - len(currentVersion)
 	n := w.cfg.BatchSize
 	if n > i {
 		n = i
 	}
+	n = w.capDeploys(ctx, n)
 	w.logger.WithField("num", n).Info("Adding apps to pool")
 
-	ctx, cancel := context.WithCancel(ctx)
-	var g run.Group
-	for j := 0; j < n; j++ {
-		g.Add(func() error {
-			d := editor.NewDeployer(w.cfg.HerokuAPIKey, w.cfg.TemplateDir)
-			_, err := d.DeployEditorAndScaleDown(ctx)
-			return err
-		}, func(err error) {
-			cancel()
-		})
-	}
-
-	if err := g.Run(); err != nil {
-		return err
+	deploys := make([]*Worker, n)
+	for j := range deploys {
+		deploys[j] = w
 	}
+	w.deployAll(ctx, deploys)
 
 	return nil
 }